@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+
+	"github.com/biancarosa/netkit/internal/proxy"
+)
+
+// runBench measures the latency and allocations the proxy adds per request
+func runBench() error {
+	if len(os.Args) < 2 || os.Args[1] != "self" {
+		return fmt.Errorf("usage: netkit bench self [--requests 2000] [--scenarios proxy,tls] [--format json]")
+	}
+	flags := flag.NewFlagSet("bench self", flag.ExitOnError)
+	requests := flags.Int("requests", 2000, "Measured requests per feature set")
+	warmup := flags.Int("warmup", 200, "Unmeasured requests sent first for each feature set")
+	bodySize := flags.Int("body-size", 1024, "Bytes in each upstream response body")
+	scenarios := flags.String("scenarios", strings.Join(proxy.BenchScenarios, ","), "Comma-separated feature sets to measure: direct, proxy, capture-off, rules, tls; the direct baseline always runs")
+	format := flags.String("format", "text", "Output format: text or json")
+	if err := flags.Parse(os.Args[2:]); err != nil {
+		return err
+	}
+
+	if *requests <= 0 {
+		return fmt.Errorf("invalid --requests: must be above 0")
+	}
+	if *warmup < 0 || *bodySize < 0 {
+		return fmt.Errorf("invalid --warmup or --body-size: must not be negative")
+	}
+	if *format != "text" && *format != "json" {
+		return fmt.Errorf("invalid --format: expected text or json")
+	}
+	options := proxy.BenchOptions{Requests: *requests, Warmup: *warmup, BodySize: *bodySize}
+	for _, scenario := range strings.Split(*scenarios, ",") {
+		if scenario = strings.TrimSpace(scenario); scenario == "" {
+			continue
+		}
+		if err := proxy.ValidateBenchScenario(scenario); err != nil {
+			return fmt.Errorf("invalid --scenarios: %v", err)
+		}
+		options.Scenarios = append(options.Scenarios, scenario)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	report, err := proxy.RunSelfBench(ctx, options)
+	if err != nil {
+		return fmt.Errorf("benchmark failed: %v", err)
+	}
+
+	if *format == "json" {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(report)
+	}
+	fmt.Printf("%d requests per feature set, %d-byte responses, %s %s/%s, %d CPUs\n\n",
+		report.Requests, report.BodySize, report.GoVersion, report.OS, report.Arch, report.CPUs)
+	writer := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(writer, "SCENARIO\tP50\tP99\tADDED P50\tADDED MEAN\tALLOCS/REQ\tADDED ALLOCS\tADDED BYTES\tFEATURES")
+	for _, result := range report.Results {
+		fmt.Fprintf(writer, "%s\t%dµs\t%dµs\t%+dµs\t%+dµs\t%.0f\t%+.0f\t%+.0f\t%s\n", result.Scenario, result.P50Us, result.P99Us,
+			result.AddedP50Us, result.AddedMeanUs, result.Allocs, result.AddedAllocs, result.AddedBytes, result.Description)
+	}
+	return writer.Flush()
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/biancarosa/netkit/internal/proxy"
+)
+
+// runCodegen prints code that reproduces a recorded request
+func runCodegen() error {
+	flags := flag.NewFlagSet("codegen", flag.ExitOnError)
+	lang := flags.String("lang", proxy.CodegenGo, "Language: go (net/http), python (requests), or js (fetch)")
+	from := flags.String("from", "", "Read the record from exported records (NDJSON, a GET /requests response, a capture export, a JSON array of records, or a mitmproxy flow file; - for stdin) instead of a running proxy")
+	adminURL := flags.String("admin-url", "http://localhost:8081", "Admin URL of the running proxy")
+	token := flags.String("token", os.Getenv("NETKIT_ADMIN_TOKEN"), "Admin API token (default: $NETKIT_ADMIN_TOKEN)")
+
+	// Accept the record ID between flags as well as after them
+	var positional []string
+	for args := os.Args[1:]; ; args = flags.Args()[1:] {
+		if err := flags.Parse(args); err != nil {
+			return err
+		}
+		if flags.NArg() == 0 {
+			break
+		}
+		positional = append(positional, flags.Arg(0))
+	}
+	if len(positional) != 1 {
+		return fmt.Errorf("usage: netkit codegen <record-id> [--lang go|python|js] [--from history.ndjson]")
+	}
+	id := positional[0]
+	if err := proxy.ValidateCodegenLanguage(*lang); err != nil {
+		return fmt.Errorf("invalid --lang: %v", err)
+	}
+
+	var records []proxy.RequestRecord
+	if *from != "" {
+		input := os.Stdin
+		if *from != "-" {
+			file, err := os.Open(*from)
+			if err != nil {
+				return err
+			}
+			defer func() {
+				if closeErr := file.Close(); closeErr != nil {
+					slog.Warn("Error closing file", "file", *from, "error", closeErr)
+				}
+			}()
+			input = file
+		}
+		var err error
+		if records, err = proxy.ReadRecords(input); err != nil {
+			return fmt.Errorf("failed to read %s: %v", *from, err)
+		}
+	} else {
+		client := &http.Client{Timeout: 10 * time.Second}
+		endpoint := strings.TrimSuffix(*adminURL, "/") + "/requests?id=" + url.QueryEscape(id)
+		var history struct {
+			Records []proxy.RequestRecord `json:"records"`
+		}
+		if err := filtersRequest(client, *token, http.MethodGet, endpoint, nil, &history); err != nil {
+			return err
+		}
+		records = history.Records
+	}
+
+	for _, record := range records {
+		if record.ID != id {
+			continue
+		}
+		if record.RequestBodyTruncated {
+			fmt.Fprintf(os.Stderr, "Warning: only the first %d of %d request body bytes were captured\n", len(record.RequestBody), record.RequestSize)
+		}
+		code, err := proxy.GenerateCode(record, *lang)
+		if err != nil {
+			return err
+		}
+		fmt.Print(code)
+		return nil
+	}
+	return fmt.Errorf("record %s not found", id)
+}
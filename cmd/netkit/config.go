@@ -0,0 +1,137 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"github.com/biancarosa/netkit/internal/proxy"
+	"gopkg.in/yaml.v3"
+)
+
+// applyConfigFile sets flags from a YAML or TOML file whose keys are flag
+// names (e.g. admin-port, or admin_port). Flags given on the command line
+// keep their values. Lists set repeatable flags once per item and are joined
+// with commas for the rest. Files older than configSchemaVersion are migrated
+// as they load.
+func applyConfigFile(flags *flag.FlagSet, path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	settings := map[string]interface{}{}
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &settings)
+	case ".toml":
+		err = toml.Unmarshal(data, &settings)
+	default:
+		return fmt.Errorf("unsupported config format %q (expected .yaml, .yml, or .toml)", ext)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to parse %s: %v", path, err)
+	}
+
+	// Older files keep loading; netkit config migrate rewrites them
+	version, err := configFileVersion(settings["version"])
+	if err != nil {
+		return fmt.Errorf("invalid %s: %v", path, err)
+	}
+	delete(settings, "version")
+	settings = migrateSettings(settings, version)
+
+	explicit := map[string]bool{}
+	flags.Visit(func(f *flag.Flag) { explicit[f.Name] = true })
+
+	// Apply settings in a stable order so errors are reproducible
+	keys := make([]string, 0, len(settings))
+	for key := range settings {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		name := strings.ReplaceAll(key, "_", "-")
+		f := flags.Lookup(name)
+		if f == nil || name == "config" {
+			return fmt.Errorf("unknown setting %q in %s", key, path)
+		}
+		if explicit[name] {
+			continue
+		}
+		values, err := configValues(settings[key])
+		if err != nil {
+			return fmt.Errorf("invalid %s in %s: %v", key, path, err)
+		}
+		if _, repeatable := f.Value.(*stringSliceFlag); !repeatable {
+			values = []string{strings.Join(values, ",")}
+		}
+		for _, value := range values {
+			if err := flags.Set(name, value); err != nil {
+				return fmt.Errorf("invalid %s in %s: %v", key, path, err)
+			}
+		}
+	}
+	return nil
+}
+
+// applyRuleBundles adds the settings of the rule bundles installed in dir to
+// their flags. Bundles only hold repeatable flags, so their rules add to the
+// ones from the command line and config file rather than replacing them.
+func applyRuleBundles(flags *flag.FlagSet, dir string) error {
+	bundles, err := proxy.LoadRuleBundles(dir)
+	if err != nil {
+		return err
+	}
+	for _, bundle := range bundles {
+		names := make([]string, 0, len(bundle.Settings))
+		for name := range bundle.Settings {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		for _, name := range names {
+			f := flags.Lookup(name)
+			if f == nil {
+				return fmt.Errorf("unknown setting %q in rule bundle %s", name, bundle.Name)
+			}
+			if _, repeatable := f.Value.(*stringSliceFlag); !repeatable {
+				return fmt.Errorf("setting %q in rule bundle %s is not a repeatable flag", name, bundle.Name)
+			}
+			for _, value := range bundle.Settings[name] {
+				if err := flags.Set(name, value); err != nil {
+					return fmt.Errorf("invalid %s in rule bundle %s: %v", name, bundle.Name, err)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// configValues converts a setting to flag values: one for a scalar, one per
+// item for a list
+func configValues(setting interface{}) ([]string, error) {
+	switch value := setting.(type) {
+	case []interface{}:
+		values := make([]string, 0, len(value))
+		for _, item := range value {
+			itemValues, err := configValues(item)
+			if err != nil || len(itemValues) != 1 {
+				return nil, fmt.Errorf("lists may only hold single values")
+			}
+			values = append(values, itemValues[0])
+		}
+		return values, nil
+	case map[string]interface{}:
+		return nil, fmt.Errorf("expected a value or a list, not a table")
+	case nil:
+		return []string{""}, nil
+	default:
+		return []string{fmt.Sprint(value)}, nil
+	}
+}
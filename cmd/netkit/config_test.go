@@ -0,0 +1,188 @@
+//go:build unit
+
+package main
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// configFlags returns a flag set with the kinds of flags config files set,
+// and its repeatable flag
+func configFlags() (*flag.FlagSet, *stringSliceFlag) {
+	flags := flag.NewFlagSet("test", flag.ContinueOnError)
+	flags.Int("admin-port", 8081, "")
+	flags.String("log-level", "info", "")
+	flags.String("hosts", "", "")
+	flags.Bool("dashboard", false, "")
+	flags.String("config", "", "")
+	var rules stringSliceFlag
+	flags.Var(&rules, "rule", "")
+	return flags, &rules
+}
+
+func TestApplyConfigFile(t *testing.T) {
+	tests := []struct {
+		name      string
+		file      string
+		content   string
+		args      []string
+		want      map[string]string // Flag values after the file is applied
+		wantRules []string
+		wantErr   string
+	}{
+		{
+			name:    "yaml settings",
+			file:    "netkit.yaml",
+			content: "version: 2\nadmin-port: 9000\nlog-level: debug\ndashboard: true\n",
+			want:    map[string]string{"admin-port": "9000", "log-level": "debug", "dashboard": "true"},
+		},
+		{
+			name:    "toml settings",
+			file:    "netkit.toml",
+			content: "version = 2\nadmin-port = 9000\nlog-level = \"debug\"\n",
+			want:    map[string]string{"admin-port": "9000", "log-level": "debug"},
+		},
+		{
+			name:    "underscores spell flag names",
+			file:    "netkit.yml",
+			content: "version: 2\nadmin_port: 9000\nlog_level: warn\n",
+			want:    map[string]string{"admin-port": "9000", "log-level": "warn"},
+		},
+		{
+			name:    "files without a version are migrated",
+			file:    "netkit.yaml",
+			content: "admin_port: 9000\n",
+			want:    map[string]string{"admin-port": "9000"},
+		},
+		{
+			name:    "command-line flags override the file",
+			file:    "netkit.yaml",
+			content: "admin-port: 9000\nlog-level: debug\n",
+			args:    []string{"--admin-port=7000"},
+			want:    map[string]string{"admin-port": "7000", "log-level": "debug"},
+		},
+		{
+			name:      "lists set repeatable flags once per item",
+			file:      "netkit.yaml",
+			content:   "rule:\n  - a\n  - b\n",
+			wantRules: []string{"a", "b"},
+		},
+		{
+			name:      "repeatable flags on the command line replace the file's list",
+			file:      "netkit.toml",
+			content:   "rule = [\"a\", \"b\"]\n",
+			args:      []string{"--rule", "c"},
+			wantRules: []string{"c"},
+		},
+		{
+			name:    "lists are joined with commas for other flags",
+			file:    "netkit.yaml",
+			content: "hosts: [api.example.com, cdn.example.com]\n",
+			want:    map[string]string{"hosts": "api.example.com,cdn.example.com"},
+		},
+		{
+			name:    "empty values",
+			file:    "netkit.yaml",
+			content: "log-level:\n",
+			want:    map[string]string{"log-level": ""},
+		},
+		{
+			name:    "unknown settings",
+			file:    "netkit.yaml",
+			content: "version: 2\nadmin-port: 9000\nbogus_setting: 1\n",
+			wantErr: `unknown setting "bogus_setting"`,
+		},
+		{
+			name:    "config cannot be set from a file",
+			file:    "netkit.yaml",
+			content: "config: other.yaml\n",
+			wantErr: `unknown setting "config"`,
+		},
+		{
+			name:    "tables",
+			file:    "netkit.toml",
+			content: "[hosts]\napi = \"api.example.com\"\n",
+			wantErr: "expected a value or a list, not a table",
+		},
+		{
+			name:    "nested lists",
+			file:    "netkit.yaml",
+			content: "rule: [[a, b]]\n",
+			wantErr: "lists may only hold single values",
+		},
+		{
+			name:    "invalid values",
+			file:    "netkit.yaml",
+			content: "admin-port: many\n",
+			wantErr: "invalid admin-port",
+		},
+		{
+			name:    "newer versions",
+			file:    "netkit.yaml",
+			content: "version: 99\n",
+			wantErr: "newer than this netkit supports",
+		},
+		{
+			name:    "unsupported formats",
+			file:    "netkit.json",
+			content: "{}",
+			wantErr: `unsupported config format ".json"`,
+		},
+		{
+			name:    "invalid files",
+			file:    "netkit.yaml",
+			content: "admin-port: [\n",
+			wantErr: "failed to parse",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), tt.file)
+			require.NoError(t, os.WriteFile(path, []byte(tt.content), 0o600))
+			flags, rules := configFlags()
+			require.NoError(t, flags.Parse(tt.args))
+
+			err := applyConfigFile(flags, path)
+			if tt.wantErr != "" {
+				assert.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			for name, want := range tt.want {
+				assert.Equal(t, want, flags.Lookup(name).Value.String(), name)
+			}
+			assert.Equal(t, tt.wantRules, []string(*rules))
+		})
+	}
+}
+
+func TestApplyConfigFileMissing(t *testing.T) {
+	flags, _ := configFlags()
+	err := applyConfigFile(flags, filepath.Join(t.TempDir(), "missing.yaml"))
+	assert.ErrorIs(t, err, os.ErrNotExist)
+}
+
+func TestConfigValues(t *testing.T) {
+	tests := []struct {
+		setting interface{}
+		want    []string
+	}{
+		{"debug", []string{"debug"}},
+		{8081, []string{"8081"}},
+		{true, []string{"true"}},
+		{nil, []string{""}},
+		{[]interface{}{"a", 2}, []string{"a", "2"}},
+		{[]interface{}{}, []string{}},
+	}
+	for _, tt := range tests {
+		values, err := configValues(tt.setting)
+		require.NoError(t, err)
+		assert.Equal(t, tt.want, values, "%v", tt.setting)
+	}
+}
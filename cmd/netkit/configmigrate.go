@@ -0,0 +1,276 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// configSchemaVersion is the version of the config file schema this build
+// writes. Files without a version key are version 1.
+const configSchemaVersion = 2
+
+// configMigration upgrades a config file from the previous schema version
+type configMigration struct {
+	version     int                     // Schema version the migration upgrades to
+	description string                  // Shown by netkit config migrate
+	rename      func(key string) string // New name of a setting, or "" to drop it
+}
+
+// configMigrations are applied in order to files older than their version
+var configMigrations = []configMigration{
+	{
+		version:     2,
+		description: "add the version key and spell settings as their flag names (admin_port becomes admin-port)",
+		rename:      func(key string) string { return strings.ReplaceAll(key, "_", "-") },
+	},
+}
+
+// configFileVersion returns the schema version a file declares, 1 without
+// a version key
+func configFileVersion(setting interface{}) (int, error) {
+	if setting == nil {
+		return 1, nil
+	}
+	version, err := strconv.Atoi(fmt.Sprint(setting))
+	if err != nil || version < 1 {
+		return 0, fmt.Errorf("invalid version %v: expected a positive number", setting)
+	}
+	if version > configSchemaVersion {
+		return 0, fmt.Errorf("version %d is newer than this netkit supports (%d); upgrade netkit", version, configSchemaVersion)
+	}
+	return version, nil
+}
+
+// migrateSettings upgrades parsed settings from version to the current
+// schema, so older files keep loading
+func migrateSettings(settings map[string]interface{}, version int) map[string]interface{} {
+	for _, migration := range configMigrations {
+		if migration.version <= version {
+			continue
+		}
+		migrated := make(map[string]interface{}, len(settings))
+		for key, value := range settings {
+			if name := migration.rename(key); name != "" {
+				migrated[name] = value
+			}
+		}
+		settings = migrated
+	}
+	return settings
+}
+
+// runConfig manages netkit config files
+func runConfig() error {
+	if len(os.Args) < 2 || os.Args[1] != "migrate" {
+		return fmt.Errorf("usage: netkit config migrate [--write] [--check] <file>")
+	}
+	flags := flag.NewFlagSet("config migrate", flag.ExitOnError)
+	write := flags.Bool("write", false, "Write the migrated config back to the file instead of only showing the changes")
+	check := flags.Bool("check", false, "Exit with an error when the file needs migrating, e.g. in CI")
+	if err := flags.Parse(os.Args[2:]); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		return fmt.Errorf("usage: netkit config migrate [--write] [--check] <file>")
+	}
+	path := flags.Arg(0)
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	migrated, applied, err := migrateConfigFile(path, data)
+	if err != nil {
+		return fmt.Errorf("failed to migrate %s: %v", path, err)
+	}
+	if len(applied) == 0 {
+		fmt.Printf("%s is up to date (version %d)\n", path, configSchemaVersion)
+		return nil
+	}
+
+	for _, migration := range applied {
+		fmt.Printf("Version %d: %s\n", migration.version, migration.description)
+	}
+	fmt.Println()
+	fmt.Print(lineDiff(path, string(data), string(migrated)))
+	switch {
+	case *write:
+		info, err := os.Stat(path)
+		if err != nil {
+			return err
+		}
+		if err := os.WriteFile(path, migrated, info.Mode().Perm()); err != nil {
+			return err
+		}
+		fmt.Printf("\nMigrated %s to version %d\n", path, configSchemaVersion)
+	case *check:
+		return fmt.Errorf("%s needs migrating to version %d; run netkit config migrate --write %s", path, configSchemaVersion, path)
+	default:
+		fmt.Printf("\nRun with --write to migrate %s to version %d\n", path, configSchemaVersion)
+	}
+	return nil
+}
+
+// Top-level settings of each config format; values continued on indented
+// lines (YAML lists, TOML arrays) never match, so they are kept as written
+var (
+	yamlSettingPattern = regexp.MustCompile(`^()([A-Za-z0-9_-]+)(\s*:.*)$`)
+	tomlSettingPattern = regexp.MustCompile(`^(\s*)([A-Za-z0-9_-]+)(\s*=.*)$`)
+)
+
+// pendingMigrations returns the migrations a file of version still needs
+func pendingMigrations(version int) []configMigration {
+	var pending []configMigration
+	for _, migration := range configMigrations {
+		if migration.version > version {
+			pending = append(pending, migration)
+		}
+	}
+	return pending
+}
+
+// migrateConfigFile rewrites a config file at the current schema version and
+// returns the migrations it applied. Config files are flat, so settings are
+// renamed line by line, which keeps comments and blank lines as written
+func migrateConfigFile(path string, data []byte) ([]byte, []configMigration, error) {
+	settings := map[string]interface{}{}
+	var pattern *regexp.Regexp
+	var versionSetting string
+	var err error
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &settings)
+		pattern, versionSetting = yamlSettingPattern, "version: %d"
+	case ".toml":
+		err = toml.Unmarshal(data, &settings)
+		pattern, versionSetting = tomlSettingPattern, "version = %d"
+	default:
+		return nil, nil, fmt.Errorf("unsupported config format %q (expected .yaml, .yml, or .toml)", ext)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	version, err := configFileVersion(settings["version"])
+	if err != nil {
+		return nil, nil, err
+	}
+	pending := pendingMigrations(version)
+	if len(pending) == 0 {
+		return data, nil, nil
+	}
+
+	lines := strings.Split(string(data), "\n")
+	migrated := make([]string, 0, len(lines)+1)
+	// Keep comments heading the file above the version key
+	start := 0
+	for start < len(lines) && strings.HasPrefix(strings.TrimSpace(lines[start]), "#") {
+		migrated = append(migrated, lines[start])
+		start++
+	}
+	migrated = append(migrated, fmt.Sprintf(versionSetting, configSchemaVersion))
+	for _, line := range lines[start:] {
+		match := pattern.FindStringSubmatch(line)
+		if match == nil {
+			migrated = append(migrated, line)
+			continue
+		}
+		key := match[2]
+		if key == "version" {
+			continue
+		}
+		for _, migration := range pending {
+			if key = migration.rename(key); key == "" {
+				break
+			}
+		}
+		if key != "" {
+			migrated = append(migrated, match[1]+key+match[3])
+		}
+	}
+	return []byte(strings.Join(migrated, "\n")), pending, nil
+}
+
+// lineDiff renders the changes from before to after as a unified diff with
+// three lines of context
+func lineDiff(name, before, after string) string {
+	a, b := strings.Split(before, "\n"), strings.Split(after, "\n")
+
+	// Longest common subsequence lengths of every pair of suffixes
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	type edit struct {
+		op   byte // ' ', '-', or '+'
+		line string
+		a, b int // Line numbers before and after, from 1
+	}
+	var edits []edit
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			edits = append(edits, edit{' ', a[i], i + 1, j + 1})
+			i, j = i+1, j+1
+		case j < len(b) && (i == len(a) || lcs[i][j+1] > lcs[i+1][j]):
+			edits = append(edits, edit{'+', b[j], i + 1, j + 1})
+			j++
+		default:
+			edits = append(edits, edit{'-', a[i], i + 1, j + 1})
+			i++
+		}
+	}
+
+	var out strings.Builder
+	fmt.Fprintf(&out, "--- %s\n+++ %s (version %d)\n", name, name, configSchemaVersion)
+	const context = 3
+	for start := 0; start < len(edits); {
+		if edits[start].op == ' ' {
+			start++
+			continue
+		}
+		// Grow the hunk while changes are within twice the context of each other
+		first := max(start-context, 0)
+		end := start
+		for k := start; k < len(edits) && k-end <= 2*context; k++ {
+			if edits[k].op != ' ' {
+				end = k
+			}
+		}
+		last := min(end+context, len(edits)-1)
+
+		var countA, countB int
+		for _, e := range edits[first : last+1] {
+			if e.op != '+' {
+				countA++
+			}
+			if e.op != '-' {
+				countB++
+			}
+		}
+		fmt.Fprintf(&out, "@@ -%d,%d +%d,%d @@\n", edits[first].a, countA, edits[first].b, countB)
+		for _, e := range edits[first : last+1] {
+			fmt.Fprintf(&out, "%c%s\n", e.op, e.line)
+		}
+		start = last + 1
+	}
+	return out.String()
+}
@@ -0,0 +1,163 @@
+//go:build unit
+
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConfigFileVersion(t *testing.T) {
+	tests := []struct {
+		setting interface{}
+		want    int
+		wantErr string
+	}{
+		{nil, 1, ""},
+		{1, 1, ""},
+		{"2", 2, ""},
+		{0, 0, "expected a positive number"},
+		{"two", 0, "expected a positive number"},
+		{configSchemaVersion + 1, 0, "newer than this netkit supports"},
+	}
+	for _, tt := range tests {
+		version, err := configFileVersion(tt.setting)
+		if tt.wantErr != "" {
+			assert.ErrorContains(t, err, tt.wantErr, "%v", tt.setting)
+			continue
+		}
+		require.NoError(t, err, "%v", tt.setting)
+		assert.Equal(t, tt.want, version, "%v", tt.setting)
+	}
+}
+
+func TestMigrateSettings(t *testing.T) {
+	tests := []struct {
+		name     string
+		settings map[string]interface{}
+		version  int
+		want     map[string]interface{}
+	}{
+		{
+			name:     "version 1 settings are spelled as flag names",
+			settings: map[string]interface{}{"admin_port": 9000, "log-level": "debug", "history_key": "env:KEY"},
+			version:  1,
+			want:     map[string]interface{}{"admin-port": 9000, "log-level": "debug", "history-key": "env:KEY"},
+		},
+		{
+			name:     "current settings are kept",
+			settings: map[string]interface{}{"admin_port": 9000},
+			version:  configSchemaVersion,
+			want:     map[string]interface{}{"admin_port": 9000},
+		},
+		{
+			name:     "empty files",
+			settings: map[string]interface{}{},
+			version:  1,
+			want:     map[string]interface{}{},
+		},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, migrateSettings(tt.settings, tt.version), tt.name)
+	}
+}
+
+func TestMigrateConfigFile(t *testing.T) {
+	tests := []struct {
+		name        string
+		path        string
+		data        string
+		want        string
+		wantApplied []int
+		wantErr     string
+	}{
+		{
+			name:        "yaml",
+			path:        "netkit.yaml",
+			data:        "# Shared settings\n\nadmin_port: 9000 # admin\nallowed_hosts:\n  - api_v1.example.com\nlog-level: debug\n",
+			want:        "# Shared settings\nversion: 2\n\nadmin-port: 9000 # admin\nallowed-hosts:\n  - api_v1.example.com\nlog-level: debug\n",
+			wantApplied: []int{2},
+		},
+		{
+			name:        "toml",
+			path:        "netkit.toml",
+			data:        "version = 1\nadmin_port = 9000\nallowed_hosts = [\n  \"a_b.example.com\",\n]\n",
+			want:        "version = 2\nadmin-port = 9000\nallowed-hosts = [\n  \"a_b.example.com\",\n]\n",
+			wantApplied: []int{2},
+		},
+		{
+			name: "current files are unchanged",
+			path: "netkit.yml",
+			data: "version: 2\nadmin_port: 9000\n",
+			want: "version: 2\nadmin_port: 9000\n",
+		},
+		{
+			name:    "newer versions",
+			path:    "netkit.yaml",
+			data:    "version: 99\n",
+			wantErr: "newer than this netkit supports",
+		},
+		{
+			name:    "unsupported formats",
+			path:    "netkit.ini",
+			data:    "admin_port=9000\n",
+			wantErr: "unsupported config format",
+		},
+		{
+			name:    "invalid files",
+			path:    "netkit.toml",
+			data:    "admin_port = \n",
+			wantErr: "expected value",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			migrated, applied, err := migrateConfigFile(tt.path, []byte(tt.data))
+			if tt.wantErr != "" {
+				assert.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, string(migrated))
+			var versions []int
+			for _, migration := range applied {
+				versions = append(versions, migration.version)
+			}
+			assert.Equal(t, tt.wantApplied, versions)
+		})
+	}
+}
+
+func TestLineDiff(t *testing.T) {
+	tests := []struct {
+		name          string
+		before, after string
+		want          string
+	}{
+		{
+			name:   "one hunk",
+			before: "a_b: 1\nc: 2\n",
+			after:  "version: 2\na-b: 1\nc: 2\n",
+			want:   "--- f\n+++ f (version 2)\n@@ -1,3 +1,4 @@\n-a_b: 1\n+version: 2\n+a-b: 1\n c: 2\n \n",
+		},
+		{
+			name:   "changes far apart get a hunk each",
+			before: "a_a\n1\n2\n3\n4\n5\n6\n7\n8\nb_b",
+			after:  "a-a\n1\n2\n3\n4\n5\n6\n7\n8\nb-b",
+			want: "--- f\n+++ f (version 2)\n" +
+				"@@ -1,4 +1,4 @@\n-a_a\n+a-a\n 1\n 2\n 3\n" +
+				"@@ -7,4 +7,4 @@\n 6\n 7\n 8\n-b_b\n+b-b\n",
+		},
+		{
+			name:   "no changes",
+			before: "a: 1",
+			after:  "a: 1",
+			want:   "--- f\n+++ f (version 2)\n",
+		},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, lineDiff("f", tt.before, tt.after), tt.name)
+	}
+}
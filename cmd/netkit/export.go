@@ -0,0 +1,141 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/biancarosa/netkit/internal/proxy"
+)
+
+// Formats of netkit export
+const (
+	exportFixtures = "fixtures"
+	exportMitm     = "mitm"
+	exportHAR      = "har"
+)
+
+// runExport writes recorded requests as deterministic fixture files that can
+// be committed to a repository, or as a mitmproxy flow file or HAR log
+func runExport() error {
+	flags := flag.NewFlagSet("export", flag.ExitOnError)
+	out := flags.String("out", "", "Directory to write fixtures to, or with --format mitm or har the file to write (- for stdout) (required)")
+	format := flags.String("format", exportFixtures, "Export format: fixtures, mitm (a mitmproxy flow file), or har (a HAR 1.2 log for browser devtools)")
+	from := flags.String("from", "", "Export records from a file (NDJSON, a GET /requests response, a capture export, a JSON array of records, or a mitmproxy flow file; - for stdin) instead of a running proxy")
+	capture := flags.String("capture", "", "Export a named capture of the running proxy instead of its history")
+	query := flags.String("query", "", "GET /requests filter parameters selecting the records to export, e.g. host=api.example.com&method=POST")
+	adminURL := flags.String("admin-url", "http://localhost:8081", "Admin URL of the running proxy")
+	token := flags.String("token", os.Getenv("NETKIT_ADMIN_TOKEN"), "Admin API token (default: $NETKIT_ADMIN_TOKEN)")
+	if err := flags.Parse(os.Args[1:]); err != nil {
+		return err
+	}
+	if *out == "" || flags.NArg() > 0 {
+		return fmt.Errorf("usage: netkit export --out DIR|FILE [--format fixtures|mitm|har] [--from FILE | --capture NAME | --query FILTERS]")
+	}
+	if *format != exportFixtures && *format != exportMitm && *format != exportHAR {
+		return fmt.Errorf("invalid --format %q (expected fixtures, mitm, or har)", *format)
+	}
+	if *from != "" && *capture != "" {
+		return fmt.Errorf("--from and --capture cannot be used together")
+	}
+	if *query != "" && (*from != "" || *capture != "") {
+		return fmt.Errorf("--query only applies to the history of a running proxy")
+	}
+
+	var input io.Reader
+	if *from != "" {
+		input = os.Stdin
+		if *from != "-" {
+			file, err := os.Open(*from)
+			if err != nil {
+				return err
+			}
+			defer func() {
+				if closeErr := file.Close(); closeErr != nil {
+					slog.Warn("Error closing file", "file", *from, "error", closeErr)
+				}
+			}()
+			input = file
+		}
+	} else {
+		client := &http.Client{Timeout: 30 * time.Second}
+		endpoint := strings.TrimSuffix(*adminURL, "/") + "/requests"
+		if *capture != "" {
+			endpoint = strings.TrimSuffix(*adminURL, "/") + "/captures?name=" + url.QueryEscape(*capture)
+		} else if *query != "" {
+			endpoint += "?" + strings.TrimPrefix(*query, "?")
+		}
+		var data json.RawMessage
+		if err := filtersRequest(client, *token, http.MethodGet, endpoint, nil, &data); err != nil {
+			return err
+		}
+		input = bytes.NewReader(data)
+	}
+
+	records, err := proxy.ReadRecords(input)
+	if err != nil {
+		return fmt.Errorf("failed to read records: %v", err)
+	}
+	switch *format {
+	case exportMitm:
+		return exportMitmFlows(*out, records)
+	case exportHAR:
+		return exportHARLog(*out, records)
+	}
+	written, err := proxy.WriteFixtures(*out, records)
+	if err != nil {
+		return fmt.Errorf("failed to write fixtures: %v", err)
+	}
+	fmt.Printf("Exported %d fixtures to %s\n", written, *out)
+	return nil
+}
+
+// exportMitmFlows writes records as a mitmproxy flow file at path, or to
+// stdout for -
+func exportMitmFlows(path string, records []proxy.RequestRecord) error {
+	if path == "-" {
+		if _, err := proxy.WriteMitmFlows(os.Stdout, records); err != nil {
+			return fmt.Errorf("failed to write flows: %v", err)
+		}
+		return nil
+	}
+
+	var buf bytes.Buffer
+	written, err := proxy.WriteMitmFlows(&buf, records)
+	if err != nil {
+		return fmt.Errorf("failed to write flows: %v", err)
+	}
+	if err := os.WriteFile(path, buf.Bytes(), 0600); err != nil {
+		return fmt.Errorf("failed to write flows: %v", err)
+	}
+	fmt.Printf("Exported %d flows to %s\n", written, path)
+	return nil
+}
+
+// exportHARLog writes records as a HAR log at path, or to stdout for -
+func exportHARLog(path string, records []proxy.RequestRecord) error {
+	if path == "-" {
+		if err := proxy.WriteHAR(os.Stdout, records); err != nil {
+			return fmt.Errorf("failed to write HAR: %v", err)
+		}
+		return nil
+	}
+
+	var buf bytes.Buffer
+	if err := proxy.WriteHAR(&buf, records); err != nil {
+		return fmt.Errorf("failed to write HAR: %v", err)
+	}
+	if err := os.WriteFile(path, buf.Bytes(), 0600); err != nil {
+		return fmt.Errorf("failed to write HAR: %v", err)
+	}
+	fmt.Printf("Exported %d entries to %s\n", len(records), path)
+	return nil
+}
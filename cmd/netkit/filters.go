@@ -0,0 +1,114 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/biancarosa/netkit/internal/proxy"
+)
+
+// runFilters manages the saved history filters of a running `netkit serve`
+func runFilters() error {
+	adminURL := flag.String("admin-url", "http://localhost:8081", "Admin URL of the running proxy")
+	description := flag.String("description", "", "Description shown when listing filters (with save)")
+	token := flag.String("token", os.Getenv("NETKIT_ADMIN_TOKEN"), "Admin API token (default: $NETKIT_ADMIN_TOKEN)")
+	flag.Parse()
+
+	args := flag.Args()
+	if len(args) == 0 {
+		return fmt.Errorf("usage: netkit filters list | save <name> <query> | delete <name>")
+	}
+
+	client := &http.Client{Timeout: 10 * time.Second}
+	endpoint := strings.TrimSuffix(*adminURL, "/") + "/requests/filters"
+
+	switch args[0] {
+	case "list":
+		if len(args) != 1 {
+			return fmt.Errorf("usage: netkit filters list")
+		}
+		var result struct {
+			Filters []proxy.SavedFilter `json:"filters"`
+		}
+		if err := filtersRequest(client, *token, http.MethodGet, endpoint, nil, &result); err != nil {
+			return err
+		}
+		for _, filter := range result.Filters {
+			fmt.Printf("%s\t%s\t%s\n", filter.Name, filter.Query, filter.Description)
+		}
+		return nil
+
+	case "save":
+		if len(args) != 3 {
+			return fmt.Errorf("usage: netkit filters save <name> <query>, e.g. save \"prod 5xx\" 'host=api.example.com&status=5xx'")
+		}
+		body, err := json.Marshal(proxy.SavedFilter{Name: args[1], Query: args[2], Description: *description})
+		if err != nil {
+			return err
+		}
+		var saved proxy.SavedFilter
+		if err := filtersRequest(client, *token, http.MethodPost, endpoint, body, &saved); err != nil {
+			return err
+		}
+		fmt.Printf("Saved filter %q: %s/requests?filter=%s\n", saved.Name, strings.TrimSuffix(*adminURL, "/"), url.QueryEscape(saved.Name))
+		return nil
+
+	case "delete":
+		if len(args) != 2 {
+			return fmt.Errorf("usage: netkit filters delete <name>")
+		}
+		if err := filtersRequest(client, *token, http.MethodDelete, endpoint+"?name="+url.QueryEscape(args[1]), nil, nil); err != nil {
+			return err
+		}
+		fmt.Printf("Deleted filter %q\n", args[1])
+		return nil
+
+	default:
+		return fmt.Errorf("unknown filters command %q (expected list, save, or delete)", args[0])
+	}
+}
+
+// filtersRequest calls the saved filters admin endpoint and decodes the response into result
+func filtersRequest(client *http.Client, token, method, endpoint string, body []byte, result interface{}) error {
+	req, err := http.NewRequest(method, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to reach admin API: %v", err)
+	}
+	defer func() {
+		if closeErr := resp.Body.Close(); closeErr != nil {
+			slog.Warn("Error closing response body", "error", closeErr)
+		}
+	}()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("failed to read admin API response: %v", err)
+	}
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("admin API returned %s: %s", resp.Status, strings.TrimSpace(string(data)))
+	}
+	if result == nil {
+		return nil
+	}
+	return json.Unmarshal(data, result)
+}
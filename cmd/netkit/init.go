@@ -0,0 +1,135 @@
+package main
+
+import (
+	"embed"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// scaffold holds the files netkit init writes. The .gitignore is stored as
+// gitignore so it does not apply to this repository.
+//
+//go:embed scaffold
+var scaffold embed.FS
+
+// runInit scaffolds a project directory with shared netkit settings, so a
+// team can check its setup into version control
+func runInit() error {
+	return initProject(os.Args[1:])
+}
+
+// initProject scaffolds the directory named in args, given its flags
+func initProject(args []string) error {
+	flags := flag.NewFlagSet("init", flag.ContinueOnError)
+	force := flags.Bool("force", false, "Overwrite files that already exist")
+
+	// Accept the directory before flags as well as after them
+	var positional []string
+	for ; ; args = flags.Args()[1:] {
+		if err := flags.Parse(args); err != nil {
+			return err
+		}
+		if flags.NArg() == 0 {
+			break
+		}
+		positional = append(positional, flags.Arg(0))
+	}
+	if len(positional) > 1 {
+		return fmt.Errorf("usage: netkit init [directory] [--force]")
+	}
+	dir := "."
+	if len(positional) == 1 {
+		dir = positional[0]
+	}
+
+	root, err := fs.Sub(scaffold, "scaffold")
+	if err != nil {
+		return err
+	}
+	err = fs.WalkDir(root, ".", func(path string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() {
+			return err
+		}
+		data, err := fs.ReadFile(root, path)
+		if err != nil {
+			return err
+		}
+		if path == "gitignore" {
+			return addGitignoreEntries(filepath.Join(dir, ".gitignore"), string(data))
+		}
+
+		target := filepath.Join(dir, filepath.FromSlash(path))
+		if _, err := os.Stat(target); err == nil && !*force {
+			fmt.Printf("exists   %s\n", target)
+			return nil
+		}
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return err
+		}
+		if err := os.WriteFile(target, data, 0644); err != nil {
+			return err
+		}
+		fmt.Printf("created  %s\n", target)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	fmt.Println()
+	fmt.Println("Next steps:")
+	if dir != "." {
+		fmt.Printf("  cd %s\n", dir)
+	}
+	fmt.Println("  netkit serve --config netkit.yaml")
+	fmt.Println("  netkit mock --openapi mocks/openapi.yaml --behaviors mocks/behaviors.yaml")
+	fmt.Println("  netkit replay --from collections/example.ndjson")
+	return nil
+}
+
+// addGitignoreEntries appends the lines of entries that path does not have
+// yet, creating it if needed
+func addGitignoreEntries(path, entries string) error {
+	existing, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	present := make(map[string]bool)
+	for _, line := range strings.Split(string(existing), "\n") {
+		present[strings.TrimSpace(line)] = true
+	}
+
+	// Comments are only added along with a missing pattern
+	var missing []string
+	needed := false
+	for _, line := range strings.Split(strings.TrimSpace(entries), "\n") {
+		if !present[strings.TrimSpace(line)] {
+			missing = append(missing, line)
+			needed = needed || !strings.HasPrefix(line, "#")
+		}
+	}
+	if !needed {
+		fmt.Printf("exists   %s\n", path)
+		return nil
+	}
+
+	content := string(existing)
+	if content != "" && !strings.HasSuffix(content, "\n") {
+		content += "\n"
+	}
+	content += strings.Join(missing, "\n") + "\n"
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		return err
+	}
+	if len(existing) == 0 {
+		fmt.Printf("created  %s\n", path)
+	} else {
+		fmt.Printf("updated  %s\n", path)
+	}
+	return nil
+}
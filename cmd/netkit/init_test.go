@@ -0,0 +1,90 @@
+//go:build unit
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInitProject(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "project")
+	require.NoError(t, initProject([]string{dir}))
+
+	for _, path := range []string{"netkit.yaml", "environments/ci.yaml", "environments/staging.yaml", "collections/example.ndjson", "mocks/behaviors.yaml", "mocks/openapi.yaml"} {
+		want, err := scaffold.ReadFile("scaffold/" + path)
+		require.NoError(t, err)
+		got, err := os.ReadFile(filepath.Join(dir, filepath.FromSlash(path)))
+		require.NoError(t, err, path)
+		assert.Equal(t, string(want), string(got), path)
+	}
+	gitignore, err := os.ReadFile(filepath.Join(dir, ".gitignore"))
+	require.NoError(t, err)
+	assert.Contains(t, string(gitignore), ".netkit/\n")
+	assert.NoFileExists(t, filepath.Join(dir, "gitignore"))
+}
+
+func TestInitProjectKeepsExistingFiles(t *testing.T) {
+	dir := t.TempDir()
+	config := filepath.Join(dir, "netkit.yaml")
+	require.NoError(t, os.WriteFile(config, []byte("admin-port: 9000\n"), 0o600))
+
+	require.NoError(t, initProject([]string{dir}))
+	data, err := os.ReadFile(config)
+	require.NoError(t, err)
+	assert.Equal(t, "admin-port: 9000\n", string(data), "existing files are kept without --force")
+	assert.FileExists(t, filepath.Join(dir, "mocks", "openapi.yaml"), "missing files are still created")
+
+	// --force overwrites them, given before or after the directory
+	require.NoError(t, initProject([]string{dir, "--force"}))
+	data, err = os.ReadFile(config)
+	require.NoError(t, err)
+	want, err := scaffold.ReadFile("scaffold/netkit.yaml")
+	require.NoError(t, err)
+	assert.Equal(t, string(want), string(data))
+
+	require.NoError(t, os.WriteFile(config, []byte("admin-port: 9000\n"), 0o600))
+	require.NoError(t, initProject([]string{"--force", dir}))
+	data, err = os.ReadFile(config)
+	require.NoError(t, err)
+	assert.Equal(t, string(want), string(data))
+
+	assert.ErrorContains(t, initProject([]string{dir, "other"}), "usage: netkit init")
+}
+
+func TestAddGitignoreEntries(t *testing.T) {
+	entries := "# netkit: local files\n.netkit/\n*.db\n"
+	tests := []struct {
+		name     string
+		existing string // No file when empty
+		want     string
+	}{
+		{"created", "", entries},
+		{"appended after existing content", "node_modules/\n", "node_modules/\n" + entries},
+		{"a missing final newline is added", "node_modules/", "node_modules/\n" + entries},
+		{"only missing patterns are added", "*.db\n", "*.db\n# netkit: local files\n.netkit/\n"},
+		{"nothing is added when every pattern is present", "dist/\n .netkit/\n*.db\n", "dist/\n .netkit/\n*.db\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), ".gitignore")
+			if tt.existing != "" {
+				require.NoError(t, os.WriteFile(path, []byte(tt.existing), 0o600))
+			}
+			require.NoError(t, addGitignoreEntries(path, entries))
+			data, err := os.ReadFile(path)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, string(data))
+
+			// Running it again adds nothing
+			require.NoError(t, addGitignoreEntries(path, entries))
+			again, err := os.ReadFile(path)
+			require.NoError(t, err)
+			assert.Equal(t, string(data), string(again))
+		})
+	}
+}
@@ -1,11 +1,22 @@
 package main
 
 import (
+	"crypto/ed25519"
+	"errors"
 	"flag"
+	"fmt"
+	"io"
 	"log"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
 	"os"
 	"os/signal"
+	"path/filepath"
+	"strings"
 	"syscall"
+	"time"
 
 	"github.com/biancarosa/netkit/internal/proxy"
 )
@@ -13,7 +24,7 @@ import (
 func main() {
 	// Parse command
 	if len(os.Args) < 2 {
-		log.Fatal("Please specify a command: serve or request")
+		log.Fatal("Please specify a command: serve, request, session, filters, report, expose, tunnel-server, mock, replay, codegen, init, export, sinks, rules, config, bench, or scan")
 	}
 
 	command := os.Args[1]
@@ -26,21 +37,678 @@ func main() {
 		if err := runRequest(); err != nil {
 			log.Fatal(err)
 		}
+	case "session":
+		if err := runSession(); err != nil {
+			log.Fatal(err)
+		}
+	case "filters":
+		if err := runFilters(); err != nil {
+			log.Fatal(err)
+		}
+	case "report":
+		if err := runReport(); err != nil {
+			log.Fatal(err)
+		}
+	case "expose":
+		if err := runExpose(); err != nil {
+			log.Fatal(err)
+		}
+	case "tunnel-server":
+		if err := runTunnelServer(); err != nil {
+			log.Fatal(err)
+		}
+	case "mock":
+		if err := runMock(); err != nil {
+			log.Fatal(err)
+		}
+	case "replay":
+		if err := runReplay(); err != nil {
+			log.Fatal(err)
+		}
+	case "codegen":
+		if err := runCodegen(); err != nil {
+			log.Fatal(err)
+		}
+	case "init":
+		if err := runInit(); err != nil {
+			log.Fatal(err)
+		}
+	case "export":
+		if err := runExport(); err != nil {
+			log.Fatal(err)
+		}
+	case "sinks":
+		if err := runSinks(); err != nil {
+			log.Fatal(err)
+		}
+	case "rules":
+		if err := runRules(); err != nil {
+			log.Fatal(err)
+		}
+	case "config":
+		if err := runConfig(); err != nil {
+			log.Fatal(err)
+		}
+	case "bench":
+		if err := runBench(); err != nil {
+			log.Fatal(err)
+		}
+	case "scan":
+		if err := runScan(); err != nil {
+			log.Fatal(err)
+		}
 	default:
-		log.Fatalf("Unknown command: %s. Use 'serve' or 'request'", command)
+		log.Fatalf("Unknown command: %s. Use 'serve', 'request', 'session', 'filters', 'report', 'expose', 'tunnel-server', 'mock', 'replay', 'codegen', 'init', 'export', 'sinks', 'rules', 'config', 'bench', or 'scan'", command)
 	}
 }
 
-func runServe() {
+// serveConfig builds the proxy configuration from serve's command-line flags
+// and the --config file. It runs again on every reload, so the file is re-read
+// while the command line stays the same. With --watch it also returns the
+// files the configuration was read from.
+func serveConfig(args []string) (*proxy.Config, []string, error) {
 	// Parse command line flags
-	port := flag.Int("port", 8080, "Port to listen on")
-	adminPort := flag.Int("admin-port", 8081, "Admin port for health checks and metrics (0 to disable)")
-	historySize := flag.Int("history-size", 1000, "Maximum number of requests to keep in history")
-	dashboard := flag.Bool("dashboard", true, "Enable web dashboard")
-	dashboardPort := flag.Int("dashboard-port", 3000, "Dashboard port")
-	dashboardDir := flag.String("dashboard-dir", "", "Directory containing dashboard build files (optional if embedded)")
-	logLevel := flag.String("log-level", "info", "Logging level (debug, info, warn, error)")
-	flag.Parse()
+	flags := flag.NewFlagSet("serve", flag.ContinueOnError)
+	configFile := flags.String("config", os.Getenv("NETKIT_CONFIG"), "YAML or TOML file of serve settings keyed by flag name; command-line flags override it (default: $NETKIT_CONFIG)")
+	watch := flags.Bool("watch", false, "Reload when the config file or a body schema file changes, keeping the current configuration if the new one is invalid")
+	port := flags.Int("port", 8080, "Port to listen on")
+	adminPort := flags.Int("admin-port", 8081, "Admin port for health checks and metrics (0 to disable)")
+	historySize := flags.Int("history-size", 1000, "Maximum number of requests to keep in history")
+	dashboard := flags.Bool("dashboard", true, "Enable web dashboard")
+	dashboardPort := flags.Int("dashboard-port", 3000, "Dashboard port")
+	dashboardDir := flags.String("dashboard-dir", "", "Directory containing dashboard build files (optional if embedded)")
+	logLevel := flags.String("log-level", "info", "Logging level (debug, info, warn, error)")
+	logFormat := flags.String("log-format", proxy.LogFormatText, "Log format: text or json")
+	logFile := flags.String("log-file", "", "Write logs to this file instead of stderr, rotating it by size and age")
+	logMaxSize := flags.Int64("log-max-size", proxy.DefaultLogMaxSize>>20, "Rotate --log-file once it reaches this many MiB; 0 disables size-based rotation")
+	logRotateEvery := flags.Duration("log-rotate-every", 0, "Rotate --log-file after it has been written to for this long, e.g. 24h; 0 disables time-based rotation")
+	logMaxBackups := flags.Int("log-max-backups", 7, "Rotated log files kept, removing the oldest first; 0 keeps all")
+	logMaxAge := flags.Duration("log-max-age", 0, "Remove rotated log files older than this, e.g. 720h; 0 keeps them regardless of age")
+	logSyslog := flags.String("log-syslog", "", "Send logs to a syslog daemon instead of stderr: udp://host:514, tcp://host:514, unix:///dev/log, or local")
+	logSyslogFacility := flags.String("log-syslog-facility", proxy.DefaultSyslogFacility, "Syslog facility logs are sent with, e.g. daemon, user, or local0 to local7")
+	logSyslogTag := flags.String("log-syslog-tag", "netkit", "Syslog tag (app name) logs are sent with")
+	conditionalGET := flags.Bool("conditional-get", false, "Revalidate cached GET responses upstream and serve full responses on 304")
+	cacheSize := flags.Int("cache-size", 500, "Maximum number of responses kept for conditional GET revalidation")
+	preflightCache := flags.Bool("preflight-cache", false, "Forward CORS preflights upstream and answer repeats for the same origin and route locally")
+	preflightMaxAge := flags.Duration("preflight-max-age", proxy.DefaultPreflightMaxAge, "Longest a preflight is cached with --preflight-cache; a lower upstream Access-Control-Max-Age wins")
+	var bodySchemaSpecs stringSliceFlag
+	flags.Var(&bodySchemaSpecs, "body-schema", "Decode binary bodies on a route with a .proto/.thrift schema (route=file:RequestType[,ResponseType], repeatable)")
+	xmlPretty := flags.Bool("xml-pretty", false, "Store captured XML bodies pretty-printed")
+	var xmlRedactSpecs stringSliceFlag
+	flags.Var(&xmlRedactSpecs, "xml-redact", "XPath of XML elements or attributes to mask in captured bodies, e.g. //Password or //Login/@token (repeatable)")
+	grpcWeb := flags.Bool("grpc-web", false, "Translate gRPC-Web requests to native gRPC toward upstreams")
+	grpcWebUpstream := flags.String("grpc-web-upstream", "", "gRPC upstream for gRPC-Web requests sent directly to the proxy (e.g. http://localhost:50051)")
+	protocolSniffing := flags.Bool("protocol-sniffing", false, "Detect TLS, SOCKS4/5, and plain HTTP on the proxy port")
+	tlsCert := flags.String("tls-cert", "", "Certificate file for terminating TLS on the proxy port (requires --protocol-sniffing)")
+	tlsKey := flags.String("tls-key", "", "Private key file for --tls-cert")
+	var reverseSpecs stringSliceFlag
+	flags.Var(&reverseSpecs, "reverse", "Reverse-proxy a route to an upstream (route=upstream[,host=rewrite|preserve|<value>][,forwarded][,absolute=route|forward|reject], repeatable)")
+	redirectPolicy := flags.String("redirect-policy", "follow", "How upstream redirects are handled: follow, none (pass through to the client), or record (follow and record every hop)")
+	maxRedirects := flags.Int("max-redirects", 10, "Maximum redirects followed per request")
+	var webhookSpecs stringSliceFlag
+	flags.Var(&webhookSpecs, "webhook-secret", "Verify webhook signatures on a route (route=stripe|github|slack:secret, secret may be env:NAME, repeatable)")
+	webhookTolerance := flags.Duration("webhook-tolerance", 5*time.Minute, "Maximum age of Stripe/Slack signature timestamps (0 disables the check)")
+	inboxPath := flags.String("inbox-path", "", "Path prefix that accepts and stores any webhook, e.g. /inbox/")
+	inboxForward := flags.String("inbox-forward", "", "Local target that captured inbox webhooks are forwarded to (e.g. http://localhost:3000)")
+	inboxRetries := flags.Int("inbox-retries", 5, "Delivery retries while the inbox forward target is down")
+	inboxRetryInterval := flags.Duration("inbox-retry-interval", time.Second, "Delay before the first inbox delivery retry, doubled per attempt")
+	testEndpoints := flags.Bool("test-endpoints", false, "Serve /__netkit/echo, /__netkit/status/{code}, and /__netkit/delay/{duration} from the proxy itself")
+	serverTiming := flags.Bool("server-timing", true, "Add a Server-Timing header with upstream latency and proxy overhead to proxied responses")
+	timingHeaders := flags.Bool("timing-headers", false, "Add X-Netkit-* timing and cache status headers to proxied responses")
+	reportSchedule := flags.String("report-schedule", "", "Cron schedule for traffic reports, e.g. \"0 9 * * *\", @daily, or \"@every 1h\"")
+	reportFormat := flags.String("report-format", "markdown", "Comma-separated report formats written to --report-dir (json, html, markdown)")
+	reportDir := flags.String("report-dir", "", "Directory scheduled reports are written to")
+	var reportNotify stringSliceFlag
+	flags.Var(&reportNotify, "report-notify", "URL each scheduled report is POSTed to; Slack incoming webhooks receive a Markdown message (repeatable)")
+	advisoryHeaders := flags.Bool("advisory-headers", false, "Attach Deprecation/Sunset and recent rate-limit headers seen earlier on a route to responses that lack them")
+	filtersFile := flags.String("filters-file", "", "JSON file saved history filters persist to (default: kept in memory)")
+	adminToken := flags.String("admin-token", os.Getenv("NETKIT_ADMIN_TOKEN"), "Static admin API token with every scope; when set the admin API requires a bearer token (default: $NETKIT_ADMIN_TOKEN)")
+	tokensFile := flags.String("tokens-file", "", "JSON file issued admin API tokens persist to, stored hashed (default: kept in memory)")
+	workspace := flags.String("workspace", proxy.DefaultWorkspace, "Workspace name admin API tokens must be scoped to")
+	var adminOriginSpecs stringSliceFlag
+	flags.Var(&adminOriginSpecs, "admin-origin", "Browser origin allowed to call the admin API, with credentials, e.g. http://localhost:3000; others are rejected and logged (repeatable, default: any origin without credentials)")
+	pprof := flags.Bool("pprof", false, "Serve CPU, heap, goroutine, and other Go runtime profiles under /debug/pprof/ on the admin port")
+	var providerSpecs stringSliceFlag
+	flags.Var(&providerSpecs, "provider", "Group upstream hosts into a named provider for SLA reports, e.g. stripe=api.stripe.com,*.stripe.com (repeatable)")
+	streamThreshold := flags.Int64("stream-threshold", 1<<20, "Pipe bodies larger than this many bytes, or of unknown length, instead of buffering them; negative buffers every body")
+	streamCapture := flags.Int64("stream-capture", 64<<10, "Bytes at the start of a streamed body kept in history")
+	captureMaxBody := flags.Int64("capture-max-body-bytes", 0, "Bytes at the start of every request and response body kept in history; longer bodies are truncated (0 keeps whole bodies)")
+	var captureBodyTypeSpecs stringSliceFlag
+	flags.Var(&captureBodyTypeSpecs, "capture-body-type", "Content type whose bodies are stored in history, e.g. application/json, image/*, or */* for every type; other bodies keep only their size and SHA-256 (repeatable, default: every type but images, video, audio, fonts, PDFs, archives, and application/octet-stream)")
+	var rawCaptureSpecs stringSliceFlag
+	flags.Var(&rawCaptureSpecs, "raw-capture", "Keep the exact bytes sent to and received from the upstream for a route (host/path/prefix), downloadable from /requests/raw (repeatable)")
+	rawCaptureLimit := flags.Int64("raw-capture-limit", proxy.DefaultRawCaptureLimit, "Bytes of each direction a raw capture keeps")
+	var capturePolicySpecs stringSliceFlag
+	flags.Var(&capturePolicySpecs, "capture-policy", "Limit what is recorded for a route, as route=mode with mode full, headers-only, metadata-only, or none, e.g. *.stripe.com=metadata-only; the first matching policy applies (repeatable, default: full)")
+	var redactHeaderSpecs stringSliceFlag
+	flags.Var(&redactHeaderSpecs, "redact-header", "Header whose value is masked before requests are stored in history (repeatable, added to the defaults)")
+	var redactBodySpecs stringSliceFlag
+	flags.Var(&redactBodySpecs, "redact-body", "Regular expression masked in bodies and query strings before requests are stored, only its first group when it has one (repeatable, added to the defaults)")
+	redactDefaults := flags.Bool("redact-defaults", true, "Mask Authorization, Proxy-Authorization, Cookie, and Set-Cookie values and tokens and keys in bodies and query strings before requests are stored")
+	rulesDir := flags.String("rules-dir", "", "Directory rule bundles are installed to with netkit rules install; their settings apply after the command line and config file (default: importing is off)")
+	var rulesKeySpecs stringSliceFlag
+	flags.Var(&rulesKeySpecs, "rules-key", "Base64 Ed25519 public key trusted to sign rule bundles, as printed by netkit rules keygen (repeatable)")
+	var teeSpecs stringSliceFlag
+	flags.Var(&teeSpecs, "tee", "Stream response bodies of a route to a sink while serving them (route=file:PATH, route=exec:COMMAND, or route=URL, repeatable)")
+	var extractSpecs stringSliceFlag
+	flags.Var(&extractSpecs, "extract", "Save response bodies of a route and type to --extract-dir, e.g. '*=application/pdf,.csv' (route=type[,type...], repeatable)")
+	extractDir := flags.String("extract-dir", "", "Directory --extract saves response bodies and their index to (required with --extract)")
+	extractMaxSize := flags.Int64("extract-max-size", proxy.DefaultExtractMaxSize>>20, "Largest response body --extract saves, in MiB")
+	capturesDir := flags.String("captures-dir", "", "Directory named captures persist to, one file each (default: kept in memory)")
+	clearProtection := flags.String("clear-protection", "", "Protect clearing history and deleting runs or captures: confirm (repeat with a confirmation token) or admin (admin scope only)")
+	clearBackup := flags.Bool("clear-backup", false, "Save history as a pre-clear-<time> capture before it is cleared or a run's records are deleted")
+	historyFile := flags.String("history-file", "", "File request history is saved to and restored from across restarts (default: kept in memory)")
+	historyBackend := flags.String("history-backend", proxy.HistoryBackendMemory, "Where request history is kept: memory, sqlite (durable, needs a binary built with -tags sqlite), bolt (durable, embedded), or redis (shared by every instance using the same --redis-url)")
+	historyPath := flags.String("history-path", "netkit.db", "Database or file request history is kept in with --history-backend=sqlite or bolt")
+	redisURL := flags.String("redis-url", os.Getenv("NETKIT_REDIS_URL"), "Redis server request history is shared in with --history-backend=redis, e.g. redis://:password@redis:6379/0 (default: $NETKIT_REDIS_URL)")
+	historyTTL := flags.Duration("history-ttl", 0, "Remove history records older than this, e.g. 168h, from memory and the history backend (default: keep them)")
+	historyKeySpec := flags.String("history-key", "", "Encrypt the history file or history backend with a key from env:NAME, file:PATH, or vault:KEY (Vault transit, uses $VAULT_ADDR and $VAULT_TOKEN)")
+	idFormat := flags.String("id-format", proxy.IDRandom, "Request ID format: random, uuidv7, ulid, or snowflake[:NODE]")
+	samplingSpec := flags.String("sampling", "", "Keep a share of requests in history: head:RATE or keep-errors:RATE, e.g. keep-errors:0.1 (default: keep all)")
+	tailSamplingRate := flags.Float64("tail-sampling", -1, "Buffer records briefly and keep every error, slow, or --tail-keep request plus this share (0 to 1) of the rest")
+	tailSlow := flags.Duration("tail-slow", 0, "With --tail-sampling, always keep requests slower than this (e.g. 1s)")
+	var tailKeepSpecs stringSliceFlag
+	flags.Var(&tailKeepSpecs, "tail-keep", "With --tail-sampling, always keep requests matching a filter query, e.g. method=POST&host=api.example.com (repeatable)")
+	tailDelay := flags.Duration("tail-delay", 0, "With --tail-sampling, how long records are buffered before the decision (default: 2s)")
+	allowOptions := flags.String("allow-options", "", "Comma-separated X-Netkit-Options clients may set: capture, no-cache, timeout, tags, upstream (default: none)")
+	hedgeAfter := flags.Duration("hedge-after", 0, "Send a second copy of idempotent requests with no response after this long and use the first answer (e.g. 200ms)")
+	hedgeTarget := flags.String("hedge-target", "", "With --hedge-after, send the second copy to this scheme and host instead (e.g. http://replica:8080)")
+	adaptiveConcurrency := flags.String("adaptive-concurrency", "", "Limit concurrent requests per upstream host with an adaptive limit: aimd or gradient (default: unlimited)")
+	concurrencyInitial := flags.Int("concurrency-initial", 20, "With --adaptive-concurrency, the limit each upstream starts at")
+	concurrencyMin := flags.Int("concurrency-min", 1, "With --adaptive-concurrency, the lowest limit")
+	concurrencyMax := flags.Int("concurrency-max", 1000, "With --adaptive-concurrency, the highest limit")
+	var throttleSpecs stringSliceFlag
+	flags.Var(&throttleSpecs, "throttle", "Slow down clients whose header matches a pattern without blocking them (header=pattern:rate=N/s,burst=N,delay=DURATION,max-wait=DURATION, e.g. User-Agent=python-requests/*:rate=2/s, repeatable)")
+	var latencyProfileSpecs stringSliceFlag
+	flags.Var(&latencyProfileSpecs, "latency-profile", "Hold responses of a route so its end-to-end latency follows target percentiles, as route=pN:DURATION,... (e.g. api.example.com=p50:120ms,p95:800ms,p99:2s) or route=@FILE to import them from a saved /requests/stats/hosts report (repeatable, first match wins)")
+	var prewarmSpecs stringSliceFlag
+	flags.Var(&prewarmSpecs, "prewarm", "Resolve and open connections to this upstream at startup and keep them warm, e.g. https://api.example.com (repeatable)")
+	prewarmConnections := flags.Int("prewarm-connections", 2, "With --prewarm, warm connections kept per upstream")
+	var dnsSpecs stringSliceFlag
+	flags.Var(&dnsSpecs, "dns", "Resolve upstream names with this DNS-over-HTTPS URL or DNS-over-TLS server (tls://host[:port]), tried in order (repeatable)")
+	dnsFallback := flags.Bool("dns-fallback", true, "With --dns, ask the system's name server when every resolver fails")
+	var ipFamilySpecs stringSliceFlag
+	flags.Var(&ipFamilySpecs, "ip-family", "How connections to upstreams choose between IPv4 and IPv6: happy-eyeballs, ipv4, ipv6, prefer-ipv4, or prefer-ipv6, for every host or host=mode (repeatable)")
+	happyEyeballsDelay := flags.Duration("happy-eyeballs-delay", proxy.DefaultHappyEyeballsDelay, "Head start of one address family before the other is tried (negative tries them one after the other)")
+	var sourceSpecs stringSliceFlag
+	flags.Var(&sourceSpecs, "source", "Bind upstream connections to this local IP address or network interface, for every upstream or route=source (repeatable)")
+	var sshJumpSpecs stringSliceFlag
+	flags.Var(&sshJumpSpecs, "ssh-jump", "Connect to upstream hosts matching a pattern through an SSH jump host, as host=user@jumphost[:port] (repeatable)")
+	sshKey := flags.String("ssh-key", "", "Private key to log in to --ssh-jump hosts with")
+	sshKnownHosts := flags.String("ssh-known-hosts", "", "known_hosts file --ssh-jump host keys are checked against (default: ~/.ssh/known_hosts)")
+	var sinkSpecs stringSliceFlag
+	flags.Var(&sinkSpecs, "sink", "Export new records to a file, webhook, collector, kafka (REST Proxy), or s3 sink, as name=kind:target[;filter=QUERY;batch=N;interval=D;retries=N;disabled] (repeatable)")
+	sinkDeadLetterDir := flags.String("sink-dead-letter-dir", "", "Directory --sink batches are spooled to when every delivery retry fails, for netkit sinks replay")
+	statsdAddr := flags.String("statsd-addr", "", "Send per-request counters and timings to a StatsD server at host:port over UDP")
+	statsdPrefix := flags.String("statsd-prefix", "netkit.", "Prefix of the metric names sent to --statsd-addr")
+	statsdFlavor := flags.String("statsd-flavor", proxy.StatsDPlain, "StatsD format: statsd, or dogstatsd to tag metrics by method, status class, and upstream")
+	var statsdTags stringSliceFlag
+	flags.Var(&statsdTags, "statsd-tag", "Tag added to every StatsD metric with --statsd-flavor dogstatsd, e.g. env:staging (repeatable)")
+	otlpEndpoint := flags.String("otlp-endpoint", "", "Export a span for each proxied request, with a child span for its upstream call, to an OpenTelemetry collector at this OTLP/HTTP URL, e.g. http://localhost:4318")
+	var otlpHeaders stringSliceFlag
+	flags.Var(&otlpHeaders, "otlp-header", "Header sent with every span export as key=value, e.g. Authorization=Bearer TOKEN (repeatable)")
+	traceGenerate := flags.Bool("trace-generate", false, "Start a W3C trace for requests without a traceparent header and send it upstream, so upstream logs can be matched with history")
+	otlpServiceName := flags.String("otlp-service-name", proxy.DefaultTracingServiceName, "service.name of the spans exported to --otlp-endpoint")
+	strictParsing := flags.Bool("strict-parsing", false, "Reject malformed or ambiguous requests on the proxy port with a 400: smuggling-prone framing, unencoded URI characters, folded headers, and conflicting Host")
+	strictHeaderBytes := flags.Int("strict-max-header-bytes", 32768, "Largest request line and headers accepted with --strict-parsing")
+	var headerCaseSpecs stringSliceFlag
+	flags.Var(&headerCaseSpecs, "preserve-header-case", "Send request headers of a route (host/path/prefix) upstream with the casing the client used instead of Go's canonical form, for case-sensitive legacy upstreams (repeatable)")
+	if err := flags.Parse(args); err != nil {
+		return nil, nil, err
+	}
+
+	// Fill in settings the command line left out from the config file
+	if *configFile != "" {
+		if err := applyConfigFile(flags, *configFile); err != nil {
+			return nil, nil, fmt.Errorf("Invalid --config: %v", err)
+		}
+	}
+	if *rulesDir != "" {
+		if err := applyRuleBundles(flags, *rulesDir); err != nil {
+			return nil, nil, fmt.Errorf("Invalid --rules-dir: %v", err)
+		}
+	}
+
+	// Load body schemas up front so invalid schema files fail fast
+	var bodySchemas []proxy.BodySchema
+	for _, spec := range bodySchemaSpecs {
+		bodySchema, err := proxy.ParseBodySchema(spec)
+		if err != nil {
+			return nil, nil, fmt.Errorf("Invalid --body-schema: %v", err)
+		}
+		bodySchemas = append(bodySchemas, bodySchema)
+	}
+
+	var xmlRedactions []proxy.XPath
+	for _, expr := range xmlRedactSpecs {
+		xpath, err := proxy.ParseXPath(expr)
+		if err != nil {
+			return nil, nil, fmt.Errorf("Invalid --xml-redact: %v", err)
+		}
+		xmlRedactions = append(xmlRedactions, xpath)
+	}
+
+	var reverseRoutes []proxy.ReverseRoute
+	for _, spec := range reverseSpecs {
+		reverseRoute, err := proxy.ParseReverseRoute(spec)
+		if err != nil {
+			return nil, nil, fmt.Errorf("Invalid --reverse: %v", err)
+		}
+		reverseRoutes = append(reverseRoutes, reverseRoute)
+	}
+
+	var webhookVerifiers []proxy.WebhookVerifier
+	for _, spec := range webhookSpecs {
+		verifier, err := proxy.ParseWebhookVerifier(spec)
+		if err != nil {
+			return nil, nil, fmt.Errorf("Invalid --webhook-secret: %v", err)
+		}
+		webhookVerifiers = append(webhookVerifiers, verifier)
+	}
+
+	if *inboxForward != "" && *inboxPath == "" {
+		return nil, nil, fmt.Errorf("--inbox-forward requires --inbox-path")
+	}
+
+	var schedule *proxy.CronSchedule
+	var reportFormats []string
+	if *reportSchedule != "" {
+		parsed, err := proxy.ParseCronSchedule(*reportSchedule)
+		if err != nil {
+			return nil, nil, fmt.Errorf("Invalid --report-schedule: %v", err)
+		}
+		schedule = parsed
+		if *reportDir == "" && len(reportNotify) == 0 {
+			return nil, nil, fmt.Errorf("--report-schedule requires --report-dir or --report-notify")
+		}
+		for _, format := range strings.Split(*reportFormat, ",") {
+			format = strings.TrimSpace(format)
+			if err := proxy.ValidateReportFormat(format); err != nil {
+				return nil, nil, fmt.Errorf("Invalid --report-format: %v", err)
+			}
+			reportFormats = append(reportFormats, format)
+		}
+	}
+
+	var adminOrigins []string
+	for _, spec := range adminOriginSpecs {
+		origin, err := proxy.ParseAdminOrigin(spec)
+		if err != nil {
+			return nil, nil, fmt.Errorf("Invalid --admin-origin: %v", err)
+		}
+		adminOrigins = append(adminOrigins, origin)
+	}
+
+	if _, err := proxy.ParseLogLevel(*logLevel); err != nil {
+		return nil, nil, fmt.Errorf("Invalid --log-level: %v", err)
+	}
+	if err := proxy.ValidateLogFormat(*logFormat); err != nil {
+		return nil, nil, fmt.Errorf("Invalid --log-format: %v", err)
+	}
+	if *logMaxSize < 0 {
+		return nil, nil, fmt.Errorf("Invalid --log-max-size: must be 0 or above")
+	}
+	if *logRotateEvery < 0 {
+		return nil, nil, fmt.Errorf("Invalid --log-rotate-every: must be 0 or above")
+	}
+	if *logMaxBackups < 0 {
+		return nil, nil, fmt.Errorf("Invalid --log-max-backups: must be 0 or above")
+	}
+	if *logMaxAge < 0 {
+		return nil, nil, fmt.Errorf("Invalid --log-max-age: must be 0 or above")
+	}
+	if *logSyslog != "" {
+		if _, _, err := proxy.ParseSyslogAddress(*logSyslog); err != nil {
+			return nil, nil, fmt.Errorf("Invalid --log-syslog: %v", err)
+		}
+	}
+	if _, err := proxy.ParseSyslogFacility(*logSyslogFacility); err != nil {
+		return nil, nil, fmt.Errorf("Invalid --log-syslog-facility: %v", err)
+	}
+	if *logSyslogTag == "" || strings.ContainsAny(*logSyslogTag, " :[]\n") {
+		return nil, nil, fmt.Errorf("Invalid --log-syslog-tag: must be non-empty without spaces, colons, or brackets")
+	}
+
+	if *preflightMaxAge <= 0 {
+		return nil, nil, fmt.Errorf("Invalid --preflight-max-age: must be above 0")
+	}
+
+	var providers []proxy.Provider
+	for _, spec := range providerSpecs {
+		provider, err := proxy.ParseProvider(spec)
+		if err != nil {
+			return nil, nil, fmt.Errorf("Invalid --provider: %v", err)
+		}
+		providers = append(providers, provider)
+	}
+
+	var rulesKeys []ed25519.PublicKey
+	for _, spec := range rulesKeySpecs {
+		key, err := proxy.ParseRulesKey(spec)
+		if err != nil {
+			return nil, nil, fmt.Errorf("Invalid --rules-key: %v", err)
+		}
+		rulesKeys = append(rulesKeys, key)
+	}
+
+	var capturePolicies []proxy.CapturePolicy
+	for _, spec := range capturePolicySpecs {
+		policy, err := proxy.ParseCapturePolicy(spec)
+		if err != nil {
+			return nil, nil, fmt.Errorf("Invalid --capture-policy: %v", err)
+		}
+		capturePolicies = append(capturePolicies, policy)
+	}
+
+	var redaction proxy.Redaction
+	if *redactDefaults {
+		redaction = proxy.DefaultRedaction()
+	}
+	for _, header := range redactHeaderSpecs {
+		if header = strings.TrimSpace(header); header == "" || strings.ContainsAny(header, " :") {
+			return nil, nil, fmt.Errorf("Invalid --redact-header: %q is not a header name", header)
+		}
+		redaction.Headers = append(redaction.Headers, header)
+	}
+	for _, expr := range redactBodySpecs {
+		pattern, err := proxy.ParseRedactPattern(expr)
+		if err != nil {
+			return nil, nil, fmt.Errorf("Invalid --redact-body: %v", err)
+		}
+		redaction.Patterns = append(redaction.Patterns, pattern)
+	}
+
+	if err := proxy.ValidateHistoryBackend(*historyBackend); err != nil {
+		return nil, nil, fmt.Errorf("Invalid --history-backend: %v", err)
+	}
+	if *historyBackend != "" && *historyBackend != proxy.HistoryBackendMemory && *historyFile != "" {
+		return nil, nil, fmt.Errorf("--history-file cannot be used with --history-backend=%s", *historyBackend)
+	}
+	if *historyBackend == proxy.HistoryBackendRedis && *redisURL == "" {
+		return nil, nil, fmt.Errorf("--history-backend=redis requires --redis-url")
+	}
+	if *historyTTL < 0 {
+		return nil, nil, fmt.Errorf("Invalid --history-ttl: must not be negative")
+	}
+
+	var historyKey proxy.KeyProvider
+	if *historyKeySpec != "" {
+		if *historyFile == "" && (*historyBackend == "" || *historyBackend == proxy.HistoryBackendMemory) {
+			return nil, nil, fmt.Errorf("--history-key requires --history-file or a --history-backend other than memory")
+		}
+		parsed, err := proxy.ParseHistoryKey(*historyKeySpec)
+		if err != nil {
+			return nil, nil, fmt.Errorf("Invalid --history-key: %v", err)
+		}
+		historyKey = parsed
+	}
+
+	idGenerator, err := proxy.ParseIDGenerator(*idFormat)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Invalid --id-format: %v", err)
+	}
+
+	var sampler proxy.Sampler
+	if *samplingSpec != "" {
+		sampler, err = proxy.ParseSampler(*samplingSpec)
+		if err != nil {
+			return nil, nil, fmt.Errorf("Invalid --sampling: %v", err)
+		}
+	}
+
+	var allowedOptions []string
+	for _, name := range strings.Split(*allowOptions, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			allowedOptions = append(allowedOptions, name)
+		}
+	}
+	if err := proxy.ValidateRequestOptions(allowedOptions); err != nil {
+		return nil, nil, fmt.Errorf("Invalid --allow-options: %v", err)
+	}
+
+	var hedgeTargetURL *url.URL
+	if *hedgeTarget != "" {
+		if *hedgeAfter <= 0 {
+			return nil, nil, fmt.Errorf("--hedge-target requires --hedge-after")
+		}
+		hedgeTargetURL, err = proxy.ParseHedgeTarget(*hedgeTarget)
+		if err != nil {
+			return nil, nil, fmt.Errorf("Invalid --hedge-target: %v", err)
+		}
+	}
+
+	var concurrencyLimit *proxy.ConcurrencyLimit
+	if *adaptiveConcurrency != "" {
+		concurrencyLimit = &proxy.ConcurrencyLimit{
+			Algorithm: *adaptiveConcurrency,
+			Initial:   *concurrencyInitial,
+			Min:       *concurrencyMin,
+			Max:       *concurrencyMax,
+		}
+		if err := proxy.ValidateConcurrencyLimit(*concurrencyLimit); err != nil {
+			return nil, nil, fmt.Errorf("Invalid --adaptive-concurrency: %v", err)
+		}
+	}
+
+	var throttleRules []proxy.ThrottleRule
+	for _, spec := range throttleSpecs {
+		rule, err := proxy.ParseThrottleRule(spec)
+		if err != nil {
+			return nil, nil, fmt.Errorf("Invalid --throttle: %v", err)
+		}
+		throttleRules = append(throttleRules, rule)
+	}
+
+	var latencyProfiles []proxy.LatencyProfile
+	for _, spec := range latencyProfileSpecs {
+		profile, err := proxy.ParseLatencyProfile(spec)
+		if err != nil {
+			return nil, nil, fmt.Errorf("Invalid --latency-profile: %v", err)
+		}
+		latencyProfiles = append(latencyProfiles, profile)
+	}
+
+	var prewarm []*url.URL
+	for _, spec := range prewarmSpecs {
+		target, err := proxy.ParsePrewarmTarget(spec)
+		if err != nil {
+			return nil, nil, fmt.Errorf("Invalid --prewarm: %v", err)
+		}
+		prewarm = append(prewarm, target)
+	}
+	var dnsResolvers []proxy.DNSResolver
+	for _, spec := range dnsSpecs {
+		resolver, err := proxy.ParseDNSResolver(spec)
+		if err != nil {
+			return nil, nil, fmt.Errorf("Invalid --dns: %v", err)
+		}
+		dnsResolvers = append(dnsResolvers, resolver)
+	}
+
+	var ipFamilyRules []proxy.IPFamilyRule
+	for _, spec := range ipFamilySpecs {
+		rule, err := proxy.ParseIPFamilyRule(spec)
+		if err != nil {
+			return nil, nil, fmt.Errorf("Invalid --ip-family: %v", err)
+		}
+		ipFamilyRules = append(ipFamilyRules, rule)
+	}
+	if *happyEyeballsDelay == 0 {
+		return nil, nil, fmt.Errorf("Invalid --happy-eyeballs-delay: must not be 0 (negative tries the address families one after the other)")
+	}
+
+	var sourceRules []proxy.SourceRule
+	for _, spec := range sourceSpecs {
+		rule, err := proxy.ParseSourceRule(spec)
+		if err != nil {
+			return nil, nil, fmt.Errorf("Invalid --source: %v", err)
+		}
+		sourceRules = append(sourceRules, rule)
+	}
+
+	var sshJumps []proxy.SSHJump
+	for _, spec := range sshJumpSpecs {
+		jump, err := proxy.ParseSSHJump(spec)
+		if err != nil {
+			return nil, nil, fmt.Errorf("Invalid --ssh-jump: %v", err)
+		}
+		sshJumps = append(sshJumps, jump)
+	}
+	if len(sshJumps) > 0 {
+		if *sshKey == "" {
+			return nil, nil, fmt.Errorf("Invalid --ssh-key: required with --ssh-jump")
+		}
+		if *sshKnownHosts == "" {
+			home, err := os.UserHomeDir()
+			if err != nil {
+				return nil, nil, fmt.Errorf("Invalid --ssh-known-hosts: %v", err)
+			}
+			*sshKnownHosts = filepath.Join(home, ".ssh", "known_hosts")
+		}
+	}
+
+	if *prewarmConnections <= 0 {
+		return nil, nil, fmt.Errorf("Invalid --prewarm-connections: must be at least 1")
+	}
+
+	var sinks []proxy.Sink
+	sinkNames := make(map[string]bool)
+	for _, spec := range sinkSpecs {
+		sink, err := proxy.ParseSink(spec)
+		if err != nil {
+			return nil, nil, fmt.Errorf("Invalid --sink: %v", err)
+		}
+		if sinkNames[sink.Name] {
+			return nil, nil, fmt.Errorf("Invalid --sink: %q is named twice", sink.Name)
+		}
+		sinkNames[sink.Name] = true
+		sinks = append(sinks, sink)
+	}
+
+	if *statsdAddr != "" {
+		if _, _, err := net.SplitHostPort(*statsdAddr); err != nil {
+			return nil, nil, fmt.Errorf("Invalid --statsd-addr: %v", err)
+		}
+	}
+	if err := proxy.ValidateStatsDFlavor(*statsdFlavor); err != nil {
+		return nil, nil, fmt.Errorf("Invalid --statsd-flavor: %v", err)
+	}
+	for _, tag := range statsdTags {
+		if err := proxy.ValidateStatsDTag(tag); err != nil {
+			return nil, nil, fmt.Errorf("Invalid --statsd-tag: %v", err)
+		}
+	}
+	if len(statsdTags) > 0 && *statsdFlavor != proxy.StatsDDatadog {
+		return nil, nil, fmt.Errorf("Invalid --statsd-tag: tags need --statsd-flavor %s", proxy.StatsDDatadog)
+	}
+	if *otlpEndpoint != "" {
+		if parsed, err := url.Parse(*otlpEndpoint); err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+			return nil, nil, fmt.Errorf("Invalid --otlp-endpoint: expected an http or https URL, got %q", *otlpEndpoint)
+		}
+	}
+	tracingHeaders := make(map[string]string)
+	for _, header := range otlpHeaders {
+		key, value, ok := strings.Cut(header, "=")
+		if !ok || key == "" || strings.ContainsAny(key, " \t:") {
+			return nil, nil, fmt.Errorf("Invalid --otlp-header: expected key=value, got %q", header)
+		}
+		tracingHeaders[key] = value
+	}
+	if *strictHeaderBytes <= 0 {
+		return nil, nil, fmt.Errorf("Invalid --strict-max-header-bytes: must be at least 1")
+	}
+
+	var headerCaseRoutes []proxy.Route
+	for _, spec := range headerCaseSpecs {
+		headerCaseRoutes = append(headerCaseRoutes, proxy.ParseRoute(spec))
+	}
+
+	var tailSampling *proxy.TailSampling
+	if *tailSamplingRate >= 0 {
+		if *tailSamplingRate > 1 {
+			return nil, nil, fmt.Errorf("Invalid --tail-sampling: rate must be between 0 and 1")
+		}
+		if sampler != nil {
+			return nil, nil, fmt.Errorf("--tail-sampling cannot be combined with --sampling")
+		}
+		tailSampling = &proxy.TailSampling{Rate: *tailSamplingRate, SlowThreshold: *tailSlow, Delay: *tailDelay}
+		for _, spec := range tailKeepSpecs {
+			values, err := url.ParseQuery(strings.TrimPrefix(spec, "?"))
+			if err != nil {
+				return nil, nil, fmt.Errorf("Invalid --tail-keep: %v", err)
+			}
+			filter, err := proxy.ParseRequestFilter(values, true)
+			if err != nil {
+				return nil, nil, fmt.Errorf("Invalid --tail-keep: %v", err)
+			}
+			tailSampling.Keep = append(tailSampling.Keep, filter)
+		}
+	} else if *tailSlow > 0 || len(tailKeepSpecs) > 0 || *tailDelay > 0 {
+		return nil, nil, fmt.Errorf("--tail-slow, --tail-keep, and --tail-delay require --tail-sampling")
+	}
+
+	if *streamCapture <= 0 {
+		return nil, nil, fmt.Errorf("Invalid --stream-capture: must be at least 1")
+	}
+	if *captureMaxBody < 0 {
+		return nil, nil, fmt.Errorf("Invalid --capture-max-body-bytes: must not be negative")
+	}
+	var captureBodyTypes []string
+	for _, spec := range captureBodyTypeSpecs {
+		bodyType, err := proxy.ParseBodyType(spec)
+		if err != nil {
+			return nil, nil, fmt.Errorf("Invalid --capture-body-type: %v", err)
+		}
+		captureBodyTypes = append(captureBodyTypes, bodyType)
+	}
+
+	var rawCaptureRoutes []proxy.Route
+	for _, spec := range rawCaptureSpecs {
+		rawCaptureRoutes = append(rawCaptureRoutes, proxy.ParseRoute(spec))
+	}
+	if *rawCaptureLimit <= 0 {
+		return nil, nil, fmt.Errorf("Invalid --raw-capture-limit: must be at least 1")
+	}
+
+	var teeRules []proxy.TeeRule
+	for _, spec := range teeSpecs {
+		rule, err := proxy.ParseTeeRule(spec)
+		if err != nil {
+			return nil, nil, fmt.Errorf("Invalid --tee: %v", err)
+		}
+		teeRules = append(teeRules, rule)
+	}
+
+	var extractRules []proxy.ExtractRule
+	for _, spec := range extractSpecs {
+		rule, err := proxy.ParseExtractRule(spec)
+		if err != nil {
+			return nil, nil, fmt.Errorf("Invalid --extract: %v", err)
+		}
+		extractRules = append(extractRules, rule)
+	}
+	if len(extractRules) > 0 && *extractDir == "" {
+		return nil, nil, fmt.Errorf("Invalid --extract: --extract-dir is required")
+	}
+	if *extractMaxSize <= 0 {
+		return nil, nil, fmt.Errorf("Invalid --extract-max-size: must be at least 1")
+	}
+
+	if err := proxy.ValidateClearProtection(*clearProtection); err != nil {
+		return nil, nil, fmt.Errorf("Invalid --clear-protection: %v", err)
+	}
+
+	if err := proxy.ValidateRedirectPolicy(*redirectPolicy); err != nil {
+		return nil, nil, fmt.Errorf("Invalid --redirect-policy: %v", err)
+	}
+
+	tlsConfig, err := proxy.LoadTLSConfig(*tlsCert, *tlsKey)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Invalid --tls-cert/--tls-key: %v", err)
+	}
+	if tlsConfig != nil && !*protocolSniffing {
+		return nil, nil, fmt.Errorf("--tls-cert requires --protocol-sniffing")
+	}
 
 	// Create proxy configuration
 	config := &proxy.Config{
@@ -51,47 +719,271 @@ func runServe() {
 		DashboardPort: *dashboardPort,
 		DashboardDir:  *dashboardDir,
 		LogLevel:      *logLevel,
+		LogFormat:     *logFormat,
+
+		LogFile: *logFile,
+		LogRotation: proxy.LogRotation{
+			MaxSize:    *logMaxSize << 20,
+			Every:      *logRotateEvery,
+			MaxBackups: *logMaxBackups,
+			MaxAge:     *logMaxAge,
+		},
+
+		LogSyslog:         *logSyslog,
+		LogSyslogFacility: *logSyslogFacility,
+		LogSyslogTag:      *logSyslogTag,
+
+		ConditionalGET: *conditionalGET,
+		CacheSize:      *cacheSize,
+
+		PreflightCache:  *preflightCache,
+		PreflightMaxAge: *preflightMaxAge,
+
+		BodySchemas: bodySchemas,
+
+		XMLPrettyPrint: *xmlPretty,
+		XMLRedactions:  xmlRedactions,
+
+		GRPCWeb:         *grpcWeb,
+		GRPCWebUpstream: *grpcWebUpstream,
+
+		ProtocolSniffing: *protocolSniffing,
+		TLSConfig:        tlsConfig,
+
+		ReverseRoutes: reverseRoutes,
+
+		RedirectPolicy: *redirectPolicy,
+		MaxRedirects:   *maxRedirects,
+
+		WebhookVerifiers: webhookVerifiers,
+		WebhookTolerance: *webhookTolerance,
+
+		InboxPath:          *inboxPath,
+		InboxForward:       *inboxForward,
+		InboxRetries:       *inboxRetries,
+		InboxRetryInterval: *inboxRetryInterval,
+
+		TestEndpoints: *testEndpoints,
+
+		ServerTiming:  *serverTiming,
+		TimingHeaders: *timingHeaders,
+
+		ReportSchedule: schedule,
+		ReportFormats:  reportFormats,
+		ReportDir:      *reportDir,
+		ReportNotify:   reportNotify,
+
+		AdvisoryHeaders: *advisoryHeaders,
+
+		FiltersFile: *filtersFile,
+
+		AdminToken: *adminToken,
+		TokensFile: *tokensFile,
+		Workspace:  *workspace,
+		Pprof:      *pprof,
+
+		AdminOrigins: adminOrigins,
+
+		Providers: providers,
+
+		StreamThreshold:    *streamThreshold,
+		StreamCaptureLimit: *streamCapture,
+
+		CaptureMaxBodyBytes: *captureMaxBody,
+		CaptureBodyTypes:    captureBodyTypes,
+
+		RawCaptureRoutes: rawCaptureRoutes,
+		RawCaptureLimit:  *rawCaptureLimit,
+
+		TeeRules: teeRules,
+
+		ExtractRules:   extractRules,
+		ExtractDir:     *extractDir,
+		ExtractMaxSize: *extractMaxSize << 20,
+
+		CapturePolicies: capturePolicies,
+		Redaction:       redaction,
+
+		RulesDir:  *rulesDir,
+		RulesKeys: rulesKeys,
+
+		CapturesDir: *capturesDir,
+
+		ClearProtection: *clearProtection,
+		ClearBackup:     *clearBackup,
+
+		HistoryFile:    *historyFile,
+		HistoryKey:     historyKey,
+		HistoryBackend: *historyBackend,
+		HistoryPath:    *historyPath,
+		RedisURL:       *redisURL,
+		HistoryTTL:     *historyTTL,
+
+		IDGenerator: idGenerator,
+		Sampler:     sampler,
+
+		TailSampling: tailSampling,
+
+		AllowedOptions: allowedOptions,
+
+		HedgeDelay:  *hedgeAfter,
+		HedgeTarget: hedgeTargetURL,
+
+		ConcurrencyLimit: concurrencyLimit,
+
+		ThrottleRules: throttleRules,
+
+		LatencyProfiles: latencyProfiles,
+
+		Prewarm:            prewarm,
+		PrewarmConnections: *prewarmConnections,
+
+		DNSResolvers: dnsResolvers,
+		DNSFallback:  *dnsFallback,
+
+		IPFamilyRules:      ipFamilyRules,
+		HappyEyeballsDelay: *happyEyeballsDelay,
+
+		SourceRules: sourceRules,
+
+		SSHJumps:          sshJumps,
+		SSHKeyFile:        *sshKey,
+		SSHKnownHostsFile: *sshKnownHosts,
+
+		Sinks:             sinks,
+		SinkDeadLetterDir: *sinkDeadLetterDir,
+
+		StatsDAddr:   *statsdAddr,
+		StatsDPrefix: *statsdPrefix,
+		StatsDFlavor: *statsdFlavor,
+		StatsDTags:   statsdTags,
+
+		StrictParsing:     *strictParsing,
+		StrictHeaderBytes: *strictHeaderBytes,
+
+		HeaderCaseRoutes: headerCaseRoutes,
+
+		TracingEndpoint:    *otlpEndpoint,
+		TracingHeaders:     tracingHeaders,
+		TracingServiceName: *otlpServiceName,
+		TraceGenerate:      *traceGenerate,
+	}
+
+	var watched []string
+	if *watch {
+		if *configFile != "" {
+			watched = append(watched, *configFile)
+		}
+		for _, bodySchema := range bodySchemas {
+			watched = append(watched, bodySchema.File)
+		}
+	}
+	return config, watched, nil
+}
+
+func runServe() {
+	args := os.Args[1:]
+	config, watched, err := serveConfig(args)
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	var logOutput io.Writer = os.Stderr
+	if config.LogFile != "" {
+		logFile, err := proxy.OpenRotatingFile(config.LogFile, config.LogRotation)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer logFile.Close()
+		logOutput = logFile
+	}
+	var syslog *proxy.SyslogWriter
+	if config.LogSyslog != "" {
+		facility, err := proxy.ParseSyslogFacility(config.LogSyslogFacility)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if syslog, err = proxy.OpenSyslog(config.LogSyslog, facility, config.LogSyslogTag); err != nil {
+			log.Fatal(err)
+		}
+		defer syslog.Close()
+		if config.LogFile == "" {
+			logOutput = nil
+		}
+	}
+	if err := proxy.SetupLoggingWithSyslog(logOutput, syslog, config.LogLevel, config.LogFormat); err != nil {
+		log.Fatal(err)
+	}
+
+	// Reload the config file on SIGHUP or POST /config/reload, and with
+	// --watch keep watching the files the new configuration was read from
+	watcher := newFileWatcher(watched)
+	config.ConfigLoader = func() (*proxy.Config, error) {
+		next, files, err := serveConfig(args)
+		if err == nil && len(watched) > 0 {
+			watcher.setFiles(files)
+		}
+		return next, err
 	}
 
 	// Create and start proxy server
 	proxyServer := proxy.New(config)
 
-	// Handle graceful shutdown
+	// Reload when a watched file changes
+	if len(watched) > 0 {
+		stopWatching := make(chan struct{})
+		defer close(stopWatching)
+		go watcher.run(stopWatching, func(changed []string) {
+			slog.Info("Reloading config", "changed", changed)
+			if _, err := proxyServer.ReloadConfig(); err != nil {
+				slog.Error("Error reloading config, keeping the current one", "error", err)
+			}
+		})
+		slog.Info("Watching for changes", "files", watched)
+	}
+
+	// Handle graceful shutdown and reloads
 	sigChan := make(chan os.Signal, 1)
-	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
 
 	// Add debug logging for when we're about to start
-	if *logLevel == "debug" {
-		log.Printf("Starting proxy server on port %d", config.Port)
-		if config.AdminPort > 0 {
-			log.Printf("Admin endpoints will be available on port %d", config.AdminPort)
-			log.Printf("Request history size: %d", config.HistorySize)
-		}
-		if config.Dashboard {
-			log.Printf("Dashboard will be available on port %d", config.DashboardPort)
-			log.Printf("Dashboard directory: %s", config.DashboardDir)
-		}
+	slog.Debug("Starting proxy server", "port", config.Port)
+	if config.AdminPort > 0 {
+		slog.Debug("Admin endpoints will be available", "port", config.AdminPort, "history_size", config.HistorySize)
+	}
+	if config.Dashboard {
+		slog.Debug("Dashboard will be available", "port", config.DashboardPort, "dir", config.DashboardDir)
 	}
 
 	go func() {
-		if err := proxyServer.Start(); err != nil {
-			log.Fatalf("Failed to start proxy server: %v", err)
+		if err := proxyServer.Start(); err != nil && err != http.ErrServerClosed {
+			slog.Error("Failed to start proxy server", "error", err)
+			os.Exit(1)
 		}
 	}()
 
-	log.Printf("Proxy server started on port %d", config.Port)
+	slog.Info("Proxy server started", "port", config.Port)
 	if config.AdminPort > 0 {
-		log.Printf("Admin server started on port %d (health: /healthz, metrics: /metrics, history: /requests)", config.AdminPort)
+		slog.Info("Admin server started (health: /healthz, metrics: /metrics, history: /requests)", "port", config.AdminPort)
 	}
 	if config.Dashboard {
-		log.Printf("Dashboard server started on port %d", config.DashboardPort)
+		slog.Info("Dashboard server started", "port", config.DashboardPort)
 	}
 
-	// Wait for shutdown signal
-	<-sigChan
-	log.Println("Shutting down proxy server...")
+	// Wait for shutdown signal, reloading the config on SIGHUP
+	for sig := range sigChan {
+		if sig != syscall.SIGHUP {
+			break
+		}
+		if _, err := proxyServer.ReloadConfig(); err != nil {
+			slog.Error("Error reloading config, keeping the current one", "error", err)
+		}
+	}
+	slog.Info("Shutting down proxy server")
 
 	if err := proxyServer.Stop(); err != nil {
-		log.Printf("Error stopping proxy server: %v", err)
+		slog.Error("Error stopping proxy server", "error", err)
 	}
 }
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/biancarosa/netkit/internal/mock"
+)
+
+// runMock serves example responses from an OpenAPI spec, for developing
+// against APIs that do not exist yet
+func runMock() error {
+	specPath := flag.String("openapi", "", "OpenAPI 3 or Swagger 2 spec in YAML or JSON (required)")
+	port := flag.Int("port", 4010, "Port to listen on")
+	selection := flag.String("select", mock.SelectFirst, "Response selection: first (lowest 2xx, first example) or random")
+	latency := flag.String("latency", "", "Delay every response, e.g. 100ms or a random 50ms-200ms")
+	behaviorsPath := flag.String("behaviors", "", "YAML or JSON file of stateful resources and response sequences")
+	watch := flag.Bool("watch", false, "Reload the spec and behaviors when they change, keeping the current ones if the new ones are invalid")
+	flag.Parse()
+
+	if *specPath == "" {
+		return fmt.Errorf("usage: netkit mock --openapi spec.yaml [--port 4010]")
+	}
+	if err := mock.ValidateSelection(*selection); err != nil {
+		return fmt.Errorf("invalid --select: %v", err)
+	}
+	latencyMin, latencyMax, err := mock.ParseLatency(*latency)
+	if err != nil {
+		return err
+	}
+
+	// load reads the spec and behaviors into a new mock server
+	load := func() (*mock.Spec, http.Handler, error) {
+		spec, err := mock.LoadSpec(*specPath)
+		if err != nil {
+			return nil, nil, err
+		}
+		var behaviors *mock.Behaviors
+		if *behaviorsPath != "" {
+			if behaviors, err = mock.LoadBehaviors(*behaviorsPath); err != nil {
+				return nil, nil, err
+			}
+		}
+		return spec, mock.NewServer(spec, mock.Options{
+			Selection:  *selection,
+			LatencyMin: latencyMin,
+			LatencyMax: latencyMax,
+			Behaviors:  behaviors,
+		}), nil
+	}
+	spec, initial, err := load()
+	if err != nil {
+		return err
+	}
+	var handler atomic.Pointer[http.Handler]
+	handler.Store(&initial)
+
+	server := &http.Server{
+		Addr: fmt.Sprintf(":%d", *port),
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			slog.Info("Mock request", "method", r.Method, "uri", r.URL.RequestURI())
+			(*handler.Load()).ServeHTTP(w, r)
+		}),
+	}
+
+	// Swap in a new mock server when the spec or behaviors change
+	if *watch {
+		watched := []string{*specPath}
+		if *behaviorsPath != "" {
+			watched = append(watched, *behaviorsPath)
+		}
+		stopWatching := make(chan struct{})
+		defer close(stopWatching)
+		go newFileWatcher(watched).run(stopWatching, func(changed []string) {
+			spec, next, err := load()
+			if err != nil {
+				slog.Error("Error reloading mock, keeping the current one", "changed", changed, "error", err)
+				return
+			}
+			handler.Store(&next)
+			slog.Info("Reloaded mock, resources and sequences reset", "changed", changed, "operations", len(spec.Operations))
+		})
+		slog.Info("Watching for changes", "files", watched)
+	}
+
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		<-sigChan
+		slog.Info("Shutting down mock server")
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := server.Shutdown(ctx); err != nil {
+			slog.Error("Error stopping mock server", "error", err)
+		}
+	}()
+
+	title := spec.Title
+	if title == "" {
+		title = *specPath
+	}
+	slog.Info("Mocking API", "title", title, "operations", len(spec.Operations), "port", *port)
+	if err := server.ListenAndServe(); err != http.ErrServerClosed {
+		return err
+	}
+	return nil
+}
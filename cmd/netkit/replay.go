@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/url"
+	"os"
+	"os/signal"
+	"sort"
+	"syscall"
+	"time"
+
+	"github.com/biancarosa/netkit/internal/proxy"
+)
+
+// runReplay sends exported history records again, e.g. against staging
+func runReplay() error {
+	from := flag.String("from", "", "Exported records to replay: NDJSON, a GET /requests response, a capture export, a JSON array of records, or a mitmproxy flow file (- for stdin)")
+	target := flag.String("target", "", "Send requests to this scheme and host instead of their recorded ones, e.g. https://staging.example.com")
+	pacing := flag.String("pacing", proxy.PacingNone, "Pacing: none (back to back), global (original gaps), or session (each client's think time)")
+	speed := flag.Float64("speed", 1, "Divide recorded gaps by this factor, e.g. 2 replays twice as fast")
+	maxGap := flag.Duration("max-gap", 0, "Cap waits between requests, e.g. 30s (default: no cap)")
+	sessionKey := flag.String("session-key", "", "With --pacing session, group requests by this header or cookie:NAME (default: Authorization, Cookie, then client address)")
+	verbose := flag.Bool("verbose", false, "Print every replayed request")
+	flag.Parse()
+
+	if *from == "" {
+		return fmt.Errorf("usage: netkit replay --from history.ndjson [--target https://staging.example.com] [--pacing session]")
+	}
+	if err := proxy.ValidatePacing(*pacing); err != nil {
+		return fmt.Errorf("invalid --pacing: %v", err)
+	}
+	if *speed <= 0 {
+		return fmt.Errorf("invalid --speed: must be above 0")
+	}
+	var targetURL *url.URL
+	if *target != "" {
+		parsed, err := url.Parse(*target)
+		if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+			return fmt.Errorf("invalid --target: expected an http or https URL, got %q", *target)
+		}
+		targetURL = parsed
+	}
+
+	var input io.Reader = os.Stdin
+	if *from != "-" {
+		file, err := os.Open(*from)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			if closeErr := file.Close(); closeErr != nil {
+				slog.Warn("Error closing file", "file", *from, "error", closeErr)
+			}
+		}()
+		input = file
+	}
+	records, err := proxy.ReadRecords(input)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %v", *from, err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	options := proxy.ReplayOptions{
+		Target:     targetURL,
+		Pacing:     *pacing,
+		Speed:      *speed,
+		MaxGap:     *maxGap,
+		SessionKey: *sessionKey,
+	}
+	if *verbose {
+		options.OnResult = func(result proxy.ReplayResult) {
+			outcome := fmt.Sprint(result.Status)
+			if result.Error != "" {
+				outcome = result.Error
+			}
+			fmt.Printf("session %d  %s %s -> %s (%s)\n", result.Session, result.Method, result.URL, outcome, result.Duration.Round(time.Millisecond))
+		}
+	}
+
+	summary := proxy.ReplayRecords(ctx, records, options)
+	fmt.Printf("Replayed %d requests in %d sessions over %s (%d failed, %d skipped)\n",
+		summary.Sent, summary.Sessions, summary.Duration.Round(time.Millisecond), summary.Failed, summary.Skipped)
+	statuses := make([]int, 0, len(summary.Statuses))
+	for status := range summary.Statuses {
+		statuses = append(statuses, status)
+	}
+	sort.Ints(statuses)
+	for _, status := range statuses {
+		fmt.Printf("  %d: %d\n", status, summary.Statuses[status])
+	}
+	if ctx.Err() != nil {
+		return fmt.Errorf("replay interrupted")
+	}
+	return nil
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+
+	"github.com/biancarosa/netkit/internal/proxy"
+)
+
+// runReport renders a report from exported history, without a running proxy
+func runReport() error {
+	from := flag.String("from", "", "Exported records to report on: NDJSON, a GET /requests response, a capture export, a JSON array of records, or a mitmproxy flow file (- for stdin)")
+	out := flag.String("out", "", "File the report is written to (default: stdout)")
+	format := flag.String("format", proxy.ReportHTML, "Report format: html, markdown, or json")
+	title := flag.String("title", "", "Report title (default: \"Netkit traffic report\")")
+	flag.Parse()
+
+	if *from == "" {
+		return fmt.Errorf("usage: netkit report --from history.ndjson [--out report.html]")
+	}
+	if err := proxy.ValidateReportFormat(*format); err != nil {
+		return err
+	}
+
+	var input io.Reader = os.Stdin
+	if *from != "-" {
+		file, err := os.Open(*from)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			if closeErr := file.Close(); closeErr != nil {
+				slog.Warn("Error closing file", "file", *from, "error", closeErr)
+			}
+		}()
+		input = file
+	}
+
+	records, err := proxy.ReadRecords(input)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %v", *from, err)
+	}
+	report := proxy.BuildRecordsReport(records)
+	report.Title = *title
+	data, err := proxy.RenderReport(report, *format)
+	if err != nil {
+		return err
+	}
+
+	if *out == "" {
+		_, err := os.Stdout.Write(data)
+		return err
+	}
+	if err := os.WriteFile(*out, data, 0644); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "Wrote report on %d requests to %s\n", report.TotalRequests, *out)
+	return nil
+}
@@ -3,7 +3,7 @@ package main
 import (
 	"flag"
 	"fmt"
-	"log"
+	"log/slog"
 	"os"
 	"os/signal"
 	"sort"
@@ -12,6 +12,7 @@ import (
 
 	"github.com/biancarosa/netkit/internal/api"
 	"github.com/biancarosa/netkit/internal/proxy"
+	"github.com/biancarosa/netkit/internal/session"
 )
 
 func runRequest() error {
@@ -20,12 +21,26 @@ func runRequest() error {
 	url := flag.String("url", "", "Target URL (required)")
 	port := flag.Int("port", 8080, "Proxy port")
 	timeout := flag.Duration("timeout", 30*time.Second, "Request timeout")
+	sessionName := flag.String("session", "", "Named session whose cookies are sent and updated (persisted encrypted on disk)")
 	flag.Parse()
 
 	if *url == "" {
 		return fmt.Errorf("--url is required")
 	}
 
+	// Load the session's cookie jar before starting the proxy so bad sessions fail fast
+	var store *session.Store
+	var jar *session.Jar
+	if *sessionName != "" {
+		var err error
+		if store, err = session.DefaultStore(); err != nil {
+			return err
+		}
+		if jar, err = store.Load(*sessionName); err != nil {
+			return err
+		}
+	}
+
 	// Create proxy configuration
 	config := &proxy.Config{
 		Port:     *port,
@@ -42,7 +57,7 @@ func runRequest() error {
 	// Start proxy server in a goroutine
 	go func() {
 		if err := proxyServer.Start(); err != nil {
-			log.Printf("Proxy server error: %v", err)
+			slog.Error("Proxy server error", "error", err)
 		}
 	}()
 
@@ -57,6 +72,9 @@ func runRequest() error {
 		Headers: make(map[string]string),
 		Timeout: *timeout,
 	}
+	if jar != nil {
+		reqConfig.Jar = jar
+	}
 
 	// Add default headers
 	reqConfig.Headers["User-Agent"] = "netkit/1.0"
@@ -65,11 +83,17 @@ func runRequest() error {
 	resp, err := api.MakeRequest(proxyURL, reqConfig)
 	if err != nil {
 		if stopErr := proxyServer.Stop(); stopErr != nil {
-			log.Printf("Error stopping proxy server: %v", stopErr)
+			slog.Error("Error stopping proxy server", "error", stopErr)
 		}
 		return fmt.Errorf("request failed: %v", err)
 	}
 
+	if jar != nil {
+		if err := store.Save(*sessionName, jar); err != nil {
+			slog.Error("Error saving session", "session", *sessionName, "error", err)
+		}
+	}
+
 	// Print response
 	fmt.Printf("Status: %d\n", resp.StatusCode)
 
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/biancarosa/netkit/internal/proxy"
+	"gopkg.in/yaml.v3"
+)
+
+// runRules installs, lists, and removes the rule bundles of a running
+// `netkit serve`, and creates signed bundles to share
+func runRules() error {
+	adminURL := flag.String("admin-url", "http://localhost:8081", "Admin URL of the running proxy")
+	token := flag.String("token", os.Getenv("NETKIT_ADMIN_TOKEN"), "Admin API token (default: $NETKIT_ADMIN_TOKEN)")
+	checksum := flag.String("sha256", "", "Expected SHA-256 of the bundle, checked on top of its signature (with install)")
+	keyFile := flag.String("key", "", "Private key file written by keygen to sign the bundle with (with pack)")
+	flag.Parse()
+
+	args := flag.Args()
+	if len(args) == 0 {
+		return fmt.Errorf("usage: netkit rules list | install <url-or-path> | remove <name> | keygen <key-file> | --key <key-file> pack <bundle.yaml>")
+	}
+
+	client := &http.Client{Timeout: time.Minute}
+	endpoint := strings.TrimSuffix(*adminURL, "/") + "/rules"
+
+	switch args[0] {
+	case "list":
+		if len(args) != 1 {
+			return fmt.Errorf("usage: netkit rules list")
+		}
+		var result struct {
+			Bundles []proxy.InstalledRuleBundle `json:"bundles"`
+		}
+		if err := filtersRequest(client, *token, http.MethodGet, endpoint, nil, &result); err != nil {
+			return err
+		}
+		for _, bundle := range result.Bundles {
+			fmt.Printf("%s\t%s\tkey %s\t%s\n", bundle.Name, bundle.Version, bundle.SignedBy, bundle.Description)
+		}
+		return nil
+
+	case "install":
+		if len(args) != 2 {
+			return fmt.Errorf("usage: netkit rules [--sha256 <checksum>] install <url-or-path>, e.g. install https://example.com/staging-rewrites.json")
+		}
+		request := map[string]interface{}{"sha256": *checksum}
+		if strings.HasPrefix(args[1], "https://") || strings.HasPrefix(args[1], "http://") {
+			request["url"] = args[1]
+		} else {
+			data, err := os.ReadFile(args[1])
+			if err != nil {
+				return err
+			}
+			var signed proxy.SignedRuleBundle
+			if err := json.Unmarshal(data, &signed); err != nil {
+				return fmt.Errorf("invalid bundle %s: %v", args[1], err)
+			}
+			request["bundle"] = signed
+		}
+		body, err := json.Marshal(request)
+		if err != nil {
+			return err
+		}
+		var result struct {
+			Bundle proxy.InstalledRuleBundle `json:"bundle"`
+			Config *proxy.ConfigDiff         `json:"config"`
+		}
+		if err := filtersRequest(client, *token, http.MethodPost, endpoint+"/import", body, &result); err != nil {
+			return err
+		}
+		fmt.Printf("Installed rule bundle %q %s (sha256 %s)\n", result.Bundle.Name, result.Bundle.Version, result.Bundle.SHA256)
+		if result.Bundle.BehaviorsFile != "" {
+			fmt.Printf("Mock behaviors: netkit mock --behaviors %s\n", result.Bundle.BehaviorsFile)
+		}
+		if result.Config != nil && len(result.Config.RestartRequired) > 0 {
+			fmt.Printf("Restart the proxy to apply: %s\n", strings.Join(result.Config.RestartRequired, ", "))
+		}
+		return nil
+
+	case "remove":
+		if len(args) != 2 {
+			return fmt.Errorf("usage: netkit rules remove <name>")
+		}
+		if err := filtersRequest(client, *token, http.MethodDelete, endpoint+"?name="+url.QueryEscape(args[1]), nil, nil); err != nil {
+			return err
+		}
+		fmt.Printf("Removed rule bundle %q\n", args[1])
+		return nil
+
+	case "keygen":
+		if len(args) != 2 {
+			return fmt.Errorf("usage: netkit rules keygen <key-file>")
+		}
+		public, private, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return err
+		}
+		if err := os.WriteFile(args[1], []byte(base64.StdEncoding.EncodeToString(private)+"\n"), 0600); err != nil {
+			return err
+		}
+		fmt.Printf("Wrote private key to %s (key %s)\n", args[1], proxy.RulesKeyID(public))
+		fmt.Printf("Trust it with: netkit serve --rules-key %s\n", base64.StdEncoding.EncodeToString(public))
+		return nil
+
+	case "pack":
+		if len(args) != 2 || *keyFile == "" {
+			return fmt.Errorf("usage: netkit rules --key <key-file> pack <bundle.yaml>")
+		}
+		key, err := readRulesKey(*keyFile)
+		if err != nil {
+			return err
+		}
+		bundle, err := readRuleBundle(args[1])
+		if err != nil {
+			return err
+		}
+		signed, err := proxy.SignRuleBundle(bundle, key)
+		if err != nil {
+			return err
+		}
+		data, err := json.MarshalIndent(signed, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(data))
+		return nil
+
+	default:
+		return fmt.Errorf("unknown rules command %q (expected list, install, remove, keygen, or pack)", args[0])
+	}
+}
+
+// readRulesKey reads a private key file written by netkit rules keygen
+func readRulesKey(path string) (ed25519.PrivateKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil || len(key) != ed25519.PrivateKeySize {
+		return nil, fmt.Errorf("invalid key file %s: expected a key written by netkit rules keygen", path)
+	}
+	return ed25519.PrivateKey(key), nil
+}
+
+// readRuleBundle reads a bundle from YAML (or JSON) with the name, version,
+// and description, settings keyed by serve flag name like a config file, and
+// mock behaviors
+func readRuleBundle(path string) (proxy.RuleBundle, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return proxy.RuleBundle{}, err
+	}
+	var source struct {
+		Name        string                 `yaml:"name"`
+		Version     string                 `yaml:"version"`
+		Description string                 `yaml:"description"`
+		Settings    map[string]interface{} `yaml:"settings"`
+		Behaviors   interface{}            `yaml:"behaviors"`
+	}
+	if err := yaml.Unmarshal(data, &source); err != nil {
+		return proxy.RuleBundle{}, fmt.Errorf("failed to parse %s: %v", path, err)
+	}
+
+	bundle := proxy.RuleBundle{Name: source.Name, Version: source.Version, Description: source.Description}
+	keys := make([]string, 0, len(source.Settings))
+	for key := range source.Settings {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		values, err := configValues(source.Settings[key])
+		if err != nil {
+			return proxy.RuleBundle{}, fmt.Errorf("invalid %s in %s: %v", key, path, err)
+		}
+		if bundle.Settings == nil {
+			bundle.Settings = make(map[string][]string)
+		}
+		bundle.Settings[strings.ReplaceAll(key, "_", "-")] = values
+	}
+	if source.Behaviors != nil {
+		if bundle.Behaviors, err = json.Marshal(source.Behaviors); err != nil {
+			return proxy.RuleBundle{}, fmt.Errorf("invalid behaviors in %s: %v", path, err)
+		}
+	}
+	return bundle, nil
+}
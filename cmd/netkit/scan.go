@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+
+	"github.com/biancarosa/netkit/internal/proxy"
+)
+
+// runScan probes a target for security issues. Only scan systems you are
+// allowed to test.
+func runScan() error {
+	if len(os.Args) < 2 || os.Args[1] != "smuggling" {
+		return fmt.Errorf("usage: netkit scan smuggling --target URL [--via host:port] [--techniques cl.te,te.cl] [--format json]")
+	}
+	flags := flag.NewFlagSet("scan smuggling", flag.ExitOnError)
+	target := flags.String("target", "", "http or https URL to probe with POST requests, e.g. https://staging.example.com/login")
+	via := flags.String("via", "", "Send the probes through the HTTP proxy at host:port, e.g. a running netkit, to test it as the front end (http targets only)")
+	techniques := flags.String("techniques", strings.Join(proxy.SmugglingTechniques, ","), "Comma-separated techniques to probe: cl.te, te.cl; te.cl alone can disturb other clients of a vulnerable target")
+	timeout := flags.Duration("timeout", proxy.DefaultSmugglingTimeout, "How long a probe waits for a response; keep it well above the target's normal response time")
+	attempts := flags.Int("attempts", proxy.DefaultSmugglingAttempts, "Times a probe must time out before it is reported")
+	insecure := flags.Bool("insecure", false, "Skip verifying the target's TLS certificate")
+	raw := flags.Bool("raw", false, "Print the bytes sent and received for suspicious and inconclusive probes")
+	format := flags.String("format", "text", "Output format: text or json")
+	if err := flags.Parse(os.Args[2:]); err != nil {
+		return err
+	}
+
+	if *target == "" {
+		return fmt.Errorf("--target is required")
+	}
+	if *via != "" {
+		if _, _, err := net.SplitHostPort(*via); err != nil {
+			return fmt.Errorf("invalid --via: %v", err)
+		}
+	}
+	if *timeout <= 0 {
+		return fmt.Errorf("invalid --timeout: must be above 0")
+	}
+	if *attempts <= 0 {
+		return fmt.Errorf("invalid --attempts: must be at least 1")
+	}
+	if *format != "text" && *format != "json" {
+		return fmt.Errorf("invalid --format: expected text or json")
+	}
+	options := proxy.SmugglingOptions{Target: *target, Via: *via, Timeout: *timeout, Attempts: *attempts, Insecure: *insecure}
+	for _, technique := range strings.Split(*techniques, ",") {
+		if technique = strings.TrimSpace(technique); technique == "" {
+			continue
+		}
+		if err := proxy.ValidateSmugglingTechnique(technique); err != nil {
+			return fmt.Errorf("invalid --techniques: %v", err)
+		}
+		options.Techniques = append(options.Techniques, technique)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	report, err := proxy.RunSmugglingScan(ctx, options)
+	if err != nil {
+		return fmt.Errorf("scan failed: %v", err)
+	}
+
+	if *format == "json" {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(report); err != nil {
+			return err
+		}
+	} else if err := printSmugglingReport(report, *raw); err != nil {
+		return err
+	}
+	if report.Findings > 0 {
+		return fmt.Errorf("%d probes suggest %s is open to request smuggling", report.Findings, report.Target)
+	}
+	return nil
+}
+
+// printSmugglingReport prints a scan as a table of probes
+func printSmugglingReport(report *proxy.SmugglingReport, raw bool) error {
+	via := ""
+	if report.Via != "" {
+		via = " via " + report.Via
+	}
+	fmt.Printf("Scanned %s%s: baseline %d in %dms, probes time out after %dms\n\n", report.Target, via, report.BaselineStatus, report.BaselineMs, report.TimeoutMs)
+	writer := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(writer, "TECHNIQUE\tVARIANT\tOUTCOME\tSTATUS\tTIME\tNOTE")
+	for _, probe := range report.Probes {
+		outcome := probe.Outcome
+		if probe.Suspicious {
+			outcome += " (suspicious)"
+		}
+		status := "-"
+		if probe.Status > 0 {
+			status = fmt.Sprint(probe.Status)
+		}
+		fmt.Fprintf(writer, "%s\t%s\t%s\t%s\t%dms\t%s\n", probe.Technique, probe.Variant, outcome, status, probe.DurationMs, probe.Note)
+	}
+	if err := writer.Flush(); err != nil {
+		return err
+	}
+
+	if raw {
+		for _, probe := range report.Probes {
+			if !probe.Suspicious && probe.Outcome != proxy.SmugglingInconclusive {
+				continue
+			}
+			fmt.Printf("\n%s %s sent:\n%q\nreceived:\n%q\n", probe.Technique, probe.Variant, probe.Sent, probe.Received)
+		}
+	}
+	fmt.Printf("\n%d suspicious of %d probes\n", report.Findings, len(report.Probes))
+	return nil
+}
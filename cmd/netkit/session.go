@@ -0,0 +1,93 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+
+	"github.com/biancarosa/netkit/internal/session"
+)
+
+// runSession manages the named cookie sessions used by `netkit request --session`
+func runSession() error {
+	flag.Parse()
+
+	store, err := session.DefaultStore()
+	if err != nil {
+		return err
+	}
+
+	args := flag.Args()
+	if len(args) == 0 {
+		return fmt.Errorf("usage: netkit session list | show <name> | clear <name> | clear --all")
+	}
+
+	switch args[0] {
+	case "list":
+		names, err := store.List()
+		if err != nil {
+			return err
+		}
+		for _, name := range names {
+			fmt.Println(name)
+		}
+		return nil
+
+	case "show":
+		if len(args) != 2 {
+			return fmt.Errorf("usage: netkit session show <name>")
+		}
+		jar, err := store.Load(args[1])
+		if err != nil {
+			return err
+		}
+		for _, c := range jar.All() {
+			expires := "session"
+			if !c.Expires.IsZero() {
+				expires = c.Expires.Format("2006-01-02 15:04:05 MST")
+			}
+			fmt.Printf("%s\t%s%s\t%s\t%s\n", c.Name, c.Domain, c.Path, expires, maskValue(c.Value))
+		}
+		return nil
+
+	case "clear":
+		clearFlags := flag.NewFlagSet("session clear", flag.ContinueOnError)
+		all := clearFlags.Bool("all", false, "Clear every saved session")
+		if err := clearFlags.Parse(args[1:]); err != nil {
+			return err
+		}
+		args = append(args[:1], clearFlags.Args()...)
+
+		if *all {
+			names, err := store.List()
+			if err != nil {
+				return err
+			}
+			for _, name := range names {
+				if err := store.Clear(name); err != nil {
+					return err
+				}
+			}
+			fmt.Printf("Cleared %d sessions\n", len(names))
+			return nil
+		}
+		if len(args) != 2 {
+			return fmt.Errorf("usage: netkit session clear <name> | clear --all")
+		}
+		if err := store.Clear(args[1]); err != nil {
+			return err
+		}
+		fmt.Printf("Cleared session %q\n", args[1])
+		return nil
+
+	default:
+		return fmt.Errorf("unknown session command %q (expected list, show, or clear)", args[0])
+	}
+}
+
+// maskValue hides all but the start of a cookie value when printing sessions
+func maskValue(value string) string {
+	if len(value) <= 4 {
+		return "****"
+	}
+	return value[:4] + "****"
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/biancarosa/netkit/internal/proxy"
+)
+
+// runSinks lists, toggles, and replays the history sinks of a running `netkit serve`
+func runSinks() error {
+	adminURL := flag.String("admin-url", "http://localhost:8081", "Admin URL of the running proxy")
+	token := flag.String("token", os.Getenv("NETKIT_ADMIN_TOKEN"), "Admin API token (default: $NETKIT_ADMIN_TOKEN)")
+	flag.Parse()
+
+	args := flag.Args()
+	if len(args) == 0 {
+		return fmt.Errorf("usage: netkit sinks list | enable <name> | disable <name> | replay <name>")
+	}
+
+	client := &http.Client{Timeout: 10 * time.Second}
+	endpoint := strings.TrimSuffix(*adminURL, "/") + "/sinks"
+
+	switch args[0] {
+	case "list":
+		if len(args) != 1 {
+			return fmt.Errorf("usage: netkit sinks list")
+		}
+		var statuses []proxy.SinkStatus
+		if err := filtersRequest(client, *token, http.MethodGet, endpoint, nil, &statuses); err != nil {
+			return err
+		}
+		for _, status := range statuses {
+			state := "enabled"
+			if !status.Enabled {
+				state = "disabled"
+			}
+			fmt.Printf("%s\t%s:%s\t%s\tdelivered=%d failed=%d dropped=%d dead_letters=%d\t%s\n", status.Name, status.Kind, status.Target, state,
+				status.Delivered, status.Failed, status.Dropped, status.DeadLetters, status.LastError)
+		}
+		return nil
+
+	case "enable", "disable":
+		if len(args) != 2 {
+			return fmt.Errorf("usage: netkit sinks %s <name>", args[0])
+		}
+		var status proxy.SinkStatus
+		if err := filtersRequest(client, *token, http.MethodPost, endpoint+"/"+url.PathEscape(args[1])+"/"+args[0], nil, &status); err != nil {
+			return err
+		}
+		fmt.Printf("Sink %q is %sd\n", status.Name, args[0])
+		return nil
+
+	case "replay":
+		if len(args) != 2 {
+			return fmt.Errorf("usage: netkit sinks replay <name>")
+		}
+		// Replays deliver every spooled batch before answering
+		client.Timeout = 10 * time.Minute
+		var replay proxy.SinkReplay
+		if err := filtersRequest(client, *token, http.MethodPost, endpoint+"/"+url.PathEscape(args[1])+"/replay", nil, &replay); err != nil {
+			return err
+		}
+		fmt.Printf("Replayed %d records in %d batches to sink %q\n", replay.Records, replay.Batches, args[1])
+		return nil
+
+	default:
+		return fmt.Errorf("unknown sinks command %q (expected list, enable, disable, or replay)", args[0])
+	}
+}
@@ -0,0 +1,7 @@
+//go:build sqlite
+
+package main
+
+// Register the SQLite driver used by --history-backend=sqlite. It needs cgo,
+// so it is only built in with -tags sqlite.
+import _ "github.com/mattn/go-sqlite3"
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/biancarosa/netkit/internal/proxy"
+	"github.com/biancarosa/netkit/internal/tunnel"
+)
+
+// runExpose exposes a local service through a tunnel server. Public traffic is
+// routed through a local proxy so every request lands in history.
+func runExpose() error {
+	port := flag.Int("port", 0, "Local port of the service to expose (required)")
+	server := flag.String("server", "", "Tunnel server control address, e.g. tunnel.example.com:7000 (required)")
+	token := flag.String("token", os.Getenv("NETKIT_TUNNEL_TOKEN"), "Tunnel server token (default: $NETKIT_TUNNEL_TOKEN)")
+	poolSize := flag.Int("connections", 4, "Idle tunnel connections kept open (maximum concurrent public connections)")
+	proxyPort := flag.Int("proxy-port", 8080, "Local proxy port, which also serves the exposed service")
+	adminPort := flag.Int("admin-port", 8081, "Admin port for health checks, metrics, and history (0 to disable)")
+	historySize := flag.Int("history-size", 1000, "Maximum number of requests to keep in history")
+	logLevel := flag.String("log-level", "info", "Logging level (debug, info, warn, error)")
+	logFormat := flag.String("log-format", proxy.LogFormatText, "Log format: text or json")
+	flag.Parse()
+
+	if err := proxy.SetupLogging(os.Stderr, *logLevel, *logFormat); err != nil {
+		return fmt.Errorf("invalid --log-level or --log-format: %v", err)
+	}
+
+	if *port <= 0 {
+		return fmt.Errorf("--port is required")
+	}
+	if *server == "" {
+		return fmt.Errorf("--server is required")
+	}
+
+	route, err := proxy.ParseReverseRoute(fmt.Sprintf("*=http://localhost:%d,forwarded", *port))
+	if err != nil {
+		return err
+	}
+
+	client, err := tunnel.Dial(*server, *token, *poolSize)
+	if err != nil {
+		return fmt.Errorf("failed to connect to tunnel server: %v", err)
+	}
+
+	config := &proxy.Config{
+		Port:          *proxyPort,
+		AdminPort:     *adminPort,
+		HistorySize:   *historySize,
+		LogLevel:      *logLevel,
+		LogFormat:     *logFormat,
+		ReverseRoutes: []proxy.ReverseRoute{route},
+	}
+	proxyServer := proxy.New(config)
+
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+
+	go func() {
+		if err := proxyServer.Start(); err != nil && err != http.ErrServerClosed {
+			slog.Error("Failed to start proxy server", "error", err)
+			os.Exit(1)
+		}
+	}()
+	go func() {
+		if err := proxyServer.Serve(client); err != nil && err != http.ErrServerClosed {
+			slog.Error("Tunnel serving error", "error", err)
+		}
+	}()
+
+	slog.Info("Exposing local port", "port", *port, "url", client.PublicURL())
+	if *adminPort > 0 {
+		slog.Info("Request history available", "url", fmt.Sprintf("http://localhost:%d/requests", *adminPort))
+	}
+
+	<-sigChan
+	slog.Info("Closing tunnel")
+
+	if err := client.Close(); err != nil {
+		slog.Warn("Error closing tunnel", "error", err)
+	}
+	if err := proxyServer.Stop(); err != nil {
+		slog.Error("Error stopping proxy server", "error", err)
+	}
+	return nil
+}
+
+// runTunnelServer runs the public side of `netkit expose`
+func runTunnelServer() error {
+	port := flag.Int("port", 8000, "Public port that exposed traffic arrives on")
+	controlPort := flag.Int("control-port", 7000, "Port tunnel clients connect to")
+	token := flag.String("token", os.Getenv("NETKIT_TUNNEL_TOKEN"), "Token clients must present (default: $NETKIT_TUNNEL_TOKEN)")
+	publicURL := flag.String("public-url", "", "Public URL reported to clients, e.g. https://tunnel.example.com (default: http://<host>:<port>)")
+	flag.Parse()
+
+	if *token == "" {
+		slog.Warn("No --token set, any client can register a tunnel")
+	}
+
+	server := tunnel.NewServer(*token, *publicURL)
+
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		<-sigChan
+		slog.Info("Shutting down tunnel server")
+		if err := server.Close(); err != nil {
+			slog.Error("Error stopping tunnel server", "error", err)
+		}
+	}()
+
+	slog.Info("Tunnel server listening", "port", *port, "control_port", *controlPort)
+	return server.ListenAndServe(fmt.Sprintf(":%d", *port), fmt.Sprintf(":%d", *controlPort))
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// watchInterval is how often watched files are checked for changes
+const watchInterval = 500 * time.Millisecond
+
+// fileState is what a watched file looked like when last checked
+type fileState struct {
+	exists  bool
+	size    int64
+	modTime time.Time
+}
+
+// fileWatcher polls files for changes to their size or modification time,
+// which works the same on every platform and for files replaced by editors
+type fileWatcher struct {
+	mutex sync.Mutex
+	files map[string]fileState
+}
+
+func newFileWatcher(paths []string) *fileWatcher {
+	fw := &fileWatcher{files: make(map[string]fileState)}
+	fw.setFiles(paths)
+	return fw
+}
+
+// setFiles changes the watched files, keeping what is known about files
+// that were already watched so their pending changes are not lost
+func (fw *fileWatcher) setFiles(paths []string) {
+	fw.mutex.Lock()
+	defer fw.mutex.Unlock()
+
+	files := make(map[string]fileState, len(paths))
+	for _, path := range paths {
+		if state, ok := fw.files[path]; ok {
+			files[path] = state
+		} else {
+			files[path] = statFile(path)
+		}
+	}
+	fw.files = files
+}
+
+// changed returns the files that changed since the last check, sorted
+func (fw *fileWatcher) changed() []string {
+	fw.mutex.Lock()
+	defer fw.mutex.Unlock()
+
+	var changed []string
+	for path, previous := range fw.files {
+		if current := statFile(path); current != previous {
+			fw.files[path] = current
+			changed = append(changed, path)
+		}
+	}
+	sort.Strings(changed)
+	return changed
+}
+
+// run calls onChange with the files that changed until stop is closed.
+// Changes are reported once files stop changing for an interval, so a file
+// still being written is not read half-way.
+func (fw *fileWatcher) run(stop <-chan struct{}, onChange func(changed []string)) {
+	ticker := time.NewTicker(watchInterval)
+	defer ticker.Stop()
+
+	pending := make(map[string]bool)
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+
+		changed := fw.changed()
+		for _, path := range changed {
+			pending[path] = true
+		}
+		if len(changed) > 0 || len(pending) == 0 {
+			continue
+		}
+
+		paths := make([]string, 0, len(pending))
+		for path := range pending {
+			paths = append(paths, path)
+		}
+		sort.Strings(paths)
+		clear(pending)
+		onChange(paths)
+	}
+}
+
+func statFile(path string) fileState {
+	info, err := os.Stat(path)
+	if err != nil {
+		return fileState{}
+	}
+	return fileState{exists: true, size: info.Size(), modTime: info.ModTime()}
+}
@@ -0,0 +1,86 @@
+//go:build unit
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFileWatcherChanged(t *testing.T) {
+	dir := t.TempDir()
+	config, rules := filepath.Join(dir, "netkit.yaml"), filepath.Join(dir, "rules.yaml")
+	require.NoError(t, os.WriteFile(config, []byte("admin-port: 9000\n"), 0o600))
+	watcher := newFileWatcher([]string{config, rules})
+	assert.Empty(t, watcher.changed())
+
+	tests := []struct {
+		name   string
+		change func()
+		want   []string
+	}{
+		{"resized", func() { require.NoError(t, os.WriteFile(config, []byte("admin-port: 10000\n"), 0o600)) }, []string{config}},
+		{"touched", func() {
+			later := time.Now().Add(time.Hour)
+			require.NoError(t, os.Chtimes(config, later, later))
+		}, []string{config}},
+		{"created", func() { require.NoError(t, os.WriteFile(rules, nil, 0o600)) }, []string{rules}},
+		{"both", func() {
+			require.NoError(t, os.Remove(config))
+			require.NoError(t, os.WriteFile(rules, []byte("x"), 0o600))
+		}, []string{config, rules}},
+		{"unchanged", func() {}, nil},
+	}
+	for _, tt := range tests {
+		tt.change()
+		assert.Equal(t, tt.want, watcher.changed(), tt.name)
+	}
+}
+
+func TestFileWatcherSetFiles(t *testing.T) {
+	dir := t.TempDir()
+	config, rules := filepath.Join(dir, "netkit.yaml"), filepath.Join(dir, "rules.yaml")
+	require.NoError(t, os.WriteFile(config, []byte("a"), 0o600))
+	watcher := newFileWatcher([]string{config})
+
+	// Changes to files still watched are kept; new files start unchanged
+	require.NoError(t, os.WriteFile(config, []byte("ab"), 0o600))
+	require.NoError(t, os.WriteFile(rules, []byte("r"), 0o600))
+	watcher.setFiles([]string{config, rules})
+	assert.Equal(t, []string{config}, watcher.changed())
+
+	watcher.setFiles([]string{rules})
+	require.NoError(t, os.WriteFile(config, []byte("abc"), 0o600))
+	assert.Empty(t, watcher.changed(), "files no longer watched are not reported")
+}
+
+func TestFileWatcherRun(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "netkit.yaml")
+	require.NoError(t, os.WriteFile(path, []byte("a"), 0o600))
+	watcher := newFileWatcher([]string{path})
+
+	reports := make(chan []string, 1)
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		watcher.run(stop, func(changed []string) { reports <- changed })
+	}()
+
+	require.NoError(t, os.WriteFile(path, []byte("ab"), 0o600))
+	select {
+	case changed := <-reports:
+		assert.Equal(t, []string{path}, changed)
+	case <-time.After(10 * watchInterval):
+		t.Fatal("change was not reported")
+	}
+
+	close(stop)
+	<-done
+	assert.Empty(t, reports, "changes are reported once")
+}
@@ -15,6 +15,7 @@ type RequestConfig struct {
 	Headers map[string]string
 	Body    io.Reader
 	Timeout time.Duration
+	Jar     http.CookieJar // Optional cookie jar shared across requests in a session
 }
 
 // Response represents the API response
@@ -38,6 +39,7 @@ func MakeRequest(proxyURL string, config RequestConfig) (*Response, error) {
 			Proxy: http.ProxyURL(proxy),
 		},
 		Timeout: config.Timeout,
+		Jar:     config.Jar,
 	}
 
 	// Create request
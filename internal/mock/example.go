@@ -0,0 +1,212 @@
+package mock
+
+import (
+	"math"
+	"sort"
+	"strings"
+)
+
+// maxSchemaDepth stops example generation in recursive schemas
+const maxSchemaDepth = 8
+
+// exampleStrings are values for string formats, valid against the format
+var exampleStrings = map[string]string{
+	"date-time": "2024-01-01T12:00:00Z",
+	"date":      "2024-01-01",
+	"time":      "12:00:00",
+	"email":     "user@example.com",
+	"uuid":      "3fa85f64-5717-4562-b3fc-2c963f66afa6",
+	"uri":       "https://example.com",
+	"url":       "https://example.com",
+	"hostname":  "example.com",
+	"ipv4":      "192.0.2.1",
+	"ipv6":      "2001:db8::1",
+	"byte":      "ZXhhbXBsZQ==",
+	"password":  "********",
+}
+
+// Example builds a value that is valid against the schema, preferring the
+// examples, defaults, and enums the schema documents
+func (s *Spec) Example(schema map[string]interface{}) interface{} {
+	return s.example(schema, 0)
+}
+
+func (s *Spec) example(schema map[string]interface{}, depth int) interface{} {
+	if depth > maxSchemaDepth {
+		return nil
+	}
+	schema, _ = s.resolve(schema).(map[string]interface{})
+	if schema == nil {
+		return nil
+	}
+
+	for _, key := range []string{"example", "default", "const"} {
+		if value, ok := schema[key]; ok {
+			return value
+		}
+	}
+	if examples, ok := schema["examples"].([]interface{}); ok && len(examples) > 0 {
+		return examples[0]
+	}
+	if enum, ok := schema["enum"].([]interface{}); ok && len(enum) > 0 {
+		return enum[0]
+	}
+
+	if all, ok := schema["allOf"].([]interface{}); ok {
+		merged := map[string]interface{}{}
+		for _, part := range all {
+			part, _ := part.(map[string]interface{})
+			if object, ok := s.example(part, depth+1).(map[string]interface{}); ok {
+				for key, value := range object {
+					merged[key] = value
+				}
+			}
+		}
+		return merged
+	}
+	for _, key := range []string{"oneOf", "anyOf"} {
+		if options, ok := schema[key].([]interface{}); ok && len(options) > 0 {
+			option, _ := options[0].(map[string]interface{})
+			return s.example(option, depth+1)
+		}
+	}
+
+	switch schemaType(schema) {
+	case "object":
+		return s.objectExample(schema, depth)
+	case "array":
+		items, _ := schema["items"].(map[string]interface{})
+		count := max(1, int(number(schema["minItems"], 0)))
+		values := make([]interface{}, count)
+		for i := range values {
+			values[i] = s.example(items, depth+1)
+		}
+		return values
+	case "string":
+		return stringExample(schema)
+	case "integer":
+		return int64(numberExample(schema, true))
+	case "number":
+		return numberExample(schema, false)
+	case "boolean":
+		return true
+	default:
+		return nil
+	}
+}
+
+func (s *Spec) objectExample(schema map[string]interface{}, depth int) map[string]interface{} {
+	properties, _ := schema["properties"].(map[string]interface{})
+	names := make([]string, 0, len(properties))
+	for name := range properties {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	object := make(map[string]interface{}, len(names))
+	for _, name := range names {
+		property, _ := properties[name].(map[string]interface{})
+		// Recursive properties that run out of depth are left out, unless required
+		value := s.example(property, depth+1)
+		if value == nil && !isRequired(schema, name) {
+			continue
+		}
+		object[name] = value
+	}
+	return object
+}
+
+// schemaType returns the schema's type, inferring objects and arrays from
+// their keywords and taking the first non-null type of an OpenAPI 3.1 list
+func schemaType(schema map[string]interface{}) string {
+	switch t := schema["type"].(type) {
+	case string:
+		return t
+	case []interface{}:
+		for _, item := range t {
+			if name, ok := item.(string); ok && name != "null" {
+				return name
+			}
+		}
+	}
+	if schema["properties"] != nil {
+		return "object"
+	}
+	if schema["items"] != nil {
+		return "array"
+	}
+	return ""
+}
+
+func stringExample(schema map[string]interface{}) string {
+	format, _ := schema["format"].(string)
+	value, ok := exampleStrings[format]
+	if !ok {
+		value = "string"
+	}
+	if minLength := int(number(schema["minLength"], 0)); len(value) < minLength {
+		value += strings.Repeat("x", minLength-len(value))
+	}
+	if maxLength := int(number(schema["maxLength"], -1)); maxLength >= 0 && len(value) > maxLength {
+		value = value[:maxLength]
+	}
+	return value
+}
+
+// numberExample returns the smallest value the bounds allow, or zero when it fits
+func numberExample(schema map[string]interface{}, integer bool) float64 {
+	step := 1.0
+	if !integer {
+		step = 0.5
+	}
+	minimum := number(schema["minimum"], math.Inf(-1))
+	maximum := number(schema["maximum"], math.Inf(1))
+	// exclusiveMinimum is a flag in OpenAPI 3.0 and a bound in 3.1
+	if exclusive, ok := schema["exclusiveMinimum"].(bool); ok && exclusive {
+		minimum += step
+	} else if bound := number(schema["exclusiveMinimum"], math.Inf(-1)); !math.IsInf(bound, -1) {
+		minimum = bound + step
+	}
+	if exclusive, ok := schema["exclusiveMaximum"].(bool); ok && exclusive {
+		maximum -= step
+	} else if bound := number(schema["exclusiveMaximum"], math.Inf(1)); !math.IsInf(bound, 1) {
+		maximum = bound - step
+	}
+
+	value := 0.0
+	if value < minimum {
+		value = minimum
+	}
+	if value > maximum {
+		value = maximum
+	}
+	if integer {
+		value = math.Ceil(value)
+	}
+	return value
+}
+
+func isRequired(schema map[string]interface{}, name string) bool {
+	required, _ := schema["required"].([]interface{})
+	for _, item := range required {
+		if item == name {
+			return true
+		}
+	}
+	return false
+}
+
+// number reads a numeric schema keyword, which YAML may decode as an int or float
+func number(value interface{}, fallback float64) float64 {
+	switch v := value.(type) {
+	case int:
+		return float64(v)
+	case int64:
+		return float64(v)
+	case uint64:
+		return float64(v)
+	case float64:
+		return v
+	}
+	return fallback
+}
@@ -0,0 +1,350 @@
+package mock
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Spec is an OpenAPI 3 (or Swagger 2) document, reduced to the operations and
+// responses a mock server needs
+type Spec struct {
+	Title      string
+	BasePath   string // Path of the first server URL, e.g. /v1, accepted as a request prefix
+	Operations []*Operation
+
+	root map[string]interface{} // The whole document, for resolving $ref
+}
+
+// Operation is one method on one path template
+type Operation struct {
+	Method    string
+	Path      string // Template such as /orders/{id}
+	ID        string // operationId, if set
+	Responses []*Response
+
+	segments []string
+}
+
+// Response is one documented response of an operation
+type Response struct {
+	Status      int // 0 for the default response
+	StatusRange int // 2 for a 2XX response, 0 otherwise
+	Description string
+	Content     []*Content // Sorted by media type
+}
+
+// Content is the body of a response in one media type
+type Content struct {
+	MediaType string
+	Schema    map[string]interface{}
+	Example   interface{}            // Single example, if set
+	Examples  map[string]interface{} // Named examples, resolved to their values
+}
+
+// name identifies the operation in logs and headers
+func (o *Operation) name() string {
+	if o.ID != "" {
+		return o.ID
+	}
+	return o.Method + " " + o.Path
+}
+
+// LoadSpec reads an OpenAPI document in YAML or JSON form
+func LoadSpec(path string) (*Spec, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	spec, err := ParseSpec(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return spec, nil
+}
+
+// ParseSpec parses an OpenAPI document in YAML or JSON form
+func ParseSpec(data []byte) (*Spec, error) {
+	var raw interface{}
+	if err := yaml.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("invalid document: %v", err)
+	}
+	root, ok := normalize(raw).(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("invalid document: expected an object")
+	}
+	if root["openapi"] == nil && root["swagger"] == nil {
+		return nil, fmt.Errorf("not an OpenAPI document: missing openapi or swagger version")
+	}
+
+	spec := &Spec{root: root}
+	if info, ok := root["info"].(map[string]interface{}); ok {
+		spec.Title, _ = info["title"].(string)
+	}
+	spec.BasePath = basePath(root)
+
+	paths, ok := root["paths"].(map[string]interface{})
+	if !ok || len(paths) == 0 {
+		return nil, fmt.Errorf("no paths defined")
+	}
+	for path, item := range paths {
+		methods, ok := spec.resolve(item).(map[string]interface{})
+		if !ok {
+			continue
+		}
+		for method, value := range methods {
+			method = strings.ToUpper(method)
+			if !isHTTPMethod(method) {
+				continue
+			}
+			operation, ok := spec.resolve(value).(map[string]interface{})
+			if !ok {
+				continue
+			}
+			spec.Operations = append(spec.Operations, spec.parseOperation(method, path, operation))
+		}
+	}
+	if len(spec.Operations) == 0 {
+		return nil, fmt.Errorf("no operations defined")
+	}
+
+	sort.Slice(spec.Operations, func(i, j int) bool {
+		a, b := spec.Operations[i], spec.Operations[j]
+		if a.Path != b.Path {
+			return a.Path < b.Path
+		}
+		return a.Method < b.Method
+	})
+	return spec, nil
+}
+
+func (s *Spec) parseOperation(method, path string, raw map[string]interface{}) *Operation {
+	operation := &Operation{Method: method, Path: path, segments: splitPath(path)}
+	operation.ID, _ = raw["operationId"].(string)
+
+	responses, _ := s.resolve(raw["responses"]).(map[string]interface{})
+	for code, value := range responses {
+		response, ok := s.resolve(value).(map[string]interface{})
+		if !ok {
+			continue
+		}
+		parsed := &Response{}
+		switch {
+		case code == "default":
+		case len(code) == 3 && strings.HasSuffix(strings.ToUpper(code), "XX"):
+			parsed.StatusRange = int(code[0] - '0')
+		default:
+			status, err := strconv.Atoi(code)
+			if err != nil {
+				continue
+			}
+			parsed.Status = status
+		}
+		parsed.Description, _ = response["description"].(string)
+		parsed.Content = s.parseContent(response)
+		operation.Responses = append(operation.Responses, parsed)
+	}
+	sort.Slice(operation.Responses, func(i, j int) bool {
+		return operation.Responses[i].sortKey() < operation.Responses[j].sortKey()
+	})
+	return operation
+}
+
+// parseContent reads the media types of an OpenAPI 3 response, or the
+// schema and examples of a Swagger 2 response
+func (s *Spec) parseContent(response map[string]interface{}) []*Content {
+	var contents []*Content
+	if content, ok := response["content"].(map[string]interface{}); ok {
+		for mediaType, value := range content {
+			media, _ := s.resolve(value).(map[string]interface{})
+			parsed := &Content{MediaType: mediaType, Example: media["example"]}
+			parsed.Schema, _ = s.resolve(media["schema"]).(map[string]interface{})
+			if examples, ok := media["examples"].(map[string]interface{}); ok {
+				parsed.Examples = make(map[string]interface{}, len(examples))
+				for name, example := range examples {
+					if example, ok := s.resolve(example).(map[string]interface{}); ok {
+						parsed.Examples[name] = example["value"]
+					}
+				}
+			}
+			contents = append(contents, parsed)
+		}
+	} else if schema, ok := s.resolve(response["schema"]).(map[string]interface{}); ok {
+		parsed := &Content{MediaType: "application/json", Schema: schema}
+		if examples, ok := response["examples"].(map[string]interface{}); ok {
+			parsed.Example = examples["application/json"]
+		}
+		contents = append(contents, parsed)
+	}
+	sort.Slice(contents, func(i, j int) bool { return contents[i].MediaType < contents[j].MediaType })
+	return contents
+}
+
+// code is the status the response is served with
+func (r *Response) code() int {
+	switch {
+	case r.Status > 0:
+		return r.Status
+	case r.StatusRange > 0:
+		return r.StatusRange * 100
+	default:
+		return 200
+	}
+}
+
+// matches reports whether the response documents the status
+func (r *Response) matches(status int) bool {
+	if r.Status > 0 {
+		return r.Status == status
+	}
+	if r.StatusRange > 0 {
+		return status/100 == r.StatusRange
+	}
+	return true
+}
+
+// successful reports whether the response is a 2xx response
+func (r *Response) successful() bool {
+	return r.Status/100 == 2 || r.StatusRange == 2
+}
+
+// sortKey orders exact statuses first, then ranges, then the default
+func (r *Response) sortKey() int {
+	switch {
+	case r.Status > 0:
+		return r.Status
+	case r.StatusRange > 0:
+		return 1000 + r.StatusRange
+	default:
+		return 2000
+	}
+}
+
+// matchTemplate returns the path parameters if the request path fits the template
+func matchTemplate(template, segments []string) (map[string]string, bool) {
+	if len(segments) != len(template) {
+		return nil, false
+	}
+	params := map[string]string{}
+	for i, segment := range template {
+		if strings.HasPrefix(segment, "{") && strings.HasSuffix(segment, "}") {
+			params[strings.Trim(segment, "{}")] = segments[i]
+		} else if segment != segments[i] {
+			return nil, false
+		}
+	}
+	return params, true
+}
+
+// literals counts the fixed segments, so /orders/latest wins over /orders/{id}
+func (o *Operation) literals() int {
+	return countLiterals(o.segments)
+}
+
+func countLiterals(segments []string) int {
+	count := 0
+	for _, segment := range segments {
+		if !strings.HasPrefix(segment, "{") {
+			count++
+		}
+	}
+	return count
+}
+
+// resolve follows local $ref pointers such as #/components/schemas/Order
+func (s *Spec) resolve(value interface{}) interface{} {
+	for depth := 0; depth < 32; depth++ {
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return value
+		}
+		ref, ok := object["$ref"].(string)
+		if !ok {
+			return value
+		}
+		value = s.lookup(ref)
+	}
+	return nil
+}
+
+// lookup finds the value a local JSON pointer refers to
+func (s *Spec) lookup(ref string) interface{} {
+	pointer, ok := strings.CutPrefix(ref, "#/")
+	if !ok {
+		return nil
+	}
+	var current interface{} = s.root
+	for _, part := range strings.Split(pointer, "/") {
+		part = strings.ReplaceAll(strings.ReplaceAll(part, "~1", "/"), "~0", "~")
+		object, ok := current.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		current = object[part]
+	}
+	return current
+}
+
+// basePath returns the path of the first server (OpenAPI 3) or the basePath (Swagger 2)
+func basePath(root map[string]interface{}) string {
+	if path, ok := root["basePath"].(string); ok {
+		return strings.TrimSuffix(path, "/")
+	}
+	servers, _ := root["servers"].([]interface{})
+	if len(servers) == 0 {
+		return ""
+	}
+	server, _ := servers[0].(map[string]interface{})
+	serverURL, _ := server["url"].(string)
+	parsed, err := url.Parse(serverURL)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSuffix(parsed.Path, "/")
+}
+
+// normalize converts YAML maps with non-string keys, such as unquoted status
+// codes, to string-keyed maps
+func normalize(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, item := range v {
+			v[key] = normalize(item)
+		}
+		return v
+	case map[interface{}]interface{}:
+		object := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			object[fmt.Sprint(key)] = normalize(item)
+		}
+		return object
+	case []interface{}:
+		for i, item := range v {
+			v[i] = normalize(item)
+		}
+		return v
+	default:
+		return v
+	}
+}
+
+func splitPath(path string) []string {
+	path = strings.Trim(path, "/")
+	if path == "" {
+		return nil
+	}
+	return strings.Split(path, "/")
+}
+
+func isHTTPMethod(method string) bool {
+	switch method {
+	case "GET", "PUT", "POST", "DELETE", "OPTIONS", "HEAD", "PATCH", "TRACE":
+		return true
+	}
+	return false
+}
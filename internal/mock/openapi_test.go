@@ -0,0 +1,162 @@
+//go:build unit
+
+package mock
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testSpec = `
+openapi: 3.0.3
+info:
+  title: Store
+servers:
+  - url: https://api.example.com/v1
+paths:
+  /orders:
+    get:
+      operationId: listOrders
+      responses:
+        200:
+          description: Orders
+          content:
+            application/json:
+              schema:
+                type: array
+                items:
+                  $ref: '#/components/schemas/Order'
+    post:
+      operationId: createOrder
+      responses:
+        '201':
+          description: Created
+          content:
+            application/json:
+              examples:
+                pending:
+                  value: {id: 7, status: pending}
+                soldOut:
+                  $ref: '#/components/examples/SoldOut'
+        '4XX':
+          description: Client error
+          content:
+            application/json:
+              schema:
+                $ref: '#/components/schemas/Error'
+  /orders/{id}:
+    get:
+      responses:
+        '200':
+          description: Order
+          content:
+            application/json:
+              schema:
+                $ref: '#/components/schemas/Order'
+        '404':
+          description: Missing
+    delete:
+      responses:
+        '204':
+          description: Deleted
+  /orders/latest:
+    get:
+      operationId: latestOrder
+      responses:
+        default:
+          description: Latest
+          content:
+            text/plain:
+              example: order 7
+components:
+  examples:
+    SoldOut:
+      value: {id: 8, status: sold_out}
+  schemas:
+    Order:
+      type: object
+      required: [id, parent]
+      properties:
+        id: {type: integer, minimum: 1}
+        total: {type: number, exclusiveMinimum: true, minimum: 0}
+        status: {type: string, enum: [pending, shipped]}
+        email: {type: string, format: email}
+        created: {type: string, format: date-time}
+        code: {type: string, minLength: 8, maxLength: 10}
+        tags: {type: array, minItems: 2, items: {type: string}}
+        paid: {type: boolean}
+        note: {type: [string, "null"]}
+        parent: {$ref: '#/components/schemas/Order'}
+    Error:
+      allOf:
+        - type: object
+          properties:
+            message: {type: string, example: bad request}
+        - type: object
+          properties:
+            code: {type: integer, default: 400}
+`
+
+func TestParseSpec(t *testing.T) {
+	spec, err := ParseSpec([]byte(testSpec))
+	require.NoError(t, err)
+	assert.Equal(t, "Store", spec.Title)
+	assert.Equal(t, "/v1", spec.BasePath)
+
+	var names []string
+	for _, operation := range spec.Operations {
+		names = append(names, operation.name())
+	}
+	assert.Equal(t, []string{"listOrders", "createOrder", "latestOrder", "DELETE /orders/{id}", "GET /orders/{id}"}, names)
+
+	create := spec.Operations[1]
+	require.Len(t, create.Responses, 2)
+	assert.Equal(t, 201, create.Responses[0].Status)
+	assert.Equal(t, 4, create.Responses[1].StatusRange)
+	assert.Equal(t, map[string]interface{}{"id": 7, "status": "pending"}, create.Responses[0].Content[0].Examples["pending"])
+	assert.Equal(t, map[string]interface{}{"id": 8, "status": "sold_out"}, create.Responses[0].Content[0].Examples["soldOut"])
+
+	params, ok := matchTemplate(spec.Operations[4].segments, []string{"orders", "42"})
+	require.True(t, ok)
+	assert.Equal(t, map[string]string{"id": "42"}, params)
+	_, ok = matchTemplate(spec.Operations[4].segments, []string{"orders"})
+	assert.False(t, ok)
+}
+
+func TestParseSpecErrors(t *testing.T) {
+	for document, message := range map[string]string{
+		"openapi: [":                             "invalid document",
+		"- a\n- b":                               "expected an object",
+		"info: {title: x}":                       "not an OpenAPI document",
+		"openapi: 3.0.0\npaths: {}":              "no paths",
+		"openapi: 3.0.0\npaths: {/a: {foo: {}}}": "no operations",
+	} {
+		_, err := ParseSpec([]byte(document))
+		assert.ErrorContains(t, err, message, document)
+	}
+}
+
+func TestExample(t *testing.T) {
+	spec, err := ParseSpec([]byte(testSpec))
+	require.NoError(t, err)
+
+	order := spec.Example(spec.lookup("#/components/schemas/Order").(map[string]interface{})).(map[string]interface{})
+	assert.Equal(t, int64(1), order["id"])
+	assert.Equal(t, 0.5, order["total"])
+	assert.Equal(t, "pending", order["status"])
+	assert.Equal(t, "user@example.com", order["email"])
+	assert.Equal(t, "2024-01-01T12:00:00Z", order["created"])
+	assert.Equal(t, "stringxx", order["code"])
+	assert.Equal(t, []interface{}{"string", "string"}, order["tags"])
+	assert.Equal(t, true, order["paid"])
+	assert.Equal(t, "string", order["note"])
+	assert.Contains(t, order, "parent", "recursive required properties are kept")
+
+	errorExample := spec.Example(spec.lookup("#/components/schemas/Error").(map[string]interface{}))
+	assert.Equal(t, map[string]interface{}{"message": "bad request", "code": 400}, errorExample)
+
+	assert.Equal(t, int64(-3), spec.Example(map[string]interface{}{"type": "integer", "maximum": -3}))
+	assert.Nil(t, spec.Example(map[string]interface{}{"$ref": "#/components/schemas/Missing"}))
+}
@@ -0,0 +1,334 @@
+package mock
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"math/rand"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Response selection strategies
+const (
+	SelectFirst  = "first"  // The lowest 2xx response and its first example
+	SelectRandom = "random" // A random 2xx response and a random example
+)
+
+// OperationHeader names the operation that produced a mock response
+const OperationHeader = "X-Netkit-Mock-Operation"
+
+// Options configure how the mock server answers
+type Options struct {
+	Selection  string        // SelectFirst (default) or SelectRandom
+	LatencyMin time.Duration // Each response is delayed by a random time in [LatencyMin, LatencyMax]
+	LatencyMax time.Duration
+	Behaviors  *Behaviors // Stateful resources and sequences, served before the spec's examples
+}
+
+// Server answers requests with example responses from an OpenAPI spec.
+// Clients pick a specific response with a Prefer header, as in
+// "Prefer: code=404" or "Prefer: example=soldOut". Behaviors, when set,
+// answer matching requests first.
+type Server struct {
+	spec    *Spec
+	options Options
+
+	mutex sync.Mutex
+	rand  *rand.Rand
+	state *state
+}
+
+// NewServer creates a mock server for the spec
+func NewServer(spec *Spec, options Options) *Server {
+	if options.Selection == "" {
+		options.Selection = SelectFirst
+	}
+	return &Server{
+		spec:    spec,
+		options: options,
+		rand:    rand.New(rand.NewSource(time.Now().UnixNano())),
+		state:   newState(),
+	}
+}
+
+// ValidateSelection checks a response selection strategy
+func ValidateSelection(selection string) error {
+	if selection != SelectFirst && selection != SelectRandom {
+		return fmt.Errorf("selection must be %s or %s, got %q", SelectFirst, SelectRandom, selection)
+	}
+	return nil
+}
+
+// ParseLatency parses a fixed latency (100ms) or a range (50ms-200ms)
+func ParseLatency(value string) (time.Duration, time.Duration, error) {
+	if value == "" {
+		return 0, 0, nil
+	}
+	low, high, isRange := strings.Cut(value, "-")
+	minimum, err := time.ParseDuration(strings.TrimSpace(low))
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid latency %q: expected a duration such as 100ms or a range such as 50ms-200ms", value)
+	}
+	maximum := minimum
+	if isRange {
+		if maximum, err = time.ParseDuration(strings.TrimSpace(high)); err != nil {
+			return 0, 0, fmt.Errorf("invalid latency %q: expected a duration such as 100ms or a range such as 50ms-200ms", value)
+		}
+	}
+	if minimum < 0 || maximum < minimum {
+		return 0, 0, fmt.Errorf("invalid latency %q: the range must be ascending and not negative", value)
+	}
+	return minimum, maximum, nil
+}
+
+// ServeHTTP answers a request from the configured behaviors, or with a
+// response documented for its operation
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, PATCH, HEAD, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "*")
+
+	if r.URL.Path == ResetPath && r.Method == http.MethodPost {
+		s.reset()
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	path := r.URL.Path
+	if s.spec.BasePath != "" && strings.HasPrefix(path, s.spec.BasePath) {
+		path = strings.TrimPrefix(path, s.spec.BasePath)
+	}
+	segments := splitPath(path)
+	operation, status := s.route(r.Method, segments)
+
+	if s.serveSequence(w, r, segments, operation) || s.serveResource(w, r, segments, operation) {
+		return
+	}
+
+	if operation == nil {
+		// Answer CORS preflights for paths without an OPTIONS operation
+		if r.Method == http.MethodOptions && status == http.StatusMethodNotAllowed {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		writeError(w, status, fmt.Sprintf("No operation for %s %s", r.Method, r.URL.Path))
+		return
+	}
+
+	prefer := parsePrefer(r.Header.Get("Prefer"))
+	response, err := s.selectResponse(operation, prefer["code"])
+	if err != nil {
+		writeError(w, http.StatusNotFound, err.Error())
+		return
+	}
+
+	s.delay(r)
+	s.writeResponse(w, r, operation, response, prefer["example"])
+}
+
+// writeResponse writes a documented response with an example body
+func (s *Server) writeResponse(w http.ResponseWriter, r *http.Request, operation *Operation, response *Response, example string) {
+	w.Header().Set(OperationHeader, operation.name())
+	content := negotiate(response.Content, r.Header.Get("Accept"))
+	if content == nil || r.Method == http.MethodHead || response.code() == http.StatusNoContent {
+		w.WriteHeader(response.code())
+		return
+	}
+
+	body, err := s.body(content, example)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", content.MediaType)
+	w.WriteHeader(response.code())
+	if _, err := w.Write(body); err != nil {
+		slog.Warn("Error writing mock response", "error", err)
+	}
+}
+
+// route finds the operation for the request path, preferring the template
+// with the most fixed segments. With no operation it returns 404, or 405 when
+// the path exists for other methods.
+func (s *Server) route(method string, segments []string) (*Operation, int) {
+	var best *Operation
+	status := http.StatusNotFound
+	for _, operation := range s.spec.Operations {
+		if _, ok := matchTemplate(operation.segments, segments); !ok {
+			continue
+		}
+		if operation.Method != method && !(method == http.MethodHead && operation.Method == http.MethodGet) {
+			status = http.StatusMethodNotAllowed
+			continue
+		}
+		if best == nil || operation.literals() > best.literals() {
+			best = operation
+		}
+	}
+	return best, status
+}
+
+// selectResponse picks the requested status, or a 2xx response by the
+// selection strategy, falling back to the first documented response
+func (s *Server) selectResponse(operation *Operation, code string) (*Response, error) {
+	if len(operation.Responses) == 0 {
+		return &Response{Status: http.StatusOK}, nil
+	}
+
+	if code != "" {
+		status, err := strconv.Atoi(code)
+		if err != nil {
+			return nil, fmt.Errorf("invalid Prefer code %q", code)
+		}
+		for _, response := range operation.Responses {
+			if response.matches(status) {
+				if response.Status == 0 {
+					// Serve ranges and the default response with the requested code
+					copied := *response
+					copied.Status = status
+					return &copied, nil
+				}
+				return response, nil
+			}
+		}
+		return nil, fmt.Errorf("no %d response documented for %s", status, operation.name())
+	}
+
+	var successful []*Response
+	for _, response := range operation.Responses {
+		if response.successful() {
+			successful = append(successful, response)
+		}
+	}
+	if len(successful) == 0 {
+		return operation.Responses[0], nil
+	}
+	if s.options.Selection == SelectRandom {
+		return successful[s.intn(len(successful))], nil
+	}
+	return successful[0], nil
+}
+
+// body encodes the example for the content
+func (s *Server) body(content *Content, name string) ([]byte, error) {
+	example, err := s.example(content, name)
+	if err != nil {
+		return nil, err
+	}
+
+	// Text examples of non-JSON media types are served as they are
+	if text, ok := example.(string); ok && !strings.Contains(content.MediaType, "json") {
+		return []byte(text), nil
+	}
+	return json.Marshal(example)
+}
+
+// example returns the named example, a documented example, or one generated from the schema
+func (s *Server) example(content *Content, name string) (interface{}, error) {
+	var example interface{}
+	switch {
+	case name != "":
+		value, ok := content.Examples[name]
+		if !ok {
+			return nil, fmt.Errorf("no example named %q", name)
+		}
+		example = value
+	case len(content.Examples) > 0:
+		names := make([]string, 0, len(content.Examples))
+		for name := range content.Examples {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		pick := names[0]
+		if s.options.Selection == SelectRandom {
+			pick = names[s.intn(len(names))]
+		}
+		example = content.Examples[pick]
+	case content.Example != nil:
+		example = content.Example
+	default:
+		example = s.spec.Example(content.Schema)
+	}
+	return example, nil
+}
+
+// delay waits for the configured latency, or until the client goes away
+func (s *Server) delay(r *http.Request) {
+	latency := s.options.LatencyMin
+	if spread := s.options.LatencyMax - s.options.LatencyMin; spread > 0 {
+		latency += time.Duration(s.int63n(int64(spread) + 1))
+	}
+	if latency <= 0 {
+		return
+	}
+	timer := time.NewTimer(latency)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-r.Context().Done():
+	}
+}
+
+func (s *Server) intn(n int) int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.rand.Intn(n)
+}
+
+func (s *Server) int63n(n int64) int64 {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.rand.Int63n(n)
+}
+
+// negotiate picks the media type the client accepts, preferring JSON
+func negotiate(contents []*Content, accept string) *Content {
+	if len(contents) == 0 {
+		return nil
+	}
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, _, _ := strings.Cut(strings.TrimSpace(part), ";")
+		if mediaType == "" || mediaType == "*/*" {
+			continue
+		}
+		for _, content := range contents {
+			if content.MediaType == mediaType {
+				return content
+			}
+		}
+	}
+	for _, content := range contents {
+		if strings.Contains(content.MediaType, "json") {
+			return content
+		}
+	}
+	return contents[0]
+}
+
+// parsePrefer reads key=value preferences such as "code=404, example=soldOut"
+func parsePrefer(header string) map[string]string {
+	preferences := map[string]string{}
+	for _, part := range strings.FieldsFunc(header, func(r rune) bool { return r == ',' || r == ';' }) {
+		key, value, _ := strings.Cut(strings.TrimSpace(part), "=")
+		preferences[strings.ToLower(key)] = strings.Trim(value, `"`)
+	}
+	return preferences
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	data, err := json.Marshal(map[string]string{"error": message})
+	if err != nil {
+		http.Error(w, message, status)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if _, err := w.Write(data); err != nil {
+		slog.Warn("Error writing mock error response", "error", err)
+	}
+}
@@ -0,0 +1,132 @@
+//go:build unit
+
+package mock
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestServer(t *testing.T, options Options) *Server {
+	t.Helper()
+	spec, err := ParseSpec([]byte(testSpec))
+	require.NoError(t, err)
+	return NewServer(spec, options)
+}
+
+func serve(server *Server, method, target, prefer string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, nil)
+	if prefer != "" {
+		req.Header.Set("Prefer", prefer)
+	}
+	rec := httptest.NewRecorder()
+	server.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestServerResponses(t *testing.T) {
+	server := newTestServer(t, Options{})
+
+	rec := serve(server, http.MethodGet, "/orders", "")
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+	assert.Equal(t, "listOrders", rec.Header().Get(OperationHeader))
+	var orders []map[string]interface{}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &orders))
+	require.Len(t, orders, 1)
+	assert.Equal(t, float64(1), orders[0]["id"])
+
+	// The base path of the first server is accepted too
+	rec = serve(server, http.MethodGet, "/v1/orders/42", "")
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	// Fixed segments win over parameters
+	rec = serve(server, http.MethodGet, "/orders/latest", "")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "text/plain", rec.Header().Get("Content-Type"))
+	assert.Equal(t, "order 7", rec.Body.String())
+
+	rec = serve(server, http.MethodPost, "/orders", "")
+	assert.Equal(t, http.StatusCreated, rec.Code)
+	assert.JSONEq(t, `{"id": 7, "status": "pending"}`, rec.Body.String())
+
+	rec = serve(server, http.MethodDelete, "/orders/42", "")
+	assert.Equal(t, http.StatusNoContent, rec.Code)
+	assert.Empty(t, rec.Body.String())
+}
+
+func TestServerPrefer(t *testing.T) {
+	server := newTestServer(t, Options{})
+
+	rec := serve(server, http.MethodPost, "/orders", "example=soldOut")
+	assert.Equal(t, http.StatusCreated, rec.Code)
+	assert.JSONEq(t, `{"id": 8, "status": "sold_out"}`, rec.Body.String())
+
+	rec = serve(server, http.MethodPost, "/orders", "code=422")
+	assert.Equal(t, http.StatusUnprocessableEntity, rec.Code)
+	assert.JSONEq(t, `{"message": "bad request", "code": 400}`, rec.Body.String())
+
+	rec = serve(server, http.MethodGet, "/orders/42", "code=404")
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+	assert.Empty(t, rec.Body.String())
+
+	rec = serve(server, http.MethodGet, "/orders", "code=500")
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+	assert.Contains(t, rec.Body.String(), "no 500 response documented for listOrders")
+
+	rec = serve(server, http.MethodPost, "/orders", "example=missing")
+	assert.Equal(t, http.StatusInternalServerError, rec.Code)
+}
+
+func TestServerRouting(t *testing.T) {
+	server := newTestServer(t, Options{})
+
+	assert.Equal(t, http.StatusNotFound, serve(server, http.MethodGet, "/customers", "").Code)
+	assert.Equal(t, http.StatusMethodNotAllowed, serve(server, http.MethodPatch, "/orders", "").Code)
+	assert.Equal(t, http.StatusNoContent, serve(server, http.MethodOptions, "/orders", "").Code)
+
+	rec := serve(server, http.MethodHead, "/orders", "")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Empty(t, rec.Body.String())
+}
+
+func TestServerRandomSelection(t *testing.T) {
+	server := newTestServer(t, Options{Selection: SelectRandom})
+	seen := map[string]bool{}
+	for i := 0; i < 100; i++ {
+		seen[serve(server, http.MethodPost, "/orders", "").Body.String()] = true
+	}
+	assert.Len(t, seen, 2, "both named examples are served")
+}
+
+func TestServerLatency(t *testing.T) {
+	server := newTestServer(t, Options{LatencyMin: 20 * time.Millisecond, LatencyMax: 40 * time.Millisecond})
+	start := time.Now()
+	serve(server, http.MethodGet, "/orders", "")
+	assert.GreaterOrEqual(t, time.Since(start), 20*time.Millisecond)
+}
+
+func TestParseLatency(t *testing.T) {
+	minimum, maximum, err := ParseLatency("100ms")
+	require.NoError(t, err)
+	assert.Equal(t, 100*time.Millisecond, minimum)
+	assert.Equal(t, 100*time.Millisecond, maximum)
+
+	minimum, maximum, err = ParseLatency("50ms-200ms")
+	require.NoError(t, err)
+	assert.Equal(t, 50*time.Millisecond, minimum)
+	assert.Equal(t, 200*time.Millisecond, maximum)
+
+	for _, value := range []string{"soon", "200ms-50ms", "50ms-later"} {
+		_, _, err := ParseLatency(value)
+		assert.Error(t, err, value)
+	}
+	assert.NoError(t, ValidateSelection(SelectRandom))
+	assert.Error(t, ValidateSelection("last"))
+}
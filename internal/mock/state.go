@@ -0,0 +1,437 @@
+package mock
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ResetPath clears all resources and restarts all sequences when POSTed to
+const ResetPath = "/__netkit/mock/reset"
+
+// Sequence repeat modes
+const (
+	RepeatLast  = "last"  // Keep serving the last step once the sequence ends
+	RepeatCycle = "cycle" // Start the sequence over
+)
+
+// Behaviors declare stateful mock behavior on top of an OpenAPI spec
+type Behaviors struct {
+	Resources []ResourceBehavior `yaml:"resources"`
+	Sequences []SequenceBehavior `yaml:"sequences"`
+}
+
+// ResourceBehavior keeps an in-memory collection: POST to the collection
+// creates an item, GET lists them, and GET, PUT, PATCH, and DELETE on the
+// item path read and change one
+type ResourceBehavior struct {
+	Collection string `yaml:"collection"` // e.g. /orders
+	Item       string `yaml:"item"`       // e.g. /orders/{id}
+	IDField    string `yaml:"id"`         // Field holding the item ID (default: id)
+
+	collection []string
+	item       []string
+}
+
+// SequenceBehavior answers successive requests to one path with successive
+// steps, e.g. failing twice and then succeeding. Each concrete path, such as
+// /payments/1 and /payments/2, runs through the steps separately.
+type SequenceBehavior struct {
+	Method string         `yaml:"method"`
+	Path   string         `yaml:"path"`
+	Steps  []SequenceStep `yaml:"steps"`
+	Repeat string         `yaml:"repeat"` // RepeatLast (default) or RepeatCycle
+
+	path []string
+}
+
+// SequenceStep is one response of a sequence
+type SequenceStep struct {
+	Status  int               `yaml:"status"`
+	Times   int               `yaml:"times"`   // How many requests get this step (default: 1)
+	Body    interface{}       `yaml:"body"`    // Served as JSON; without one the spec's example for the status is used
+	Headers map[string]string `yaml:"headers"` // Extra response headers
+}
+
+// resourceState holds the items of one resource behavior
+type resourceState struct {
+	items  map[string]map[string]interface{}
+	order  []string
+	nextID int
+}
+
+// LoadBehaviors reads behaviors from a YAML or JSON file
+func LoadBehaviors(path string) (*Behaviors, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	behaviors, err := ParseBehaviors(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return behaviors, nil
+}
+
+// ParseBehaviors parses and validates behaviors in YAML or JSON form
+func ParseBehaviors(data []byte) (*Behaviors, error) {
+	var behaviors Behaviors
+	if err := yaml.Unmarshal(data, &behaviors); err != nil {
+		return nil, fmt.Errorf("invalid behaviors: %v", err)
+	}
+
+	for i := range behaviors.Resources {
+		resource := &behaviors.Resources[i]
+		if resource.Collection == "" || resource.Item == "" {
+			return nil, fmt.Errorf("resource %d: collection and item paths are required", i+1)
+		}
+		if resource.IDField == "" {
+			resource.IDField = "id"
+		}
+		resource.collection = splitPath(resource.Collection)
+		resource.item = splitPath(resource.Item)
+		if itemParam(resource.item) == "" {
+			return nil, fmt.Errorf("resource %s: item path must end in a parameter such as /orders/{id}", resource.Collection)
+		}
+	}
+
+	for i := range behaviors.Sequences {
+		sequence := &behaviors.Sequences[i]
+		if sequence.Path == "" || len(sequence.Steps) == 0 {
+			return nil, fmt.Errorf("sequence %d: a path and at least one step are required", i+1)
+		}
+		sequence.Method = strings.ToUpper(sequence.Method)
+		if sequence.Method == "" {
+			sequence.Method = http.MethodGet
+		}
+		switch sequence.Repeat {
+		case "":
+			sequence.Repeat = RepeatLast
+		case RepeatLast, RepeatCycle:
+		default:
+			return nil, fmt.Errorf("sequence %s %s: repeat must be %s or %s", sequence.Method, sequence.Path, RepeatLast, RepeatCycle)
+		}
+		for j := range sequence.Steps {
+			step := &sequence.Steps[j]
+			if step.Status < 100 || step.Status > 599 {
+				return nil, fmt.Errorf("sequence %s %s: step %d needs a status between 100 and 599", sequence.Method, sequence.Path, j+1)
+			}
+			if step.Times <= 0 {
+				step.Times = 1
+			}
+			step.Body = normalize(step.Body)
+		}
+		sequence.path = splitPath(sequence.Path)
+	}
+	return &behaviors, nil
+}
+
+// state holds what behaviors have seen since the server started or was reset
+type state struct {
+	mutex     sync.Mutex
+	resources map[string]*resourceState // By collection path
+	sequences map[string]int            // Requests served per method and concrete path
+}
+
+func newState() *state {
+	return &state{resources: map[string]*resourceState{}, sequences: map[string]int{}}
+}
+
+// reset clears all resources and restarts all sequences
+func (s *Server) reset() {
+	s.state.mutex.Lock()
+	defer s.state.mutex.Unlock()
+	s.state.resources = map[string]*resourceState{}
+	s.state.sequences = map[string]int{}
+}
+
+// serveSequence answers the request with the next step of a matching sequence
+func (s *Server) serveSequence(w http.ResponseWriter, r *http.Request, segments []string, operation *Operation) bool {
+	if s.options.Behaviors == nil {
+		return false
+	}
+	for i := range s.options.Behaviors.Sequences {
+		sequence := &s.options.Behaviors.Sequences[i]
+		if sequence.Method != r.Method {
+			continue
+		}
+		if _, ok := matchTemplate(sequence.path, segments); !ok {
+			continue
+		}
+
+		key := r.Method + " /" + strings.Join(segments, "/")
+		s.state.mutex.Lock()
+		count := s.state.sequences[key]
+		s.state.sequences[key]++
+		s.state.mutex.Unlock()
+
+		step := sequence.step(count)
+		s.delay(r)
+		for name, value := range step.Headers {
+			w.Header().Set(name, value)
+		}
+		if step.Body == nil && operation != nil {
+			if response, err := s.selectResponse(operation, fmt.Sprint(step.Status)); err == nil {
+				s.writeResponse(w, r, operation, response, "")
+				return true
+			}
+		}
+		writeJSON(w, step.Status, step.Body)
+		return true
+	}
+	return false
+}
+
+// step returns the step for the request that follows count earlier ones
+func (b *SequenceBehavior) step(count int) SequenceStep {
+	total := 0
+	for _, step := range b.Steps {
+		total += step.Times
+	}
+	if count >= total {
+		if b.Repeat != RepeatCycle {
+			return b.Steps[len(b.Steps)-1]
+		}
+		count %= total
+	}
+	for _, step := range b.Steps {
+		if count < step.Times {
+			return step
+		}
+		count -= step.Times
+	}
+	return b.Steps[len(b.Steps)-1]
+}
+
+// serveResource answers the request from a matching in-memory resource
+func (s *Server) serveResource(w http.ResponseWriter, r *http.Request, segments []string, operation *Operation) bool {
+	if s.options.Behaviors == nil {
+		return false
+	}
+	for i := range s.options.Behaviors.Resources {
+		resource := &s.options.Behaviors.Resources[i]
+		if _, ok := matchTemplate(resource.collection, segments); ok {
+			switch r.Method {
+			case http.MethodGet, http.MethodHead:
+				s.delay(r)
+				writeJSON(w, http.StatusOK, s.listItems(resource))
+				return true
+			case http.MethodPost:
+				s.createItem(w, r, resource, operation)
+				return true
+			}
+		}
+		// More specific spec operations, such as /orders/latest, win over the item path
+		if params, ok := matchTemplate(resource.item, segments); ok && (operation == nil || operation.literals() <= countLiterals(resource.item)) {
+			id := params[itemParam(resource.item)]
+			switch r.Method {
+			case http.MethodGet, http.MethodHead, http.MethodPut, http.MethodPatch, http.MethodDelete:
+				s.changeItem(w, r, resource, id)
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// createItem stores the posted object, filling fields the client left out
+// from the spec's example response and assigning an ID when none is given
+func (s *Server) createItem(w http.ResponseWriter, r *http.Request, resource *ResourceBehavior, operation *Operation) {
+	body, err := readObject(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	item := map[string]interface{}{}
+	if example, ok := s.createdExample(operation).(map[string]interface{}); ok {
+		for key, value := range example {
+			item[key] = value
+		}
+		delete(item, resource.IDField)
+	}
+	for key, value := range body {
+		item[key] = value
+	}
+
+	s.state.mutex.Lock()
+	items := s.state.resource(resource.Collection)
+	if item[resource.IDField] == nil {
+		items.nextID++
+		item[resource.IDField] = items.nextID
+	}
+	id := fmt.Sprint(item[resource.IDField])
+	if _, exists := items.items[id]; !exists {
+		items.order = append(items.order, id)
+	}
+	items.items[id] = item
+	s.state.mutex.Unlock()
+
+	s.delay(r)
+	w.Header().Set("Location", strings.TrimSuffix(r.URL.Path, "/")+"/"+id)
+	writeJSON(w, http.StatusCreated, item)
+}
+
+// changeItem reads, replaces, updates, or deletes one stored item
+func (s *Server) changeItem(w http.ResponseWriter, r *http.Request, resource *ResourceBehavior, id string) {
+	var body map[string]interface{}
+	if r.Method == http.MethodPut || r.Method == http.MethodPatch {
+		var err error
+		if body, err = readObject(r); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+
+	s.state.mutex.Lock()
+	items := s.state.resource(resource.Collection)
+	item, exists := items.items[id]
+	status := http.StatusOK
+	switch {
+	case r.Method == http.MethodPut:
+		if !exists {
+			items.order = append(items.order, id)
+			status = http.StatusCreated
+		}
+		item = body
+		item[resource.IDField] = storedID(items.items[id], resource.IDField, id)
+		items.items[id] = item
+	case !exists:
+		status = http.StatusNotFound
+	case r.Method == http.MethodPatch:
+		for key, value := range body {
+			if key != resource.IDField {
+				item[key] = value
+			}
+		}
+	case r.Method == http.MethodDelete:
+		delete(items.items, id)
+		for i, stored := range items.order {
+			if stored == id {
+				items.order = append(items.order[:i], items.order[i+1:]...)
+				break
+			}
+		}
+		status = http.StatusNoContent
+	}
+	data, err := json.Marshal(item)
+	s.state.mutex.Unlock()
+
+	s.delay(r)
+	switch {
+	case status == http.StatusNotFound:
+		writeError(w, status, fmt.Sprintf("No %s with %s %s", resource.Collection, resource.IDField, id))
+	case status == http.StatusNoContent:
+		w.WriteHeader(status)
+	case err != nil:
+		writeError(w, http.StatusInternalServerError, "Failed to encode item")
+	default:
+		writeRaw(w, status, data)
+	}
+}
+
+// listItems returns the stored items in the order they were created
+func (s *Server) listItems(resource *ResourceBehavior) []map[string]interface{} {
+	s.state.mutex.Lock()
+	defer s.state.mutex.Unlock()
+	items := s.state.resource(resource.Collection)
+	list := make([]map[string]interface{}, 0, len(items.order))
+	for _, id := range items.order {
+		list = append(list, items.items[id])
+	}
+	return list
+}
+
+// createdExample is the example body of the operation's success response, if any
+func (s *Server) createdExample(operation *Operation) interface{} {
+	if operation == nil {
+		return nil
+	}
+	response, err := s.selectResponse(operation, "")
+	if err != nil {
+		return nil
+	}
+	content := negotiate(response.Content, "application/json")
+	if content == nil {
+		return nil
+	}
+	example, err := s.example(content, "")
+	if err != nil {
+		return nil
+	}
+	return example
+}
+
+// resource returns the state of a collection, creating it on first use
+func (st *state) resource(collection string) *resourceState {
+	items := st.resources[collection]
+	if items == nil {
+		items = &resourceState{items: map[string]map[string]interface{}{}}
+		st.resources[collection] = items
+	}
+	return items
+}
+
+// storedID keeps the ID of an existing item, in its original type
+func storedID(existing map[string]interface{}, field, id string) interface{} {
+	if existing != nil && existing[field] != nil {
+		return existing[field]
+	}
+	return id
+}
+
+// itemParam returns the parameter name of the last segment of an item path
+func itemParam(segments []string) string {
+	if len(segments) == 0 {
+		return ""
+	}
+	last := segments[len(segments)-1]
+	if !strings.HasPrefix(last, "{") || !strings.HasSuffix(last, "}") {
+		return ""
+	}
+	return strings.Trim(last, "{}")
+}
+
+// readObject decodes a JSON object request body, treating an empty body as empty
+func readObject(r *http.Request) (map[string]interface{}, error) {
+	data, err := io.ReadAll(r.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read request body: %v", err)
+	}
+	object := map[string]interface{}{}
+	if len(strings.TrimSpace(string(data))) == 0 {
+		return object, nil
+	}
+	if err := json.Unmarshal(data, &object); err != nil {
+		return nil, fmt.Errorf("request body must be a JSON object: %v", err)
+	}
+	return object, nil
+}
+
+func writeJSON(w http.ResponseWriter, status int, value interface{}) {
+	if value == nil {
+		w.WriteHeader(status)
+		return
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "Failed to encode response")
+		return
+	}
+	writeRaw(w, status, data)
+}
+
+func writeRaw(w http.ResponseWriter, status int, data []byte) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if _, err := w.Write(data); err != nil {
+		slog.Warn("Error writing mock response", "error", err)
+	}
+}
@@ -0,0 +1,163 @@
+//go:build unit
+
+package mock
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testBehaviors = `
+resources:
+  - collection: /orders
+    item: /orders/{id}
+sequences:
+  - path: /orders/{id}/payment
+    steps:
+      - status: 503
+        times: 2
+        headers: {Retry-After: "1"}
+      - status: 200
+        body: {paid: true}
+  - method: post
+    path: /orders/{id}/refund
+    repeat: cycle
+    steps:
+      - status: 202
+      - status: 409
+        body: {error: already refunded}
+`
+
+func newStatefulServer(t *testing.T) *Server {
+	t.Helper()
+	spec, err := ParseSpec([]byte(testSpec))
+	require.NoError(t, err)
+	behaviors, err := ParseBehaviors([]byte(testBehaviors))
+	require.NoError(t, err)
+	return NewServer(spec, Options{Behaviors: behaviors})
+}
+
+func send(server *Server, method, target, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	rec := httptest.NewRecorder()
+	server.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestResourceBehavior(t *testing.T) {
+	server := newStatefulServer(t)
+
+	rec := send(server, http.MethodGet, "/orders", "")
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.JSONEq(t, `[]`, rec.Body.String())
+
+	// Created items get an ID and fields from the spec's example response
+	rec = send(server, http.MethodPost, "/orders", `{"note": "gift"}`)
+	require.Equal(t, http.StatusCreated, rec.Code)
+	assert.Equal(t, "/orders/1", rec.Header().Get("Location"))
+	assert.JSONEq(t, `{"id": 1, "status": "pending", "note": "gift"}`, rec.Body.String())
+
+	rec = send(server, http.MethodPost, "/v1/orders", `{"id": "abc", "status": "shipped"}`)
+	require.Equal(t, http.StatusCreated, rec.Code)
+
+	rec = send(server, http.MethodGet, "/orders/1", "")
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.JSONEq(t, `{"id": 1, "status": "pending", "note": "gift"}`, rec.Body.String())
+
+	rec = send(server, http.MethodPatch, "/orders/1", `{"status": "shipped", "id": 99}`)
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.JSONEq(t, `{"id": 1, "status": "shipped", "note": "gift"}`, rec.Body.String())
+
+	rec = send(server, http.MethodPut, "/orders/abc", `{"status": "cancelled"}`)
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.JSONEq(t, `{"id": "abc", "status": "cancelled"}`, rec.Body.String())
+
+	rec = send(server, http.MethodGet, "/orders", "")
+	var orders []map[string]interface{}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &orders))
+	require.Len(t, orders, 2)
+	assert.Equal(t, float64(1), orders[0]["id"])
+	assert.Equal(t, "abc", orders[1]["id"])
+
+	assert.Equal(t, http.StatusNoContent, send(server, http.MethodDelete, "/orders/1", "").Code)
+	assert.Equal(t, http.StatusNotFound, send(server, http.MethodGet, "/orders/1", "").Code)
+	assert.Equal(t, http.StatusNotFound, send(server, http.MethodDelete, "/orders/1", "").Code)
+	assert.Equal(t, http.StatusBadRequest, send(server, http.MethodPost, "/orders", `[1]`).Code)
+
+	// Operations outside the behaviors still come from the spec
+	rec = send(server, http.MethodGet, "/orders/latest", "")
+	assert.Equal(t, "order 7", rec.Body.String())
+
+	assert.Equal(t, http.StatusNoContent, send(server, http.MethodPost, ResetPath, "").Code)
+	assert.JSONEq(t, `[]`, send(server, http.MethodGet, "/orders", "").Body.String())
+}
+
+func TestSequenceBehavior(t *testing.T) {
+	server := newStatefulServer(t)
+
+	for i := 0; i < 2; i++ {
+		rec := send(server, http.MethodGet, "/orders/1/payment", "")
+		assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+		assert.Equal(t, "1", rec.Header().Get("Retry-After"))
+	}
+	for i := 0; i < 2; i++ {
+		rec := send(server, http.MethodGet, "/orders/1/payment", "")
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.JSONEq(t, `{"paid": true}`, rec.Body.String())
+	}
+
+	// Each concrete path has its own position in the sequence
+	assert.Equal(t, http.StatusServiceUnavailable, send(server, http.MethodGet, "/orders/2/payment", "").Code)
+
+	var codes []int
+	for i := 0; i < 4; i++ {
+		codes = append(codes, send(server, http.MethodPost, "/orders/1/refund", "").Code)
+	}
+	assert.Equal(t, []int{202, 409, 202, 409}, codes)
+
+	send(server, http.MethodPost, ResetPath, "")
+	assert.Equal(t, http.StatusServiceUnavailable, send(server, http.MethodGet, "/orders/1/payment", "").Code)
+}
+
+func TestSequenceUsesSpecExamples(t *testing.T) {
+	spec, err := ParseSpec([]byte(testSpec))
+	require.NoError(t, err)
+	behaviors, err := ParseBehaviors([]byte(`
+sequences:
+  - method: POST
+    path: /orders
+    steps:
+      - status: 422
+      - status: 201
+`))
+	require.NoError(t, err)
+	server := NewServer(spec, Options{Behaviors: behaviors})
+
+	rec := send(server, http.MethodPost, "/orders", "")
+	assert.Equal(t, http.StatusUnprocessableEntity, rec.Code)
+	assert.JSONEq(t, `{"message": "bad request", "code": 400}`, rec.Body.String())
+
+	rec = send(server, http.MethodPost, "/orders", "")
+	assert.Equal(t, http.StatusCreated, rec.Code)
+	assert.JSONEq(t, `{"id": 7, "status": "pending"}`, rec.Body.String())
+}
+
+func TestParseBehaviorsErrors(t *testing.T) {
+	for document, message := range map[string]string{
+		"resources: [":                                        "invalid behaviors",
+		"resources: [{collection: /orders}]":                  "collection and item paths are required",
+		"resources: [{collection: /a, item: /a/b}]":           "must end in a parameter",
+		"sequences: [{path: /a}]":                             "at least one step",
+		"sequences: [{path: /a, steps: [{status: 42}]}]":      "status between 100 and 599",
+		"sequences: [{path: /a, repeat: twice, steps: [{}]}]": "repeat must be",
+	} {
+		_, err := ParseBehaviors([]byte(document))
+		assert.ErrorContains(t, err, message, document)
+	}
+}
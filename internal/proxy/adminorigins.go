@@ -0,0 +1,87 @@
+package proxy
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// ParseAdminOrigin checks a browser origin allowed to call the admin API and
+// returns it the way browsers send it in the Origin header
+func ParseAdminOrigin(value string) (string, error) {
+	u, err := url.Parse(strings.TrimSuffix(strings.TrimSpace(value), "/"))
+	if err != nil {
+		return "", err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return "", fmt.Errorf("%q is not an http or https origin", value)
+	}
+	if u.Host == "" || u.User != nil || u.Path != "" || u.RawQuery != "" || u.Fragment != "" {
+		return "", fmt.Errorf("%q is not an origin, expected scheme://host[:port]", value)
+	}
+	return strings.ToLower(u.Scheme + "://" + u.Host), nil
+}
+
+// adminOriginAllowed reports whether a browser at origin may call the admin API
+func (p *Proxy) adminOriginAllowed(origin string) bool {
+	for _, allowed := range p.currentConfig().AdminOrigins {
+		if strings.EqualFold(origin, allowed) {
+			return true
+		}
+	}
+	return false
+}
+
+// requireAdminOrigin limits browser access to the admin API to the configured
+// origins. Allowed origins get their own origin back instead of `*`, with
+// credentials allowed; other origins are rejected and logged. Requests without
+// an Origin header, such as curl and the CLI, are not browser requests and
+// pass through.
+func (p *Proxy) requireAdminOrigin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if len(p.currentConfig().AdminOrigins) == 0 || origin == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if !p.adminOriginAllowed(origin) {
+			slog.Warn("Rejected admin request from a disallowed origin", "origin", origin, "method", r.Method, "path", r.URL.Path)
+			http.Error(w, "Origin not allowed", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(&originResponseWriter{ResponseWriter: w, origin: origin}, r)
+	})
+}
+
+// originResponseWriter replaces the wildcard origin admin handlers allow with
+// the caller's origin once the response header is written
+type originResponseWriter struct {
+	http.ResponseWriter
+	origin      string
+	wroteHeader bool
+}
+
+func (w *originResponseWriter) WriteHeader(status int) {
+	if !w.wroteHeader {
+		w.wroteHeader = true
+		header := w.Header()
+		header.Set("Access-Control-Allow-Origin", w.origin)
+		header.Set("Access-Control-Allow-Credentials", "true")
+		header.Add("Vary", "Origin")
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *originResponseWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// Unwrap lets http.ResponseController flush and hijack the connection
+func (w *originResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,84 @@
+//go:build unit
+
+package proxy
+
+import (
+	"bytes"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAdminOrigins(t *testing.T) {
+	var logs bytes.Buffer
+	log.SetOutput(&logs)
+	defer log.SetOutput(os.Stderr)
+
+	p := New(&Config{AdminOrigins: []string{"http://localhost:3000"}})
+	request := func(method, origin string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "/requests", nil)
+		if origin != "" {
+			req.Header.Set("Origin", origin)
+		}
+		rec := httptest.NewRecorder()
+		p.adminServer.Handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := request(http.MethodGet, "http://localhost:3000")
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "http://localhost:3000", rec.Header().Get("Access-Control-Allow-Origin"))
+	assert.Equal(t, "true", rec.Header().Get("Access-Control-Allow-Credentials"))
+	assert.Equal(t, "Origin", rec.Header().Get("Vary"))
+
+	rec = request(http.MethodOptions, "http://localhost:3000")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "http://localhost:3000", rec.Header().Get("Access-Control-Allow-Origin"))
+
+	rec = request(http.MethodOptions, "https://evil.example")
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+	assert.Empty(t, rec.Header().Get("Access-Control-Allow-Origin"))
+	assert.Contains(t, logs.String(), "WARN Rejected admin request from a disallowed origin origin=https://evil.example method=OPTIONS path=/requests")
+
+	rec = request(http.MethodGet, "")
+	assert.Equal(t, http.StatusOK, rec.Code, "requests from outside a browser pass")
+	assert.Equal(t, "*", rec.Header().Get("Access-Control-Allow-Origin"))
+
+	// Without a policy every origin gets the wildcard
+	p = New(&Config{})
+	rec = request(http.MethodGet, "https://evil.example")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "*", rec.Header().Get("Access-Control-Allow-Origin"))
+	assert.Empty(t, rec.Header().Get("Access-Control-Allow-Credentials"))
+}
+
+func TestAdminOriginsWithAuth(t *testing.T) {
+	p := New(&Config{AdminToken: "root-secret", AdminOrigins: []string{"https://dash.example.com"}})
+	req := httptest.NewRequest(http.MethodGet, "/requests", nil)
+	req.Header.Set("Origin", "https://dash.example.com")
+	rec := httptest.NewRecorder()
+	p.adminServer.Handler.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	assert.Equal(t, "https://dash.example.com", rec.Header().Get("Access-Control-Allow-Origin"), "the dashboard can read the error")
+
+	req.Header.Set("Authorization", "Bearer root-secret")
+	rec = httptest.NewRecorder()
+	p.adminServer.Handler.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusOK, rec.Code)
+}
+
+func TestParseAdminOrigin(t *testing.T) {
+	origin, err := ParseAdminOrigin("HTTP://LocalHost:3000/")
+	require.NoError(t, err)
+	assert.Equal(t, "http://localhost:3000", origin)
+
+	for _, value := range []string{"*", "localhost:3000", "ftp://example.com", "https://example.com/dashboard", "https://user@example.com", "https://"} {
+		_, err := ParseAdminOrigin(value)
+		assert.Error(t, err, value)
+	}
+}
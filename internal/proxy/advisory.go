@@ -0,0 +1,121 @@
+package proxy
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// rateLimitAdvisoryMaxAge is how long an observed rate limit is still worth reporting
+const rateLimitAdvisoryMaxAge = 5 * time.Minute
+
+// learnedRateLimitPrefix marks rate-limit headers copied from an earlier response
+const learnedRateLimitPrefix = "X-Netkit-Last-"
+
+// deprecationHeaders announce that an endpoint is going away (RFC 8594, RFC 9745)
+var deprecationHeaders = []string{"Deprecation", "Sunset"}
+
+// deprecationLinkRels are the Link relations that belong with deprecation headers
+var deprecationLinkRels = []string{`rel="deprecation"`, `rel="sunset"`, `rel="successor-version"`, `rel=deprecation`, `rel=sunset`, `rel=successor-version`}
+
+// rateLimitHeaders are the common rate-limit headers (IETF draft and X- variants)
+var rateLimitHeaders = []string{
+	"RateLimit", "RateLimit-Policy", "RateLimit-Limit", "RateLimit-Remaining", "RateLimit-Reset",
+	"X-RateLimit-Limit", "X-RateLimit-Remaining", "X-RateLimit-Reset", "Retry-After",
+}
+
+// advisoryStore remembers deprecation and rate-limit headers per route
+type advisoryStore struct {
+	mutex  sync.Mutex
+	routes map[string]*routeAdvisory
+}
+
+type routeAdvisory struct {
+	deprecation   http.Header
+	rateLimit     http.Header
+	rateLimitSeen time.Time
+}
+
+func newAdvisoryStore() *advisoryStore {
+	return &advisoryStore{routes: make(map[string]*routeAdvisory)}
+}
+
+// applyAdvisories learns advisory headers from an upstream response and attaches
+// ones learned earlier for the same route when this response lacks them, so
+// callers hear about deprecations even from upstreams that only send them sometimes
+func (p *Proxy) applyAdvisories(header http.Header, targetURL *url.URL, record *RequestRecord) {
+	if p.advisories == nil {
+		return
+	}
+
+	route := normalizeRoute(targetURL.String())
+	deprecation := deprecationHeadersOf(header)
+	rateLimit := pickHeaders(header, rateLimitHeaders)
+	now := time.Now()
+
+	p.advisories.mutex.Lock()
+	advisory := p.advisories.routes[route]
+	if advisory == nil {
+		advisory = &routeAdvisory{}
+		p.advisories.routes[route] = advisory
+	}
+	if len(deprecation) > 0 {
+		advisory.deprecation = deprecation
+	}
+	if len(rateLimit) > 0 {
+		advisory.rateLimit = rateLimit
+		advisory.rateLimitSeen = now
+	}
+	learnedDeprecation := advisory.deprecation
+	learnedRateLimit := advisory.rateLimit
+	rateLimitSeen := advisory.rateLimitSeen
+	p.advisories.mutex.Unlock()
+
+	if len(deprecation) == 0 && len(learnedDeprecation) > 0 {
+		for key, values := range learnedDeprecation {
+			for _, value := range values {
+				header.Add(key, value)
+			}
+			record.Advisories = append(record.Advisories, key)
+		}
+	}
+
+	if len(rateLimit) == 0 && len(learnedRateLimit) > 0 && now.Sub(rateLimitSeen) <= rateLimitAdvisoryMaxAge {
+		// Past rate-limit values are only hints, so they are not passed off as current
+		for key, values := range learnedRateLimit {
+			header[learnedRateLimitPrefix+key] = values
+			record.Advisories = append(record.Advisories, learnedRateLimitPrefix+key)
+		}
+		header.Set(learnedRateLimitPrefix+"RateLimit-Observed", rateLimitSeen.UTC().Format(time.RFC3339))
+	}
+}
+
+// deprecationHeadersOf returns the deprecation headers and deprecation-related Links
+func deprecationHeadersOf(header http.Header) http.Header {
+	result := pickHeaders(header, deprecationHeaders)
+	if len(result) == 0 {
+		return result
+	}
+	for _, link := range header.Values("Link") {
+		for _, rel := range deprecationLinkRels {
+			if strings.Contains(strings.ToLower(link), rel) {
+				result.Add("Link", link)
+				break
+			}
+		}
+	}
+	return result
+}
+
+// pickHeaders copies the named headers that are present
+func pickHeaders(header http.Header, names []string) http.Header {
+	result := http.Header{}
+	for _, name := range names {
+		if values := header.Values(name); len(values) > 0 {
+			result[http.CanonicalHeaderKey(name)] = append([]string(nil), values...)
+		}
+	}
+	return result
+}
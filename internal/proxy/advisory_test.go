@@ -0,0 +1,99 @@
+//go:build unit
+
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newAdvisoryUpstream sends deprecation and rate-limit headers on the first request only
+func newAdvisoryUpstream(t *testing.T) *httptest.Server {
+	var calls int32
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			w.Header().Set("Deprecation", "@1704067200")
+			w.Header().Set("Sunset", "Wed, 01 Jan 2025 00:00:00 GMT")
+			w.Header().Add("Link", `<https://api.example.com/v2/users>; rel="successor-version"`)
+			w.Header().Add("Link", `<https://api.example.com/users?page=2>; rel="next"`)
+			w.Header().Set("X-RateLimit-Remaining", "3")
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	t.Cleanup(upstream.Close)
+	return upstream
+}
+
+func TestAdvisoryHeaders(t *testing.T) {
+	upstream := newAdvisoryUpstream(t)
+	p := New(&Config{AdvisoryHeaders: true})
+
+	first := httptest.NewRecorder()
+	p.ServeHTTP(first, httptest.NewRequest(http.MethodGet, upstream.URL+"/users/1", nil))
+	assert.Empty(t, p.history.GetRecords()[0].Advisories, "headers from the upstream itself are not advisories")
+
+	// A different ID normalizes to the same route
+	second := httptest.NewRecorder()
+	p.ServeHTTP(second, httptest.NewRequest(http.MethodGet, upstream.URL+"/users/2", nil))
+
+	assert.Equal(t, "@1704067200", second.Header().Get("Deprecation"))
+	assert.Equal(t, "Wed, 01 Jan 2025 00:00:00 GMT", second.Header().Get("Sunset"))
+	assert.Equal(t, []string{`<https://api.example.com/v2/users>; rel="successor-version"`}, second.Header().Values("Link"))
+
+	assert.Empty(t, second.Header().Get("X-RateLimit-Remaining"), "stale rate limits are not passed off as current")
+	assert.Equal(t, "3", second.Header().Get("X-Netkit-Last-X-Ratelimit-Remaining"))
+	observed, err := time.Parse(time.RFC3339, second.Header().Get("X-Netkit-Last-Ratelimit-Observed"))
+	require.NoError(t, err)
+	assert.WithinDuration(t, time.Now(), observed, time.Minute)
+
+	advisories := p.history.GetRecords()[0].Advisories
+	assert.Contains(t, advisories, "Sunset")
+	assert.Contains(t, advisories, "X-Netkit-Last-X-Ratelimit-Remaining")
+}
+
+func TestAdvisoryHeadersOtherRoute(t *testing.T) {
+	upstream := newAdvisoryUpstream(t)
+	p := New(&Config{AdvisoryHeaders: true})
+
+	p.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, upstream.URL+"/users/1", nil))
+	rec := httptest.NewRecorder()
+	p.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, upstream.URL+"/orders", nil))
+
+	for key := range rec.Header() {
+		assert.False(t, strings.HasPrefix(key, "X-Netkit-Last-"), key)
+	}
+	assert.Empty(t, rec.Header().Get("Sunset"))
+}
+
+func TestAdvisoryHeadersExpiredRateLimit(t *testing.T) {
+	p := New(&Config{AdvisoryHeaders: true})
+	target, err := url.Parse("http://api.example.com/users/1")
+	require.NoError(t, err)
+
+	p.applyAdvisories(http.Header{"Retry-After": {"30"}}, target, &RequestRecord{})
+	p.advisories.routes["api.example.com/users/{id}"].rateLimitSeen = time.Now().Add(-time.Hour)
+
+	header := http.Header{}
+	record := RequestRecord{}
+	p.applyAdvisories(header, target, &record)
+	assert.Empty(t, header)
+	assert.Empty(t, record.Advisories)
+}
+
+func TestAdvisoryHeadersDisabled(t *testing.T) {
+	upstream := newAdvisoryUpstream(t)
+	p := New(&Config{})
+
+	p.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, upstream.URL+"/users/1", nil))
+	rec := httptest.NewRecorder()
+	p.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, upstream.URL+"/users/1", nil))
+	assert.Empty(t, rec.Header().Get("Sunset"))
+}
@@ -0,0 +1,575 @@
+package proxy
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultExtractMaxSize is the largest body extracted unless set otherwise
+const DefaultExtractMaxSize = 100 << 20
+
+// artifactIndexFile lists the extracted artifacts, one JSON object per line
+const artifactIndexFile = "index.ndjson"
+
+// artifactNameReplacer matches the characters not kept in artifact file names
+var artifactNameReplacer = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// ExtractRule saves the bodies of a route's responses of some types to the
+// artifacts directory, e.g. to harvest the reports a system under test
+// generates
+type ExtractRule struct {
+	Route Route
+	Types []string // MIME types such as application/pdf or text/*, or file extensions such as .csv
+}
+
+// ParseExtractRule parses a rule in "route=type[,type...]" form, e.g.
+// "*=application/pdf,.csv" or "reports.example.com/exports=text/*"
+func ParseExtractRule(spec string) (ExtractRule, error) {
+	route, types, ok := strings.Cut(spec, "=")
+	if !ok || strings.TrimSpace(types) == "" {
+		return ExtractRule{}, fmt.Errorf("invalid extract rule %q: expected route=type[,type...]", spec)
+	}
+	rule := ExtractRule{Route: ParseRoute(strings.TrimSpace(route))}
+	for _, t := range strings.Split(types, ",") {
+		t = strings.ToLower(strings.TrimSpace(t))
+		switch {
+		case strings.HasPrefix(t, ".") && len(t) > 1:
+		case strings.Count(t, "/") == 1 && !strings.HasPrefix(t, "/") && !strings.HasSuffix(t, "/"):
+		default:
+			return ExtractRule{}, fmt.Errorf("invalid extract rule %q: %q is not a MIME type such as application/pdf or text/* or an extension such as .csv", spec, t)
+		}
+		rule.Types = append(rule.Types, t)
+	}
+	return rule, nil
+}
+
+// String returns the rule in the form accepted by ParseExtractRule
+func (er ExtractRule) String() string {
+	return er.Route.String() + "=" + strings.Join(er.Types, ",")
+}
+
+// matchesType reports whether a body of the MIME type saved under filename
+// is one of the rule's types
+func (er ExtractRule) matchesType(mediaType, filename string) bool {
+	for _, t := range er.Types {
+		switch {
+		case strings.HasPrefix(t, "."):
+			if strings.EqualFold(filepath.Ext(filename), t) {
+				return true
+			}
+		case strings.HasSuffix(t, "/*"):
+			if strings.HasPrefix(mediaType, strings.TrimSuffix(t, "*")) {
+				return true
+			}
+		case mediaType == t:
+			return true
+		}
+	}
+	return false
+}
+
+// Artifact is a response body extracted to the artifacts directory
+type Artifact struct {
+	ID          string    `json:"id"`
+	RequestID   string    `json:"request_id"`
+	URL         string    `json:"url"`
+	Status      int       `json:"status"`
+	ContentType string    `json:"content_type,omitempty"`
+	Filename    string    `json:"filename"` // File name in the artifacts directory
+	Size        int64     `json:"size"`
+	SHA256      string    `json:"sha256"`
+	ExtractedAt time.Time `json:"extracted_at"`
+}
+
+// artifactStore saves matching response bodies to a directory and keeps an
+// index of them there, so artifacts outlive restarts
+type artifactStore struct {
+	dir       string
+	rules     []ExtractRule
+	maxSize   int64
+	mutex     sync.Mutex
+	artifacts []Artifact // Oldest first
+}
+
+// newArtifactStore opens the artifacts directory, loading the index of the
+// artifacts whose files are still there
+func newArtifactStore(dir string, rules []ExtractRule, maxSize int64) (*artifactStore, error) {
+	if maxSize <= 0 {
+		maxSize = DefaultExtractMaxSize
+	}
+	store := &artifactStore{dir: dir, rules: rules, maxSize: maxSize}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create artifacts directory: %v", err)
+	}
+
+	// Remove bodies whose extraction a previous run did not finish
+	partials, err := filepath.Glob(filepath.Join(dir, ".partial-*"))
+	if err != nil {
+		return nil, err
+	}
+	for _, partial := range partials {
+		_ = os.Remove(partial)
+	}
+
+	file, err := os.Open(filepath.Join(dir, artifactIndexFile))
+	if os.IsNotExist(err) {
+		return store, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var artifact Artifact
+		if err := json.Unmarshal(scanner.Bytes(), &artifact); err != nil {
+			slog.Warn("Skipping unreadable artifact index line", "error", err)
+			continue
+		}
+		if _, err := os.Stat(filepath.Join(dir, artifact.Filename)); err != nil {
+			continue
+		}
+		store.artifacts = append(store.artifacts, artifact)
+	}
+	return store, scanner.Err()
+}
+
+// artifactFilename returns the name a response is saved under: its
+// Content-Disposition filename, the last segment of its path, or "artifact"
+// with an extension for its type
+func artifactFilename(target *url.URL, header http.Header, mediaType string) string {
+	name := ""
+	if _, params, err := mime.ParseMediaType(header.Get("Content-Disposition")); err == nil {
+		name = params["filename"]
+	}
+	if name == "" {
+		if base := path.Base(target.Path); strings.Contains(base, ".") {
+			name = base
+		}
+	}
+	if name == "" {
+		name = "artifact"
+		if extensions, err := mime.ExtensionsByType(mediaType); err == nil && len(extensions) > 0 {
+			name += extensions[0]
+		}
+	}
+	name = strings.Trim(artifactNameReplacer.ReplaceAllString(filepath.Base(name), "_"), "._")
+	if len(name) > 100 {
+		ext := filepath.Ext(name)
+		if len(ext) > 20 {
+			ext = ""
+		}
+		name = name[:100-len(ext)] + ext
+	}
+	if name == "" {
+		name = "artifact"
+	}
+	return name
+}
+
+// extract replaces the body of a response to an extract route with one that
+// saves what is read from it, returning the extraction or nil when the
+// response is not extracted
+func (s *artifactStore) extract(target *url.URL, resp *http.Response, requestID string) *artifactStream {
+	if resp.Body == nil || resp.StatusCode < 200 || resp.StatusCode >= 300 ||
+		resp.StatusCode == http.StatusNoContent || resp.StatusCode == http.StatusPartialContent ||
+		(resp.Request != nil && resp.Request.Method == http.MethodHead) {
+		return nil
+	}
+	if resp.ContentLength > s.maxSize {
+		return nil
+	}
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	mediaType = strings.ToLower(mediaType)
+	filename := artifactFilename(target, resp.Header, mediaType)
+
+	for _, rule := range s.rules {
+		if !rule.Route.Matches(target) || !rule.matchesType(mediaType, filename) {
+			continue
+		}
+		encoding := strings.ToLower(strings.TrimSpace(resp.Header.Get("Content-Encoding")))
+		if encoding != "" && encoding != "identity" && encoding != "gzip" {
+			slog.Warn("Not extracting artifact with unsupported encoding", "url", target.String(), "encoding", encoding)
+			return nil
+		}
+		id := generateID()
+		file, err := os.CreateTemp(s.dir, ".partial-"+id+"-*")
+		if err != nil {
+			slog.Error("Error extracting artifact", "url", target.String(), "error", err)
+			return nil
+		}
+		stream := &artifactStream{
+			store: s,
+			file:  file,
+			gzip:  encoding == "gzip",
+			artifact: Artifact{
+				ID:          id,
+				RequestID:   requestID,
+				URL:         target.String(),
+				Status:      resp.StatusCode,
+				ContentType: resp.Header.Get("Content-Type"),
+				Filename:    id + "-" + filename,
+			},
+		}
+		resp.Body = &artifactBody{ReadCloser: resp.Body, stream: stream}
+		return stream
+	}
+	return nil
+}
+
+// add indexes an extracted artifact
+func (s *artifactStore) add(artifact Artifact) error {
+	data, err := json.Marshal(artifact)
+	if err != nil {
+		return err
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	file, err := os.OpenFile(filepath.Join(s.dir, artifactIndexFile), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(append(data, '\n')); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	s.artifacts = append(s.artifacts, artifact)
+	return nil
+}
+
+// List returns the artifacts, newest first
+func (s *artifactStore) List() []Artifact {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	artifacts := make([]Artifact, len(s.artifacts))
+	for i, artifact := range s.artifacts {
+		artifacts[len(s.artifacts)-1-i] = artifact
+	}
+	return artifacts
+}
+
+// Get returns an artifact by ID
+func (s *artifactStore) Get(id string) (Artifact, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for _, artifact := range s.artifacts {
+		if artifact.ID == id {
+			return artifact, true
+		}
+	}
+	return Artifact{}, false
+}
+
+// Remove deletes the artifacts with the given IDs, or all of them when ids
+// is nil, returning how many were removed
+func (s *artifactStore) Remove(ids []string) (int, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	remove := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		remove[id] = true
+	}
+	var kept []Artifact
+	var index bytes.Buffer
+	removed := 0
+	for _, artifact := range s.artifacts {
+		if ids != nil && !remove[artifact.ID] {
+			data, err := json.Marshal(artifact)
+			if err != nil {
+				return 0, err
+			}
+			index.Write(append(data, '\n'))
+			kept = append(kept, artifact)
+			continue
+		}
+		if err := os.Remove(filepath.Join(s.dir, artifact.Filename)); err != nil && !os.IsNotExist(err) {
+			return removed, err
+		}
+		removed++
+	}
+	if err := writeFileAtomic(filepath.Join(s.dir, artifactIndexFile), index.Bytes(), 0600); err != nil {
+		return removed, err
+	}
+	s.artifacts = kept
+	return removed, nil
+}
+
+// artifactStream writes one response body to a partial file and moves it
+// into place once the body has been read to the end
+type artifactStream struct {
+	store    *artifactStore
+	file     *os.File
+	gzip     bool // The body is gzip-encoded and is decoded when saved
+	size     int64
+	artifact Artifact
+	mutex    sync.Mutex
+	done     bool // Saved or abandoned
+	saved    bool
+}
+
+// write appends a chunk, abandoning bodies that grow too large
+func (s *artifactStream) write(chunk []byte) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.done {
+		return
+	}
+	s.size += int64(len(chunk))
+	if s.size > s.store.maxSize {
+		slog.Warn("Not extracting artifact larger than the limit", "url", s.artifact.URL, "max_size", s.store.maxSize)
+		s.abandon()
+		return
+	}
+	if _, err := s.file.Write(chunk); err != nil {
+		slog.Error("Error extracting artifact", "url", s.artifact.URL, "error", err)
+		s.abandon()
+	}
+}
+
+// finish saves the body once it has been read to the end, or abandons it
+// when it was cut short
+func (s *artifactStream) finish(complete bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.done {
+		return
+	}
+	if !complete || s.size == 0 {
+		s.abandon()
+		return
+	}
+	s.done = true
+	if err := s.save(); err != nil {
+		slog.Error("Error extracting artifact", "url", s.artifact.URL, "error", err)
+		_ = os.Remove(s.file.Name())
+		return
+	}
+	s.saved = true
+	slog.Debug("Extracted artifact", "url", s.artifact.URL, "file", s.artifact.Filename, "size", s.artifact.Size)
+}
+
+// save decodes the partial file into the artifact's file and indexes it
+func (s *artifactStream) save() error {
+	if err := s.file.Close(); err != nil {
+		return err
+	}
+	partial := s.file.Name()
+	defer os.Remove(partial)
+
+	in, err := os.Open(partial)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	var body io.Reader = in
+	if s.gzip {
+		decoded, err := gzip.NewReader(in)
+		if err != nil {
+			return fmt.Errorf("failed to decode gzip body: %v", err)
+		}
+		defer decoded.Close()
+		body = decoded
+	}
+
+	path := filepath.Join(s.store.dir, s.artifact.Filename)
+	out, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	hash := sha256.New()
+	size, err := io.Copy(io.MultiWriter(out, hash), io.LimitReader(body, s.store.maxSize+1))
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil && size > s.store.maxSize {
+		err = fmt.Errorf("decoded body is larger than %d bytes", s.store.maxSize)
+	}
+	if err != nil {
+		_ = os.Remove(path)
+		return err
+	}
+
+	s.artifact.Size = size
+	s.artifact.SHA256 = hex.EncodeToString(hash.Sum(nil))
+	s.artifact.ExtractedAt = time.Now()
+	if err := s.store.add(s.artifact); err != nil {
+		_ = os.Remove(path)
+		return err
+	}
+	return nil
+}
+
+// abandon removes the partial file. Callers hold the mutex.
+func (s *artifactStream) abandon() {
+	s.done = true
+	s.file.Close()
+	_ = os.Remove(s.file.Name())
+}
+
+// id returns the ID of the saved artifact, or "" when it was not saved
+func (s *artifactStream) id() string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if !s.saved {
+		return ""
+	}
+	return s.artifact.ID
+}
+
+// artifactBody saves a response body to its artifact as it is read
+type artifactBody struct {
+	io.ReadCloser
+	stream *artifactStream
+}
+
+func (b *artifactBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if n > 0 {
+		b.stream.write(p[:n])
+	}
+	if err != nil {
+		b.stream.finish(err == io.EOF)
+	}
+	return n, err
+}
+
+func (b *artifactBody) Close() error {
+	b.stream.finish(false)
+	return b.ReadCloser.Close()
+}
+
+// handleArtifacts lists the extracted artifacts or removes them all
+func (p *Proxy) handleArtifacts(w http.ResponseWriter, r *http.Request) {
+	// Add CORS headers
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "GET, DELETE, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, Cache-Control, Pragma, Expires")
+
+	// Handle preflight requests
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	if p.artifacts == nil {
+		http.Error(w, "Artifact extraction is not configured, start netkit with --extract and --extract-dir", http.StatusNotImplemented)
+		return
+	}
+
+	var response interface{}
+	switch r.Method {
+	case http.MethodGet:
+		artifacts := p.artifacts.List()
+		if requestID := r.URL.Query().Get("request_id"); requestID != "" {
+			var matching []Artifact
+			for _, artifact := range artifacts {
+				if artifact.RequestID == requestID {
+					matching = append(matching, artifact)
+				}
+			}
+			artifacts = matching
+		}
+		if artifacts == nil {
+			artifacts = []Artifact{}
+		}
+		response = map[string]interface{}{"artifacts": artifacts}
+	case http.MethodDelete:
+		removed, err := p.artifacts.Remove(nil)
+		if err != nil {
+			http.Error(w, "Failed to remove artifacts: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		response = map[string]interface{}{"removed": removed}
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	data, err := json.Marshal(response)
+	if err != nil {
+		http.Error(w, "Failed to encode artifacts", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if _, err := w.Write(data); err != nil {
+		slog.Warn("Error writing artifacts response", "error", err)
+	}
+}
+
+// handleArtifact downloads or removes one artifact at /artifacts/{id}
+func (p *Proxy) handleArtifact(w http.ResponseWriter, r *http.Request) {
+	// Add CORS headers
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "GET, DELETE, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, Cache-Control, Pragma, Expires")
+
+	// Handle preflight requests
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	if p.artifacts == nil {
+		http.Error(w, "Artifact extraction is not configured, start netkit with --extract and --extract-dir", http.StatusNotImplemented)
+		return
+	}
+	artifact, ok := p.artifacts.Get(strings.TrimPrefix(r.URL.Path, "/artifacts/"))
+	if !ok {
+		http.Error(w, "Artifact not found", http.StatusNotFound)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		file, err := os.Open(filepath.Join(p.artifacts.dir, artifact.Filename))
+		if err != nil {
+			http.Error(w, "Artifact not found", http.StatusNotFound)
+			return
+		}
+		defer file.Close()
+		if artifact.ContentType != "" {
+			w.Header().Set("Content-Type", artifact.ContentType)
+		}
+		w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": artifact.Filename}))
+		w.Header().Set("Content-Length", strconv.FormatInt(artifact.Size, 10))
+		w.WriteHeader(http.StatusOK)
+		if _, err := io.Copy(w, file); err != nil {
+			slog.Warn("Error writing artifact response", "error", err)
+		}
+	case http.MethodDelete:
+		if _, err := p.artifacts.Remove([]string{artifact.ID}); err != nil {
+			http.Error(w, "Failed to remove artifact: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		if _, err := w.Write([]byte(`{"success":true,"message":"Artifact deleted"}`)); err != nil {
+			slog.Warn("Error writing artifact response", "error", err)
+		}
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
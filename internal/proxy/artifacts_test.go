@@ -0,0 +1,170 @@
+//go:build unit
+
+package proxy
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// artifactTestURL parses a URL for a test
+func artifactTestURL(t *testing.T, raw string) *url.URL {
+	t.Helper()
+	u, err := url.Parse(raw)
+	require.NoError(t, err)
+	return u
+}
+
+func TestParseExtractRule(t *testing.T) {
+	rule, err := ParseExtractRule("reports.example.com/exports=Application/PDF, text/*,.csv")
+	require.NoError(t, err)
+	assert.Equal(t, ExtractRule{Route: Route{Host: "reports.example.com", PathPrefix: "/exports"}, Types: []string{"application/pdf", "text/*", ".csv"}}, rule)
+	assert.Equal(t, "reports.example.com/exports=application/pdf,text/*,.csv", rule.String())
+
+	assert.True(t, rule.matchesType("application/pdf", "invoice.pdf"))
+	assert.True(t, rule.matchesType("text/plain", "notes.txt"))
+	assert.True(t, rule.matchesType("application/octet-stream", "DATA.CSV"))
+	assert.False(t, rule.matchesType("application/json", "data.json"))
+
+	for _, spec := range []string{"*", "*=", "*=pdf", "*=.", "*=application/", "*=/pdf"} {
+		_, err := ParseExtractRule(spec)
+		assert.Error(t, err, spec)
+	}
+}
+
+func TestArtifactFilename(t *testing.T) {
+	target := artifactTestURL(t, "http://reports.example.com/exports/42")
+	header := http.Header{"Content-Disposition": {`attachment; filename="../Q3 report.pdf"`}}
+	assert.Equal(t, "Q3_report.pdf", artifactFilename(target, header, "application/pdf"))
+
+	assert.Equal(t, "data.csv", artifactFilename(artifactTestURL(t, "http://reports.example.com/data.csv"), http.Header{}, "text/csv"))
+	assert.Equal(t, "artifact.pdf", artifactFilename(target, http.Header{}, "application/pdf"))
+}
+
+func TestProxyExtractArtifacts(t *testing.T) {
+	var gzipped bytes.Buffer
+	writer := gzip.NewWriter(&gzipped)
+	_, _ = writer.Write([]byte("a,b\n1,2\n"))
+	require.NoError(t, writer.Close())
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/report":
+			w.Header().Set("Content-Type", "application/pdf")
+			w.Header().Set("Content-Disposition", `attachment; filename="report.pdf"`)
+			_, _ = w.Write([]byte("%PDF-1.4"))
+		case "/data.csv":
+			w.Header().Set("Content-Type", "text/csv")
+			w.Header().Set("Content-Encoding", "gzip")
+			_, _ = w.Write(gzipped.Bytes())
+		case "/missing.pdf":
+			w.Header().Set("Content-Type", "application/pdf")
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = w.Write([]byte("not found"))
+		default:
+			w.Header().Set("Content-Type", "text/html")
+			_, _ = w.Write([]byte("<html></html>"))
+		}
+	}))
+	defer upstream.Close()
+
+	dir := t.TempDir()
+	rule, err := ParseExtractRule("*=application/pdf,.csv")
+	require.NoError(t, err)
+	p := New(&Config{ExtractRules: []ExtractRule{rule}, ExtractDir: dir})
+
+	for _, target := range []string{"/report", "/data.csv", "/missing.pdf", "/index.html"} {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, upstream.URL+target, nil)
+		req.Header.Set("Accept-Encoding", "gzip")
+		p.ServeHTTP(rec, req)
+		if target == "/report" {
+			assert.Equal(t, "%PDF-1.4", rec.Body.String(), "the client gets the body as usual")
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	p.adminServer.Handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/artifacts", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	var listed struct {
+		Artifacts []Artifact `json:"artifacts"`
+	}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &listed))
+	require.Len(t, listed.Artifacts, 2)
+	csv, pdf := listed.Artifacts[0], listed.Artifacts[1]
+	assert.True(t, strings.HasSuffix(pdf.Filename, "-report.pdf"), pdf.Filename)
+	assert.Equal(t, int64(8), csv.Size, "gzip bodies are saved decoded")
+	data, err := os.ReadFile(filepath.Join(dir, csv.Filename))
+	require.NoError(t, err)
+	assert.Equal(t, "a,b\n1,2\n", string(data))
+
+	// History links each record to its artifact
+	records := p.history.GetRecords()
+	artifactIDs := map[string]string{}
+	for _, record := range records {
+		artifactIDs[record.ID] = record.ArtifactID
+	}
+	assert.Equal(t, pdf.ID, artifactIDs[pdf.RequestID])
+	assert.Equal(t, csv.ID, artifactIDs[csv.RequestID])
+
+	rec = httptest.NewRecorder()
+	p.adminServer.Handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/artifacts/"+pdf.ID, nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "%PDF-1.4", rec.Body.String())
+	assert.Equal(t, "application/pdf", rec.Header().Get("Content-Type"))
+
+	// The index survives a restart
+	reopened := New(&Config{ExtractRules: []ExtractRule{rule}, ExtractDir: dir})
+	assert.Len(t, reopened.artifacts.List(), 2)
+
+	rec = httptest.NewRecorder()
+	p.adminServer.Handler.ServeHTTP(rec, httptest.NewRequest(http.MethodDelete, "/artifacts/"+pdf.ID, nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.NoFileExists(t, filepath.Join(dir, pdf.Filename))
+	rec = httptest.NewRecorder()
+	p.adminServer.Handler.ServeHTTP(rec, httptest.NewRequest(http.MethodDelete, "/artifacts", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.JSONEq(t, `{"removed": 1}`, rec.Body.String())
+	assert.Empty(t, New(&Config{ExtractRules: []ExtractRule{rule}, ExtractDir: dir}).artifacts.List())
+
+	rec = httptest.NewRecorder()
+	New(&Config{}).adminServer.Handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/artifacts", nil))
+	assert.Equal(t, http.StatusNotImplemented, rec.Code)
+}
+
+func TestArtifactLimitAndCutShortBodies(t *testing.T) {
+	dir := t.TempDir()
+	rule, err := ParseExtractRule("*=.bin")
+	require.NoError(t, err)
+	store, err := newArtifactStore(dir, []ExtractRule{rule}, 4)
+	require.NoError(t, err)
+
+	for _, body := range []string{"12345", "123"} {
+		resp := &http.Response{StatusCode: http.StatusOK, Header: http.Header{}, ContentLength: -1, Body: io.NopCloser(strings.NewReader(body))}
+		stream := store.extract(artifactTestURL(t, "http://files.example.com/blob.bin"), resp, "req")
+		require.NotNil(t, stream)
+		if body == "123" {
+			// Closed before the end, e.g. the client went away
+			require.NoError(t, resp.Body.Close())
+		} else {
+			_, _ = io.ReadAll(resp.Body)
+		}
+		assert.Empty(t, stream.id())
+	}
+	assert.Empty(t, store.List())
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Empty(t, entries, "partial files are removed")
+}
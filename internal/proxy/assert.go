@@ -0,0 +1,210 @@
+package proxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Assertion is the body of POST /requests/assert: a matcher for history
+// records and how many of them are expected
+type Assertion struct {
+	Method       string `json:"method,omitempty"`
+	URL          string `json:"url,omitempty"`           // Glob where * matches anything; patterns starting with / match the path only
+	BodyContains string `json:"body_contains,omitempty"` // Substring of the request body
+	Query        string `json:"query,omitempty"`         // History filter parameters, e.g. status=5xx
+	Run          string `json:"run,omitempty"`           // Only records of this test run
+	Within       string `json:"within,omitempty"`        // Trailing duration or start/end window (default: all of history)
+
+	Count    *int `json:"count,omitempty"` // Exact number of matches
+	MinCount *int `json:"min_count,omitempty"`
+	MaxCount *int `json:"max_count,omitempty"` // Without any count, at least one match is expected
+}
+
+// AssertionResult reports whether an assertion held
+type AssertionResult struct {
+	Passed   bool            `json:"passed"`
+	Count    int             `json:"count"`
+	Expected string          `json:"expected"` // e.g. "exactly 2"
+	Message  string          `json:"message"`
+	Records  []RequestRecord `json:"records"` // Matching records, most recent first
+}
+
+// compiledAssertion is an assertion with its patterns parsed
+type compiledAssertion struct {
+	Assertion
+	filter     *RequestFilter
+	url        *regexp.Regexp
+	start, end time.Time
+}
+
+// compileAssertion validates an assertion and parses its patterns
+func compileAssertion(assertion Assertion, now time.Time) (*compiledAssertion, error) {
+	compiled := &compiledAssertion{Assertion: assertion}
+	for name, count := range map[string]*int{"count": assertion.Count, "min_count": assertion.MinCount, "max_count": assertion.MaxCount} {
+		if count != nil && *count < 0 {
+			return nil, fmt.Errorf("%s must not be negative", name)
+		}
+	}
+	if assertion.Count != nil && (assertion.MinCount != nil || assertion.MaxCount != nil) {
+		return nil, fmt.Errorf("count cannot be combined with min_count or max_count")
+	}
+
+	values, err := url.ParseQuery(strings.TrimPrefix(assertion.Query, "?"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid query: %v", err)
+	}
+	if compiled.filter, err = ParseRequestFilter(values, true); err != nil {
+		return nil, err
+	}
+	if assertion.URL != "" {
+		compiled.url = globPattern(assertion.URL)
+	}
+	if assertion.Within != "" {
+		if compiled.start, compiled.end, err = parseReportWindow(assertion.Within, 0, now); err != nil {
+			return nil, fmt.Errorf("invalid within: %v", err)
+		}
+	}
+	return compiled, nil
+}
+
+// globPattern turns a glob where * matches any run of characters into an
+// anchored regular expression
+func globPattern(glob string) *regexp.Regexp {
+	parts := strings.Split(glob, "*")
+	for i, part := range parts {
+		parts[i] = regexp.QuoteMeta(part)
+	}
+	return regexp.MustCompile("^" + strings.Join(parts, ".*") + "$")
+}
+
+// Matches reports whether a record satisfies the matcher
+func (a *compiledAssertion) Matches(record RequestRecord) bool {
+	if a.Method != "" && !strings.EqualFold(a.Method, record.Method) {
+		return false
+	}
+	if a.Run != "" && record.RunID != a.Run {
+		return false
+	}
+	if !a.start.IsZero() && (record.Timestamp.Before(a.start) || !record.Timestamp.Before(a.end)) {
+		return false
+	}
+	if a.BodyContains != "" && !strings.Contains(record.RequestBody, a.BodyContains) {
+		return false
+	}
+	if a.url != nil {
+		target := record.URL
+		if strings.HasPrefix(a.URL, "/") {
+			components := record.URLComponents
+			if components == nil {
+				components = ParseURLComponents(record.URL)
+			}
+			if components == nil {
+				return false
+			}
+			target = components.Path
+		}
+		if !a.url.MatchString(target) {
+			return false
+		}
+	}
+	return a.filter.Matches(record)
+}
+
+// expected describes the expected number of matches
+func (a *compiledAssertion) expected() string {
+	switch {
+	case a.Count != nil:
+		return fmt.Sprintf("exactly %d", *a.Count)
+	case a.MinCount != nil && a.MaxCount != nil:
+		return fmt.Sprintf("between %d and %d", *a.MinCount, *a.MaxCount)
+	case a.MaxCount != nil:
+		return fmt.Sprintf("at most %d", *a.MaxCount)
+	case a.MinCount != nil:
+		return fmt.Sprintf("at least %d", *a.MinCount)
+	default:
+		return "at least 1"
+	}
+}
+
+// holds reports whether a number of matches meets the expected count
+func (a *compiledAssertion) holds(count int) bool {
+	switch {
+	case a.Count != nil:
+		return count == *a.Count
+	case a.MinCount == nil && a.MaxCount == nil:
+		return count > 0
+	}
+	return (a.MinCount == nil || count >= *a.MinCount) && (a.MaxCount == nil || count <= *a.MaxCount)
+}
+
+// Evaluate checks an assertion against history records
+func (a *compiledAssertion) Evaluate(records []RequestRecord) AssertionResult {
+	result := AssertionResult{Expected: a.expected(), Records: make([]RequestRecord, 0)}
+	for _, record := range records {
+		if a.Matches(record) {
+			result.Records = append(result.Records, record)
+		}
+	}
+	result.Count = len(result.Records)
+	result.Passed = a.holds(result.Count)
+	if result.Passed {
+		result.Message = fmt.Sprintf("expected %s matching requests and found %d", result.Expected, result.Count)
+	} else {
+		result.Message = fmt.Sprintf("expected %s matching requests but found %d", result.Expected, result.Count)
+	}
+	return result
+}
+
+// handleRequestAssert checks history against a matcher for test suites
+func (p *Proxy) handleRequestAssert(w http.ResponseWriter, r *http.Request) {
+	// Add CORS headers
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, Cache-Control, Pragma, Expires")
+
+	// Handle preflight requests
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var assertion Assertion
+	if err := json.NewDecoder(r.Body).Decode(&assertion); err != nil {
+		http.Error(w, "Invalid assertion JSON", http.StatusBadRequest)
+		return
+	}
+	compiled, err := compileAssertion(assertion, time.Now())
+	if err != nil {
+		http.Error(w, "Invalid assertion: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if assertion.Run != "" {
+		if _, ok := p.runs.Get(assertion.Run); !ok {
+			http.Error(w, "Run not found", http.StatusNotFound)
+			return
+		}
+	}
+
+	data, err := json.Marshal(compiled.Evaluate(p.history.GetRecords()))
+	if err != nil {
+		http.Error(w, "Failed to encode assertion result", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if _, err := w.Write(data); err != nil {
+		slog.Warn("Error writing assertion response", "error", err)
+	}
+}
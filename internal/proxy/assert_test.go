@@ -0,0 +1,71 @@
+//go:build unit
+
+package proxy
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func assertCall(p *Proxy, body string) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	p.handleRequestAssert(rec, httptest.NewRequest(http.MethodPost, "/requests/assert", strings.NewReader(body)))
+	return rec
+}
+
+func TestRequestAssert(t *testing.T) {
+	p := New(&Config{})
+	now := time.Now()
+	p.history.restore([]RequestRecord{
+		{ID: "3", Method: http.MethodPost, URL: "http://api.example.com/orders?id=2", RequestBody: `{"sku": "b"}`, ResponseStatus: 500, Timestamp: now},
+		{ID: "2", Method: http.MethodPost, URL: "http://api.example.com/orders?id=1", RequestBody: `{"sku": "a"}`, ResponseStatus: 201, Timestamp: now.Add(-time.Minute)},
+		{ID: "1", Method: http.MethodGet, URL: "http://api.example.com/users/1", ResponseStatus: 200, Timestamp: now.Add(-time.Hour)},
+	})
+
+	evaluate := func(body string) AssertionResult {
+		t.Helper()
+		rec := assertCall(p, body)
+		require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+		var result AssertionResult
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &result))
+		return result
+	}
+
+	result := evaluate(`{"method": "post", "url": "http://api.example.com/orders*", "count": 2}`)
+	assert.True(t, result.Passed, result.Message)
+	assert.Equal(t, "exactly 2", result.Expected)
+	require.Len(t, result.Records, 2)
+	assert.Equal(t, "3", result.Records[0].ID)
+
+	result = evaluate(`{"url": "/orders", "body_contains": "\"sku\": \"a\"", "count": 2}`)
+	assert.False(t, result.Passed)
+	assert.Equal(t, 1, result.Count)
+	assert.Equal(t, "expected exactly 2 matching requests but found 1", result.Message)
+
+	// Without a count, one match is enough
+	assert.True(t, evaluate(`{"url": "/users/*"}`).Passed)
+	assert.False(t, evaluate(`{"url": "/users/*", "within": "10m"}`).Passed)
+	assert.True(t, evaluate(`{"query": "status=5xx", "max_count": 1}`).Passed)
+	assert.False(t, evaluate(`{"method": "POST", "min_count": 1, "max_count": 1}`).Passed)
+	assert.True(t, evaluate(`{"method": "DELETE", "count": 0}`).Passed)
+
+	assert.Equal(t, http.StatusBadRequest, assertCall(p, `{"count": 1, "min_count": 1}`).Code)
+	assert.Equal(t, http.StatusBadRequest, assertCall(p, `{"query": "bogus=1"}`).Code)
+	assert.Equal(t, http.StatusBadRequest, assertCall(p, `{"within": "soon"}`).Code)
+	assert.Equal(t, http.StatusNotFound, assertCall(p, `{"run": "missing"}`).Code)
+
+	run := p.runs.Create("ci")
+	assert.True(t, evaluate(`{"run": "`+run.ID+`", "count": 0}`).Passed)
+}
+
+func TestAssertScope(t *testing.T) {
+	req := httptest.NewRequest(http.MethodPost, "/requests/assert", nil)
+	assert.Equal(t, ScopeRead, requiredScope(req))
+}
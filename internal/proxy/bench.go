@@ -0,0 +1,297 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/url"
+	"runtime"
+	"time"
+)
+
+// Feature sets measured by RunSelfBench
+const (
+	BenchDirect     = "direct"      // Client straight to the upstream, the baseline
+	BenchProxy      = "proxy"       // Forward proxy with default settings and full capture
+	BenchCaptureOff = "capture-off" // Forward proxy with capture paused
+	BenchRules      = "rules"       // Reverse proxy picking among routes, with a throttle rule to check
+	BenchTLS        = "tls"         // Reverse proxy terminating TLS
+)
+
+// BenchScenarios are the feature sets in the order they are measured
+var BenchScenarios = []string{BenchDirect, BenchProxy, BenchCaptureOff, BenchRules, BenchTLS}
+
+// benchDescriptions explain each feature set in reports
+var benchDescriptions = map[string]string{
+	BenchDirect:     "no proxy (baseline)",
+	BenchProxy:      "forward proxy, full capture",
+	BenchCaptureOff: "forward proxy, capture paused",
+	BenchRules:      "reverse proxy, 10 routes and a throttle rule",
+	BenchTLS:        "reverse proxy terminating TLS",
+}
+
+// BenchOptions configures RunSelfBench
+type BenchOptions struct {
+	Scenarios []string // Feature sets to measure besides the direct baseline (default: all)
+	Requests  int      // Measured requests per feature set
+	Warmup    int      // Unmeasured requests sent first, to open connections and fill caches
+	BodySize  int      // Bytes in each upstream response body
+}
+
+// BenchResult is the latency and allocations of one feature set
+type BenchResult struct {
+	Scenario    string  `json:"scenario"`
+	Description string  `json:"description"`
+	MeanUs      int64   `json:"mean_us"`
+	P50Us       int64   `json:"p50_us"`
+	P90Us       int64   `json:"p90_us"`
+	P99Us       int64   `json:"p99_us"`
+	AddedP50Us  int64   `json:"added_p50_us"`  // P50 above the direct baseline
+	AddedMeanUs int64   `json:"added_mean_us"` // Mean above the direct baseline
+	Allocs      float64 `json:"allocs_per_request"`
+	Bytes       float64 `json:"bytes_per_request"`
+	AddedAllocs float64 `json:"added_allocs_per_request"` // Allocations above the direct baseline
+	AddedBytes  float64 `json:"added_bytes_per_request"`
+}
+
+// BenchReport is the outcome of RunSelfBench
+type BenchReport struct {
+	GoVersion string        `json:"go_version"`
+	OS        string        `json:"os"`
+	Arch      string        `json:"arch"`
+	CPUs      int           `json:"cpus"`
+	Requests  int           `json:"requests"`
+	BodySize  int           `json:"body_size"`
+	Results   []BenchResult `json:"results"` // The direct baseline first
+}
+
+// ValidateBenchScenario checks a feature set name
+func ValidateBenchScenario(scenario string) error {
+	if _, ok := benchDescriptions[scenario]; !ok {
+		return fmt.Errorf("unknown scenario %q (expected direct, proxy, capture-off, rules, or tls)", scenario)
+	}
+	return nil
+}
+
+// RunSelfBench runs a local upstream and sends sequential requests to it
+// through in-process proxies with different feature sets, measuring the
+// latency and allocations each adds over requests sent straight to the
+// upstream. Allocations count the whole process, client and upstream
+// included, so only the added ones are the proxy's.
+func RunSelfBench(ctx context.Context, options BenchOptions) (BenchReport, error) {
+	if options.Requests <= 0 {
+		return BenchReport{}, fmt.Errorf("requests must be above 0")
+	}
+	scenarios := options.Scenarios
+	if len(scenarios) == 0 {
+		scenarios = BenchScenarios
+	}
+	for _, scenario := range scenarios {
+		if err := ValidateBenchScenario(scenario); err != nil {
+			return BenchReport{}, err
+		}
+	}
+
+	body := bytes.Repeat([]byte("x"), options.BodySize)
+	upstreamListener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return BenchReport{}, err
+	}
+	upstream := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.Copy(io.Discard, r.Body)
+		w.Header().Set("Content-Type", "text/plain")
+		_, _ = w.Write(body)
+	})}
+	go func() { _ = upstream.Serve(upstreamListener) }()
+	defer upstream.Close()
+	upstreamURL := "http://" + upstreamListener.Addr().String()
+
+	report := BenchReport{
+		GoVersion: runtime.Version(),
+		OS:        runtime.GOOS,
+		Arch:      runtime.GOARCH,
+		CPUs:      runtime.NumCPU(),
+		Requests:  options.Requests,
+		BodySize:  options.BodySize,
+	}
+	baseline, err := runBenchScenario(ctx, BenchDirect, upstreamURL, options)
+	if err != nil {
+		return BenchReport{}, fmt.Errorf("%s: %v", BenchDirect, err)
+	}
+	report.Results = append(report.Results, baseline)
+	for _, scenario := range scenarios {
+		if scenario == BenchDirect {
+			continue
+		}
+		result, err := runBenchScenario(ctx, scenario, upstreamURL, options)
+		if err != nil {
+			return BenchReport{}, fmt.Errorf("%s: %v", scenario, err)
+		}
+		result.AddedP50Us = result.P50Us - baseline.P50Us
+		result.AddedMeanUs = result.MeanUs - baseline.MeanUs
+		result.AddedAllocs = result.Allocs - baseline.Allocs
+		result.AddedBytes = result.Bytes - baseline.Bytes
+		report.Results = append(report.Results, result)
+	}
+	return report, nil
+}
+
+// runBenchScenario measures one feature set
+func runBenchScenario(ctx context.Context, scenario, upstreamURL string, options BenchOptions) (BenchResult, error) {
+	transport := &http.Transport{MaxIdleConnsPerHost: 1, DisableCompression: true}
+	client := &http.Client{Transport: transport}
+	defer transport.CloseIdleConnections()
+	target := upstreamURL + "/bench"
+
+	if scenario != BenchDirect {
+		config := &Config{}
+		switch scenario {
+		case BenchRules:
+			for i := 0; i < 9; i++ {
+				route, err := ParseReverseRoute(fmt.Sprintf("/service-%d/=%s", i, upstreamURL))
+				if err != nil {
+					return BenchResult{}, err
+				}
+				config.ReverseRoutes = append(config.ReverseRoutes, route)
+			}
+			rule, err := ParseThrottleRule("X-Bench-Client=batch-*:delay=1s")
+			if err != nil {
+				return BenchResult{}, err
+			}
+			config.ThrottleRules = []ThrottleRule{rule}
+			fallthrough
+		case BenchTLS:
+			route, err := ParseReverseRoute("/=" + upstreamURL)
+			if err != nil {
+				return BenchResult{}, err
+			}
+			config.ReverseRoutes = append(config.ReverseRoutes, route)
+		}
+		p := New(config)
+		defer func() { _ = p.Stop() }()
+		if scenario == BenchCaptureOff {
+			if _, err := p.capture.Pause(CapturePause{Mode: CaptureOff, Reason: "netkit bench self"}); err != nil {
+				return BenchResult{}, err
+			}
+		}
+
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			return BenchResult{}, err
+		}
+		proxyURL := &url.URL{Scheme: "http", Host: ln.Addr().String()}
+		if scenario == BenchTLS {
+			serverConfig, clientConfig, err := benchTLSConfigs()
+			if err != nil {
+				_ = ln.Close()
+				return BenchResult{}, err
+			}
+			ln = tls.NewListener(ln, serverConfig)
+			transport.TLSClientConfig = clientConfig
+			proxyURL.Scheme = "https"
+		}
+		server := &http.Server{Handler: p}
+		go func() { _ = server.Serve(ln) }()
+		defer server.Close()
+
+		switch scenario {
+		case BenchProxy, BenchCaptureOff:
+			transport.Proxy = http.ProxyURL(proxyURL)
+		default:
+			target = proxyURL.String() + "/bench"
+		}
+	}
+
+	send := func() error {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+		if err != nil {
+			return err
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(io.Discard, resp.Body)
+		if closeErr := resp.Body.Close(); err == nil {
+			err = closeErr
+		}
+		if err == nil && resp.StatusCode != http.StatusOK {
+			err = fmt.Errorf("unexpected status %d", resp.StatusCode)
+		}
+		return err
+	}
+	for i := 0; i < options.Warmup; i++ {
+		if err := send(); err != nil {
+			return BenchResult{}, err
+		}
+	}
+
+	durations := make([]int64, options.Requests)
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+	var total int64
+	for i := range durations {
+		start := time.Now()
+		if err := send(); err != nil {
+			if errors.Is(err, context.Canceled) {
+				return BenchResult{}, fmt.Errorf("interrupted")
+			}
+			return BenchResult{}, err
+		}
+		durations[i] = time.Since(start).Microseconds()
+		total += durations[i]
+	}
+	runtime.ReadMemStats(&after)
+
+	requests := float64(options.Requests)
+	return BenchResult{
+		Scenario:    scenario,
+		Description: benchDescriptions[scenario],
+		MeanUs:      total / int64(options.Requests),
+		P50Us:       percentile(durations, 50),
+		P90Us:       percentile(durations, 90),
+		P99Us:       percentile(durations, 99),
+		Allocs:      float64(after.Mallocs-before.Mallocs) / requests,
+		Bytes:       float64(after.TotalAlloc-before.TotalAlloc) / requests,
+	}, nil
+}
+
+// benchTLSConfigs returns a server config with a throwaway self-signed
+// certificate for 127.0.0.1 and a client config trusting it
+func benchTLSConfigs() (*tls.Config, *tls.Config, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "netkit bench"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, nil, err
+	}
+	certificate, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, nil, err
+	}
+	roots := x509.NewCertPool()
+	roots.AddCert(certificate)
+	server := &tls.Config{Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}}}
+	return server, &tls.Config{RootCAs: roots}, nil
+}
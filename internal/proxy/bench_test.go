@@ -0,0 +1,38 @@
+//go:build unit
+
+package proxy
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRunSelfBench(t *testing.T) {
+	report, err := RunSelfBench(context.Background(), BenchOptions{Requests: 5, Warmup: 1, BodySize: 64})
+	require.NoError(t, err)
+	require.Len(t, report.Results, len(BenchScenarios))
+	for i, result := range report.Results {
+		assert.Equal(t, BenchScenarios[i], result.Scenario)
+		assert.Positive(t, result.P50Us)
+		assert.GreaterOrEqual(t, result.P99Us, result.P50Us)
+		assert.Positive(t, result.Allocs)
+	}
+	assert.Zero(t, report.Results[0].AddedP50Us, "the direct baseline adds nothing")
+	assert.Equal(t, report.Results[1].P50Us-report.Results[0].P50Us, report.Results[1].AddedP50Us)
+}
+
+func TestRunSelfBenchScenarios(t *testing.T) {
+	report, err := RunSelfBench(context.Background(), BenchOptions{Scenarios: []string{BenchTLS}, Requests: 2})
+	require.NoError(t, err)
+	require.Len(t, report.Results, 2, "the direct baseline always runs")
+	assert.Equal(t, BenchDirect, report.Results[0].Scenario)
+	assert.Equal(t, BenchTLS, report.Results[1].Scenario)
+
+	_, err = RunSelfBench(context.Background(), BenchOptions{Scenarios: []string{"cache"}, Requests: 2})
+	assert.Error(t, err)
+	_, err = RunSelfBench(context.Background(), BenchOptions{})
+	assert.Error(t, err)
+}
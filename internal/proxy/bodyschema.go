@@ -0,0 +1,122 @@
+package proxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/biancarosa/netkit/internal/schema"
+)
+
+// BodySchema decodes binary request/response bodies on a route using a
+// user-supplied .proto or .thrift schema
+type BodySchema struct {
+	Route        Route
+	File         string
+	RequestType  string
+	ResponseType string
+
+	requestDecoder  schema.Decoder
+	responseDecoder schema.Decoder
+}
+
+// ParseBodySchema parses a body schema in "route=file:RequestType[,ResponseType]" form
+// and loads the schema file, e.g. "api.example.com/rpc=users.proto:GetUserRequest,User".
+// For Thrift, a service name may be given instead of struct names to decode full
+// message envelopes.
+func ParseBodySchema(spec string) (BodySchema, error) {
+	route, rest, ok := strings.Cut(spec, "=")
+	if !ok {
+		return BodySchema{}, fmt.Errorf("invalid body schema %q: expected route=file:type", spec)
+	}
+
+	i := strings.LastIndex(rest, ":")
+	if i <= 0 || i == len(rest)-1 {
+		return BodySchema{}, fmt.Errorf("invalid body schema %q: expected route=file:type", spec)
+	}
+	file, types := rest[:i], rest[i+1:]
+
+	requestType, responseType, _ := strings.Cut(types, ",")
+	if responseType == "" {
+		responseType = requestType
+	}
+
+	s := BodySchema{
+		Route:        ParseRoute(route),
+		File:         file,
+		RequestType:  requestType,
+		ResponseType: responseType,
+	}
+	if err := s.load(); err != nil {
+		return BodySchema{}, err
+	}
+	return s, nil
+}
+
+func (s *BodySchema) load() error {
+	f, err := schema.LoadFile(s.File)
+	if err != nil {
+		return err
+	}
+	if s.requestDecoder, err = f.Decoder(s.RequestType); err != nil {
+		return fmt.Errorf("schema %s: %v", s.File, err)
+	}
+	if s.responseDecoder, err = f.Decoder(s.ResponseType); err != nil {
+		return fmt.Errorf("schema %s: %v", s.File, err)
+	}
+	return nil
+}
+
+// decodeBodies decodes the captured bodies using the first body schema matching the target
+func (p *Proxy) decodeBodies(record *RequestRecord, target *url.URL) {
+	for _, s := range p.currentConfig().BodySchemas {
+		if !s.Route.Matches(target) || s.requestDecoder == nil {
+			continue
+		}
+
+		var errs []string
+		if record.RequestBody != "" {
+			decoded, err := decodeBody(s.requestDecoder, record.RequestBody, record.GRPCMethod != "")
+			if err != nil {
+				errs = append(errs, "request: "+err.Error())
+			}
+			record.RequestBodyDecoded = decoded
+		}
+		if record.ResponseBody != "" {
+			decoded, err := decodeBody(s.responseDecoder, record.ResponseBody, record.GRPCMethod != "")
+			if err != nil {
+				errs = append(errs, "response: "+err.Error())
+			}
+			record.ResponseBodyDecoded = decoded
+		}
+		record.BodyDecodeError = strings.Join(errs, "; ")
+		return
+	}
+}
+
+// decodeBody decodes a binary body into JSON. gRPC bodies are split into their
+// length-prefixed messages and decoded into a JSON array.
+func decodeBody(decoder schema.Decoder, body string, grpc bool) (json.RawMessage, error) {
+	if !grpc {
+		value, err := decoder.Decode([]byte(body))
+		if err != nil {
+			return nil, err
+		}
+		return json.Marshal(value)
+	}
+
+	frames, err := splitGRPCFrames([]byte(body))
+	if err != nil {
+		return nil, err
+	}
+	messages := make([]interface{}, 0, len(frames))
+	for _, frame := range frames {
+		value, err := decoder.Decode(frame)
+		if err != nil {
+			return nil, err
+		}
+		messages = append(messages, value)
+	}
+	return json.Marshal(messages)
+}
@@ -0,0 +1,88 @@
+//go:build unit
+
+package proxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseBodySchema(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "greeter.proto")
+	require.NoError(t, os.WriteFile(file, []byte(`
+syntax = "proto3";
+message HelloRequest { string name = 1; }
+message HelloReply { string message = 1; }
+`), 0o600))
+
+	s, err := ParseBodySchema("api.example.com/greeter=" + file + ":HelloRequest,HelloReply")
+	require.NoError(t, err)
+	assert.Equal(t, Route{Host: "api.example.com", PathPrefix: "/greeter"}, s.Route)
+	assert.Equal(t, "HelloRequest", s.RequestType)
+	assert.Equal(t, "HelloReply", s.ResponseType)
+
+	// Response type defaults to the request type
+	s, err = ParseBodySchema("/greeter=" + file + ":HelloRequest")
+	require.NoError(t, err)
+	assert.Equal(t, "HelloRequest", s.ResponseType)
+
+	_, err = ParseBodySchema(file + ":HelloRequest")
+	assert.Error(t, err)
+	_, err = ParseBodySchema("/greeter=" + file + ":Missing")
+	assert.Error(t, err)
+	_, err = ParseBodySchema("/greeter=" + filepath.Join(dir, "schema.json") + ":HelloRequest")
+	assert.Error(t, err)
+}
+
+func TestBodySchemaDecodingInHistory(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "greeter.proto")
+	require.NoError(t, os.WriteFile(file, []byte(`
+syntax = "proto3";
+message HelloRequest { string name = 1; }
+message HelloReply { string message = 1; }
+`), 0o600))
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/x-protobuf")
+		// message = "hi"
+		if _, err := w.Write([]byte{0x0a, 0x02, 'h', 'i'}); err != nil {
+			t.Logf("Error writing response: %v", err)
+		}
+	}))
+	defer upstream.Close()
+
+	s, err := ParseBodySchema("/greeter=" + file + ":HelloRequest,HelloReply")
+	require.NoError(t, err)
+	proxy := New(&Config{Port: 8080, BodySchemas: []BodySchema{s}})
+
+	// name = "Ada"
+	body := []byte{0x0a, 0x03, 'A', 'd', 'a'}
+	req := httptest.NewRequest(http.MethodPost, upstream.URL+"/greeter/SayHello", bytes.NewReader(body))
+	proxy.ServeHTTP(httptest.NewRecorder(), req)
+
+	// Routes without a schema are left untouched
+	proxy.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, upstream.URL+"/other", http.NoBody))
+
+	records := proxy.history.GetRecords()
+	require.Len(t, records, 2)
+	assert.Nil(t, records[0].ResponseBodyDecoded)
+
+	record := records[1]
+	assert.Empty(t, record.BodyDecodeError)
+	assert.JSONEq(t, `{"name":"Ada"}`, string(record.RequestBodyDecoded))
+	assert.JSONEq(t, `{"message":"hi"}`, string(record.ResponseBodyDecoded))
+
+	data, err := json.Marshal(record)
+	require.NoError(t, err)
+	assert.Contains(t, string(data), `"response_body_decoded":{"message":"hi"}`)
+}
@@ -0,0 +1,91 @@
+package proxy
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"mime"
+	"strings"
+)
+
+// DefaultBinaryBodyTypes are the content types whose bodies are not stored
+// in history unless CaptureBodyTypes lists them; records keep their size and
+// hash instead
+var DefaultBinaryBodyTypes = []string{
+	"image/*",
+	"video/*",
+	"audio/*",
+	"font/*",
+	"application/octet-stream",
+	"application/pdf",
+	"application/zip",
+	"application/gzip",
+	"application/x-tar",
+	"application/wasm",
+}
+
+// ParseBodyType parses a content type bodies are captured for: a MIME type
+// such as application/json, type/* such as image/*, or */* for every type
+func ParseBodyType(spec string) (string, error) {
+	t := strings.ToLower(strings.TrimSpace(spec))
+	kind, subtype, ok := strings.Cut(t, "/")
+	if !ok || kind == "" || subtype == "" || strings.Contains(subtype, "/") || (kind == "*" && subtype != "*") {
+		return "", fmt.Errorf("invalid content type %q: expected a MIME type such as application/json, type/* such as image/*, or */*", spec)
+	}
+	return t, nil
+}
+
+// bodyTypeMatches reports whether a media type is one of the types
+func bodyTypeMatches(types []string, mediaType string) bool {
+	for _, t := range types {
+		switch {
+		case t == "*/*", t == mediaType:
+			return true
+		case strings.HasSuffix(t, "/*"):
+			if strings.HasPrefix(mediaType, strings.TrimSuffix(t, "*")) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// capturesBodyType reports whether a body sent with the Content-Type is
+// stored. Bodies without a valid Content-Type are stored.
+func capturesBodyType(types []string, contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return true
+	}
+	if len(types) > 0 {
+		return bodyTypeMatches(types, mediaType)
+	}
+	return !bodyTypeMatches(DefaultBinaryBodyTypes, mediaType)
+}
+
+// skipBinaryBodies replaces the bodies of content types that are not
+// captured with their SHA-256, keeping their sizes. The hash is of the whole
+// body when it was streamed; it is left out for truncated bodies without one.
+func skipBinaryBodies(record *RequestRecord, types []string) {
+	if record.RequestBody != "" && !capturesBodyType(types, recordHeader(record.RequestHeaders, "Content-Type")) {
+		record.RequestBodySHA256 = bodyHash(record.RequestBody, record.RequestBodyTruncated, record.requestBodyHash)
+		record.RequestBody = ""
+	}
+	if record.ResponseBody != "" && !capturesBodyType(types, recordHeader(record.ResponseHeaders, "Content-Type")) {
+		record.ResponseBodySHA256 = bodyHash(record.ResponseBody, record.ResponseBodyTruncated, record.responseBodyHash)
+		record.ResponseBody = ""
+	}
+}
+
+// bodyHash returns the hex SHA-256 of a body, or the hash of the whole body
+// computed while it was streamed
+func bodyHash(body string, truncated bool, streamed string) string {
+	if streamed != "" {
+		return streamed
+	}
+	if truncated {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(body))
+	return hex.EncodeToString(sum[:])
+}
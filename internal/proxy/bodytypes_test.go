@@ -0,0 +1,100 @@
+//go:build unit
+
+package proxy
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseBodyType(t *testing.T) {
+	for spec, want := range map[string]string{"Application/JSON": "application/json", " image/* ": "image/*", "*/*": "*/*"} {
+		bodyType, err := ParseBodyType(spec)
+		require.NoError(t, err, spec)
+		assert.Equal(t, want, bodyType)
+	}
+	for _, spec := range []string{"json", "image/", "/png", "*/png", "a/b/c"} {
+		_, err := ParseBodyType(spec)
+		assert.Error(t, err, spec)
+	}
+}
+
+func TestCapturesBodyType(t *testing.T) {
+	assert.True(t, capturesBodyType(nil, "application/json; charset=utf-8"))
+	assert.True(t, capturesBodyType(nil, ""), "bodies without a type are stored")
+	assert.False(t, capturesBodyType(nil, "image/png"))
+	assert.False(t, capturesBodyType(nil, "Application/Octet-Stream"))
+
+	types := []string{"application/json", "image/*"}
+	assert.True(t, capturesBodyType(types, "image/png"))
+	assert.False(t, capturesBodyType(types, "text/html"))
+	assert.True(t, capturesBodyType([]string{"*/*"}, "video/mp4"))
+}
+
+func TestProxySkipsBinaryBodies(t *testing.T) {
+	image := strings.Repeat("\x89PNG", 100)
+	video := strings.Repeat("v", 3000)
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/logo.png":
+			w.Header().Set("Content-Type", "image/png")
+			_, _ = w.Write([]byte(image))
+		case "/clip":
+			w.Header().Set("Content-Type", "video/mp4")
+			_, _ = w.Write([]byte(video))
+		default:
+			w.Header().Set("Content-Type", "application/json")
+			_, _ = w.Write([]byte(`{"ok": true}`))
+		}
+	}))
+	defer upstream.Close()
+
+	p := New(&Config{StreamThreshold: 1000, StreamCaptureLimit: 100})
+	for _, target := range []string{"/logo.png", "/clip", "/api"} {
+		rec := httptest.NewRecorder()
+		p.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, upstream.URL+target, nil))
+		require.Equal(t, http.StatusOK, rec.Code)
+		if target == "/logo.png" {
+			assert.Equal(t, image, rec.Body.String(), "the client gets the body as usual")
+		}
+	}
+
+	records := map[string]RequestRecord{}
+	for _, record := range p.history.GetRecords() {
+		records[record.URLComponents.Path] = record
+	}
+	logo := records["/logo.png"]
+	assert.Empty(t, logo.ResponseBody)
+	assert.Equal(t, int64(len(image)), logo.ResponseSize)
+	imageSum := sha256.Sum256([]byte(image))
+	assert.Equal(t, hex.EncodeToString(imageSum[:]), logo.ResponseBodySHA256)
+
+	// Streamed bodies are hashed whole, not just their captured start
+	clip := records["/clip"]
+	assert.Empty(t, clip.ResponseBody)
+	videoSum := sha256.Sum256([]byte(video))
+	assert.Equal(t, hex.EncodeToString(videoSum[:]), clip.ResponseBodySHA256)
+
+	api := records["/api"]
+	assert.Equal(t, `{"ok": true}`, api.ResponseBody)
+	assert.Empty(t, api.ResponseBodySHA256)
+
+	// Listed types are stored
+	p = New(&Config{CaptureBodyTypes: []string{"image/*"}})
+	p.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, upstream.URL+"/logo.png", nil))
+	p.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, upstream.URL+"/api", nil))
+	records = map[string]RequestRecord{}
+	for _, record := range p.history.GetRecords() {
+		records[record.URLComponents.Path] = record
+	}
+	assert.Equal(t, image, records["/logo.png"].ResponseBody)
+	assert.Empty(t, records["/api"].ResponseBody)
+	assert.NotEmpty(t, records["/api"].ResponseBodySHA256)
+}
@@ -0,0 +1,172 @@
+package proxy
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Cache status values recorded on RequestRecord.CacheStatus
+const (
+	CacheStatusMiss        = "miss"
+	CacheStatusRevalidated = "revalidated"
+	CacheStatusBypass      = "bypass" // The client asked to skip the cache with X-Netkit-Options
+)
+
+// cachedResponse is an upstream GET response that carried validators
+type cachedResponse struct {
+	status       int
+	header       http.Header
+	body         []byte
+	etag         string
+	lastModified string
+	varyValues   map[string]string // Request header values the response varies on
+	storedAt     time.Time
+}
+
+// ResponseCache keeps validated GET responses so polling clients can be
+// revalidated upstream with conditional requests
+type ResponseCache struct {
+	entries    map[string]*cachedResponse
+	order      []string // Insertion order used for eviction
+	mutex      sync.RWMutex
+	maxEntries int
+}
+
+// NewResponseCache creates a new response cache with the specified maximum number of entries
+func NewResponseCache(maxEntries int) *ResponseCache {
+	return &ResponseCache{
+		entries:    make(map[string]*cachedResponse),
+		order:      make([]string, 0),
+		maxEntries: maxEntries,
+	}
+}
+
+// PrepareConditional adds If-None-Match/If-Modified-Since to the outgoing request
+// when a cached response exists for key. It returns the cached response used, or nil
+// if the request was left untouched. Requests that already carry their own
+// validators are passed through so clients that understand 304 still see it.
+func (c *ResponseCache) PrepareConditional(key string, req *http.Request) *cachedResponse {
+	if req.Method != http.MethodGet {
+		return nil
+	}
+	if req.Header.Get("If-None-Match") != "" || req.Header.Get("If-Modified-Since") != "" {
+		return nil
+	}
+
+	c.mutex.RLock()
+	entry, ok := c.entries[key]
+	c.mutex.RUnlock()
+	if !ok || !entry.matchesVary(req.Header) {
+		return nil
+	}
+
+	if entry.etag != "" {
+		req.Header.Set("If-None-Match", entry.etag)
+	}
+	if entry.lastModified != "" {
+		req.Header.Set("If-Modified-Since", entry.lastModified)
+	}
+	return entry
+}
+
+// Store caches a successful GET response if it carries an ETag or Last-Modified validator
+func (c *ResponseCache) Store(key string, req *http.Request, resp *http.Response, body []byte) {
+	if req.Method != http.MethodGet || resp.StatusCode != http.StatusOK {
+		return
+	}
+
+	etag := resp.Header.Get("ETag")
+	lastModified := resp.Header.Get("Last-Modified")
+	if etag == "" && lastModified == "" {
+		return
+	}
+	if strings.Contains(strings.ToLower(resp.Header.Get("Cache-Control")), "no-store") {
+		return
+	}
+
+	vary := resp.Header.Values("Vary")
+	varyValues := make(map[string]string)
+	for _, v := range vary {
+		for _, name := range strings.Split(v, ",") {
+			name = strings.TrimSpace(name)
+			if name == "*" {
+				return
+			}
+			if name != "" {
+				varyValues[http.CanonicalHeaderKey(name)] = req.Header.Get(name)
+			}
+		}
+	}
+
+	entry := &cachedResponse{
+		status:       resp.StatusCode,
+		header:       resp.Header.Clone(),
+		body:         append([]byte(nil), body...),
+		etag:         etag,
+		lastModified: lastModified,
+		varyValues:   varyValues,
+		storedAt:     time.Now(),
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if _, exists := c.entries[key]; !exists {
+		c.order = append(c.order, key)
+	}
+	c.entries[key] = entry
+
+	// Evict oldest entries beyond max size
+	for len(c.order) > c.maxEntries {
+		delete(c.entries, c.order[0])
+		c.order = c.order[1:]
+	}
+}
+
+// Len returns the number of cached responses
+func (c *ResponseCache) Len() int {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	return len(c.entries)
+}
+
+// Clear removes all cached responses
+func (c *ResponseCache) Clear() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.entries = make(map[string]*cachedResponse)
+	c.order = c.order[:0]
+}
+
+// synthesize turns an upstream 304 into the full cached 200 response, merging any
+// updated headers sent with the 304 into the stored ones
+func (e *cachedResponse) synthesize(resp *http.Response) {
+	header := e.header.Clone()
+	for key, values := range resp.Header {
+		if key == "Content-Length" {
+			continue
+		}
+		header[key] = values
+	}
+
+	resp.StatusCode = e.status
+	resp.Status = http.StatusText(e.status)
+	resp.Header = header
+	resp.ContentLength = int64(len(e.body))
+	resp.Body = io.NopCloser(bytes.NewReader(e.body))
+}
+
+// matchesVary reports whether the request carries the same values for the headers
+// the cached response varies on
+func (e *cachedResponse) matchesVary(header http.Header) bool {
+	for name, value := range e.varyValues {
+		if header.Get(name) != value {
+			return false
+		}
+	}
+	return true
+}
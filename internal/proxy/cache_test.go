@@ -0,0 +1,119 @@
+//go:build unit
+
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConditionalGETSynthesis(t *testing.T) {
+	var fullResponses, notModified int32
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", `"v1"`)
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			atomic.AddInt32(&notModified, 1)
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		atomic.AddInt32(&fullResponses, 1)
+		w.Header().Set("Content-Type", "application/json")
+		if _, err := w.Write([]byte(`{"status":"ok"}`)); err != nil {
+			t.Logf("Error writing response: %v", err)
+		}
+	}))
+	defer upstream.Close()
+
+	proxy := New(&Config{Port: 8080, ConditionalGET: true})
+
+	// First poll populates the cache, the second is revalidated upstream
+	for i := 0; i < 2; i++ {
+		req := httptest.NewRequest(http.MethodGet, upstream.URL+"/poll", http.NoBody)
+		rec := httptest.NewRecorder()
+		proxy.ServeHTTP(rec, req)
+
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, `{"status":"ok"}`, rec.Body.String())
+		assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+	}
+
+	assert.Equal(t, int32(1), atomic.LoadInt32(&fullResponses))
+	assert.Equal(t, int32(1), atomic.LoadInt32(&notModified))
+
+	records := proxy.history.GetRecords()
+	require.Len(t, records, 2)
+	assert.Equal(t, CacheStatusRevalidated, records[0].CacheStatus)
+	assert.Equal(t, http.StatusOK, records[0].ResponseStatus)
+	assert.Equal(t, `{"status":"ok"}`, records[0].ResponseBody)
+	assert.Equal(t, CacheStatusMiss, records[1].CacheStatus)
+}
+
+func TestConditionalGETClientValidatorsPassThrough(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", `"v1"`)
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		if _, err := w.Write([]byte("body")); err != nil {
+			t.Logf("Error writing response: %v", err)
+		}
+	}))
+	defer upstream.Close()
+
+	proxy := New(&Config{Port: 8080, ConditionalGET: true})
+
+	rec := httptest.NewRecorder()
+	proxy.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, upstream.URL, http.NoBody))
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	// A client sending its own validator understands 304 and should receive it
+	req := httptest.NewRequest(http.MethodGet, upstream.URL, http.NoBody)
+	req.Header.Set("If-None-Match", `"v1"`)
+	rec = httptest.NewRecorder()
+	proxy.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusNotModified, rec.Code)
+}
+
+func TestResponseCacheVaryAndEviction(t *testing.T) {
+	cache := NewResponseCache(1)
+
+	req := httptest.NewRequest(http.MethodGet, "http://example.com/a", http.NoBody)
+	req.Header.Set("Accept-Language", "en")
+	resp := &http.Response{StatusCode: http.StatusOK, Header: http.Header{}}
+	resp.Header.Set("ETag", `"a"`)
+	resp.Header.Set("Vary", "Accept-Language")
+	cache.Store("a", req, resp, []byte("a"))
+	assert.Equal(t, 1, cache.Len())
+
+	// Different Vary header value must not reuse the entry
+	other := httptest.NewRequest(http.MethodGet, "http://example.com/a", http.NoBody)
+	other.Header.Set("Accept-Language", "pt")
+	assert.Nil(t, cache.PrepareConditional("a", other))
+	assert.Empty(t, other.Header.Get("If-None-Match"))
+
+	same := httptest.NewRequest(http.MethodGet, "http://example.com/a", http.NoBody)
+	same.Header.Set("Accept-Language", "en")
+	assert.NotNil(t, cache.PrepareConditional("a", same))
+	assert.Equal(t, `"a"`, same.Header.Get("If-None-Match"))
+
+	// Storing a second entry evicts the oldest one
+	resp.Header.Del("Vary")
+	cache.Store("b", req, resp, []byte("b"))
+	assert.Equal(t, 1, cache.Len())
+	assert.Nil(t, cache.PrepareConditional("a", same))
+
+	// Responses without validators or marked no-store are not cached
+	cache.Clear()
+	plain := &http.Response{StatusCode: http.StatusOK, Header: http.Header{}}
+	cache.Store("c", req, plain, []byte("c"))
+	plain.Header.Set("ETag", `"c"`)
+	plain.Header.Set("Cache-Control", "no-store")
+	cache.Store("c", req, plain, []byte("c"))
+	assert.Equal(t, 0, cache.Len())
+}
@@ -0,0 +1,362 @@
+package proxy
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// Capture modes for paused traffic
+const (
+	CaptureOff      = "off"      // Record nothing
+	CaptureMetadata = "metadata" // Record requests without their bodies
+)
+
+// CapturePause stops or reduces capture globally or for one route
+type CapturePause struct {
+	Route    string     `json:"route,omitempty"` // Empty for the global pause
+	Mode     string     `json:"mode"`
+	Reason   string     `json:"reason,omitempty"`
+	PausedAt time.Time  `json:"paused_at"`
+	Until    *time.Time `json:"until,omitempty"` // Capture resumes on its own after this
+
+	route Route
+}
+
+func (cp *CapturePause) active(now time.Time) bool {
+	return cp != nil && (cp.Until == nil || now.Before(*cp.Until))
+}
+
+// CaptureStatus is the runtime capture state reported by GET /capture
+type CaptureStatus struct {
+	Capturing      bool           `json:"capturing"` // False while the global pause is active
+	Global         *CapturePause  `json:"global,omitempty"`
+	Routes         []CapturePause `json:"routes"`
+	SkippedRecords int64          `json:"skipped_records"` // Requests not recorded since startup
+	StrippedBodies int64          `json:"stripped_bodies"` // Requests recorded without bodies since startup
+	SampledOut     int64          `json:"sampled_out"`     // Requests dropped by the sampler since startup
+	Policies       []string       `json:"policies"`        // Per-host capture policies, as route=mode
+}
+
+// captureControl holds the capture pauses toggled through the admin API
+type captureControl struct {
+	mutex      sync.RWMutex
+	global     *CapturePause
+	routes     []CapturePause
+	skipped    int64
+	stripped   int64
+	sampledOut int64
+}
+
+func newCaptureControl() *captureControl {
+	return &captureControl{}
+}
+
+// modeFor returns the strictest capture mode of the pauses covering the URL,
+// or "" when the request is captured in full
+func (c *captureControl) modeFor(rawURL string, now time.Time) string {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	mode := ""
+	if c.global.active(now) {
+		mode = c.global.Mode
+	}
+	if mode == CaptureOff || len(c.routes) == 0 {
+		return mode
+	}
+
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return mode
+	}
+	for i := range c.routes {
+		pause := &c.routes[i]
+		if !pause.active(now) || !pause.route.Matches(u) {
+			continue
+		}
+		if pause.Mode == CaptureOff {
+			return CaptureOff
+		}
+		mode = pause.Mode
+	}
+	return mode
+}
+
+// Pause adds a pause, replacing any existing pause for the same route
+func (c *captureControl) Pause(pause CapturePause) (CapturePause, error) {
+	if pause.Mode == "" {
+		pause.Mode = CaptureOff
+	}
+	if pause.Mode != CaptureOff && pause.Mode != CaptureMetadata {
+		return CapturePause{}, fmt.Errorf("mode must be %s or %s", CaptureOff, CaptureMetadata)
+	}
+	pause.PausedAt = time.Now()
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if pause.Route == "" {
+		c.global = &pause
+		return pause, nil
+	}
+
+	pause.route = ParseRoute(pause.Route)
+	pause.Route = pause.route.String()
+	for i := range c.routes {
+		if c.routes[i].Route == pause.Route {
+			c.routes[i] = pause
+			return pause, nil
+		}
+	}
+	c.routes = append(c.routes, pause)
+	return pause, nil
+}
+
+// Resume removes the pause for a route, or every pause when route is empty,
+// reporting whether anything was paused
+func (c *captureControl) Resume(route string) bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if route == "" {
+		resumed := c.global != nil || len(c.routes) > 0
+		c.global, c.routes = nil, nil
+		return resumed
+	}
+
+	route = ParseRoute(route).String()
+	for i := range c.routes {
+		if c.routes[i].Route == route {
+			c.routes = append(c.routes[:i], c.routes[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// Status returns the pauses that are still in effect
+func (c *captureControl) Status() CaptureStatus {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	now := time.Now()
+	status := CaptureStatus{Capturing: true, Routes: []CapturePause{}, SkippedRecords: c.skipped, StrippedBodies: c.stripped, SampledOut: c.sampledOut}
+	if c.global.active(now) {
+		global := *c.global
+		status.Global = &global
+		status.Capturing = false
+	}
+	for _, pause := range c.routes {
+		if pause.active(now) {
+			status.Routes = append(status.Routes, pause)
+		}
+	}
+	return status
+}
+
+// recordRequest adds a record to history unless capture is paused for it, a
+// capture policy or the client with X-Netkit-Options opted out, or the
+// sampler drops it. Records of a test run or a recording skip sampling so
+// they see every one of their requests.
+func (p *Proxy) recordRequest(record RequestRecord) {
+	record.measure()
+	if record.URLComponents == nil {
+		record.URLComponents = ParseURLComponents(record.URL)
+	}
+	mode := p.capture.modeFor(record.URL, time.Now())
+	if record.captureOverride == CaptureOff || (record.captureOverride == CaptureMetadata && mode == "") {
+		mode = record.captureOverride
+	}
+	policy := capturePolicyFor(p.currentConfig().CapturePolicies, record.URL)
+	if policy == CapturePolicyNone {
+		mode = CaptureOff
+	} else if policy != CapturePolicyFull && mode == "" {
+		mode = CaptureMetadata
+	}
+	if mode == CaptureOff {
+		p.capture.mutex.Lock()
+		p.capture.skipped++
+		p.capture.mutex.Unlock()
+		return
+	}
+	// Match recordings before capture policies strip the tag header
+	recordings := p.recordings.matching(record)
+	if p.currentConfig().Sampler != nil && record.RunID == "" && len(recordings) == 0 && !p.currentConfig().Sampler.Sample(record) {
+		p.capture.mutex.Lock()
+		p.capture.sampledOut++
+		p.capture.mutex.Unlock()
+		return
+	}
+	if mode == CaptureMetadata {
+		record.rawWire = nil // Raw bytes include the bodies
+		record.RequestBody, record.ResponseBody = "", ""
+		record.RequestBodyDecoded, record.ResponseBodyDecoded = nil, nil
+		record.BodiesOmitted = true
+		p.capture.mutex.Lock()
+		p.capture.stripped++
+		p.capture.mutex.Unlock()
+	}
+	if policy == CapturePolicyMetadata {
+		stripToMetadata(&record)
+	}
+	skipBinaryBodies(&record, p.currentConfig().CaptureBodyTypes)
+	p.currentConfig().Redaction.apply(&record)
+	truncateBodies(&record, p.currentConfig().CaptureMaxBodyBytes)
+	p.recordings.add(recordings, record)
+	if p.tailSampler != nil && record.RunID == "" && len(recordings) == 0 {
+		p.tailSampler.add(record)
+		return
+	}
+	p.storeRecord(record)
+}
+
+// storeRecord adds a record that passed capture and sampling to history
+func (p *Proxy) storeRecord(record RequestRecord) {
+	if record.rawWire != nil {
+		p.rawCaptures.add(&record, p.currentConfig().Redaction)
+	}
+	p.history.AddRecord(record)
+	p.heatmap.observe(record.Timestamp, time.Duration(record.TotalDurationUs)*time.Microsecond, isFailedRecord(record))
+	p.feed.publish(record)
+	p.sinks.publish(record)
+}
+
+// updateRecord applies update to a recorded request, whether it is already in
+// history or still buffered for tail sampling
+func (p *Proxy) updateRecord(id string, update func(*RequestRecord)) bool {
+	if p.tailSampler != nil && p.tailSampler.update(id, update) {
+		return true
+	}
+	return p.history.UpdateRecord(id, update)
+}
+
+// handleCapture reports the runtime capture state
+func (p *Proxy) handleCapture(w http.ResponseWriter, r *http.Request) {
+	// Add CORS headers
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, Cache-Control, Pragma, Expires")
+
+	// Handle preflight requests
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	p.writeCaptureStatus(w, http.StatusOK)
+}
+
+// handleCapturePause pauses capture globally or for a route
+func (p *Proxy) handleCapturePause(w http.ResponseWriter, r *http.Request) {
+	// Add CORS headers
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, Cache-Control, Pragma, Expires")
+
+	// Handle preflight requests
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// An empty body pauses all capture
+	var request struct {
+		Route    string `json:"route"`
+		Mode     string `json:"mode"`
+		Duration string `json:"duration"`
+		Reason   string `json:"reason"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil && !errors.Is(err, io.EOF) {
+		http.Error(w, "Invalid pause JSON", http.StatusBadRequest)
+		return
+	}
+
+	pause := CapturePause{Route: request.Route, Mode: request.Mode, Reason: request.Reason}
+	if request.Duration != "" {
+		duration, err := time.ParseDuration(request.Duration)
+		if err != nil || duration <= 0 {
+			http.Error(w, "Invalid duration", http.StatusBadRequest)
+			return
+		}
+		until := time.Now().Add(duration)
+		pause.Until = &until
+	}
+	if _, err := p.capture.Pause(pause); err != nil {
+		http.Error(w, "Invalid pause: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	p.writeCaptureStatus(w, http.StatusOK)
+}
+
+// handleCaptureResume resumes capture for a route, or everywhere
+func (p *Proxy) handleCaptureResume(w http.ResponseWriter, r *http.Request) {
+	// Add CORS headers
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, Cache-Control, Pragma, Expires")
+
+	// Handle preflight requests
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var request struct {
+		Route string `json:"route"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil && !errors.Is(err, io.EOF) {
+		http.Error(w, "Invalid resume JSON", http.StatusBadRequest)
+		return
+	}
+	if !p.capture.Resume(request.Route) && request.Route != "" {
+		http.Error(w, "Route capture is not paused", http.StatusNotFound)
+		return
+	}
+
+	p.writeCaptureStatus(w, http.StatusOK)
+}
+
+func (p *Proxy) writeCaptureStatus(w http.ResponseWriter, status int) {
+	captureStatus := p.capture.Status()
+	captureStatus.Policies = []string{}
+	for _, policy := range p.currentConfig().CapturePolicies {
+		captureStatus.Policies = append(captureStatus.Policies, policy.String())
+	}
+	if p.tailSampler != nil {
+		captureStatus.SampledOut += p.tailSampler.droppedRecords()
+	}
+	data, err := json.Marshal(captureStatus)
+	if err != nil {
+		http.Error(w, "Failed to encode capture status", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if _, err := w.Write(data); err != nil {
+		slog.Warn("Error writing capture status response", "error", err)
+	}
+}
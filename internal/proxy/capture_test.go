@@ -0,0 +1,118 @@
+//go:build unit
+
+package proxy
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func captureRecord(id, target string) RequestRecord {
+	return RequestRecord{ID: id, URL: target, RequestBody: "card=4242", ResponseBody: "ok", Timestamp: time.Now()}
+}
+
+func captureCall(t *testing.T, handler http.HandlerFunc, method, target, body string) (int, CaptureStatus) {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	handler(rec, httptest.NewRequest(method, target, strings.NewReader(body)))
+	var status CaptureStatus
+	if rec.Code == http.StatusOK {
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &status))
+	}
+	return rec.Code, status
+}
+
+func TestCapturePauseAndResume(t *testing.T) {
+	p := New(&Config{})
+
+	code, status := captureCall(t, p.handleCapturePause, http.MethodPost, "/capture/pause", "")
+	require.Equal(t, http.StatusOK, code)
+	assert.False(t, status.Capturing)
+	require.NotNil(t, status.Global)
+	assert.Equal(t, CaptureOff, status.Global.Mode)
+
+	p.recordRequest(captureRecord("dropped", "http://api.example.com/users"))
+	assert.Empty(t, p.history.GetRecords())
+
+	code, status = captureCall(t, p.handleCaptureResume, http.MethodPost, "/capture/resume", "")
+	require.Equal(t, http.StatusOK, code)
+	assert.True(t, status.Capturing)
+	assert.Equal(t, int64(1), status.SkippedRecords)
+
+	p.recordRequest(captureRecord("kept", "http://api.example.com/users"))
+	records := p.history.GetRecords()
+	require.Len(t, records, 1)
+	assert.Equal(t, "card=4242", records[0].RequestBody)
+}
+
+func TestCapturePerRoute(t *testing.T) {
+	p := New(&Config{})
+
+	code, _ := captureCall(t, p.handleCapturePause, http.MethodPost, "/capture/pause", `{"route": "api.example.com/payments", "mode": "metadata", "reason": "migration"}`)
+	require.Equal(t, http.StatusOK, code)
+	code, status := captureCall(t, p.handleCapturePause, http.MethodPost, "/capture/pause", `{"route": "*/internal", "mode": "off"}`)
+	require.Equal(t, http.StatusOK, code)
+	assert.True(t, status.Capturing, "route pauses leave capture on elsewhere")
+	require.Len(t, status.Routes, 2)
+	assert.Equal(t, "migration", status.Routes[0].Reason)
+
+	p.recordRequest(captureRecord("payment", "http://api.example.com/payments/1"))
+	p.recordRequest(captureRecord("internal", "http://other.example.com/internal/health"))
+	p.recordRequest(captureRecord("users", "http://api.example.com/users"))
+
+	records := p.history.GetRecords()
+	require.Len(t, records, 2)
+	assert.Equal(t, "users", records[0].ID)
+	assert.Equal(t, "ok", records[0].ResponseBody)
+	assert.Equal(t, "payment", records[1].ID)
+	assert.Empty(t, records[1].RequestBody)
+	assert.Empty(t, records[1].ResponseBody)
+	assert.True(t, records[1].BodiesOmitted)
+
+	// A global metadata pause does not loosen a route that records nothing
+	_, err := p.capture.Pause(CapturePause{Mode: CaptureMetadata})
+	require.NoError(t, err)
+	assert.Equal(t, CaptureOff, p.capture.modeFor("http://other.example.com/internal/x", time.Now()))
+	assert.Equal(t, CaptureMetadata, p.capture.modeFor("http://api.example.com/users", time.Now()))
+
+	code, status = captureCall(t, p.handleCaptureResume, http.MethodPost, "/capture/resume", `{"route": "*/internal"}`)
+	require.Equal(t, http.StatusOK, code)
+	assert.Len(t, status.Routes, 1)
+	code, _ = captureCall(t, p.handleCaptureResume, http.MethodPost, "/capture/resume", `{"route": "*/internal"}`)
+	assert.Equal(t, http.StatusNotFound, code)
+}
+
+func TestCapturePauseExpires(t *testing.T) {
+	p := New(&Config{})
+	until := time.Now().Add(-time.Second)
+	_, err := p.capture.Pause(CapturePause{Until: &until})
+	require.NoError(t, err)
+
+	assert.Equal(t, "", p.capture.modeFor("http://api.example.com/", time.Now()))
+	assert.True(t, p.capture.Status().Capturing)
+
+	code, status := captureCall(t, p.handleCapturePause, http.MethodPost, "/capture/pause", `{"duration": "1h"}`)
+	require.Equal(t, http.StatusOK, code)
+	require.NotNil(t, status.Global.Until)
+	assert.WithinDuration(t, time.Now().Add(time.Hour), *status.Global.Until, time.Minute)
+}
+
+func TestCapturePauseValidation(t *testing.T) {
+	p := New(&Config{})
+	for _, body := range []string{`{"mode": "partial"}`, `{"duration": "soon"}`, `{"duration": "-1m"}`, `not json`} {
+		code, _ := captureCall(t, p.handleCapturePause, http.MethodPost, "/capture/pause", body)
+		assert.Equal(t, http.StatusBadRequest, code, body)
+	}
+	code, _ := captureCall(t, p.handleCapturePause, http.MethodGet, "/capture/pause", "")
+	assert.Equal(t, http.StatusMethodNotAllowed, code)
+	code, status := captureCall(t, p.handleCapture, http.MethodGet, "/capture", "")
+	require.Equal(t, http.StatusOK, code)
+	assert.True(t, status.Capturing)
+}
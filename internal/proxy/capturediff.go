@@ -0,0 +1,182 @@
+package proxy
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// Route presence in a capture diff
+const (
+	RouteInBoth  = "both"
+	RouteAdded   = "added"   // Only in the capture
+	RouteRemoved = "removed" // Only in the baseline
+)
+
+// StatusDiff compares how often one status code was returned on a route
+type StatusDiff struct {
+	Status        int     `json:"status"` // 0 for requests that got no response
+	Baseline      int     `json:"baseline"`
+	Capture       int     `json:"capture"`
+	BaselineShare float64 `json:"baseline_share"` // Fraction of the route's requests, 0-1
+	CaptureShare  float64 `json:"capture_share"`
+}
+
+// RouteDiff compares one method and normalized route between a capture and
+// its baseline
+type RouteDiff struct {
+	Method          string       `json:"method"`
+	Route           string       `json:"route"`
+	Presence        string       `json:"presence"` // both, added, or removed
+	Baseline        TrafficStats `json:"baseline"`
+	Capture         TrafficStats `json:"capture"`
+	Delta           StatsDelta   `json:"delta"`            // Capture minus baseline
+	Statuses        []StatusDiff `json:"statuses"`         // Ascending by status code
+	StatusesChanged bool         `json:"statuses_changed"` // A status code was returned in only one of them
+}
+
+// CaptureDiff compares the traffic pattern of a capture with a baseline
+// capture, e.g. before and after a refactor
+type CaptureDiff struct {
+	Capture       string      `json:"capture"`
+	Against       string      `json:"against"`
+	Matches       bool        `json:"matches"` // Same routes with the same status codes; latency is not considered
+	RoutesAdded   int         `json:"routes_added"`
+	RoutesRemoved int         `json:"routes_removed"`
+	StatusChanges int         `json:"status_changes"` // Routes in both whose status codes changed
+	Delta         StatsDelta  `json:"delta"`          // Overall, capture minus baseline
+	Routes        []RouteDiff `json:"routes"`         // Busiest routes (both captures combined) first
+}
+
+// diffCaptures compares capture with baseline per route: which routes appear
+// in only one of them, their status code distributions, and their latency
+func diffCaptures(capture, baseline *Capture) CaptureDiff {
+	comparison := compareRecords(baseline.Records, capture.Records)
+	diff := CaptureDiff{
+		Capture: capture.Name,
+		Against: baseline.Name,
+		Delta:   comparison.Delta,
+		Routes:  make([]RouteDiff, 0, len(comparison.Routes)),
+	}
+
+	type routeKey struct{ method, route string }
+	baselineStatuses := make(map[routeKey]map[int]int)
+	captureStatuses := make(map[routeKey]map[int]int)
+	countStatuses := func(records []RequestRecord, counts map[routeKey]map[int]int) {
+		for _, record := range records {
+			key := routeKey{record.Method, normalizeRoute(record.URL)}
+			if counts[key] == nil {
+				counts[key] = make(map[int]int)
+			}
+			counts[key][record.ResponseStatus]++
+		}
+	}
+	countStatuses(baseline.Records, baselineStatuses)
+	countStatuses(capture.Records, captureStatuses)
+
+	for _, route := range comparison.Routes {
+		routeDiff := RouteDiff{
+			Method:   route.Method,
+			Route:    route.Route,
+			Presence: RouteInBoth,
+			Baseline: route.A,
+			Capture:  route.B,
+			Delta:    route.Delta,
+			Statuses: []StatusDiff{},
+		}
+		switch {
+		case route.A.Count == 0:
+			routeDiff.Presence = RouteAdded
+			diff.RoutesAdded++
+		case route.B.Count == 0:
+			routeDiff.Presence = RouteRemoved
+			diff.RoutesRemoved++
+		}
+
+		key := routeKey{route.Method, route.Route}
+		statuses := make(map[int]bool)
+		for status := range baselineStatuses[key] {
+			statuses[status] = true
+		}
+		for status := range captureStatuses[key] {
+			statuses[status] = true
+		}
+		for status := range statuses {
+			statusDiff := StatusDiff{Status: status, Baseline: baselineStatuses[key][status], Capture: captureStatuses[key][status]}
+			if route.A.Count > 0 {
+				statusDiff.BaselineShare = float64(statusDiff.Baseline) / float64(route.A.Count)
+			}
+			if route.B.Count > 0 {
+				statusDiff.CaptureShare = float64(statusDiff.Capture) / float64(route.B.Count)
+			}
+			if routeDiff.Presence == RouteInBoth && (statusDiff.Baseline == 0 || statusDiff.Capture == 0) {
+				routeDiff.StatusesChanged = true
+			}
+			routeDiff.Statuses = append(routeDiff.Statuses, statusDiff)
+		}
+		sort.Slice(routeDiff.Statuses, func(i, j int) bool { return routeDiff.Statuses[i].Status < routeDiff.Statuses[j].Status })
+		if routeDiff.StatusesChanged {
+			diff.StatusChanges++
+		}
+		diff.Routes = append(diff.Routes, routeDiff)
+	}
+
+	diff.Matches = diff.RoutesAdded == 0 && diff.RoutesRemoved == 0 && diff.StatusChanges == 0
+	return diff
+}
+
+// handleCaptureSubresource serves /captures/{name}/diff?against={baseline},
+// which compares a capture's traffic pattern with a baseline capture
+func (p *Proxy) handleCaptureSubresource(w http.ResponseWriter, r *http.Request) {
+	// Add CORS headers
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, Cache-Control, Pragma, Expires")
+
+	// Handle preflight requests
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	name, rest, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/captures/"), "/")
+	if rest != "diff" {
+		http.NotFound(w, r)
+		return
+	}
+
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	against := r.URL.Query().Get("against")
+	if against == "" {
+		http.Error(w, "A baseline capture is required: against=<name>", http.StatusBadRequest)
+		return
+	}
+	capture, ok := p.captures.Get(name)
+	if !ok {
+		http.Error(w, "Capture not found", http.StatusNotFound)
+		return
+	}
+	baseline, ok := p.captures.Get(against)
+	if !ok {
+		http.Error(w, "Baseline capture not found", http.StatusNotFound)
+		return
+	}
+
+	data, err := json.Marshal(diffCaptures(capture, baseline))
+	if err != nil {
+		http.Error(w, "Failed to diff captures", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if _, err := w.Write(data); err != nil {
+		slog.Warn("Error writing capture diff response", "error", err)
+	}
+}
@@ -0,0 +1,86 @@
+package proxy
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// Capture policies set per host with --capture-policy
+const (
+	CapturePolicyFull     = "full"          // Record everything
+	CapturePolicyHeaders  = "headers-only"  // Record headers but not bodies
+	CapturePolicyMetadata = "metadata-only" // Record the method, host, path, status, sizes, and timing
+	CapturePolicyNone     = "none"          // Record nothing
+)
+
+// CapturePolicy limits what is recorded of the requests to a route, so
+// regulated destinations such as payment processors can be proxied without
+// keeping their data
+type CapturePolicy struct {
+	Route Route
+	Mode  string
+}
+
+// ParseCapturePolicy parses a policy in "route=mode" form, e.g.
+// "*.stripe.com=metadata-only". Routes take the ParseRoute form; a host of
+// "*.example.com" also matches every subdomain of example.com.
+func ParseCapturePolicy(spec string) (CapturePolicy, error) {
+	route, mode, ok := strings.Cut(spec, "=")
+	route, mode = strings.TrimSpace(route), strings.TrimSpace(mode)
+	if !ok || route == "" {
+		return CapturePolicy{}, fmt.Errorf("invalid capture policy %q: expected route=mode", spec)
+	}
+	switch mode {
+	case CapturePolicyFull, CapturePolicyHeaders, CapturePolicyMetadata, CapturePolicyNone:
+	default:
+		return CapturePolicy{}, fmt.Errorf("invalid capture policy %q: mode must be %s, %s, %s, or %s",
+			spec, CapturePolicyFull, CapturePolicyHeaders, CapturePolicyMetadata, CapturePolicyNone)
+	}
+	return CapturePolicy{Route: ParseRoute(route), Mode: mode}, nil
+}
+
+// String returns the policy in the form accepted by ParseCapturePolicy
+func (cp CapturePolicy) String() string {
+	return cp.Route.String() + "=" + cp.Mode
+}
+
+// capturePolicyFor returns the mode of the first policy matching the URL, or
+// full capture when none does
+func capturePolicyFor(policies []CapturePolicy, rawURL string) string {
+	if len(policies) == 0 {
+		return CapturePolicyFull
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return CapturePolicyFull
+	}
+	for _, policy := range policies {
+		if policy.Route.matchesSubdomains(u) {
+			return policy.Mode
+		}
+	}
+	return CapturePolicyFull
+}
+
+// stripToMetadata drops everything from a record but where it went, its
+// outcome, sizes, and timing: headers, bodies, the query, and fields read
+// from the bodies
+func stripToMetadata(record *RequestRecord) {
+	if u, err := url.Parse(record.URL); err == nil {
+		u.RawQuery, u.Fragment, u.User = "", "", nil
+		record.URL = u.String()
+	} else {
+		record.URL = upstreamHost(record.URL)
+	}
+	record.URLComponents = ParseURLComponents(record.URL)
+	record.RequestHeaders, record.ResponseHeaders = nil, nil
+	record.HeadersOmitted = true
+	record.TraceState = ""
+	record.Advisories = nil
+
+	record.XMLRequestRoot, record.XMLResponseRoot = "", ""
+	record.SOAPAction, record.SOAPOperation, record.SOAPFault = "", "", ""
+	record.GRPCMessage = ""
+	record.BodyDecodeError = ""
+}
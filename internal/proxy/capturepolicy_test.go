@@ -0,0 +1,82 @@
+//go:build unit
+
+package proxy
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseCapturePolicy(t *testing.T) {
+	policy, err := ParseCapturePolicy("*.stripe.com = metadata-only")
+	require.NoError(t, err)
+	assert.Equal(t, Route{Host: "*.stripe.com"}, policy.Route)
+	assert.Equal(t, CapturePolicyMetadata, policy.Mode)
+	assert.Equal(t, "*.stripe.com=metadata-only", policy.String())
+
+	for _, spec := range []string{"api.stripe.com", "=none", "api.stripe.com=metadata", "api.stripe.com="} {
+		_, err := ParseCapturePolicy(spec)
+		assert.Error(t, err, spec)
+	}
+}
+
+func TestCapturePolicies(t *testing.T) {
+	var policies []CapturePolicy
+	for _, spec := range []string{"api.example.com/health=full", "*.stripe.com=metadata-only", "health.example.com=headers-only", "api.example.com=none"} {
+		policy, err := ParseCapturePolicy(spec)
+		require.NoError(t, err)
+		policies = append(policies, policy)
+	}
+	p := New(&Config{CapturePolicies: policies})
+
+	record := func(id, target string) RequestRecord {
+		record := captureRecord(id, target)
+		record.RequestHeaders = map[string]string{"Authorization": "Bearer sk_live"}
+		record.ResponseHeaders = map[string]string{"Content-Type": "application/json"}
+		record.ResponseStatus = http.StatusOK
+		record.ResponseSize = 1500
+		return record
+	}
+	p.recordRequest(record("stripe", "https://api.stripe.com/v1/charges?customer=cus_123"))
+	p.recordRequest(record("health", "https://health.example.com/patients/7"))
+	p.recordRequest(record("dropped", "https://api.example.com/users"))
+	p.recordRequest(record("check", "https://api.example.com/health"))
+	p.recordRequest(record("other", "https://other.example.com/"))
+
+	stripe, ok := p.history.GetRecord("stripe")
+	require.True(t, ok)
+	assert.Equal(t, "https://api.stripe.com/v1/charges", stripe.URL, "the query is dropped")
+	assert.Empty(t, stripe.RequestHeaders)
+	assert.Empty(t, stripe.ResponseHeaders)
+	assert.Empty(t, stripe.RequestBody)
+	assert.True(t, stripe.BodiesOmitted)
+	assert.True(t, stripe.HeadersOmitted)
+	assert.Equal(t, http.StatusOK, stripe.ResponseStatus)
+	assert.Equal(t, int64(1500), stripe.ResponseSize)
+
+	health, ok := p.history.GetRecord("health")
+	require.True(t, ok)
+	assert.Equal(t, "Bearer sk_live", health.RequestHeaders["Authorization"])
+	assert.Empty(t, health.RequestBody)
+	assert.True(t, health.BodiesOmitted)
+	assert.False(t, health.HeadersOmitted)
+
+	_, ok = p.history.GetRecord("dropped")
+	assert.False(t, ok)
+
+	for _, id := range []string{"check", "other"} {
+		full, ok := p.history.GetRecord(id)
+		require.True(t, ok, id)
+		assert.Equal(t, "card=4242", full.RequestBody, "the first matching policy applies")
+		assert.False(t, full.BodiesOmitted)
+	}
+
+	code, status := captureCall(t, p.handleCapture, http.MethodGet, "/capture", "")
+	require.Equal(t, http.StatusOK, code)
+	assert.Equal(t, []string{"api.example.com/health=full", "*.stripe.com=metadata-only", "health.example.com=headers-only", "api.example.com=none"}, status.Policies)
+	assert.Equal(t, int64(1), status.SkippedRecords)
+	assert.Equal(t, int64(2), status.StrippedBodies)
+}
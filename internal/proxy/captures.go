@@ -0,0 +1,391 @@
+package proxy
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// captureNamePattern keeps capture names safe to use as file names
+var captureNamePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]{0,63}$`)
+
+// errCaptureExists is returned when a capture name is already taken
+var errCaptureExists = errors.New("capture already exists")
+
+// CaptureInfo describes a named capture without its records
+type CaptureInfo struct {
+	Name        string    `json:"name"`
+	Description string    `json:"description,omitempty"`
+	Query       string    `json:"query,omitempty"` // History filter the capture was taken with
+	CreatedAt   time.Time `json:"created_at"`
+	Start       time.Time `json:"start"` // Window the records were taken from
+	End         time.Time `json:"end"`
+	Count       int       `json:"count"`
+}
+
+// Capture is an immutable, named copy of part of the request history
+type Capture struct {
+	CaptureInfo
+	Records []RequestRecord `json:"records"` // Most recent first
+}
+
+// captureFile is the on-disk form of a capture; its records use the history
+// file format, so they are encrypted like history when a history key is set
+type captureFile struct {
+	Capture  CaptureInfo `json:"capture"`
+	Snapshot historyFile `json:"snapshot"`
+}
+
+// captureStore keeps named captures, persisting each to its own file in a
+// directory when one is configured
+type captureStore struct {
+	mutex    sync.RWMutex
+	dir      string
+	provider KeyProvider
+	captures map[string]*Capture
+}
+
+// newCaptureStore loads the captures in dir, which is created if needed.
+// Captures that cannot be read are skipped, and their names stay taken.
+func newCaptureStore(dir string, provider KeyProvider) (*captureStore, error) {
+	store := &captureStore{dir: dir, provider: provider, captures: make(map[string]*Capture)}
+	if dir == "" {
+		return store, nil
+	}
+
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create captures directory: %v", err)
+	}
+	paths, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	for _, path := range paths {
+		capture, err := store.load(path)
+		if err != nil {
+			slog.Error("Error loading capture", "file", filepath.Base(path), "error", err)
+			continue
+		}
+		store.captures[capture.Name] = capture
+	}
+	return store, nil
+}
+
+func (s *captureStore) load(path string) (*Capture, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var file captureFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("failed to parse capture file: %v", err)
+	}
+	sealer := recordSealer{provider: s.provider}
+	records, err := sealer.decode(file.Snapshot)
+	if err != nil {
+		return nil, err
+	}
+	if records == nil {
+		records = []RequestRecord{}
+	}
+	return &Capture{CaptureInfo: file.Capture, Records: records}, nil
+}
+
+func (s *captureStore) path(name string) string {
+	return filepath.Join(s.dir, name+".json")
+}
+
+// Create stores a new capture; names cannot be reused until the capture is deleted
+func (s *captureStore) Create(capture Capture) error {
+	if !captureNamePattern.MatchString(capture.Name) {
+		return fmt.Errorf("invalid capture name %q (letters, digits, '.', '_', and '-', up to 64 characters)", capture.Name)
+	}
+	capture.Count = len(capture.Records)
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if _, exists := s.captures[capture.Name]; exists {
+		return errCaptureExists
+	}
+	if s.dir != "" {
+		// Files that failed to load still hold their name
+		if _, err := os.Stat(s.path(capture.Name)); err == nil {
+			return errCaptureExists
+		}
+		sealer := recordSealer{provider: s.provider}
+		snapshot, err := sealer.encode(capture.Records)
+		if err != nil {
+			return err
+		}
+		data, err := json.Marshal(captureFile{Capture: capture.CaptureInfo, Snapshot: snapshot})
+		if err != nil {
+			return err
+		}
+		if err := writeFileAtomic(s.path(capture.Name), data, 0600); err != nil {
+			return fmt.Errorf("failed to write capture file: %v", err)
+		}
+	}
+	s.captures[capture.Name] = &capture
+	return nil
+}
+
+// List returns every capture's info, oldest first
+func (s *captureStore) List() []CaptureInfo {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	infos := make([]CaptureInfo, 0, len(s.captures))
+	for _, capture := range s.captures {
+		infos = append(infos, capture.CaptureInfo)
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		if !infos[i].CreatedAt.Equal(infos[j].CreatedAt) {
+			return infos[i].CreatedAt.Before(infos[j].CreatedAt)
+		}
+		return infos[i].Name < infos[j].Name
+	})
+	return infos
+}
+
+// Get returns the named capture. Captures are never modified, so callers may
+// read it without holding the lock.
+func (s *captureStore) Get(name string) (*Capture, bool) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	capture, ok := s.captures[name]
+	return capture, ok
+}
+
+// Delete removes a capture and its file, reporting whether it existed
+func (s *captureStore) Delete(name string) (bool, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if _, ok := s.captures[name]; !ok {
+		return false, nil
+	}
+	if s.dir != "" {
+		if err := os.Remove(s.path(name)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return false, fmt.Errorf("failed to delete capture file: %v", err)
+		}
+	}
+	delete(s.captures, name)
+	return true, nil
+}
+
+// captureRequest is the body of POST /captures
+type captureRequest struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Window      string `json:"window"` // Trailing duration or start/end; all of history when empty
+	Query       string `json:"query"`  // History filter parameters, e.g. host=api.example.com
+}
+
+// freezeCapture copies the history records matching the request
+func (p *Proxy) freezeCapture(request captureRequest, now time.Time) (Capture, error) {
+	capture := Capture{CaptureInfo: CaptureInfo{Name: request.Name, Description: request.Description, CreatedAt: now}}
+	if !captureNamePattern.MatchString(capture.Name) {
+		return capture, fmt.Errorf("invalid capture name %q (letters, digits, '.', '_', and '-', up to 64 characters)", capture.Name)
+	}
+
+	values, err := url.ParseQuery(strings.TrimPrefix(request.Query, "?"))
+	if err != nil {
+		return capture, fmt.Errorf("invalid query: %v", err)
+	}
+	filter, err := ParseRequestFilter(values, true)
+	if err != nil {
+		return capture, err
+	}
+	capture.Query = values.Encode()
+
+	records := p.history.GetFilteredRecords(filter)
+	if request.Window != "" {
+		start, end, err := parseReportWindow(request.Window, 0, now)
+		if err != nil {
+			return capture, fmt.Errorf("invalid window: %v", err)
+		}
+		capture.Start, capture.End = start, end
+		inWindow := []RequestRecord{}
+		for _, record := range records {
+			if !record.Timestamp.Before(start) && record.Timestamp.Before(end) {
+				inWindow = append(inWindow, record)
+			}
+		}
+		records = inWindow
+	} else if len(records) > 0 {
+		capture.Start, capture.End = records[len(records)-1].Timestamp, records[0].Timestamp
+	}
+	capture.Records = records
+	return capture, nil
+}
+
+// handleCaptures lists, creates, exports, and deletes named captures
+func (p *Proxy) handleCaptures(w http.ResponseWriter, r *http.Request) {
+	// Add CORS headers
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "GET, POST, DELETE, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, Cache-Control, Pragma, Expires")
+
+	// Handle preflight requests
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	var response interface{}
+	status := http.StatusOK
+	switch r.Method {
+	case http.MethodGet:
+		name := r.URL.Query().Get("name")
+		if name == "" {
+			response = map[string]interface{}{"captures": p.captures.List()}
+			break
+		}
+		capture, ok := p.captures.Get(name)
+		if !ok {
+			http.Error(w, "Capture not found", http.StatusNotFound)
+			return
+		}
+		switch format := r.URL.Query().Get("format"); format {
+		case "", "json":
+			response = capture
+		case "csv":
+			data, err := statsExportCSV(StatsExport{Start: capture.Start, End: capture.End, Routes: summarizeRoutes(capture.Records)})
+			if err != nil {
+				http.Error(w, "Failed to export capture", http.StatusInternalServerError)
+				return
+			}
+			w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+			w.Header().Set("Content-Disposition", `attachment; filename="netkit-capture-`+capture.Name+`.csv"`)
+			w.WriteHeader(http.StatusOK)
+			if _, err := w.Write(data); err != nil {
+				slog.Warn("Error writing capture export response", "error", err)
+			}
+			return
+		default:
+			http.Error(w, "Invalid format: expected json or csv", http.StatusBadRequest)
+			return
+		}
+
+	case http.MethodPost:
+		var request captureRequest
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			http.Error(w, "Invalid capture JSON", http.StatusBadRequest)
+			return
+		}
+		capture, err := p.freezeCapture(request, time.Now())
+		if err != nil {
+			http.Error(w, "Invalid capture: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := p.captures.Create(capture); err != nil {
+			if errors.Is(err, errCaptureExists) {
+				http.Error(w, "Capture already exists", http.StatusConflict)
+				return
+			}
+			slog.Error("Error saving capture", "capture", capture.Name, "error", err)
+			http.Error(w, "Failed to save capture", http.StatusInternalServerError)
+			return
+		}
+		saved, _ := p.captures.Get(capture.Name)
+		response = saved.CaptureInfo
+		status = http.StatusCreated
+
+	case http.MethodDelete:
+		if !p.allowPurge(w, r) {
+			return
+		}
+		deleted, err := p.captures.Delete(r.URL.Query().Get("name"))
+		if err != nil {
+			http.Error(w, "Failed to delete capture", http.StatusInternalServerError)
+			return
+		}
+		if !deleted {
+			http.Error(w, "Capture not found", http.StatusNotFound)
+			return
+		}
+		response = map[string]interface{}{"success": true, "message": "Capture deleted"}
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	data, err := json.Marshal(response)
+	if err != nil {
+		http.Error(w, "Failed to encode captures", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if _, err := w.Write(data); err != nil {
+		slog.Warn("Error writing captures response", "error", err)
+	}
+}
+
+// handleCaptureDiff compares two captures overall and per route
+func (p *Proxy) handleCaptureDiff(w http.ResponseWriter, r *http.Request) {
+	// Add CORS headers
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, Cache-Control, Pragma, Expires")
+
+	// Handle preflight requests
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := r.URL.Query()
+	if query.Get("a") == "" || query.Get("b") == "" {
+		http.Error(w, "Both a and b capture names are required", http.StatusBadRequest)
+		return
+	}
+	a, ok := p.captures.Get(query.Get("a"))
+	if !ok {
+		http.Error(w, "Capture a not found", http.StatusNotFound)
+		return
+	}
+	b, ok := p.captures.Get(query.Get("b"))
+	if !ok {
+		http.Error(w, "Capture b not found", http.StatusNotFound)
+		return
+	}
+
+	comparison := compareRecords(a.Records, b.Records)
+	comparison.WindowA.Start, comparison.WindowA.End = a.Start, a.End
+	comparison.WindowB.Start, comparison.WindowB.End = b.Start, b.End
+	data, err := json.Marshal(map[string]interface{}{
+		"a":          a.Name,
+		"b":          b.Name,
+		"comparison": comparison,
+	})
+	if err != nil {
+		http.Error(w, "Failed to compare captures", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if _, err := w.Write(data); err != nil {
+		slog.Warn("Error writing capture diff response", "error", err)
+	}
+}
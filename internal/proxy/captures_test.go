@@ -0,0 +1,215 @@
+//go:build unit
+
+package proxy
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func capturesCall(p *Proxy, handler http.HandlerFunc, method, target, body string) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	handler(rec, httptest.NewRequest(method, target, strings.NewReader(body)))
+	return rec
+}
+
+func seedCaptureHistory(p *Proxy, now time.Time, durationUs int64, status int) {
+	var records []RequestRecord
+	for i, path := range []string{"/users/1", "/users/2", "/orders"} {
+		records = append(records, RequestRecord{
+			ID:              path,
+			Method:          http.MethodGet,
+			URL:             "http://api.example.com" + path,
+			ResponseStatus:  status,
+			ResponseBody:    "secret-body",
+			Success:         true,
+			TotalDurationUs: durationUs,
+			Timestamp:       now.Add(-time.Duration(i) * time.Minute),
+		})
+	}
+	p.history.restore(records)
+}
+
+func TestCapturesLifecycle(t *testing.T) {
+	p := New(&Config{})
+	now := time.Now()
+	seedCaptureHistory(p, now, 10000, 200)
+
+	rec := capturesCall(p, p.handleCaptures, http.MethodPost, "/captures", `{"name": "before", "description": "flag off", "query": "path=/users"}`)
+	require.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+	var info CaptureInfo
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &info))
+	assert.Equal(t, 2, info.Count)
+	assert.Equal(t, "path=%2Fusers", info.Query)
+	assert.Equal(t, now.Add(-time.Minute).Unix(), info.Start.Unix())
+	assert.Equal(t, now.Unix(), info.End.Unix())
+
+	// Captures are immutable: later history changes do not touch them
+	p.history.Clear()
+	rec = capturesCall(p, p.handleCaptures, http.MethodPost, "/captures", `{"name": "before"}`)
+	assert.Equal(t, http.StatusConflict, rec.Code)
+
+	rec = capturesCall(p, p.handleCaptures, http.MethodGet, "/captures?name=before", "")
+	require.Equal(t, http.StatusOK, rec.Code)
+	var capture Capture
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &capture))
+	assert.Equal(t, "flag off", capture.Description)
+	require.Len(t, capture.Records, 2)
+	assert.Equal(t, "/users/1", capture.Records[0].ID)
+
+	rec = capturesCall(p, p.handleCaptures, http.MethodGet, "/captures?name=before&format=csv", "")
+	require.Equal(t, http.StatusOK, rec.Code)
+	rows, err := csv.NewReader(strings.NewReader(rec.Body.String())).ReadAll()
+	require.NoError(t, err)
+	require.Len(t, rows, 2)
+	assert.Equal(t, []string{"GET", "api.example.com/users/{id}", "2"}, rows[1][2:5])
+
+	rec = capturesCall(p, p.handleCaptures, http.MethodGet, "/captures", "")
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.NotContains(t, rec.Body.String(), "secret-body", "listing leaves out records")
+
+	assert.Equal(t, http.StatusOK, capturesCall(p, p.handleCaptures, http.MethodDelete, "/captures?name=before", "").Code)
+	assert.Equal(t, http.StatusNotFound, capturesCall(p, p.handleCaptures, http.MethodDelete, "/captures?name=before", "").Code)
+	assert.Equal(t, http.StatusNotFound, capturesCall(p, p.handleCaptures, http.MethodGet, "/captures?name=before", "").Code)
+}
+
+func TestCaptureDiff(t *testing.T) {
+	p := New(&Config{})
+	now := time.Now()
+
+	seedCaptureHistory(p, now, 10000, 200)
+	require.Equal(t, http.StatusCreated, capturesCall(p, p.handleCaptures, http.MethodPost, "/captures", `{"name": "before"}`).Code)
+	seedCaptureHistory(p, now, 30000, 500)
+	require.Equal(t, http.StatusCreated, capturesCall(p, p.handleCaptures, http.MethodPost, "/captures", `{"name": "after", "window": "90s"}`).Code)
+
+	rec := capturesCall(p, p.handleCaptureDiff, http.MethodGet, "/captures/diff?a=before&b=after", "")
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	var diff struct {
+		A          string          `json:"a"`
+		B          string          `json:"b"`
+		Comparison StatsComparison `json:"comparison"`
+	}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &diff))
+	assert.Equal(t, 3, diff.Comparison.WindowA.Count)
+	assert.Equal(t, 2, diff.Comparison.WindowB.Count)
+	assert.Equal(t, -1, diff.Comparison.Delta.Count)
+	assert.InDelta(t, 1.0, diff.Comparison.Delta.ErrorRate, 0.001)
+	require.NotNil(t, diff.Comparison.Delta.P50DurationUs)
+	assert.Equal(t, int64(20000), *diff.Comparison.Delta.P50DurationUs)
+	assert.Equal(t, now.Add(-90*time.Second).Unix(), diff.Comparison.WindowB.Start.Unix())
+
+	assert.Equal(t, http.StatusNotFound, capturesCall(p, p.handleCaptureDiff, http.MethodGet, "/captures/diff?a=before&b=missing", "").Code)
+	assert.Equal(t, http.StatusBadRequest, capturesCall(p, p.handleCaptureDiff, http.MethodGet, "/captures/diff?a=before", "").Code)
+}
+
+func TestCaptureValidation(t *testing.T) {
+	p := New(&Config{})
+	for _, body := range []string{
+		`{"name": "../etc/passwd"}`,
+		`{"name": ""}`,
+		`{"name": "has space"}`,
+		`{"name": "x", "window": "soon"}`,
+		`{"name": "x", "query": "colour=red"}`,
+		`not json`,
+	} {
+		assert.Equal(t, http.StatusBadRequest, capturesCall(p, p.handleCaptures, http.MethodPost, "/captures", body).Code, body)
+	}
+	require.Equal(t, http.StatusCreated, capturesCall(p, p.handleCaptures, http.MethodPost, "/captures", `{"name": "x"}`).Code)
+	assert.Equal(t, http.StatusBadRequest, capturesCall(p, p.handleCaptures, http.MethodGet, "/captures?name=x&format=xml", "").Code)
+}
+
+func TestCaptureStorePersistence(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "captures")
+	provider := envHistoryKey(t, "capture passphrase")
+	p := New(&Config{CapturesDir: dir, HistoryKey: provider})
+	seedCaptureHistory(p, time.Now(), 10000, 200)
+	require.Equal(t, http.StatusCreated, capturesCall(p, p.handleCaptures, http.MethodPost, "/captures", `{"name": "baseline"}`).Code)
+
+	data, err := os.ReadFile(filepath.Join(dir, "baseline.json"))
+	require.NoError(t, err)
+	assert.NotContains(t, string(data), "secret-body", "captures are encrypted with the history key")
+
+	reloaded, err := newCaptureStore(dir, provider)
+	require.NoError(t, err)
+	capture, ok := reloaded.Get("baseline")
+	require.True(t, ok)
+	assert.Equal(t, 3, capture.Count)
+	assert.Equal(t, "secret-body", capture.Records[0].ResponseBody)
+
+	// A capture that cannot be decrypted is skipped but keeps its name
+	locked, err := newCaptureStore(dir, envHistoryKey(t, "wrong"))
+	require.NoError(t, err)
+	assert.Empty(t, locked.List())
+	assert.ErrorIs(t, locked.Create(Capture{CaptureInfo: CaptureInfo{Name: "baseline"}}), errCaptureExists)
+
+	deleted, err := reloaded.Delete("baseline")
+	require.NoError(t, err)
+	assert.True(t, deleted)
+	_, err = os.Stat(filepath.Join(dir, "baseline.json"))
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestCaptureDiffAgainstBaseline(t *testing.T) {
+	p := New(&Config{})
+	now := time.Now()
+
+	seedCaptureHistory(p, now, 10000, 200)
+	require.Equal(t, http.StatusCreated, capturesCall(p, p.handleCaptures, http.MethodPost, "/captures", `{"name": "before"}`).Code)
+	require.Equal(t, http.StatusCreated, capturesCall(p, p.handleCaptures, http.MethodPost, "/captures", `{"name": "same"}`).Code)
+
+	rec := capturesCall(p, p.handleCaptureSubresource, http.MethodGet, "/captures/same/diff?against=before", "")
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	var diff CaptureDiff
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &diff))
+	assert.True(t, diff.Matches)
+	assert.Equal(t, "same", diff.Capture)
+	assert.Equal(t, "before", diff.Against)
+
+	// The refactor drops /orders, adds /carts, and fails some user lookups
+	p.history.restore([]RequestRecord{
+		{Method: http.MethodGet, URL: "http://api.example.com/users/1", ResponseStatus: 200, Success: true, TotalDurationUs: 30000, Timestamp: now},
+		{Method: http.MethodGet, URL: "http://api.example.com/users/2", ResponseStatus: 500, Success: true, TotalDurationUs: 30000, Timestamp: now},
+		{Method: http.MethodGet, URL: "http://api.example.com/carts", ResponseStatus: 200, Success: true, TotalDurationUs: 5000, Timestamp: now},
+	})
+	require.Equal(t, http.StatusCreated, capturesCall(p, p.handleCaptures, http.MethodPost, "/captures", `{"name": "after"}`).Code)
+
+	rec = capturesCall(p, p.handleCaptureSubresource, http.MethodGet, "/captures/after/diff?against=before", "")
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &diff))
+	assert.False(t, diff.Matches)
+	assert.Equal(t, 1, diff.RoutesAdded)
+	assert.Equal(t, 1, diff.RoutesRemoved)
+	assert.Equal(t, 1, diff.StatusChanges)
+
+	routes := map[string]RouteDiff{}
+	for _, route := range diff.Routes {
+		routes[route.Route] = route
+	}
+	users := routes["api.example.com/users/{id}"]
+	assert.Equal(t, RouteInBoth, users.Presence)
+	assert.True(t, users.StatusesChanged)
+	assert.Equal(t, []StatusDiff{
+		{Status: 200, Baseline: 2, Capture: 1, BaselineShare: 1, CaptureShare: 0.5},
+		{Status: 500, Baseline: 0, Capture: 1, BaselineShare: 0, CaptureShare: 0.5},
+	}, users.Statuses)
+	require.NotNil(t, users.Delta.P50DurationUs)
+	assert.Equal(t, int64(20000), *users.Delta.P50DurationUs)
+	assert.Equal(t, RouteRemoved, routes["api.example.com/orders"].Presence)
+	assert.Equal(t, RouteAdded, routes["api.example.com/carts"].Presence)
+	assert.False(t, routes["api.example.com/carts"].StatusesChanged, "new routes count as added, not as status changes")
+
+	assert.Equal(t, http.StatusBadRequest, capturesCall(p, p.handleCaptureSubresource, http.MethodGet, "/captures/after/diff", "").Code)
+	assert.Equal(t, http.StatusNotFound, capturesCall(p, p.handleCaptureSubresource, http.MethodGet, "/captures/after/diff?against=missing", "").Code)
+	assert.Equal(t, http.StatusNotFound, capturesCall(p, p.handleCaptureSubresource, http.MethodGet, "/captures/missing/diff?against=before", "").Code)
+	assert.Equal(t, http.StatusNotFound, capturesCall(p, p.handleCaptureSubresource, http.MethodGet, "/captures/after/other", "").Code)
+}
@@ -0,0 +1,96 @@
+package proxy
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// processClock anchors monotonic readings for this proxy process. Instants
+// taken with time.Now carry a monotonic reading, so durations between them
+// are unaffected by wall clock steps such as NTP adjustments.
+var processClock = struct {
+	id    string    // Changes on every start, so offsets are only compared within one process
+	start time.Time // Wall and monotonic reading at startup
+}{id: generateID()[:16], start: time.Now()}
+
+// ServerTime is the proxy's clock as reported by GET /time
+type ServerTime struct {
+	Time        time.Time `json:"time"`      // Wall clock
+	UnixNano    int64     `json:"unix_nano"` // Wall clock in nanoseconds since the Unix epoch
+	ClockID     string    `json:"clock_id"`  // Matches clock_id on records taken by this process
+	MonotonicUs int64     `json:"monotonic_us"`
+	StartedAt   time.Time `json:"started_at"`
+}
+
+// monotonicOffset returns microseconds from process start to t on the
+// monotonic clock. Instants without a monotonic reading, such as restored
+// ones, fall back to the wall clock.
+func monotonicOffset(t time.Time) int64 {
+	return t.Sub(processClock.start).Microseconds()
+}
+
+// elapsed returns the time between two recorded instants, or 0 unless both
+// were reached
+func elapsed(start, end time.Time) time.Duration {
+	if start.IsZero() || end.IsZero() {
+		return 0
+	}
+	return end.Sub(start)
+}
+
+// measure fills in a record's durations on the monotonic clock and where its
+// start sits on this process's clock. Restored records keep what they were
+// measured with.
+func (r *RequestRecord) measure() {
+	if r.ClockID != "" && r.ClockID != processClock.id {
+		return
+	}
+	r.TotalDurationUs = elapsed(r.ProxyStartTime, r.ProxyEndTime).Microseconds()
+	r.UpstreamLatencyUs = elapsed(r.UpstreamStartTime, r.UpstreamEndTime).Microseconds()
+	r.ProxyOverheadUs = r.TotalDurationUs - r.UpstreamLatencyUs
+	if !r.ProxyStartTime.IsZero() {
+		r.ClockID = processClock.id
+		r.MonotonicStartUs = monotonicOffset(r.ProxyStartTime)
+	}
+}
+
+// handleTime reports the proxy's wall and monotonic clocks, so records can be
+// correlated with other systems and clock skew measured
+func (p *Proxy) handleTime(w http.ResponseWriter, r *http.Request) {
+	// Add CORS headers
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, Cache-Control, Pragma, Expires")
+
+	// Handle preflight requests
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	now := time.Now()
+	data, err := json.Marshal(ServerTime{
+		Time:        now,
+		UnixNano:    now.UnixNano(),
+		ClockID:     processClock.id,
+		MonotonicUs: monotonicOffset(now),
+		StartedAt:   processClock.start,
+	})
+	if err != nil {
+		http.Error(w, "Failed to encode server time", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if _, err := w.Write(data); err != nil {
+		slog.Warn("Error writing server time response", "error", err)
+	}
+}
@@ -0,0 +1,70 @@
+//go:build unit
+
+package proxy
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRecordMeasure(t *testing.T) {
+	start := time.Now()
+	record := RequestRecord{
+		ProxyStartTime:    start,
+		UpstreamStartTime: start.Add(time.Millisecond),
+		UpstreamEndTime:   start.Add(4 * time.Millisecond),
+		ProxyEndTime:      start.Add(5 * time.Millisecond),
+	}
+	record.measure()
+	assert.Equal(t, int64(5000), record.TotalDurationUs)
+	assert.Equal(t, int64(3000), record.UpstreamLatencyUs)
+	assert.Equal(t, int64(2000), record.ProxyOverheadUs)
+	assert.Equal(t, processClock.id, record.ClockID)
+	assert.Equal(t, start.Sub(processClock.start).Microseconds(), record.MonotonicStartUs)
+
+	// A request that never reached the upstream has no upstream latency
+	rejected := RequestRecord{ProxyStartTime: start, ProxyEndTime: start.Add(time.Millisecond)}
+	rejected.measure()
+	assert.Zero(t, rejected.UpstreamLatencyUs)
+	assert.Equal(t, int64(1000), rejected.ProxyOverheadUs)
+
+	// Records measured by another process keep their durations
+	restored := RequestRecord{ClockID: "earlier", TotalDurationUs: 42, ProxyStartTime: start.Round(0), ProxyEndTime: start.Round(0).Add(time.Hour)}
+	restored.measure()
+	assert.Equal(t, int64(42), restored.TotalDurationUs)
+	assert.Equal(t, "earlier", restored.ClockID)
+}
+
+func TestTailSamplingSeesLiveDurations(t *testing.T) {
+	sampling := &TailSampling{SlowThreshold: time.Millisecond}
+	start := time.Now()
+	record := RequestRecord{ProxyStartTime: start, ProxyEndTime: start.Add(2 * time.Millisecond), Success: true, ResponseStatus: 200}
+	record.measure()
+	assert.True(t, sampling.Interesting(record))
+}
+
+func TestServerTime(t *testing.T) {
+	p := New(&Config{})
+	before := time.Now()
+	rec := httptest.NewRecorder()
+	p.handleTime(rec, httptest.NewRequest(http.MethodGet, "/time", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	var serverTime ServerTime
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &serverTime))
+	assert.Equal(t, processClock.id, serverTime.ClockID)
+	assert.False(t, serverTime.Time.Before(before.Round(0)))
+	assert.Equal(t, serverTime.Time.UnixNano(), serverTime.UnixNano)
+	assert.GreaterOrEqual(t, serverTime.MonotonicUs, before.Sub(processClock.start).Microseconds())
+	assert.Equal(t, processClock.start.UnixNano(), serverTime.StartedAt.UnixNano())
+
+	rec = httptest.NewRecorder()
+	p.handleTime(rec, httptest.NewRequest(http.MethodPost, "/time", nil))
+	assert.Equal(t, http.StatusMethodNotAllowed, rec.Code)
+}
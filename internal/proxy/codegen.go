@@ -0,0 +1,142 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Languages code can be generated in
+const (
+	CodegenGo     = "go"     // net/http
+	CodegenPython = "python" // requests
+	CodegenJS     = "js"     // fetch
+)
+
+// codegenSkippedHeaders are set by the client library or only meant for the
+// proxy, so generated code leaves them out
+var codegenSkippedHeaders = map[string]bool{
+	"Accept-Encoding":      true, // Clients negotiate and decode compression themselves
+	"Connection":           true,
+	"Content-Length":       true,
+	"Host":                 true,
+	"Proxy-Authorization":  true,
+	"Proxy-Connection":     true,
+	"Transfer-Encoding":    true,
+	"X-Netkit-Destination": true,
+	RequestOptionsHeader:   true,
+	RunHeader:              true,
+	ReplayHeader:           true,
+}
+
+// ValidateCodegenLanguage checks that code can be generated in a language
+func ValidateCodegenLanguage(lang string) error {
+	switch lang {
+	case CodegenGo, CodegenPython, CodegenJS:
+		return nil
+	}
+	return fmt.Errorf("unknown language %q (expected go, python, or js)", lang)
+}
+
+// GenerateCode returns a ready-to-run program that sends the recorded request
+// again and prints the response. Bodies are sent as recorded, so a truncated
+// body is sent truncated.
+func GenerateCode(record RequestRecord, lang string) (string, error) {
+	if err := ValidateCodegenLanguage(lang); err != nil {
+		return "", err
+	}
+	method := record.Method
+	if method == "" {
+		method = http.MethodGet
+	}
+
+	var headers []string
+	for name := range record.RequestHeaders {
+		if !codegenSkippedHeaders[http.CanonicalHeaderKey(name)] {
+			headers = append(headers, name)
+		}
+	}
+	sort.Strings(headers)
+
+	var b strings.Builder
+	switch lang {
+	case CodegenGo:
+		imports := []string{"fmt", "io", "log", "net/http"}
+		body := "nil"
+		if record.RequestBody != "" {
+			imports = append(imports, "strings")
+			body = "strings.NewReader(" + strconv.Quote(record.RequestBody) + ")"
+		}
+		b.WriteString("package main\n\nimport (\n")
+		for _, pkg := range imports {
+			fmt.Fprintf(&b, "\t%q\n", pkg)
+		}
+		b.WriteString(")\n\nfunc main() {\n")
+		fmt.Fprintf(&b, "\treq, err := http.NewRequest(%q, %q, %s)\n", method, record.URL, body)
+		b.WriteString("\tif err != nil {\n\t\tlog.Fatal(err)\n\t}\n")
+		for _, name := range headers {
+			fmt.Fprintf(&b, "\treq.Header.Set(%q, %q)\n", name, record.RequestHeaders[name])
+		}
+		b.WriteString("\n\tresp, err := http.DefaultClient.Do(req)\n")
+		b.WriteString("\tif err != nil {\n\t\tlog.Fatal(err)\n\t}\n")
+		b.WriteString("\tdefer resp.Body.Close()\n\n")
+		b.WriteString("\tdata, err := io.ReadAll(resp.Body)\n")
+		b.WriteString("\tif err != nil {\n\t\tlog.Fatal(err)\n\t}\n")
+		b.WriteString("\tfmt.Println(resp.Status)\n")
+		b.WriteString("\tfmt.Println(string(data))\n")
+		b.WriteString("}\n")
+
+	case CodegenPython:
+		b.WriteString("import requests\n\n")
+		b.WriteString("response = requests.request(\n")
+		fmt.Fprintf(&b, "    %s,\n    %s,\n", jsonString(method), jsonString(record.URL))
+		if len(headers) > 0 {
+			b.WriteString("    headers={\n")
+			for _, name := range headers {
+				fmt.Fprintf(&b, "        %s: %s,\n", jsonString(name), jsonString(record.RequestHeaders[name]))
+			}
+			b.WriteString("    },\n")
+		}
+		if record.RequestBody != "" {
+			fmt.Fprintf(&b, "    data=%s,\n", jsonString(record.RequestBody))
+		}
+		b.WriteString(")\n")
+		b.WriteString("print(response.status_code, response.reason)\n")
+		b.WriteString("print(response.text)\n")
+
+	case CodegenJS:
+		// Top-level await needs an ES module, e.g. a .mjs file run with Node 18+
+		fmt.Fprintf(&b, "const response = await fetch(%s, {\n", jsonString(record.URL))
+		fmt.Fprintf(&b, "  method: %s,\n", jsonString(method))
+		if len(headers) > 0 {
+			b.WriteString("  headers: {\n")
+			for _, name := range headers {
+				fmt.Fprintf(&b, "    %s: %s,\n", jsonString(name), jsonString(record.RequestHeaders[name]))
+			}
+			b.WriteString("  },\n")
+		}
+		if record.RequestBody != "" {
+			fmt.Fprintf(&b, "  body: %s,\n", jsonString(record.RequestBody))
+		}
+		b.WriteString("});\n")
+		b.WriteString("console.log(response.status, response.statusText);\n")
+		b.WriteString("console.log(await response.text());\n")
+	}
+	return b.String(), nil
+}
+
+// jsonString quotes s as a JSON string, which Python and JavaScript accept as
+// a string literal
+func jsonString(s string) string {
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(s); err != nil {
+		return strconv.Quote(s)
+	}
+	return strings.TrimSuffix(buf.String(), "\n")
+}
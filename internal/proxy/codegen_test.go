@@ -0,0 +1,85 @@
+//go:build unit
+
+package proxy
+
+import (
+	"go/format"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func codegenRecord() RequestRecord {
+	return RequestRecord{
+		ID:     "abc",
+		Method: "POST",
+		URL:    "https://api.example.com/users?expand=team",
+		RequestHeaders: map[string]string{
+			"Content-Type":    "application/json",
+			"Authorization":   "Bearer t0ken",
+			"Content-Length":  "27",
+			"Accept-Encoding": "gzip",
+			"X-Netkit-Run":    "run-1",
+		},
+		RequestBody: `{"name": "Ada", "note": "<b>"}`,
+	}
+}
+
+func TestGenerateGo(t *testing.T) {
+	code, err := GenerateCode(codegenRecord(), CodegenGo)
+	require.NoError(t, err)
+
+	formatted, err := format.Source([]byte(code))
+	require.NoError(t, err, "generated Go code parses")
+	assert.Equal(t, string(formatted), code, "generated Go code is gofmt-clean")
+	assert.Contains(t, code, `http.NewRequest("POST", "https://api.example.com/users?expand=team", strings.NewReader("{\"name\": \"Ada\", \"note\": \"<b>\"}"))`)
+	assert.Contains(t, code, `req.Header.Set("Authorization", "Bearer t0ken")`)
+	assert.NotContains(t, code, "Content-Length")
+	assert.NotContains(t, code, "X-Netkit-Run")
+	assert.NotContains(t, code, "gzip")
+
+	// Requests without a body do not import strings
+	code, err = GenerateCode(RequestRecord{URL: "http://example.com/"}, CodegenGo)
+	require.NoError(t, err)
+	_, err = format.Source([]byte(code))
+	require.NoError(t, err)
+	assert.Contains(t, code, `http.NewRequest("GET", "http://example.com/", nil)`)
+	assert.NotContains(t, code, `"strings"`)
+}
+
+func TestGeneratePythonAndJS(t *testing.T) {
+	code, err := GenerateCode(codegenRecord(), CodegenPython)
+	require.NoError(t, err)
+	assert.Equal(t, `import requests
+
+response = requests.request(
+    "POST",
+    "https://api.example.com/users?expand=team",
+    headers={
+        "Authorization": "Bearer t0ken",
+        "Content-Type": "application/json",
+    },
+    data="{\"name\": \"Ada\", \"note\": \"<b>\"}",
+)
+print(response.status_code, response.reason)
+print(response.text)
+`, code)
+
+	code, err = GenerateCode(codegenRecord(), CodegenJS)
+	require.NoError(t, err)
+	assert.Equal(t, `const response = await fetch("https://api.example.com/users?expand=team", {
+  method: "POST",
+  headers: {
+    "Authorization": "Bearer t0ken",
+    "Content-Type": "application/json",
+  },
+  body: "{\"name\": \"Ada\", \"note\": \"<b>\"}",
+});
+console.log(response.status, response.statusText);
+console.log(await response.text());
+`, code)
+
+	_, err = GenerateCode(codegenRecord(), "ruby")
+	assert.Error(t, err)
+}
@@ -0,0 +1,261 @@
+package proxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// TrafficStats is the volume, error rate, and latency of a set of requests
+type TrafficStats struct {
+	Count         int     `json:"count"`
+	ErrorCount    int     `json:"error_count"`
+	ErrorRate     float64 `json:"error_rate"` // Fraction of requests that failed, 0-1
+	P50DurationUs int64   `json:"p50_duration_us"`
+	P95DurationUs int64   `json:"p95_duration_us"`
+	P99DurationUs int64   `json:"p99_duration_us"`
+}
+
+// ComparisonWindow is the traffic in one of the compared time windows
+type ComparisonWindow struct {
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+	TrafficStats
+}
+
+// StatsDelta is window B minus window A. Latency deltas are only set when
+// the route had traffic in both windows.
+type StatsDelta struct {
+	Count         int      `json:"count"`
+	CountChange   *float64 `json:"count_change,omitempty"` // Relative change, e.g. 0.5 for +50%
+	ErrorRate     float64  `json:"error_rate"`
+	P50DurationUs *int64   `json:"p50_duration_us,omitempty"`
+	P95DurationUs *int64   `json:"p95_duration_us,omitempty"`
+	P99DurationUs *int64   `json:"p99_duration_us,omitempty"`
+}
+
+// RouteComparison compares one method and normalized route across both windows
+type RouteComparison struct {
+	Method string       `json:"method"`
+	Route  string       `json:"route"`
+	A      TrafficStats `json:"a"`
+	B      TrafficStats `json:"b"`
+	Delta  StatsDelta   `json:"delta"`
+}
+
+// StatsComparison is the result of comparing two time windows of history
+type StatsComparison struct {
+	WindowA ComparisonWindow  `json:"window_a"`
+	WindowB ComparisonWindow  `json:"window_b"`
+	Delta   StatsDelta        `json:"delta"`
+	Routes  []RouteComparison `json:"routes"` // Busiest routes (both windows combined) first
+}
+
+// trafficAccumulator collects durations until percentiles are computed
+type trafficAccumulator struct {
+	errors    int
+	durations []int64
+}
+
+func (a *trafficAccumulator) add(record RequestRecord) {
+	if isFailedRecord(record) {
+		a.errors++
+	}
+	a.durations = append(a.durations, record.TotalDurationUs)
+}
+
+func (a *trafficAccumulator) stats() TrafficStats {
+	if a == nil || len(a.durations) == 0 {
+		return TrafficStats{}
+	}
+	return TrafficStats{
+		Count:         len(a.durations),
+		ErrorCount:    a.errors,
+		ErrorRate:     float64(a.errors) / float64(len(a.durations)),
+		P50DurationUs: percentile(a.durations, 50),
+		P95DurationUs: percentile(a.durations, 95),
+		P99DurationUs: percentile(a.durations, 99),
+	}
+}
+
+// CompareStats compares the records in window A ([startA, endA)) with window B
+func CompareStats(records []RequestRecord, startA, endA, startB, endB time.Time) StatsComparison {
+	var recordsA, recordsB []RequestRecord
+	for _, record := range records {
+		if !record.Timestamp.Before(startA) && record.Timestamp.Before(endA) {
+			recordsA = append(recordsA, record)
+		}
+		if !record.Timestamp.Before(startB) && record.Timestamp.Before(endB) {
+			recordsB = append(recordsB, record)
+		}
+	}
+
+	comparison := compareRecords(recordsA, recordsB)
+	comparison.WindowA.Start, comparison.WindowA.End = startA, endA
+	comparison.WindowB.Start, comparison.WindowB.End = startB, endB
+	return comparison
+}
+
+// compareRecords compares two sets of records overall and per route,
+// leaving the window bounds to the caller
+func compareRecords(recordsA, recordsB []RequestRecord) StatsComparison {
+	type routeKey struct{ method, route string }
+	var totalA, totalB trafficAccumulator
+	routesA := make(map[routeKey]*trafficAccumulator)
+	routesB := make(map[routeKey]*trafficAccumulator)
+
+	for _, record := range recordsA {
+		key := routeKey{record.Method, normalizeRoute(record.URL)}
+		totalA.add(record)
+		if routesA[key] == nil {
+			routesA[key] = &trafficAccumulator{}
+		}
+		routesA[key].add(record)
+	}
+	for _, record := range recordsB {
+		key := routeKey{record.Method, normalizeRoute(record.URL)}
+		totalB.add(record)
+		if routesB[key] == nil {
+			routesB[key] = &trafficAccumulator{}
+		}
+		routesB[key].add(record)
+	}
+
+	comparison := StatsComparison{
+		WindowA: ComparisonWindow{TrafficStats: totalA.stats()},
+		WindowB: ComparisonWindow{TrafficStats: totalB.stats()},
+		Routes:  []RouteComparison{},
+	}
+	comparison.Delta = statsDelta(comparison.WindowA.TrafficStats, comparison.WindowB.TrafficStats)
+
+	keys := make(map[routeKey]bool)
+	for key := range routesA {
+		keys[key] = true
+	}
+	for key := range routesB {
+		keys[key] = true
+	}
+	for key := range keys {
+		a, b := routesA[key].stats(), routesB[key].stats()
+		comparison.Routes = append(comparison.Routes, RouteComparison{
+			Method: key.method,
+			Route:  key.route,
+			A:      a,
+			B:      b,
+			Delta:  statsDelta(a, b),
+		})
+	}
+	sort.Slice(comparison.Routes, func(i, j int) bool {
+		ri, rj := comparison.Routes[i], comparison.Routes[j]
+		if ri.A.Count+ri.B.Count != rj.A.Count+rj.B.Count {
+			return ri.A.Count+ri.B.Count > rj.A.Count+rj.B.Count
+		}
+		return ri.Method+" "+ri.Route < rj.Method+" "+rj.Route
+	})
+	return comparison
+}
+
+func statsDelta(a, b TrafficStats) StatsDelta {
+	delta := StatsDelta{
+		Count:     b.Count - a.Count,
+		ErrorRate: b.ErrorRate - a.ErrorRate,
+	}
+	if a.Count > 0 {
+		change := float64(b.Count-a.Count) / float64(a.Count)
+		delta.CountChange = &change
+	}
+	if a.Count > 0 && b.Count > 0 {
+		p50 := b.P50DurationUs - a.P50DurationUs
+		p95 := b.P95DurationUs - a.P95DurationUs
+		p99 := b.P99DurationUs - a.P99DurationUs
+		delta.P50DurationUs, delta.P95DurationUs, delta.P99DurationUs = &p50, &p95, &p99
+	}
+	return delta
+}
+
+// ParseTimeWindow parses a "start/end" window. Each bound is an RFC 3339
+// time, "now", or a duration meaning that long before now (e.g. "2h/1h").
+func ParseTimeWindow(value string, now time.Time) (time.Time, time.Time, error) {
+	startValue, endValue, ok := strings.Cut(value, "/")
+	if !ok {
+		return time.Time{}, time.Time{}, fmt.Errorf("expected start/end, got %q", value)
+	}
+	start, err := parseWindowBound(startValue, now)
+	if err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+	end, err := parseWindowBound(endValue, now)
+	if err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+	if !end.After(start) {
+		return time.Time{}, time.Time{}, fmt.Errorf("window end must be after its start")
+	}
+	return start, end, nil
+}
+
+func parseWindowBound(value string, now time.Time) (time.Time, error) {
+	value = strings.TrimSpace(value)
+	if value == "now" {
+		return now, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	if ago, err := time.ParseDuration(value); err == nil && ago >= 0 {
+		return now.Add(-ago), nil
+	}
+	return time.Time{}, fmt.Errorf("invalid window bound %q (expected RFC 3339 time, duration ago, or now)", value)
+}
+
+// handleStatsCompare handles traffic comparison requests between two time windows
+func (p *Proxy) handleStatsCompare(w http.ResponseWriter, r *http.Request) {
+	// Add CORS headers
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, Cache-Control, Pragma, Expires")
+
+	// Handle preflight requests
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	now := time.Now()
+	query := r.URL.Query()
+	if query.Get("windowA") == "" || query.Get("windowB") == "" {
+		http.Error(w, "Both windowA and windowB are required", http.StatusBadRequest)
+		return
+	}
+	startA, endA, err := ParseTimeWindow(query.Get("windowA"), now)
+	if err != nil {
+		http.Error(w, "Invalid windowA: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	startB, endB, err := ParseTimeWindow(query.Get("windowB"), now)
+	if err != nil {
+		http.Error(w, "Invalid windowB: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	data, err := json.Marshal(CompareStats(p.history.GetRecords(), startA, endA, startB, endB))
+	if err != nil {
+		http.Error(w, "Failed to compare stats", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if _, err := w.Write(data); err != nil {
+		slog.Warn("Error writing stats comparison response", "error", err)
+	}
+}
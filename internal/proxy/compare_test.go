@@ -0,0 +1,113 @@
+//go:build unit
+
+package proxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCompareStats(t *testing.T) {
+	deploy := time.Date(2024, 1, 15, 15, 0, 0, 0, time.UTC)
+	var records []RequestRecord
+	add := func(at time.Time, url string, status int, durationUs int64) {
+		records = append([]RequestRecord{{
+			ID:              fmt.Sprintf("r%d", len(records)),
+			Timestamp:       at,
+			Method:          http.MethodGet,
+			URL:             url,
+			ResponseStatus:  status,
+			Success:         true,
+			TotalDurationUs: durationUs,
+		}}, records...)
+	}
+
+	// Before the deploy: fast and healthy
+	for i := 0; i < 10; i++ {
+		add(deploy.Add(-time.Duration(i+1)*time.Minute), fmt.Sprintf("http://api.example.com/users/%d", i), http.StatusOK, 1000)
+	}
+	add(deploy.Add(-time.Minute), "http://api.example.com/legacy", http.StatusOK, 500)
+	// After: slower with errors, and a new route
+	for i := 0; i < 20; i++ {
+		status := http.StatusOK
+		if i%4 == 0 {
+			status = http.StatusInternalServerError
+		}
+		add(deploy.Add(time.Duration(i+1)*time.Minute), fmt.Sprintf("http://api.example.com/users/%d", i), status, 3000)
+	}
+	add(deploy.Add(time.Minute), "http://api.example.com/v2/users", http.StatusOK, 2000)
+
+	comparison := CompareStats(records, deploy.Add(-time.Hour), deploy, deploy, deploy.Add(time.Hour))
+
+	assert.Equal(t, 11, comparison.WindowA.Count)
+	assert.Equal(t, 21, comparison.WindowB.Count)
+	assert.Equal(t, 10, comparison.Delta.Count)
+
+	require.Len(t, comparison.Routes, 3)
+	users := comparison.Routes[0]
+	assert.Equal(t, "api.example.com/users/{id}", users.Route)
+	assert.Equal(t, 10, users.A.Count)
+	assert.Equal(t, 20, users.B.Count)
+	require.NotNil(t, users.Delta.CountChange)
+	assert.InDelta(t, 1.0, *users.Delta.CountChange, 0.0001)
+	assert.InDelta(t, 0.25, users.Delta.ErrorRate, 0.0001)
+	require.NotNil(t, users.Delta.P95DurationUs)
+	assert.Equal(t, int64(2000), *users.Delta.P95DurationUs)
+
+	// Routes only present in one window have no latency delta
+	for _, route := range comparison.Routes[1:] {
+		assert.Nil(t, route.Delta.P95DurationUs, route.Route)
+	}
+	v2 := comparison.Routes[2]
+	assert.Equal(t, "api.example.com/v2/users", v2.Route)
+	assert.Nil(t, v2.Delta.CountChange)
+}
+
+func TestParseTimeWindow(t *testing.T) {
+	now := time.Date(2024, 1, 15, 16, 0, 0, 0, time.UTC)
+
+	start, end, err := ParseTimeWindow("2h/1h", now)
+	require.NoError(t, err)
+	assert.Equal(t, now.Add(-2*time.Hour), start)
+	assert.Equal(t, now.Add(-time.Hour), end)
+
+	start, end, err = ParseTimeWindow("2024-01-15T15:00:00Z/now", now)
+	require.NoError(t, err)
+	assert.Equal(t, time.Date(2024, 1, 15, 15, 0, 0, 0, time.UTC), start)
+	assert.Equal(t, now, end)
+
+	for _, value := range []string{"2h", "1h/2h", "yesterday/now", "-1h/now"} {
+		_, _, err := ParseTimeWindow(value, now)
+		assert.Error(t, err, value)
+	}
+}
+
+func TestHandleStatsCompare(t *testing.T) {
+	p := New(&Config{})
+	p.history.AddRecord(RequestRecord{ID: "a", Timestamp: time.Now().Add(-90 * time.Minute), Method: http.MethodGet, URL: "http://example.com/", Success: true, ResponseStatus: 200})
+	p.history.AddRecord(RequestRecord{ID: "b", Timestamp: time.Now().Add(-time.Minute), Method: http.MethodGet, URL: "http://example.com/", Success: true, ResponseStatus: 200})
+
+	rec := httptest.NewRecorder()
+	p.handleStatsCompare(rec, httptest.NewRequest(http.MethodGet, "/requests/stats/compare?windowA=2h/1h&windowB=1h/now", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	var comparison StatsComparison
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &comparison))
+	assert.Equal(t, 1, comparison.WindowA.Count)
+	assert.Equal(t, 1, comparison.WindowB.Count)
+
+	rec = httptest.NewRecorder()
+	p.handleStatsCompare(rec, httptest.NewRequest(http.MethodGet, "/requests/stats/compare?windowA=2h/1h", nil))
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	rec = httptest.NewRecorder()
+	p.handleStatsCompare(rec, httptest.NewRequest(http.MethodGet, "/requests/stats/compare?windowA=2h/1h&windowB=bad", nil))
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+}
@@ -0,0 +1,209 @@
+package proxy
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Adaptive concurrency algorithms
+const (
+	ConcurrencyAIMD     = "aimd"     // Add one on success, cut by a tenth on a drop
+	ConcurrencyGradient = "gradient" // Follow the ratio of long-term to recent latency
+)
+
+// Concurrency limit defaults
+const (
+	defaultConcurrencyInitial = 20
+	defaultConcurrencyMin     = 1
+	defaultConcurrencyMax     = 1000
+
+	aimdBackoff       = 0.9
+	gradientSmoothing = 0.2
+	gradientLongTerm  = 0.01 // EWMA weight of each sample in the long-term latency
+	gradientShortTerm = 0.1  // EWMA weight of each sample in the recent latency
+)
+
+// ConcurrencyLimit configures adaptive per-upstream concurrency limits, in
+// the style of Netflix's concurrency-limits: requests over an upstream's
+// current limit are rejected instead of queueing behind a struggling upstream
+type ConcurrencyLimit struct {
+	Algorithm string // ConcurrencyAIMD or ConcurrencyGradient
+	Initial   int    // Limit each upstream starts at (default: 20)
+	Min       int    // Lowest limit (default: 1)
+	Max       int    // Highest limit (default: 1000)
+}
+
+// ValidateConcurrencyLimit checks the algorithm and bounds
+func ValidateConcurrencyLimit(config ConcurrencyLimit) error {
+	if config.Algorithm != ConcurrencyAIMD && config.Algorithm != ConcurrencyGradient {
+		return fmt.Errorf("algorithm must be %s or %s, got %q", ConcurrencyAIMD, ConcurrencyGradient, config.Algorithm)
+	}
+	if config.Initial < 0 || config.Min < 0 || config.Max < 0 {
+		return fmt.Errorf("limits cannot be negative")
+	}
+	if config.Max > 0 && config.Min > config.Max {
+		return fmt.Errorf("minimum limit %d is above the maximum %d", config.Min, config.Max)
+	}
+	return nil
+}
+
+// UpstreamConcurrency is the current state of one upstream's limiter
+type UpstreamConcurrency struct {
+	Upstream string `json:"upstream"`
+	Limit    int    `json:"limit"`
+	Inflight int    `json:"inflight"`
+	Rejected int64  `json:"rejected"`
+}
+
+// upstreamLimiter adapts the concurrency limit of a single upstream host
+type upstreamLimiter struct {
+	limit    float64
+	inflight int
+	rejected int64
+
+	// Gradient state
+	longRTT  float64 // Microseconds
+	shortRTT float64
+}
+
+// concurrencyLimiter keeps a limiter per upstream host
+type concurrencyLimiter struct {
+	mutex     sync.Mutex
+	config    ConcurrencyLimit
+	upstreams map[string]*upstreamLimiter
+}
+
+func newConcurrencyLimiter(config ConcurrencyLimit) *concurrencyLimiter {
+	if config.Initial <= 0 {
+		config.Initial = defaultConcurrencyInitial
+	}
+	if config.Min <= 0 {
+		config.Min = defaultConcurrencyMin
+	}
+	if config.Max <= 0 {
+		config.Max = defaultConcurrencyMax
+	}
+	config.Initial = min(max(config.Initial, config.Min), config.Max)
+	return &concurrencyLimiter{config: config, upstreams: make(map[string]*upstreamLimiter)}
+}
+
+// acquire reserves a slot for a request to the upstream, returning false
+// when the upstream is at its limit. Each reserved slot must be released.
+func (c *concurrencyLimiter) acquire(upstream string) bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	limiter := c.upstreams[upstream]
+	if limiter == nil {
+		limiter = &upstreamLimiter{limit: float64(c.config.Initial)}
+		c.upstreams[upstream] = limiter
+	}
+	if limiter.inflight >= int(limiter.limit) {
+		limiter.rejected++
+		return false
+	}
+	limiter.inflight++
+	return true
+}
+
+// release frees a slot and adapts the limit to how the request went. Dropped
+// requests (transport errors and overload responses) shrink the limit.
+func (c *concurrencyLimiter) release(upstream string, rtt time.Duration, dropped bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	limiter := c.upstreams[upstream]
+	if limiter == nil {
+		return
+	}
+	inflight := limiter.inflight
+	limiter.inflight--
+
+	switch c.config.Algorithm {
+	case ConcurrencyGradient:
+		limiter.gradientSample(float64(rtt.Microseconds()), dropped)
+	default:
+		limiter.aimdSample(inflight, dropped)
+	}
+	limiter.limit = math.Min(math.Max(limiter.limit, float64(c.config.Min)), float64(c.config.Max))
+}
+
+// aimdSample grows the limit by one while it is being used and backs off on drops
+func (l *upstreamLimiter) aimdSample(inflight int, dropped bool) {
+	if dropped {
+		l.limit *= aimdBackoff
+		return
+	}
+	// Only grow when the limit is actually being approached
+	if float64(inflight)*2 >= l.limit {
+		l.limit++
+	}
+}
+
+// gradientSample moves the limit by the ratio of long-term to recent latency,
+// shrinking it when latency rises, with headroom to probe for more capacity
+func (l *upstreamLimiter) gradientSample(rtt float64, dropped bool) {
+	if rtt <= 0 {
+		rtt = 1
+	}
+	if l.longRTT == 0 {
+		l.longRTT, l.shortRTT = rtt, rtt
+	}
+	l.shortRTT += gradientShortTerm * (rtt - l.shortRTT)
+	l.longRTT += gradientLongTerm * (rtt - l.longRTT)
+
+	gradient := math.Max(0.5, math.Min(1, l.longRTT/l.shortRTT))
+	if dropped {
+		gradient = 0.5
+	}
+	target := l.limit*gradient + math.Sqrt(l.limit)
+	l.limit = l.limit*(1-gradientSmoothing) + target*gradientSmoothing
+}
+
+// snapshot returns the state of every upstream, by name
+func (c *concurrencyLimiter) snapshot() []UpstreamConcurrency {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	upstreams := make([]UpstreamConcurrency, 0, len(c.upstreams))
+	for name, limiter := range c.upstreams {
+		upstreams = append(upstreams, UpstreamConcurrency{
+			Upstream: name,
+			Limit:    int(limiter.limit),
+			Inflight: limiter.inflight,
+			Rejected: limiter.rejected,
+		})
+	}
+	sort.Slice(upstreams, func(i, j int) bool { return upstreams[i].Upstream < upstreams[j].Upstream })
+	return upstreams
+}
+
+// isDroppedResponse reports whether an upstream response signals overload
+func isDroppedResponse(resp *http.Response, err error) bool {
+	return err != nil || resp.StatusCode == http.StatusServiceUnavailable || resp.StatusCode == http.StatusTooManyRequests
+}
+
+// writeConcurrencyMetrics appends the limit and inflight gauges of every upstream
+func (c *concurrencyLimiter) writeConcurrencyMetrics(b *strings.Builder) {
+	upstreams := c.snapshot()
+	b.WriteString("\n# HELP netkit_upstream_concurrency_limit Current adaptive concurrency limit per upstream\n")
+	b.WriteString("# TYPE netkit_upstream_concurrency_limit gauge\n")
+	for _, upstream := range upstreams {
+		fmt.Fprintf(b, "netkit_upstream_concurrency_limit{upstream=%q} %d\n", upstream.Upstream, upstream.Limit)
+	}
+	b.WriteString("\n# HELP netkit_upstream_inflight Requests in flight per upstream\n")
+	b.WriteString("# TYPE netkit_upstream_inflight gauge\n")
+	for _, upstream := range upstreams {
+		fmt.Fprintf(b, "netkit_upstream_inflight{upstream=%q} %d\n", upstream.Upstream, upstream.Inflight)
+	}
+	b.WriteString("\n# HELP netkit_upstream_concurrency_rejected_total Requests rejected at the concurrency limit per upstream\n")
+	b.WriteString("# TYPE netkit_upstream_concurrency_rejected_total counter\n")
+	for _, upstream := range upstreams {
+		fmt.Fprintf(b, "netkit_upstream_concurrency_rejected_total{upstream=%q} %d\n", upstream.Upstream, upstream.Rejected)
+	}
+}
@@ -0,0 +1,100 @@
+//go:build unit
+
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAIMDConcurrencyLimit(t *testing.T) {
+	limiter := newConcurrencyLimiter(ConcurrencyLimit{Algorithm: ConcurrencyAIMD, Initial: 2, Max: 4})
+
+	require.True(t, limiter.acquire("api"))
+	require.True(t, limiter.acquire("api"))
+	assert.False(t, limiter.acquire("api"), "requests over the limit are rejected")
+	assert.True(t, limiter.acquire("other"), "each upstream has its own limit")
+
+	// Successes at the limit grow it, up to the maximum
+	for i := 0; i < 5; i++ {
+		limiter.release("api", time.Millisecond, false)
+		require.True(t, limiter.acquire("api"))
+	}
+	state := limiter.snapshot()
+	require.Len(t, state, 2)
+	assert.Equal(t, UpstreamConcurrency{Upstream: "api", Limit: 4, Inflight: 2, Rejected: 1}, state[0])
+
+	// Drops back off multiplicatively, but not below the minimum
+	for i := 0; i < 20; i++ {
+		limiter.release("api", time.Millisecond, true)
+		limiter.acquire("api")
+	}
+	assert.Equal(t, 1, limiter.snapshot()[0].Limit)
+}
+
+func TestGradientConcurrencyLimit(t *testing.T) {
+	limiter := newConcurrencyLimiter(ConcurrencyLimit{Algorithm: ConcurrencyGradient, Initial: 50})
+
+	// Steady latency leaves room to grow
+	for i := 0; i < 50; i++ {
+		limiter.acquire("api")
+		limiter.release("api", 10*time.Millisecond, false)
+	}
+	steady := limiter.snapshot()[0].Limit
+	assert.Greater(t, steady, 50)
+
+	// A latency spike shrinks the limit
+	for i := 0; i < 50; i++ {
+		limiter.acquire("api")
+		limiter.release("api", 200*time.Millisecond, false)
+	}
+	assert.Less(t, limiter.snapshot()[0].Limit, steady)
+}
+
+func TestValidateConcurrencyLimit(t *testing.T) {
+	assert.NoError(t, ValidateConcurrencyLimit(ConcurrencyLimit{Algorithm: ConcurrencyAIMD}))
+	assert.Error(t, ValidateConcurrencyLimit(ConcurrencyLimit{Algorithm: "vegas"}))
+	assert.Error(t, ValidateConcurrencyLimit(ConcurrencyLimit{Algorithm: ConcurrencyGradient, Min: 10, Max: 5}))
+	assert.Error(t, ValidateConcurrencyLimit(ConcurrencyLimit{Algorithm: ConcurrencyGradient, Initial: -1}))
+}
+
+func TestProxyConcurrencyLimit(t *testing.T) {
+	release := make(chan struct{})
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer upstream.Close()
+
+	p := New(&Config{ConcurrencyLimit: &ConcurrencyLimit{Algorithm: ConcurrencyAIMD, Initial: 1, Max: 1}})
+
+	// Hold the only slot with a request that waits on the upstream
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		p.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, upstream.URL+"/slow", nil))
+	}()
+	require.Eventually(t, func() bool { return p.concurrency.snapshot()[0].Inflight == 1 }, time.Second, time.Millisecond)
+
+	rec := httptest.NewRecorder()
+	p.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, upstream.URL+"/rejected", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+	assert.Equal(t, "Upstream concurrency limit reached", p.history.GetRecords()[0].Error)
+
+	close(release)
+	wg.Wait()
+
+	rec = httptest.NewRecorder()
+	p.handleMetrics(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	host := strings.TrimPrefix(upstream.URL, "http://")
+	assert.Contains(t, rec.Body.String(), `netkit_upstream_concurrency_limit{upstream="`+host+`"} 1`)
+	assert.Contains(t, rec.Body.String(), `netkit_upstream_inflight{upstream="`+host+`"} 0`)
+	assert.Contains(t, rec.Body.String(), `netkit_upstream_concurrency_rejected_total{upstream="`+host+`"} 1`)
+}
@@ -0,0 +1,196 @@
+package proxy
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Authorization scheme labels for values that do not start with a scheme
+const (
+	authSchemeNone    = "(none)"    // A bare credential without a scheme
+	authSchemeInvalid = "(invalid)" // Something that does not look like a scheme name
+)
+
+// authSchemePattern matches the scheme names of RFC 9110 auth-schemes
+var authSchemePattern = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9!#$%&'*+.^_|~-]{0,31}$`)
+
+// credentialNameParts mark header and query parameter names that carry secrets
+var credentialNameParts = []string{"apikey", "api-key", "api_key", "token", "secret", "password", "signature", "credential", "session"}
+
+// credentialNames are short names that carry secrets but are too generic to
+// match by part
+var credentialNames = map[string]bool{"key": true, "sig": true, "auth": true, "x-api-key": true, "private-token": true}
+
+// CredentialHostStats counts the credentials sent to one upstream host. Only
+// header, cookie, and parameter names and Authorization schemes are reported,
+// never their values.
+type CredentialHostStats struct {
+	Host            string         `json:"host"`
+	Requests        int            `json:"requests"`
+	WithCredentials int            `json:"with_credentials"`
+	Insecure        int            `json:"insecure"`                  // Credentials sent over plain HTTP
+	AuthSchemes     map[string]int `json:"auth_schemes,omitempty"`    // Authorization header schemes, e.g. Bearer or Basic
+	APIKeyHeaders   map[string]int `json:"api_key_headers,omitempty"` // Other headers named like API keys and tokens
+	Cookies         map[string]int `json:"cookies,omitempty"`         // Cookie names
+	QueryParams     map[string]int `json:"query_params,omitempty"`    // URL parameters named like API keys and tokens
+}
+
+// CredentialReport lists the upstream hosts credentials were sent to over a window
+type CredentialReport struct {
+	Start time.Time             `json:"start"`
+	End   time.Time             `json:"end"`
+	Hosts []CredentialHostStats `json:"hosts"` // Most requests with credentials first
+}
+
+// isCredentialName reports whether a header or query parameter name looks
+// like it carries a secret
+func isCredentialName(name string) bool {
+	name = strings.ToLower(name)
+	if credentialNames[name] {
+		return true
+	}
+	for _, part := range credentialNameParts {
+		if strings.Contains(name, part) {
+			return true
+		}
+	}
+	return false
+}
+
+// authScheme returns the scheme of an Authorization header value without any
+// of the credentials that follow it
+func authScheme(value string) string {
+	fields := strings.Fields(value)
+	if len(fields) < 2 {
+		return authSchemeNone
+	}
+	if !authSchemePattern.MatchString(fields[0]) {
+		return authSchemeInvalid
+	}
+	// Schemes are case-insensitive, so "bearer" and "Bearer" are counted together
+	scheme := strings.ToLower(fields[0])
+	for _, known := range []string{"Basic", "Bearer", "Digest", "Negotiate", "NTLM", "AWS4-HMAC-SHA256", "HOBA", "Mutual", "Token"} {
+		if strings.EqualFold(scheme, known) {
+			return known
+		}
+	}
+	return scheme
+}
+
+// BuildCredentialReport counts the credentials carried by the records in
+// [start, end) per upstream host. Hosts no credentials were sent to are left
+// out.
+func BuildCredentialReport(records []RequestRecord, start, end time.Time) CredentialReport {
+	hosts := make(map[string]*CredentialHostStats)
+
+	for _, record := range records {
+		if record.Timestamp.Before(start) || !record.Timestamp.Before(end) {
+			continue
+		}
+		host := upstreamHost(record.URL)
+		stats := hosts[host]
+		if stats == nil {
+			stats = &CredentialHostStats{
+				Host:          host,
+				AuthSchemes:   make(map[string]int),
+				APIKeyHeaders: make(map[string]int),
+				Cookies:       make(map[string]int),
+				QueryParams:   make(map[string]int),
+			}
+			hosts[host] = stats
+		}
+		stats.Requests++
+
+		found := false
+		for name, value := range record.RequestHeaders {
+			switch {
+			case strings.EqualFold(name, "Authorization"):
+				stats.AuthSchemes[authScheme(value)]++
+				found = true
+			case strings.EqualFold(name, "Cookie"):
+				cookies := (&http.Request{Header: http.Header{"Cookie": {value}}}).Cookies()
+				for _, cookie := range cookies {
+					stats.Cookies[cookie.Name]++
+				}
+				found = found || len(cookies) > 0
+			case isCredentialName(name):
+				stats.APIKeyHeaders[http.CanonicalHeaderKey(name)]++
+				found = true
+			}
+		}
+		u, err := url.Parse(record.URL)
+		if err == nil {
+			for name := range u.Query() {
+				if isCredentialName(name) {
+					stats.QueryParams[name]++
+					found = true
+				}
+			}
+		}
+
+		if found {
+			stats.WithCredentials++
+			if err == nil && u.Scheme == "http" {
+				stats.Insecure++
+			}
+		}
+	}
+
+	report := CredentialReport{Start: start, End: end, Hosts: make([]CredentialHostStats, 0, len(hosts))}
+	for _, stats := range hosts {
+		if stats.WithCredentials > 0 {
+			report.Hosts = append(report.Hosts, *stats)
+		}
+	}
+	sort.Slice(report.Hosts, func(i, j int) bool {
+		hi, hj := report.Hosts[i], report.Hosts[j]
+		if hi.WithCredentials != hj.WithCredentials {
+			return hi.WithCredentials > hj.WithCredentials
+		}
+		return hi.Host < hj.Host
+	})
+	return report
+}
+
+// handleCredentialReport reports which upstream hosts were sent credentials,
+// and which kinds, so teams can audit where secrets go through the proxy
+func (p *Proxy) handleCredentialReport(w http.ResponseWriter, r *http.Request) {
+	// Add CORS headers
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, Cache-Control, Pragma, Expires")
+
+	// Handle preflight requests
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	start, end, err := parseReportWindow(r.URL.Query().Get("window"), 24*time.Hour, time.Now())
+	if err != nil {
+		http.Error(w, "Invalid window: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	data, err := json.Marshal(BuildCredentialReport(p.history.GetRecords(), start, end))
+	if err != nil {
+		http.Error(w, "Failed to build credential report", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if _, err := w.Write(data); err != nil {
+		slog.Warn("Error writing credential report response", "error", err)
+	}
+}
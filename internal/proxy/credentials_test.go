@@ -0,0 +1,79 @@
+//go:build unit
+
+package proxy
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func credentialRecords(now time.Time) []RequestRecord {
+	return []RequestRecord{
+		{Timestamp: now.Add(-time.Minute), URL: "https://api.example.com/users", RequestHeaders: map[string]string{"Authorization": "Bearer eyJhbGciOi.secret"}},
+		{Timestamp: now.Add(-time.Minute), URL: "https://api.example.com/orders", RequestHeaders: map[string]string{"Authorization": "bearer abc123", "X-Api-Key": "k-123"}},
+		{Timestamp: now.Add(-time.Minute), URL: "https://api.example.com/health", RequestHeaders: map[string]string{"Accept": "*/*"}},
+		{Timestamp: now.Add(-time.Minute), URL: "http://legacy:8080/login?user=bob&access_token=t0k3n", RequestHeaders: map[string]string{"Cookie": "session=s3cr3t; theme=dark"}},
+		{Timestamp: now.Add(-time.Minute), URL: "http://cdn/logo.png", RequestHeaders: map[string]string{}},
+		{Timestamp: now.Add(-2 * time.Hour), URL: "https://old.example.com/", RequestHeaders: map[string]string{"Authorization": "Basic dXNlcjpwYXNz"}},
+	}
+}
+
+func TestBuildCredentialReport(t *testing.T) {
+	now := time.Now()
+	report := BuildCredentialReport(credentialRecords(now), now.Add(-time.Hour), now)
+	require.Len(t, report.Hosts, 2, "hosts without credentials and records outside the window are skipped")
+
+	api := report.Hosts[0]
+	assert.Equal(t, "api.example.com", api.Host)
+	assert.Equal(t, 3, api.Requests)
+	assert.Equal(t, 2, api.WithCredentials)
+	assert.Zero(t, api.Insecure)
+	assert.Equal(t, map[string]int{"Bearer": 2}, api.AuthSchemes)
+	assert.Equal(t, map[string]int{"X-Api-Key": 1}, api.APIKeyHeaders)
+
+	legacy := report.Hosts[1]
+	assert.Equal(t, "legacy:8080", legacy.Host)
+	assert.Equal(t, 1, legacy.Insecure)
+	assert.Equal(t, map[string]int{"session": 1, "theme": 1}, legacy.Cookies)
+	assert.Equal(t, map[string]int{"access_token": 1}, legacy.QueryParams)
+
+	data, err := json.Marshal(report)
+	require.NoError(t, err)
+	for _, secret := range []string{"eyJhbGciOi", "abc123", "k-123", "s3cr3t", "t0k3n", "bob"} {
+		assert.NotContains(t, string(data), secret)
+	}
+}
+
+func TestAuthScheme(t *testing.T) {
+	assert.Equal(t, "Basic", authScheme("basic dXNlcjpwYXNz"))
+	assert.Equal(t, "AWS4-HMAC-SHA256", authScheme("AWS4-HMAC-SHA256 Credential=AKIA/20240101, Signature=abc"))
+	assert.Equal(t, "custom", authScheme("Custom value"))
+	assert.Equal(t, authSchemeNone, authScheme("sk-live-1234567890"), "a bare key is not reported as a scheme")
+	assert.Equal(t, authSchemeInvalid, authScheme("sk/live=1234 more"))
+}
+
+func TestCredentialReportAPI(t *testing.T) {
+	p := New(&Config{})
+	p.history.restore(credentialRecords(time.Now()))
+
+	rec := httptest.NewRecorder()
+	p.handleCredentialReport(rec, httptest.NewRequest(http.MethodGet, "/requests/credentials?window=3h", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	var report CredentialReport
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &report))
+	assert.Len(t, report.Hosts, 3)
+
+	rec = httptest.NewRecorder()
+	p.handleCredentialReport(rec, httptest.NewRequest(http.MethodGet, "/requests/credentials?window=bad", nil))
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	rec = httptest.NewRecorder()
+	p.handleCredentialReport(rec, httptest.NewRequest(http.MethodPost, "/requests/credentials", nil))
+	assert.Equal(t, http.StatusMethodNotAllowed, rec.Code)
+}
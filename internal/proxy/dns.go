@@ -0,0 +1,271 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// DNS resolver protocols
+const (
+	DNSOverHTTPS = "https" // RFC 8484, DNS messages POSTed to a URL
+	DNSOverTLS   = "tls"   // RFC 7858, DNS messages over a TLS connection
+)
+
+// defaultDoTPort is the port of DNS-over-TLS servers
+const defaultDoTPort = "853"
+
+// dnsExchangeTimeout bounds one exchange with a resolver when the lookup has
+// no deadline of its own
+const dnsExchangeTimeout = 5 * time.Second
+
+// dnsMessageType is the media type of DNS-over-HTTPS messages
+const dnsMessageType = "application/dns-message"
+
+// DNSResolver is a DNS-over-HTTPS or DNS-over-TLS server upstream names are
+// resolved with
+type DNSResolver struct {
+	Protocol string // https or tls
+	Address  string // URL of a DoH server, or host:port of a DoT server
+}
+
+// ParseDNSResolver parses a resolver: an https:// URL for DNS-over-HTTPS,
+// e.g. https://cloudflare-dns.com/dns-query, or tls://host[:port] for
+// DNS-over-TLS, e.g. tls://dns.google (port 853 by default)
+func ParseDNSResolver(value string) (DNSResolver, error) {
+	parsed, err := url.Parse(value)
+	if err != nil {
+		return DNSResolver{}, fmt.Errorf("invalid DNS resolver %q: %v", value, err)
+	}
+	if parsed.Host == "" {
+		return DNSResolver{}, fmt.Errorf("invalid DNS resolver %q: missing host", value)
+	}
+	switch parsed.Scheme {
+	case DNSOverHTTPS:
+		return DNSResolver{Protocol: DNSOverHTTPS, Address: value}, nil
+	case DNSOverTLS:
+		if parsed.Path != "" && parsed.Path != "/" {
+			return DNSResolver{}, fmt.Errorf("invalid DNS resolver %q: DNS-over-TLS takes no path", value)
+		}
+		address := parsed.Host
+		if parsed.Port() == "" {
+			address = net.JoinHostPort(parsed.Hostname(), defaultDoTPort)
+		}
+		return DNSResolver{Protocol: DNSOverTLS, Address: address}, nil
+	default:
+		return DNSResolver{}, fmt.Errorf("invalid DNS resolver %q: expected an https:// URL (DNS-over-HTTPS) or tls://host[:port] (DNS-over-TLS)", value)
+	}
+}
+
+// String returns the resolver in the form accepted by ParseDNSResolver
+func (dr DNSResolver) String() string {
+	if dr.Protocol == DNSOverTLS {
+		return DNSOverTLS + "://" + dr.Address
+	}
+	return dr.Address
+}
+
+// dnsResolver sends the lookups of Go's resolver to DoH and DoT servers in
+// order, then to the system's name server when fallback is on. The servers
+// themselves are reached through the system resolver.
+type dnsResolver struct {
+	servers   []DNSResolver
+	fallback  bool
+	client    *http.Client // For DoH
+	tlsConfig *tls.Config  // For DoT; the server name is set per server
+	dialer    *net.Dialer
+}
+
+// newDNSResolver returns a resolver for upstream names that uses servers
+func newDNSResolver(servers []DNSResolver, fallback bool) *net.Resolver {
+	return (&dnsResolver{
+		servers:  servers,
+		fallback: fallback,
+		client:   &http.Client{Transport: &http.Transport{ForceAttemptHTTP2: true}},
+		dialer:   &net.Dialer{Timeout: dnsExchangeTimeout},
+	}).resolver()
+}
+
+// resolver returns a Go resolver whose queries go through r
+func (r *dnsResolver) resolver() *net.Resolver {
+	return &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+			return &dnsConn{ctx: ctx, resolver: r, system: address}, nil
+		},
+	}
+}
+
+// exchange sends a DNS message to each server in turn and returns the first
+// answer. system is the name server Go's resolver would have asked.
+func (r *dnsResolver) exchange(ctx context.Context, query []byte, system string) ([]byte, error) {
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, dnsExchangeTimeout)
+		defer cancel()
+	}
+
+	var lastErr error
+	for _, server := range r.servers {
+		var answer []byte
+		var err error
+		if server.Protocol == DNSOverTLS {
+			answer, err = r.exchangeTLS(ctx, server.Address, query)
+		} else {
+			answer, err = r.exchangeHTTPS(ctx, server.Address, query)
+		}
+		if err == nil {
+			return answer, nil
+		}
+		slog.Warn("Error resolving", "server", server, "error", err)
+		lastErr = err
+		if ctx.Err() != nil {
+			return nil, lastErr
+		}
+	}
+	if r.fallback && system != "" {
+		conn, err := r.dialer.DialContext(ctx, "tcp", system)
+		if err != nil {
+			return nil, err
+		}
+		return exchangeStream(ctx, conn, query)
+	}
+	return nil, lastErr
+}
+
+// exchangeHTTPS POSTs a DNS message to a DoH server
+func (r *dnsResolver) exchangeHTTPS(ctx context.Context, server string, query []byte) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, server, bytes.NewReader(query))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", dnsMessageType)
+	req.Header.Set("Accept", dnsMessageType)
+
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if closeErr := resp.Body.Close(); closeErr != nil {
+			slog.Warn("Error closing DNS-over-HTTPS response body", "error", closeErr)
+		}
+	}()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("DNS-over-HTTPS server returned %s", resp.Status)
+	}
+	if mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type")); mediaType != dnsMessageType {
+		return nil, fmt.Errorf("DNS-over-HTTPS server returned %q instead of %s", mediaType, dnsMessageType)
+	}
+	return io.ReadAll(io.LimitReader(resp.Body, 1<<16))
+}
+
+// exchangeTLS sends a DNS message to a DoT server over a new connection
+func (r *dnsResolver) exchangeTLS(ctx context.Context, server string, query []byte) ([]byte, error) {
+	host, _, err := net.SplitHostPort(server)
+	if err != nil {
+		return nil, err
+	}
+	config := &tls.Config{}
+	if r.tlsConfig != nil {
+		config = r.tlsConfig.Clone()
+	}
+	config.ServerName = host
+	conn, err := (&tls.Dialer{NetDialer: r.dialer, Config: config}).DialContext(ctx, "tcp", server)
+	if err != nil {
+		return nil, err
+	}
+	return exchangeStream(ctx, conn, query)
+}
+
+// exchangeStream sends a length-prefixed DNS message over a connection and
+// reads the answer, closing the connection
+func exchangeStream(ctx context.Context, conn net.Conn, query []byte) ([]byte, error) {
+	defer func() {
+		if closeErr := conn.Close(); closeErr != nil {
+			slog.Warn("Error closing DNS connection", "error", closeErr)
+		}
+	}()
+	if deadline, ok := ctx.Deadline(); ok {
+		if err := conn.SetDeadline(deadline); err != nil {
+			return nil, err
+		}
+	}
+
+	message := binary.BigEndian.AppendUint16(nil, uint16(len(query)))
+	if _, err := conn.Write(append(message, query...)); err != nil {
+		return nil, err
+	}
+	var length [2]byte
+	if _, err := io.ReadFull(conn, length[:]); err != nil {
+		return nil, err
+	}
+	answer := make([]byte, binary.BigEndian.Uint16(length[:]))
+	if _, err := io.ReadFull(conn, answer); err != nil {
+		return nil, err
+	}
+	return answer, nil
+}
+
+// dnsConn is the connection Go's resolver writes its queries to. It frames
+// them like DNS over TCP, and each query is answered by the resolver's
+// servers as soon as it has been written.
+type dnsConn struct {
+	ctx      context.Context
+	resolver *dnsResolver
+	system   string
+	query    bytes.Buffer
+	answers  bytes.Buffer
+	err      error
+}
+
+func (c *dnsConn) Write(b []byte) (int, error) {
+	c.query.Write(b)
+	for c.query.Len() >= 2 {
+		length := int(binary.BigEndian.Uint16(c.query.Bytes()))
+		if c.query.Len() < 2+length {
+			break
+		}
+		query := c.query.Next(2 + length)[2:]
+		answer, err := c.resolver.exchange(c.ctx, query, c.system)
+		if err != nil {
+			c.err = err
+			continue
+		}
+		c.answers.Write(binary.BigEndian.AppendUint16(nil, uint16(len(answer))))
+		c.answers.Write(answer)
+	}
+	return len(b), nil
+}
+
+func (c *dnsConn) Read(b []byte) (int, error) {
+	if c.answers.Len() == 0 {
+		if c.err != nil {
+			return 0, c.err
+		}
+		return 0, io.EOF
+	}
+	return c.answers.Read(b)
+}
+
+func (c *dnsConn) Close() error                     { return nil }
+func (c *dnsConn) LocalAddr() net.Addr              { return dnsAddr{} }
+func (c *dnsConn) RemoteAddr() net.Addr             { return dnsAddr{} }
+func (c *dnsConn) SetDeadline(time.Time) error      { return nil }
+func (c *dnsConn) SetReadDeadline(time.Time) error  { return nil }
+func (c *dnsConn) SetWriteDeadline(time.Time) error { return nil }
+
+// dnsAddr is the address of a dnsConn
+type dnsAddr struct{}
+
+func (dnsAddr) Network() string { return "dns" }
+func (dnsAddr) String() string  { return "netkit-resolver" }
@@ -0,0 +1,179 @@
+//go:build unit
+
+package proxy
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// dnsTestAnswer answers an A query for any name with 127.0.0.1, and other
+// queries with no records
+func dnsTestAnswer(query []byte) []byte {
+	// The question ends after the name's labels, its type, and its class
+	end := 12
+	for query[end] != 0 {
+		end += int(query[end]) + 1
+	}
+	end += 5
+	answer := append([]byte(nil), query[:end]...)
+	answer[2] |= 0x80 // Response
+	answer[3] = 0x80  // Recursion available, no error
+	binary.BigEndian.PutUint16(answer[6:], 0)
+	binary.BigEndian.PutUint16(answer[8:], 0)
+	binary.BigEndian.PutUint16(answer[10:], 0)
+	if binary.BigEndian.Uint16(query[end-4:]) == 1 {
+		binary.BigEndian.PutUint16(answer[6:], 1)
+		answer = append(answer, 0xc0, 0x0c, 0, 1, 0, 1, 0, 0, 0, 60, 0, 4, 127, 0, 0, 1)
+	}
+	return answer
+}
+
+// serveTestDNSStream answers length-prefixed DNS queries on a listener
+func serveTestDNSStream(ln net.Listener) {
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			defer conn.Close()
+			var length [2]byte
+			if _, err := io.ReadFull(conn, length[:]); err != nil {
+				return
+			}
+			query := make([]byte, binary.BigEndian.Uint16(length[:]))
+			if _, err := io.ReadFull(conn, query); err != nil {
+				return
+			}
+			answer := dnsTestAnswer(query)
+			_, _ = conn.Write(append(binary.BigEndian.AppendUint16(nil, uint16(len(answer))), answer...))
+		}()
+	}
+}
+
+func newTestDoHServer(t *testing.T) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, http.MethodPost, r.Method)
+		assert.Equal(t, dnsMessageType, r.Header.Get("Content-Type"))
+		query, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+		w.Header().Set("Content-Type", dnsMessageType)
+		_, _ = w.Write(dnsTestAnswer(query))
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestParseDNSResolver(t *testing.T) {
+	resolver, err := ParseDNSResolver("https://cloudflare-dns.com/dns-query")
+	require.NoError(t, err)
+	assert.Equal(t, DNSResolver{Protocol: DNSOverHTTPS, Address: "https://cloudflare-dns.com/dns-query"}, resolver)
+
+	resolver, err = ParseDNSResolver("tls://dns.google")
+	require.NoError(t, err)
+	assert.Equal(t, DNSResolver{Protocol: DNSOverTLS, Address: "dns.google:853"}, resolver)
+	assert.Equal(t, "tls://dns.google:853", resolver.String())
+
+	resolver, err = ParseDNSResolver("tls://[2606:4700:4700::1111]:8853")
+	require.NoError(t, err)
+	assert.Equal(t, "[2606:4700:4700::1111]:8853", resolver.Address)
+
+	for _, value := range []string{"8.8.8.8", "udp://8.8.8.8", "https:///dns-query", "tls://dns.google/dns-query"} {
+		_, err := ParseDNSResolver(value)
+		assert.Error(t, err, value)
+	}
+}
+
+func TestDNSResolverHTTPS(t *testing.T) {
+	doh := newTestDoHServer(t)
+	resolver := newDNSResolver([]DNSResolver{{Protocol: DNSOverHTTPS, Address: doh.URL + "/dns-query"}}, false)
+
+	addresses, err := resolver.LookupHost(context.Background(), "upstream.netkit.test")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"127.0.0.1"}, addresses)
+}
+
+func TestDNSResolverTLSAfterFailure(t *testing.T) {
+	server := httptest.NewTLSServer(http.NotFoundHandler())
+	defer server.Close()
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: server.TLS.Certificates})
+	require.NoError(t, err)
+	defer ln.Close()
+	go serveTestDNSStream(ln)
+
+	// The first server fails, so queries move on to the DoT server
+	broken := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer broken.Close()
+	roots := x509.NewCertPool()
+	roots.AddCert(server.Certificate())
+	r := &dnsResolver{
+		servers:   []DNSResolver{{Protocol: DNSOverHTTPS, Address: broken.URL}, {Protocol: DNSOverTLS, Address: ln.Addr().String()}},
+		client:    &http.Client{},
+		tlsConfig: &tls.Config{RootCAs: roots},
+		dialer:    &net.Dialer{Timeout: time.Second},
+	}
+
+	addresses, err := r.resolver().LookupHost(context.Background(), "upstream.netkit.test")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"127.0.0.1"}, addresses)
+}
+
+func TestDNSResolverFallback(t *testing.T) {
+	system, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer system.Close()
+	go serveTestDNSStream(system)
+
+	broken := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer broken.Close()
+	r := &dnsResolver{
+		servers: []DNSResolver{{Protocol: DNSOverHTTPS, Address: broken.URL}},
+		client:  &http.Client{},
+		dialer:  &net.Dialer{Timeout: time.Second},
+	}
+	// A query for example.com
+	query := []byte{0x12, 0x34, 1, 0, 0, 1, 0, 0, 0, 0, 0, 0, 7, 'e', 'x', 'a', 'm', 'p', 'l', 'e', 3, 'c', 'o', 'm', 0, 0, 1, 0, 1}
+
+	_, err = r.exchange(context.Background(), query, system.Addr().String())
+	assert.Error(t, err, "without fallback every server failing fails the query")
+
+	r.fallback = true
+	answer, err := r.exchange(context.Background(), query, system.Addr().String())
+	require.NoError(t, err)
+	assert.Equal(t, []byte{0x12, 0x34}, answer[:2])
+	assert.Equal(t, []byte{127, 0, 0, 1}, answer[len(answer)-4:])
+}
+
+func TestProxyDNSResolvers(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(r.Host))
+	}))
+	defer upstream.Close()
+	doh := newTestDoHServer(t)
+	p := New(&Config{DNSResolvers: []DNSResolver{{Protocol: DNSOverHTTPS, Address: doh.URL}}})
+
+	parsed, err := url.Parse(upstream.URL)
+	require.NoError(t, err)
+	target := "http://upstream.netkit.test:" + parsed.Port() + "/"
+	rec := httptest.NewRecorder()
+	p.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, target, nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "upstream.netkit.test:"+parsed.Port(), rec.Body.String())
+}
@@ -0,0 +1,151 @@
+package proxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// TestEndpointsPrefix is the path prefix of the built-in test endpoints
+const TestEndpointsPrefix = "/__netkit/"
+
+// maxTestDelay caps how long /__netkit/delay holds a request
+const maxTestDelay = time.Minute
+
+// echoResponse describes the request /__netkit/echo received
+type echoResponse struct {
+	Method     string              `json:"method"`
+	URL        string              `json:"url"`
+	Host       string              `json:"host"`
+	Path       string              `json:"path"`
+	Query      map[string][]string `json:"query"`
+	Headers    map[string]string   `json:"headers"`
+	Body       string              `json:"body"`
+	RemoteAddr string              `json:"remote_addr"`
+	Delay      string              `json:"delay,omitempty"`
+}
+
+// isTestEndpointRequest reports whether the request was sent directly to the
+// proxy's built-in test endpoints
+func (p *Proxy) isTestEndpointRequest(r *http.Request) bool {
+	return p.currentConfig().TestEndpoints && !r.URL.IsAbs() && strings.HasPrefix(r.URL.Path, TestEndpointsPrefix)
+}
+
+// handleTestEndpoint answers requests to the built-in test endpoints, in the
+// style of httpbin, and stores them in history like proxied requests:
+//
+//	/__netkit/echo              - the request as JSON
+//	/__netkit/status/{code}     - a plain text response with the given status
+//	/__netkit/delay/{duration}  - the echo response after a delay (e.g. 500ms or 2)
+func (p *Proxy) handleTestEndpoint(w http.ResponseWriter, r *http.Request) {
+	proxyStartTime := time.Now()
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "Failed to read request body", http.StatusBadRequest)
+		return
+	}
+
+	record := RequestRecord{
+		ID:             p.newRequestID(),
+		Timestamp:      proxyStartTime,
+		Method:         r.Method,
+		URL:            r.URL.String(),
+		RequestHeaders: convertHeaders(r.Header),
+		RequestBody:    string(body),
+		RequestSize:    int64(len(body)),
+		ProxyStartTime: proxyStartTime,
+	}
+
+	status, contentType, response := p.testEndpointResponse(r, body)
+
+	record.ResponseStatus = status
+	record.ResponseHeaders = map[string]string{"Content-Type": contentType}
+	record.ResponseBody = string(response)
+	record.ResponseSize = int64(len(response))
+	record.Success = status < http.StatusBadRequest
+	if !record.Success {
+		record.Error = http.StatusText(status)
+	}
+	record.ProxyEndTime = time.Now()
+	p.recordRequest(record)
+
+	w.Header().Set("Content-Type", contentType)
+	w.WriteHeader(status)
+	if _, err := w.Write(response); err != nil {
+		slog.Warn("Error writing test endpoint response", "error", err)
+	}
+}
+
+// testEndpointResponse builds the status, content type, and body of a test
+// endpoint response, waiting first for delay requests
+func (p *Proxy) testEndpointResponse(r *http.Request, body []byte) (int, string, []byte) {
+	endpoint, arg, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, TestEndpointsPrefix), "/")
+
+	var delay time.Duration
+	switch endpoint {
+	case "echo":
+	case "status":
+		code, err := strconv.Atoi(arg)
+		if err != nil || code < 200 || code > 599 {
+			return testEndpointError(http.StatusBadRequest, fmt.Sprintf("Invalid status code %q, expected 200-599", arg))
+		}
+		return code, "text/plain; charset=utf-8", []byte(fmt.Sprintf("%d %s\n", code, http.StatusText(code)))
+	case "delay":
+		var err error
+		if delay, err = parseTestDelay(arg); err != nil {
+			return testEndpointError(http.StatusBadRequest, "Invalid delay: "+err.Error())
+		}
+		select {
+		case <-time.After(delay):
+		case <-r.Context().Done():
+			return testEndpointError(http.StatusServiceUnavailable, "Request cancelled during delay")
+		}
+	default:
+		return testEndpointError(http.StatusNotFound, "Unknown test endpoint, expected echo, status/{code}, or delay/{duration}")
+	}
+
+	echo := echoResponse{
+		Method:     r.Method,
+		URL:        r.URL.String(),
+		Host:       r.Host,
+		Path:       r.URL.Path,
+		Query:      r.URL.Query(),
+		Headers:    convertHeaders(r.Header),
+		Body:       string(body),
+		RemoteAddr: r.RemoteAddr,
+	}
+	if delay > 0 {
+		echo.Delay = delay.String()
+	}
+	data, err := json.Marshal(echo)
+	if err != nil {
+		return testEndpointError(http.StatusInternalServerError, "Failed to encode echo response")
+	}
+	return http.StatusOK, "application/json", data
+}
+
+// parseTestDelay parses a delay as a duration (500ms) or a number of seconds (2)
+func parseTestDelay(value string) (time.Duration, error) {
+	delay, err := time.ParseDuration(value)
+	if err != nil {
+		seconds, parseErr := strconv.ParseFloat(value, 64)
+		if parseErr != nil {
+			return 0, fmt.Errorf("expected a duration such as 500ms or a number of seconds, got %q", value)
+		}
+		delay = time.Duration(seconds * float64(time.Second))
+	}
+	if delay < 0 || delay > maxTestDelay {
+		return 0, fmt.Errorf("must be between 0 and %s", maxTestDelay)
+	}
+	return delay, nil
+}
+
+func testEndpointError(status int, message string) (int, string, []byte) {
+	return status, "text/plain; charset=utf-8", []byte(message + "\n")
+}
@@ -0,0 +1,89 @@
+//go:build unit
+
+package proxy
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTestEndpoints(t *testing.T) {
+	p := New(&Config{TestEndpoints: true})
+	send := func(method, target, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		req.Header.Set("X-Trace", "abc")
+		rec := httptest.NewRecorder()
+		p.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := send(http.MethodPost, "/__netkit/echo?a=1&a=2", `{"hello":"world"}`)
+	require.Equal(t, http.StatusOK, rec.Code)
+	var echo echoResponse
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &echo))
+	assert.Equal(t, http.MethodPost, echo.Method)
+	assert.Equal(t, "/__netkit/echo", echo.Path)
+	assert.Equal(t, []string{"1", "2"}, echo.Query["a"])
+	assert.Equal(t, "abc", echo.Headers["X-Trace"])
+	assert.Equal(t, `{"hello":"world"}`, echo.Body)
+
+	record := p.history.GetRecords()[0]
+	assert.Equal(t, "/__netkit/echo?a=1&a=2", record.URL)
+	assert.Equal(t, http.StatusOK, record.ResponseStatus)
+	assert.True(t, record.Success)
+
+	rec = send(http.MethodGet, "/__netkit/status/503", "")
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+	assert.Equal(t, "503 Service Unavailable\n", rec.Body.String())
+	record = p.history.GetRecords()[0]
+	assert.False(t, record.Success)
+	assert.Equal(t, http.StatusServiceUnavailable, record.ResponseStatus)
+
+	start := time.Now()
+	rec = send(http.MethodGet, "/__netkit/delay/50ms", "")
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.GreaterOrEqual(t, time.Since(start), 50*time.Millisecond)
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &echo))
+	assert.Equal(t, "50ms", echo.Delay)
+
+	for target, status := range map[string]int{
+		"/__netkit/status/99":   http.StatusBadRequest,
+		"/__netkit/status/abc":  http.StatusBadRequest,
+		"/__netkit/delay/2h":    http.StatusBadRequest,
+		"/__netkit/delay/later": http.StatusBadRequest,
+		"/__netkit/anything":    http.StatusNotFound,
+	} {
+		assert.Equal(t, status, send(http.MethodGet, target, "").Code, target)
+	}
+}
+
+func TestParseTestDelay(t *testing.T) {
+	delay, err := parseTestDelay("1.5")
+	require.NoError(t, err)
+	assert.Equal(t, 1500*time.Millisecond, delay)
+
+	delay, err = parseTestDelay("250ms")
+	require.NoError(t, err)
+	assert.Equal(t, 250*time.Millisecond, delay)
+
+	_, err = parseTestDelay("-1s")
+	assert.Error(t, err)
+}
+
+func TestTestEndpointsDisabled(t *testing.T) {
+	p := New(&Config{})
+	req := httptest.NewRequest(http.MethodGet, "/__netkit/echo", nil)
+	assert.False(t, p.isTestEndpointRequest(req))
+
+	// Absolute proxy requests are forwarded even when the endpoints are enabled
+	p = New(&Config{TestEndpoints: true})
+	req = httptest.NewRequest(http.MethodGet, "http://api.example.com/__netkit/echo", nil)
+	assert.False(t, p.isTestEndpointRequest(req))
+}
@@ -0,0 +1,227 @@
+package proxy
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxClusterExamples is the number of example record IDs kept per error cluster
+const maxClusterExamples = 5
+
+// maxSignatureLength bounds the error body signature stored on a cluster
+const maxSignatureLength = 120
+
+// ErrorCluster groups failed requests that share an error fingerprint
+type ErrorCluster struct {
+	Fingerprint string    `json:"fingerprint"`
+	Status      int       `json:"status"` // 0 when the proxy failed before getting a response
+	Method      string    `json:"method"`
+	Route       string    `json:"route"`     // Host and path with IDs normalized, e.g. api.example.com/users/{id}
+	Signature   string    `json:"signature"` // Normalized error message or body
+	Count       int       `json:"count"`
+	FirstSeen   time.Time `json:"first_seen"`
+	LastSeen    time.Time `json:"last_seen"`
+	ExampleIDs  []string  `json:"example_ids"` // Most recent first
+}
+
+var (
+	uuidPattern   = regexp.MustCompile(`(?i)^[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}$`)
+	hexIDPattern  = regexp.MustCompile(`(?i)^[0-9a-f]{16,}$`)
+	numberPattern = regexp.MustCompile(`^\d+$`)
+
+	uuidInText   = regexp.MustCompile(`(?i)[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}`)
+	hexInText    = regexp.MustCompile(`(?i)\b[0-9a-f]{16,}\b`)
+	numberInText = regexp.MustCompile(`\d+`)
+)
+
+// errorBodyFields are the JSON fields that usually carry an error's identity
+var errorBodyFields = []string{"error", "code", "type", "message", "detail", "title"}
+
+// isFailedRecord reports whether a record counts as a failure for error analytics
+func isFailedRecord(record RequestRecord) bool {
+	return !record.Success || record.ResponseStatus >= http.StatusBadRequest
+}
+
+// GetErrorClusters groups failed requests by fingerprint, most frequent first
+func (h *RequestHistory) GetErrorClusters() []ErrorCluster {
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+
+	return clusterErrors(h.ordered())
+}
+
+// clusterErrors groups the failed records, which must be most recent first
+func clusterErrors(records []RequestRecord) []ErrorCluster {
+	clusters := make(map[string]*ErrorCluster)
+	// Records are most recent first, so the first record seen is the latest
+	for _, record := range records {
+		if !isFailedRecord(record) {
+			continue
+		}
+
+		route := normalizeRoute(record.URL)
+		signature := errorSignature(record)
+		fingerprint := errorFingerprint(record.ResponseStatus, record.Method, route, signature)
+
+		cluster, ok := clusters[fingerprint]
+		if !ok {
+			cluster = &ErrorCluster{
+				Fingerprint: fingerprint,
+				Status:      record.ResponseStatus,
+				Method:      record.Method,
+				Route:       route,
+				Signature:   signature,
+				LastSeen:    record.Timestamp,
+			}
+			clusters[fingerprint] = cluster
+		}
+		cluster.Count++
+		cluster.FirstSeen = record.Timestamp
+		if len(cluster.ExampleIDs) < maxClusterExamples {
+			cluster.ExampleIDs = append(cluster.ExampleIDs, record.ID)
+		}
+	}
+
+	result := make([]ErrorCluster, 0, len(clusters))
+	for _, cluster := range clusters {
+		result = append(result, *cluster)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Count != result[j].Count {
+			return result[i].Count > result[j].Count
+		}
+		return result[i].LastSeen.After(result[j].LastSeen)
+	})
+	return result
+}
+
+func errorFingerprint(status int, method, route, signature string) string {
+	sum := sha1.Sum([]byte(strings.Join([]string{strconv.Itoa(status), method, route, signature}, "\x00")))
+	return hex.EncodeToString(sum[:6])
+}
+
+// normalizeRoute reduces a URL to host and path with ID-like segments replaced
+func normalizeRoute(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return rawURL
+	}
+
+	segments := strings.Split(u.Path, "/")
+	for i, segment := range segments {
+		switch {
+		case numberPattern.MatchString(segment):
+			segments[i] = "{id}"
+		case uuidPattern.MatchString(segment):
+			segments[i] = "{uuid}"
+		case hexIDPattern.MatchString(segment):
+			segments[i] = "{hex}"
+		}
+	}
+	return strings.ToLower(u.Host) + strings.Join(segments, "/")
+}
+
+// errorSignature summarizes why a request failed: the proxy error, the
+// identifying fields of a JSON error body, or the start of any other body
+func errorSignature(record RequestRecord) string {
+	if record.Error != "" {
+		return normalizeErrorText(record.Error)
+	}
+
+	body := strings.TrimSpace(record.ResponseBody)
+	var fields map[string]interface{}
+	if json.Unmarshal([]byte(body), &fields) == nil {
+		var parts []string
+		for _, key := range errorBodyFields {
+			if value, ok := fields[key]; ok {
+				parts = append(parts, key+"="+jsonSignatureValue(value))
+			}
+		}
+		if len(parts) > 0 {
+			return normalizeErrorText(strings.Join(parts, " "))
+		}
+		// Fall back to the shape of the body so values do not split clusters
+		keys := make([]string, 0, len(fields))
+		for key := range fields {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		return "{" + strings.Join(keys, ",") + "}"
+	}
+	return normalizeErrorText(body)
+}
+
+// jsonSignatureValue flattens nested error objects such as {"error": {"code": "x"}}
+func jsonSignatureValue(value interface{}) string {
+	nested, ok := value.(map[string]interface{})
+	if !ok {
+		data, err := json.Marshal(value)
+		if err != nil {
+			return ""
+		}
+		return strings.Trim(string(data), `"`)
+	}
+	var parts []string
+	for _, key := range errorBodyFields {
+		if inner, ok := nested[key]; ok {
+			parts = append(parts, key+"="+jsonSignatureValue(inner))
+		}
+	}
+	return "{" + strings.Join(parts, " ") + "}"
+}
+
+// normalizeErrorText masks variable parts (IDs, numbers) and collapses whitespace
+func normalizeErrorText(text string) string {
+	text = uuidInText.ReplaceAllString(text, "{uuid}")
+	text = hexInText.ReplaceAllString(text, "{hex}")
+	text = numberInText.ReplaceAllString(text, "#")
+	text = strings.Join(strings.Fields(text), " ")
+	if len(text) > maxSignatureLength {
+		text = text[:maxSignatureLength]
+	}
+	return text
+}
+
+// handleRequestErrors handles error cluster requests
+func (p *Proxy) handleRequestErrors(w http.ResponseWriter, r *http.Request) {
+	// Add CORS headers
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, Cache-Control, Pragma, Expires")
+
+	// Handle preflight requests
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	clusters := p.history.GetErrorClusters()
+	data, err := json.Marshal(map[string]interface{}{
+		"clusters": clusters,
+		"total":    len(clusters),
+	})
+	if err != nil {
+		http.Error(w, "Failed to get error clusters", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if _, err := w.Write(data); err != nil {
+		slog.Warn("Error writing error clusters response", "error", err)
+	}
+}
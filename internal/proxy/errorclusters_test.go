@@ -0,0 +1,107 @@
+//go:build unit
+
+package proxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func failedRecord(id, method, url string, status int, body string, at time.Time) RequestRecord {
+	return RequestRecord{
+		ID:             id,
+		Timestamp:      at,
+		Method:         method,
+		URL:            url,
+		ResponseStatus: status,
+		ResponseBody:   body,
+		Success:        true,
+	}
+}
+
+func TestNormalizeRoute(t *testing.T) {
+	tests := map[string]string{
+		"http://API.example.com/users/42/orders":                            "api.example.com/users/{id}/orders",
+		"http://api.example.com/items/3f2504e0-4f89-11d3-9a0c-0305e82c3301": "api.example.com/items/{uuid}",
+		"http://api.example.com/blobs/a94a8fe5ccb19ba61c4c0873?x=1":         "api.example.com/blobs/{hex}",
+		"http://api.example.com/users/me":                                   "api.example.com/users/me",
+	}
+	for input, expected := range tests {
+		assert.Equal(t, expected, normalizeRoute(input), input)
+	}
+}
+
+func TestErrorSignature(t *testing.T) {
+	assert.Equal(t, "dial tcp: lookup host-#: no such host",
+		errorSignature(RequestRecord{Error: "dial tcp: lookup host-12: no such host"}))
+	assert.Equal(t, "error={code=not_found message=User # not found}",
+		errorSignature(RequestRecord{ResponseBody: `{"error": {"message": "User 17 not found", "code": "not_found"}}`}))
+	assert.Equal(t, "{id,status}",
+		errorSignature(RequestRecord{ResponseBody: `{"status": "bad", "id": 9}`}))
+	assert.Equal(t, "Internal Server Error on request {uuid}",
+		errorSignature(RequestRecord{ResponseBody: "Internal  Server Error on request 3f2504e0-4f89-11d3-9a0c-0305e82c3301\n"}))
+}
+
+func TestGetErrorClusters(t *testing.T) {
+	h := NewRequestHistory(100)
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	// Oldest first, since AddRecord prepends
+	for i := 0; i < 7; i++ {
+		h.AddRecord(failedRecord(fmt.Sprintf("nf-%d", i), http.MethodGet,
+			fmt.Sprintf("http://api.example.com/users/%d", i), http.StatusNotFound,
+			fmt.Sprintf(`{"error": "user %d not found"}`, i), start.Add(time.Duration(i)*time.Minute)))
+	}
+	h.AddRecord(failedRecord("gone", http.MethodGet, "http://api.example.com/users/8", http.StatusGone,
+		`{"error": "user 8 not found"}`, start.Add(10*time.Minute)))
+	h.AddRecord(RequestRecord{ID: "dial", Timestamp: start.Add(11 * time.Minute), Method: http.MethodPost,
+		URL: "http://down.example.com/jobs", Error: "connection refused"})
+	h.AddRecord(failedRecord("ok", http.MethodGet, "http://api.example.com/users/9", http.StatusOK, `{}`, start))
+
+	clusters := h.GetErrorClusters()
+	require.Len(t, clusters, 3)
+
+	notFound := clusters[0]
+	assert.Equal(t, http.StatusNotFound, notFound.Status)
+	assert.Equal(t, "api.example.com/users/{id}", notFound.Route)
+	assert.Equal(t, "error=user # not found", notFound.Signature)
+	assert.Equal(t, 7, notFound.Count)
+	assert.Equal(t, start, notFound.FirstSeen)
+	assert.Equal(t, start.Add(6*time.Minute), notFound.LastSeen)
+	assert.Equal(t, []string{"nf-6", "nf-5", "nf-4", "nf-3", "nf-2"}, notFound.ExampleIDs)
+
+	// Ties are ordered by most recently seen
+	assert.Equal(t, "dial", clusters[1].ExampleIDs[0])
+	assert.Equal(t, 0, clusters[1].Status)
+	assert.Equal(t, http.StatusGone, clusters[2].Status, "a different status splits the cluster")
+	assert.NotEqual(t, notFound.Fingerprint, clusters[2].Fingerprint)
+}
+
+func TestHandleRequestErrors(t *testing.T) {
+	p := New(&Config{})
+	p.history.AddRecord(failedRecord("a", http.MethodGet, "http://api.example.com/x", http.StatusBadGateway, "bad gateway", time.Now()))
+
+	rec := httptest.NewRecorder()
+	p.handleRequestErrors(rec, httptest.NewRequest(http.MethodGet, "/requests/errors", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+
+	var result struct {
+		Clusters []ErrorCluster `json:"clusters"`
+		Total    int            `json:"total"`
+	}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &result))
+	assert.Equal(t, 1, result.Total)
+	assert.Equal(t, []string{"a"}, result.Clusters[0].ExampleIDs)
+
+	rec = httptest.NewRecorder()
+	p.handleRequestErrors(rec, httptest.NewRequest(http.MethodPost, "/requests/errors", nil))
+	assert.Equal(t, http.StatusMethodNotAllowed, rec.Code)
+}
@@ -0,0 +1,181 @@
+package proxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// feedBufferSize is how many records wait for a slow subscriber; later ones
+// are dropped rather than holding up the requests being recorded
+const feedBufferSize = 256
+
+// feedKeepAliveInterval is how often an idle stream sends a comment so
+// intermediaries do not time it out
+const feedKeepAliveInterval = 15 * time.Second
+
+// feedSubscriber receives the records added to history
+type feedSubscriber struct {
+	records chan RequestRecord
+	dropped int // Records that did not fit in the buffer since the last delivery
+}
+
+// recordFeed hands every record added to history to its subscribers
+type recordFeed struct {
+	mutex       sync.Mutex
+	subscribers map[*feedSubscriber]struct{}
+	closed      bool
+}
+
+func newRecordFeed() *recordFeed {
+	return &recordFeed{subscribers: make(map[*feedSubscriber]struct{})}
+}
+
+// subscribe returns a subscriber for the records published from now on, or
+// nil once the feed is closed
+func (f *recordFeed) subscribe() *feedSubscriber {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if f.closed {
+		return nil
+	}
+	subscriber := &feedSubscriber{records: make(chan RequestRecord, feedBufferSize)}
+	f.subscribers[subscriber] = struct{}{}
+	return subscriber
+}
+
+// unsubscribe stops publishing to a subscriber
+func (f *recordFeed) unsubscribe(subscriber *feedSubscriber) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if _, ok := f.subscribers[subscriber]; ok {
+		delete(f.subscribers, subscriber)
+		close(subscriber.records)
+	}
+}
+
+// publish hands a record to every subscriber with room for it
+func (f *recordFeed) publish(record RequestRecord) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	for subscriber := range f.subscribers {
+		select {
+		case subscriber.records <- record:
+		default:
+			subscriber.dropped++
+		}
+	}
+}
+
+// takeDropped returns and resets how many records a subscriber missed
+func (f *recordFeed) takeDropped(subscriber *feedSubscriber) int {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	dropped := subscriber.dropped
+	subscriber.dropped = 0
+	return dropped
+}
+
+// close ends every subscription, so open streams return
+func (f *recordFeed) close() {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.closed = true
+	for subscriber := range f.subscribers {
+		delete(f.subscribers, subscriber)
+		close(subscriber.records)
+	}
+}
+
+// handleRequestStream serves GET /requests/stream: each new record matching
+// GET /requests filters as a Server-Sent Event once it completes
+func (p *Proxy) handleRequestStream(w http.ResponseWriter, r *http.Request) {
+	// Add CORS headers
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, Cache-Control, Pragma, Expires, Last-Event-ID")
+
+	// Handle preflight requests
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	filter, err := p.historyFilter(r.URL.Query())
+	if err != nil {
+		http.Error(w, "Invalid filter: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	summary, _ := strconv.ParseBool(r.URL.Query().Get("summary"))
+
+	subscriber := p.feed.subscribe()
+	if subscriber == nil {
+		http.Error(w, "Proxy is shutting down", http.StatusServiceUnavailable)
+		return
+	}
+	defer p.feed.unsubscribe(subscriber)
+
+	controller := http.NewResponseController(w)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	// Tell clients to wait a few seconds before reconnecting
+	if _, err := fmt.Fprint(w, "retry: 3000\n\n"); err != nil {
+		slog.Warn("Error writing request stream", "error", err)
+		return
+	}
+	if err := controller.Flush(); err != nil {
+		slog.Warn("Error writing request stream", "error", err)
+		return
+	}
+
+	keepAlive := time.NewTicker(feedKeepAliveInterval)
+	defer keepAlive.Stop()
+	for {
+		var event string
+		select {
+		case <-r.Context().Done():
+			return
+		case <-keepAlive.C:
+			event = ": keep-alive\n\n"
+		case record, ok := <-subscriber.records:
+			if !ok {
+				return
+			}
+			if dropped := p.feed.takeDropped(subscriber); dropped > 0 {
+				event = fmt.Sprintf("event: dropped\ndata: {\"count\":%d}\n\n", dropped)
+			}
+			if !filter.Matches(record) {
+				break
+			}
+			if summary {
+				record.summarize()
+			}
+			data, err := json.Marshal(record)
+			if err != nil {
+				slog.Error("Error encoding streamed request", "id", record.ID, "error", err)
+				break
+			}
+			event += "event: request\nid: " + record.ID + "\ndata: " + string(data) + "\n\n"
+		}
+		if event == "" {
+			continue
+		}
+		if _, err := fmt.Fprint(w, event); err != nil {
+			return
+		}
+		if err := controller.Flush(); err != nil {
+			return
+		}
+	}
+}
@@ -0,0 +1,82 @@
+//go:build unit
+
+package proxy
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// readEvent reads the next Server-Sent Event, skipping comments
+func readEvent(t *testing.T, reader *bufio.Reader) map[string]string {
+	event := make(map[string]string)
+	for {
+		line, err := reader.ReadString('\n')
+		require.NoError(t, err)
+		line = strings.TrimSuffix(line, "\n")
+		if line == "" {
+			if len(event) > 0 {
+				return event
+			}
+			continue
+		}
+		if strings.HasPrefix(line, ":") {
+			continue
+		}
+		field, value, _ := strings.Cut(line, ": ")
+		event[field] = value
+	}
+}
+
+func TestHandleRequestStream(t *testing.T) {
+	p := New(&Config{})
+	server := httptest.NewServer(http.HandlerFunc(p.handleRequestStream))
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/requests/stream?method=POST&summary=true")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+	reader := bufio.NewReader(resp.Body)
+	assert.Equal(t, "3000", readEvent(t, reader)["retry"], "subscribed once the retry interval arrives")
+
+	p.storeRecord(RequestRecord{ID: "get", Method: "GET", URL: "http://api.example.com/users"})
+	p.storeRecord(RequestRecord{ID: "post", Method: "POST", URL: "http://api.example.com/users", RequestBody: `{"name":"ada"}`})
+
+	event := readEvent(t, reader)
+	assert.Equal(t, "request", event["event"])
+	assert.Equal(t, "post", event["id"], "records not matching the filter are skipped")
+	var record RequestRecord
+	require.NoError(t, json.Unmarshal([]byte(event["data"]), &record))
+	assert.Equal(t, "http://api.example.com/users", record.URL)
+	assert.Empty(t, record.RequestBody, "summaries leave out bodies")
+
+	// Stopping ends the stream
+	p.feed.close()
+	_, err = reader.ReadString('\n')
+	assert.Error(t, err)
+}
+
+func TestRecordFeedDropsForSlowSubscribers(t *testing.T) {
+	feed := newRecordFeed()
+	subscriber := feed.subscribe()
+	for i := 0; i < feedBufferSize+3; i++ {
+		feed.publish(RequestRecord{ID: "r"})
+	}
+	assert.Len(t, subscriber.records, feedBufferSize)
+	assert.Equal(t, 3, feed.takeDropped(subscriber))
+	assert.Equal(t, 0, feed.takeDropped(subscriber))
+
+	feed.unsubscribe(subscriber)
+	feed.publish(RequestRecord{ID: "after"})
+	feed.close()
+	assert.Nil(t, feed.subscribe(), "no subscriptions once closed")
+}
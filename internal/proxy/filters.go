@@ -0,0 +1,602 @@
+package proxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// filterParams are the query parameters understood by RequestFilter
+var filterParams = map[string]bool{
+	"id":           true,
+	"method":       true,
+	"status":       true,
+	"host":         true,
+	"path":         true,
+	"min_duration": true,
+	"max_duration": true,
+	"errors":       true,
+	"success":      true,
+	"since":        true,
+	"until":        true,
+	"tag":          true,
+	"trace_id":     true,
+}
+
+// filterNamePattern restricts saved filter names to something safe in URLs and shells
+var filterNamePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9 ._-]{0,63}$`)
+
+// statusRange is an inclusive range of response status codes
+type statusRange struct{ low, high int }
+
+// RequestFilter selects history records by query parameters such as
+// method=POST&status=5xx&host=api.example.com&min_duration=1s
+type RequestFilter struct {
+	IDs         []string
+	Methods     []string
+	Statuses    []statusRange
+	Host        string
+	PathPrefix  string
+	MinDuration time.Duration
+	MaxDuration time.Duration
+	ErrorsOnly  bool
+	Success     *bool               // Records whose exchange did or did not complete without a transport error
+	Since       time.Time           // Records from this time on
+	Until       time.Time           // Records before this time
+	Tags        []string            // Records with any of these X-Netkit-Options tags
+	TraceIDs    []string            // Records in any of these W3C traces
+	Params      map[string][]string // Query parameter name to accepted values; "" accepts any value
+	Segments    map[int][]string    // Path segment index to accepted values
+}
+
+// ParseRequestFilter parses filter query parameters. Parameters that are not
+// filters (such as filter=<name>) are ignored unless strict is set.
+func ParseRequestFilter(values url.Values, strict bool) (*RequestFilter, error) {
+	filter := &RequestFilter{}
+	for key := range values {
+		if strict && !isFilterParam(key) {
+			return nil, fmt.Errorf("unknown filter parameter %q", key)
+		}
+	}
+
+	filter.IDs = splitFilterList(values["id"])
+
+	for _, method := range splitFilterList(values["method"]) {
+		filter.Methods = append(filter.Methods, strings.ToUpper(method))
+	}
+
+	for _, status := range splitFilterList(values["status"]) {
+		statuses, err := parseStatusRange(status)
+		if err != nil {
+			return nil, err
+		}
+		filter.Statuses = append(filter.Statuses, statuses)
+	}
+
+	filter.Tags = splitFilterList(values["tag"])
+	for _, traceID := range splitFilterList(values["trace_id"]) {
+		filter.TraceIDs = append(filter.TraceIDs, strings.ToLower(traceID))
+	}
+
+	filter.Host = strings.ToLower(values.Get("host"))
+	filter.PathPrefix = values.Get("path")
+
+	var err error
+	if value := values.Get("min_duration"); value != "" {
+		if filter.MinDuration, err = time.ParseDuration(value); err != nil {
+			return nil, fmt.Errorf("invalid min_duration: %v", err)
+		}
+	}
+	if value := values.Get("max_duration"); value != "" {
+		if filter.MaxDuration, err = time.ParseDuration(value); err != nil {
+			return nil, fmt.Errorf("invalid max_duration: %v", err)
+		}
+	}
+	if value := values.Get("errors"); value != "" {
+		if filter.ErrorsOnly, err = strconv.ParseBool(value); err != nil {
+			return nil, fmt.Errorf("invalid errors: %v", err)
+		}
+	}
+	if value := values.Get("success"); value != "" {
+		success, err := strconv.ParseBool(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid success: %v", err)
+		}
+		filter.Success = &success
+	}
+	now := time.Now()
+	if value := values.Get("since"); value != "" {
+		if filter.Since, err = parseWindowBound(value, now); err != nil {
+			return nil, fmt.Errorf("invalid since: %v", err)
+		}
+	}
+	if value := values.Get("until"); value != "" {
+		if filter.Until, err = parseWindowBound(value, now); err != nil {
+			return nil, fmt.Errorf("invalid until: %v", err)
+		}
+	}
+
+	// URL components: repeated values match any of them
+	for key, accepted := range values {
+		if name, ok := strings.CutPrefix(key, paramFilterPrefix); ok {
+			if name == "" {
+				return nil, fmt.Errorf("invalid %s: missing parameter name", key)
+			}
+			if filter.Params == nil {
+				filter.Params = make(map[string][]string)
+			}
+			filter.Params[name] = accepted
+		}
+		if index, ok := strings.CutPrefix(key, segmentFilterPrefix); ok {
+			position, err := strconv.Atoi(index)
+			if err != nil || position < 0 {
+				return nil, fmt.Errorf("invalid %s: expected a segment index from 0", key)
+			}
+			if filter.Segments == nil {
+				filter.Segments = make(map[int][]string)
+			}
+			filter.Segments[position] = accepted
+		}
+	}
+	return filter, nil
+}
+
+// splitFilterList flattens repeated and comma-separated parameter values
+func splitFilterList(values []string) []string {
+	var result []string
+	for _, value := range values {
+		for _, part := range strings.Split(value, ",") {
+			if part = strings.TrimSpace(part); part != "" {
+				result = append(result, part)
+			}
+		}
+	}
+	return result
+}
+
+// parseStatusRange parses "404", "5xx", or "400-499"
+func parseStatusRange(value string) (statusRange, error) {
+	lower := strings.ToLower(value)
+	if len(lower) == 3 && strings.HasSuffix(lower, "xx") && lower[0] >= '1' && lower[0] <= '5' {
+		base := int(lower[0]-'0') * 100
+		return statusRange{base, base + 99}, nil
+	}
+	lowPart, highPart, isRange := strings.Cut(lower, "-")
+	low, err := strconv.Atoi(lowPart)
+	if err != nil {
+		return statusRange{}, fmt.Errorf("invalid status %q (expected 404, 5xx, or 400-499)", value)
+	}
+	high := low
+	if isRange {
+		if high, err = strconv.Atoi(highPart); err != nil || high < low {
+			return statusRange{}, fmt.Errorf("invalid status %q (expected 404, 5xx, or 400-499)", value)
+		}
+	}
+	return statusRange{low, high}, nil
+}
+
+// Matches reports whether a record passes every condition of the filter
+func (f *RequestFilter) Matches(record RequestRecord) bool {
+	if len(f.IDs) > 0 && !containsString(f.IDs, record.ID) {
+		return false
+	}
+
+	if len(f.Methods) > 0 && !containsString(f.Methods, record.Method) {
+		return false
+	}
+
+	if len(f.Statuses) > 0 {
+		matched := false
+		for _, status := range f.Statuses {
+			if record.ResponseStatus >= status.low && record.ResponseStatus <= status.high {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+
+	if f.Host != "" || f.PathPrefix != "" || len(f.Params) > 0 || len(f.Segments) > 0 {
+		components := recordURLComponents(record)
+		if components == nil || !f.matchesURL(components) {
+			return false
+		}
+	}
+
+	duration := time.Duration(record.TotalDurationUs) * time.Microsecond
+	if f.MinDuration > 0 && duration < f.MinDuration {
+		return false
+	}
+	if f.MaxDuration > 0 && duration > f.MaxDuration {
+		return false
+	}
+
+	if f.ErrorsOnly && !isFailedRecord(record) {
+		return false
+	}
+	if f.Success != nil && record.Success != *f.Success {
+		return false
+	}
+	if !f.Since.IsZero() && record.Timestamp.Before(f.Since) {
+		return false
+	}
+	if !f.Until.IsZero() && !record.Timestamp.Before(f.Until) {
+		return false
+	}
+
+	if len(f.Tags) > 0 && !containsAnyString(f.Tags, record.Tags) {
+		return false
+	}
+	if len(f.TraceIDs) > 0 && !containsString(f.TraceIDs, record.TraceID) {
+		return false
+	}
+	return true
+}
+
+// matchesURL reports whether URL components pass the host, path, query
+// parameter, and path segment conditions
+func (f *RequestFilter) matchesURL(components *URLComponents) bool {
+	if f.Host != "" && components.Host != f.Host && net.JoinHostPort(components.Host, components.Port) != f.Host {
+		return false
+	}
+	if f.PathPrefix != "" && !strings.HasPrefix(components.Path, f.PathPrefix) {
+		return false
+	}
+	for name, accepted := range f.Params {
+		values, present := components.Query[name]
+		if !present {
+			return false
+		}
+		if !containsString(accepted, "") && !containsAnyString(accepted, values) {
+			return false
+		}
+	}
+	for position, accepted := range f.Segments {
+		if position >= len(components.Segments) || !containsString(accepted, components.Segments[position]) {
+			return false
+		}
+	}
+	return true
+}
+
+// containsAnyString reports whether any of values is in accepted
+func containsAnyString(accepted, values []string) bool {
+	for _, value := range values {
+		if containsString(accepted, value) {
+			return true
+		}
+	}
+	return false
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// GetFilteredRecords returns the records that match the filter, most recent first
+func (h *RequestHistory) GetFilteredRecords(filter *RequestFilter) []RequestRecord {
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+
+	// Records asked for by ID are looked up directly
+	if len(filter.IDs) > 0 {
+		var indexes []int
+		for _, id := range filter.IDs {
+			if i, ok := h.byID[id]; ok && !slices.Contains(indexes, i) {
+				indexes = append(indexes, i)
+			}
+		}
+		slices.SortFunc(indexes, func(a, b int) int { return h.age(a) - h.age(b) })
+		records := make([]RequestRecord, 0, len(indexes))
+		for _, i := range indexes {
+			if filter.Matches(h.records[i]) {
+				records = append(records, h.records[i])
+			}
+		}
+		return records
+	}
+
+	records := make([]RequestRecord, 0)
+	for i := range h.records {
+		if record := h.at(i); filter.Matches(*record) {
+			records = append(records, *record)
+		}
+	}
+	return records
+}
+
+// SavedFilter is a named set of history filter parameters
+type SavedFilter struct {
+	Name        string    `json:"name"`
+	Description string    `json:"description,omitempty"`
+	Query       string    `json:"query"` // e.g. status=5xx&host=api.example.com&min_duration=1s
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// filterStore keeps saved filters, persisting them to a file when one is configured
+type filterStore struct {
+	mutex   sync.RWMutex
+	path    string
+	filters map[string]SavedFilter
+}
+
+// newFilterStore loads saved filters from path, which may not exist yet
+func newFilterStore(path string) (*filterStore, error) {
+	store := &filterStore{path: path, filters: make(map[string]SavedFilter)}
+	if path == "" {
+		return store, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return store, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read filters file: %v", err)
+	}
+
+	var filters []SavedFilter
+	if err := json.Unmarshal(data, &filters); err != nil {
+		return nil, fmt.Errorf("failed to parse filters file: %v", err)
+	}
+	for _, filter := range filters {
+		store.filters[filter.Name] = filter
+	}
+	return store, nil
+}
+
+// List returns the saved filters sorted by name
+func (s *filterStore) List() []SavedFilter {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	filters := make([]SavedFilter, 0, len(s.filters))
+	for _, filter := range s.filters {
+		filters = append(filters, filter)
+	}
+	sort.Slice(filters, func(i, j int) bool { return filters[i].Name < filters[j].Name })
+	return filters
+}
+
+// Get returns the saved filter with the given name
+func (s *filterStore) Get(name string) (SavedFilter, bool) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	filter, ok := s.filters[name]
+	return filter, ok
+}
+
+// Save validates and stores a filter, replacing one with the same name
+func (s *filterStore) Save(filter SavedFilter) error {
+	if !filterNamePattern.MatchString(filter.Name) {
+		return fmt.Errorf("invalid filter name %q (letters, digits, spaces, '.', '_', and '-', up to 64 characters)", filter.Name)
+	}
+	values, err := url.ParseQuery(strings.TrimPrefix(filter.Query, "?"))
+	if err != nil {
+		return fmt.Errorf("invalid query: %v", err)
+	}
+	if len(values) == 0 {
+		return fmt.Errorf("query must set at least one filter parameter")
+	}
+	if _, err := ParseRequestFilter(values, true); err != nil {
+		return err
+	}
+	filter.Query = values.Encode()
+	if filter.CreatedAt.IsZero() {
+		filter.CreatedAt = time.Now()
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	previous, existed := s.filters[filter.Name]
+	s.filters[filter.Name] = filter
+	if err := s.persist(); err != nil {
+		if existed {
+			s.filters[filter.Name] = previous
+		} else {
+			delete(s.filters, filter.Name)
+		}
+		return err
+	}
+	return nil
+}
+
+// Delete removes a saved filter, reporting whether it existed
+func (s *filterStore) Delete(name string) (bool, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	filter, ok := s.filters[name]
+	if !ok {
+		return false, nil
+	}
+	delete(s.filters, name)
+	if err := s.persist(); err != nil {
+		s.filters[name] = filter
+		return false, err
+	}
+	return true, nil
+}
+
+// persist writes the filters to the filters file; the caller holds the lock
+func (s *filterStore) persist() error {
+	if s.path == "" {
+		return nil
+	}
+
+	filters := make([]SavedFilter, 0, len(s.filters))
+	for _, filter := range s.filters {
+		filters = append(filters, filter)
+	}
+	sort.Slice(filters, func(i, j int) bool { return filters[i].Name < filters[j].Name })
+
+	data, err := json.MarshalIndent(filters, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return fmt.Errorf("failed to write filters file: %v", err)
+	}
+	if err := os.Rename(tmp, s.path); err != nil {
+		return fmt.Errorf("failed to write filters file: %v", err)
+	}
+	return nil
+}
+
+// historyFilter builds the filter for a history query. A filter=<name>
+// parameter applies a saved filter, and explicit parameters override it.
+func (p *Proxy) historyFilter(query url.Values) (*RequestFilter, error) {
+	values := url.Values{}
+	if name := query.Get("filter"); name != "" {
+		saved, ok := p.filters.Get(name)
+		if !ok {
+			return nil, fmt.Errorf("no saved filter named %q", name)
+		}
+		savedValues, err := url.ParseQuery(saved.Query)
+		if err != nil {
+			return nil, fmt.Errorf("saved filter %q has an invalid query: %v", name, err)
+		}
+		values = savedValues
+	}
+	for key, value := range query {
+		if isFilterParam(key) {
+			values[key] = value
+		}
+	}
+	return ParseRequestFilter(values, false)
+}
+
+// historyPage selects a page of GET /requests results: limit records after
+// skipping offset of them, or after the record a cursor points at. A cursor
+// is the ID of the last record of the previous page, so pages stay in place
+// while new records arrive.
+type historyPage struct {
+	limit  int // 0 for every remaining record
+	offset int
+	cursor string
+}
+
+// parseHistoryPage parses the limit, offset, and cursor query parameters
+func parseHistoryPage(query url.Values) (historyPage, error) {
+	var page historyPage
+	var err error
+	if value := query.Get("limit"); value != "" {
+		if page.limit, err = strconv.Atoi(value); err != nil || page.limit <= 0 {
+			return page, fmt.Errorf("invalid limit %q (expected a positive number)", value)
+		}
+	}
+	if value := query.Get("offset"); value != "" {
+		if page.offset, err = strconv.Atoi(value); err != nil || page.offset < 0 {
+			return page, fmt.Errorf("invalid offset %q (expected a number from 0)", value)
+		}
+	}
+	page.cursor = query.Get("cursor")
+	if page.cursor != "" && page.offset > 0 {
+		return page, fmt.Errorf("offset and cursor cannot be used together")
+	}
+	return page, nil
+}
+
+// apply returns the page of records and the cursor of the next page, or ""
+// for the last page
+func (hp historyPage) apply(records []RequestRecord) ([]RequestRecord, string, error) {
+	start := min(hp.offset, len(records))
+	if hp.cursor != "" {
+		index := slices.IndexFunc(records, func(record RequestRecord) bool { return record.ID == hp.cursor })
+		if index < 0 {
+			return nil, "", fmt.Errorf("cursor %q is no longer in history", hp.cursor)
+		}
+		start = index + 1
+	}
+	records = records[start:]
+	if hp.limit == 0 || len(records) <= hp.limit {
+		return records, "", nil
+	}
+	records = records[:hp.limit]
+	return records, records[len(records)-1].ID, nil
+}
+
+// handleSavedFilters lists, saves, and deletes saved history filters
+func (p *Proxy) handleSavedFilters(w http.ResponseWriter, r *http.Request) {
+	// Add CORS headers
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "GET, POST, DELETE, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, Cache-Control, Pragma, Expires")
+
+	// Handle preflight requests
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	var response interface{}
+	status := http.StatusOK
+	switch r.Method {
+	case http.MethodGet:
+		response = map[string]interface{}{"filters": p.filters.List()}
+
+	case http.MethodPost:
+		var filter SavedFilter
+		if err := json.NewDecoder(r.Body).Decode(&filter); err != nil {
+			http.Error(w, "Invalid filter JSON", http.StatusBadRequest)
+			return
+		}
+		filter.CreatedAt = time.Time{}
+		if err := p.filters.Save(filter); err != nil {
+			http.Error(w, "Invalid filter: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		saved, _ := p.filters.Get(filter.Name)
+		response = saved
+		status = http.StatusCreated
+
+	case http.MethodDelete:
+		name := r.URL.Query().Get("name")
+		deleted, err := p.filters.Delete(name)
+		if err != nil {
+			http.Error(w, "Failed to delete filter", http.StatusInternalServerError)
+			return
+		}
+		if !deleted {
+			http.Error(w, "Filter not found", http.StatusNotFound)
+			return
+		}
+		response = map[string]interface{}{"success": true, "message": "Filter deleted"}
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	data, err := json.Marshal(response)
+	if err != nil {
+		http.Error(w, "Failed to encode filters", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if _, err := w.Write(data); err != nil {
+		slog.Warn("Error writing saved filters response", "error", err)
+	}
+}
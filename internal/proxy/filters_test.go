@@ -0,0 +1,302 @@
+//go:build unit
+
+package proxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func filterRecords() []RequestRecord {
+	return []RequestRecord{
+		{ID: "slow-500", Method: http.MethodGet, URL: "http://api.prod.example.com/users/1", ResponseStatus: 500, Success: true, TotalDurationUs: 2000000, TraceID: "4bf92f3577b34da6a3ce929d0e0e4736"},
+		{ID: "fast-503", Method: http.MethodPost, URL: "http://api.prod.example.com/orders", ResponseStatus: 503, Success: true, TotalDurationUs: 20000},
+		{ID: "slow-200", Method: http.MethodGet, URL: "http://api.prod.example.com/users/2", ResponseStatus: 200, Success: true, TotalDurationUs: 3000000, TraceID: "0af7651916cd43dd8448eb211c80319c"},
+		{ID: "staging-500", Method: http.MethodGet, URL: "http://api.staging.example.com:8443/users/1", ResponseStatus: 500, Success: true, TotalDurationUs: 2000000},
+		{ID: "dial", Method: http.MethodGet, URL: "http://down.example.com/", Error: "connection refused"},
+	}
+}
+
+func filterIDs(t *testing.T, query string) []string {
+	t.Helper()
+	values, err := url.ParseQuery(query)
+	require.NoError(t, err)
+	filter, err := ParseRequestFilter(values, true)
+	require.NoError(t, err)
+
+	var ids []string
+	for _, record := range filterRecords() {
+		if filter.Matches(record) {
+			ids = append(ids, record.ID)
+		}
+	}
+	return ids
+}
+
+func TestRequestFilter(t *testing.T) {
+	assert.Equal(t, []string{"slow-500"}, filterIDs(t, "host=api.prod.example.com&status=5xx&min_duration=1s"))
+	assert.Equal(t, []string{"slow-500", "fast-503", "staging-500"}, filterIDs(t, "status=500,503"))
+	assert.Equal(t, []string{"slow-500", "fast-503", "staging-500"}, filterIDs(t, "status=500-599"))
+	assert.Equal(t, []string{"fast-503"}, filterIDs(t, "method=post"))
+	assert.Equal(t, []string{"staging-500"}, filterIDs(t, "host=api.staging.example.com"))
+	assert.Equal(t, []string{"slow-500", "slow-200", "staging-500"}, filterIDs(t, "path=/users"))
+	assert.Equal(t, []string{"fast-503", "dial"}, filterIDs(t, "max_duration=100ms"))
+	assert.Equal(t, []string{"slow-500", "fast-503", "staging-500", "dial"}, filterIDs(t, "errors=true"))
+	assert.Equal(t, []string{"fast-503", "dial"}, filterIDs(t, "id=dial,fast-503"))
+	assert.Equal(t, []string{"dial"}, filterIDs(t, "success=false"))
+	assert.Equal(t, []string{"slow-500", "slow-200"}, filterIDs(t, "trace_id=4BF92F3577B34DA6A3CE929D0E0E4736,0af7651916cd43dd8448eb211c80319c"))
+	assert.Len(t, filterIDs(t, ""), 5)
+}
+
+func TestParseRequestFilterErrors(t *testing.T) {
+	for _, query := range []string{"status=abc", "status=500-400", "min_duration=fast", "errors=maybe", "success=maybe", "since=yesterday"} {
+		values, err := url.ParseQuery(query)
+		require.NoError(t, err)
+		_, err = ParseRequestFilter(values, false)
+		assert.Error(t, err, query)
+	}
+
+	_, err := ParseRequestFilter(url.Values{"colour": {"red"}}, true)
+	assert.Error(t, err)
+	_, err = ParseRequestFilter(url.Values{"colour": {"red"}}, false)
+	assert.NoError(t, err)
+}
+
+func TestRequestFilterTimes(t *testing.T) {
+	now := time.Now()
+	filter, err := ParseRequestFilter(url.Values{"since": {"1h"}, "until": {now.Add(-time.Minute).Format(time.RFC3339)}}, true)
+	require.NoError(t, err)
+	assert.False(t, filter.Matches(RequestRecord{Timestamp: now.Add(-2 * time.Hour)}))
+	assert.True(t, filter.Matches(RequestRecord{Timestamp: now.Add(-30 * time.Minute)}))
+	assert.False(t, filter.Matches(RequestRecord{Timestamp: now}))
+}
+
+// decodeHistory decodes a GET /requests response into its records and total
+func decodeHistory(t *testing.T, rec *httptest.ResponseRecorder) ([]RequestRecord, int) {
+	t.Helper()
+	var page struct {
+		Records []RequestRecord `json:"records"`
+		Total   int             `json:"total"`
+	}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &page))
+	return page.Records, page.Total
+}
+
+func TestRequestHistoryPages(t *testing.T) {
+	p := New(&Config{})
+	for i := 0; i < 5; i++ {
+		p.history.AddRecord(RequestRecord{ID: fmt.Sprintf("r%d", i), Method: http.MethodGet, URL: "http://api.example.com/", Timestamp: time.Now()})
+	}
+
+	page := func(query string) ([]string, *httptest.ResponseRecorder) {
+		rec := httptest.NewRecorder()
+		p.handleRequestHistory(rec, httptest.NewRequest(http.MethodGet, "/requests?"+query, nil))
+		if rec.Code != http.StatusOK {
+			return nil, rec
+		}
+		records, total := decodeHistory(t, rec)
+		assert.Equal(t, strconv.Itoa(total), rec.Header().Get("X-Total-Count"))
+		ids := []string{}
+		for _, record := range records {
+			ids = append(ids, record.ID)
+		}
+		return ids, rec
+	}
+
+	ids, rec := page("limit=2")
+	assert.Equal(t, []string{"r4", "r3"}, ids)
+	assert.Equal(t, "5", rec.Header().Get("X-Total-Count"))
+	assert.Equal(t, "r3", rec.Header().Get("X-Next-Cursor"))
+	assert.Equal(t, `</requests?cursor=r3&limit=2>; rel="next"`, rec.Header().Get("Link"))
+
+	// New records do not shift a cursor
+	p.history.AddRecord(RequestRecord{ID: "r5", Method: http.MethodGet, URL: "http://api.example.com/", Timestamp: time.Now()})
+	ids, rec = page("limit=2&cursor=r3")
+	assert.Equal(t, []string{"r2", "r1"}, ids)
+	ids, rec = page("limit=2&cursor=" + rec.Header().Get("X-Next-Cursor"))
+	assert.Equal(t, []string{"r0"}, ids)
+	assert.Empty(t, rec.Header().Get("X-Next-Cursor"))
+	assert.Empty(t, rec.Header().Get("Link"))
+
+	ids, _ = page("offset=1&limit=2")
+	assert.Equal(t, []string{"r4", "r3"}, ids)
+	ids, _ = page("offset=10")
+	assert.Empty(t, ids)
+
+	for _, query := range []string{"limit=0", "offset=-1", "offset=1&cursor=r3", "cursor=gone"} {
+		_, rec := page(query)
+		assert.Equal(t, http.StatusBadRequest, rec.Code, query)
+	}
+}
+
+func TestRequestDetail(t *testing.T) {
+	p := New(&Config{})
+	p.history.AddRecord(RequestRecord{
+		ID:              "abc",
+		Method:          http.MethodPost,
+		URL:             "http://api.example.com/orders",
+		RequestHeaders:  map[string]string{"Content-Type": "application/json"},
+		RequestBody:     `{"sku":"A1"}`,
+		ResponseStatus:  201,
+		ResponseHeaders: map[string]string{"Location": "/orders/1"},
+		ResponseBody:    `{"id":1}`,
+		Timestamp:       time.Now(),
+	})
+
+	// The list leaves out headers and bodies on request
+	rec := httptest.NewRecorder()
+	p.handleRequestHistory(rec, httptest.NewRequest(http.MethodGet, "/requests?summary=true", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	records, _ := decodeHistory(t, rec)
+	require.Len(t, records, 1)
+	assert.Equal(t, 201, records[0].ResponseStatus)
+	assert.Empty(t, records[0].RequestHeaders)
+	assert.Empty(t, records[0].ResponseBody)
+
+	rec = httptest.NewRecorder()
+	p.handleRequestDetail(rec, httptest.NewRequest(http.MethodGet, "/requests/abc", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	var record RequestRecord
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &record))
+	assert.Equal(t, "abc", record.ID)
+	assert.Equal(t, "application/json", record.RequestHeaders["Content-Type"])
+	assert.Equal(t, `{"sku":"A1"}`, record.RequestBody)
+	assert.Equal(t, `{"id":1}`, record.ResponseBody)
+
+	for _, path := range []string{"/requests/missing", "/requests/abc/more"} {
+		rec = httptest.NewRecorder()
+		p.handleRequestDetail(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		assert.Equal(t, http.StatusNotFound, rec.Code, path)
+	}
+	rec = httptest.NewRecorder()
+	p.handleRequestDetail(rec, httptest.NewRequest(http.MethodPut, "/requests/abc", nil))
+	assert.Equal(t, http.StatusMethodNotAllowed, rec.Code)
+}
+
+func TestDeleteRequest(t *testing.T) {
+	p := New(&Config{ClearProtection: ClearProtectionConfirm})
+	for _, id := range []string{"keep", "secret"} {
+		p.history.AddRecord(RequestRecord{ID: id, Method: http.MethodGet, URL: "http://api.example.com/", Timestamp: time.Now()})
+	}
+
+	rec := httptest.NewRecorder()
+	p.handleRequestDetail(rec, httptest.NewRequest(http.MethodDelete, "/requests/secret", nil))
+	require.Equal(t, http.StatusPreconditionRequired, rec.Code)
+	var confirmation struct {
+		ConfirmToken string `json:"confirm_token"`
+	}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &confirmation))
+
+	req := httptest.NewRequest(http.MethodDelete, "/requests/secret", nil)
+	req.Header.Set(ConfirmHeader, confirmation.ConfirmToken)
+	rec = httptest.NewRecorder()
+	p.handleRequestDetail(rec, req)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+	records := p.history.GetRecords()
+	require.Len(t, records, 1)
+	assert.Equal(t, "keep", records[0].ID)
+
+	p = New(&Config{})
+	rec = httptest.NewRecorder()
+	p.handleRequestDetail(rec, httptest.NewRequest(http.MethodDelete, "/requests/missing", nil))
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+}
+
+func TestFilterStorePersistence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "filters.json")
+	store, err := newFilterStore(path)
+	require.NoError(t, err)
+
+	require.NoError(t, store.Save(SavedFilter{Name: "prod 5xx over 1s", Query: "?status=5xx&host=api.prod.example.com&min_duration=1s"}))
+	require.NoError(t, store.Save(SavedFilter{Name: "posts", Query: "method=POST", Description: "Writes"}))
+	assert.Error(t, store.Save(SavedFilter{Name: "bad", Query: "colour=red"}))
+	assert.Error(t, store.Save(SavedFilter{Name: "empty", Query: ""}))
+	assert.Error(t, store.Save(SavedFilter{Name: "../etc", Query: "method=GET"}))
+
+	reloaded, err := newFilterStore(path)
+	require.NoError(t, err)
+	filters := reloaded.List()
+	require.Len(t, filters, 2)
+	assert.Equal(t, "posts", filters[0].Name)
+	assert.Equal(t, "Writes", filters[0].Description)
+	assert.Equal(t, "prod 5xx over 1s", filters[1].Name)
+	assert.Equal(t, "host=api.prod.example.com&min_duration=1s&status=5xx", filters[1].Query)
+
+	deleted, err := reloaded.Delete("posts")
+	require.NoError(t, err)
+	assert.True(t, deleted)
+	deleted, err = reloaded.Delete("posts")
+	require.NoError(t, err)
+	assert.False(t, deleted)
+
+	reloaded, err = newFilterStore(path)
+	require.NoError(t, err)
+	assert.Len(t, reloaded.List(), 1)
+}
+
+func TestSavedFiltersAPI(t *testing.T) {
+	p := New(&Config{})
+	for i := len(filterRecords()) - 1; i >= 0; i-- {
+		record := filterRecords()[i]
+		record.Timestamp = time.Now()
+		p.history.AddRecord(record)
+	}
+
+	rec := httptest.NewRecorder()
+	p.handleSavedFilters(rec, httptest.NewRequest(http.MethodPost, "/requests/filters",
+		strings.NewReader(`{"name": "prod 5xx", "query": "host=api.prod.example.com&status=5xx"}`)))
+	require.Equal(t, http.StatusCreated, rec.Code)
+
+	rec = httptest.NewRecorder()
+	p.handleSavedFilters(rec, httptest.NewRequest(http.MethodGet, "/requests/filters", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	var list struct {
+		Filters []SavedFilter `json:"filters"`
+	}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &list))
+	require.Len(t, list.Filters, 1)
+	assert.Equal(t, "prod 5xx", list.Filters[0].Name)
+
+	history := func(query string) []string {
+		rec := httptest.NewRecorder()
+		p.handleRequestHistory(rec, httptest.NewRequest(http.MethodGet, "/requests?"+query, nil))
+		require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+		records, _ := decodeHistory(t, rec)
+		var ids []string
+		for _, record := range records {
+			ids = append(ids, record.ID)
+		}
+		return ids
+	}
+	assert.Equal(t, []string{"slow-500", "fast-503"}, history("filter=prod+5xx"))
+	// Explicit parameters override the saved ones
+	assert.Equal(t, []string{"slow-500"}, history("filter=prod+5xx&status=500"))
+	assert.Len(t, history(""), 5)
+
+	rec = httptest.NewRecorder()
+	p.handleRequestHistory(rec, httptest.NewRequest(http.MethodGet, "/requests?filter=missing", nil))
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	rec = httptest.NewRecorder()
+	p.handleSavedFilters(rec, httptest.NewRequest(http.MethodPost, "/requests/filters", strings.NewReader(`{"name": "x", "query": "status=bad"}`)))
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	rec = httptest.NewRecorder()
+	p.handleSavedFilters(rec, httptest.NewRequest(http.MethodDelete, "/requests/filters?name=prod+5xx", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	rec = httptest.NewRecorder()
+	p.handleSavedFilters(rec, httptest.NewRequest(http.MethodDelete, "/requests/filters?name=prod+5xx", nil))
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+}
@@ -0,0 +1,282 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// fixtureIndexFile lists the files of a fixture export
+const fixtureIndexFile = "index.json"
+
+// fixtureVolatileValue replaces header values that change between otherwise
+// identical exchanges, so the header's presence still shows up in diffs
+const fixtureVolatileValue = "<volatile>"
+
+// fixtureVolatileHeaders change on every exchange or carry credentials that
+// do not belong in a repository
+var fixtureVolatileHeaders = map[string]bool{
+	"Age":                 true,
+	"Cf-Ray":              true,
+	"Date":                true,
+	"Expires":             true,
+	"Last-Modified":       true,
+	"Server-Timing":       true,
+	"Set-Cookie":          true,
+	"Traceparent":         true,
+	"Tracestate":          true,
+	"X-Amzn-Trace-Id":     true,
+	"X-Correlation-Id":    true,
+	"X-Request-Id":        true,
+	"X-Runtime":           true,
+	"X-Trace-Id":          true,
+	"Authorization":       true,
+	"Cookie":              true,
+	"Proxy-Authorization": true,
+}
+
+// fixtureSkippedHeaders depend on how a message was sent rather than on what
+// it says, or were added by netkit
+var fixtureSkippedHeaders = map[string]bool{
+	"Connection":           true,
+	"Content-Length":       true,
+	"Keep-Alive":           true,
+	"Proxy-Connection":     true,
+	"Transfer-Encoding":    true,
+	"X-Netkit-Destination": true,
+	RequestOptionsHeader:   true,
+	RunHeader:              true,
+	ReplayHeader:           true,
+}
+
+var fixtureNameUnsafe = regexp.MustCompile(`[^a-z0-9]+`)
+
+// Fixture is one recorded exchange in the git-friendly export. IDs, times,
+// durations, and sizes are left out, volatile header values are normalized,
+// and bodies are stored in files of their own next to the fixture.
+type Fixture struct {
+	Method                string            `json:"method"`
+	URL                   string            `json:"url"`
+	RequestHeaders        map[string]string `json:"request_headers,omitempty"`
+	RequestBodyFile       string            `json:"request_body_file,omitempty"`
+	RequestBodyTruncated  bool              `json:"request_body_truncated,omitempty"`
+	Status                int               `json:"status"`
+	ResponseHeaders       map[string]string `json:"response_headers,omitempty"`
+	ResponseBodyFile      string            `json:"response_body_file,omitempty"`
+	ResponseBodyTruncated bool              `json:"response_body_truncated,omitempty"`
+	Error                 string            `json:"error,omitempty"`
+}
+
+// fixtureIndex lists the fixtures of an export and every file written for
+// them, so exporting into the same directory again removes stale files
+type fixtureIndex struct {
+	Fixtures []string `json:"fixtures"`
+	Files    []string `json:"files"`
+}
+
+// WriteFixtures exports records to dir in a form meant for version control:
+// one fixture file per record, named after its method and URL and sorted the
+// same way regardless of when the records were made, with JSON bodies
+// pretty-printed with sorted keys. Exporting the same exchanges again writes
+// identical files. Files of an earlier export that are no longer written are
+// removed. It returns the number of fixtures written.
+func WriteFixtures(dir string, records []RequestRecord) (int, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return 0, err
+	}
+	previous, err := readFixtureIndex(dir)
+	if err != nil {
+		return 0, err
+	}
+
+	type entry struct {
+		fixture      Fixture
+		requestBody  string
+		responseBody string
+		key          string
+	}
+	entries := make([]entry, 0, len(records))
+	for _, record := range records {
+		fixture := Fixture{
+			Method:                record.Method,
+			URL:                   record.URL,
+			RequestHeaders:        fixtureHeaders(record.RequestHeaders),
+			RequestBodyTruncated:  record.RequestBodyTruncated,
+			Status:                record.ResponseStatus,
+			ResponseHeaders:       fixtureHeaders(record.ResponseHeaders),
+			ResponseBodyTruncated: record.ResponseBodyTruncated,
+			Error:                 record.Error,
+		}
+		requestBody, responseBody := fixtureBody(record.RequestBody), fixtureBody(record.ResponseBody)
+		key := strings.Join([]string{fixture.URL, fixture.Method, requestBody, fmt.Sprintf("%03d", fixture.Status), responseBody}, "\x00")
+		entries = append(entries, entry{fixture, requestBody, responseBody, key})
+	}
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].key < entries[j].key })
+
+	index := fixtureIndex{Fixtures: []string{}, Files: []string{}}
+	used := make(map[string]int)
+	write := func(name string, data []byte) error {
+		index.Files = append(index.Files, name)
+		return os.WriteFile(filepath.Join(dir, name), data, 0644)
+	}
+	for _, e := range entries {
+		name := fixtureName(e.fixture.Method, e.fixture.URL)
+		if used[name]++; used[name] > 1 {
+			name = fmt.Sprintf("%s-%d", name, used[name])
+		}
+
+		if e.requestBody != "" {
+			e.fixture.RequestBodyFile = name + ".request" + fixtureBodyExtension(e.fixture.RequestHeaders, e.requestBody)
+			if err := write(e.fixture.RequestBodyFile, []byte(e.requestBody)); err != nil {
+				return 0, err
+			}
+		}
+		if e.responseBody != "" {
+			e.fixture.ResponseBodyFile = name + ".response" + fixtureBodyExtension(e.fixture.ResponseHeaders, e.responseBody)
+			if err := write(e.fixture.ResponseBodyFile, []byte(e.responseBody)); err != nil {
+				return 0, err
+			}
+		}
+		data, err := fixtureJSON(e.fixture)
+		if err != nil {
+			return 0, err
+		}
+		if err := write(name+".json", data); err != nil {
+			return 0, err
+		}
+		index.Fixtures = append(index.Fixtures, name)
+	}
+
+	// Remove what the previous export wrote and this one did not
+	written := make(map[string]bool, len(index.Files))
+	for _, name := range index.Files {
+		written[name] = true
+	}
+	for _, name := range previous.Files {
+		if written[name] || name != filepath.Base(name) {
+			continue
+		}
+		if err := os.Remove(filepath.Join(dir, name)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return 0, err
+		}
+	}
+
+	data, err := fixtureJSON(index)
+	if err != nil {
+		return 0, err
+	}
+	if err := os.WriteFile(filepath.Join(dir, fixtureIndexFile), data, 0644); err != nil {
+		return 0, err
+	}
+	return len(index.Fixtures), nil
+}
+
+// readFixtureIndex reads the index of an earlier export in dir, if any
+func readFixtureIndex(dir string) (fixtureIndex, error) {
+	var index fixtureIndex
+	data, err := os.ReadFile(filepath.Join(dir, fixtureIndexFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return index, nil
+	}
+	if err != nil {
+		return index, err
+	}
+	if err := json.Unmarshal(data, &index); err != nil {
+		return index, fmt.Errorf("invalid %s in %s: %v", fixtureIndexFile, dir, err)
+	}
+	return index, nil
+}
+
+// fixtureHeaders drops transport headers and normalizes volatile values.
+// Maps are written with sorted keys.
+func fixtureHeaders(headers map[string]string) map[string]string {
+	result := make(map[string]string, len(headers))
+	for name, value := range headers {
+		canonical := http.CanonicalHeaderKey(name)
+		switch {
+		case fixtureSkippedHeaders[canonical]:
+			continue
+		case fixtureVolatileHeaders[canonical]:
+			value = fixtureVolatileValue
+		}
+		result[canonical] = value
+	}
+	if len(result) == 0 {
+		return nil
+	}
+	return result
+}
+
+// fixtureBody pretty-prints JSON bodies with sorted keys so changes diff line
+// by line, and keeps other bodies as recorded
+func fixtureBody(body string) string {
+	trimmed := strings.TrimSpace(body)
+	if trimmed == "" || (trimmed[0] != '{' && trimmed[0] != '[') {
+		return body
+	}
+	var value interface{}
+	decoder := json.NewDecoder(strings.NewReader(trimmed))
+	decoder.UseNumber()
+	if err := decoder.Decode(&value); err != nil || decoder.More() {
+		return body
+	}
+	data, err := fixtureJSON(value)
+	if err != nil {
+		return body
+	}
+	return string(data)
+}
+
+// fixtureJSON encodes v indented, with sorted keys, unescaped HTML
+// characters, and a trailing newline
+func fixtureJSON(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// fixtureBodyExtension picks a file extension from the Content-Type header,
+// falling back to .json for JSON bodies and .txt otherwise
+func fixtureBodyExtension(headers map[string]string, body string) string {
+	mediaType, _, _ := mime.ParseMediaType(headers["Content-Type"])
+	switch {
+	case mediaType == "application/json" || strings.HasSuffix(mediaType, "+json"):
+		return ".json"
+	case mediaType == "application/xml" || mediaType == "text/xml" || strings.HasSuffix(mediaType, "+xml"):
+		return ".xml"
+	case mediaType == "text/html":
+		return ".html"
+	case mediaType == "" && json.Valid([]byte(body)):
+		return ".json"
+	case mediaType == "" || strings.HasPrefix(mediaType, "text/") || mediaType == "application/x-www-form-urlencoded":
+		return ".txt"
+	}
+	return ".bin"
+}
+
+// fixtureName is a file name made of the method and URL, e.g.
+// get-api-example-com-orders-42
+func fixtureName(method, rawURL string) string {
+	rawURL = strings.TrimPrefix(strings.TrimPrefix(rawURL, "https://"), "http://")
+	name := strings.Trim(fixtureNameUnsafe.ReplaceAllString(strings.ToLower(method+"-"+rawURL), "-"), "-")
+	if len(name) > 80 {
+		name = strings.TrimRight(name[:80], "-")
+	}
+	if name == "" {
+		name = "request"
+	}
+	return name
+}
@@ -0,0 +1,132 @@
+//go:build unit
+
+package proxy
+
+import (
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func fixtureRecords(id string, at time.Time) []RequestRecord {
+	return []RequestRecord{
+		{
+			ID:             id + "-1",
+			Timestamp:      at,
+			Method:         "POST",
+			URL:            "https://api.example.com/orders",
+			RequestHeaders: map[string]string{"Content-Type": "application/json", "Content-Length": "26", "Authorization": "Bearer " + id},
+			RequestBody:    `{"sku":"A1","quantity":2}`,
+			ResponseStatus: 201,
+			ResponseHeaders: map[string]string{
+				"Content-Type": "application/json",
+				"Date":         at.Format(time.RFC1123),
+				"X-Request-Id": id,
+			},
+			ResponseBody:    `{"status":"created","id":42}`,
+			TotalDurationUs: int64(len(id)) * 1000,
+		},
+		{
+			ID:              id + "-2",
+			Timestamp:       at.Add(time.Second),
+			Method:          "GET",
+			URL:             "https://api.example.com/orders/42",
+			ResponseStatus:  200,
+			ResponseHeaders: map[string]string{"Content-Type": "text/plain"},
+			ResponseBody:    "ok",
+		},
+	}
+}
+
+// readFixtureDir returns the contents of every file in dir by name
+func readFixtureDir(t *testing.T, dir string) map[string]string {
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	files := make(map[string]string, len(entries))
+	for _, entry := range entries {
+		data, err := os.ReadFile(filepath.Join(dir, entry.Name()))
+		require.NoError(t, err)
+		files[entry.Name()] = string(data)
+	}
+	return files
+}
+
+func TestWriteFixtures(t *testing.T) {
+	first, second := t.TempDir(), t.TempDir()
+
+	written, err := WriteFixtures(first, fixtureRecords("one", time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)))
+	require.NoError(t, err)
+	assert.Equal(t, 2, written)
+
+	// The same exchanges recorded later, with other IDs and in another order
+	records := fixtureRecords("another", time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC))
+	records[0], records[1] = records[1], records[0]
+	_, err = WriteFixtures(second, records)
+	require.NoError(t, err)
+
+	files := readFixtureDir(t, first)
+	assert.Equal(t, files, readFixtureDir(t, second))
+	assert.ElementsMatch(t, []string{
+		"index.json",
+		"get-api-example-com-orders-42.json",
+		"get-api-example-com-orders-42.response.txt",
+		"post-api-example-com-orders.json",
+		"post-api-example-com-orders.request.json",
+		"post-api-example-com-orders.response.json",
+	}, slices.Collect(maps.Keys(files)))
+
+	assert.Equal(t, `{
+  "method": "POST",
+  "url": "https://api.example.com/orders",
+  "request_headers": {
+    "Authorization": "<volatile>",
+    "Content-Type": "application/json"
+  },
+  "request_body_file": "post-api-example-com-orders.request.json",
+  "status": 201,
+  "response_headers": {
+    "Content-Type": "application/json",
+    "Date": "<volatile>",
+    "X-Request-Id": "<volatile>"
+  },
+  "response_body_file": "post-api-example-com-orders.response.json"
+}
+`, files["post-api-example-com-orders.json"])
+	assert.Equal(t, "{\n  \"id\": 42,\n  \"status\": \"created\"\n}\n", files["post-api-example-com-orders.response.json"])
+	assert.Equal(t, "ok", files["get-api-example-com-orders-42.response.txt"])
+}
+
+func TestWriteFixturesRemovesStaleFiles(t *testing.T) {
+	dir := t.TempDir()
+	records := fixtureRecords("one", time.Now())
+	_, err := WriteFixtures(dir, append(records, records[1]))
+	require.NoError(t, err)
+	assert.Contains(t, readFixtureDir(t, dir), "get-api-example-com-orders-42-2.json")
+
+	// Files netkit did not write are left alone
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "README.md"), []byte("fixtures"), 0644))
+
+	written, err := WriteFixtures(dir, records[:1])
+	require.NoError(t, err)
+	assert.Equal(t, 1, written)
+	assert.ElementsMatch(t, []string{
+		"README.md",
+		"index.json",
+		"post-api-example-com-orders.json",
+		"post-api-example-com-orders.request.json",
+		"post-api-example-com-orders.response.json",
+	}, slices.Collect(maps.Keys(readFixtureDir(t, dir))))
+}
+
+func TestFixtureName(t *testing.T) {
+	assert.Equal(t, "get-api-example-com-orders-id-42", fixtureName("GET", "https://api.example.com/orders?id=42"))
+	assert.Equal(t, "connect-example-com-443", fixtureName("CONNECT", "example.com:443"))
+	assert.Len(t, fixtureName("GET", "https://example.com/"+strings.Repeat("a", 200)), 80)
+}
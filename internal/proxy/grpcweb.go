@@ -0,0 +1,313 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// gRPC length-prefixed message flags
+const (
+	grpcFrameHeaderSize = 5
+	grpcFlagTrailer     = 0x80
+)
+
+// grpcWebHeaders are request headers that only make sense between the browser and
+// the gRPC-Web endpoint and are dropped when translating to native gRPC
+var grpcWebHeaders = map[string]bool{
+	"Content-Type":         true,
+	"Content-Length":       true,
+	"X-Grpc-Web":           true,
+	"X-User-Agent":         true,
+	"X-Netkit-Destination": true,
+	"Connection":           true,
+	"Keep-Alive":           true,
+	"Accept-Encoding":      true,
+}
+
+// newGRPCClient creates an HTTP client that speaks HTTP/2 to upstreams, using
+// h2c (prior knowledge) for http:// targets and ALPN for https:// targets.
+// Connections are opened with dialer.
+func newGRPCClient(dialer *upstreamDialer) *http.Client {
+	protocols := new(http.Protocols)
+	protocols.SetHTTP2(true)
+	protocols.SetUnencryptedHTTP2(true)
+
+	return &http.Client{
+		Transport: &http.Transport{
+			Protocols:         protocols,
+			ForceAttemptHTTP2: true,
+			DialContext:       dialer.DialContext,
+		},
+	}
+}
+
+// isGRPCWebRequest reports whether the request carries a gRPC-Web payload
+func isGRPCWebRequest(r *http.Request) bool {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return err == nil && r.Method == http.MethodPost && strings.HasPrefix(mediaType, "application/grpc-web")
+}
+
+// handleGRPCWeb translates a browser gRPC-Web call into a native gRPC call
+// toward the upstream and converts the response (including trailers) back
+func (p *Proxy) handleGRPCWeb(w http.ResponseWriter, r *http.Request) {
+	proxyStartTime := time.Now()
+
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	textMode := strings.HasPrefix(mediaType, "application/grpc-web-text")
+
+	record := RequestRecord{
+		ID:             p.newRequestID(),
+		Timestamp:      proxyStartTime,
+		Method:         r.Method,
+		URL:            r.URL.String(),
+		RequestHeaders: convertHeaders(r.Header),
+		ProxyStartTime: proxyStartTime,
+		Success:        false, // Will be updated based on outcome
+	}
+	record.GRPCService, record.GRPCMethod = parseGRPCPath(r.URL.Path)
+
+	fail := func(message string, status int) {
+		record.Error = message
+		record.ProxyEndTime = time.Now()
+		p.recordRequest(record)
+		http.Error(w, message, status)
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		fail("Failed to read gRPC-Web request body", http.StatusBadRequest)
+		return
+	}
+	if textMode {
+		if body, err = decodeGRPCWebText(body); err != nil {
+			fail("Invalid gRPC-Web text payload", http.StatusBadRequest)
+			return
+		}
+	}
+	record.RequestBody = string(body)
+	record.RequestSize = int64(len(body))
+
+	targetURL, err := p.grpcWebTarget(r)
+	if err != nil {
+		fail(fmt.Sprintf("Invalid gRPC-Web destination: %v", err), http.StatusBadRequest)
+		return
+	}
+	record.URL = targetURL.String()
+
+	proxyReq, err := http.NewRequestWithContext(r.Context(), http.MethodPost, targetURL.String(), bytes.NewReader(body))
+	if err != nil {
+		fail("Failed to create gRPC request", http.StatusInternalServerError)
+		return
+	}
+	for key, values := range r.Header {
+		if grpcWebHeaders[key] {
+			continue
+		}
+		for _, value := range values {
+			proxyReq.Header.Add(key, value)
+		}
+	}
+	proxyReq.Header.Set("Content-Type", grpcContentType(mediaType))
+	proxyReq.Header.Set("TE", "trailers")
+
+	record.UpstreamStartTime = time.Now()
+	resp, err := p.grpcClient.Do(proxyReq)
+	if err != nil {
+		record.UpstreamEndTime = time.Now()
+		fail("Failed to proxy gRPC request", http.StatusBadGateway)
+		return
+	}
+	defer func() {
+		if closeErr := resp.Body.Close(); closeErr != nil {
+			slog.Warn("Error closing gRPC response body", "error", closeErr)
+		}
+	}()
+
+	// Trailers are only populated once the body has been fully read
+	respBody, err := io.ReadAll(resp.Body)
+	record.UpstreamEndTime = time.Now()
+	if err != nil {
+		fail("Failed to read gRPC response body", http.StatusBadGateway)
+		return
+	}
+
+	// Trailers-only responses carry the status in the headers
+	trailers := resp.Trailer.Clone()
+	if trailers == nil {
+		trailers = http.Header{}
+	}
+	for _, key := range []string{"Grpc-Status", "Grpc-Message", "Grpc-Status-Details-Bin"} {
+		if value := resp.Header.Get(key); value != "" && trailers.Get(key) == "" {
+			trailers.Set(key, value)
+		}
+	}
+
+	record.GRPCStatus = trailers.Get("Grpc-Status")
+	record.GRPCMessage = trailers.Get("Grpc-Message")
+	record.ResponseStatus = resp.StatusCode
+	record.ResponseHeaders = convertHeaders(resp.Header)
+	record.ResponseBody = string(respBody)
+	record.ResponseSize = int64(len(respBody))
+	record.Success = true
+
+	p.decodeBodies(&record, targetURL)
+
+	// Build the gRPC-Web response: data frames followed by a trailer frame
+	payload := append(respBody, encodeGRPCWebTrailers(trailers)...)
+	contentType := "application/grpc-web+proto"
+	if textMode {
+		payload = []byte(base64.StdEncoding.EncodeToString(payload))
+		contentType = "application/grpc-web-text+proto"
+	}
+
+	for key, values := range resp.Header {
+		switch key {
+		case "Content-Type", "Content-Length", "Trailer", "Grpc-Status", "Grpc-Message", "Grpc-Status-Details-Bin":
+			continue
+		}
+		for _, value := range values {
+			w.Header().Add(key, value)
+		}
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Access-Control-Expose-Headers", "Grpc-Status, Grpc-Message, Grpc-Status-Details-Bin")
+
+	record.ProxyEndTime = time.Now()
+
+	w.WriteHeader(resp.StatusCode)
+	if _, err := w.Write(payload); err != nil {
+		slog.Warn("Error writing gRPC-Web response", "error", err)
+		record.Error = "Failed to copy response body"
+		record.Success = false
+	}
+
+	p.recordRequest(record)
+
+	slog.Debug("gRPC-Web request completed", "service", record.GRPCService, "method", record.GRPCMethod,
+		"grpc_status", record.GRPCStatus, "duration_us", elapsed(record.ProxyStartTime, record.ProxyEndTime).Microseconds())
+}
+
+// grpcWebTarget resolves the native gRPC upstream URL for a gRPC-Web request.
+// Browsers call the proxy directly, so origin-form requests go to GRPCWebUpstream.
+func (p *Proxy) grpcWebTarget(r *http.Request) (*url.URL, error) {
+	if destination := r.Header.Get("X-Netkit-Destination"); destination != "" {
+		target, err := url.Parse(destination)
+		if err != nil {
+			return nil, fmt.Errorf("invalid X-Netkit-Destination URL")
+		}
+		return target, nil
+	}
+	if r.URL.IsAbs() {
+		return r.URL, nil
+	}
+	if p.currentConfig().GRPCWebUpstream == "" {
+		return nil, fmt.Errorf("no gRPC upstream configured")
+	}
+
+	upstream, err := url.Parse(p.currentConfig().GRPCWebUpstream)
+	if err != nil {
+		return nil, fmt.Errorf("invalid gRPC upstream URL")
+	}
+	target := *upstream
+	target.Path = strings.TrimSuffix(upstream.Path, "/") + r.URL.Path
+	target.RawQuery = r.URL.RawQuery
+	return &target, nil
+}
+
+// parseGRPCPath splits "/package.Service/Method" into service and method
+func parseGRPCPath(path string) (string, string) {
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+	if len(parts) < 2 {
+		return "", ""
+	}
+	return parts[len(parts)-2], parts[len(parts)-1]
+}
+
+// grpcContentType maps a gRPC-Web content type to its native gRPC equivalent
+func grpcContentType(mediaType string) string {
+	suffix := ""
+	if i := strings.Index(mediaType, "+"); i >= 0 {
+		suffix = mediaType[i:]
+	}
+	return "application/grpc" + suffix
+}
+
+// decodeGRPCWebText decodes a base64 gRPC-Web text body. Clients may send
+// several independently padded base64 chunks back to back.
+func decodeGRPCWebText(body []byte) ([]byte, error) {
+	text := strings.Join(strings.Fields(string(body)), "")
+	var result []byte
+	for text != "" {
+		// Each padded chunk ends at the first quantum containing '='
+		end := len(text)
+		for i := 0; i+4 <= len(text); i += 4 {
+			if strings.Contains(text[i:i+4], "=") {
+				end = i + 4
+				break
+			}
+		}
+		chunk, err := base64.StdEncoding.DecodeString(text[:end])
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, chunk...)
+		text = text[end:]
+	}
+	return result, nil
+}
+
+// encodeGRPCWebTrailers encodes trailers as a gRPC-Web trailer frame
+func encodeGRPCWebTrailers(trailers http.Header) []byte {
+	keys := make([]string, 0, len(trailers))
+	for key := range trailers {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var block bytes.Buffer
+	for _, key := range keys {
+		for _, value := range trailers[key] {
+			block.WriteString(strings.ToLower(key) + ": " + value + "\r\n")
+		}
+	}
+
+	frame := make([]byte, grpcFrameHeaderSize, grpcFrameHeaderSize+block.Len())
+	frame[0] = grpcFlagTrailer
+	binary.BigEndian.PutUint32(frame[1:], uint32(block.Len()))
+	return append(frame, block.Bytes()...)
+}
+
+// splitGRPCFrames returns the message payloads of a length-prefixed gRPC body,
+// skipping gRPC-Web trailer frames
+func splitGRPCFrames(body []byte) ([][]byte, error) {
+	var messages [][]byte
+	for len(body) > 0 {
+		if len(body) < grpcFrameHeaderSize {
+			return nil, fmt.Errorf("truncated gRPC frame header")
+		}
+		flags := body[0]
+		length := binary.BigEndian.Uint32(body[1:grpcFrameHeaderSize])
+		if uint64(len(body)-grpcFrameHeaderSize) < uint64(length) {
+			return nil, fmt.Errorf("truncated gRPC frame")
+		}
+		end := grpcFrameHeaderSize + int(length)
+		if flags&grpcFlagTrailer == 0 {
+			if flags&1 != 0 {
+				return nil, fmt.Errorf("compressed gRPC messages are not supported")
+			}
+			messages = append(messages, body[grpcFrameHeaderSize:end])
+		}
+		body = body[end:]
+	}
+	return messages, nil
+}
@@ -0,0 +1,163 @@
+//go:build unit
+
+package proxy
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// grpcFrame wraps a message in the gRPC length-prefixed framing
+func grpcFrame(message []byte) []byte {
+	frame := make([]byte, grpcFrameHeaderSize, grpcFrameHeaderSize+len(message))
+	binary.BigEndian.PutUint32(frame[1:], uint32(len(message)))
+	return append(frame, message...)
+}
+
+// newGRPCUpstream starts an h2c server that echoes the request message back
+func newGRPCUpstream(t *testing.T) *httptest.Server {
+	upstream := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, 2, r.ProtoMajor, "upstream must be called over HTTP/2")
+		assert.True(t, strings.HasPrefix(r.Header.Get("Content-Type"), "application/grpc"))
+		assert.NotContains(t, r.Header.Get("Content-Type"), "grpc-web")
+		assert.Equal(t, "trailers", r.Header.Get("TE"))
+		assert.Empty(t, r.Header.Get("X-Grpc-Web"))
+		assert.Equal(t, "/greeter.Greeter/SayHello", r.URL.Path)
+
+		body, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+
+		w.Header().Set("Content-Type", "application/grpc+proto")
+		w.Header().Set("Trailer", "Grpc-Status, Grpc-Message")
+		w.WriteHeader(http.StatusOK)
+		if _, err := w.Write(body); err != nil {
+			t.Logf("Error writing response: %v", err)
+		}
+		w.Header().Set("Grpc-Status", "0")
+		w.Header().Set("Grpc-Message", "")
+	}))
+	upstream.Config.Protocols = new(http.Protocols)
+	upstream.Config.Protocols.SetUnencryptedHTTP2(true)
+	upstream.Start()
+	return upstream
+}
+
+func TestGRPCWebTranslation(t *testing.T) {
+	upstream := newGRPCUpstream(t)
+	defer upstream.Close()
+
+	proxy := New(&Config{Port: 8080, GRPCWeb: true, GRPCWebUpstream: upstream.URL})
+
+	message := []byte{0x0a, 0x03, 'A', 'd', 'a'}
+	req := httptest.NewRequest(http.MethodPost, "/greeter.Greeter/SayHello", bytes.NewReader(grpcFrame(message)))
+	req.Header.Set("Content-Type", "application/grpc-web+proto")
+	req.Header.Set("X-Grpc-Web", "1")
+	rec := httptest.NewRecorder()
+	proxy.ServeHTTP(rec, req)
+
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "application/grpc-web+proto", rec.Header().Get("Content-Type"))
+
+	// Response is the echoed data frame followed by a trailer frame
+	body := rec.Body.Bytes()
+	frames, err := splitGRPCFrames(body)
+	require.NoError(t, err)
+	require.Len(t, frames, 1)
+	assert.Equal(t, message, frames[0])
+
+	trailer := body[len(grpcFrame(message)):]
+	require.NotEmpty(t, trailer)
+	assert.Equal(t, byte(grpcFlagTrailer), trailer[0])
+	assert.Contains(t, string(trailer[grpcFrameHeaderSize:]), "grpc-status: 0\r\n")
+
+	records := proxy.history.GetRecords()
+	require.Len(t, records, 1)
+	assert.Equal(t, "greeter.Greeter", records[0].GRPCService)
+	assert.Equal(t, "SayHello", records[0].GRPCMethod)
+	assert.Equal(t, "0", records[0].GRPCStatus)
+	assert.Equal(t, upstream.URL+"/greeter.Greeter/SayHello", records[0].URL)
+	assert.True(t, records[0].Success)
+}
+
+func TestGRPCWebTextWithSchema(t *testing.T) {
+	upstream := newGRPCUpstream(t)
+	defer upstream.Close()
+
+	file := filepath.Join(t.TempDir(), "greeter.proto")
+	require.NoError(t, os.WriteFile(file, []byte(`
+syntax = "proto3";
+package greeter;
+message HelloRequest { string name = 1; }
+`), 0o600))
+	s, err := ParseBodySchema("/greeter.Greeter=" + file + ":HelloRequest")
+	require.NoError(t, err)
+
+	proxy := New(&Config{Port: 8080, GRPCWeb: true, GRPCWebUpstream: upstream.URL, BodySchemas: []BodySchema{s}})
+
+	message := []byte{0x0a, 0x03, 'A', 'd', 'a'}
+	encoded := base64.StdEncoding.EncodeToString(grpcFrame(message))
+	req := httptest.NewRequest(http.MethodPost, "/greeter.Greeter/SayHello", strings.NewReader(encoded))
+	req.Header.Set("Content-Type", "application/grpc-web-text")
+	rec := httptest.NewRecorder()
+	proxy.ServeHTTP(rec, req)
+
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "application/grpc-web-text+proto", rec.Header().Get("Content-Type"))
+
+	decoded, err := base64.StdEncoding.DecodeString(rec.Body.String())
+	require.NoError(t, err)
+	frames, err := splitGRPCFrames(decoded)
+	require.NoError(t, err)
+	require.Len(t, frames, 1)
+	assert.Equal(t, message, frames[0])
+
+	records := proxy.history.GetRecords()
+	require.Len(t, records, 1)
+	assert.JSONEq(t, `[{"name":"Ada"}]`, string(records[0].RequestBodyDecoded))
+	assert.JSONEq(t, `[{"name":"Ada"}]`, string(records[0].ResponseBodyDecoded))
+}
+
+func TestGRPCWebWithoutUpstream(t *testing.T) {
+	proxy := New(&Config{Port: 8080, GRPCWeb: true})
+
+	req := httptest.NewRequest(http.MethodPost, "/greeter.Greeter/SayHello", bytes.NewReader(grpcFrame(nil)))
+	req.Header.Set("Content-Type", "application/grpc-web")
+	rec := httptest.NewRecorder()
+	proxy.ServeHTTP(rec, req)
+
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	records := proxy.history.GetRecords()
+	require.Len(t, records, 1)
+	assert.False(t, records[0].Success)
+}
+
+func TestDecodeGRPCWebText(t *testing.T) {
+	// Clients may concatenate independently padded chunks
+	chunked := base64.StdEncoding.EncodeToString([]byte("a")) + base64.StdEncoding.EncodeToString([]byte("bc"))
+	decoded, err := decodeGRPCWebText([]byte(chunked))
+	require.NoError(t, err)
+	assert.Equal(t, "abc", string(decoded))
+
+	_, err = decodeGRPCWebText([]byte("!!!"))
+	assert.Error(t, err)
+}
+
+func TestSplitGRPCFramesErrors(t *testing.T) {
+	_, err := splitGRPCFrames([]byte{0, 0, 0})
+	assert.Error(t, err)
+	_, err = splitGRPCFrames([]byte{0, 0, 0, 0, 5, 1})
+	assert.Error(t, err)
+	_, err = splitGRPCFrames([]byte{1, 0, 0, 0, 0})
+	assert.Error(t, err, "compressed frames are rejected")
+}
@@ -0,0 +1,351 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"time"
+	"unicode/utf8"
+)
+
+// harVersion is the HAR specification version written
+const harVersion = "1.2"
+
+// harCreatorVersion fills the creator version HAR requires; netkit builds
+// carry no version of their own
+const harCreatorVersion = "dev"
+
+// HAR 1.2 document, with the fields browser devtools read
+type (
+	harDocument struct {
+		Log harLog `json:"log"`
+	}
+	harLog struct {
+		Version string     `json:"version"`
+		Creator harCreator `json:"creator"`
+		Entries []harEntry `json:"entries"`
+	}
+	harCreator struct {
+		Name    string `json:"name"`
+		Version string `json:"version"`
+	}
+	harEntry struct {
+		StartedDateTime string      `json:"startedDateTime"`
+		Time            float64     `json:"time"` // Sum of the timings that apply, in milliseconds
+		Request         harRequest  `json:"request"`
+		Response        harResponse `json:"response"`
+		Cache           struct{}    `json:"cache"`
+		Timings         harTimings  `json:"timings"`
+		ID              string      `json:"_id,omitempty"`
+	}
+	harRequest struct {
+		Method      string         `json:"method"`
+		URL         string         `json:"url"`
+		HTTPVersion string         `json:"httpVersion"`
+		Cookies     []harNameValue `json:"cookies"`
+		Headers     []harNameValue `json:"headers"`
+		QueryString []harNameValue `json:"queryString"`
+		PostData    *harPostData   `json:"postData,omitempty"`
+		HeadersSize int64          `json:"headersSize"`
+		BodySize    int64          `json:"bodySize"`
+	}
+	harResponse struct {
+		Status      int            `json:"status"`
+		StatusText  string         `json:"statusText"`
+		HTTPVersion string         `json:"httpVersion"`
+		Cookies     []harNameValue `json:"cookies"`
+		Headers     []harNameValue `json:"headers"`
+		Content     harContent     `json:"content"`
+		RedirectURL string         `json:"redirectURL"`
+		HeadersSize int64          `json:"headersSize"`
+		BodySize    int64          `json:"bodySize"`
+		Error       string         `json:"_error,omitempty"`
+	}
+	harNameValue struct {
+		Name  string `json:"name"`
+		Value string `json:"value"`
+	}
+	harPostData struct {
+		MimeType string `json:"mimeType"`
+		Text     string `json:"text"`
+	}
+	harContent struct {
+		Size     int64  `json:"size"`
+		MimeType string `json:"mimeType"`
+		Text     string `json:"text,omitempty"`
+		Encoding string `json:"encoding,omitempty"`
+	}
+	// harTimings are in milliseconds; -1 marks a phase that does not apply
+	harTimings struct {
+		Blocked float64 `json:"blocked"`
+		DNS     float64 `json:"dns"`
+		Connect float64 `json:"connect"` // Includes SSL
+		Send    float64 `json:"send"`
+		Wait    float64 `json:"wait"`
+		Receive float64 `json:"receive"`
+		SSL     float64 `json:"ssl"`
+	}
+)
+
+// WriteHAR writes records as a HAR 1.2 log, oldest first, that browser
+// devtools can import. Phase timings of the upstream exchange become the
+// entry's timings, so the waterfall shows DNS, connect, TLS, send, wait, and
+// receive; records captured without them show their upstream latency as
+// wait.
+func WriteHAR(w io.Writer, records []RequestRecord) error {
+	ordered := append([]RequestRecord(nil), records...)
+	sort.SliceStable(ordered, func(i, j int) bool { return ordered[i].Timestamp.Before(ordered[j].Timestamp) })
+
+	document := harDocument{Log: harLog{
+		Version: harVersion,
+		Creator: harCreator{Name: "netkit", Version: harCreatorVersion},
+		Entries: make([]harEntry, 0, len(ordered)),
+	}}
+	for _, record := range ordered {
+		document.Log.Entries = append(document.Log.Entries, harRecordEntry(record))
+	}
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(document)
+}
+
+// harRecordEntry converts a record to a HAR entry
+func harRecordEntry(record RequestRecord) harEntry {
+	started := record.UpstreamStartTime
+	if started.IsZero() {
+		started = record.Timestamp
+	}
+	timings := harPhaseTimings(record)
+
+	entry := harEntry{
+		StartedDateTime: started.UTC().Format("2006-01-02T15:04:05.000Z07:00"),
+		Time:            harTotal(timings),
+		Request: harRequest{
+			Method:      record.Method,
+			URL:         record.URL,
+			HTTPVersion: "HTTP/1.1",
+			Cookies:     []harNameValue{},
+			Headers:     harHeaders(record.RequestHeaders),
+			QueryString: harQuery(record.URL),
+			HeadersSize: -1,
+			BodySize:    record.RequestSize,
+		},
+		Response: harResponse{
+			Status:      record.ResponseStatus,
+			StatusText:  http.StatusText(record.ResponseStatus),
+			HTTPVersion: "HTTP/1.1",
+			Cookies:     []harNameValue{},
+			Headers:     harHeaders(record.ResponseHeaders),
+			Content:     harBody(record.ResponseBody, record.ResponseSize, record.ResponseHeaders["Content-Type"]),
+			RedirectURL: record.ResponseHeaders["Location"],
+			HeadersSize: -1,
+			BodySize:    record.ResponseSize,
+			Error:       record.Error,
+		},
+		Timings: timings,
+		ID:      record.ID,
+	}
+	if record.RequestBody != "" {
+		entry.Request.PostData = &harPostData{MimeType: record.RequestHeaders["Content-Type"], Text: record.RequestBody}
+	}
+	return entry
+}
+
+// harPhaseTimings maps a record's phase timings onto HAR timings
+func harPhaseTimings(record RequestRecord) harTimings {
+	phases := record.Phases
+	if phases == nil {
+		wait := record.UpstreamLatencyUs
+		if wait <= 0 {
+			wait = record.TotalDurationUs
+		}
+		return harTimings{Blocked: -1, DNS: -1, Connect: -1, Send: 0, Wait: harMillis(wait), Receive: 0, SSL: -1}
+	}
+	return harTimings{
+		Blocked: harMillis(phases.BlockedUs),
+		DNS:     harMillis(phases.DNSUs),
+		Connect: harMillis(phases.ConnectUs),
+		Send:    harMillis(phases.SendUs),
+		Wait:    harMillis(phases.WaitUs),
+		Receive: harMillis(phases.ReceiveUs),
+		SSL:     harMillis(phases.TLSUs),
+	}
+}
+
+// harMillis converts microseconds to milliseconds, keeping -1 for phases that
+// do not apply
+func harMillis(us int64) float64 {
+	if us < 0 {
+		return -1
+	}
+	return float64(us) / float64(time.Millisecond/time.Microsecond)
+}
+
+// harTotal sums the timings that apply; SSL is already part of connect
+func harTotal(t harTimings) float64 {
+	total := 0.0
+	for _, phase := range []float64{t.Blocked, t.DNS, t.Connect, t.Send, t.Wait, t.Receive} {
+		if phase > 0 {
+			total += phase
+		}
+	}
+	return total
+}
+
+// harHeaders lists headers sorted by name
+func harHeaders(headers map[string]string) []harNameValue {
+	fields := make([]harNameValue, 0, len(headers))
+	for name, value := range headers {
+		fields = append(fields, harNameValue{Name: name, Value: value})
+	}
+	sort.Slice(fields, func(i, j int) bool { return fields[i].Name < fields[j].Name })
+	return fields
+}
+
+// harQuery lists the query parameters of a URL in order
+func harQuery(rawURL string) []harNameValue {
+	params := []harNameValue{}
+	parsed, err := url.Parse(rawURL)
+	if err != nil {
+		return params
+	}
+	query := parsed.Query()
+	names := make([]string, 0, len(query))
+	for name := range query {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		for _, value := range query[name] {
+			params = append(params, harNameValue{Name: name, Value: value})
+		}
+	}
+	return params
+}
+
+// harBody describes a response body; bodies that are not UTF-8 text are
+// base64-encoded
+func harBody(body string, size int64, contentType string) harContent {
+	if contentType == "" {
+		contentType = "x-unknown"
+	}
+	content := harContent{Size: size, MimeType: contentType, Text: body}
+	if !utf8.ValidString(body) {
+		content.Text, content.Encoding = base64.StdEncoding.EncodeToString([]byte(body)), "base64"
+	}
+	return content
+}
+
+// handleRequestExport serves GET /requests/export: the records matching GET
+// /requests filters as a HAR file to open in browser devtools (format=har)
+// or streamed one JSON object per line (format=ndjson)
+func (p *Proxy) handleRequestExport(w http.ResponseWriter, r *http.Request) {
+	// Add CORS headers
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, Cache-Control, Pragma, Expires")
+
+	// Handle preflight requests
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	format := r.URL.Query().Get("format")
+	if format != "" && format != "har" && format != "ndjson" {
+		http.Error(w, "Invalid format: expected har or ndjson", http.StatusBadRequest)
+		return
+	}
+	filter, err := p.historyFilter(r.URL.Query())
+	if err != nil {
+		http.Error(w, "Invalid filter: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if format == "ndjson" {
+		p.streamRequestExport(w, r, filter)
+		return
+	}
+
+	records, err := p.queryHistory(filter)
+	if err != nil {
+		slog.Error("Error querying history backend", "error", err)
+		http.Error(w, "Failed to export request history", http.StatusInternalServerError)
+		return
+	}
+	var buf bytes.Buffer
+	if err := WriteHAR(&buf, records); err != nil {
+		http.Error(w, "Failed to export request history", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Disposition", `attachment; filename="netkit-`+time.Now().UTC().Format("20060102T150405Z")+`.har"`)
+	w.WriteHeader(http.StatusOK)
+	if _, err := w.Write(buf.Bytes()); err != nil {
+		slog.Warn("Error writing request export response", "error", err)
+	}
+}
+
+// ndjsonFlushInterval is how many NDJSON records are written between flushes
+const ndjsonFlushInterval = 64
+
+// streamRequestExport writes the records matching filter, most recent first,
+// as one JSON object per line. Records are encoded as the client reads them,
+// so a slow reader holds back the export instead of it piling up in memory.
+func (p *Proxy) streamRequestExport(w http.ResponseWriter, r *http.Request, filter *RequestFilter) {
+	summary, _ := strconv.ParseBool(r.URL.Query().Get("summary"))
+	controller := http.NewResponseController(w)
+	encoder := json.NewEncoder(w)
+
+	begin := func() {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		w.Header().Set("Content-Disposition", `attachment; filename="netkit-`+time.Now().UTC().Format("20060102T150405Z")+`.ndjson"`)
+		w.WriteHeader(http.StatusOK)
+	}
+	written := 0
+	err := p.eachHistory(filter, func(record RequestRecord) error {
+		if err := r.Context().Err(); err != nil {
+			return err
+		}
+		if written == 0 {
+			begin()
+		}
+		if summary {
+			record.summarize()
+		}
+		if err := encoder.Encode(record); err != nil {
+			return err
+		}
+		written++
+		if written%ndjsonFlushInterval == 0 {
+			return controller.Flush()
+		}
+		return nil
+	})
+	switch {
+	case err != nil && written == 0:
+		slog.Error("Error querying history backend", "error", err)
+		http.Error(w, "Failed to export request history", http.StatusInternalServerError)
+	case err != nil:
+		// The status has been sent, so the client sees a cut-off stream
+		slog.Warn("Error writing request export response", "error", err)
+	case written == 0:
+		begin()
+	default:
+		if err := controller.Flush(); err != nil {
+			slog.Warn("Error writing request export response", "error", err)
+		}
+	}
+}
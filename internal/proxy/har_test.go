@@ -0,0 +1,175 @@
+//go:build unit
+
+package proxy
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteHAR(t *testing.T) {
+	start := time.Date(2025, 3, 1, 10, 0, 0, 0, time.UTC)
+	records := []RequestRecord{
+		{
+			ID:                "second",
+			Timestamp:         start.Add(time.Second),
+			Method:            "GET",
+			URL:               "http://localhost:8080/health",
+			ResponseStatus:    200,
+			ResponseHeaders:   map[string]string{},
+			ResponseBody:      "\xff\x00",
+			ResponseSize:      2,
+			UpstreamLatencyUs: 1500,
+		},
+		{
+			ID:                "first",
+			Timestamp:         start,
+			Method:            "POST",
+			URL:               "https://api.example.com/orders?b=2&a=1&a=0",
+			RequestHeaders:    map[string]string{"Content-Type": "application/json", "Accept": "*/*"},
+			RequestBody:       `{"sku":"A1"}`,
+			RequestSize:       12,
+			ResponseStatus:    302,
+			ResponseHeaders:   map[string]string{"Location": "/orders/42", "Content-Type": "text/plain"},
+			ResponseBody:      "found",
+			ResponseSize:      5,
+			UpstreamStartTime: start.Add(250 * time.Microsecond),
+			Phases: &PhaseTimings{
+				BlockedUs: 100, DNSUs: 2000, ConnectUs: 9000, TLSUs: 6000,
+				SendUs: 50, WaitUs: 40000, ReceiveUs: 1250,
+			},
+		},
+	}
+
+	var buf bytes.Buffer
+	require.NoError(t, WriteHAR(&buf, records))
+
+	var har struct {
+		Log struct {
+			Version string
+			Entries []map[string]any
+		}
+	}
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &har))
+	assert.Equal(t, "1.2", har.Log.Version)
+	require.Len(t, har.Log.Entries, 2)
+
+	first := har.Log.Entries[0]
+	assert.Equal(t, "first", first["_id"])
+	assert.Equal(t, "2025-03-01T10:00:00.000Z", first["startedDateTime"])
+	assert.Equal(t, map[string]any{
+		"blocked": 0.1, "dns": 2.0, "connect": 9.0, "ssl": 6.0, "send": 0.05, "wait": 40.0, "receive": 1.25,
+	}, first["timings"])
+	assert.InDelta(t, 52.4, first["time"], 1e-9, "ssl is counted once, as part of connect")
+
+	request := first["request"].(map[string]any)
+	assert.Equal(t, []any{
+		map[string]any{"name": "Accept", "value": "*/*"},
+		map[string]any{"name": "Content-Type", "value": "application/json"},
+	}, request["headers"])
+	assert.Equal(t, []any{
+		map[string]any{"name": "a", "value": "1"},
+		map[string]any{"name": "a", "value": "0"},
+		map[string]any{"name": "b", "value": "2"},
+	}, request["queryString"])
+	assert.Equal(t, map[string]any{"mimeType": "application/json", "text": `{"sku":"A1"}`}, request["postData"])
+
+	response := first["response"].(map[string]any)
+	assert.Equal(t, "Found", response["statusText"])
+	assert.Equal(t, "/orders/42", response["redirectURL"])
+	assert.Equal(t, map[string]any{"size": 5.0, "mimeType": "text/plain", "text": "found"}, response["content"])
+
+	// Without phase timings, the upstream latency is shown as waiting
+	second := har.Log.Entries[1]
+	assert.Equal(t, map[string]any{
+		"blocked": -1.0, "dns": -1.0, "connect": -1.0, "ssl": -1.0, "send": 0.0, "wait": 1.5, "receive": 0.0,
+	}, second["timings"])
+	assert.Equal(t, map[string]any{"size": 2.0, "mimeType": "x-unknown", "text": "/wA=", "encoding": "base64"},
+		second["response"].(map[string]any)["content"])
+}
+
+func TestHandleRequestExport(t *testing.T) {
+	p := New(&Config{})
+	now := time.Now()
+	p.history.AddRecord(RequestRecord{ID: "get", Timestamp: now.Add(-time.Second), Method: "GET", URL: "http://api.example.com/users", ResponseStatus: 200})
+	p.history.AddRecord(RequestRecord{ID: "post", Timestamp: now, Method: "POST", URL: "http://api.example.com/users", ResponseStatus: 201,
+		RequestHeaders: map[string]string{"Content-Type": "application/json"}, RequestBody: `{"name":"ada"}`})
+
+	export := func(query string) (*harDocument, *httptest.ResponseRecorder) {
+		rec := httptest.NewRecorder()
+		p.handleRequestExport(rec, httptest.NewRequest(http.MethodGet, "/requests/export?"+query, nil))
+		if rec.Code != http.StatusOK {
+			return nil, rec
+		}
+		var document harDocument
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &document))
+		return &document, rec
+	}
+
+	document, rec := export("format=har")
+	require.NotNil(t, document)
+	assert.Contains(t, rec.Header().Get("Content-Disposition"), ".har")
+	assert.Equal(t, "1.2", document.Log.Version)
+	require.Len(t, document.Log.Entries, 2)
+	assert.Equal(t, "get", document.Log.Entries[0].ID, "oldest first")
+	require.NotNil(t, document.Log.Entries[1].Request.PostData)
+	assert.Equal(t, "application/json", document.Log.Entries[1].Request.PostData.MimeType)
+
+	// GET /requests filters apply
+	document, _ = export("format=har&method=POST")
+	require.NotNil(t, document)
+	require.Len(t, document.Log.Entries, 1)
+	assert.Equal(t, "post", document.Log.Entries[0].ID)
+
+	_, rec = export("format=csv")
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+}
+
+func TestHandleRequestExportNDJSON(t *testing.T) {
+	p := New(&Config{})
+	now := time.Now()
+	p.history.AddRecord(RequestRecord{ID: "get", Timestamp: now.Add(-time.Second), Method: "GET", URL: "http://api.example.com/users", ResponseStatus: 200})
+	p.history.AddRecord(RequestRecord{ID: "post", Timestamp: now, Method: "POST", URL: "http://api.example.com/users", ResponseStatus: 201,
+		RequestBody: `{"name":"ada"}`})
+
+	export := func(query string) ([]RequestRecord, *httptest.ResponseRecorder) {
+		rec := httptest.NewRecorder()
+		p.handleRequestExport(rec, httptest.NewRequest(http.MethodGet, "/requests/export?"+query, nil))
+		var records []RequestRecord
+		scanner := bufio.NewScanner(rec.Body)
+		for scanner.Scan() {
+			var record RequestRecord
+			require.NoError(t, json.Unmarshal(scanner.Bytes(), &record), "every line is a JSON object")
+			records = append(records, record)
+		}
+		return records, rec
+	}
+
+	records, rec := export("format=ndjson")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "application/x-ndjson", rec.Header().Get("Content-Type"))
+	assert.Contains(t, rec.Header().Get("Content-Disposition"), ".ndjson")
+	require.Len(t, records, 2)
+	assert.Equal(t, "post", records[0].ID, "most recent first")
+	assert.Equal(t, `{"name":"ada"}`, records[0].RequestBody)
+
+	records, _ = export("format=ndjson&method=GET")
+	require.Len(t, records, 1)
+	assert.Equal(t, "get", records[0].ID)
+
+	records, _ = export("format=ndjson&summary=true")
+	require.Len(t, records, 2)
+	assert.Empty(t, records[0].RequestBody, "summaries leave out bodies")
+
+	records, rec = export("format=ndjson&method=DELETE")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Empty(t, records)
+}
@@ -0,0 +1,109 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// headerCaseHeader carries the header names of a request as the client sent
+// them, added by the proxy port's connections when header case is preserved
+const headerCaseHeader = "X-Netkit-Header-Case"
+
+// headerCaseKeepCanonical are headers Go's HTTP client writes or inspects by
+// their canonical name, so renaming them would drop or duplicate them
+var headerCaseKeepCanonical = map[string]bool{
+	"Host":              true,
+	"Content-Length":    true,
+	"Transfer-Encoding": true,
+	"Trailer":           true,
+	"Connection":        true,
+	"Accept-Encoding":   true,
+	"Range":             true,
+}
+
+// withHeaderCase adds a header listing the names of a request head's headers
+// as sent, the first casing of each name in order
+func withHeaderCase(head []byte) []byte {
+	lines := bytes.Split(bytes.TrimSuffix(head, []byte("\r\n\r\n")), []byte("\r\n"))
+	if len(lines) < 2 {
+		return head
+	}
+	var names []string
+	seen := make(map[string]bool)
+	for _, line := range lines[1:] {
+		name, _, ok := bytes.Cut(line, []byte(":"))
+		if !ok {
+			continue
+		}
+		canonical := http.CanonicalHeaderKey(string(name))
+		if seen[canonical] || canonical == headerCaseHeader {
+			continue
+		}
+		seen[canonical] = true
+		names = append(names, string(name))
+	}
+
+	out := make([]byte, 0, len(head)+len(headerCaseHeader)+64)
+	out = append(out, head[:len(head)-2]...)
+	out = append(out, headerCaseHeader+": "+strings.Join(names, ",")+"\r\n\r\n"...)
+	return out
+}
+
+// headerNamesKey is the context key of a request's header names as sent
+type headerNamesKey struct{}
+
+// takeHeaderNames removes the header names the connection added to a request
+// and keeps them in its context. The last value is the connection's; earlier
+// ones were sent by the client.
+func (p *Proxy) takeHeaderNames(r *http.Request) *http.Request {
+	if !p.headerCase {
+		return r
+	}
+	values := r.Header.Values(headerCaseHeader)
+	r.Header.Del(headerCaseHeader)
+	if len(values) == 0 {
+		return r
+	}
+	names := strings.Split(values[len(values)-1], ",")
+	return r.WithContext(context.WithValue(r.Context(), headerNamesKey{}, names))
+}
+
+// headerNamesFrom returns the header names a request was sent with, or nil
+// when they were not noted
+func headerNamesFrom(ctx context.Context) []string {
+	names, _ := ctx.Value(headerNamesKey{}).([]string)
+	return names
+}
+
+// preservesHeaderCase reports whether requests to the URL are sent with the
+// client's header casing
+func (p *Proxy) preservesHeaderCase(u *url.URL) bool {
+	for _, route := range p.currentConfig().HeaderCaseRoutes {
+		if route.Matches(u) {
+			return true
+		}
+	}
+	return false
+}
+
+// applyHeaderCase renames the headers of an upstream request to the casing
+// they were sent with. User-Agent is kept with an empty canonical value so
+// Go's client does not add its own next to the renamed one.
+func applyHeaderCase(header http.Header, names []string) {
+	for _, name := range names {
+		canonical := http.CanonicalHeaderKey(name)
+		values, ok := header[canonical]
+		if name == canonical || !ok || headerCaseKeepCanonical[canonical] {
+			continue
+		}
+		if canonical == "User-Agent" {
+			header[canonical] = []string{""}
+		} else {
+			delete(header, canonical)
+		}
+		header[name] = values
+	}
+}
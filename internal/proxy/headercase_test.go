@@ -0,0 +1,97 @@
+//go:build unit
+
+package proxy
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// startHeadUpstream answers every request with 200, sending the request
+// heads it received, exactly as written, to the returned channel
+func startHeadUpstream(t *testing.T) (string, <-chan string) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = ln.Close() })
+	heads := make(chan string, 10)
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			reader := bufio.NewReader(conn)
+			var head strings.Builder
+			for {
+				line, err := reader.ReadString('\n')
+				head.WriteString(line)
+				if err != nil || line == "\r\n" {
+					break
+				}
+			}
+			heads <- head.String()
+			_, _ = io.WriteString(conn, "HTTP/1.1 200 OK\r\nContent-Length: 2\r\nConnection: close\r\n\r\nok")
+			_ = conn.Close()
+		}
+	}()
+	return ln.Addr().String(), heads
+}
+
+func TestApplyHeaderCase(t *testing.T) {
+	header := http.Header{
+		"X-Api-Key":      {"k"},
+		"User-Agent":     {"legacy/1.0"},
+		"Content-Length": {"5"},
+		"Accept":         {"*/*"},
+	}
+	applyHeaderCase(header, []string{"x-api-KEY", "user-agent", "content-length", "Accept", "x-removed"})
+	assert.Equal(t, http.Header{
+		"x-api-KEY":      {"k"},
+		"User-Agent":     {""},
+		"user-agent":     {"legacy/1.0"},
+		"Content-Length": {"5"},
+		"Accept":         {"*/*"},
+	}, header)
+}
+
+func TestProxyPreservesHeaderCase(t *testing.T) {
+	upstream, heads := startHeadUpstream(t)
+	p := New(&Config{HeaderCaseRoutes: []Route{ParseRoute(upstream + "/legacy")}})
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	server := &http.Server{Handler: p}
+	go func() { _ = server.Serve(&strictListener{Listener: ln, headerCase: true}) }()
+	t.Cleanup(func() { _ = server.Close() })
+
+	send := func(path string) string {
+		raw := "GET http://" + upstream + path + " HTTP/1.1\r\nHost: " + upstream + "\r\nx-api-KEY: k\r\nuser-agent: legacy/1.0\r\n" +
+			headerCaseHeader + ": Spoofed\r\nConnection: close\r\n\r\n"
+		responses := sendRaw(t, ln.Addr().String(), raw, 1)
+		require.Equal(t, http.StatusOK, responses[0].StatusCode)
+		return <-heads
+	}
+
+	head := send("/legacy/orders")
+	assert.Contains(t, head, "\r\nx-api-KEY: k\r\n")
+	assert.Contains(t, head, "\r\nuser-agent: legacy/1.0\r\n")
+	assert.NotContains(t, head, "Go-http-client", "Go does not add a second User-Agent")
+	assert.NotContains(t, head, headerCaseHeader)
+
+	record := p.history.GetRecords()[0]
+	assert.Equal(t, []string{"Host", "x-api-KEY", "user-agent", "Connection"}, record.RequestHeaderNames)
+	assert.Equal(t, "k", record.RequestHeaders["X-Api-Key"])
+	assert.NotContains(t, record.RequestHeaders, headerCaseHeader)
+
+	// Other routes get Go's canonical form
+	head = send("/modern")
+	assert.Contains(t, head, "\r\nX-Api-Key: k\r\n")
+	assert.Nil(t, p.history.GetRecords()[0].RequestHeaderNames)
+}
@@ -0,0 +1,197 @@
+package proxy
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Heatmap granularity and retention
+const (
+	heatmapResolution = time.Minute
+	heatmapRetention  = 24 * time.Hour
+)
+
+// heatmapResolutions are the default column sizes, finest first
+var heatmapResolutions = []time.Duration{time.Minute, 5 * time.Minute, 15 * time.Minute, 30 * time.Minute, time.Hour}
+
+// heatmapBoundsUs are the upper bounds of the latency rows in microseconds;
+// a final row counts everything slower than the last bound
+var heatmapBoundsUs = []int64{
+	1000, 2500, 5000, 10000, 25000, 50000, 100000, 250000, 500000,
+	1000000, 2500000, 5000000, 10000000,
+}
+
+// HeatmapColumn is the latency distribution of the requests in one time slice
+type HeatmapColumn struct {
+	Start  time.Time `json:"start"`
+	Total  int       `json:"total"`
+	Errors int       `json:"errors"`
+	Counts []int     `json:"counts"` // One per latency row, fastest first
+}
+
+// LatencyHeatmap is latency-vs-time data for rendering a heatmap
+type LatencyHeatmap struct {
+	Start           time.Time       `json:"start"`
+	End             time.Time       `json:"end"`
+	ResolutionSecs  int             `json:"resolution_seconds"`
+	LatencyBoundsUs []int64         `json:"latency_bounds_us"` // Upper bound of each row; the last row is unbounded
+	Columns         []HeatmapColumn `json:"columns"`           // Oldest first, including empty slices
+	MaxCount        int             `json:"max_count"`         // Largest cell, for scaling colors
+}
+
+// heatmapSlot holds one minute of counts in the ring
+type heatmapSlot struct {
+	start  int64 // Unix minute the slot holds, so stale slots can be recognized
+	errors int
+	counts []int
+}
+
+// latencyHeatmap counts requests per minute and latency row as they are
+// recorded, keeping the last day in a fixed-size ring
+type latencyHeatmap struct {
+	mutex sync.RWMutex
+	slots []heatmapSlot
+}
+
+func newLatencyHeatmap() *latencyHeatmap {
+	h := &latencyHeatmap{slots: make([]heatmapSlot, heatmapRetention/heatmapResolution)}
+	for i := range h.slots {
+		h.slots[i].start = -1
+	}
+	return h
+}
+
+// observe adds a record to the heatmap
+func (h *latencyHeatmap) observe(timestamp time.Time, duration time.Duration, failed bool) {
+	minute := timestamp.Unix() / int64(heatmapResolution/time.Second)
+	row := sort.Search(len(heatmapBoundsUs), func(i int) bool { return duration.Microseconds() <= heatmapBoundsUs[i] })
+
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	slot := &h.slots[minute%int64(len(h.slots))]
+	if slot.start != minute {
+		if slot.start > minute {
+			// Older than the retention window
+			return
+		}
+		*slot = heatmapSlot{start: minute, counts: make([]int, len(heatmapBoundsUs)+1)}
+	}
+	slot.counts[row]++
+	if failed {
+		slot.errors++
+	}
+}
+
+// reset drops every count
+func (h *latencyHeatmap) reset() {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	for i := range h.slots {
+		h.slots[i] = heatmapSlot{start: -1}
+	}
+}
+
+// snapshot returns the columns covering [start, end), merging minutes into
+// columns of the given resolution, which must be a whole number of minutes
+func (h *latencyHeatmap) snapshot(start, end time.Time, resolution time.Duration) LatencyHeatmap {
+	minutesPerColumn := int64(resolution / heatmapResolution)
+	first := start.Truncate(resolution)
+	heatmap := LatencyHeatmap{
+		Start:           first,
+		End:             end,
+		ResolutionSecs:  int(resolution / time.Second),
+		LatencyBoundsUs: heatmapBoundsUs,
+		Columns:         []HeatmapColumn{},
+	}
+
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+
+	for columnStart := first; columnStart.Before(end); columnStart = columnStart.Add(resolution) {
+		column := HeatmapColumn{Start: columnStart, Counts: make([]int, len(heatmapBoundsUs)+1)}
+		firstMinute := columnStart.Unix() / int64(heatmapResolution/time.Second)
+		for minute := firstMinute; minute < firstMinute+minutesPerColumn; minute++ {
+			slot := &h.slots[minute%int64(len(h.slots))]
+			if slot.start != minute {
+				continue
+			}
+			column.Errors += slot.errors
+			for row, count := range slot.counts {
+				column.Counts[row] += count
+				column.Total += count
+			}
+		}
+		for _, count := range column.Counts {
+			if count > heatmap.MaxCount {
+				heatmap.MaxCount = count
+			}
+		}
+		heatmap.Columns = append(heatmap.Columns, column)
+	}
+	return heatmap
+}
+
+// handleHeatmap serves latency heatmap data for a trailing window
+func (p *Proxy) handleHeatmap(w http.ResponseWriter, r *http.Request) {
+	// Add CORS headers
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, Cache-Control, Pragma, Expires")
+
+	// Handle preflight requests
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	window := time.Hour
+	if value := r.URL.Query().Get("window"); value != "" {
+		var err error
+		window, err = time.ParseDuration(value)
+		if err != nil || window <= 0 || window > heatmapRetention {
+			http.Error(w, "Invalid window: expected a duration up to 24h", http.StatusBadRequest)
+			return
+		}
+	}
+
+	resolution := heatmapResolution
+	if value := r.URL.Query().Get("resolution"); value != "" {
+		var err error
+		resolution, err = time.ParseDuration(value)
+		if err != nil || resolution < heatmapResolution || resolution%heatmapResolution != 0 {
+			http.Error(w, "Invalid resolution: expected whole minutes, e.g. 1m or 15m", http.StatusBadRequest)
+			return
+		}
+	} else {
+		// Keep the default readable: at most 60 columns
+		for _, candidate := range heatmapResolutions {
+			resolution = candidate
+			if window/candidate <= 60 {
+				break
+			}
+		}
+	}
+
+	end := time.Now()
+	data, err := json.Marshal(p.heatmap.snapshot(end.Add(-window), end, resolution))
+	if err != nil {
+		http.Error(w, "Failed to build heatmap", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if _, err := w.Write(data); err != nil {
+		slog.Warn("Error writing heatmap response", "error", err)
+	}
+}
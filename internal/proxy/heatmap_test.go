@@ -0,0 +1,97 @@
+//go:build unit
+
+package proxy
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLatencyHeatmapBuckets(t *testing.T) {
+	h := newLatencyHeatmap()
+	base := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	h.observe(base, 500*time.Microsecond, false)                   // Row 0: <= 1ms
+	h.observe(base.Add(10*time.Second), 1*time.Millisecond, false) // Row 0: bounds are inclusive
+	h.observe(base.Add(30*time.Second), 30*time.Millisecond, true) // Row 5: <= 50ms
+	h.observe(base.Add(time.Minute), 20*time.Second, false)        // Last row: slower than every bound
+	h.observe(base.Add(6*time.Minute), 3*time.Millisecond, false)  // Row 2: <= 5ms
+
+	heatmap := h.snapshot(base, base.Add(10*time.Minute), 5*time.Minute)
+	assert.Equal(t, 300, heatmap.ResolutionSecs)
+	require.Len(t, heatmap.Columns, 2)
+	assert.Len(t, heatmap.LatencyBoundsUs, len(heatmap.Columns[0].Counts)-1)
+
+	first := heatmap.Columns[0]
+	assert.Equal(t, base, first.Start)
+	assert.Equal(t, 4, first.Total)
+	assert.Equal(t, 1, first.Errors)
+	assert.Equal(t, 2, first.Counts[0])
+	assert.Equal(t, 1, first.Counts[5])
+	assert.Equal(t, 1, first.Counts[len(first.Counts)-1])
+
+	second := heatmap.Columns[1]
+	assert.Equal(t, 1, second.Total)
+	assert.Equal(t, 1, second.Counts[2])
+	assert.Equal(t, 2, heatmap.MaxCount)
+}
+
+func TestLatencyHeatmapRingDropsStaleMinutes(t *testing.T) {
+	h := newLatencyHeatmap()
+	base := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	h.observe(base, time.Millisecond, false)
+	// A day later the same slot is reused for the new minute
+	h.observe(base.Add(heatmapRetention), time.Millisecond, false)
+	// Records older than the slot's minute are ignored
+	h.observe(base, time.Millisecond, false)
+
+	assert.Equal(t, 0, h.snapshot(base, base.Add(time.Minute), time.Minute).Columns[0].Total)
+	assert.Equal(t, 1, h.snapshot(base.Add(heatmapRetention), base.Add(heatmapRetention+time.Minute), time.Minute).Columns[0].Total)
+
+	h.reset()
+	assert.Equal(t, 0, h.snapshot(base.Add(heatmapRetention), base.Add(heatmapRetention+time.Minute), time.Minute).MaxCount)
+}
+
+func TestHeatmapAPI(t *testing.T) {
+	p := New(&Config{})
+	now := time.Now()
+	p.recordRequest(RequestRecord{URL: "http://api.example.com/", ResponseStatus: 200, Success: true, Timestamp: now,
+		ProxyStartTime: now, ProxyEndTime: now.Add(40 * time.Millisecond)})
+
+	rec := httptest.NewRecorder()
+	p.handleHeatmap(rec, httptest.NewRequest(http.MethodGet, "/requests/stats/heatmap", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	var heatmap LatencyHeatmap
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &heatmap))
+	assert.Equal(t, 60, heatmap.ResolutionSecs)
+	assert.Equal(t, 1, heatmap.MaxCount)
+	last := heatmap.Columns[len(heatmap.Columns)-1]
+	assert.Equal(t, 1, last.Counts[5])
+
+	rec = httptest.NewRecorder()
+	p.handleHeatmap(rec, httptest.NewRequest(http.MethodGet, "/requests/stats/heatmap?window=24h", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &heatmap))
+	assert.Equal(t, 1800, heatmap.ResolutionSecs, "the default keeps at most 60 columns")
+	assert.LessOrEqual(t, len(heatmap.Columns), 49)
+
+	// Clearing history clears the heatmap too
+	p.handleClearHistory(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/requests/clear", nil))
+	rec = httptest.NewRecorder()
+	p.handleHeatmap(rec, httptest.NewRequest(http.MethodGet, "/requests/stats/heatmap", nil))
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &heatmap))
+	assert.Equal(t, 0, heatmap.MaxCount)
+
+	for _, query := range []string{"window=48h", "window=soon", "resolution=30s", "resolution=90s"} {
+		rec = httptest.NewRecorder()
+		p.handleHeatmap(rec, httptest.NewRequest(http.MethodGet, "/requests/stats/heatmap?"+query, nil))
+		assert.Equal(t, http.StatusBadRequest, rec.Code, query)
+	}
+}
@@ -0,0 +1,148 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// Hedge winners recorded on RequestRecord.HedgeWinner
+const (
+	HedgePrimary = "primary"
+	HedgeSecond  = "hedge"
+)
+
+// idempotentMethods can be sent twice without changing the outcome
+var idempotentMethods = map[string]bool{
+	http.MethodGet:     true,
+	http.MethodHead:    true,
+	http.MethodOptions: true,
+	http.MethodPut:     true,
+	http.MethodDelete:  true,
+	http.MethodTrace:   true,
+}
+
+// hedgeAttempt is the outcome of one copy of a hedged request
+type hedgeAttempt struct {
+	winner string
+	resp   *http.Response
+	hops   *redirectHops
+	err    error
+}
+
+// cancelOnClose releases an attempt's context once its response body is closed
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (c *cancelOnClose) Close() error {
+	defer c.cancel()
+	return c.ReadCloser.Close()
+}
+
+// sendUpstream sends the request upstream. With hedging enabled, an
+// idempotent request that has no response after the hedge delay is sent a
+// second time, to the hedge target when one is set, and the first response
+// wins. body is the captured request body, replayed for the second copy.
+func (p *Proxy) sendUpstream(req *http.Request, body string, record *RequestRecord) (*http.Response, *redirectHops, error) {
+	// A streamed body cannot be sent twice, and a raw capture records one exchange
+	_, streamed := req.Body.(*bodyCapture)
+	if p.currentConfig().HedgeDelay <= 0 || !idempotentMethods[req.Method] || streamed || wireCaptureFrom(req.Context()) != nil {
+		req, hops := p.trackRedirects(req)
+		resp, err := p.httpClient.Do(req)
+		return resp, hops, err
+	}
+
+	results := make(chan hedgeAttempt, 2)
+	cancels := map[string]context.CancelFunc{}
+	send := func(winner string, req *http.Request) {
+		ctx, cancel := context.WithCancel(req.Context())
+		cancels[winner] = cancel
+		req, hops := p.trackRedirects(req.WithContext(ctx))
+		go func() {
+			resp, err := p.httpClient.Do(req)
+			results <- hedgeAttempt{winner: winner, resp: resp, hops: hops, err: err}
+		}()
+	}
+
+	send(HedgePrimary, req)
+	pending := 1
+
+	timer := time.NewTimer(p.currentConfig().HedgeDelay)
+	defer timer.Stop()
+
+	var failed hedgeAttempt
+	for pending > 0 {
+		select {
+		case <-timer.C:
+			record.Hedged = true
+			send(HedgeSecond, p.hedgeRequest(req, body))
+			pending++
+			continue
+		case result := <-results:
+			pending--
+			if result.err != nil {
+				// Wait for the other copy, if one was sent
+				failed = result
+				continue
+			}
+
+			// Stop the slower copy and discard its response
+			for winner, cancel := range cancels {
+				if winner != result.winner {
+					cancel()
+				}
+			}
+			if pending > 0 {
+				go discardHedgeAttempt(results)
+			}
+			if record.Hedged {
+				record.HedgeWinner = result.winner
+			}
+			result.resp.Body = &cancelOnClose{ReadCloser: result.resp.Body, cancel: cancels[result.winner]}
+			return result.resp, result.hops, nil
+		}
+	}
+
+	for _, cancel := range cancels {
+		cancel()
+	}
+	return nil, failed.hops, failed.err
+}
+
+// hedgeRequest copies a request for the second attempt, pointed at the hedge
+// target when one is configured
+func (p *Proxy) hedgeRequest(req *http.Request, body string) *http.Request {
+	hedge := req.Clone(req.Context())
+	if req.Body != nil {
+		hedge.Body = io.NopCloser(bytes.NewReader([]byte(body)))
+	}
+	if p.currentConfig().HedgeTarget != nil {
+		target := *hedge.URL
+		target.Scheme, target.Host = p.currentConfig().HedgeTarget.Scheme, p.currentConfig().HedgeTarget.Host
+		hedge.URL = &target
+		hedge.Host = target.Host
+	}
+	return hedge
+}
+
+// discardHedgeAttempt closes the response of the attempt that lost
+func discardHedgeAttempt(results <-chan hedgeAttempt) {
+	result := <-results
+	if result.resp == nil {
+		return
+	}
+	if err := result.resp.Body.Close(); err != nil {
+		slog.Warn("Error closing hedged response body", "error", err)
+	}
+}
+
+// ParseHedgeTarget parses the alternate target hedged requests are sent to
+func ParseHedgeTarget(value string) (*url.URL, error) {
+	return parseUpstreamURL(value)
+}
@@ -0,0 +1,115 @@
+//go:build unit
+
+package proxy
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// slowFirstUpstream answers its first request after a second, or when the
+// request is cancelled, and later requests right away
+func slowFirstUpstream(t *testing.T, calls *int32) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		call := atomic.AddInt32(calls, 1)
+		if call == 1 {
+			select {
+			case <-time.After(time.Second):
+			case <-r.Context().Done():
+				return
+			}
+		}
+		if _, err := w.Write([]byte(fmt.Sprintf("call %d %s", call, body))); err != nil {
+			t.Logf("Error writing response: %v", err)
+		}
+	}))
+}
+
+func TestHedgedRequestUsesFirstAnswer(t *testing.T) {
+	var calls int32
+	upstream := slowFirstUpstream(t, &calls)
+	defer upstream.Close()
+
+	p := New(&Config{HedgeDelay: 20 * time.Millisecond})
+	rec := httptest.NewRecorder()
+	p.ServeHTTP(rec, httptest.NewRequest(http.MethodPut, upstream.URL+"/items/1", strings.NewReader("payload")))
+
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "call 2 payload", rec.Body.String(), "the hedge replays the request body")
+	record := p.history.GetRecords()[0]
+	assert.True(t, record.Hedged)
+	assert.Equal(t, HedgeSecond, record.HedgeWinner)
+	assert.Less(t, record.UpstreamEndTime.Sub(record.UpstreamStartTime), 500*time.Millisecond)
+}
+
+func TestHedgingSkipsFastAndNonIdempotentRequests(t *testing.T) {
+	var calls int32
+	upstream := slowFirstUpstream(t, &calls)
+	defer upstream.Close()
+
+	p := New(&Config{HedgeDelay: 20 * time.Millisecond})
+
+	// POST requests are never sent twice, however slow
+	rec := httptest.NewRecorder()
+	p.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, upstream.URL+"/orders", strings.NewReader("order")))
+	assert.Equal(t, "call 1 order", rec.Body.String())
+	assert.False(t, p.history.GetRecords()[0].Hedged)
+
+	// A response within the delay is not hedged
+	rec = httptest.NewRecorder()
+	p.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, upstream.URL+"/fast", nil))
+	assert.Equal(t, "call 2 ", rec.Body.String())
+	record := p.history.GetRecords()[0]
+	assert.False(t, record.Hedged)
+	assert.Empty(t, record.HedgeWinner)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&calls))
+}
+
+func TestHedgeTarget(t *testing.T) {
+	var primaryCalls, replicaCalls int32
+	primary := slowFirstUpstream(t, &primaryCalls)
+	defer primary.Close()
+	replica := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&replicaCalls, 1)
+		if _, err := w.Write([]byte("replica " + r.URL.RequestURI())); err != nil {
+			t.Logf("Error writing response: %v", err)
+		}
+	}))
+	defer replica.Close()
+
+	target, err := ParseHedgeTarget(replica.URL)
+	require.NoError(t, err)
+	p := New(&Config{HedgeDelay: 20 * time.Millisecond, HedgeTarget: target})
+
+	rec := httptest.NewRecorder()
+	p.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, primary.URL+"/users?page=2", nil))
+	assert.Equal(t, "replica /users?page=2", rec.Body.String())
+	assert.Equal(t, int32(1), atomic.LoadInt32(&replicaCalls))
+	assert.Equal(t, HedgeSecond, p.history.GetRecords()[0].HedgeWinner)
+
+	_, err = ParseHedgeTarget("replica:8080")
+	assert.Error(t, err)
+}
+
+func TestHedgedRequestFailure(t *testing.T) {
+	// Nothing listens on the target: the request fails before the hedge delay
+	upstream := httptest.NewServer(http.NotFoundHandler())
+	target := upstream.URL
+	upstream.Close()
+
+	p := New(&Config{HedgeDelay: time.Second})
+	rec := httptest.NewRecorder()
+	p.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, target+"/", nil))
+	assert.Equal(t, http.StatusBadGateway, rec.Code)
+	assert.False(t, p.history.GetRecords()[0].Hedged)
+}
@@ -2,6 +2,7 @@ package proxy
 
 import (
 	"encoding/json"
+	"fmt"
 	"sync"
 	"time"
 )
@@ -12,64 +13,296 @@ type RequestRecord struct {
 	Timestamp       time.Time         `json:"timestamp"`
 	Method          string            `json:"method"`
 	URL             string            `json:"url"`
+	URLComponents   *URLComponents    `json:"url_components,omitempty"` // Parsed when the request is recorded
+	ClientAddr      string            `json:"client_addr,omitempty"`    // IP address of the client that sent the request
 	RequestHeaders  map[string]string `json:"request_headers"`
 	RequestBody     string            `json:"request_body,omitempty"`
 	ResponseStatus  int               `json:"response_status"`
 	ResponseHeaders map[string]string `json:"response_headers"`
 	ResponseBody    string            `json:"response_body,omitempty"`
 
+	// Bodies decoded with a registered protobuf/thrift schema
+	RequestBodyDecoded  json.RawMessage `json:"request_body_decoded,omitempty"`
+	ResponseBodyDecoded json.RawMessage `json:"response_body_decoded,omitempty"`
+	BodyDecodeError     string          `json:"body_decode_error,omitempty"`
+	BodiesOmitted       bool            `json:"bodies_omitted,omitempty"`  // Capture was paused to metadata only
+	HeadersOmitted      bool            `json:"headers_omitted,omitempty"` // A metadata-only capture policy dropped headers and the query
+	ArtifactID          string          `json:"artifact_id,omitempty"`     // The response body was extracted to the artifacts directory
+
+	// XML and SOAP fields
+	XMLRequestRoot  string `json:"xml_request_root,omitempty"`  // Document element of an XML request body
+	XMLResponseRoot string `json:"xml_response_root,omitempty"` // Document element of an XML response body
+	SOAPAction      string `json:"soap_action,omitempty"`
+	SOAPOperation   string `json:"soap_operation,omitempty"` // First element inside the request's SOAP Body
+	SOAPFault       string `json:"soap_fault,omitempty"`     // Fault string of a SOAP fault response
+
+	// gRPC fields for translated gRPC-Web calls
+	GRPCService string `json:"grpc_service,omitempty"`
+	GRPCMethod  string `json:"grpc_method,omitempty"`
+	GRPCStatus  string `json:"grpc_status,omitempty"` // Numeric grpc-status code from the trailers
+	GRPCMessage string `json:"grpc_message,omitempty"`
+
 	// Timing metrics
 	ProxyStartTime    time.Time `json:"proxy_start_time"`
 	UpstreamStartTime time.Time `json:"upstream_start_time"`
 	UpstreamEndTime   time.Time `json:"upstream_end_time"`
 	ProxyEndTime      time.Time `json:"proxy_end_time"`
 
-	// Calculated metrics (in microseconds for better precision)
+	// Position of ProxyStartTime on the monotonic clock of the process that took it
+	ClockID          string `json:"clock_id,omitempty"`
+	MonotonicStartUs int64  `json:"monotonic_start_us,omitempty"` // Microseconds since that process started
+
+	// Calculated metrics on the monotonic clock (in microseconds for better precision)
 	ProxyOverheadUs   int64 `json:"proxy_overhead_us"`   // Time spent in proxy logic (microseconds)
 	UpstreamLatencyUs int64 `json:"upstream_latency_us"` // Time waiting for upstream (microseconds)
 	TotalDurationUs   int64 `json:"total_duration_us"`   // Total time from client perspective (microseconds)
 
+	// Phases of the upstream exchange from httptrace; nil for hedged requests
+	// and exchanges that got no response
+	Phases *PhaseTimings `json:"phases,omitempty"`
+
+	// Endpoint the upstream connection was made to, for debugging dual-stack
+	// connectivity
+	UpstreamAddr     string `json:"upstream_addr,omitempty"`      // IP address and port
+	UpstreamIPFamily string `json:"upstream_ip_family,omitempty"` // ipv4 or ipv6
+
 	// Size metrics
 	RequestSize  int64 `json:"request_size"`
 	ResponseSize int64 `json:"response_size"`
 
+	// Streamed bodies longer than the capture limit keep only their start
+	RequestBodyTruncated  bool `json:"request_body_truncated,omitempty"`
+	ResponseBodyTruncated bool `json:"response_body_truncated,omitempty"`
+
+	// Bodies of content types not captured keep only their size and SHA-256
+	RequestBodySHA256  string `json:"request_body_sha256,omitempty"`
+	ResponseBodySHA256 string `json:"response_body_sha256,omitempty"`
+	requestBodyHash    string // SHA-256 of a whole streamed body
+	responseBodyHash   string
+
 	// Status
 	Success bool   `json:"success"`
 	Error   string `json:"error,omitempty"`
+
+	// Request hedging
+	Hedged      bool   `json:"hedged,omitempty"`       // A second copy was sent after the hedge delay
+	HedgeWinner string `json:"hedge_winner,omitempty"` // primary or hedge, whichever answered first
+
+	// Request header names as the client sent them, for routes that preserve
+	// header case
+	RequestHeaderNames []string `json:"request_header_names,omitempty"`
+
+	// Throttle rule that held or rejected the request
+	Throttle *ThrottleDecision `json:"throttle,omitempty"`
+
+	// Time the response was held to follow a latency profile
+	LatencyInjectedUs int64 `json:"latency_injected_us,omitempty"`
+
+	// Labels from the X-Netkit-Options tags option
+	Tags []string `json:"tags,omitempty"`
+
+	// Test run from the X-Netkit-Run header
+	RunID string `json:"run_id,omitempty"`
+
+	// W3C trace context: the trace the request belongs to, the proxy's span
+	// when it took part in the trace, and the client's span
+	TraceID      string `json:"trace_id,omitempty"`
+	SpanID       string `json:"span_id,omitempty"`
+	ParentSpanID string `json:"parent_span_id,omitempty"`
+	TraceState   string `json:"trace_state,omitempty"` // Vendor tracestate forwarded with the request
+
+	// Capture mode asked for with X-Netkit-Options, applied when the record is stored
+	captureOverride string
+
+	// Cache
+	CacheStatus string `json:"cache_status,omitempty"` // miss or revalidated when conditional GET is enabled
+
+	// Reverse-proxy route that handled the request
+	ReverseRoute string `json:"reverse_route,omitempty"`
+
+	// Redirect chain links when redirects are recorded hop by hop
+	RedirectHop    int    `json:"redirect_hop,omitempty"`     // Position in the chain, starting at 1
+	RedirectPrevID string `json:"redirect_prev_id,omitempty"` // Record of the hop that redirected here
+	RedirectNextID string `json:"redirect_next_id,omitempty"` // Record of the request this hop redirected to
+
+	// Webhook signature verification
+	WebhookProvider string `json:"webhook_provider,omitempty"`
+	SignatureValid  *bool  `json:"signature_valid,omitempty"` // Nil when no verifier matched the route
+	SignatureError  string `json:"signature_error,omitempty"`
+
+	// Webhook inbox delivery to the forward target
+	InboxPath             string `json:"inbox_path,omitempty"`
+	InboxTarget           string `json:"inbox_target,omitempty"`
+	InboxTargetStatus     int    `json:"inbox_target_status,omitempty"`   // Status of the last delivery attempt
+	InboxDeliveryStatus   string `json:"inbox_delivery_status,omitempty"` // pending, delivered, or failed
+	InboxDeliveryAttempts int    `json:"inbox_delivery_attempts,omitempty"`
+	InboxDeliveryError    string `json:"inbox_delivery_error,omitempty"`
+
+	// Advisory headers attached from earlier responses for the same route
+	Advisories []string `json:"advisories,omitempty"`
+
+	// Raw upstream bytes for routes configured for raw capture, downloadable from /requests/raw
+	RawCapture          bool `json:"raw_capture,omitempty"`
+	RawCaptureTruncated bool `json:"raw_capture_truncated,omitempty"` // Only the first bytes of a direction were kept
+	rawWire             *wireCapture
+}
+
+// UnmarshalJSON reads a record, accepting the millisecond timing fields of
+// older exports and history files when the microsecond ones are missing
+func (r *RequestRecord) UnmarshalJSON(data []byte) error {
+	type plainRecord RequestRecord
+	var legacy struct {
+		*plainRecord
+		ProxyOverheadMs   *float64 `json:"proxy_overhead_ms"`
+		UpstreamLatencyMs *float64 `json:"upstream_latency_ms"`
+		TotalDurationMs   *float64 `json:"total_duration_ms"`
+	}
+	legacy.plainRecord = (*plainRecord)(r)
+	if err := json.Unmarshal(data, &legacy); err != nil {
+		return err
+	}
+
+	for _, field := range []struct {
+		us *int64
+		ms *float64
+	}{
+		{&r.ProxyOverheadUs, legacy.ProxyOverheadMs},
+		{&r.UpstreamLatencyUs, legacy.UpstreamLatencyMs},
+		{&r.TotalDurationUs, legacy.TotalDurationMs},
+	} {
+		if *field.us == 0 && field.ms != nil {
+			*field.us = int64(*field.ms * 1000)
+		}
+	}
+	return nil
 }
 
-// RequestHistory manages the collection of request records
+// RequestHistory manages the collection of request records. Records are kept
+// in a ring buffer that is overwritten oldest first once it holds maxSize
+// records, so adding one does not move the others.
 type RequestHistory struct {
-	records []RequestRecord
+	records []RequestRecord // Ring buffer, grown up to maxSize
+	next    int             // One past the most recent record; len(records) until the buffer is full
+	byID    map[string]int  // Record ID to its index in records
 	mutex   sync.RWMutex
 	maxSize int
+	version uint64         // Incremented on every change, used to skip unchanged snapshots
+	journal historyJournal // Told about every change (optional)
+}
+
+// summarize drops the headers and bodies of a record, leaving what a list of
+// requests shows
+func (r *RequestRecord) summarize() {
+	r.RequestHeaders, r.ResponseHeaders = nil, nil
+	r.RequestBody, r.ResponseBody = "", ""
+	r.RequestBodyDecoded, r.ResponseBodyDecoded = nil, nil
 }
 
 // NewRequestHistory creates a new request history with the specified maximum size
 func NewRequestHistory(maxSize int) *RequestHistory {
 	return &RequestHistory{
 		records: make([]RequestRecord, 0),
+		byID:    make(map[string]int),
 		maxSize: maxSize,
 	}
 }
 
+// at returns the record at position i, counted from the most recent one.
+// Callers hold the mutex and keep i below len(h.records).
+func (h *RequestHistory) at(i int) *RequestRecord {
+	n := len(h.records)
+	return &h.records[(h.next-1-i+n)%n]
+}
+
+// ordered returns a copy of the records, most recent first. Callers hold the
+// mutex.
+func (h *RequestHistory) ordered() []RequestRecord {
+	result := make([]RequestRecord, len(h.records))
+	for i := range result {
+		result[i] = *h.at(i)
+	}
+	return result
+}
+
+// age returns how many records were added after the one at index i of the
+// buffer. Callers hold the mutex.
+func (h *RequestHistory) age(i int) int {
+	n := len(h.records)
+	return (h.next - 1 - i + n) % n
+}
+
+// store replaces the buffer with records given most recent first, oldest at
+// index 0. Callers hold the mutex and keep len(records) within maxSize.
+func (h *RequestHistory) store(records []RequestRecord) {
+	ring := make([]RequestRecord, len(records))
+	byID := make(map[string]int, len(records))
+	for i, record := range records {
+		ring[len(records)-1-i] = record
+		byID[record.ID] = len(records) - 1 - i
+	}
+	h.records = ring
+	h.byID = byID
+	h.next = len(ring)
+}
+
 // AddRecord adds a new request record to the history
 func (h *RequestHistory) AddRecord(record RequestRecord) {
 	h.mutex.Lock()
 	defer h.mutex.Unlock()
 
 	// Calculate metrics
-	record.TotalDurationUs = record.ProxyEndTime.Sub(record.ProxyStartTime).Microseconds()
-	record.UpstreamLatencyUs = record.UpstreamEndTime.Sub(record.UpstreamStartTime).Microseconds()
-	record.ProxyOverheadUs = record.TotalDurationUs - record.UpstreamLatencyUs
+	record.measure()
+
+	if h.maxSize <= 0 {
+		return
+	}
+	if len(h.records) < h.maxSize {
+		h.records = append(h.records, record)
+		h.next = len(h.records)
+	} else {
+		// Overwrite the oldest record
+		i := h.next % len(h.records)
+		oldID := h.records[i].ID
+		if h.byID[oldID] == i {
+			delete(h.byID, oldID)
+		}
+		if h.journal != nil {
+			h.journal.recordsRemoved([]string{oldID})
+		}
+		h.records[i] = record
+		h.next = i + 1
+	}
+	h.byID[record.ID] = h.next - 1
+	h.version++
+	if h.journal != nil {
+		h.journal.recordPut(record)
+	}
+}
 
-	// Add to beginning of slice (most recent first)
-	h.records = append([]RequestRecord{record}, h.records...)
+// attach sets the journal told about later changes, or removes it when nil
+func (h *RequestHistory) attach(journal historyJournal) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.journal = journal
+}
 
-	// Trim to max size
-	if len(h.records) > h.maxSize {
-		h.records = h.records[:h.maxSize]
+// SetMaxSize changes how many records are kept, dropping the oldest ones
+// beyond the new size
+func (h *RequestHistory) SetMaxSize(maxSize int) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	records := h.ordered()
+	if len(records) > maxSize {
+		dropped := records[max(maxSize, 0):]
+		records = records[:max(maxSize, 0)]
+		h.version++
+		if h.journal != nil {
+			h.journal.recordsRemoved(recordIDs(dropped))
+		}
 	}
+	h.maxSize = maxSize
+	h.store(records)
 }
 
 // GetRecords returns all records (most recent first)
@@ -78,25 +311,130 @@ func (h *RequestHistory) GetRecords() []RequestRecord {
 	defer h.mutex.RUnlock()
 
 	// Return a copy to avoid race conditions
-	result := make([]RequestRecord, len(h.records))
-	copy(result, h.records)
-	return result
+	return h.ordered()
+}
+
+// UpdateRecord applies update to the record with the given ID, reporting whether it was found
+func (h *RequestHistory) UpdateRecord(id string, update func(*RequestRecord)) bool {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	i, ok := h.byID[id]
+	if !ok {
+		return false
+	}
+	record := &h.records[i]
+	update(record)
+	h.version++
+	if h.journal != nil {
+		h.journal.recordPut(*record)
+	}
+	return true
+}
+
+// GetRecord returns the record with the given ID
+func (h *RequestHistory) GetRecord(id string) (RequestRecord, bool) {
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+
+	i, ok := h.byID[id]
+	if !ok {
+		return RequestRecord{}, false
+	}
+	return h.records[i], true
 }
 
-// GetRecordsJSON returns all records as JSON
-func (h *RequestHistory) GetRecordsJSON() ([]byte, error) {
-	records := h.GetRecords()
-	return json.Marshal(map[string]interface{}{
-		"records": records,
-		"total":   len(records),
-	})
+// RemoveRecord deletes the record with the given ID, reporting whether it was found
+func (h *RequestHistory) RemoveRecord(id string) bool {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	i, ok := h.byID[id]
+	if !ok {
+		return false
+	}
+	records := h.ordered()
+	age := h.age(i)
+	h.store(append(records[:age], records[age+1:]...))
+	h.version++
+	if h.journal != nil {
+		h.journal.recordsRemoved([]string{id})
+	}
+	return true
+}
+
+// RemoveRecords deletes the records that match and returns how many were removed
+func (h *RequestHistory) RemoveRecords(match func(RequestRecord) bool) int {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	kept := make([]RequestRecord, 0, len(h.records))
+	var removed []RequestRecord
+	for i := range h.records {
+		if record := h.at(i); !match(*record) {
+			kept = append(kept, *record)
+		} else {
+			removed = append(removed, *record)
+		}
+	}
+	if len(removed) > 0 {
+		h.store(kept)
+		h.version++
+		if h.journal != nil {
+			h.journal.recordsRemoved(recordIDs(removed))
+		}
+	}
+	return len(removed)
+}
+
+// RemoveOlderThan deletes the records from before cutoff and returns how many were removed
+func (h *RequestHistory) RemoveOlderThan(cutoff time.Time) int {
+	return h.RemoveRecords(func(record RequestRecord) bool { return record.Timestamp.Before(cutoff) })
 }
 
 // Clear removes all records
 func (h *RequestHistory) Clear() {
 	h.mutex.Lock()
 	defer h.mutex.Unlock()
+	clear(h.records)
 	h.records = h.records[:0]
+	clear(h.byID)
+	h.next = 0
+	h.version++
+	if h.journal != nil {
+		h.journal.cleared()
+	}
+}
+
+// recordIDs returns the ID of each record
+func recordIDs(records []RequestRecord) []string {
+	ids := make([]string, len(records))
+	for i, record := range records {
+		ids[i] = record.ID
+	}
+	return ids
+}
+
+// snapshot returns a copy of all records with the current history version
+func (h *RequestHistory) snapshot() ([]RequestRecord, uint64) {
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+
+	return h.ordered(), h.version
+}
+
+// restore replaces the history with previously saved records (most recent
+// first), keeping their recorded metrics, and returns the new history version
+func (h *RequestHistory) restore(records []RequestRecord) uint64 {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	if len(records) > h.maxSize {
+		records = records[:max(h.maxSize, 0)]
+	}
+	h.store(records)
+	h.version++
+	return h.version
 }
 
 // GetStats returns aggregated statistics
@@ -104,7 +442,12 @@ func (h *RequestHistory) GetStats() map[string]interface{} {
 	h.mutex.RLock()
 	defer h.mutex.RUnlock()
 
-	if len(h.records) == 0 {
+	return recordStats(h.records)
+}
+
+// recordStats summarizes records
+func recordStats(records []RequestRecord) map[string]interface{} {
+	if len(records) == 0 {
 		return map[string]interface{}{
 			"total_requests": 0,
 		}
@@ -112,14 +455,20 @@ func (h *RequestHistory) GetStats() map[string]interface{} {
 
 	var totalDuration, totalUpstreamLatency, totalProxyOverhead int64
 	var totalRequestSize, totalResponseSize int64
-	var successCount, errorCount int
+	var successCount, errorCount, preflightHits, preflightMisses int
 	statusCounts := make(map[int]int)
 	methodCounts := make(map[string]int)
+	durations := make([]int64, 0, len(records))
+	upstreamLatencies := make([]int64, 0, len(records))
+	proxyOverheads := make([]int64, 0, len(records))
 
-	for _, record := range h.records {
+	for _, record := range records {
 		totalDuration += record.TotalDurationUs
 		totalUpstreamLatency += record.UpstreamLatencyUs
 		totalProxyOverhead += record.ProxyOverheadUs
+		durations = append(durations, record.TotalDurationUs)
+		upstreamLatencies = append(upstreamLatencies, record.UpstreamLatencyUs)
+		proxyOverheads = append(proxyOverheads, record.ProxyOverheadUs)
 		totalRequestSize += record.RequestSize
 		totalResponseSize += record.ResponseSize
 
@@ -131,10 +480,16 @@ func (h *RequestHistory) GetStats() map[string]interface{} {
 
 		statusCounts[record.ResponseStatus]++
 		methodCounts[record.Method]++
+		switch record.CacheStatus {
+		case CacheStatusPreflightHit:
+			preflightHits++
+		case CacheStatusPreflightMiss:
+			preflightMisses++
+		}
 	}
 
-	count := len(h.records)
-	return map[string]interface{}{
+	count := len(records)
+	stats := map[string]interface{}{
 		"total_requests":          count,
 		"success_count":           successCount,
 		"error_count":             errorCount,
@@ -146,4 +501,18 @@ func (h *RequestHistory) GetStats() map[string]interface{} {
 		"status_codes":            statusCounts,
 		"methods":                 methodCounts,
 	}
+	// Preflights answered from the cache never reached the upstream
+	if preflightHits+preflightMisses > 0 {
+		stats["preflight_cache"] = map[string]int{"hits": preflightHits, "misses": preflightMisses}
+	}
+	// Averages hide the tail, so report percentiles alongside them
+	for _, p := range statsPercentiles {
+		stats[fmt.Sprintf("p%d_duration_us", p)] = percentile(durations, float64(p))
+		stats[fmt.Sprintf("p%d_upstream_latency_us", p)] = percentile(upstreamLatencies, float64(p))
+		stats[fmt.Sprintf("p%d_proxy_overhead_us", p)] = percentile(proxyOverheads, float64(p))
+	}
+	return stats
 }
+
+// statsPercentiles are the latency percentiles recordStats reports
+var statsPercentiles = []int{50, 90, 95, 99}
@@ -3,10 +3,13 @@
 package proxy
 
 import (
+	"encoding/json"
+	"fmt"
 	"testing"
 	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestNewRequestHistory(t *testing.T) {
@@ -301,3 +304,153 @@ func TestProxyOverheadCalculation(t *testing.T) {
 	assert.Equal(t, int64(10000), stats["avg_upstream_latency_us"])
 	assert.Equal(t, int64(10000), stats["avg_proxy_overhead_us"])
 }
+
+func TestRecordStatsPercentiles(t *testing.T) {
+	records := make([]RequestRecord, 100)
+	for i := range records {
+		records[i] = RequestRecord{TotalDurationUs: int64(i+1) * 1000, UpstreamLatencyUs: int64(i+1) * 900, ProxyOverheadUs: int64(i+1) * 100}
+	}
+	// One slow request barely moves the average but shows in the tail
+	records[99].TotalDurationUs = 5000000
+
+	stats := recordStats(records)
+	assert.Equal(t, int64(50000), stats["p50_duration_us"])
+	assert.Equal(t, int64(90000), stats["p90_duration_us"])
+	assert.Equal(t, int64(95000), stats["p95_duration_us"])
+	assert.Equal(t, int64(99000), stats["p99_duration_us"])
+	assert.Equal(t, int64(89100), stats["p99_upstream_latency_us"])
+	assert.Equal(t, int64(5000), stats["p50_proxy_overhead_us"])
+	assert.Equal(t, int64(1000), records[0].TotalDurationUs, "the records are left in order")
+}
+
+func TestRecordLegacyMillisecondFields(t *testing.T) {
+	var record RequestRecord
+	require.NoError(t, json.Unmarshal([]byte(`{"id": "old", "total_duration_ms": 12.5, "upstream_latency_ms": 10, "proxy_overhead_ms": 2.5}`), &record))
+	assert.Equal(t, "old", record.ID)
+	assert.Equal(t, int64(12500), record.TotalDurationUs)
+	assert.Equal(t, int64(10000), record.UpstreamLatencyUs)
+	assert.Equal(t, int64(2500), record.ProxyOverheadUs)
+
+	// Microsecond fields win, and records are written with them only
+	require.NoError(t, json.Unmarshal([]byte(`{"total_duration_us": 900, "total_duration_ms": 1}`), &record))
+	assert.Equal(t, int64(900), record.TotalDurationUs)
+	data, err := json.Marshal(record)
+	require.NoError(t, err)
+	assert.Contains(t, string(data), `"total_duration_us":900`)
+	assert.NotContains(t, string(data), "_ms")
+}
+
+func TestRingBufferKeepsOrderAcrossWraps(t *testing.T) {
+	history := NewRequestHistory(3)
+	ids := func() []string {
+		var ids []string
+		for _, record := range history.GetRecords() {
+			ids = append(ids, record.ID)
+		}
+		return ids
+	}
+	for i := 1; i <= 5; i++ {
+		history.AddRecord(RequestRecord{ID: fmt.Sprint(i)})
+	}
+	assert.Equal(t, []string{"5", "4", "3"}, ids())
+
+	// Updates, removals, and resizes work on the wrapped buffer
+	assert.True(t, history.UpdateRecord("4", func(r *RequestRecord) { r.Method = "PUT" }))
+	assert.Equal(t, "PUT", history.GetRecords()[1].Method)
+	assert.Equal(t, 1, history.RemoveRecords(func(r RequestRecord) bool { return r.ID == "4" }))
+	assert.Equal(t, []string{"5", "3"}, ids())
+	history.AddRecord(RequestRecord{ID: "6"})
+	history.AddRecord(RequestRecord{ID: "7"})
+	assert.Equal(t, []string{"7", "6", "5"}, ids())
+
+	history.SetMaxSize(5)
+	history.AddRecord(RequestRecord{ID: "8"})
+	assert.Equal(t, []string{"8", "7", "6", "5"}, ids())
+	history.SetMaxSize(2)
+	assert.Equal(t, []string{"8", "7"}, ids())
+	history.AddRecord(RequestRecord{ID: "9"})
+	assert.Equal(t, []string{"9", "8"}, ids())
+
+	filtered := history.GetFilteredRecords(&RequestFilter{})
+	assert.Equal(t, "9", filtered[0].ID)
+
+	history.Clear()
+	assert.Empty(t, ids())
+	history.AddRecord(RequestRecord{ID: "10"})
+	assert.Equal(t, []string{"10"}, ids())
+}
+
+func TestRecordsByID(t *testing.T) {
+	history := NewRequestHistory(3)
+	for i := 1; i <= 5; i++ {
+		history.AddRecord(RequestRecord{ID: fmt.Sprint(i), Method: "GET"})
+	}
+
+	_, found := history.GetRecord("2")
+	assert.False(t, found, "overwritten records leave the index")
+	record, found := history.GetRecord("4")
+	require.True(t, found)
+	assert.Equal(t, "4", record.ID)
+
+	assert.True(t, history.RemoveRecord("4"))
+	assert.False(t, history.RemoveRecord("4"))
+	_, found = history.GetRecord("4")
+	assert.False(t, found)
+	assert.Len(t, history.GetRecords(), 2)
+
+	// The index follows the buffer as it fills and wraps again
+	history.AddRecord(RequestRecord{ID: "6", Method: "GET"})
+	history.AddRecord(RequestRecord{ID: "7", Method: "GET"})
+	assert.True(t, history.UpdateRecord("5", func(r *RequestRecord) { r.Method = "PUT" }))
+	assert.False(t, history.UpdateRecord("3", func(r *RequestRecord) {}))
+	filtered := history.GetFilteredRecords(&RequestFilter{IDs: []string{"5", "7", "3", "7"}})
+	require.Len(t, filtered, 2)
+	assert.Equal(t, "7", filtered[0].ID, "most recent first")
+	assert.Equal(t, "PUT", filtered[1].Method)
+	assert.Empty(t, history.GetFilteredRecords(&RequestFilter{IDs: []string{"5"}, Methods: []string{"GET"}}))
+
+	history.Clear()
+	_, found = history.GetRecord("7")
+	assert.False(t, found)
+}
+
+// BenchmarkAddRecord adds records to a full history. The prepend case is the
+// slice-prepending history this ring buffer replaced, for comparison.
+func BenchmarkAddRecord(b *testing.B) {
+	record := RequestRecord{ID: "bench", Method: "GET", URL: "http://example.com/", Success: true}
+	for _, size := range []int{1000, 10000} {
+		b.Run(fmt.Sprintf("ring/%d", size), func(b *testing.B) {
+			history := NewRequestHistory(size)
+			for i := 0; i < size; i++ {
+				history.AddRecord(record)
+			}
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				history.AddRecord(record)
+			}
+		})
+		b.Run(fmt.Sprintf("prepend/%d", size), func(b *testing.B) {
+			records := make([]RequestRecord, size)
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				records = append([]RequestRecord{record}, records...)
+				records = records[:size]
+			}
+		})
+	}
+}
+
+// BenchmarkGetRecords copies a full, wrapped history most recent first
+func BenchmarkGetRecords(b *testing.B) {
+	history := NewRequestHistory(1000)
+	for i := 0; i < 1500; i++ {
+		history.AddRecord(RequestRecord{ID: fmt.Sprint(i)})
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		history.GetRecords()
+	}
+}
@@ -0,0 +1,242 @@
+package proxy
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// Buckets of the bolt history file
+var (
+	boltRecordsBucket = []byte("records") // Sequence number (big-endian) to record JSON, or sealed record, in the order records were added
+	boltIDsBucket     = []byte("ids")     // Record ID to sequence number
+	boltMetaBucket    = []byte("meta")    // "encryption" to the wrapped data key of a history key
+)
+
+// historyBolt keeps history in a bbolt key-value file, a pure-Go embedded
+// store for binaries built without cgo. Each batch of changes is written in
+// one transaction.
+type historyBolt struct {
+	*historyWriter
+	db    *bolt.DB
+	codec recordCodec
+}
+
+// openHistoryBolt opens or creates the bolt file at path and restores its
+// most recent records into history, deleting older ones beyond the history
+// size. Later changes to history are written to the file, encrypted when a
+// key provider is set.
+func openHistoryBolt(path string, provider KeyProvider, history *RequestHistory) (*historyBolt, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open history file: %v", err)
+	}
+
+	hb := &historyBolt{db: db}
+	if err := hb.openKey(provider); err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+	history.mutex.RLock()
+	maxSize := history.maxSize
+	history.mutex.RUnlock()
+	records, err := hb.load(max(maxSize, 0))
+	if err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+	history.restore(records)
+	hb.historyWriter = newHistoryWriter(hb.apply)
+	history.attach(hb)
+	return hb, nil
+}
+
+// openKey sets up the codec of the file's records, saving the wrapped data
+// key of a new history key
+func (hb *historyBolt) openKey(provider KeyProvider) error {
+	return hb.db.Update(func(tx *bolt.Tx) error {
+		metaBucket, err := tx.CreateBucketIfNotExists(boltMetaBucket)
+		if err != nil {
+			return err
+		}
+		var stored *historyFileKey
+		if data := metaBucket.Get([]byte("encryption")); data != nil {
+			if err := json.Unmarshal(data, &stored); err != nil {
+				return fmt.Errorf("invalid history file key: %v", err)
+			}
+		}
+		codec, created, err := newRecordCodec(provider, stored)
+		if err != nil {
+			return err
+		}
+		hb.codec = codec
+		if created == nil {
+			return nil
+		}
+		data, err := json.Marshal(created)
+		if err != nil {
+			return err
+		}
+		return metaBucket.Put([]byte("encryption"), data)
+	})
+}
+
+// load returns up to limit records, most recent first, and deletes the rest
+func (hb *historyBolt) load(limit int) ([]RequestRecord, error) {
+	records := make([]RequestRecord, 0)
+	err := hb.db.Update(func(tx *bolt.Tx) error {
+		recordsBucket, err := tx.CreateBucketIfNotExists(boltRecordsBucket)
+		if err != nil {
+			return err
+		}
+		idsBucket, err := tx.CreateBucketIfNotExists(boltIDsBucket)
+		if err != nil {
+			return err
+		}
+
+		var stale [][]byte
+		cursor := recordsBucket.Cursor()
+		for key, value := cursor.Last(); key != nil; key, value = cursor.Prev() {
+			if len(records) >= limit {
+				stale = append(stale, slices.Clone(key))
+				continue
+			}
+			record, err := hb.codec.decode(value)
+			if err != nil {
+				return err
+			}
+			records = append(records, record)
+		}
+		for _, key := range stale {
+			if err := hb.deleteRecord(recordsBucket, idsBucket, key); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read history file: %v", err)
+	}
+	return records, nil
+}
+
+// apply writes a batch of changes in one transaction
+func (hb *historyBolt) apply(batch []historyOp) error {
+	return hb.db.Update(func(tx *bolt.Tx) error {
+		for _, op := range batch {
+			recordsBucket, idsBucket := tx.Bucket(boltRecordsBucket), tx.Bucket(boltIDsBucket)
+			switch {
+			case op.put != nil:
+				data, err := hb.codec.encode(*op.put)
+				if err != nil {
+					return err
+				}
+				// Updated records keep their place
+				key := slices.Clone(idsBucket.Get([]byte(op.put.ID)))
+				if key == nil {
+					seq, err := recordsBucket.NextSequence()
+					if err != nil {
+						return err
+					}
+					key = binary.BigEndian.AppendUint64(nil, seq)
+					if err := idsBucket.Put([]byte(op.put.ID), key); err != nil {
+						return err
+					}
+				}
+				if err := recordsBucket.Put(key, data); err != nil {
+					return err
+				}
+			case len(op.removed) > 0:
+				for _, id := range op.removed {
+					if key := slices.Clone(idsBucket.Get([]byte(id))); key != nil {
+						if err := hb.deleteRecord(recordsBucket, idsBucket, key); err != nil {
+							return err
+						}
+					}
+				}
+			case op.clear:
+				for _, name := range [][]byte{boltRecordsBucket, boltIDsBucket} {
+					if err := tx.DeleteBucket(name); err != nil {
+						return err
+					}
+					if _, err := tx.CreateBucket(name); err != nil {
+						return err
+					}
+				}
+			}
+		}
+		return nil
+	})
+}
+
+// deleteRecord deletes the record stored under key and its ID entry
+func (hb *historyBolt) deleteRecord(recordsBucket, idsBucket *bolt.Bucket, key []byte) error {
+	if record, err := hb.codec.decode(recordsBucket.Get(key)); err == nil {
+		if err := idsBucket.Delete([]byte(record.ID)); err != nil {
+			return err
+		}
+	}
+	return recordsBucket.Delete(key)
+}
+
+// query returns the records that match filter, most recent first. Records
+// asked for by ID are looked up directly; otherwise every record is scanned.
+func (hb *historyBolt) query(filter *RequestFilter) ([]RequestRecord, error) {
+	hb.sync()
+
+	records := make([]RequestRecord, 0)
+	err := hb.db.View(func(tx *bolt.Tx) error {
+		recordsBucket, idsBucket := tx.Bucket(boltRecordsBucket), tx.Bucket(boltIDsBucket)
+		match := func(value []byte) error {
+			record, err := hb.codec.decode(value)
+			if err != nil {
+				return err
+			}
+			if filter.Matches(record) {
+				records = append(records, record)
+			}
+			return nil
+		}
+
+		if len(filter.IDs) > 0 {
+			var seqs []uint64
+			for _, id := range filter.IDs {
+				if key := idsBucket.Get([]byte(id)); key != nil && !slices.Contains(seqs, binary.BigEndian.Uint64(key)) {
+					seqs = append(seqs, binary.BigEndian.Uint64(key))
+				}
+			}
+			// Most recent first, like a scan
+			slices.Sort(seqs)
+			slices.Reverse(seqs)
+			for _, seq := range seqs {
+				if err := match(recordsBucket.Get(binary.BigEndian.AppendUint64(nil, seq))); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+
+		cursor := recordsBucket.Cursor()
+		for key, value := cursor.Last(); key != nil; key, value = cursor.Prev() {
+			if err := match(value); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return records, err
+}
+
+func (hb *historyBolt) shared() bool {
+	return false
+}
+
+// close writes the remaining changes and closes the file
+func (hb *historyBolt) close() error {
+	hb.stop()
+	return hb.db.Close()
+}
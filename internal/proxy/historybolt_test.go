@@ -0,0 +1,102 @@
+//go:build unit
+
+package proxy
+
+import (
+	"net/url"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	bolt "go.etcd.io/bbolt"
+)
+
+func TestHistoryBoltSurvivesRestarts(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history.bolt")
+
+	history := NewRequestHistory(3)
+	store, err := openHistoryBolt(path, nil, history)
+	require.NoError(t, err)
+	start := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i, status := range []int{200, 404, 500, 201} {
+		history.AddRecord(RequestRecord{ID: string(rune('a' + i)), Method: "GET", URL: "http://api.example.com/", ResponseStatus: status, Timestamp: start.Add(time.Duration(i) * time.Second)})
+	}
+	history.UpdateRecord("b", func(record *RequestRecord) { record.Method = "POST" })
+
+	filter, err := ParseRequestFilter(url.Values{"status": {"4xx,5xx"}}, true)
+	require.NoError(t, err)
+	records, err := store.query(filter)
+	require.NoError(t, err)
+	require.Len(t, records, 2)
+	assert.Equal(t, "c", records[0].ID)
+	assert.Equal(t, "b", records[1].ID, "updated records keep their place")
+	assert.Equal(t, "POST", records[1].Method)
+
+	records, err = store.query(&RequestFilter{IDs: []string{"b", "d", "a", "d"}})
+	require.NoError(t, err)
+	require.Len(t, records, 2, "a was overwritten when history was full")
+	assert.Equal(t, "d", records[0].ID)
+	assert.Equal(t, "b", records[1].ID)
+
+	history.attach(nil)
+	require.NoError(t, store.close())
+
+	restored := NewRequestHistory(2)
+	store, err = openHistoryBolt(path, nil, restored)
+	require.NoError(t, err)
+	records = restored.GetRecords()
+	require.Len(t, records, 2)
+	assert.Equal(t, "d", records[0].ID)
+	assert.Equal(t, "c", records[1].ID)
+	all, err := store.query(&RequestFilter{})
+	require.NoError(t, err)
+	assert.Len(t, all, 2, "records beyond the history size are deleted on restore")
+
+	restored.Clear()
+	all, err = store.query(&RequestFilter{})
+	require.NoError(t, err)
+	assert.Empty(t, all)
+	require.NoError(t, store.close())
+}
+
+func TestHistoryBoltEncryption(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history.bolt")
+	provider := envHistoryKey(t, "bolt passphrase")
+
+	history := NewRequestHistory(10)
+	store, err := openHistoryBolt(path, provider, history)
+	require.NoError(t, err)
+	history.AddRecord(RequestRecord{ID: "a", Method: "POST", URL: "http://api.example.com/login", RequestBody: "password=hunter2", Timestamp: time.Now()})
+	history.AddRecord(RequestRecord{ID: "b", Method: "GET", URL: "http://api.example.com/me", Timestamp: time.Now()})
+	history.RemoveRecord("b")
+	history.attach(nil)
+	require.NoError(t, store.close())
+
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second})
+	require.NoError(t, err)
+	require.NoError(t, db.View(func(tx *bolt.Tx) error {
+		assert.Equal(t, 1, tx.Bucket(boltRecordsBucket).Stats().KeyN)
+		assert.Equal(t, 1, tx.Bucket(boltIDsBucket).Stats().KeyN, "removed records leave no ID behind")
+		return tx.Bucket(boltRecordsBucket).ForEach(func(key, value []byte) error {
+			assert.NotContains(t, string(value), "hunter2")
+			assert.NotContains(t, string(value), "api.example.com")
+			return nil
+		})
+	}))
+	require.NoError(t, db.Close())
+
+	restored := NewRequestHistory(10)
+	store, err = openHistoryBolt(path, provider, restored)
+	require.NoError(t, err)
+	require.Len(t, restored.GetRecords(), 1)
+	assert.Equal(t, "password=hunter2", restored.GetRecords()[0].RequestBody)
+	restored.attach(nil)
+	require.NoError(t, store.close())
+
+	_, err = openHistoryBolt(path, nil, NewRequestHistory(10))
+	assert.ErrorContains(t, err, "no history key is set")
+	_, err = openHistoryBolt(path, envHistoryKey(t, "wrong"), NewRequestHistory(10))
+	assert.ErrorContains(t, err, "failed to unwrap history data key")
+}
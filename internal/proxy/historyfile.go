@@ -0,0 +1,253 @@
+package proxy
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+)
+
+// historyFileVersion is the format version written to history files
+const historyFileVersion = 1
+
+// historyFlushInterval is how often changed history is written to the history file
+const historyFlushInterval = 5 * time.Second
+
+// historyFile is the on-disk form of persisted history. With a key provider,
+// each record is sealed with AES-256-GCM under a random data key, and only the
+// data key wrapped by the provider is stored alongside.
+type historyFile struct {
+	Version       int             `json:"version"`
+	SavedAt       time.Time       `json:"saved_at"`
+	Encryption    *historyFileKey `json:"encryption,omitempty"`
+	Records       []RequestRecord `json:"records,omitempty"`
+	SealedRecords []string        `json:"sealed_records,omitempty"` // base64(nonce | ciphertext) of each record's JSON
+}
+
+type historyFileKey struct {
+	Provider   string `json:"provider"`
+	WrappedKey string `json:"wrapped_key"`
+}
+
+// recordSealer reads and writes records in the history file format, sealing
+// them under one data key when a key provider is set
+type recordSealer struct {
+	provider KeyProvider
+	dataKey  []byte
+	wrapped  string
+}
+
+// encode builds a file from the records, wrapping a data key on first use
+func (rs *recordSealer) encode(records []RequestRecord) (historyFile, error) {
+	file := historyFile{Version: historyFileVersion, SavedAt: time.Now().UTC()}
+	if rs.provider == nil {
+		file.Records = records
+		return file, nil
+	}
+
+	if err := rs.ensureDataKey(); err != nil {
+		return file, err
+	}
+	file.Encryption = &historyFileKey{Provider: rs.provider.Name(), WrappedKey: rs.wrapped}
+	file.SealedRecords = make([]string, 0, len(records))
+	for _, record := range records {
+		sealed, err := rs.seal(record)
+		if err != nil {
+			return file, err
+		}
+		file.SealedRecords = append(file.SealedRecords, sealed)
+	}
+	return file, nil
+}
+
+// decode returns the records of a file, keeping its data key for later encodes
+func (rs *recordSealer) decode(file historyFile) ([]RequestRecord, error) {
+	if file.Version != historyFileVersion {
+		return nil, fmt.Errorf("unsupported history file version %d", file.Version)
+	}
+	if file.Encryption == nil {
+		return file.Records, nil
+	}
+
+	if rs.provider == nil {
+		return nil, fmt.Errorf("file is encrypted with %s but no history key is set", file.Encryption.Provider)
+	}
+	dataKey, err := rs.provider.Unwrap(file.Encryption.WrappedKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unwrap history data key: %v", err)
+	}
+	rs.dataKey, rs.wrapped = dataKey, file.Encryption.WrappedKey
+
+	records := make([]RequestRecord, 0, len(file.SealedRecords))
+	for _, sealed := range file.SealedRecords {
+		record, err := rs.open(sealed)
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, record)
+	}
+	return records, nil
+}
+
+// ensureDataKey generates and wraps a data key the first time one is needed
+func (rs *recordSealer) ensureDataKey() error {
+	if rs.dataKey != nil {
+		return nil
+	}
+	dataKey := make([]byte, historyKeySize)
+	if _, err := rand.Read(dataKey); err != nil {
+		return err
+	}
+	wrapped, err := rs.provider.Wrap(dataKey)
+	if err != nil {
+		return fmt.Errorf("failed to wrap history data key: %v", err)
+	}
+	rs.dataKey, rs.wrapped = dataKey, wrapped
+	return nil
+}
+
+func (rs *recordSealer) seal(record RequestRecord) (string, error) {
+	plaintext, err := json.Marshal(record)
+	if err != nil {
+		return "", err
+	}
+	gcm, err := newGCM(rs.dataKey)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(gcm.Seal(nonce, nonce, plaintext, nil)), nil
+}
+
+func (rs *recordSealer) open(sealed string) (RequestRecord, error) {
+	var record RequestRecord
+	data, err := base64.StdEncoding.DecodeString(sealed)
+	if err != nil {
+		return record, fmt.Errorf("invalid sealed record")
+	}
+	gcm, err := newGCM(rs.dataKey)
+	if err != nil {
+		return record, err
+	}
+	if len(data) < gcm.NonceSize() {
+		return record, fmt.Errorf("invalid sealed record")
+	}
+	plaintext, err := gcm.Open(nil, data[:gcm.NonceSize()], data[gcm.NonceSize():], nil)
+	if err != nil {
+		return record, fmt.Errorf("failed to decrypt history record")
+	}
+	if err := json.Unmarshal(plaintext, &record); err != nil {
+		return record, fmt.Errorf("invalid sealed record: %v", err)
+	}
+	return record, nil
+}
+
+// writeFileAtomic replaces path with data through a temporary file
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, perm); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// historyPersister snapshots history to a file in the background
+type historyPersister struct {
+	recordSealer
+	path    string
+	history *RequestHistory
+	saved   uint64 // History version of the last snapshot
+	cancel  context.CancelFunc
+	wg      sync.WaitGroup
+}
+
+// startHistoryPersistence loads the history file into history and starts
+// saving changes to it. A file that cannot be read or decrypted is left alone.
+func startHistoryPersistence(path string, provider KeyProvider, history *RequestHistory) (*historyPersister, error) {
+	persister := &historyPersister{recordSealer: recordSealer{provider: provider}, path: path, history: history}
+	if err := persister.load(); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	persister.cancel = cancel
+	persister.wg.Add(1)
+	go func() {
+		defer persister.wg.Done()
+		ticker := time.NewTicker(historyFlushInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := persister.flush(); err != nil {
+					slog.Error("Error saving history file", "error", err)
+				}
+			}
+		}
+	}()
+	return persister, nil
+}
+
+// stop ends background saving and writes a final snapshot
+func (hp *historyPersister) stop() error {
+	hp.cancel()
+	hp.wg.Wait()
+	return hp.flush()
+}
+
+// load restores records from the history file, reusing its data key so
+// records stay readable with the same key provider
+func (hp *historyPersister) load() error {
+	data, err := os.ReadFile(hp.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read history file: %v", err)
+	}
+
+	var file historyFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return fmt.Errorf("failed to parse history file: %v", err)
+	}
+	records, err := hp.decode(file)
+	if err != nil {
+		return err
+	}
+
+	hp.saved = hp.history.restore(records)
+	return nil
+}
+
+// flush writes a snapshot if history changed since the last one
+func (hp *historyPersister) flush() error {
+	records, version := hp.history.snapshot()
+	if version == hp.saved {
+		return nil
+	}
+
+	file, err := hp.encode(records)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(file)
+	if err != nil {
+		return err
+	}
+	if err := writeFileAtomic(hp.path, data, 0600); err != nil {
+		return fmt.Errorf("failed to write history file: %v", err)
+	}
+	hp.saved = version
+	return nil
+}
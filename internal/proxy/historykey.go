@@ -0,0 +1,220 @@
+package proxy
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// Key derivation for passphrase-based history keys
+const (
+	historyKeySaltSize         = 16
+	historyKeySize             = 32
+	historyKeyPBKDF2Iterations = 100000
+)
+
+// KeyProvider wraps and unwraps the data key that encrypts persisted history
+// (envelope encryption), so the key-encryption key never touches the history file
+type KeyProvider interface {
+	// Name identifies the provider in the history file, e.g. "env:NETKIT_HISTORY_KEY"
+	Name() string
+	Wrap(dataKey []byte) (string, error)
+	Unwrap(wrapped string) ([]byte, error)
+}
+
+// ParseHistoryKey parses a history key source: env:NAME or file:PATH hold a
+// passphrase, vault:KEY uses the named Vault transit key with $VAULT_ADDR and
+// $VAULT_TOKEN (cloud KMS keys can be used through Vault's managed keys)
+func ParseHistoryKey(spec string) (KeyProvider, error) {
+	source, value, ok := strings.Cut(spec, ":")
+	if !ok || value == "" {
+		return nil, fmt.Errorf("expected env:NAME, file:PATH, or vault:KEY, got %q", spec)
+	}
+
+	switch source {
+	case "env":
+		passphrase := os.Getenv(value)
+		if passphrase == "" {
+			return nil, fmt.Errorf("environment variable %s is not set", value)
+		}
+		return &passphraseKeyProvider{name: spec, passphrase: passphrase}, nil
+
+	case "file":
+		data, err := os.ReadFile(value)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read key file: %v", err)
+		}
+		passphrase := strings.TrimSpace(string(data))
+		if passphrase == "" {
+			return nil, fmt.Errorf("key file %s is empty", value)
+		}
+		return &passphraseKeyProvider{name: spec, passphrase: passphrase}, nil
+
+	case "vault":
+		addr := os.Getenv("VAULT_ADDR")
+		token := os.Getenv("VAULT_TOKEN")
+		if addr == "" || token == "" {
+			return nil, fmt.Errorf("vault keys need VAULT_ADDR and VAULT_TOKEN")
+		}
+		return newVaultTransitProvider(addr, token, value), nil
+
+	default:
+		return nil, fmt.Errorf("unknown key source %q (expected env, file, or vault)", source)
+	}
+}
+
+// passphraseKeyProvider wraps data keys with a key derived from a passphrase
+type passphraseKeyProvider struct {
+	name       string
+	passphrase string
+}
+
+func (p *passphraseKeyProvider) Name() string {
+	return p.name
+}
+
+// Wrap seals the data key as base64(salt | nonce | ciphertext)
+func (p *passphraseKeyProvider) Wrap(dataKey []byte) (string, error) {
+	salt := make([]byte, historyKeySaltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	gcm, err := p.cipher(salt)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+
+	data := append(salt, nonce...)
+	return base64.StdEncoding.EncodeToString(gcm.Seal(data, nonce, dataKey, nil)), nil
+}
+
+func (p *passphraseKeyProvider) Unwrap(wrapped string) ([]byte, error) {
+	data, err := base64.StdEncoding.DecodeString(wrapped)
+	if err != nil || len(data) < historyKeySaltSize {
+		return nil, fmt.Errorf("invalid wrapped key")
+	}
+	salt, data := data[:historyKeySaltSize], data[historyKeySaltSize:]
+
+	gcm, err := p.cipher(salt)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < gcm.NonceSize() {
+		return nil, fmt.Errorf("invalid wrapped key")
+	}
+	dataKey, err := gcm.Open(nil, data[:gcm.NonceSize()], data[gcm.NonceSize():], nil)
+	if err != nil {
+		return nil, fmt.Errorf("wrong history key")
+	}
+	return dataKey, nil
+}
+
+func (p *passphraseKeyProvider) cipher(salt []byte) (cipher.AEAD, error) {
+	key, err := pbkdf2.Key(sha256.New, p.passphrase, salt, historyKeyPBKDF2Iterations, historyKeySize)
+	if err != nil {
+		return nil, err
+	}
+	return newGCM(key)
+}
+
+// vaultTransitProvider wraps data keys with a Vault transit key
+type vaultTransitProvider struct {
+	addr    string
+	token   string
+	keyName string
+	client  *http.Client
+}
+
+func newVaultTransitProvider(addr, token, keyName string) *vaultTransitProvider {
+	return &vaultTransitProvider{
+		addr:    strings.TrimSuffix(addr, "/"),
+		token:   token,
+		keyName: keyName,
+		client:  &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+func (v *vaultTransitProvider) Name() string {
+	return "vault:" + v.keyName
+}
+
+func (v *vaultTransitProvider) Wrap(dataKey []byte) (string, error) {
+	var result struct {
+		Data struct {
+			Ciphertext string `json:"ciphertext"`
+		} `json:"data"`
+	}
+	if err := v.call("encrypt", map[string]string{"plaintext": base64.StdEncoding.EncodeToString(dataKey)}, &result); err != nil {
+		return "", err
+	}
+	return result.Data.Ciphertext, nil
+}
+
+func (v *vaultTransitProvider) Unwrap(wrapped string) ([]byte, error) {
+	var result struct {
+		Data struct {
+			Plaintext string `json:"plaintext"`
+		} `json:"data"`
+	}
+	if err := v.call("decrypt", map[string]string{"ciphertext": wrapped}, &result); err != nil {
+		return nil, err
+	}
+	return base64.StdEncoding.DecodeString(result.Data.Plaintext)
+}
+
+// call POSTs to a transit endpoint, e.g. /v1/transit/encrypt/<key>
+func (v *vaultTransitProvider) call(operation string, body map[string]string, result interface{}) error {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, fmt.Sprintf("%s/v1/transit/%s/%s", v.addr, operation, v.keyName), bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("X-Vault-Token", v.token)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := v.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("vault transit %s failed: %v", operation, err)
+	}
+	defer func() {
+		if closeErr := resp.Body.Close(); closeErr != nil {
+			slog.Warn("Error closing vault response body", "error", closeErr)
+		}
+	}()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("vault transit %s failed: %v", operation, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("vault transit %s returned %s: %s", operation, resp.Status, strings.TrimSpace(string(respBody)))
+	}
+	return json.Unmarshal(respBody, result)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,219 @@
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Keys of the shared Redis history
+const (
+	redisRecordsKey    = "netkit:history:records"    // Hash of record ID to record JSON, or sealed record
+	redisOrderKey      = "netkit:history:order"      // Sorted set of record IDs scored by timestamp in microseconds
+	redisEncryptionKey = "netkit:history:encryption" // Wrapped data key of a history key
+)
+
+// redisTimeout bounds each round trip to Redis
+const redisTimeout = 5 * time.Second
+
+// redisQueryPage is how many records a query reads from Redis at a time
+const redisQueryPage = 500
+
+// historyRedis keeps history in Redis shared by every instance pointed at it,
+// so instances behind a load balancer see the traffic of the whole fleet.
+// Each instance writes its own records; the shared history is trimmed to the
+// most recent history-size records.
+type historyRedis struct {
+	*historyWriter
+	client  *redis.Client
+	codec   recordCodec
+	history *RequestHistory
+}
+
+// openHistoryRedis connects to the Redis server at rawURL
+// (redis://[user:password@]host:port[/db], or rediss:// for TLS). History
+// is not restored from it: the instance's own history starts empty, and
+// queries read the records of every instance from Redis. Records are
+// encrypted when a key provider is set.
+func openHistoryRedis(rawURL string, provider KeyProvider, history *RequestHistory) (*historyRedis, error) {
+	options, err := redis.ParseURL(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid Redis URL: %v", err)
+	}
+	client := redis.NewClient(options)
+
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+	if err := client.Ping(ctx).Err(); err != nil {
+		_ = client.Close()
+		return nil, fmt.Errorf("failed to reach Redis: %v", err)
+	}
+
+	codec, err := openRedisKey(ctx, client, provider)
+	if err != nil {
+		_ = client.Close()
+		return nil, err
+	}
+
+	hr := &historyRedis{client: client, codec: codec, history: history}
+	hr.historyWriter = newHistoryWriter(hr.apply)
+	history.attach(hr)
+	return hr, nil
+}
+
+// openRedisKey returns the codec of the shared records. The first instance
+// with a history key stores the wrapped data key; the others unwrap it, so
+// every instance needs the same key.
+func openRedisKey(ctx context.Context, client *redis.Client, provider KeyProvider) (recordCodec, error) {
+	for {
+		var stored *historyFileKey
+		data, err := client.Get(ctx, redisEncryptionKey).Bytes()
+		switch {
+		case err == nil:
+			if err := json.Unmarshal(data, &stored); err != nil {
+				return recordCodec{}, fmt.Errorf("invalid Redis history key: %v", err)
+			}
+		case !errors.Is(err, redis.Nil):
+			return recordCodec{}, fmt.Errorf("failed to read Redis history key: %v", err)
+		}
+
+		codec, created, err := newRecordCodec(provider, stored)
+		if err != nil || created == nil {
+			return codec, err
+		}
+		value, err := json.Marshal(created)
+		if err != nil {
+			return recordCodec{}, err
+		}
+		saved, err := client.SetNX(ctx, redisEncryptionKey, value, 0).Result()
+		if err != nil {
+			return recordCodec{}, fmt.Errorf("failed to save Redis history key: %v", err)
+		}
+		if saved {
+			return codec, nil
+		}
+		// Another instance saved its key first; use that one
+	}
+}
+
+// apply writes a batch of changes in one transaction, then trims the shared
+// history to the history size
+func (hr *historyRedis) apply(batch []historyOp) error {
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+
+	_, err := hr.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		for _, op := range batch {
+			switch {
+			case op.put != nil:
+				data, err := hr.codec.encode(*op.put)
+				if err != nil {
+					return err
+				}
+				pipe.HSet(ctx, redisRecordsKey, op.put.ID, data)
+				pipe.ZAdd(ctx, redisOrderKey, redis.Z{Score: float64(op.put.Timestamp.UnixMicro()), Member: op.put.ID})
+			case len(op.removed) > 0:
+				pipe.HDel(ctx, redisRecordsKey, op.removed...)
+				pipe.ZRem(ctx, redisOrderKey, stringArgs(op.removed)...)
+			case op.clear:
+				pipe.Del(ctx, redisRecordsKey, redisOrderKey)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return hr.trim(ctx)
+}
+
+// trim removes the oldest records beyond the history size, including records
+// of instances that stopped without removing their own
+func (hr *historyRedis) trim(ctx context.Context) error {
+	hr.history.mutex.RLock()
+	maxSize := max(hr.history.maxSize, 0)
+	hr.history.mutex.RUnlock()
+
+	stale, err := hr.client.ZRange(ctx, redisOrderKey, 0, int64(-maxSize-1)).Result()
+	if err != nil || len(stale) == 0 {
+		return err
+	}
+	_, err = hr.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HDel(ctx, redisRecordsKey, stale...)
+		pipe.ZRem(ctx, redisOrderKey, stringArgs(stale)...)
+		return nil
+	})
+	return err
+}
+
+// query returns the records of every instance that match filter, most recent
+// first. Records asked for by ID are looked up directly; otherwise every
+// record is read, a page at a time.
+func (hr *historyRedis) query(filter *RequestFilter) ([]RequestRecord, error) {
+	hr.sync()
+
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+
+	records := make([]RequestRecord, 0)
+	match := func(ids []string) error {
+		values, err := hr.client.HMGet(ctx, redisRecordsKey, ids...).Result()
+		if err != nil {
+			return err
+		}
+		for _, value := range values {
+			data, ok := value.(string)
+			if !ok {
+				continue // Removed since its ID was read
+			}
+			record, err := hr.codec.decode([]byte(data))
+			if err != nil {
+				return err
+			}
+			if filter.Matches(record) {
+				records = append(records, record)
+			}
+		}
+		return nil
+	}
+
+	if len(filter.IDs) > 0 {
+		if err := match(slices.Compact(slices.Sorted(slices.Values(filter.IDs)))); err != nil {
+			return nil, err
+		}
+		// Most recent first, like a scan
+		slices.SortStableFunc(records, func(a, b RequestRecord) int { return b.Timestamp.Compare(a.Timestamp) })
+		return records, nil
+	}
+
+	for start := int64(0); ; start += redisQueryPage {
+		ids, err := hr.client.ZRevRange(ctx, redisOrderKey, start, start+redisQueryPage-1).Result()
+		if err != nil {
+			return nil, err
+		}
+		if len(ids) > 0 {
+			if err := match(ids); err != nil {
+				return nil, err
+			}
+		}
+		if len(ids) < redisQueryPage {
+			return records, nil
+		}
+	}
+}
+
+// shared reports that other instances write to the same history
+func (hr *historyRedis) shared() bool {
+	return true
+}
+
+// close writes the remaining changes and closes the connection
+func (hr *historyRedis) close() error {
+	hr.stop()
+	return hr.client.Close()
+}
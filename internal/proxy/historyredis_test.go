@@ -0,0 +1,105 @@
+//go:build unit
+
+package proxy
+
+import (
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHistoryRedisIsShared(t *testing.T) {
+	server := miniredis.RunT(t)
+	redisURL := "redis://" + server.Addr()
+
+	// Two instances behind a load balancer
+	first, second := NewRequestHistory(3), NewRequestHistory(3)
+	firstStore, err := openHistoryRedis(redisURL, nil, first)
+	require.NoError(t, err)
+	secondStore, err := openHistoryRedis(redisURL, nil, second)
+	require.NoError(t, err)
+	assert.True(t, firstStore.shared())
+
+	start := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	first.AddRecord(RequestRecord{ID: "a", Method: "GET", ResponseStatus: 200, Timestamp: start})
+	second.AddRecord(RequestRecord{ID: "b", Method: "GET", ResponseStatus: 500, Timestamp: start.Add(time.Second)})
+	first.AddRecord(RequestRecord{ID: "c", Method: "POST", ResponseStatus: 201, Timestamp: start.Add(2 * time.Second)})
+	first.UpdateRecord("a", func(record *RequestRecord) { record.ResponseStatus = 204 })
+	secondStore.sync()
+
+	for _, store := range []*historyRedis{firstStore, secondStore} {
+		records, err := store.query(&RequestFilter{})
+		require.NoError(t, err)
+		require.Len(t, records, 3)
+		assert.Equal(t, []string{"c", "b", "a"}, recordIDs(records))
+		assert.Equal(t, 204, records[2].ResponseStatus)
+	}
+
+	records, err := secondStore.query(&RequestFilter{IDs: []string{"a", "c", "a"}, Methods: []string{"POST"}})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"c"}, recordIDs(records))
+
+	// The shared history is trimmed to the history size across instances
+	second.AddRecord(RequestRecord{ID: "d", Method: "GET", ResponseStatus: 200, Timestamp: start.Add(3 * time.Second)})
+	records, err = secondStore.query(&RequestFilter{})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"d", "c", "b"}, recordIDs(records))
+
+	stats := recordStats(records)
+	assert.Equal(t, 3, stats["total_requests"])
+
+	first.Clear()
+	firstStore.sync()
+	records, err = secondStore.query(&RequestFilter{})
+	require.NoError(t, err)
+	assert.Empty(t, records)
+
+	require.NoError(t, firstStore.close())
+	require.NoError(t, secondStore.close())
+}
+
+func TestHistoryRedisEncryption(t *testing.T) {
+	server := miniredis.RunT(t)
+	redisURL := "redis://" + server.Addr()
+
+	// Instances sharing the history share its data key
+	first, second := NewRequestHistory(10), NewRequestHistory(10)
+	firstStore, err := openHistoryRedis(redisURL, envHistoryKey(t, "fleet passphrase"), first)
+	require.NoError(t, err)
+	secondStore, err := openHistoryRedis(redisURL, envHistoryKey(t, "fleet passphrase"), second)
+	require.NoError(t, err)
+
+	first.AddRecord(RequestRecord{ID: "a", Method: "POST", URL: "http://api.example.com/login", RequestBody: "password=hunter2", Timestamp: time.Now()})
+	firstStore.sync()
+	stored := server.HGet(redisRecordsKey, "a")
+	assert.NotEmpty(t, stored)
+	assert.NotContains(t, stored, "hunter2")
+	assert.NotContains(t, stored, "api.example.com")
+
+	records, err := secondStore.query(&RequestFilter{})
+	require.NoError(t, err)
+	require.Len(t, records, 1)
+	assert.Equal(t, "password=hunter2", records[0].RequestBody)
+
+	_, err = openHistoryRedis(redisURL, nil, NewRequestHistory(10))
+	assert.ErrorContains(t, err, "no history key is set")
+	_, err = openHistoryRedis(redisURL, envHistoryKey(t, "wrong"), NewRequestHistory(10))
+	assert.ErrorContains(t, err, "failed to unwrap history data key")
+
+	require.NoError(t, firstStore.close())
+	require.NoError(t, secondStore.close())
+}
+
+func TestOpenHistoryRedisErrors(t *testing.T) {
+	_, err := openHistoryRedis("http://localhost:6379", nil, NewRequestHistory(1))
+	assert.ErrorContains(t, err, "invalid Redis URL")
+
+	server := miniredis.RunT(t)
+	addr := server.Addr()
+	server.Close()
+	_, err = openHistoryRedis("redis://"+addr, nil, NewRequestHistory(1))
+	assert.ErrorContains(t, err, "failed to reach Redis")
+}
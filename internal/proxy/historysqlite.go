@@ -0,0 +1,399 @@
+package proxy
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"unicode/utf8"
+)
+
+// sqliteDriver is the database/sql driver the SQLite backend opens databases
+// with. Binaries built with -tags sqlite register it.
+const sqliteDriver = "sqlite3"
+
+// historyDBSchema keeps each record as JSON, or sealed with a history key,
+// with the columns history is queried by alongside it. seq follows the order
+// records were added in. meta holds the wrapped data key of a history key.
+const historyDBSchema = `
+CREATE TABLE IF NOT EXISTS records (
+	seq INTEGER PRIMARY KEY AUTOINCREMENT,
+	id TEXT NOT NULL UNIQUE,
+	timestamp INTEGER NOT NULL,
+	method TEXT NOT NULL,
+	url TEXT NOT NULL,
+	status INTEGER NOT NULL,
+	duration_us INTEGER NOT NULL,
+	record TEXT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS records_timestamp ON records (timestamp);
+CREATE INDEX IF NOT EXISTS records_method ON records (method);
+CREATE INDEX IF NOT EXISTS records_status ON records (status);
+CREATE TABLE IF NOT EXISTS meta (
+	key TEXT PRIMARY KEY,
+	value TEXT NOT NULL
+);
+`
+
+// historyFTSSchema indexes the URL, header values, and bodies of each record
+// for GET /requests/search. The trigram tokenizer finds substrings, like a
+// scan would. FTS5 is only compiled into SQLite with -tags sqlite_fts5.
+const historyFTSSchema = `CREATE VIRTUAL TABLE IF NOT EXISTS records_fts USING fts5(id UNINDEXED, text, tokenize = 'trigram')`
+
+// historyDB keeps history in a SQLite database, writing each batch of
+// changes in one transaction
+type historyDB struct {
+	*historyWriter
+	db    *sql.DB
+	codec recordCodec
+	fts   bool // records_fts is kept up to date
+}
+
+// openHistoryDB opens or creates the database at path and restores its most
+// recent records into history, deleting older ones beyond the history size.
+// Later changes to history are written to the database, encrypted when a key
+// provider is set.
+func openHistoryDB(path string, provider KeyProvider, history *RequestHistory) (*historyDB, error) {
+	db, err := sql.Open(sqliteDriver, path)
+	if err != nil {
+		return nil, err
+	}
+	// SQLite allows one writer at a time
+	db.SetMaxOpenConns(1)
+	for _, statement := range []string{"PRAGMA journal_mode=WAL", "PRAGMA synchronous=NORMAL", historyDBSchema} {
+		if _, err := db.Exec(statement); err != nil {
+			_ = db.Close()
+			return nil, fmt.Errorf("failed to set up history database: %v", err)
+		}
+	}
+
+	hdb := &historyDB{db: db, fts: true}
+	if hdb.codec, err = openHistoryDBKey(db, provider); err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+	if hdb.codec.encrypted() {
+		// The index would keep bodies and headers in plain text
+		if _, err := db.Exec("DROP TABLE IF EXISTS records_fts"); err != nil {
+			_ = db.Close()
+			return nil, fmt.Errorf("failed to drop history search index: %v", err)
+		}
+		hdb.fts = false
+	} else if _, err := db.Exec(historyFTSSchema); err != nil {
+		slog.Warn("History search will scan records instead of using a full-text index; build with -tags \"sqlite sqlite_fts5\" to index them", "error", err)
+		hdb.fts = false
+	}
+	history.mutex.RLock()
+	maxSize := history.maxSize
+	history.mutex.RUnlock()
+	records, err := hdb.load(max(maxSize, 0))
+	if err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+	if hdb.fts {
+		if err := hdb.reindex(records); err != nil {
+			_ = db.Close()
+			return nil, fmt.Errorf("failed to index history database: %v", err)
+		}
+	}
+	history.restore(records)
+	hdb.historyWriter = newHistoryWriter(hdb.apply)
+	history.attach(hdb)
+	return hdb, nil
+}
+
+// openHistoryDBKey returns the codec of the database's records, saving the
+// wrapped data key of a new history key
+func openHistoryDBKey(db *sql.DB, provider KeyProvider) (recordCodec, error) {
+	var stored *historyFileKey
+	var data string
+	switch err := db.QueryRow("SELECT value FROM meta WHERE key = 'encryption'").Scan(&data); {
+	case err == nil:
+		if err := json.Unmarshal([]byte(data), &stored); err != nil {
+			return recordCodec{}, fmt.Errorf("invalid history database key: %v", err)
+		}
+	case !errors.Is(err, sql.ErrNoRows):
+		return recordCodec{}, fmt.Errorf("failed to read history database key: %v", err)
+	}
+
+	codec, created, err := newRecordCodec(provider, stored)
+	if err != nil || created == nil {
+		return codec, err
+	}
+	value, err := json.Marshal(created)
+	if err != nil {
+		return recordCodec{}, err
+	}
+	if _, err := db.Exec("INSERT INTO meta (key, value) VALUES ('encryption', ?)", string(value)); err != nil {
+		return recordCodec{}, fmt.Errorf("failed to save history database key: %v", err)
+	}
+	return codec, nil
+}
+
+// load returns up to limit records, most recent first, and deletes the rest
+func (hdb *historyDB) load(limit int) ([]RequestRecord, error) {
+	rows, err := hdb.db.Query("SELECT record FROM records ORDER BY seq DESC LIMIT ?", limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read history database: %v", err)
+	}
+	records := make([]RequestRecord, 0)
+	err = hdb.scanRecords(rows, func(record RequestRecord) error {
+		records = append(records, record)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read history database: %v", err)
+	}
+
+	if _, err := hdb.db.Exec("DELETE FROM records WHERE seq NOT IN (SELECT seq FROM records ORDER BY seq DESC LIMIT ?)", limit); err != nil {
+		return nil, fmt.Errorf("failed to trim history database: %v", err)
+	}
+	return records, nil
+}
+
+// apply writes a batch of changes in one transaction
+func (hdb *historyDB) apply(batch []historyOp) error {
+	tx, err := hdb.db.Begin()
+	if err != nil {
+		return err
+	}
+	for _, op := range batch {
+		switch {
+		case op.put != nil:
+			err = hdb.putRecord(tx, *op.put)
+			if err == nil && hdb.fts {
+				err = indexRecord(tx, *op.put)
+			}
+		case len(op.removed) > 0:
+			_, err = tx.Exec("DELETE FROM records WHERE id IN ("+placeholders(len(op.removed))+")", stringArgs(op.removed)...)
+			if err == nil && hdb.fts {
+				_, err = tx.Exec("DELETE FROM records_fts WHERE id IN ("+placeholders(len(op.removed))+")", stringArgs(op.removed)...)
+			}
+		case op.clear:
+			_, err = tx.Exec("DELETE FROM records")
+			if err == nil && hdb.fts {
+				_, err = tx.Exec("DELETE FROM records_fts")
+			}
+		}
+		if err != nil {
+			_ = tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// putRecord inserts a record, or updates it in place when its ID is stored.
+// Encrypted records leave the url column empty, as it is not queried.
+func (hdb *historyDB) putRecord(tx *sql.Tx, record RequestRecord) error {
+	data, err := hdb.codec.encode(record)
+	if err != nil {
+		return err
+	}
+	recordURL := record.URL
+	if hdb.codec.encrypted() {
+		recordURL = ""
+	}
+	_, err = tx.Exec(`INSERT INTO records (id, timestamp, method, url, status, duration_us, record) VALUES (?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (id) DO UPDATE SET timestamp = excluded.timestamp, method = excluded.method, url = excluded.url,
+		status = excluded.status, duration_us = excluded.duration_us, record = excluded.record`,
+		record.ID, record.Timestamp.UnixMicro(), record.Method, recordURL, record.ResponseStatus, record.TotalDurationUs, string(data))
+	return err
+}
+
+// indexRecord replaces the full-text index entry of a record
+func indexRecord(tx *sql.Tx, record RequestRecord) error {
+	if _, err := tx.Exec("DELETE FROM records_fts WHERE id = ?", record.ID); err != nil {
+		return err
+	}
+	_, err := tx.Exec("INSERT INTO records_fts (id, text) VALUES (?, ?)", record.ID, searchText(record))
+	return err
+}
+
+// reindex rebuilds the full-text index from records unless it already has
+// an entry for each of them, e.g. for databases written before it existed
+func (hdb *historyDB) reindex(records []RequestRecord) error {
+	var indexed int
+	if err := hdb.db.QueryRow("SELECT COUNT(*) FROM records_fts").Scan(&indexed); err != nil {
+		return err
+	}
+	if indexed == len(records) {
+		return nil
+	}
+	tx, err := hdb.db.Begin()
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM records_fts"); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	for _, record := range records {
+		if err := indexRecord(tx, record); err != nil {
+			_ = tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// searchText is what the full-text index keeps of a record
+func searchText(record RequestRecord) string {
+	parts := []string{record.URL}
+	for _, headers := range []map[string]string{record.RequestHeaders, record.ResponseHeaders} {
+		for _, name := range sortedHeaderNames(headers) {
+			parts = append(parts, headers[name])
+		}
+	}
+	return strings.Join(append(parts, record.RequestBody, record.ResponseBody), "\n")
+}
+
+// searchCandidates returns the records matching filter whose index entry
+// contains every search term of three or more characters, the shortest the
+// trigram index can look up. Without an index, or without such a term, it
+// returns every record matching filter.
+func (hdb *historyDB) searchCandidates(query *searchQuery, filter *RequestFilter) ([]RequestRecord, bool, error) {
+	var terms []string
+	for _, term := range query.terms {
+		if utf8.RuneCountInString(term) >= 3 {
+			terms = append(terms, `"`+strings.ReplaceAll(term, `"`, `""`)+`"`)
+		}
+	}
+	if !hdb.fts || len(terms) == 0 {
+		records, err := hdb.query(filter)
+		return records, false, err
+	}
+	records, err := hdb.selectRecords(filter, strings.Join(terms, " AND "))
+	return records, true, err
+}
+
+// query returns the records that match filter, most recent first. IDs,
+// methods, status codes, and times are selected by the database's indexes;
+// the rest of the filter is applied to the records it returns.
+func (hdb *historyDB) query(filter *RequestFilter) ([]RequestRecord, error) {
+	return hdb.selectRecords(filter, "")
+}
+
+// selectRecords returns the records that match filter and, unless it is
+// empty, the full-text query match, most recent first
+func (hdb *historyDB) selectRecords(filter *RequestFilter, match string) ([]RequestRecord, error) {
+	records := make([]RequestRecord, 0)
+	err := hdb.eachRecord(filter, match, func(record RequestRecord) error {
+		records = append(records, record)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return records, nil
+}
+
+// each calls fn with the records that match filter, most recent first,
+// decoding one row at a time instead of loading them all
+func (hdb *historyDB) each(filter *RequestFilter, fn func(RequestRecord) error) error {
+	return hdb.eachRecord(filter, "", fn)
+}
+
+// eachRecord calls fn with the records that match filter and, unless it is
+// empty, the full-text query match, most recent first. It stops at the
+// first error fn returns.
+func (hdb *historyDB) eachRecord(filter *RequestFilter, match string, fn func(RequestRecord) error) error {
+	hdb.sync()
+
+	var conditions []string
+	var args []any
+	if match != "" {
+		conditions = append(conditions, "id IN (SELECT id FROM records_fts WHERE records_fts MATCH ?)")
+		args = append(args, match)
+	}
+	if len(filter.IDs) > 0 {
+		conditions = append(conditions, "id IN ("+placeholders(len(filter.IDs))+")")
+		args = append(args, stringArgs(filter.IDs)...)
+	}
+	if len(filter.Methods) > 0 {
+		conditions = append(conditions, "method IN ("+placeholders(len(filter.Methods))+")")
+		args = append(args, stringArgs(filter.Methods)...)
+	}
+	if len(filter.Statuses) > 0 {
+		ranges := make([]string, len(filter.Statuses))
+		for i, status := range filter.Statuses {
+			ranges[i] = "status BETWEEN ? AND ?"
+			args = append(args, status.low, status.high)
+		}
+		conditions = append(conditions, "("+strings.Join(ranges, " OR ")+")")
+	}
+	if !filter.Since.IsZero() {
+		conditions = append(conditions, "timestamp >= ?")
+		args = append(args, filter.Since.UnixMicro())
+	}
+	if !filter.Until.IsZero() {
+		conditions = append(conditions, "timestamp < ?")
+		args = append(args, filter.Until.UnixMicro())
+	}
+
+	query := "SELECT record FROM records"
+	if len(conditions) > 0 {
+		query += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	rows, err := hdb.db.Query(query+" ORDER BY seq DESC", args...)
+	if err != nil {
+		return err
+	}
+	return hdb.scanRecords(rows, func(record RequestRecord) error {
+		if !filter.Matches(record) {
+			return nil
+		}
+		return fn(record)
+	})
+}
+
+func (hdb *historyDB) shared() bool {
+	return false
+}
+
+// close writes the remaining changes and closes the database
+func (hdb *historyDB) close() error {
+	hdb.stop()
+	return hdb.db.Close()
+}
+
+// scanRecords decodes the record column of each row, calls fn with it, and
+// closes rows
+func (hdb *historyDB) scanRecords(rows *sql.Rows, fn func(RequestRecord) error) error {
+	defer func() {
+		if err := rows.Close(); err != nil {
+			slog.Warn("Error closing history database rows", "error", err)
+		}
+	}()
+
+	for rows.Next() {
+		var data string
+		if err := rows.Scan(&data); err != nil {
+			return err
+		}
+		record, err := hdb.codec.decode([]byte(data))
+		if err != nil {
+			return err
+		}
+		if err := fn(record); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// placeholders returns n comma-separated SQL parameters
+func placeholders(n int) string {
+	return strings.TrimSuffix(strings.Repeat("?, ", n), ", ")
+}
+
+func stringArgs(values []string) []any {
+	args := make([]any, len(values))
+	for i, value := range values {
+		args[i] = value
+	}
+	return args
+}
@@ -0,0 +1,159 @@
+//go:build unit && sqlite
+
+package proxy
+
+import (
+	"database/sql"
+	"errors"
+	"net/url"
+	"path/filepath"
+	"testing"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHistoryDBSurvivesRestarts(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history.db")
+	require.NoError(t, ValidateHistoryBackend(HistoryBackendSQLite))
+
+	history := NewRequestHistory(3)
+	db, err := openHistoryDB(path, nil, history)
+	require.NoError(t, err)
+	start := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i, status := range []int{200, 404, 500, 201} {
+		history.AddRecord(RequestRecord{ID: string(rune('a' + i)), Method: "GET", URL: "http://api.example.com/", ResponseStatus: status, Timestamp: start.Add(time.Duration(i) * time.Second)})
+	}
+	history.UpdateRecord("c", func(record *RequestRecord) { record.Method = "POST" })
+
+	filter, err := ParseRequestFilter(url.Values{"status": {"4xx,5xx"}}, true)
+	require.NoError(t, err)
+	records, err := db.query(filter)
+	require.NoError(t, err)
+	require.Len(t, records, 2)
+	assert.Equal(t, "c", records[0].ID)
+	assert.Equal(t, "POST", records[0].Method)
+	assert.Equal(t, "b", records[1].ID)
+
+	// Streaming stops at the first error
+	var streamed []string
+	stop := errors.New("stop")
+	err = db.each(&RequestFilter{}, func(record RequestRecord) error {
+		streamed = append(streamed, record.ID)
+		if len(streamed) == 2 {
+			return stop
+		}
+		return nil
+	})
+	assert.ErrorIs(t, err, stop)
+	assert.Equal(t, []string{"d", "c"}, streamed)
+
+	history.attach(nil)
+	require.NoError(t, db.close())
+
+	// The oldest record was dropped when history was full
+	restored := NewRequestHistory(2)
+	db, err = openHistoryDB(path, nil, restored)
+	require.NoError(t, err)
+	defer func() { _ = db.close() }()
+	records = restored.GetRecords()
+	require.Len(t, records, 2)
+	assert.Equal(t, "d", records[0].ID)
+	assert.Equal(t, "c", records[1].ID)
+
+	all, err := db.query(&RequestFilter{})
+	require.NoError(t, err)
+	assert.Len(t, all, 2, "records beyond the history size are deleted on restore")
+}
+
+func TestHistoryDBSearch(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history.db")
+	history := NewRequestHistory(10)
+	db, err := openHistoryDB(path, nil, history)
+	require.NoError(t, err)
+	for _, record := range searchRecords(time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)) {
+		history.AddRecord(record)
+	}
+	p := &Proxy{history: history, historyStore: db}
+
+	search := func(q string) []string {
+		query, err := parseSearchQuery(q)
+		require.NoError(t, err)
+		results, err := p.searchHistory(query, &RequestFilter{})
+		require.NoError(t, err)
+		ids := make([]string, len(results))
+		for i, result := range results {
+			ids[i] = result.Record.ID
+		}
+		return ids
+	}
+
+	// Built with -tags sqlite_fts5 the index answers; otherwise history is scanned
+	t.Logf("full-text index: %v", db.fts)
+	assert.Equal(t, []string{"invoice", "order"}, search("ACME"))
+	assert.Equal(t, []string{"order"}, search("acme created"))
+	assert.Equal(t, []string{"health"}, search("ok"), "terms too short for the index are scanned for")
+
+	history.UpdateRecord("health", func(record *RequestRecord) { record.ResponseBody = "degraded" })
+	assert.Equal(t, []string{"health"}, search("degraded"))
+	history.RemoveRecord("order")
+	assert.Equal(t, []string{"invoice"}, search("acme"))
+
+	history.attach(nil)
+	require.NoError(t, db.close())
+
+	// The index is rebuilt for databases it is missing from
+	if db.fts {
+		raw, err := sql.Open(sqliteDriver, path)
+		require.NoError(t, err)
+		_, err = raw.Exec("DELETE FROM records_fts")
+		require.NoError(t, err)
+		require.NoError(t, raw.Close())
+	}
+	restored := NewRequestHistory(10)
+	db, err = openHistoryDB(path, nil, restored)
+	require.NoError(t, err)
+	defer func() { _ = db.close() }()
+	p = &Proxy{history: restored, historyStore: db}
+	assert.Equal(t, []string{"invoice"}, search("acme"))
+}
+
+func TestHistoryDBEncryption(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history.db")
+	provider := envHistoryKey(t, "database passphrase")
+
+	history := NewRequestHistory(10)
+	db, err := openHistoryDB(path, provider, history)
+	require.NoError(t, err)
+	assert.False(t, db.fts, "encrypted databases are not indexed")
+	history.AddRecord(RequestRecord{ID: "a", Method: "POST", URL: "http://api.example.com/login", ResponseStatus: 200, RequestBody: "password=hunter2", Timestamp: time.Now()})
+	history.attach(nil)
+	require.NoError(t, db.close())
+
+	raw, err := sql.Open(sqliteDriver, path)
+	require.NoError(t, err)
+	var stored, storedURL string
+	require.NoError(t, raw.QueryRow("SELECT record, url FROM records WHERE id = 'a'").Scan(&stored, &storedURL))
+	assert.NotContains(t, stored, "hunter2")
+	assert.NotContains(t, stored, "api.example.com")
+	assert.Empty(t, storedURL)
+	require.NoError(t, raw.Close())
+
+	restored := NewRequestHistory(10)
+	db, err = openHistoryDB(path, provider, restored)
+	require.NoError(t, err)
+	require.Len(t, restored.GetRecords(), 1)
+	assert.Equal(t, "password=hunter2", restored.GetRecords()[0].RequestBody)
+	records, err := db.query(&RequestFilter{Methods: []string{"POST"}})
+	require.NoError(t, err)
+	assert.Len(t, records, 1)
+	restored.attach(nil)
+	require.NoError(t, db.close())
+
+	_, err = openHistoryDB(path, nil, NewRequestHistory(10))
+	assert.ErrorContains(t, err, "no history key is set")
+	_, err = openHistoryDB(path, envHistoryKey(t, "wrong"), NewRequestHistory(10))
+	assert.ErrorContains(t, err, "failed to unwrap history data key")
+}
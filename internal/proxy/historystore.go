@@ -0,0 +1,282 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// History backends
+const (
+	HistoryBackendMemory = "memory" // Kept in memory, optionally snapshotted to --history-file (default)
+	HistoryBackendSQLite = "sqlite" // Every change written to a SQLite database
+	HistoryBackendBolt   = "bolt"   // Every change written to an embedded bbolt key-value file
+	HistoryBackendRedis  = "redis"  // Every change written to Redis shared by a fleet of instances
+)
+
+// historyWriterBatchSize is the most changes a backend writes at once
+const historyWriterBatchSize = 256
+
+// historyWriterQueueSize is the most changes waiting for a backend; changes
+// made while the queue is full are dropped
+const historyWriterQueueSize = 1024
+
+// historyExpiryInterval is how often records older than the history TTL are removed
+const historyExpiryInterval = time.Minute
+
+// ValidateHistoryBackend checks that history can be kept in a backend
+func ValidateHistoryBackend(backend string) error {
+	switch backend {
+	case "", HistoryBackendMemory, HistoryBackendBolt, HistoryBackendRedis:
+		return nil
+	case HistoryBackendSQLite:
+		if !slices.Contains(sql.Drivers(), sqliteDriver) {
+			return fmt.Errorf("this binary was built without SQLite support (build with -tags sqlite, or use bolt)")
+		}
+		return nil
+	}
+	return fmt.Errorf("unknown history backend %q (expected memory, sqlite, bolt, or redis)", backend)
+}
+
+// historyJournal is told about every change to history, so a backend can
+// keep the same records
+type historyJournal interface {
+	recordPut(record RequestRecord) // Added or updated
+	recordsRemoved(ids []string)
+	cleared()
+}
+
+// historyStore keeps the records of history outside memory. A durable store
+// restores them into history when opened; every store is then told about
+// every change.
+type historyStore interface {
+	historyJournal
+	query(filter *RequestFilter) ([]RequestRecord, error) // Matching records, most recent first
+	shared() bool                                         // Other instances write to the same store
+	close() error
+}
+
+// historyStreamer is a historyStore that can hand out matching records one at
+// a time, so exports do not hold them all in memory
+type historyStreamer interface {
+	each(filter *RequestFilter, fn func(RequestRecord) error) error // Matching records, most recent first, until fn fails
+}
+
+// openHistoryStore opens the store of a backend at location, a file path or
+// a Redis URL, or returns nil for the memory backend. With a key provider,
+// stores keep their records encrypted.
+func openHistoryStore(backend, location string, provider KeyProvider, history *RequestHistory) (historyStore, error) {
+	var store historyStore
+	var err error
+	switch backend {
+	case HistoryBackendSQLite:
+		store, err = openHistoryDB(location, provider, history)
+	case HistoryBackendBolt:
+		store, err = openHistoryBolt(location, provider, history)
+	case HistoryBackendRedis:
+		store, err = openHistoryRedis(location, provider, history)
+	default:
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return store, nil
+}
+
+// recordCodec encodes the records a backend stores: as JSON, or sealed like
+// history file records under a data key wrapped by a key provider
+type recordCodec struct {
+	sealer *recordSealer // Set when records are encrypted
+}
+
+// newRecordCodec returns the codec of a store whose wrapped data key, if it
+// has one, is stored. A data key is generated for a store without one when a
+// provider is set, and returned so the store can save it.
+func newRecordCodec(provider KeyProvider, stored *historyFileKey) (recordCodec, *historyFileKey, error) {
+	switch {
+	case stored != nil && provider == nil:
+		return recordCodec{}, nil, fmt.Errorf("history is encrypted with %s but no history key is set", stored.Provider)
+	case stored != nil:
+		dataKey, err := provider.Unwrap(stored.WrappedKey)
+		if err != nil {
+			return recordCodec{}, nil, fmt.Errorf("failed to unwrap history data key: %v", err)
+		}
+		return recordCodec{sealer: &recordSealer{provider: provider, dataKey: dataKey, wrapped: stored.WrappedKey}}, nil, nil
+	case provider != nil:
+		sealer := &recordSealer{provider: provider}
+		if err := sealer.ensureDataKey(); err != nil {
+			return recordCodec{}, nil, err
+		}
+		return recordCodec{sealer: sealer}, &historyFileKey{Provider: provider.Name(), WrappedKey: sealer.wrapped}, nil
+	}
+	return recordCodec{}, nil, nil
+}
+
+// encrypted reports whether records are sealed
+func (rc recordCodec) encrypted() bool {
+	return rc.sealer != nil
+}
+
+func (rc recordCodec) encode(record RequestRecord) ([]byte, error) {
+	if rc.sealer == nil {
+		return json.Marshal(record)
+	}
+	sealed, err := rc.sealer.seal(record)
+	return []byte(sealed), err
+}
+
+// decode reads a stored record. Records stored as JSON before a key was set
+// are read as they are; sealed records are base64, which never starts with {.
+func (rc recordCodec) decode(data []byte) (RequestRecord, error) {
+	if rc.sealer != nil && !bytes.HasPrefix(data, []byte("{")) {
+		return rc.sealer.open(string(data))
+	}
+	var record RequestRecord
+	if err := json.Unmarshal(data, &record); err != nil {
+		return record, fmt.Errorf("invalid record: %v", err)
+	}
+	return record, nil
+}
+
+// historyOp is one change waiting to be written to a backend
+type historyOp struct {
+	put     *RequestRecord
+	removed []string
+	clear   bool
+	synced  chan struct{} // Closed once every earlier change is written
+}
+
+// historyWriter queues changes in the order history made them and hands them
+// to apply in batches from a background goroutine, so recording a request
+// does not wait on the disk. History reports changes while holding its lock,
+// so a backend too slow to keep up loses changes rather than stalling the
+// proxy.
+type historyWriter struct {
+	ops     chan historyOp
+	done    chan struct{}
+	apply   func([]historyOp) error
+	dropped atomic.Int64 // Changes dropped since the last batch was written
+}
+
+func newHistoryWriter(apply func([]historyOp) error) *historyWriter {
+	hw := &historyWriter{ops: make(chan historyOp, historyWriterQueueSize), done: make(chan struct{}), apply: apply}
+	go hw.write()
+	return hw
+}
+
+func (hw *historyWriter) recordPut(record RequestRecord) {
+	hw.queue(historyOp{put: &record})
+}
+
+func (hw *historyWriter) recordsRemoved(ids []string) {
+	hw.queue(historyOp{removed: ids})
+}
+
+func (hw *historyWriter) cleared() {
+	hw.queue(historyOp{clear: true})
+}
+
+// queue adds a change without waiting, dropping it when the queue is full
+func (hw *historyWriter) queue(op historyOp) {
+	select {
+	case hw.ops <- op:
+	default:
+		if hw.dropped.Add(1) == 1 {
+			slog.Warn("History backend is falling behind, dropping changes until it catches up")
+		}
+	}
+}
+
+// sync waits until every change queued so far is written
+func (hw *historyWriter) sync() {
+	synced := make(chan struct{})
+	hw.ops <- historyOp{synced: synced}
+	<-synced
+}
+
+// stop writes the remaining changes
+func (hw *historyWriter) stop() {
+	close(hw.ops)
+	<-hw.done
+}
+
+// write applies queued changes until the queue is closed
+func (hw *historyWriter) write() {
+	defer close(hw.done)
+	for op := range hw.ops {
+		batch := []historyOp{op}
+	collect:
+		for len(batch) < historyWriterBatchSize {
+			select {
+			case op, ok := <-hw.ops:
+				if !ok {
+					break collect
+				}
+				batch = append(batch, op)
+			default:
+				break collect
+			}
+		}
+
+		if err := hw.apply(batch); err != nil {
+			slog.Warn("Error writing history", "error", err)
+		}
+		if dropped := hw.dropped.Swap(0); dropped > 0 {
+			slog.Warn("History backend dropped changes it could not keep up with", "dropped", dropped)
+		}
+		for _, op := range batch {
+			if op.synced != nil {
+				close(op.synced)
+			}
+		}
+	}
+}
+
+// historyExpiry removes records older than the history TTL in the
+// background, and with them their copies in the history backend
+type historyExpiry struct {
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// startHistoryExpiry removes expired records now and then every
+// historyExpiryInterval
+func startHistoryExpiry(ttl time.Duration, history *RequestHistory) *historyExpiry {
+	expire := func() {
+		if removed := history.RemoveOlderThan(time.Now().Add(-ttl)); removed > 0 {
+			slog.Info("Removed expired history records", "records", removed, "ttl", ttl.String())
+		}
+	}
+	expire()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	he := &historyExpiry{cancel: cancel}
+	he.wg.Add(1)
+	go func() {
+		defer he.wg.Done()
+		ticker := time.NewTicker(historyExpiryInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				expire()
+			}
+		}
+	}()
+	return he
+}
+
+func (he *historyExpiry) stop() {
+	he.cancel()
+	he.wg.Wait()
+}
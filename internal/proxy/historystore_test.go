@@ -0,0 +1,113 @@
+//go:build unit
+
+package proxy
+
+import (
+	"fmt"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// recordingJournal lists the changes history reports
+type recordingJournal struct{ changes []string }
+
+func (j *recordingJournal) recordPut(record RequestRecord) {
+	j.changes = append(j.changes, "put "+record.ID)
+}
+
+func (j *recordingJournal) recordsRemoved(ids []string) {
+	j.changes = append(j.changes, fmt.Sprintf("remove %v", ids))
+}
+
+func (j *recordingJournal) cleared() {
+	j.changes = append(j.changes, "clear")
+}
+
+func TestHistoryJournal(t *testing.T) {
+	history := NewRequestHistory(2)
+	journal := &recordingJournal{}
+	history.attach(journal)
+
+	history.AddRecord(RequestRecord{ID: "a"})
+	history.AddRecord(RequestRecord{ID: "b"})
+	history.AddRecord(RequestRecord{ID: "c"}) // Overwrites a
+	history.UpdateRecord("b", func(record *RequestRecord) { record.ResponseStatus = 200 })
+	history.RemoveRecords(func(record RequestRecord) bool { return record.ID == "c" })
+	history.SetMaxSize(0)
+	history.Clear()
+
+	assert.Equal(t, []string{"put a", "put b", "remove [a]", "put c", "put b", "remove [c]", "remove [b]", "clear"}, journal.changes)
+
+	history.attach(nil)
+	history.AddRecord(RequestRecord{ID: "d"})
+	assert.Len(t, journal.changes, 8, "a detached journal is not told about changes")
+}
+
+func TestHistoryWriterDoesNotBlockHistory(t *testing.T) {
+	// A backend that hangs until released
+	release := make(chan struct{})
+	var written atomic.Int64
+	writer := newHistoryWriter(func(batch []historyOp) error {
+		<-release
+		written.Add(int64(len(batch)))
+		return nil
+	})
+	history := NewRequestHistory(10)
+	history.attach(writer)
+
+	recorded := make(chan struct{})
+	go func() {
+		defer close(recorded)
+		for i := 0; i < 2*historyWriterQueueSize; i++ {
+			history.AddRecord(RequestRecord{ID: fmt.Sprint(i)})
+		}
+		history.Clear()
+	}()
+	select {
+	case <-recorded:
+	case <-time.After(5 * time.Second):
+		t.Fatal("recording requests waited on the history backend")
+	}
+	assert.Empty(t, history.GetRecords())
+	assert.Positive(t, writer.dropped.Load())
+
+	close(release)
+	writer.stop()
+	assert.Positive(t, written.Load())
+	assert.Less(t, written.Load(), int64(2*historyWriterQueueSize))
+	assert.Zero(t, writer.dropped.Load(), "dropped changes are reported once the backend catches up")
+}
+
+func TestValidateHistoryBackend(t *testing.T) {
+	assert.NoError(t, ValidateHistoryBackend(""))
+	assert.NoError(t, ValidateHistoryBackend(HistoryBackendMemory))
+	assert.NoError(t, ValidateHistoryBackend(HistoryBackendBolt))
+	assert.Error(t, ValidateHistoryBackend("postgres"))
+}
+
+func TestHistoryExpiry(t *testing.T) {
+	history := NewRequestHistory(10)
+	store, err := openHistoryBolt(filepath.Join(t.TempDir(), "history.bolt"), nil, history)
+	require.NoError(t, err)
+	defer func() { _ = store.close() }()
+
+	now := time.Now()
+	history.AddRecord(RequestRecord{ID: "old", Timestamp: now.Add(-2 * time.Hour)})
+	history.AddRecord(RequestRecord{ID: "new", Timestamp: now.Add(-time.Minute)})
+
+	expiry := startHistoryExpiry(time.Hour, history)
+	expiry.stop()
+
+	records := history.GetRecords()
+	require.Len(t, records, 1)
+	assert.Equal(t, "new", records[0].ID)
+	stored, err := store.query(&RequestFilter{})
+	require.NoError(t, err)
+	require.Len(t, stored, 1, "expired records are removed from the backend too")
+	assert.Equal(t, "new", stored[0].ID)
+}
@@ -0,0 +1,117 @@
+package proxy
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"sort"
+	"time"
+)
+
+// unknownHost labels records whose URL has no host
+const unknownHost = "unknown"
+
+// HostStats is the traffic to one upstream host over a window
+type HostStats struct {
+	Host                 string `json:"host"` // Host and port the request was sent to
+	P50UpstreamLatencyUs int64  `json:"p50_upstream_latency_us"`
+	P95UpstreamLatencyUs int64  `json:"p95_upstream_latency_us"`
+	P99UpstreamLatencyUs int64  `json:"p99_upstream_latency_us"`
+	TrafficStats
+}
+
+// HostStatsReport is a per-host snapshot of history over a window
+type HostStatsReport struct {
+	Start time.Time   `json:"start"`
+	End   time.Time   `json:"end"`
+	Hosts []HostStats `json:"hosts"` // Slowest p95 duration first
+}
+
+// upstreamHost returns the host and port of a record's upstream URL
+func upstreamHost(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Host == "" {
+		return unknownHost
+	}
+	return u.Host
+}
+
+// BuildHostStats summarizes the records in [start, end) per upstream host
+func BuildHostStats(records []RequestRecord, start, end time.Time) HostStatsReport {
+	type hostTotals struct {
+		traffic   trafficAccumulator
+		upstreams []int64
+	}
+	hosts := make(map[string]*hostTotals)
+
+	for _, record := range records {
+		if record.Timestamp.Before(start) || !record.Timestamp.Before(end) {
+			continue
+		}
+		host := upstreamHost(record.URL)
+		totals := hosts[host]
+		if totals == nil {
+			totals = &hostTotals{}
+			hosts[host] = totals
+		}
+		totals.traffic.add(record)
+		totals.upstreams = append(totals.upstreams, record.UpstreamLatencyUs)
+	}
+
+	report := HostStatsReport{Start: start, End: end, Hosts: make([]HostStats, 0, len(hosts))}
+	for host, totals := range hosts {
+		report.Hosts = append(report.Hosts, HostStats{
+			Host:                 host,
+			P50UpstreamLatencyUs: percentile(totals.upstreams, 50),
+			P95UpstreamLatencyUs: percentile(totals.upstreams, 95),
+			P99UpstreamLatencyUs: percentile(totals.upstreams, 99),
+			TrafficStats:         totals.traffic.stats(),
+		})
+	}
+	sort.Slice(report.Hosts, func(i, j int) bool {
+		hi, hj := report.Hosts[i], report.Hosts[j]
+		if hi.P95DurationUs != hj.P95DurationUs {
+			return hi.P95DurationUs > hj.P95DurationUs
+		}
+		return hi.Host < hj.Host
+	})
+	return report
+}
+
+// handleHostStats reports request counts, error rates, and latency
+// percentiles per upstream host
+func (p *Proxy) handleHostStats(w http.ResponseWriter, r *http.Request) {
+	// Add CORS headers
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, Cache-Control, Pragma, Expires")
+
+	// Handle preflight requests
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	start, end, err := parseReportWindow(r.URL.Query().Get("window"), 24*time.Hour, time.Now())
+	if err != nil {
+		http.Error(w, "Invalid window: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	data, err := json.Marshal(BuildHostStats(p.history.GetRecords(), start, end))
+	if err != nil {
+		http.Error(w, "Failed to get host stats", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if _, err := w.Write(data); err != nil {
+		slog.Warn("Error writing host stats response", "error", err)
+	}
+}
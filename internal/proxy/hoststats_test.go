@@ -0,0 +1,74 @@
+//go:build unit
+
+package proxy
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func hostRecords(now time.Time) []RequestRecord {
+	return []RequestRecord{
+		{URL: "http://api:8080/users", ResponseStatus: 200, Success: true, TotalDurationUs: 10000, UpstreamLatencyUs: 9000, Timestamp: now.Add(-time.Minute)},
+		{URL: "http://api:8080/users", ResponseStatus: 503, Success: true, TotalDurationUs: 20000, UpstreamLatencyUs: 19000, Timestamp: now.Add(-time.Minute)},
+		{URL: "http://search:9200/q", ResponseStatus: 200, Success: true, TotalDurationUs: 900000, UpstreamLatencyUs: 890000, Timestamp: now.Add(-2 * time.Minute)},
+		{URL: "/inbox", Success: true, TotalDurationUs: 100, Timestamp: now.Add(-2 * time.Minute)},
+		{URL: "http://api:8080/old", ResponseStatus: 200, Success: true, TotalDurationUs: 10000, Timestamp: now.Add(-3 * time.Hour)},
+	}
+}
+
+func TestBuildHostStats(t *testing.T) {
+	now := time.Now()
+	report := BuildHostStats(hostRecords(now), now.Add(-time.Hour), now)
+	require.Len(t, report.Hosts, 3)
+
+	search := report.Hosts[0]
+	assert.Equal(t, "search:9200", search.Host, "slowest host first")
+	assert.Equal(t, int64(890000), search.P95UpstreamLatencyUs)
+
+	api := report.Hosts[1]
+	assert.Equal(t, "api:8080", api.Host)
+	assert.Equal(t, 2, api.Count, "records outside the window are skipped")
+	assert.Equal(t, 1, api.ErrorCount)
+	assert.Equal(t, 0.5, api.ErrorRate)
+	assert.Equal(t, int64(10000), api.P50DurationUs)
+	assert.Equal(t, int64(19000), api.P99UpstreamLatencyUs)
+
+	assert.Equal(t, unknownHost, report.Hosts[2].Host)
+}
+
+func TestHostStatsAPI(t *testing.T) {
+	p := New(&Config{})
+	p.history.restore(hostRecords(time.Now()))
+
+	rec := httptest.NewRecorder()
+	p.handleHostStats(rec, httptest.NewRequest(http.MethodGet, "/requests/stats/hosts?window=1h", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	var report HostStatsReport
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &report))
+	assert.Len(t, report.Hosts, 3)
+
+	rec = httptest.NewRecorder()
+	p.handleHostStats(rec, httptest.NewRequest(http.MethodGet, "/requests/stats/hosts?window=bad", nil))
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	rec = httptest.NewRecorder()
+	p.handleHostStats(rec, httptest.NewRequest(http.MethodPost, "/requests/stats/hosts", nil))
+	assert.Equal(t, http.StatusMethodNotAllowed, rec.Code)
+}
+
+func TestHostMetricsCap(t *testing.T) {
+	m := newTrafficMetrics()
+	for i := 0; i < maxMetricHosts+5; i++ {
+		m.observeRecord(&RequestRecord{URL: "http://host" + strconv.Itoa(i) + "/", ResponseStatus: 200, Success: true})
+	}
+	assert.Len(t, m.hosts, maxMetricHosts+1)
+	assert.Equal(t, int64(5), m.hosts[otherHosts].requests)
+}
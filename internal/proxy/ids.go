@@ -0,0 +1,170 @@
+package proxy
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Request ID formats
+const (
+	IDRandom    = "random"    // 32 random hex characters (default)
+	IDUUIDv7    = "uuidv7"    // Time-ordered RFC 9562 UUIDs
+	IDULID      = "ulid"      // Time-ordered, Crockford base32 ULIDs
+	IDSnowflake = "snowflake" // 64-bit Twitter-style snowflake IDs, as decimal
+)
+
+// snowflakeEpoch is the Twitter snowflake epoch, in Unix milliseconds
+const snowflakeEpoch = 1288834974657
+
+// IDGenerator creates the IDs of request records
+type IDGenerator interface {
+	NewID() string
+}
+
+// ParseIDGenerator parses a request ID format: random, uuidv7, ulid, or
+// snowflake[:NODE] with a node ID from 0 to 1023 (default: 0)
+func ParseIDGenerator(spec string) (IDGenerator, error) {
+	format, node, hasNode := strings.Cut(spec, ":")
+	if hasNode && format != IDSnowflake {
+		return nil, fmt.Errorf("only snowflake IDs take a node, got %q", spec)
+	}
+
+	switch format {
+	case IDRandom:
+		return randomIDs{}, nil
+	case IDUUIDv7:
+		return uuidV7IDs{}, nil
+	case IDULID:
+		return ulidIDs{}, nil
+	case IDSnowflake:
+		generator := &snowflakeIDs{}
+		if hasNode {
+			id, err := strconv.ParseInt(node, 10, 64)
+			if err != nil || id < 0 || id > 1023 {
+				return nil, fmt.Errorf("snowflake node must be 0-1023, got %q", node)
+			}
+			generator.node = id
+		}
+		return generator, nil
+	default:
+		return nil, fmt.Errorf("unknown ID format %q (expected random, uuidv7, ulid, or snowflake)", spec)
+	}
+}
+
+// randomIDs generates the default random hex IDs
+type randomIDs struct{}
+
+func (randomIDs) NewID() string {
+	return generateID()
+}
+
+// uuidV7IDs generates UUIDv7s: a millisecond timestamp followed by random bits
+type uuidV7IDs struct{}
+
+func (uuidV7IDs) NewID() string {
+	var id [16]byte
+	if _, err := rand.Read(id[6:]); err != nil {
+		return generateID()
+	}
+	milli := uint64(time.Now().UnixMilli())
+	id[0], id[1], id[2] = byte(milli>>40), byte(milli>>32), byte(milli>>24)
+	id[3], id[4], id[5] = byte(milli>>16), byte(milli>>8), byte(milli)
+	id[6] = id[6]&0x0f | 0x70 // Version 7
+	id[8] = id[8]&0x3f | 0x80 // RFC 9562 variant
+
+	text := hex.EncodeToString(id[:])
+	return text[0:8] + "-" + text[8:12] + "-" + text[12:16] + "-" + text[16:20] + "-" + text[20:]
+}
+
+// crockfordBase32 is the ULID alphabet
+const crockfordBase32 = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+// ulidIDs generates ULIDs: a 48-bit millisecond timestamp and 80 random bits
+type ulidIDs struct{}
+
+func (ulidIDs) NewID() string {
+	var id [16]byte
+	if _, err := rand.Read(id[6:]); err != nil {
+		return generateID()
+	}
+	milli := uint64(time.Now().UnixMilli())
+	binary.BigEndian.PutUint16(id[4:6], uint16(milli))
+	binary.BigEndian.PutUint32(id[0:4], uint32(milli>>16))
+
+	// Encode the 128 bits as 26 characters, 5 bits at a time from the end
+	hi, lo := binary.BigEndian.Uint64(id[:8]), binary.BigEndian.Uint64(id[8:])
+	text := make([]byte, 26)
+	for i := len(text) - 1; i >= 0; i-- {
+		text[i] = crockfordBase32[lo&0x1f]
+		lo = lo>>5 | hi<<59
+		hi >>= 5
+	}
+	return string(text)
+}
+
+// snowflakeIDs generates 64-bit IDs from a millisecond timestamp, a node ID,
+// and a per-millisecond sequence
+type snowflakeIDs struct {
+	mutex    sync.Mutex
+	node     int64
+	last     int64
+	sequence int64
+}
+
+func (s *snowflakeIDs) NewID() string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	now := time.Now().UnixMilli() - snowflakeEpoch
+	if now < s.last {
+		// Keep IDs increasing if the clock steps back
+		now = s.last
+	}
+	if now == s.last {
+		s.sequence = (s.sequence + 1) & 0xfff
+		if s.sequence == 0 {
+			// Sequence exhausted, move on to the next millisecond
+			for now <= s.last {
+				time.Sleep(100 * time.Microsecond)
+				now = time.Now().UnixMilli() - snowflakeEpoch
+			}
+		}
+	} else {
+		s.sequence = 0
+	}
+	s.last = now
+
+	return strconv.FormatInt(now<<22|s.node<<12|s.sequence, 10)
+}
+
+type requestIDKey struct{}
+
+// withRequestID has the proxy record a request under an ID chosen up front
+func withRequestID(req *http.Request, id string) *http.Request {
+	return req.WithContext(context.WithValue(req.Context(), requestIDKey{}, id))
+}
+
+// requestIDFor returns the ID a request is recorded under: the one chosen
+// with withRequestID, or else a new one
+func (p *Proxy) requestIDFor(r *http.Request) string {
+	if id, ok := r.Context().Value(requestIDKey{}).(string); ok {
+		return id
+	}
+	return p.newRequestID()
+}
+
+// newRequestID returns an ID for a new request record in the configured format
+func (p *Proxy) newRequestID() string {
+	if p.currentConfig().IDGenerator != nil {
+		return p.currentConfig().IDGenerator.NewID()
+	}
+	return generateID()
+}
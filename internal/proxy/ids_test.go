@@ -0,0 +1,74 @@
+//go:build unit
+
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"sort"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseIDGenerator(t *testing.T) {
+	formats := map[string]*regexp.Regexp{
+		"random":       regexp.MustCompile(`^[0-9a-f]{32}$`),
+		"uuidv7":       regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-7[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`),
+		"ulid":         regexp.MustCompile(`^[0-7][0-9A-HJKMNP-TV-Z]{25}$`),
+		"snowflake":    regexp.MustCompile(`^[0-9]+$`),
+		"snowflake:42": regexp.MustCompile(`^[0-9]+$`),
+	}
+	for spec, pattern := range formats {
+		generator, err := ParseIDGenerator(spec)
+		require.NoError(t, err, spec)
+		assert.Regexp(t, pattern, generator.NewID(), spec)
+	}
+
+	for _, spec := range []string{"", "uuid", "ulid:1", "snowflake:1024", "snowflake:x"} {
+		_, err := ParseIDGenerator(spec)
+		assert.Error(t, err, spec)
+	}
+}
+
+func TestTimeOrderedIDsSort(t *testing.T) {
+	for _, spec := range []string{"uuidv7", "ulid"} {
+		generator, err := ParseIDGenerator(spec)
+		require.NoError(t, err)
+		first := generator.NewID()
+		time.Sleep(2 * time.Millisecond)
+		second := generator.NewID()
+		assert.Less(t, first, second, "%s IDs sort by creation time", spec)
+	}
+
+	generator, err := ParseIDGenerator("snowflake:5")
+	require.NoError(t, err)
+	ids := make([]int64, 5000)
+	for i := range ids {
+		ids[i], err = strconv.ParseInt(generator.NewID(), 10, 64)
+		require.NoError(t, err)
+	}
+	assert.True(t, sort.SliceIsSorted(ids, func(i, j int) bool { return ids[i] < ids[j] }))
+	for i := 1; i < len(ids); i++ {
+		require.NotEqual(t, ids[i-1], ids[i], "snowflake IDs are unique within a millisecond")
+	}
+	assert.Equal(t, int64(5), ids[0]>>12&0x3ff, "the node ID is embedded")
+}
+
+func TestProxyUsesConfiguredIDFormat(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer upstream.Close()
+
+	generator, err := ParseIDGenerator("ulid")
+	require.NoError(t, err)
+	p := New(&Config{IDGenerator: generator})
+
+	p.handleHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, upstream.URL+"/", nil))
+	records := p.history.GetRecords()
+	require.Len(t, records, 1)
+	assert.Len(t, records[0].ID, 26)
+}
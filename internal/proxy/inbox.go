@@ -0,0 +1,229 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Inbox delivery states
+const (
+	InboxPending   = "pending"
+	InboxDelivered = "delivered"
+	InboxFailed    = "failed"
+)
+
+// maxInboxBackoff caps the delay between delivery attempts
+const maxInboxBackoff = time.Minute
+
+// inboxHopHeaders are not copied when forwarding a captured webhook
+var inboxHopHeaders = map[string]bool{
+	"Connection":        true,
+	"Keep-Alive":        true,
+	"Proxy-Connection":  true,
+	"Transfer-Encoding": true,
+	"Upgrade":           true,
+	"Te":                true,
+	"Content-Length":    true,
+}
+
+// webhookInbox delivers captured webhooks to the local forward target in the background
+type webhookInbox struct {
+	client *http.Client
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+func newWebhookInbox() *webhookInbox {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &webhookInbox{
+		client: &http.Client{Timeout: 30 * time.Second},
+		ctx:    ctx,
+		cancel: cancel,
+	}
+}
+
+// stop abandons pending retries and waits for in-flight deliveries
+func (in *webhookInbox) stop() {
+	in.cancel()
+	in.wg.Wait()
+}
+
+// isInboxRequest reports whether the request was sent to the inbox path
+func (p *Proxy) isInboxRequest(r *http.Request) bool {
+	return p.currentConfig().InboxPath != "" && !r.URL.IsAbs() && strings.HasPrefix(r.URL.Path, p.currentConfig().InboxPath)
+}
+
+// handleInbox accepts any webhook sent to the inbox path, stores it in history,
+// acknowledges it immediately, and forwards it to the local target if one is set
+func (p *Proxy) handleInbox(w http.ResponseWriter, r *http.Request) {
+	proxyStartTime := time.Now()
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "Failed to read webhook body", http.StatusBadRequest)
+		return
+	}
+
+	incoming := *r.URL
+	incoming.Host = r.Host
+	record := RequestRecord{
+		ID:             p.newRequestID(),
+		Timestamp:      proxyStartTime,
+		Method:         r.Method,
+		URL:            r.URL.String(),
+		RequestHeaders: convertHeaders(r.Header),
+		RequestBody:    string(body),
+		RequestSize:    int64(len(body)),
+		ResponseStatus: http.StatusOK,
+		ProxyStartTime: proxyStartTime,
+		Success:        true,
+		InboxPath:      p.currentConfig().InboxPath,
+	}
+	p.verifyWebhook(&record, r.Header, body, &incoming)
+
+	var target *url.URL
+	if p.currentConfig().InboxForward != "" {
+		target, err = p.inboxTarget(r.URL)
+		if err != nil {
+			record.Success = false
+			record.Error = err.Error()
+		} else {
+			record.InboxTarget = target.String()
+			record.InboxDeliveryStatus = InboxPending
+		}
+	}
+
+	response, err := json.Marshal(map[string]string{"id": record.ID, "status": "received"})
+	if err != nil {
+		http.Error(w, "Failed to encode response", http.StatusInternalServerError)
+		return
+	}
+	record.ResponseHeaders = map[string]string{"Content-Type": "application/json"}
+	record.ResponseBody = string(response)
+	record.ResponseSize = int64(len(response))
+	record.ProxyEndTime = time.Now()
+	p.recordRequest(record)
+
+	if target != nil {
+		p.inbox.wg.Add(1)
+		go p.deliverInbox(record.ID, r.Method, target, r.Header.Clone(), body)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if _, err := w.Write(response); err != nil {
+		slog.Warn("Error writing inbox response", "error", err)
+	}
+}
+
+// inboxTarget maps a request under the inbox path onto the forward target
+func (p *Proxy) inboxTarget(requestURL *url.URL) (*url.URL, error) {
+	forward, err := url.Parse(p.currentConfig().InboxForward)
+	if err != nil || forward.Host == "" {
+		return nil, fmt.Errorf("invalid inbox forward URL")
+	}
+	target := *forward
+	suffix := strings.TrimPrefix(requestURL.Path, p.currentConfig().InboxPath)
+	if suffix != "" && !strings.HasPrefix(suffix, "/") {
+		suffix = "/" + suffix
+	}
+	target.Path = strings.TrimSuffix(forward.Path, "/") + suffix
+	if target.Path == "" {
+		target.Path = "/"
+	}
+	target.RawQuery = requestURL.RawQuery
+	return &target, nil
+}
+
+// deliverInbox forwards a captured webhook, retrying with exponential backoff
+// while the target is unreachable or answers with a 5xx
+func (p *Proxy) deliverInbox(id, method string, target *url.URL, header http.Header, body []byte) {
+	defer p.inbox.wg.Done()
+
+	interval := p.currentConfig().InboxRetryInterval
+	if interval <= 0 {
+		interval = time.Second
+	}
+
+	attempts := p.currentConfig().InboxRetries + 1
+	for attempt := 1; attempt <= attempts; attempt++ {
+		status, err := p.forwardInbox(method, target, header, body)
+
+		done := err == nil && status < http.StatusInternalServerError
+		p.updateRecord(id, func(record *RequestRecord) {
+			record.InboxDeliveryAttempts = attempt
+			record.InboxTargetStatus = status
+			record.InboxDeliveryError = ""
+			if err != nil {
+				record.InboxDeliveryError = err.Error()
+			} else if !done {
+				record.InboxDeliveryError = fmt.Sprintf("target responded with %d", status)
+			}
+			switch {
+			case done:
+				record.InboxDeliveryStatus = InboxDelivered
+			case attempt == attempts:
+				record.InboxDeliveryStatus = InboxFailed
+			}
+		})
+		if done {
+			slog.Debug("Inbox webhook delivered", "id", id, "target", target, "status", status, "attempts", attempt)
+			return
+		}
+		if attempt == attempts {
+			break
+		}
+
+		select {
+		case <-time.After(interval):
+		case <-p.inbox.ctx.Done():
+			p.updateRecord(id, func(record *RequestRecord) {
+				record.InboxDeliveryStatus = InboxFailed
+			})
+			return
+		}
+		if interval *= 2; interval > maxInboxBackoff {
+			interval = maxInboxBackoff
+		}
+	}
+	slog.Error("Inbox webhook could not be delivered", "id", id, "target", target, "attempts", attempts)
+}
+
+func (p *Proxy) forwardInbox(method string, target *url.URL, header http.Header, body []byte) (int, error) {
+	req, err := http.NewRequestWithContext(p.inbox.ctx, method, target.String(), bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	for key, values := range header {
+		if inboxHopHeaders[key] {
+			continue
+		}
+		for _, value := range values {
+			req.Header.Add(key, value)
+		}
+	}
+
+	resp, err := p.inbox.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		if closeErr := resp.Body.Close(); closeErr != nil {
+			slog.Warn("Error closing inbox response body", "error", closeErr)
+		}
+	}()
+	if _, err := io.Copy(io.Discard, resp.Body); err != nil {
+		slog.Warn("Error draining inbox response body", "error", err)
+	}
+	return resp.StatusCode, nil
+}
@@ -0,0 +1,143 @@
+//go:build unit
+
+package proxy
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func sendToInbox(t *testing.T, p *Proxy, path, body string) RequestRecord {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Event", "order.created")
+	rec := httptest.NewRecorder()
+	p.ServeHTTP(rec, req)
+
+	require.Equal(t, http.StatusOK, rec.Code)
+	var ack map[string]string
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &ack))
+	assert.Equal(t, "received", ack["status"])
+
+	records := p.history.GetRecords()
+	require.NotEmpty(t, records)
+	assert.Equal(t, ack["id"], records[0].ID)
+	return records[0]
+}
+
+func waitForDelivery(t *testing.T, p *Proxy, id string) RequestRecord {
+	t.Helper()
+	var record RequestRecord
+	require.Eventually(t, func() bool {
+		for _, r := range p.history.GetRecords() {
+			if r.ID == id {
+				record = r
+			}
+		}
+		return record.InboxDeliveryStatus != InboxPending
+	}, 5*time.Second, 10*time.Millisecond)
+	return record
+}
+
+func TestInboxCaptureOnly(t *testing.T) {
+	p := New(&Config{InboxPath: "/inbox/"})
+	defer func() { require.NoError(t, p.Stop()) }()
+
+	record := sendToInbox(t, p, "/inbox/stripe?attempt=1", `{"id":"evt_1"}`)
+	assert.Equal(t, "/inbox/stripe?attempt=1", record.URL)
+	assert.Equal(t, `{"id":"evt_1"}`, record.RequestBody)
+	assert.Equal(t, "order.created", record.RequestHeaders["X-Event"])
+	assert.Equal(t, http.StatusOK, record.ResponseStatus)
+	assert.Empty(t, record.InboxDeliveryStatus)
+	assert.True(t, record.Success)
+}
+
+func TestInboxForward(t *testing.T) {
+	type delivery struct {
+		path, body, event string
+	}
+	received := make(chan delivery, 1)
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+		received <- delivery{r.URL.RequestURI(), string(body), r.Header.Get("X-Event")}
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer target.Close()
+
+	p := New(&Config{InboxPath: "/inbox/", InboxForward: target.URL + "/webhooks"})
+	defer func() { require.NoError(t, p.Stop()) }()
+
+	record := sendToInbox(t, p, "/inbox/stripe?attempt=1", `{"id":"evt_1"}`)
+	assert.Equal(t, target.URL+"/webhooks/stripe?attempt=1", record.InboxTarget)
+
+	got := <-received
+	assert.Equal(t, "/webhooks/stripe?attempt=1", got.path)
+	assert.Equal(t, `{"id":"evt_1"}`, got.body)
+	assert.Equal(t, "order.created", got.event)
+
+	record = waitForDelivery(t, p, record.ID)
+	assert.Equal(t, InboxDelivered, record.InboxDeliveryStatus)
+	assert.Equal(t, http.StatusAccepted, record.InboxTargetStatus)
+	assert.Equal(t, 1, record.InboxDeliveryAttempts)
+}
+
+func TestInboxRetriesUntilTargetIsUp(t *testing.T) {
+	var calls atomic.Int32
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer target.Close()
+
+	p := New(&Config{InboxPath: "/inbox", InboxForward: target.URL, InboxRetries: 3, InboxRetryInterval: time.Millisecond})
+	defer func() { require.NoError(t, p.Stop()) }()
+
+	record := waitForDelivery(t, p, sendToInbox(t, p, "/inbox", `{}`).ID)
+	assert.Equal(t, InboxDelivered, record.InboxDeliveryStatus)
+	assert.Equal(t, 3, record.InboxDeliveryAttempts)
+	assert.Empty(t, record.InboxDeliveryError)
+}
+
+func TestInboxGivesUp(t *testing.T) {
+	// Nothing listens on the target, so every attempt fails to connect
+	target := httptest.NewServer(http.NotFoundHandler())
+	targetURL := target.URL
+	target.Close()
+
+	p := New(&Config{InboxPath: "/inbox/", InboxForward: targetURL, InboxRetries: 2, InboxRetryInterval: time.Millisecond})
+	defer func() { require.NoError(t, p.Stop()) }()
+
+	record := waitForDelivery(t, p, sendToInbox(t, p, "/inbox/x", `{}`).ID)
+	assert.Equal(t, InboxFailed, record.InboxDeliveryStatus)
+	assert.Equal(t, 3, record.InboxDeliveryAttempts)
+	assert.NotEmpty(t, record.InboxDeliveryError)
+}
+
+func TestInboxIgnoresProxiedRequests(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	}))
+	defer upstream.Close()
+
+	p := New(&Config{InboxPath: "/inbox/"})
+	defer func() { require.NoError(t, p.Stop()) }()
+
+	// Absolute-form requests are proxied even when their path matches the inbox
+	rec := httptest.NewRecorder()
+	p.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, upstream.URL+"/inbox/x", nil))
+	assert.Equal(t, http.StatusTeapot, rec.Code)
+}
@@ -0,0 +1,253 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// IP family modes for dialing upstreams
+const (
+	IPFamilyHappyEyeballs = "happy-eyeballs" // Race IPv6 and IPv4 connections (RFC 8305), the default
+	IPFamilyIPv4          = "ipv4"           // Only connect over IPv4
+	IPFamilyIPv6          = "ipv6"           // Only connect over IPv6
+	IPFamilyPreferIPv4    = "prefer-ipv4"    // Race with IPv4 first, IPv6 after the fallback delay
+	IPFamilyPreferIPv6    = "prefer-ipv6"    // Race with IPv6 first, IPv4 after the fallback delay
+)
+
+// DefaultHappyEyeballsDelay is how long a connection attempt gets before one
+// over the other address family starts, as RFC 8305 recommends
+const DefaultHappyEyeballsDelay = 250 * time.Millisecond
+
+// IPFamilyRule sets how connections to upstream hosts matching a pattern
+// choose between IPv4 and IPv6
+type IPFamilyRule struct {
+	Host string // Glob matched against the hostname ignoring case, where * matches any run of characters; empty for every host
+	Mode string // happy-eyeballs, ipv4, ipv6, prefer-ipv4, or prefer-ipv6
+
+	pattern *regexp.Regexp
+}
+
+// ParseIPFamilyRule parses a rule in "[host=]mode" form, e.g. "ipv4" for
+// every upstream or "*.example.com=prefer-ipv6"
+func ParseIPFamilyRule(spec string) (IPFamilyRule, error) {
+	host, mode, ok := strings.Cut(spec, "=")
+	if !ok {
+		host, mode = "", spec
+	}
+	switch mode {
+	case IPFamilyHappyEyeballs, IPFamilyIPv4, IPFamilyIPv6, IPFamilyPreferIPv4, IPFamilyPreferIPv6:
+	default:
+		return IPFamilyRule{}, fmt.Errorf("invalid IP family rule %q: mode must be %s, %s, %s, %s, or %s", spec,
+			IPFamilyHappyEyeballs, IPFamilyIPv4, IPFamilyIPv6, IPFamilyPreferIPv4, IPFamilyPreferIPv6)
+	}
+	if ok && host == "" {
+		return IPFamilyRule{}, fmt.Errorf("invalid IP family rule %q: missing host", spec)
+	}
+	rule := IPFamilyRule{Host: strings.ToLower(host), Mode: mode}
+	if rule.Host != "" {
+		rule.pattern = globPattern(rule.Host)
+	}
+	return rule, nil
+}
+
+// matches reports whether the rule applies to a hostname
+func (rule IPFamilyRule) matches(host string) bool {
+	return rule.pattern == nil || rule.pattern.MatchString(strings.ToLower(host))
+}
+
+// upstreamDialer opens the connections to upstreams, resolving names with
+// the configured resolver and choosing the address family each host's rule
+// asks for
+type upstreamDialer struct {
+	rules  []IPFamilyRule
+	delay  time.Duration
+	dialer *net.Dialer
+	source *SourceRule // Local address connections are bound to; nil for any
+	jumps  *sshJumps   // SSH jump hosts connections to matching hosts go through (optional)
+}
+
+// newUpstreamDialer returns a dialer that resolves names with resolver, or
+// the system resolver when it is nil. A negative delay turns off racing.
+func newUpstreamDialer(rules []IPFamilyRule, delay time.Duration, resolver *net.Resolver) *upstreamDialer {
+	if delay == 0 {
+		delay = DefaultHappyEyeballsDelay
+	}
+	return &upstreamDialer{
+		rules:  rules,
+		delay:  delay,
+		dialer: &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second, FallbackDelay: delay, Resolver: resolver},
+	}
+}
+
+// mode returns the IP family mode of the first rule matching host
+func (d *upstreamDialer) mode(host string) string {
+	for _, rule := range d.rules {
+		if rule.matches(host) {
+			return rule.Mode
+		}
+	}
+	return IPFamilyHappyEyeballs
+}
+
+// resolver returns the resolver names are looked up with
+func (d *upstreamDialer) resolver() *net.Resolver {
+	if d.dialer.Resolver != nil {
+		return d.dialer.Resolver
+	}
+	return net.DefaultResolver
+}
+
+// DialContext connects to addr over the address family its host's rule asks
+// for
+func (d *upstreamDialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	host, _, err := net.SplitHostPort(addr)
+	if err == nil && d.jumps != nil {
+		if jump := d.jumps.jumpFor(host); jump != nil {
+			return d.jumps.dial(ctx, jump, addr)
+		}
+	}
+	if err != nil || network != "tcp" {
+		return d.dial(ctx, network, addr)
+	}
+	switch d.mode(host) {
+	case IPFamilyIPv4:
+		return d.dial(ctx, "tcp4", addr)
+	case IPFamilyIPv6:
+		return d.dial(ctx, "tcp6", addr)
+	case IPFamilyPreferIPv4:
+		return d.race(ctx, "tcp4", "tcp6", addr)
+	case IPFamilyPreferIPv6:
+		return d.race(ctx, "tcp6", "tcp4", addr)
+	default:
+		return d.dial(ctx, network, addr)
+	}
+}
+
+// dial connects to addr over network from the source address, if any
+func (d *upstreamDialer) dial(ctx context.Context, network, addr string) (net.Conn, error) {
+	if d.source == nil {
+		return d.dialer.DialContext(ctx, network, addr)
+	}
+	host, _, _ := net.SplitHostPort(addr)
+	local, err := d.source.localAddr(network, d.mode(host), host)
+	if err != nil {
+		return nil, err
+	}
+	dialer := *d.dialer
+	dialer.LocalAddr = local
+	return dialer.DialContext(ctx, network, addr)
+}
+
+// race connects over the first network, starting the second once the first
+// has failed or the delay has passed, and returns whichever connects first
+func (d *upstreamDialer) race(ctx context.Context, first, second, addr string) (net.Conn, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	type attempt struct {
+		conn net.Conn
+		err  error
+	}
+	attempts := make(chan attempt, 2)
+	dial := func(network string) {
+		conn, err := d.dial(ctx, network, addr)
+		attempts <- attempt{conn, err}
+	}
+	go dial(first)
+	started, pending := 1, 1
+
+	var fallback <-chan time.Time
+	if d.delay > 0 {
+		timer := time.NewTimer(d.delay)
+		defer timer.Stop()
+		fallback = timer.C
+	}
+	var firstErr error
+	for {
+		select {
+		case <-fallback:
+			if started == 1 {
+				go dial(second)
+				started, pending = 2, pending+1
+			}
+		case result := <-attempts:
+			pending--
+			if result.err == nil {
+				// Close the slower connection if it still gets through
+				if pending > 0 {
+					go func() {
+						if late := <-attempts; late.conn != nil {
+							_ = late.conn.Close()
+						}
+					}()
+				}
+				return result.conn, nil
+			}
+			if firstErr == nil {
+				firstErr = result.err
+			}
+			if started == 1 {
+				go dial(second)
+				started, pending = 2, pending+1
+			} else if pending == 0 {
+				return nil, firstErr
+			}
+		}
+	}
+}
+
+// order returns the resolved addresses of host that its rule allows, the
+// preferred family first
+func (d *upstreamDialer) order(host string, addresses []string) []string {
+	var ipv4, ipv6 []string
+	for _, address := range addresses {
+		if ip := net.ParseIP(address); ip != nil && ip.To4() == nil {
+			ipv6 = append(ipv6, address)
+		} else {
+			ipv4 = append(ipv4, address)
+		}
+	}
+	switch d.mode(host) {
+	case IPFamilyIPv4:
+		return ipv4
+	case IPFamilyIPv6:
+		return ipv6
+	case IPFamilyPreferIPv4:
+		return append(ipv4, ipv6...)
+	case IPFamilyPreferIPv6:
+		return append(ipv6, ipv4...)
+	default:
+		return addresses
+	}
+}
+
+// transport returns an HTTP transport that connects with d
+func (d *upstreamDialer) transport() *http.Transport {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = d.DialContext
+	return transport
+}
+
+// connEndpoint returns the address an upstream connection reached and its IP
+// family, or empty strings when it is not known, as for connections through
+// an SSH jump host
+func connEndpoint(conn net.Conn) (addr, family string) {
+	tcp, ok := conn.RemoteAddr().(*net.TCPAddr)
+	if !ok || tcp.IP.IsUnspecified() {
+		return "", ""
+	}
+	return tcp.String(), ipFamily(tcp.IP)
+}
+
+// ipFamily names the address family of an IP address
+func ipFamily(ip net.IP) string {
+	if ip.To4() != nil {
+		return IPFamilyIPv4
+	}
+	return IPFamilyIPv6
+}
@@ -0,0 +1,95 @@
+//go:build unit
+
+package proxy
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseIPFamilyRule(t *testing.T) {
+	rule, err := ParseIPFamilyRule("ipv4")
+	require.NoError(t, err)
+	assert.Equal(t, IPFamilyIPv4, rule.Mode)
+	assert.True(t, rule.matches("api.example.com"), "rules without a host apply to every host")
+
+	rule, err = ParseIPFamilyRule("*.Example.com=prefer-ipv6")
+	require.NoError(t, err)
+	assert.Equal(t, IPFamilyPreferIPv6, rule.Mode)
+	assert.True(t, rule.matches("api.example.com"))
+	assert.False(t, rule.matches("example.org"))
+
+	for _, spec := range []string{"ipv5", "=ipv4", "api.example.com=", "api.example.com=v4"} {
+		_, err := ParseIPFamilyRule(spec)
+		assert.Error(t, err, spec)
+	}
+}
+
+func TestUpstreamDialerOrder(t *testing.T) {
+	ipv6Only, err := ParseIPFamilyRule("v6.example.com=ipv6")
+	require.NoError(t, err)
+	preferIPv4, err := ParseIPFamilyRule("prefer-ipv4")
+	require.NoError(t, err)
+	dialer := newUpstreamDialer([]IPFamilyRule{ipv6Only, preferIPv4}, 0, nil)
+
+	addresses := []string{"2001:db8::1", "192.0.2.1", "2001:db8::2", "192.0.2.2"}
+	assert.Equal(t, []string{"2001:db8::1", "2001:db8::2"}, dialer.order("v6.example.com", addresses))
+	assert.Equal(t, []string{"192.0.2.1", "192.0.2.2", "2001:db8::1", "2001:db8::2"}, dialer.order("api.example.com", addresses))
+	assert.Equal(t, addresses, newUpstreamDialer(nil, 0, nil).order("api.example.com", addresses), "happy eyeballs keeps the resolver's order")
+}
+
+func TestUpstreamDialerFallsBackToOtherFamily(t *testing.T) {
+	ln, err := net.Listen("tcp4", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+	_, port, err := net.SplitHostPort(ln.Addr().String())
+	require.NoError(t, err)
+
+	// Nothing listens on IPv6, so the preferred family fails and IPv4 connects
+	rule, err := ParseIPFamilyRule("prefer-ipv6")
+	require.NoError(t, err)
+	conn, err := newUpstreamDialer([]IPFamilyRule{rule}, 0, nil).DialContext(context.Background(), "tcp", net.JoinHostPort("localhost", port))
+	require.NoError(t, err)
+	defer conn.Close()
+	assert.Equal(t, "127.0.0.1", conn.RemoteAddr().(*net.TCPAddr).IP.String())
+
+	rule, err = ParseIPFamilyRule("ipv6")
+	require.NoError(t, err)
+	_, err = newUpstreamDialer([]IPFamilyRule{rule}, 0, nil).DialContext(context.Background(), "tcp", ln.Addr().String())
+	assert.Error(t, err, "ipv6 only does not connect to an IPv4 address")
+}
+
+func TestProxyRecordsUpstreamEndpoint(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer upstream.Close()
+	parsed, err := url.Parse(upstream.URL)
+	require.NoError(t, err)
+
+	rule, err := ParseIPFamilyRule("localhost=ipv4")
+	require.NoError(t, err)
+	p := New(&Config{IPFamilyRules: []IPFamilyRule{rule}})
+
+	rec := httptest.NewRecorder()
+	p.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "http://localhost:"+parsed.Port()+"/", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	record := p.history.GetRecords()[0]
+	assert.Equal(t, "127.0.0.1:"+parsed.Port(), record.UpstreamAddr)
+	assert.Equal(t, IPFamilyIPv4, record.UpstreamIPFamily)
+}
@@ -0,0 +1,253 @@
+package proxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// latencyWindow is how many recent upstream latencies of a profile's route a
+// request is ranked against
+const latencyWindow = 1000
+
+// latencyWarmup is how many latencies a profile needs before requests are
+// ranked; until then each request draws its target at random
+const latencyWarmup = 20
+
+// LatencyPoint is one percentile of a latency profile
+type LatencyPoint struct {
+	Percentile float64       `json:"percentile"` // From 0 to 100
+	Latency    time.Duration `json:"latency"`
+}
+
+// LatencyProfile makes a route's end-to-end latency follow a target
+// distribution, e.g. the production p50, p95, and p99 of an upstream whose
+// staging copy is much faster. Each response is ranked against the route's
+// recent upstream latencies and held until it reaches the target latency of
+// the same percentile, so fast requests stay the fastest and the observed
+// percentiles match the target ones wherever the upstream is faster.
+type LatencyProfile struct {
+	Route  Route
+	Points []LatencyPoint // By increasing percentile
+
+	spec string
+}
+
+// ParseLatencyProfile parses a profile in "route=pN:DURATION[,pN:DURATION...]"
+// form, e.g. "api.example.com=p50:120ms,p95:800ms,p99:2s", or
+// "route=@FILE" to import the p50, p95, and p99 durations of the route's host
+// from a /requests/stats/hosts report saved from another netkit, e.g. one in
+// front of production
+func ParseLatencyProfile(spec string) (LatencyProfile, error) {
+	route, points, ok := strings.Cut(spec, "=")
+	if !ok || strings.TrimSpace(points) == "" {
+		return LatencyProfile{}, fmt.Errorf("invalid latency profile %q: expected route=pN:DURATION[,pN:DURATION...] or route=@FILE", spec)
+	}
+	profile := LatencyProfile{Route: ParseRoute(strings.TrimSpace(route)), spec: spec}
+
+	if path, ok := strings.CutPrefix(strings.TrimSpace(points), "@"); ok {
+		imported, err := importLatencyPoints(path, profile.Route)
+		if err != nil {
+			return LatencyProfile{}, fmt.Errorf("invalid latency profile %q: %v", spec, err)
+		}
+		profile.Points = imported
+		return profile, nil
+	}
+
+	for _, point := range strings.Split(points, ",") {
+		percentile, latency, ok := strings.Cut(strings.TrimSpace(point), ":")
+		value, err := strconv.ParseFloat(strings.TrimPrefix(percentile, "p"), 64)
+		if !ok || !strings.HasPrefix(percentile, "p") || err != nil || value < 0 || value > 100 {
+			return LatencyProfile{}, fmt.Errorf("invalid latency profile %q: %q is not a percentile from p0 to p100 and a duration, e.g. p95:800ms", spec, point)
+		}
+		duration, err := time.ParseDuration(latency)
+		if err != nil || duration < 0 {
+			return LatencyProfile{}, fmt.Errorf("invalid latency profile %q: invalid duration %q", spec, latency)
+		}
+		profile.Points = append(profile.Points, LatencyPoint{Percentile: value, Latency: duration})
+	}
+	if err := checkLatencyPoints(profile.Points); err != nil {
+		return LatencyProfile{}, fmt.Errorf("invalid latency profile %q: %v", spec, err)
+	}
+	return profile, nil
+}
+
+// checkLatencyPoints requires percentiles to increase and latencies to never
+// go down
+func checkLatencyPoints(points []LatencyPoint) error {
+	for i := 1; i < len(points); i++ {
+		if points[i].Percentile <= points[i-1].Percentile {
+			return fmt.Errorf("percentiles must increase, got p%g after p%g", points[i].Percentile, points[i-1].Percentile)
+		}
+		if points[i].Latency < points[i-1].Latency {
+			return fmt.Errorf("p%g is faster than p%g", points[i].Percentile, points[i-1].Percentile)
+		}
+	}
+	return nil
+}
+
+// importLatencyPoints reads the percentiles of the route's host from a saved
+// host stats report. A route without a host needs a report of one host.
+func importLatencyPoints(path string, route Route) ([]LatencyPoint, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var report HostStatsReport
+	if err := json.Unmarshal(data, &report); err != nil {
+		return nil, fmt.Errorf("%s is not a /requests/stats/hosts report: %v", path, err)
+	}
+
+	var found []HostStats
+	for _, host := range report.Hosts {
+		name := host.Host
+		if hostname, _, err := net.SplitHostPort(name); err == nil {
+			name = hostname
+		}
+		if route.Host == "" || strings.EqualFold(route.Host, host.Host) || strings.EqualFold(route.Host, name) {
+			found = append(found, host)
+		}
+	}
+	switch {
+	case len(found) == 0:
+		return nil, fmt.Errorf("%s has no stats for %s", path, route)
+	case len(found) > 1:
+		return nil, fmt.Errorf("%s has stats for %d hosts; name one in the route", path, len(found))
+	case found[0].Count == 0:
+		return nil, fmt.Errorf("%s has no requests to %s", path, found[0].Host)
+	}
+
+	stats := found[0]
+	points := []LatencyPoint{
+		{Percentile: 50, Latency: time.Duration(stats.P50DurationUs) * time.Microsecond},
+		{Percentile: 95, Latency: time.Duration(stats.P95DurationUs) * time.Microsecond},
+		{Percentile: 99, Latency: time.Duration(stats.P99DurationUs) * time.Microsecond},
+	}
+	if err := checkLatencyPoints(points); err != nil {
+		return nil, err
+	}
+	return points, nil
+}
+
+// String returns the profile as it was given
+func (lp LatencyProfile) String() string {
+	return lp.spec
+}
+
+// latencyAt returns the target latency at a percentile, interpolating
+// between points. Below the first point and above the last, the latency of
+// that point applies.
+func (lp LatencyProfile) latencyAt(percentile float64) time.Duration {
+	points := lp.Points
+	if len(points) == 0 {
+		return 0
+	}
+	i := sort.Search(len(points), func(i int) bool { return points[i].Percentile >= percentile })
+	switch {
+	case i == 0:
+		return points[0].Latency
+	case i == len(points):
+		return points[len(points)-1].Latency
+	}
+	lower, upper := points[i-1], points[i]
+	share := (percentile - lower.Percentile) / (upper.Percentile - lower.Percentile)
+	return lower.Latency + time.Duration(share*float64(upper.Latency-lower.Latency))
+}
+
+// latencySamples is a ring of a profile's recent upstream latencies
+type latencySamples struct {
+	values []time.Duration
+	next   int
+}
+
+// rank returns the percentile of a latency among the samples, counting ties
+// as half below
+func (ls *latencySamples) rank(latency time.Duration) float64 {
+	var below, equal int
+	for _, value := range ls.values {
+		switch {
+		case value < latency:
+			below++
+		case value == latency:
+			equal++
+		}
+	}
+	return (float64(below) + float64(equal)/2) / float64(len(ls.values)) * 100
+}
+
+// add keeps a latency, replacing the oldest once the window is full
+func (ls *latencySamples) add(latency time.Duration) {
+	if len(ls.values) < latencyWindow {
+		ls.values = append(ls.values, latency)
+		return
+	}
+	ls.values[ls.next] = latency
+	ls.next = (ls.next + 1) % latencyWindow
+}
+
+// latencyShaper ranks responses against the recent latencies of their
+// profile
+type latencyShaper struct {
+	mutex   sync.Mutex
+	samples map[string]*latencySamples // By profile
+}
+
+func newLatencyShaper() *latencyShaper {
+	return &latencyShaper{samples: make(map[string]*latencySamples)}
+}
+
+// delay returns how long a response that took natural so far is held to
+// reach the profile's latency at its rank
+func (s *latencyShaper) delay(profile LatencyProfile, natural time.Duration) time.Duration {
+	s.mutex.Lock()
+	samples := s.samples[profile.spec]
+	if samples == nil {
+		samples = &latencySamples{}
+		s.samples[profile.spec] = samples
+	}
+	var percentile float64
+	if len(samples.values) < latencyWarmup {
+		percentile = rand.Float64() * 100
+	} else {
+		percentile = samples.rank(natural)
+	}
+	samples.add(natural)
+	s.mutex.Unlock()
+
+	if target := profile.latencyAt(percentile); target > natural {
+		return target - natural
+	}
+	return 0
+}
+
+// shapeLatency holds a response of a route with a latency profile until the
+// request reaches its target latency, giving up early if the client went
+// away. The first matching profile applies.
+func (p *Proxy) shapeLatency(r *http.Request, targetURL *url.URL, record *RequestRecord) {
+	for _, profile := range p.currentConfig().LatencyProfiles {
+		if !profile.Route.Matches(targetURL) {
+			continue
+		}
+		delay := p.latency.delay(profile, time.Since(record.ProxyStartTime))
+		record.LatencyInjectedUs = delay.Microseconds()
+		if delay <= 0 {
+			return
+		}
+		timer := time.NewTimer(delay)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+		case <-r.Context().Done():
+		}
+		return
+	}
+}
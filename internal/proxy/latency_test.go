@@ -0,0 +1,83 @@
+//go:build unit
+
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseLatencyProfile(t *testing.T) {
+	profile, err := ParseLatencyProfile("api.example.com/v1=p50:100ms, p95:800ms,p99.9:2s")
+	require.NoError(t, err)
+	assert.Equal(t, Route{Host: "api.example.com", PathPrefix: "/v1"}, profile.Route)
+	assert.Equal(t, []LatencyPoint{{50, 100 * time.Millisecond}, {95, 800 * time.Millisecond}, {99.9, 2 * time.Second}}, profile.Points)
+	assert.Equal(t, "api.example.com/v1=p50:100ms, p95:800ms,p99.9:2s", profile.String())
+
+	assert.Equal(t, 100*time.Millisecond, profile.latencyAt(10), "below the first point its latency applies")
+	assert.Equal(t, 450*time.Millisecond, profile.latencyAt(72.5))
+	assert.Equal(t, 2*time.Second, profile.latencyAt(100))
+
+	for _, spec := range []string{"api.example.com", "*=", "*=50:1s", "*=p101:1s", "*=p50:fast", "*=p95:1s,p50:2s", "*=p50:2s,p95:1s", "*=@missing.json"} {
+		_, err := ParseLatencyProfile(spec)
+		assert.Error(t, err, spec)
+	}
+}
+
+func TestImportLatencyProfile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "prod.json")
+	report := `{"hosts": [
+		{"host": "api.example.com:443", "count": 900, "p50_duration_us": 120000, "p95_duration_us": 800000, "p99_duration_us": 2000000},
+		{"host": "cdn.example.com", "count": 100, "p50_duration_us": 5000, "p95_duration_us": 9000, "p99_duration_us": 15000}
+	]}`
+	require.NoError(t, os.WriteFile(path, []byte(report), 0o600))
+
+	profile, err := ParseLatencyProfile("api.example.com=@" + path)
+	require.NoError(t, err)
+	assert.Equal(t, []LatencyPoint{{50, 120 * time.Millisecond}, {95, 800 * time.Millisecond}, {99, 2 * time.Second}}, profile.Points)
+
+	_, err = ParseLatencyProfile("*=@" + path)
+	assert.ErrorContains(t, err, "name one in the route")
+	_, err = ParseLatencyProfile("other.example.com=@" + path)
+	assert.ErrorContains(t, err, "no stats for other.example.com")
+}
+
+func TestLatencyShaperKeepsRank(t *testing.T) {
+	profile, err := ParseLatencyProfile("*=p0:100ms,p100:200ms")
+	require.NoError(t, err)
+	shaper := newLatencyShaper()
+	for i := 0; i < latencyWarmup; i++ {
+		shaper.delay(profile, time.Duration(i)*time.Millisecond)
+	}
+
+	// Ranked against 0-19ms, 5ms is at the 27.5th percentile and 30ms above all
+	assert.Equal(t, 127500*time.Microsecond-5*time.Millisecond, shaper.delay(profile, 5*time.Millisecond))
+	assert.Equal(t, 200*time.Millisecond-30*time.Millisecond, shaper.delay(profile, 30*time.Millisecond))
+	assert.Zero(t, shaper.delay(profile, time.Second), "slower responses are not held")
+}
+
+func TestProxyShapesLatency(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer upstream.Close()
+
+	profile, err := ParseLatencyProfile("*/slow=p50:30ms")
+	require.NoError(t, err)
+	p := New(&Config{LatencyProfiles: []LatencyProfile{profile}})
+
+	p.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, upstream.URL+"/slow", nil))
+	p.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, upstream.URL+"/fast", nil))
+
+	records := p.history.GetRecords()
+	require.Len(t, records, 2)
+	fast, slow := records[0], records[1]
+	assert.Zero(t, fast.LatencyInjectedUs)
+	assert.Positive(t, slow.LatencyInjectedUs)
+	assert.GreaterOrEqual(t, slow.TotalDurationUs, int64(30000))
+}
@@ -0,0 +1,192 @@
+package proxy
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultLogMaxSize is the size a log file is rotated at unless set otherwise
+const DefaultLogMaxSize = 100 << 20
+
+// logBackupTimeFormat stamps rotated log files, e.g. netkit-20240102T150405.000.log
+const logBackupTimeFormat = "20060102T150405.000"
+
+// LogRotation sets when a log file is rotated and how many rotated files are kept
+type LogRotation struct {
+	MaxSize    int64         // Rotate once the file reaches this many bytes (0 disables)
+	Every      time.Duration // Rotate once the file has been written to for this long (0 disables)
+	MaxBackups int           // Rotated files kept, oldest removed first (0 keeps all)
+	MaxAge     time.Duration // Rotated files older than this are removed (0 keeps all)
+}
+
+// RotatingFile is a log file that is renamed aside with a timestamp and
+// reopened once it gets too large or too old, removing old rotated files, so
+// a long-running proxy needs no external logrotate configuration
+type RotatingFile struct {
+	path     string
+	rotation LogRotation
+	mutex    sync.Mutex
+	file     *os.File
+	size     int64
+	openedAt time.Time
+	now      func() time.Time
+}
+
+// OpenRotatingFile opens path for appending, creating it and its directory
+// when needed
+func OpenRotatingFile(path string, rotation LogRotation) (*RotatingFile, error) {
+	f := &RotatingFile{path: path, rotation: rotation, now: time.Now}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create log directory: %w", err)
+	}
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// open opens the log file, continuing an existing one. Callers hold the mutex
+// or own f exclusively.
+func (f *RotatingFile) open() error {
+	file, err := os.OpenFile(f.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to open log file: %w", err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("failed to open log file: %w", err)
+	}
+	f.file, f.size, f.openedAt = file, info.Size(), f.now()
+	return nil
+}
+
+// Write appends p to the log file, rotating it first when p would take it
+// past the size limit or it is due for time-based rotation. A single write
+// larger than the limit still goes to one file.
+func (f *RotatingFile) Write(p []byte) (int, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if f.file == nil {
+		return 0, os.ErrClosed
+	}
+	tooLarge := f.rotation.MaxSize > 0 && f.size > 0 && f.size+int64(len(p)) > f.rotation.MaxSize
+	tooOld := f.rotation.Every > 0 && f.now().Sub(f.openedAt) >= f.rotation.Every
+	if tooLarge || tooOld {
+		if err := f.rotate(); err != nil {
+			// Keep logging to the current file rather than losing lines
+			fmt.Fprintf(os.Stderr, "Error rotating log file: %v\n", err)
+		}
+	}
+
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+	return n, err
+}
+
+// Rotate renames the current log file aside and starts a new one
+func (f *RotatingFile) Rotate() error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if f.file == nil {
+		return os.ErrClosed
+	}
+	return f.rotate()
+}
+
+// rotate renames the log file aside and reopens it. Callers hold the mutex.
+func (f *RotatingFile) rotate() error {
+	if err := f.file.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(f.path, f.backupName(f.now())); err != nil {
+		// Reopen the same file so writes keep working
+		if openErr := f.open(); openErr != nil {
+			return openErr
+		}
+		return err
+	}
+	if err := f.open(); err != nil {
+		return err
+	}
+	return f.prune()
+}
+
+// backupName returns the name a log file rotated at t is renamed to
+func (f *RotatingFile) backupName(t time.Time) string {
+	ext := filepath.Ext(f.path)
+	return strings.TrimSuffix(f.path, ext) + "-" + t.UTC().Format(logBackupTimeFormat) + ext
+}
+
+// logBackup is a rotated log file
+type logBackup struct {
+	path    string
+	rotated time.Time
+}
+
+// backups lists the rotated log files, newest first
+func (f *RotatingFile) backups() ([]logBackup, error) {
+	ext := filepath.Ext(f.path)
+	prefix := filepath.Base(strings.TrimSuffix(f.path, ext)) + "-"
+	entries, err := os.ReadDir(filepath.Dir(f.path))
+	if err != nil {
+		return nil, err
+	}
+
+	var backups []logBackup
+	for _, entry := range entries {
+		stamp, ok := strings.CutPrefix(entry.Name(), prefix)
+		if !ok || entry.IsDir() {
+			continue
+		}
+		if stamp, ok = strings.CutSuffix(stamp, ext); !ok {
+			continue
+		}
+		rotated, err := time.Parse(logBackupTimeFormat, stamp)
+		if err != nil {
+			continue
+		}
+		backups = append(backups, logBackup{filepath.Join(filepath.Dir(f.path), entry.Name()), rotated})
+	}
+	sort.Slice(backups, func(i, j int) bool { return backups[i].rotated.After(backups[j].rotated) })
+	return backups, nil
+}
+
+// prune removes the rotated files beyond the backup count or age limits.
+// Callers hold the mutex.
+func (f *RotatingFile) prune() error {
+	if f.rotation.MaxBackups <= 0 && f.rotation.MaxAge <= 0 {
+		return nil
+	}
+	backups, err := f.backups()
+	if err != nil {
+		return err
+	}
+	cutoff := f.now().Add(-f.rotation.MaxAge)
+	for i, backup := range backups {
+		if (f.rotation.MaxBackups > 0 && i >= f.rotation.MaxBackups) || (f.rotation.MaxAge > 0 && backup.rotated.Before(cutoff)) {
+			if err := os.Remove(backup.path); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Close closes the log file
+func (f *RotatingFile) Close() error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if f.file == nil {
+		return nil
+	}
+	err := f.file.Close()
+	f.file = nil
+	return err
+}
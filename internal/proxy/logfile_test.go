@@ -0,0 +1,93 @@
+//go:build unit
+
+package proxy
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// logFiles returns the names in dir, sorted
+func logFiles(t *testing.T, dir string) []string {
+	t.Helper()
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	var names []string
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	sort.Strings(names)
+	return names
+}
+
+func TestRotatingFileBySize(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "logs", "netkit.log")
+	f, err := OpenRotatingFile(path, LogRotation{MaxSize: 20, MaxBackups: 2})
+	require.NoError(t, err)
+	defer f.Close()
+
+	clock := time.Date(2024, 1, 2, 15, 4, 5, 0, time.UTC)
+	f.now = func() time.Time {
+		clock = clock.Add(time.Second)
+		return clock
+	}
+	for _, line := range []string{"first line\n", "second line\n", "third line\n", "fourth line\n", "a line longer than the limit\n"} {
+		_, err := f.Write([]byte(line))
+		require.NoError(t, err)
+	}
+
+	names := logFiles(t, filepath.Join(dir, "logs"))
+	require.Len(t, names, 3, "the oldest rotated files are removed")
+	assert.Equal(t, "netkit.log", names[2])
+	assert.True(t, strings.HasPrefix(names[0], "netkit-20240102T"), names[0])
+
+	current, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, "a line longer than the limit\n", string(current), "a write larger than the limit is not split")
+	newest, err := os.ReadFile(filepath.Join(dir, "logs", names[1]))
+	require.NoError(t, err)
+	assert.Equal(t, "fourth line\n", string(newest))
+}
+
+func TestRotatingFileByAge(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "netkit.log")
+	require.NoError(t, os.WriteFile(path, []byte("from an earlier run\n"), 0o644))
+	// A rotated file from long ago and an unrelated file
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "netkit-20200101T000000.000.log"), nil, 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "netkit-notes.log"), nil, 0o644))
+
+	f, err := OpenRotatingFile(path, LogRotation{Every: time.Hour, MaxAge: 24 * time.Hour})
+	require.NoError(t, err)
+	defer f.Close()
+	clock := time.Now()
+	f.now = func() time.Time { return clock }
+
+	_, err = f.Write([]byte("appended\n"))
+	require.NoError(t, err)
+	assert.Len(t, logFiles(t, dir), 3, "the existing file is appended to")
+
+	clock = clock.Add(time.Hour)
+	_, err = f.Write([]byte("an hour later\n"))
+	require.NoError(t, err)
+
+	names := logFiles(t, dir)
+	require.Len(t, names, 3)
+	assert.Equal(t, "netkit-notes.log", names[1])
+	assert.Equal(t, "netkit.log", names[2])
+	rotated, err := os.ReadFile(filepath.Join(dir, names[0]))
+	require.NoError(t, err)
+	assert.Equal(t, "from an earlier run\nappended\n", string(rotated))
+
+	require.NoError(t, f.Close())
+	_, err = f.Write([]byte("closed\n"))
+	assert.ErrorIs(t, err, os.ErrClosed)
+}
@@ -0,0 +1,79 @@
+package proxy
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+)
+
+// Log formats accepted by --log-format
+const (
+	LogFormatText = "text"
+	LogFormatJSON = "json"
+)
+
+// logLevel is the level of the logger installed by SetupLogging. Reloads
+// change it, so --log-level applies without a restart.
+var logLevel = new(slog.LevelVar)
+
+// ParseLogLevel parses a --log-level value: debug, info, warn, or error
+func ParseLogLevel(value string) (slog.Level, error) {
+	switch strings.ToLower(value) {
+	case "debug":
+		return slog.LevelDebug, nil
+	case "", "info":
+		return slog.LevelInfo, nil
+	case "warn", "warning":
+		return slog.LevelWarn, nil
+	case "error":
+		return slog.LevelError, nil
+	}
+	return 0, fmt.Errorf("unknown level %q, expected debug, info, warn, or error", value)
+}
+
+// ValidateLogFormat checks a --log-format value
+func ValidateLogFormat(format string) error {
+	if format != "" && format != LogFormatText && format != LogFormatJSON {
+		return fmt.Errorf("unknown format %q, expected text or json", format)
+	}
+	return nil
+}
+
+// SetupLogging installs a slog logger writing to w at level in the text or
+// JSON format as the default logger. The log package writes through it too.
+func SetupLogging(w io.Writer, level, format string) error {
+	return SetupLoggingWithSyslog(w, nil, level, format)
+}
+
+// SetupLoggingWithSyslog is SetupLogging that also sends logs to a syslog
+// daemon when syslog is set. With a nil w, logs only go to syslog.
+func SetupLoggingWithSyslog(w io.Writer, syslog *SyslogWriter, level, format string) error {
+	parsed, err := ParseLogLevel(level)
+	if err != nil {
+		return err
+	}
+	if err := ValidateLogFormat(format); err != nil {
+		return err
+	}
+	logLevel.Set(parsed)
+
+	options := &slog.HandlerOptions{Level: logLevel}
+	var handlers teeHandler
+	if w != nil {
+		var handler slog.Handler = slog.NewTextHandler(w, options)
+		if format == LogFormatJSON {
+			handler = slog.NewJSONHandler(w, options)
+		}
+		handlers = append(handlers, handler)
+	}
+	if syslog != nil {
+		handlers = append(handlers, newSyslogHandler(syslog, format, options))
+	}
+	if len(handlers) == 1 {
+		slog.SetDefault(slog.New(handlers[0]))
+	} else {
+		slog.SetDefault(slog.New(handlers))
+	}
+	return nil
+}
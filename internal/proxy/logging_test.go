@@ -0,0 +1,86 @@
+//go:build unit
+
+package proxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// captureLogs installs a logger writing to the returned buffer until the test ends
+func captureLogs(t *testing.T, level, format string) *bytes.Buffer {
+	t.Helper()
+	// Installing a slog logger also redirects the log package, which resetting
+	// the slog default does not undo
+	previous, previousLevel := slog.Default(), logLevel.Level()
+	previousWriter, previousFlags := log.Writer(), log.Flags()
+	t.Cleanup(func() {
+		slog.SetDefault(previous)
+		log.SetOutput(previousWriter)
+		log.SetFlags(previousFlags)
+		logLevel.Set(previousLevel)
+	})
+	var logs bytes.Buffer
+	require.NoError(t, SetupLogging(&logs, level, format))
+	return &logs
+}
+
+func TestSetupLoggingJSON(t *testing.T) {
+	logs := captureLogs(t, "debug", LogFormatJSON)
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	}))
+	defer upstream.Close()
+
+	p := New(&Config{LogLevel: "debug"})
+	p.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, upstream.URL+"/brew", nil))
+	log.Printf("From the log package")
+
+	lines := strings.Split(strings.TrimSpace(logs.String()), "\n")
+	require.Len(t, lines, 3)
+	var completed map[string]any
+	require.NoError(t, json.Unmarshal([]byte(lines[1]), &completed))
+	assert.Equal(t, "DEBUG", completed["level"])
+	assert.Equal(t, "HTTP request completed", completed["msg"])
+	assert.Equal(t, upstream.URL+"/brew", completed["url"])
+	assert.Equal(t, float64(http.StatusTeapot), completed["status"])
+
+	var bridged map[string]any
+	require.NoError(t, json.Unmarshal([]byte(lines[2]), &bridged))
+	assert.Equal(t, "INFO", bridged["level"])
+	assert.Equal(t, "From the log package", bridged["msg"])
+}
+
+func TestLogLevelGatesAndReloads(t *testing.T) {
+	logs := captureLogs(t, "warn", LogFormatText)
+	slog.Info("Hidden")
+	slog.Warn("Shown", "port", 8080)
+	assert.NotContains(t, logs.String(), "Hidden")
+	assert.Contains(t, logs.String(), "level=WARN msg=Shown port=8080")
+
+	// The level follows reloads, so debug logging can be turned on in place
+	p := New(&Config{LogLevel: "warn"})
+	p.Reload(&Config{LogLevel: "debug"})
+	slog.Debug("Now shown")
+	assert.Contains(t, logs.String(), "level=DEBUG msg=\"Now shown\"")
+}
+
+func TestLoggingValidation(t *testing.T) {
+	for _, level := range []string{"debug", "INFO", "", "warning", "error"} {
+		_, err := ParseLogLevel(level)
+		assert.NoError(t, err, level)
+	}
+	_, err := ParseLogLevel("verbose")
+	assert.Error(t, err)
+	assert.Error(t, ValidateLogFormat("logfmt"))
+	assert.Error(t, SetupLogging(&bytes.Buffer{}, "info", "xml"))
+}
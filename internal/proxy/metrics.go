@@ -0,0 +1,292 @@
+package proxy
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// Kinds of proxied traffic counted by /metrics
+const (
+	trafficHTTP    = "http"    // Requests forwarded by handleHTTP
+	trafficConnect = "connect" // CONNECT tunnels
+)
+
+// latencyBuckets are the upper bounds, in seconds, of the request duration
+// and upstream latency histograms
+var latencyBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// overheadBuckets are the upper bounds, in seconds, of the proxy overhead
+// histogram, which is usually well under a millisecond
+var overheadBuckets = []float64{0.0001, 0.00025, 0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1}
+
+// maxMetricHosts caps the upstream hosts labeled on /metrics, so a forward
+// proxy browsing many sites keeps a bounded number of series; later hosts
+// are counted as otherHosts
+const maxMetricHosts = 100
+
+// otherHosts labels the upstream hosts beyond maxMetricHosts
+const otherHosts = "other"
+
+// latencyHistogram is a Prometheus histogram of durations
+type latencyHistogram struct {
+	bounds []float64
+	counts []int64 // Per bucket, not cumulative; the last is +Inf
+	sum    float64
+	count  int64
+}
+
+func newLatencyHistogram(bounds []float64) *latencyHistogram {
+	return &latencyHistogram{bounds: bounds, counts: make([]int64, len(bounds)+1)}
+}
+
+func (h *latencyHistogram) observe(us int64) {
+	seconds := float64(us) / 1e6
+	i := sort.SearchFloat64s(h.bounds, seconds)
+	h.counts[i]++
+	h.sum += seconds
+	h.count++
+}
+
+// write appends the histogram in the Prometheus text format
+func (h *latencyHistogram) write(b *strings.Builder, name, help string) {
+	fmt.Fprintf(b, "\n# HELP %s %s\n# TYPE %s histogram\n", name, help, name)
+	h.writeSeries(b, name, "")
+}
+
+// writeSeries appends the samples of the histogram, with labels (e.g.
+// upstream="api:8080") added to each
+func (h *latencyHistogram) writeSeries(b *strings.Builder, name, labels string) {
+	bucketLabels, sampleLabels := "", ""
+	if labels != "" {
+		bucketLabels, sampleLabels = labels+",", "{"+labels+"}"
+	}
+	var cumulative int64
+	for i, bound := range h.bounds {
+		cumulative += h.counts[i]
+		fmt.Fprintf(b, "%s_bucket{%sle=\"%g\"} %d\n", name, bucketLabels, bound, cumulative)
+	}
+	fmt.Fprintf(b, "%s_bucket{%sle=\"+Inf\"} %d\n", name, bucketLabels, h.count)
+	fmt.Fprintf(b, "%s_sum%s %g\n%s_count%s %d\n", name, sampleLabels, h.sum, name, sampleLabels, h.count)
+}
+
+// hostMetrics counts the requests sent to one upstream host
+type hostMetrics struct {
+	requests int64
+	errors   int64 // Proxy errors and 4xx/5xx responses
+	duration *latencyHistogram
+}
+
+// requestKey labels a request counter
+type requestKey struct {
+	method      string
+	statusClass string
+}
+
+// trafficMetrics counts the proxied requests and tunnels for /metrics
+type trafficMetrics struct {
+	mutex    sync.Mutex
+	requests map[requestKey]int64
+	errors   map[string]int64 // By kind of traffic
+	duration *latencyHistogram
+	upstream *latencyHistogram
+	overhead *latencyHistogram
+	hosts    map[string]*hostMetrics // By upstream host and port
+	statsd   *statsdClient           // Also sends every observation to StatsD (optional)
+
+	bytesIn  atomic.Int64 // Request bodies and bytes clients sent into tunnels
+	bytesOut atomic.Int64 // Response bodies and bytes tunnels sent to clients
+	active   [2]atomic.Int64
+	conns    atomic.Int64 // Open client connections on the proxy port, until they close or are hijacked
+}
+
+func newTrafficMetrics() *trafficMetrics {
+	return &trafficMetrics{
+		requests: make(map[requestKey]int64),
+		errors:   make(map[string]int64),
+		duration: newLatencyHistogram(latencyBuckets),
+		upstream: newLatencyHistogram(latencyBuckets),
+		overhead: newLatencyHistogram(overheadBuckets),
+		hosts:    make(map[string]*hostMetrics),
+	}
+}
+
+// statusClass returns "2xx" and the like for a status code, or "none" when
+// there was no upstream response
+func statusClass(status int) string {
+	if status < 100 || status > 599 {
+		return "none"
+	}
+	return fmt.Sprintf("%dxx", status/100)
+}
+
+// begin counts a request or tunnel as active until the returned func is called
+func (m *trafficMetrics) begin(kind string) func() {
+	gauge := &m.active[0]
+	if kind == trafficConnect {
+		gauge = &m.active[1]
+	}
+	gauge.Add(1)
+	return func() { gauge.Add(-1) }
+}
+
+// trackConn counts open client connections as the proxy server's ConnState hook
+func (m *trafficMetrics) trackConn(_ net.Conn, state http.ConnState) {
+	switch state {
+	case http.StateNew:
+		m.conns.Add(1)
+	case http.StateHijacked, http.StateClosed:
+		m.conns.Add(-1)
+	}
+}
+
+// observe counts a finished request or tunnel
+func (m *trafficMetrics) observe(kind, method string, status int, bytesIn, bytesOut int64, failed bool) {
+	m.bytesIn.Add(bytesIn)
+	m.bytesOut.Add(bytesOut)
+	if m.statsd != nil {
+		m.statsd.count("requests", 1, "type:"+kind, "method:"+method, "status_class:"+statusClass(status))
+		if failed {
+			m.statsd.count("errors", 1, "type:"+kind)
+		}
+		m.statsd.count("bytes.received", bytesIn, "type:"+kind)
+		m.statsd.count("bytes.sent", bytesOut, "type:"+kind)
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.requests[requestKey{method: method, statusClass: statusClass(status)}]++
+	if failed {
+		m.errors[kind]++
+	}
+}
+
+// observeRecord counts a request handled by handleHTTP from its record, and
+// adds its durations to the latency histograms, overall and for its
+// upstream host
+func (m *trafficMetrics) observeRecord(record *RequestRecord) {
+	m.observe(trafficHTTP, record.Method, record.ResponseStatus, record.RequestSize, record.ResponseSize, record.Error != "")
+
+	measured := *record
+	measured.measure()
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.duration.observe(measured.TotalDurationUs)
+	reachedUpstream := !measured.UpstreamStartTime.IsZero()
+	// Requests answered before reaching the upstream have no upstream latency
+	if reachedUpstream {
+		m.upstream.observe(measured.UpstreamLatencyUs)
+		m.overhead.observe(measured.ProxyOverheadUs)
+	}
+
+	host := upstreamHost(measured.URL)
+	if m.hosts[host] == nil && len(m.hosts) >= maxMetricHosts {
+		host = otherHosts
+	}
+	metrics := m.hosts[host]
+	if metrics == nil {
+		metrics = &hostMetrics{duration: newLatencyHistogram(latencyBuckets)}
+		m.hosts[host] = metrics
+	}
+	metrics.requests++
+	if isFailedRecord(measured) {
+		metrics.errors++
+	}
+	metrics.duration.observe(measured.TotalDurationUs)
+
+	if m.statsd != nil {
+		upstream := "upstream:" + host
+		m.statsd.timing("request.duration", measured.TotalDurationUs, "method:"+measured.Method, "status_class:"+statusClass(measured.ResponseStatus), upstream)
+		if reachedUpstream {
+			m.statsd.timing("upstream.latency", measured.UpstreamLatencyUs, upstream)
+			m.statsd.timing("proxy.overhead", measured.ProxyOverheadUs, upstream)
+		}
+	}
+}
+
+// writeHostMetrics appends the per-host counters and duration histograms;
+// the caller holds the mutex
+func (m *trafficMetrics) writeHostMetrics(b *strings.Builder) {
+	hosts := make([]string, 0, len(m.hosts))
+	for host := range m.hosts {
+		hosts = append(hosts, host)
+	}
+	sort.Strings(hosts)
+
+	b.WriteString("\n# HELP netkit_upstream_requests_total Requests handled per upstream host\n")
+	b.WriteString("# TYPE netkit_upstream_requests_total counter\n")
+	for _, host := range hosts {
+		fmt.Fprintf(b, "netkit_upstream_requests_total{upstream=%q} %d\n", host, m.hosts[host].requests)
+	}
+	b.WriteString("\n# HELP netkit_upstream_errors_total Requests per upstream host that failed in the proxy or got a 4xx or 5xx response\n")
+	b.WriteString("# TYPE netkit_upstream_errors_total counter\n")
+	for _, host := range hosts {
+		fmt.Fprintf(b, "netkit_upstream_errors_total{upstream=%q} %d\n", host, m.hosts[host].errors)
+	}
+	b.WriteString("\n# HELP netkit_upstream_request_duration_seconds Time from receiving a request to answering it per upstream host\n")
+	b.WriteString("# TYPE netkit_upstream_request_duration_seconds histogram\n")
+	for _, host := range hosts {
+		m.hosts[host].duration.writeSeries(b, "netkit_upstream_request_duration_seconds", fmt.Sprintf("upstream=%q", host))
+	}
+}
+
+// writeTrafficMetrics appends the request, byte, and connection counters and
+// the latency histograms, overall and per upstream host, in the Prometheus
+// text format
+func (m *trafficMetrics) writeTrafficMetrics(b *strings.Builder) {
+	m.mutex.Lock()
+	keys := make([]requestKey, 0, len(m.requests))
+	requests := make(map[requestKey]int64, len(m.requests))
+	var total int64
+	for key, count := range m.requests {
+		keys = append(keys, key)
+		requests[key] = count
+		total += count
+	}
+	errors := map[string]int64{trafficHTTP: m.errors[trafficHTTP], trafficConnect: m.errors[trafficConnect]}
+	var histograms strings.Builder
+	m.duration.write(&histograms, "netkit_request_duration_seconds", "Time from receiving a request to answering it")
+	m.upstream.write(&histograms, "netkit_upstream_latency_seconds", "Time waiting for the upstream")
+	m.overhead.write(&histograms, "netkit_proxy_overhead_seconds", "Time spent in the proxy rather than waiting for the upstream")
+	m.writeHostMetrics(&histograms)
+	m.mutex.Unlock()
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].method != keys[j].method {
+			return keys[i].method < keys[j].method
+		}
+		return keys[i].statusClass < keys[j].statusClass
+	})
+
+	b.WriteString("# HELP netkit_requests_total Total number of requests handled\n")
+	b.WriteString("# TYPE netkit_requests_total counter\n")
+	fmt.Fprintf(b, "netkit_requests_total %d\n", total)
+	b.WriteString("\n# HELP netkit_requests_by_status_total Requests handled by method and upstream status class (none without an upstream response)\n")
+	b.WriteString("# TYPE netkit_requests_by_status_total counter\n")
+	for _, key := range keys {
+		fmt.Fprintf(b, "netkit_requests_by_status_total{method=%q,status_class=%q} %d\n", key.method, key.statusClass, requests[key])
+	}
+	b.WriteString("\n# HELP netkit_request_errors_total Requests and tunnels that failed in the proxy\n")
+	b.WriteString("# TYPE netkit_request_errors_total counter\n")
+	for _, kind := range []string{trafficConnect, trafficHTTP} {
+		fmt.Fprintf(b, "netkit_request_errors_total{type=%q} %d\n", kind, errors[kind])
+	}
+	b.WriteString("\n# HELP netkit_received_bytes_total Bytes received from clients, in request bodies and tunnels\n")
+	b.WriteString("# TYPE netkit_received_bytes_total counter\n")
+	fmt.Fprintf(b, "netkit_received_bytes_total %d\n", m.bytesIn.Load())
+	b.WriteString("\n# HELP netkit_sent_bytes_total Bytes sent to clients, in response bodies and tunnels\n")
+	b.WriteString("# TYPE netkit_sent_bytes_total counter\n")
+	fmt.Fprintf(b, "netkit_sent_bytes_total %d\n", m.bytesOut.Load())
+	b.WriteString("\n# HELP netkit_active_connections Requests being proxied and open tunnels\n")
+	b.WriteString("# TYPE netkit_active_connections gauge\n")
+	fmt.Fprintf(b, "netkit_active_connections{type=%q} %d\n", trafficConnect, m.active[1].Load())
+	fmt.Fprintf(b, "netkit_active_connections{type=%q} %d\n", trafficHTTP, m.active[0].Load())
+	b.WriteString(histograms.String())
+	if m.statsd != nil {
+		b.WriteString("\n# HELP netkit_statsd_dropped_total StatsD metrics dropped because the send queue was full\n")
+		b.WriteString("# TYPE netkit_statsd_dropped_total counter\n")
+		fmt.Fprintf(b, "netkit_statsd_dropped_total %d\n", m.statsd.dropped.Load())
+	}
+}
@@ -0,0 +1,124 @@
+//go:build unit
+
+package proxy
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTrafficMetrics(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if r.URL.Path == "/missing" {
+			w.WriteHeader(http.StatusNotFound)
+		}
+		_, _ = w.Write(append([]byte("echo:"), body...))
+	}))
+	defer upstream.Close()
+	p := New(&Config{})
+
+	p.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, upstream.URL+"/users", strings.NewReader("hello")))
+	p.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, upstream.URL+"/missing", nil))
+	p.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "http://127.0.0.1:1/down", nil))
+
+	rec := httptest.NewRecorder()
+	p.handleMetrics(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	metrics := rec.Body.String()
+	assert.Contains(t, metrics, "netkit_requests_total 3\n")
+	assert.Contains(t, metrics, `netkit_requests_by_status_total{method="POST",status_class="2xx"} 1`)
+	assert.Contains(t, metrics, `netkit_requests_by_status_total{method="GET",status_class="4xx"} 1`)
+	assert.Contains(t, metrics, `netkit_requests_by_status_total{method="GET",status_class="none"} 1`)
+	assert.Contains(t, metrics, `netkit_request_errors_total{type="http"} 1`)
+	assert.Contains(t, metrics, "netkit_received_bytes_total 5\n")
+	assert.Contains(t, metrics, "netkit_sent_bytes_total 15\n", "echo:hello and echo: bodies")
+	assert.Contains(t, metrics, `netkit_active_connections{type="http"} 0`)
+	assert.Contains(t, metrics, "netkit_proxy_status 1")
+	assert.Contains(t, metrics, "# TYPE netkit_request_duration_seconds histogram")
+	assert.Contains(t, metrics, `netkit_request_duration_seconds_bucket{le="+Inf"} 3`)
+	assert.Contains(t, metrics, "netkit_request_duration_seconds_count 3\n")
+	assert.Contains(t, metrics, `netkit_upstream_latency_seconds_bucket{le="10"} 3`, "the failed request waited for the upstream too")
+	assert.Contains(t, metrics, "netkit_proxy_overhead_seconds_count 3\n")
+
+	host := strings.TrimPrefix(upstream.URL, "http://")
+	assert.Contains(t, metrics, `netkit_upstream_requests_total{upstream="`+host+`"} 2`)
+	assert.Contains(t, metrics, `netkit_upstream_errors_total{upstream="`+host+`"} 1`, "the 404")
+	assert.Contains(t, metrics, `netkit_upstream_errors_total{upstream="127.0.0.1:1"} 1`)
+	assert.Contains(t, metrics, `netkit_upstream_request_duration_seconds_bucket{upstream="`+host+`",le="+Inf"} 2`)
+	assert.Contains(t, metrics, `netkit_upstream_request_duration_seconds_count{upstream="127.0.0.1:1"} 1`)
+}
+
+func TestLatencyHistogram(t *testing.T) {
+	histogram := newLatencyHistogram([]float64{0.01, 0.1, 1})
+	for _, us := range []int64{5000, 10000, 50000, 2000000} {
+		histogram.observe(us)
+	}
+	var b strings.Builder
+	histogram.write(&b, "netkit_test_seconds", "Test durations")
+	assert.Equal(t, `
+# HELP netkit_test_seconds Test durations
+# TYPE netkit_test_seconds histogram
+netkit_test_seconds_bucket{le="0.01"} 2
+netkit_test_seconds_bucket{le="0.1"} 3
+netkit_test_seconds_bucket{le="1"} 3
+netkit_test_seconds_bucket{le="+Inf"} 4
+netkit_test_seconds_sum 2.065
+netkit_test_seconds_count 4
+`, b.String())
+}
+
+func TestTrafficMetricsConnect(t *testing.T) {
+	echo, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer echo.Close()
+	go func() {
+		conn, err := echo.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		_, _ = io.CopyN(conn, conn, 4)
+	}()
+
+	p := New(&Config{})
+	server := httptest.NewServer(p)
+	defer server.Close()
+
+	conn, err := net.Dial("tcp", strings.TrimPrefix(server.URL, "http://"))
+	require.NoError(t, err)
+	require.NoError(t, conn.SetDeadline(time.Now().Add(5*time.Second)))
+	_, err = io.WriteString(conn, "CONNECT "+echo.Addr().String()+" HTTP/1.1\r\nHost: "+echo.Addr().String()+"\r\n\r\n")
+	require.NoError(t, err)
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, nil)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	assert.Equal(t, int64(1), p.metrics.active[1].Load(), "the tunnel is active while open")
+	_, err = io.WriteString(conn, "ping")
+	require.NoError(t, err)
+	reply := make([]byte, 4)
+	_, err = io.ReadFull(reader, reply)
+	require.NoError(t, err)
+	assert.Equal(t, "ping", string(reply))
+	require.NoError(t, conn.Close())
+
+	require.Eventually(t, func() bool {
+		rec := httptest.NewRecorder()
+		p.handleMetrics(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+		metrics := rec.Body.String()
+		return strings.Contains(metrics, `netkit_requests_by_status_total{method="CONNECT",status_class="2xx"} 1`) &&
+			strings.Contains(metrics, "netkit_received_bytes_total 4\n") &&
+			strings.Contains(metrics, "netkit_sent_bytes_total 4\n") &&
+			strings.Contains(metrics, `netkit_active_connections{type="connect"} 0`)
+	}, 5*time.Second, 10*time.Millisecond)
+}
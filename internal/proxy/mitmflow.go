@@ -0,0 +1,534 @@
+package proxy
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// mitmFlowVersion is the mitmproxy flow format version of written flows;
+// mitmproxy upgrades older versions when it loads them
+const mitmFlowVersion = 19
+
+// mitmMaxLength bounds the length prefix of a tnetstring value
+const mitmMaxLength = 999999999
+
+// isMitmFlows reports whether data looks like a mitmproxy flow file: a
+// sequence of tnetstrings, each starting with its length
+func isMitmFlows(data []byte) bool {
+	colon := bytes.IndexByte(data, ':')
+	if colon < 1 || colon > 9 {
+		return false
+	}
+	for _, c := range data[:colon] {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// readMitmFlows reads HTTP flows from a mitmproxy flow file (as written by
+// mitmdump -w or the mitmproxy "Export flows" command) as request records.
+// TCP, UDP, and DNS flows are skipped, as are the messages of WebSocket flows.
+func readMitmFlows(data []byte) ([]RequestRecord, error) {
+	var records []RequestRecord
+	for n := 1; len(bytes.TrimSpace(data)) > 0; n++ {
+		value, rest, err := parseTnetstring(bytes.TrimLeft(data, " \t\r\n"))
+		if err != nil {
+			return nil, fmt.Errorf("invalid flow %d: %v", n, err)
+		}
+		data = rest
+
+		flow, ok := value.(map[string]any)
+		if !ok {
+			return nil, fmt.Errorf("invalid flow %d: not a dictionary", n)
+		}
+		if flowType := mitmText(flow["type"]); flowType != "" && flowType != "http" {
+			continue
+		}
+		record, err := mitmFlowRecord(flow)
+		if err != nil {
+			return nil, fmt.Errorf("invalid flow %d: %v", n, err)
+		}
+		records = append(records, record)
+	}
+	if len(records) == 0 {
+		return nil, fmt.Errorf("no HTTP flows found")
+	}
+	return records, nil
+}
+
+// mitmFlowRecord converts the state of an HTTP flow to a request record
+func mitmFlowRecord(flow map[string]any) (RequestRecord, error) {
+	request, ok := flow["request"].(map[string]any)
+	if !ok {
+		return RequestRecord{}, fmt.Errorf("missing request")
+	}
+
+	record := RequestRecord{
+		ID:              mitmText(flow["id"]),
+		Method:          mitmText(request["method"]),
+		URL:             mitmURL(request),
+		RequestHeaders:  mitmHeaders(request["headers"]),
+		RequestBody:     mitmText(request["content"]),
+		ResponseHeaders: map[string]string{},
+	}
+	record.RequestSize = int64(len(record.RequestBody))
+	if request["content"] == nil {
+		record.BodiesOmitted = true
+	}
+
+	start := mitmTime(request["timestamp_start"])
+	if start.IsZero() {
+		start = mitmTime(flow["timestamp_created"])
+	}
+	record.Timestamp, record.ProxyStartTime = start, start
+	record.UpstreamStartTime = mitmTime(request["timestamp_end"])
+	end := record.UpstreamStartTime
+
+	if client, ok := flow["client_conn"].(map[string]any); ok {
+		if peer, ok := client["peername"].([]any); ok && len(peer) > 0 {
+			record.ClientAddr = mitmText(peer[0])
+		}
+	}
+
+	if response, ok := flow["response"].(map[string]any); ok {
+		record.ResponseStatus = int(mitmNumber(response["status_code"]))
+		record.ResponseHeaders = mitmHeaders(response["headers"])
+		record.ResponseBody = mitmText(response["content"])
+		record.ResponseSize = int64(len(record.ResponseBody))
+		if response["content"] == nil {
+			record.BodiesOmitted = true
+		}
+		record.Success = true
+		if t := mitmTime(response["timestamp_start"]); !t.IsZero() && !record.UpstreamStartTime.IsZero() {
+			record.UpstreamLatencyUs = t.Sub(record.UpstreamStartTime).Microseconds()
+		}
+		if t := mitmTime(response["timestamp_end"]); !t.IsZero() {
+			end = t
+		}
+	}
+
+	if flowError, ok := flow["error"].(map[string]any); ok {
+		record.Error = mitmText(flowError["msg"])
+		record.Success = false
+		if t := mitmTime(flowError["timestamp"]); !t.IsZero() && end.IsZero() {
+			end = t
+		}
+	}
+
+	if !end.IsZero() {
+		record.UpstreamEndTime, record.ProxyEndTime = end, end
+		if !start.IsZero() {
+			record.TotalDurationUs = end.Sub(start).Microseconds()
+		}
+	}
+	if record.ID == "" {
+		record.ID = generateID()
+	}
+	return record, nil
+}
+
+// mitmURL rebuilds the URL of a flow's request
+func mitmURL(request map[string]any) string {
+	authority := mitmText(request["authority"])
+	if strings.EqualFold(mitmText(request["method"]), http.MethodConnect) && authority != "" {
+		return authority
+	}
+
+	scheme := mitmText(request["scheme"])
+	if scheme == "" {
+		scheme = "http"
+	}
+	host := mitmText(request["host"])
+	port := int(mitmNumber(request["port"]))
+	if port != 0 && !(scheme == "http" && port == 80) && !(scheme == "https" && port == 443) {
+		host = net.JoinHostPort(host, strconv.Itoa(port))
+	} else if strings.Contains(host, ":") {
+		host = "[" + host + "]"
+	}
+	return scheme + "://" + host + mitmText(request["path"])
+}
+
+// mitmHeaders converts a list of name/value pairs to headers, keeping the
+// first value of repeated headers like convertHeaders does
+func mitmHeaders(value any) map[string]string {
+	headers := make(map[string]string)
+	fields, _ := value.([]any)
+	for _, field := range fields {
+		pair, ok := field.([]any)
+		if !ok || len(pair) != 2 {
+			continue
+		}
+		name := http.CanonicalHeaderKey(mitmText(pair[0]))
+		if _, seen := headers[name]; !seen {
+			headers[name] = mitmText(pair[1])
+		}
+	}
+	return headers
+}
+
+// mitmText returns a byte or text string value as a string
+func mitmText(value any) string {
+	switch v := value.(type) {
+	case []byte:
+		return string(v)
+	case string:
+		return v
+	}
+	return ""
+}
+
+// mitmNumber returns an integer or float value as a float
+func mitmNumber(value any) float64 {
+	switch v := value.(type) {
+	case int64:
+		return float64(v)
+	case float64:
+		return v
+	}
+	return 0
+}
+
+// mitmTime converts a Unix timestamp in seconds; missing timestamps are zero
+func mitmTime(value any) time.Time {
+	seconds := mitmNumber(value)
+	if seconds <= 0 {
+		return time.Time{}
+	}
+	return time.UnixMicro(int64(math.Round(seconds * 1e6))).UTC()
+}
+
+// WriteMitmFlows writes records as a mitmproxy flow file, oldest first, that
+// mitmproxy, mitmweb, and mitmdump -r can load. Records without an absolute
+// URL are skipped. It returns how many flows were written.
+func WriteMitmFlows(w io.Writer, records []RequestRecord) (int, error) {
+	ordered := append([]RequestRecord(nil), records...)
+	sort.SliceStable(ordered, func(i, j int) bool { return ordered[i].Timestamp.Before(ordered[j].Timestamp) })
+
+	written := 0
+	for _, record := range ordered {
+		flow, ok := mitmFlowState(record)
+		if !ok {
+			continue
+		}
+		if _, err := w.Write(appendTnetstring(nil, flow)); err != nil {
+			return written, err
+		}
+		written++
+	}
+	return written, nil
+}
+
+// mitmFlowState converts a record to the state of an HTTP flow
+func mitmFlowState(record RequestRecord) (map[string]any, bool) {
+	request := map[string]any{
+		"method":       []byte(record.Method),
+		"http_version": []byte("HTTP/1.1"),
+		"headers":      mitmHeaderFields(record.RequestHeaders),
+		"content":      mitmContent(record.RequestBody, record.BodiesOmitted),
+		"trailers":     nil,
+	}
+	if record.Method == http.MethodConnect && !strings.Contains(record.URL, "://") {
+		host, port, err := net.SplitHostPort(record.URL)
+		if err != nil {
+			return nil, false
+		}
+		portNumber, _ := strconv.Atoi(port)
+		request["host"], request["port"] = host, int64(portNumber)
+		request["scheme"], request["authority"], request["path"] = []byte(""), []byte(record.URL), []byte("")
+	} else {
+		target, err := url.Parse(record.URL)
+		if err != nil || target.Host == "" {
+			return nil, false
+		}
+		port, _ := strconv.Atoi(target.Port())
+		if port == 0 {
+			port = 80
+			if target.Scheme == "https" {
+				port = 443
+			}
+		}
+		request["host"], request["port"] = target.Hostname(), int64(port)
+		request["scheme"], request["authority"], request["path"] = []byte(target.Scheme), []byte(""), []byte(target.RequestURI())
+	}
+
+	start := mitmTimestamp(record.Timestamp)
+	requestEnd := mitmTimestamp(record.UpstreamStartTime)
+	if requestEnd == nil {
+		requestEnd = start
+	}
+	end := mitmTimestamp(record.ProxyEndTime)
+	if end == nil && record.TotalDurationUs > 0 {
+		end = mitmTimestamp(record.Timestamp.Add(time.Duration(record.TotalDurationUs) * time.Microsecond))
+	}
+	if end == nil {
+		end = requestEnd
+	}
+	request["timestamp_start"], request["timestamp_end"] = start, requestEnd
+
+	var response any
+	if record.ResponseStatus != 0 {
+		responseStart := end
+		if !record.UpstreamStartTime.IsZero() && record.UpstreamLatencyUs > 0 {
+			responseStart = mitmTimestamp(record.UpstreamStartTime.Add(time.Duration(record.UpstreamLatencyUs) * time.Microsecond))
+		}
+		response = map[string]any{
+			"http_version":    []byte("HTTP/1.1"),
+			"status_code":     int64(record.ResponseStatus),
+			"reason":          []byte(http.StatusText(record.ResponseStatus)),
+			"headers":         mitmHeaderFields(record.ResponseHeaders),
+			"content":         mitmContent(record.ResponseBody, record.BodiesOmitted),
+			"trailers":        nil,
+			"timestamp_start": responseStart,
+			"timestamp_end":   end,
+		}
+	}
+
+	var flowError any
+	if record.Error != "" {
+		flowError = map[string]any{"msg": record.Error, "timestamp": end}
+	}
+
+	clientAddr := record.ClientAddr
+	if clientAddr == "" {
+		clientAddr = "127.0.0.1"
+	}
+	serverAddr := []any{request["host"], request["port"]}
+	scheme := string(request["scheme"].([]byte))
+
+	return map[string]any{
+		"version":           int64(mitmFlowVersion),
+		"type":              "http",
+		"id":                record.ID,
+		"error":             flowError,
+		"client_conn":       mitmClientState(record.ID, clientAddr, start, end),
+		"server_conn":       mitmServerState(record.ID, serverAddr, scheme == "https", requestEnd, end),
+		"intercepted":       false,
+		"is_replay":         nil,
+		"marked":            "",
+		"metadata":          map[string]any{},
+		"comment":           "",
+		"timestamp_created": start,
+		"request":           request,
+		"response":          response,
+		"websocket":         nil,
+	}, true
+}
+
+// mitmClientState describes the client connection of a written flow
+func mitmClientState(id, addr string, start, end any) map[string]any {
+	return map[string]any{
+		"id":                  id + "-client",
+		"peername":            []any{addr, int64(0)},
+		"sockname":            []any{"", int64(0)},
+		"state":               int64(0),
+		"error":               nil,
+		"tls":                 false,
+		"certificate_list":    []any{},
+		"alpn":                nil,
+		"alpn_offers":         []any{},
+		"cipher":              nil,
+		"cipher_list":         []any{},
+		"tls_version":         nil,
+		"sni":                 nil,
+		"mitmcert":            nil,
+		"proxy_mode":          "regular",
+		"timestamp_start":     start,
+		"timestamp_end":       end,
+		"timestamp_tls_setup": nil,
+	}
+}
+
+// mitmServerState describes the upstream connection of a written flow
+func mitmServerState(id string, addr []any, tls bool, start, end any) map[string]any {
+	return map[string]any{
+		"id":                  id + "-server",
+		"address":             addr,
+		"peername":            nil,
+		"sockname":            nil,
+		"state":               int64(0),
+		"error":               nil,
+		"tls":                 tls,
+		"certificate_list":    []any{},
+		"alpn":                nil,
+		"alpn_offers":         []any{},
+		"cipher":              nil,
+		"cipher_list":         []any{},
+		"tls_version":         nil,
+		"sni":                 nil,
+		"via":                 nil,
+		"timestamp_start":     start,
+		"timestamp_tcp_setup": start,
+		"timestamp_tls_setup": nil,
+		"timestamp_end":       end,
+	}
+}
+
+// mitmHeaderFields converts headers to name/value pairs sorted by name
+func mitmHeaderFields(headers map[string]string) []any {
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	fields := make([]any, 0, len(names))
+	for _, name := range names {
+		fields = append(fields, []any{[]byte(name), []byte(headers[name])})
+	}
+	return fields
+}
+
+// mitmContent is a message body, or nil when it was not captured
+func mitmContent(body string, omitted bool) any {
+	if omitted {
+		return nil
+	}
+	return []byte(body)
+}
+
+// mitmTimestamp converts a time to Unix seconds, or nil when it is unset
+func mitmTimestamp(t time.Time) any {
+	if t.IsZero() {
+		return nil
+	}
+	return float64(t.UnixMicro()) / 1e6
+}
+
+// parseTnetstring parses the tnetstring at the start of data and returns it
+// with the bytes that follow. Byte strings are []byte, text strings string,
+// integers int64, floats float64, lists []any, and dictionaries map[string]any.
+func parseTnetstring(data []byte) (any, []byte, error) {
+	colon := bytes.IndexByte(data, ':')
+	if colon < 1 || colon > 9 {
+		return nil, nil, fmt.Errorf("missing length prefix")
+	}
+	length, err := strconv.Atoi(string(data[:colon]))
+	if err != nil || length < 0 || length > mitmMaxLength {
+		return nil, nil, fmt.Errorf("invalid length prefix %q", data[:colon])
+	}
+	if len(data) < colon+1+length+1 {
+		return nil, nil, fmt.Errorf("truncated value")
+	}
+	payload := data[colon+1 : colon+1+length]
+	tag, rest := data[colon+1+length], data[colon+1+length+1:]
+
+	switch tag {
+	case ',':
+		return payload, rest, nil
+	case ';':
+		return string(payload), rest, nil
+	case '#':
+		n, err := strconv.ParseInt(string(payload), 10, 64)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid integer %q", payload)
+		}
+		return n, rest, nil
+	case '^':
+		f, err := strconv.ParseFloat(string(payload), 64)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid float %q", payload)
+		}
+		return f, rest, nil
+	case '!':
+		switch string(payload) {
+		case "true":
+			return true, rest, nil
+		case "false":
+			return false, rest, nil
+		}
+		return nil, nil, fmt.Errorf("invalid boolean %q", payload)
+	case '~':
+		if length != 0 {
+			return nil, nil, fmt.Errorf("invalid null")
+		}
+		return nil, rest, nil
+	case ']':
+		list := make([]any, 0)
+		for len(payload) > 0 {
+			var item any
+			if item, payload, err = parseTnetstring(payload); err != nil {
+				return nil, nil, err
+			}
+			list = append(list, item)
+		}
+		return list, rest, nil
+	case '}':
+		dict := make(map[string]any)
+		for len(payload) > 0 {
+			var key, item any
+			if key, payload, err = parseTnetstring(payload); err != nil {
+				return nil, nil, err
+			}
+			if _, ok := key.([]byte); !ok {
+				if _, ok := key.(string); !ok {
+					return nil, nil, fmt.Errorf("dictionary key is not a string")
+				}
+			}
+			if len(payload) == 0 {
+				return nil, nil, fmt.Errorf("dictionary key %q has no value", mitmText(key))
+			}
+			if item, payload, err = parseTnetstring(payload); err != nil {
+				return nil, nil, err
+			}
+			dict[mitmText(key)] = item
+		}
+		return dict, rest, nil
+	}
+	return nil, nil, fmt.Errorf("unknown type tag %q", tag)
+}
+
+// appendTnetstring appends the tnetstring of value to buf. Dictionary keys
+// are written as text strings in sorted order, so output is deterministic.
+func appendTnetstring(buf []byte, value any) []byte {
+	var payload []byte
+	var tag byte
+	switch v := value.(type) {
+	case nil:
+		tag = '~'
+	case bool:
+		payload, tag = strconv.AppendBool(nil, v), '!'
+	case int64:
+		payload, tag = strconv.AppendInt(nil, v, 10), '#'
+	case float64:
+		payload, tag = strconv.AppendFloat(nil, v, 'f', -1, 64), '^'
+		if !bytes.ContainsRune(payload, '.') {
+			payload = append(payload, ".0"...)
+		}
+	case string:
+		payload, tag = []byte(v), ';'
+	case []byte:
+		payload, tag = v, ','
+	case []any:
+		for _, item := range v {
+			payload = appendTnetstring(payload, item)
+		}
+		tag = ']'
+	case map[string]any:
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			payload = appendTnetstring(payload, key)
+			payload = appendTnetstring(payload, v[key])
+		}
+		tag = '}'
+	default:
+		panic(fmt.Sprintf("appendTnetstring: unsupported type %T", value))
+	}
+	buf = strconv.AppendInt(buf, int64(len(payload)), 10)
+	buf = append(buf, ':')
+	buf = append(buf, payload...)
+	return append(buf, tag)
+}
@@ -0,0 +1,162 @@
+//go:build unit
+
+package proxy
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTnetstring(t *testing.T) {
+	value := map[string]any{
+		"text":   "héllo",
+		"bytes":  []byte("a:b,c"),
+		"int":    int64(-42),
+		"float":  1.5,
+		"whole":  2.0,
+		"true":   true,
+		"null":   nil,
+		"list":   []any{int64(1), []any{}, map[string]any{}},
+		"nested": map[string]any{"k": []byte("v")},
+	}
+	data := appendTnetstring(nil, value)
+	assert.Contains(t, string(data), "3:2.0^", "floats keep a decimal point")
+
+	parsed, rest, err := parseTnetstring(append(data, "trailing"...))
+	require.NoError(t, err)
+	assert.Equal(t, "trailing", string(rest))
+	assert.Equal(t, value, parsed)
+
+	for _, invalid := range []string{"", "5:abc,", "x:abc,", "3:abc?", "1:x!", "4:1:a,}", "2:ab#"} {
+		_, _, err := parseTnetstring([]byte(invalid))
+		assert.Error(t, err, invalid)
+	}
+}
+
+func TestMitmFlowsRoundTrip(t *testing.T) {
+	start := time.Date(2025, 3, 1, 10, 0, 0, 0, time.UTC)
+	records := []RequestRecord{
+		{
+			ID:                "second",
+			Timestamp:         start.Add(time.Second),
+			Method:            "GET",
+			URL:               "http://localhost:8080/health",
+			ClientAddr:        "10.0.0.7",
+			RequestHeaders:    map[string]string{},
+			UpstreamStartTime: start.Add(time.Second),
+			Error:             "connection refused",
+		},
+		{
+			ID:                "first",
+			Timestamp:         start,
+			Method:            "POST",
+			URL:               "https://api.example.com/orders?dry_run=1",
+			RequestHeaders:    map[string]string{"Content-Type": "application/json"},
+			RequestBody:       `{"sku":"A1"}`,
+			ResponseStatus:    201,
+			ResponseHeaders:   map[string]string{"Content-Type": "application/json"},
+			ResponseBody:      "{\"id\":42}\x00\xff",
+			UpstreamStartTime: start.Add(2 * time.Millisecond),
+			UpstreamLatencyUs: 30000,
+			ProxyEndTime:      start.Add(40 * time.Millisecond),
+			TotalDurationUs:   40000,
+			Success:           true,
+		},
+		{ID: "relative", Timestamp: start, Method: "GET", URL: "/no-host"},
+	}
+
+	var buf bytes.Buffer
+	written, err := WriteMitmFlows(&buf, records)
+	require.NoError(t, err)
+	assert.Equal(t, 2, written, "records without an absolute URL are skipped")
+
+	read, err := ReadRecords(bytes.NewReader(buf.Bytes()))
+	require.NoError(t, err)
+	require.Len(t, read, 2)
+	assert.Equal(t, []string{"second", "first"}, recordIDs(read))
+
+	failed, ok := read[0], read[1]
+	assert.Equal(t, "http://localhost:8080/health", failed.URL)
+	assert.Equal(t, "10.0.0.7", failed.ClientAddr)
+	assert.Equal(t, "connection refused", failed.Error)
+	assert.False(t, failed.Success)
+	assert.Zero(t, failed.ResponseStatus)
+
+	assert.Equal(t, "POST", ok.Method)
+	assert.Equal(t, "https://api.example.com/orders?dry_run=1", ok.URL)
+	assert.Equal(t, records[1].RequestHeaders, ok.RequestHeaders)
+	assert.Equal(t, `{"sku":"A1"}`, ok.RequestBody)
+	assert.Equal(t, 201, ok.ResponseStatus)
+	assert.Equal(t, records[1].ResponseBody, ok.ResponseBody)
+	assert.True(t, ok.Success)
+	assert.True(t, start.Equal(ok.Timestamp))
+	assert.Equal(t, int64(40000), ok.TotalDurationUs)
+	assert.Equal(t, int64(30000), ok.UpstreamLatencyUs)
+
+	// Writing is deterministic
+	var again bytes.Buffer
+	_, err = WriteMitmFlows(&again, read)
+	require.NoError(t, err)
+	assert.Equal(t, buf.String(), again.String())
+}
+
+func TestReadMitmFlows(t *testing.T) {
+	// Flow state as mitmproxy writes it, with an unrelated TCP flow
+	flows := appendTnetstring(nil, map[string]any{
+		"type": "tcp", "id": "tcp-flow", "messages": []any{},
+	})
+	flows = appendTnetstring(flows, map[string]any{
+		"version":     int64(20),
+		"type":        "http",
+		"id":          "7d6f3a10-3c55-4bb1-9a8f-2a6f8f1f6f0e",
+		"client_conn": map[string]any{"peername": []any{"::1", int64(51234)}},
+		"request": map[string]any{
+			"host": "example.com", "port": int64(8443), "method": []byte("PUT"),
+			"scheme": []byte("https"), "authority": []byte(""), "path": []byte("/items/1"),
+			"headers": []any{
+				[]any{[]byte("x-trace"), []byte("one")},
+				[]any{[]byte("X-Trace"), []byte("two")},
+			},
+			"content":         nil,
+			"timestamp_start": 1700000000.25,
+			"timestamp_end":   1700000000.5,
+		},
+		"response": map[string]any{
+			"status_code": int64(204), "headers": []any{}, "content": []byte(""),
+			"timestamp_start": 1700000001.0, "timestamp_end": int64(1700000001),
+		},
+		"error": nil,
+	})
+	flows = appendTnetstring(flows, map[string]any{
+		"type": "http", "id": "tunnel",
+		"request": map[string]any{
+			"host": "example.com", "port": int64(443), "method": []byte("CONNECT"),
+			"scheme": []byte(""), "authority": []byte("example.com:443"), "path": []byte(""),
+		},
+	})
+
+	records, err := readMitmFlows(flows)
+	require.NoError(t, err)
+	require.Len(t, records, 2)
+
+	record := records[0]
+	assert.Equal(t, "https://example.com:8443/items/1", record.URL)
+	assert.Equal(t, "::1", record.ClientAddr)
+	assert.Equal(t, map[string]string{"X-Trace": "one"}, record.RequestHeaders)
+	assert.True(t, record.BodiesOmitted)
+	assert.Equal(t, 204, record.ResponseStatus)
+	assert.Equal(t, time.Unix(1700000000, 250_000_000).UTC(), record.Timestamp)
+	assert.Equal(t, int64(750_000), record.TotalDurationUs)
+	assert.Equal(t, int64(500_000), record.UpstreamLatencyUs)
+
+	assert.Equal(t, "example.com:443", records[1].URL)
+
+	_, err = readMitmFlows(appendTnetstring(nil, map[string]any{"type": "dns"}))
+	assert.ErrorContains(t, err, "no HTTP flows")
+	_, err = readMitmFlows([]byte("5:hello,"))
+	assert.ErrorContains(t, err, "invalid flow 1: not a dictionary")
+}
@@ -0,0 +1,167 @@
+package proxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RequestOptionsHeader carries per-request overrides of proxy behavior
+const RequestOptionsHeader = "X-Netkit-Options"
+
+// Per-request options clients may set in X-Netkit-Options
+const (
+	OptionCapture  = "capture"  // off or metadata: capture less of this request
+	OptionNoCache  = "no-cache" // Skip the conditional GET cache
+	OptionTimeout  = "timeout"  // Upstream timeout, e.g. 2s
+	OptionTags     = "tags"     // Labels stored on the record
+	OptionUpstream = "upstream" // Send the request to this scheme and host instead
+)
+
+// requestOptions lists every option, so allowlists can be validated
+var requestOptions = []string{OptionCapture, OptionNoCache, OptionTimeout, OptionTags, OptionUpstream}
+
+// RequestOptions are the overrides a client asked for on one request
+type RequestOptions struct {
+	Capture  string
+	NoCache  bool
+	Timeout  time.Duration
+	Tags     []string
+	Upstream *url.URL
+}
+
+// ValidateRequestOptions checks that an allowlist only names known options
+func ValidateRequestOptions(allowed []string) error {
+	for _, name := range allowed {
+		if !containsString(requestOptions, name) {
+			return fmt.Errorf("unknown option %q (expected %s)", name, strings.Join(requestOptions, ", "))
+		}
+	}
+	return nil
+}
+
+// ParseRequestOptions parses an X-Netkit-Options value, either a JSON object
+// ({"capture": "off", "tags": ["checkout"]}) or key=value pairs separated by
+// semicolons (capture=off; tags=checkout,retry; no-cache). Options outside
+// the allowlist are rejected.
+func ParseRequestOptions(header string, allowed []string) (*RequestOptions, error) {
+	values, err := splitRequestOptions(header)
+	if err != nil {
+		return nil, err
+	}
+
+	// Report problems in a stable order
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	options := &RequestOptions{}
+	for _, name := range names {
+		value := values[name]
+		if !containsString(requestOptions, name) {
+			return nil, fmt.Errorf("unknown option %q", name)
+		}
+		if !containsString(allowed, name) {
+			return nil, fmt.Errorf("option %q is not permitted", name)
+		}
+
+		switch name {
+		case OptionCapture:
+			if value != CaptureOff && value != CaptureMetadata {
+				return nil, fmt.Errorf("capture must be %s or %s", CaptureOff, CaptureMetadata)
+			}
+			options.Capture = value
+		case OptionNoCache:
+			if options.NoCache, err = strconv.ParseBool(value); err != nil {
+				return nil, fmt.Errorf("invalid no-cache: expected true or false")
+			}
+		case OptionTimeout:
+			if options.Timeout, err = time.ParseDuration(value); err != nil || options.Timeout <= 0 {
+				return nil, fmt.Errorf("invalid timeout: expected a positive duration such as 2s")
+			}
+		case OptionTags:
+			options.Tags = splitFilterList([]string{value})
+		case OptionUpstream:
+			if options.Upstream, err = parseUpstreamURL(value); err != nil {
+				return nil, fmt.Errorf("invalid upstream: %v", err)
+			}
+		}
+	}
+	return options, nil
+}
+
+// splitRequestOptions turns either header form into option values, with
+// lists joined by commas and flags without a value set to true
+func splitRequestOptions(header string) (map[string]string, error) {
+	header = strings.TrimSpace(header)
+	values := make(map[string]string)
+
+	if strings.HasPrefix(header, "{") {
+		var raw map[string]interface{}
+		if err := json.Unmarshal([]byte(header), &raw); err != nil {
+			return nil, fmt.Errorf("invalid JSON: %v", err)
+		}
+		for name, value := range raw {
+			switch v := value.(type) {
+			case string:
+				values[name] = v
+			case bool:
+				values[name] = strconv.FormatBool(v)
+			case []interface{}:
+				parts := make([]string, 0, len(v))
+				for _, item := range v {
+					text, ok := item.(string)
+					if !ok {
+						return nil, fmt.Errorf("option %q must be a list of strings", name)
+					}
+					parts = append(parts, text)
+				}
+				values[name] = strings.Join(parts, ",")
+			default:
+				return nil, fmt.Errorf("option %q must be a string, boolean, or list of strings", name)
+			}
+		}
+		return values, nil
+	}
+
+	for _, pair := range strings.Split(header, ";") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		name, value, ok := strings.Cut(pair, "=")
+		if !ok {
+			value = "true"
+		}
+		values[strings.ToLower(strings.TrimSpace(name))] = strings.TrimSpace(value)
+	}
+	return values, nil
+}
+
+// parseUpstreamURL parses an http or https URL whose scheme and host
+// replace those of proxied requests
+func parseUpstreamURL(value string) (*url.URL, error) {
+	upstream, err := url.Parse(value)
+	if err != nil || (upstream.Scheme != "http" && upstream.Scheme != "https") || upstream.Host == "" {
+		return nil, fmt.Errorf("expected an http or https URL such as http://localhost:8081, got %q", value)
+	}
+	return upstream, nil
+}
+
+// applyUpstream points the target at the upstream's scheme and host, keeping
+// the path and query
+func (o *RequestOptions) applyUpstream(target *url.URL) *url.URL {
+	if o.Upstream == nil {
+		return target
+	}
+	rewritten := *target
+	rewritten.Scheme = o.Upstream.Scheme
+	rewritten.Host = o.Upstream.Host
+	return &rewritten
+}
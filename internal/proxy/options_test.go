@@ -0,0 +1,121 @@
+//go:build unit
+
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseRequestOptions(t *testing.T) {
+	allowed := []string{OptionCapture, OptionNoCache, OptionTimeout, OptionTags, OptionUpstream}
+
+	options, err := ParseRequestOptions("capture=metadata; tags=checkout, retry; no-cache; timeout=2s", allowed)
+	require.NoError(t, err)
+	assert.Equal(t, CaptureMetadata, options.Capture)
+	assert.Equal(t, []string{"checkout", "retry"}, options.Tags)
+	assert.True(t, options.NoCache)
+	assert.Equal(t, 2*time.Second, options.Timeout)
+
+	options, err = ParseRequestOptions(`{"tags": ["a", "b"], "no-cache": false, "upstream": "http://localhost:8081/ignored"}`, allowed)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a", "b"}, options.Tags)
+	assert.False(t, options.NoCache)
+	require.NotNil(t, options.Upstream)
+	assert.Equal(t, "localhost:8081", options.Upstream.Host)
+
+	for header, message := range map[string]string{
+		"colour=red":                 "unknown option",
+		"capture=full":               "capture must be",
+		"timeout=-1s":                "invalid timeout",
+		"no-cache=maybe":             "invalid no-cache",
+		"upstream=ftp://example.com": "invalid upstream",
+		`{"timeout": 2}`:             "must be a string",
+		`{"tags": [1]}`:              "list of strings",
+		`{"capture": `:               "invalid JSON",
+	} {
+		_, err := ParseRequestOptions(header, allowed)
+		assert.ErrorContains(t, err, message, header)
+	}
+
+	_, err = ParseRequestOptions("upstream=http://localhost:8081", []string{OptionTags})
+	assert.ErrorContains(t, err, "not permitted")
+}
+
+func TestValidateRequestOptions(t *testing.T) {
+	assert.NoError(t, ValidateRequestOptions([]string{OptionTags, OptionUpstream}))
+	assert.Error(t, ValidateRequestOptions([]string{"retries"}))
+}
+
+func TestRequestOptionsProxying(t *testing.T) {
+	var forwardedOptions int32
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get(RequestOptionsHeader) != "" {
+			atomic.AddInt32(&forwardedOptions, 1)
+		}
+		if r.URL.Path == "/slow" {
+			time.Sleep(200 * time.Millisecond)
+		}
+		w.Header().Set("ETag", `"v1"`)
+		if _, err := w.Write([]byte("ok " + r.URL.RawQuery)); err != nil {
+			t.Logf("Error writing response: %v", err)
+		}
+	}))
+	defer upstream.Close()
+
+	p := New(&Config{
+		ConditionalGET: true,
+		AllowedOptions: []string{OptionCapture, OptionNoCache, OptionTimeout, OptionTags, OptionUpstream},
+	})
+	send := func(target, options string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, target, nil)
+		req.Header.Set(RequestOptionsHeader, options)
+		rec := httptest.NewRecorder()
+		p.ServeHTTP(rec, req)
+		return rec
+	}
+
+	// Tags are recorded, the cache is bypassed, and the header stays at the proxy
+	rec := send(upstream.URL+"/cart", "tags=checkout; no-cache")
+	require.Equal(t, http.StatusOK, rec.Code)
+	record := p.history.GetRecords()[0]
+	assert.Equal(t, []string{"checkout"}, record.Tags)
+	assert.Equal(t, CacheStatusBypass, record.CacheStatus)
+
+	// The upstream option rewrites the scheme and host only
+	rec = send("http://api.example.invalid/cart?id=1", "upstream="+upstream.URL)
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "ok id=1", rec.Body.String())
+	assert.Equal(t, upstream.URL+"/cart?id=1", p.history.GetRecords()[0].URL)
+
+	rec = send(upstream.URL+"/slow", "timeout=20ms")
+	assert.Equal(t, http.StatusGatewayTimeout, rec.Code)
+	assert.Equal(t, "Upstream timed out", p.history.GetRecords()[0].Error)
+
+	// Opting out of capture proxies the request without recording it
+	before := len(p.history.GetRecords())
+	rec = send(upstream.URL+"/secret", "capture=off")
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Len(t, p.history.GetRecords(), before)
+
+	rec = send(upstream.URL+"/cart", "retries=3")
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.Contains(t, p.history.GetRecords()[0].Error, "unknown option")
+	assert.Zero(t, atomic.LoadInt32(&forwardedOptions), "the header is not forwarded upstream")
+}
+
+func TestRequestOptionsNotPermittedByDefault(t *testing.T) {
+	p := New(&Config{})
+	req := httptest.NewRequest(http.MethodGet, "http://api.example.invalid/", nil)
+	req.Header.Set(RequestOptionsHeader, "tags=checkout")
+	rec := httptest.NewRecorder()
+	p.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.Contains(t, rec.Body.String(), "not permitted")
+}
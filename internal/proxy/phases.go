@@ -0,0 +1,150 @@
+package proxy
+
+import (
+	"crypto/tls"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"sync"
+	"time"
+)
+
+// PhaseTimings breaks the upstream exchange of a record down the way HAR
+// timings do, in microseconds. Phases that did not happen, such as DNS and
+// connect on a reused connection, are -1. Connect includes TLS.
+type PhaseTimings struct {
+	BlockedUs int64 `json:"blocked_us"` // Waiting for a connection before DNS or connect started
+	DNSUs     int64 `json:"dns_us"`
+	ConnectUs int64 `json:"connect_us"`
+	TLSUs     int64 `json:"tls_us"`
+	SendUs    int64 `json:"send_us"`    // Writing the request
+	WaitUs    int64 `json:"wait_us"`    // From the request written to the first response byte
+	ReceiveUs int64 `json:"receive_us"` // Reading the response body
+
+	ConnectionReused bool `json:"connection_reused,omitempty"`
+}
+
+// phaseTrace collects the httptrace events of a request's upstream exchange.
+// Each exchange starts over, so with followed redirects the final one is
+// kept.
+type phaseTrace struct {
+	mutex sync.Mutex
+	phaseEvents
+}
+
+// phaseEvents are the times of an exchange's httptrace events
+type phaseEvents struct {
+	getConn, gotConn          time.Time
+	dnsStart, dnsDone         time.Time
+	connectStart, connectDone time.Time
+	tlsStart, tlsDone         time.Time
+	wroteRequest, firstByte   time.Time
+	reused                    bool
+	remote                    net.Addr
+}
+
+// withPhaseTrace traces the phases of a request's upstream exchanges
+func withPhaseTrace(req *http.Request) (*http.Request, *phaseTrace) {
+	pt := &phaseTrace{}
+	set := func(field *time.Time) {
+		pt.mutex.Lock()
+		defer pt.mutex.Unlock()
+		*field = time.Now()
+	}
+	trace := &httptrace.ClientTrace{
+		GetConn: func(string) {
+			pt.mutex.Lock()
+			defer pt.mutex.Unlock()
+			pt.phaseEvents = phaseEvents{getConn: time.Now()}
+		},
+		GotConn: func(info httptrace.GotConnInfo) {
+			pt.mutex.Lock()
+			defer pt.mutex.Unlock()
+			pt.gotConn, pt.reused = time.Now(), info.Reused
+			if info.Conn != nil {
+				pt.remote = info.Conn.RemoteAddr()
+			}
+		},
+		DNSStart: func(httptrace.DNSStartInfo) { set(&pt.dnsStart) },
+		DNSDone:  func(httptrace.DNSDoneInfo) { set(&pt.dnsDone) },
+		ConnectStart: func(string, string) {
+			pt.mutex.Lock()
+			defer pt.mutex.Unlock()
+			// Dual-stack dialing may start several connects; time the first
+			if pt.connectStart.IsZero() {
+				pt.connectStart = time.Now()
+			}
+		},
+		ConnectDone: func(_, _ string, err error) {
+			if err == nil {
+				set(&pt.connectDone)
+			}
+		},
+		TLSHandshakeStart:    func() { set(&pt.tlsStart) },
+		TLSHandshakeDone:     func(tls.ConnectionState, error) { set(&pt.tlsDone) },
+		WroteRequest:         func(httptrace.WroteRequestInfo) { set(&pt.wroteRequest) },
+		GotFirstResponseByte: func() { set(&pt.firstByte) },
+	}
+	return req.WithContext(httptrace.WithClientTrace(req.Context(), trace)), pt
+}
+
+// result returns the phases of the last exchange, with the response body read
+// by end. It is nil when the exchange did not get as far as a response.
+func (pt *phaseTrace) result(end time.Time) *PhaseTimings {
+	pt.mutex.Lock()
+	defer pt.mutex.Unlock()
+
+	if pt.getConn.IsZero() || pt.gotConn.IsZero() || pt.wroteRequest.IsZero() || pt.firstByte.IsZero() {
+		return nil
+	}
+	phases := &PhaseTimings{DNSUs: -1, ConnectUs: -1, TLSUs: -1, ConnectionReused: pt.reused}
+
+	// Blocked until the connection was being set up, or handed over when reused
+	blockedEnd := pt.gotConn
+	if !pt.reused {
+		for _, start := range []time.Time{pt.dnsStart, pt.connectStart, pt.tlsStart} {
+			if !start.IsZero() {
+				blockedEnd = start
+				break
+			}
+		}
+	}
+	phases.BlockedUs = phaseSpan(pt.getConn, blockedEnd)
+	if !pt.reused {
+		if !pt.dnsStart.IsZero() && !pt.dnsDone.IsZero() {
+			phases.DNSUs = phaseSpan(pt.dnsStart, pt.dnsDone)
+		}
+		if !pt.connectStart.IsZero() && !pt.connectDone.IsZero() {
+			connectEnd := pt.connectDone
+			if pt.tlsDone.After(connectEnd) {
+				connectEnd = pt.tlsDone
+			}
+			phases.ConnectUs = phaseSpan(pt.connectStart, connectEnd)
+		}
+		if !pt.tlsStart.IsZero() && !pt.tlsDone.IsZero() {
+			phases.TLSUs = phaseSpan(pt.tlsStart, pt.tlsDone)
+		}
+	}
+	phases.SendUs = phaseSpan(pt.gotConn, pt.wroteRequest)
+	phases.WaitUs = phaseSpan(pt.wroteRequest, pt.firstByte)
+	phases.ReceiveUs = phaseSpan(pt.firstByte, end)
+	return phases
+}
+
+// endpoint returns the address the last exchange was connected to and its IP
+// family, or empty strings when it got no connection
+func (pt *phaseTrace) endpoint() (addr, family string) {
+	pt.mutex.Lock()
+	defer pt.mutex.Unlock()
+
+	tcp, ok := pt.remote.(*net.TCPAddr)
+	if !ok {
+		return "", ""
+	}
+	return tcp.String(), ipFamily(tcp.IP)
+}
+
+// phaseSpan is the time from start to end in microseconds, never negative
+func phaseSpan(start, end time.Time) int64 {
+	return max(end.Sub(start).Microseconds(), 0)
+}
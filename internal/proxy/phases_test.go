@@ -0,0 +1,51 @@
+//go:build unit
+
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPhaseTimings(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(20 * time.Millisecond)
+		if _, err := w.Write([]byte("ok")); err != nil {
+			t.Logf("Error writing response: %v", err)
+		}
+	}))
+	defer upstream.Close()
+
+	p := New(&Config{})
+	send := func() RequestRecord {
+		rec := httptest.NewRecorder()
+		p.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, upstream.URL+"/slow", strings.NewReader("ping")))
+		require.Equal(t, http.StatusOK, rec.Code)
+		return p.history.GetRecords()[0]
+	}
+
+	phases := send().Phases
+	require.NotNil(t, phases)
+	assert.False(t, phases.ConnectionReused)
+	assert.Equal(t, int64(-1), phases.DNSUs, "the upstream is dialed by IP")
+	assert.GreaterOrEqual(t, phases.ConnectUs, int64(0))
+	assert.Equal(t, int64(-1), phases.TLSUs)
+	assert.GreaterOrEqual(t, phases.WaitUs, (20 * time.Millisecond).Microseconds())
+
+	phases = send().Phases
+	require.NotNil(t, phases)
+	assert.True(t, phases.ConnectionReused)
+	assert.Equal(t, int64(-1), phases.ConnectUs)
+
+	// Exchanges that got no response have no phases
+	upstream.Close()
+	rec := httptest.NewRecorder()
+	p.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, upstream.URL+"/gone", nil))
+	assert.Nil(t, p.history.GetRecords()[0].Phases)
+}
@@ -0,0 +1,155 @@
+package proxy
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Cache status values recorded on CORS preflights when --preflight-cache is on
+const (
+	CacheStatusPreflightHit  = "preflight-hit"  // Answered locally from an earlier upstream preflight
+	CacheStatusPreflightMiss = "preflight-miss" // Forwarded upstream, and cached if the upstream allowed it
+)
+
+// DefaultPreflightMaxAge is how long a preflight is cached when the upstream
+// does not ask for less with Access-Control-Max-Age
+const DefaultPreflightMaxAge = 10 * time.Minute
+
+// preflightCacheSize bounds the number of cached preflights
+const preflightCacheSize = 1000
+
+// cachedPreflight is an upstream answer to a CORS preflight
+type cachedPreflight struct {
+	status  int
+	header  http.Header
+	expires time.Time
+}
+
+// preflightCache keeps upstream answers to CORS preflights so later
+// preflights for the same origin and route skip the round trip
+type preflightCache struct {
+	entries map[string]*cachedPreflight
+	order   []string // Insertion order used for eviction
+	mutex   sync.Mutex
+	hits    atomic.Int64
+	misses  atomic.Int64
+}
+
+// newPreflightCache creates an empty preflight cache
+func newPreflightCache() *preflightCache {
+	return &preflightCache{entries: make(map[string]*cachedPreflight)}
+}
+
+// isPreflightRequest reports whether r is a CORS preflight sent by a browser
+func isPreflightRequest(r *http.Request) bool {
+	return r.Method == http.MethodOptions && r.Header.Get("Origin") != "" && r.Header.Get("Access-Control-Request-Method") != ""
+}
+
+// preflightCacheKey identifies a preflight by its origin, the route it asks
+// about, and the method and headers the browser wants to send. The query is
+// left out since CORS policies rarely depend on it.
+func preflightCacheKey(header http.Header, target *url.URL) string {
+	var requested []string
+	for _, value := range header.Values("Access-Control-Request-Headers") {
+		for _, name := range strings.Split(value, ",") {
+			if name = strings.ToLower(strings.TrimSpace(name)); name != "" {
+				requested = append(requested, name)
+			}
+		}
+	}
+	sort.Strings(requested)
+	route := target.Scheme + "://" + target.Host + target.EscapedPath()
+	return strings.Join([]string{header.Get("Origin"), route, header.Get("Access-Control-Request-Method"), strings.Join(requested, ",")}, "\n")
+}
+
+// lookup returns the unexpired preflight cached for key, counting the hit or
+// miss. Expired entries stay until they are replaced or evicted.
+func (c *preflightCache) lookup(key string) *cachedPreflight {
+	c.mutex.Lock()
+	entry, ok := c.entries[key]
+	c.mutex.Unlock()
+	if !ok || !time.Now().Before(entry.expires) {
+		c.misses.Add(1)
+		return nil
+	}
+	c.hits.Add(1)
+	return entry
+}
+
+// store caches a successful preflight for maxAge, or for less when the
+// upstream's Access-Control-Max-Age asks for it
+func (c *preflightCache) store(key string, resp *http.Response, maxAge time.Duration) {
+	if resp.StatusCode < 200 || resp.StatusCode > 299 || resp.Header.Get("Access-Control-Allow-Origin") == "" {
+		return
+	}
+	if maxAge <= 0 {
+		maxAge = DefaultPreflightMaxAge
+	}
+	if value := resp.Header.Get("Access-Control-Max-Age"); value != "" {
+		seconds, err := strconv.Atoi(strings.TrimSpace(value))
+		if err != nil || seconds <= 0 {
+			return
+		}
+		maxAge = min(maxAge, time.Duration(seconds)*time.Second)
+	}
+
+	header := resp.Header.Clone()
+	header.Del("Date")
+	header.Del("Content-Length")
+	entry := &cachedPreflight{status: resp.StatusCode, header: header, expires: time.Now().Add(maxAge)}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if _, exists := c.entries[key]; !exists {
+		c.order = append(c.order, key)
+	}
+	c.entries[key] = entry
+
+	// Evict the oldest entries beyond max size
+	for len(c.order) > preflightCacheSize {
+		delete(c.entries, c.order[0])
+		c.order = c.order[1:]
+	}
+}
+
+// len returns the number of cached preflights
+func (c *preflightCache) len() int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return len(c.entries)
+}
+
+// servePreflight answers a preflight from the cache and records it
+func (p *Proxy) servePreflight(w http.ResponseWriter, entry *cachedPreflight, record *RequestRecord) {
+	record.CacheStatus = CacheStatusPreflightHit
+	record.ResponseStatus = entry.status
+	record.ResponseHeaders = convertHeaders(entry.header)
+	record.Success = true
+	record.ProxyEndTime = time.Now()
+
+	for key, values := range entry.header {
+		w.Header()[key] = values
+	}
+	p.setTimingHeaders(w.Header(), record)
+	w.WriteHeader(entry.status)
+	p.recordRequest(*record)
+}
+
+// writePreflightMetrics appends the preflight cache's hits, misses, and size in
+// the Prometheus text format
+func (c *preflightCache) writePreflightMetrics(b *strings.Builder) {
+	b.WriteString("\n# HELP netkit_preflight_cache_requests_total CORS preflights by whether they were answered from the cache\n")
+	b.WriteString("# TYPE netkit_preflight_cache_requests_total counter\n")
+	fmt.Fprintf(b, "netkit_preflight_cache_requests_total{result=%q} %d\n", "hit", c.hits.Load())
+	fmt.Fprintf(b, "netkit_preflight_cache_requests_total{result=%q} %d\n", "miss", c.misses.Load())
+	b.WriteString("# HELP netkit_preflight_cache_entries CORS preflights currently cached\n")
+	b.WriteString("# TYPE netkit_preflight_cache_entries gauge\n")
+	fmt.Fprintf(b, "netkit_preflight_cache_entries %d\n", c.len())
+}
@@ -0,0 +1,124 @@
+//go:build unit
+
+package proxy
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newPreflight(target, origin, method, headers string) *http.Request {
+	req := httptest.NewRequest(http.MethodOptions, target, nil)
+	req.Header.Set("Origin", origin)
+	req.Header.Set("Access-Control-Request-Method", method)
+	if headers != "" {
+		req.Header.Set("Access-Control-Request-Headers", headers)
+	}
+	return req
+}
+
+func TestPreflightCache(t *testing.T) {
+	preflights := 0
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		preflights++
+		w.Header().Set("Access-Control-Allow-Origin", r.Header.Get("Origin"))
+		w.Header().Set("Access-Control-Allow-Methods", "GET, PUT")
+		w.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type")
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer upstream.Close()
+
+	p := New(&Config{PreflightCache: true, TimingHeaders: true})
+	rec := httptest.NewRecorder()
+	p.ServeHTTP(rec, newPreflight(upstream.URL+"/users?page=1", "http://localhost:3000", "PUT", "Content-Type, Authorization"))
+	assert.Equal(t, http.StatusNoContent, rec.Code)
+	assert.Equal(t, "http://localhost:3000", rec.Header().Get("Access-Control-Allow-Origin"), "the upstream's policy replaces the proxy's")
+	assert.Equal(t, CacheStatusPreflightMiss, rec.Header().Get("X-Netkit-Cache"))
+
+	// The query and the order of the requested headers do not matter
+	rec = httptest.NewRecorder()
+	p.ServeHTTP(rec, newPreflight(upstream.URL+"/users?page=2", "http://localhost:3000", "PUT", "authorization,content-type"))
+	assert.Equal(t, http.StatusNoContent, rec.Code)
+	assert.Equal(t, "GET, PUT", rec.Header().Get("Access-Control-Allow-Methods"))
+	assert.Equal(t, CacheStatusPreflightHit, rec.Header().Get("X-Netkit-Cache"))
+	assert.Equal(t, 1, preflights)
+
+	// Other origins, methods, and routes are forwarded
+	p.ServeHTTP(httptest.NewRecorder(), newPreflight(upstream.URL+"/users", "http://localhost:5173", "PUT", "Content-Type, Authorization"))
+	p.ServeHTTP(httptest.NewRecorder(), newPreflight(upstream.URL+"/users", "http://localhost:3000", "DELETE", "Content-Type, Authorization"))
+	p.ServeHTTP(httptest.NewRecorder(), newPreflight(upstream.URL+"/orders", "http://localhost:3000", "PUT", "Content-Type, Authorization"))
+	assert.Equal(t, 4, preflights)
+
+	// OPTIONS requests that are not preflights are still answered locally
+	p.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodOptions, upstream.URL+"/users", nil))
+	assert.Equal(t, 4, preflights)
+
+	records := p.history.GetRecords()
+	require.Len(t, records, 5)
+	assert.Equal(t, CacheStatusPreflightHit, records[3].CacheStatus)
+	assert.Equal(t, http.StatusNoContent, records[3].ResponseStatus)
+	assert.Zero(t, records[3].UpstreamLatencyUs)
+
+	rec = httptest.NewRecorder()
+	p.handleRequestStats(rec, httptest.NewRequest(http.MethodGet, "/requests/stats", nil))
+	var stats struct {
+		PreflightCache map[string]int `json:"preflight_cache"`
+	}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &stats))
+	assert.Equal(t, map[string]int{"hits": 1, "misses": 4}, stats.PreflightCache)
+
+	rec = httptest.NewRecorder()
+	p.handleMetrics(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	assert.Contains(t, rec.Body.String(), "netkit_preflight_cache_requests_total{result=\"hit\"} 1\n")
+	assert.Contains(t, rec.Body.String(), "netkit_preflight_cache_requests_total{result=\"miss\"} 4\n")
+	assert.Contains(t, rec.Body.String(), "netkit_preflight_cache_entries 4\n")
+}
+
+func TestPreflightCacheMaxAge(t *testing.T) {
+	maxAge, status := "", http.StatusOK
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		if maxAge != "" {
+			w.Header().Set("Access-Control-Max-Age", maxAge)
+		}
+		w.WriteHeader(status)
+	}))
+	defer upstream.Close()
+
+	cases := []struct {
+		maxAge   string
+		status   int
+		cached   int
+		lifetime time.Duration
+	}{
+		{"", http.StatusOK, 1, time.Minute},
+		{"5", http.StatusOK, 1, 5 * time.Second},
+		{"3600", http.StatusOK, 1, time.Minute},
+		{"0", http.StatusOK, 0, 0},
+		{"", http.StatusForbidden, 0, 0},
+	}
+	for _, tc := range cases {
+		maxAge, status = tc.maxAge, tc.status
+		p := New(&Config{PreflightCache: true, PreflightMaxAge: time.Minute})
+		p.ServeHTTP(httptest.NewRecorder(), newPreflight(upstream.URL, "http://localhost:3000", "POST", ""))
+		require.Equal(t, tc.cached, p.preflights.len(), tc)
+		for _, entry := range p.preflights.entries {
+			assert.WithinDuration(t, time.Now().Add(tc.lifetime), entry.expires, time.Second, tc)
+		}
+	}
+
+	// Expired preflights are forwarded again
+	maxAge, status = "", http.StatusOK
+	p := New(&Config{PreflightCache: true, PreflightMaxAge: time.Millisecond})
+	p.ServeHTTP(httptest.NewRecorder(), newPreflight(upstream.URL, "http://localhost:3000", "POST", ""))
+	time.Sleep(5 * time.Millisecond)
+	p.ServeHTTP(httptest.NewRecorder(), newPreflight(upstream.URL, "http://localhost:3000", "POST", ""))
+	assert.Equal(t, int64(2), p.preflights.misses.Load())
+	assert.Equal(t, 1, p.preflights.len())
+}
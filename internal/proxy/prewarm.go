@@ -0,0 +1,340 @@
+package proxy
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// Connection prewarming settings
+const (
+	defaultPrewarmConnections = 2
+	prewarmRefreshInterval    = 5 * time.Second
+	prewarmDialTimeout        = 10 * time.Second
+	// Warm connections are replaced after this long, before upstreams close them as idle
+	prewarmMaxIdle = 30 * time.Second
+)
+
+// PrewarmStatus is the warm-up state of one upstream, reported by /readyz
+type PrewarmStatus struct {
+	Upstream  string     `json:"upstream"`
+	Addresses []string   `json:"addresses,omitempty"` // Resolved IP addresses
+	Warm      int        `json:"warm"`                // Idle connections ready for the next requests
+	Target    int        `json:"target"`
+	Ready     bool       `json:"ready"` // The first warm-up attempt has finished
+	WarmedAt  *time.Time `json:"warmed_at,omitempty"`
+	Error     string     `json:"error,omitempty"` // Last DNS, dial, or TLS error
+}
+
+// ParsePrewarmTarget parses an upstream to prewarm, such as https://api.example.com
+func ParsePrewarmTarget(value string) (*url.URL, error) {
+	return parseUpstreamURL(value)
+}
+
+// warmConn is an established connection waiting for its first request
+type warmConn struct {
+	conn    net.Conn
+	created time.Time
+}
+
+// warmUpstream holds the warm connections of one upstream
+type warmUpstream struct {
+	scheme    string
+	host      string // Hostname, used for DNS and TLS server names
+	port      string
+	conns     []warmConn
+	addresses []string
+	attempted bool
+	warmedAt  time.Time
+	err       string
+}
+
+func (u *warmUpstream) name() string {
+	return u.scheme + "://" + net.JoinHostPort(u.host, u.port)
+}
+
+// connectionPrewarmer resolves upstreams and keeps a floor of established
+// connections to each, handed to the proxy's transport before it dials anew
+type connectionPrewarmer struct {
+	mutex     sync.Mutex
+	target    int
+	upstreams []*warmUpstream
+	dialer    *upstreamDialer
+	refill    chan struct{}
+	cancel    context.CancelFunc
+	wg        sync.WaitGroup
+}
+
+// startPrewarmer opens connections with dialer, over the addresses its IP
+// family rules allow
+func startPrewarmer(targets []*url.URL, connections int, dialer *upstreamDialer) *connectionPrewarmer {
+	if connections <= 0 {
+		connections = defaultPrewarmConnections
+	}
+	prewarmer := &connectionPrewarmer{
+		target: connections,
+		dialer: dialer,
+		refill: make(chan struct{}, 1),
+	}
+	for _, target := range targets {
+		port := target.Port()
+		if port == "" {
+			port = "80"
+			if target.Scheme == "https" {
+				port = "443"
+			}
+		}
+		prewarmer.upstreams = append(prewarmer.upstreams, &warmUpstream{scheme: target.Scheme, host: target.Hostname(), port: port})
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	prewarmer.cancel = cancel
+	prewarmer.wg.Add(1)
+	go func() {
+		defer prewarmer.wg.Done()
+		ticker := time.NewTicker(prewarmRefreshInterval)
+		defer ticker.Stop()
+		for {
+			prewarmer.warm(ctx)
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			case <-prewarmer.refill:
+			}
+		}
+	}()
+	return prewarmer
+}
+
+// warm replaces stale connections and tops every upstream up to the target
+func (w *connectionPrewarmer) warm(ctx context.Context) {
+	for _, upstream := range w.upstreams {
+		w.mutex.Lock()
+		fresh := upstream.conns[:0]
+		for _, warm := range upstream.conns {
+			if time.Since(warm.created) < prewarmMaxIdle {
+				fresh = append(fresh, warm)
+			} else if err := warm.conn.Close(); err != nil {
+				slog.Warn("Error closing idle prewarmed connection", "error", err)
+			}
+		}
+		upstream.conns = fresh
+		missing := w.target - len(upstream.conns)
+		w.mutex.Unlock()
+
+		if missing <= 0 {
+			continue
+		}
+		addresses, conns, err := w.dialUpstream(ctx, upstream, missing)
+
+		w.mutex.Lock()
+		upstream.attempted = true
+		upstream.conns = append(upstream.conns, conns...)
+		if len(addresses) > 0 {
+			upstream.addresses = addresses
+		}
+		upstream.err = ""
+		if err != nil {
+			upstream.err = err.Error()
+		}
+		if len(conns) > 0 {
+			upstream.warmedAt = time.Now()
+		}
+		w.mutex.Unlock()
+	}
+}
+
+// dialUpstream resolves the upstream and opens count connections, completing
+// the TLS handshake for https upstreams
+func (w *connectionPrewarmer) dialUpstream(ctx context.Context, upstream *warmUpstream, count int) ([]string, []warmConn, error) {
+	ctx, cancel := context.WithTimeout(ctx, prewarmDialTimeout)
+	defer cancel()
+
+	addresses, err := w.dialer.resolver().LookupHost(ctx, upstream.host)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to resolve %s: %v", upstream.host, err)
+	}
+	if addresses = w.dialer.order(upstream.host, addresses); len(addresses) == 0 {
+		return nil, nil, fmt.Errorf("%s has no addresses of the IP family its rule allows", upstream.host)
+	}
+
+	var conns []warmConn
+	for i := 0; i < count; i++ {
+		// Spread connections over the resolved addresses
+		addr := net.JoinHostPort(addresses[i%len(addresses)], upstream.port)
+		conn, err := w.dial(ctx, upstream.scheme, upstream.host, addr)
+		if err != nil {
+			return addresses, conns, err
+		}
+		conns = append(conns, warmConn{conn: conn, created: time.Now()})
+	}
+	return addresses, conns, nil
+}
+
+// dial connects to addr, wrapping the connection in TLS for https upstreams
+func (w *connectionPrewarmer) dial(ctx context.Context, scheme, host, addr string) (net.Conn, error) {
+	conn, err := w.dialer.DialContext(ctx, "tcp", addr)
+	if err != nil || scheme != "https" {
+		return conn, err
+	}
+	tlsConn := tls.Client(conn, &tls.Config{ServerName: host, NextProtos: []string{"h2", "http/1.1"}})
+	if err := tlsConn.HandshakeContext(ctx); err != nil {
+		if closeErr := conn.Close(); closeErr != nil {
+			slog.Warn("Error closing connection after failed TLS handshake", "error", closeErr)
+		}
+		return nil, fmt.Errorf("TLS handshake with %s failed: %v", host, err)
+	}
+	return tlsConn, nil
+}
+
+// take hands out a warm connection to the upstream at addr, if there is one
+func (w *connectionPrewarmer) take(scheme, addr string) net.Conn {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	for _, upstream := range w.upstreams {
+		if upstream.scheme != scheme || net.JoinHostPort(upstream.host, upstream.port) != addr {
+			continue
+		}
+		for len(upstream.conns) > 0 {
+			warm := upstream.conns[len(upstream.conns)-1]
+			upstream.conns = upstream.conns[:len(upstream.conns)-1]
+			if time.Since(warm.created) < prewarmMaxIdle {
+				// Top the pool back up in the background
+				select {
+				case w.refill <- struct{}{}:
+				default:
+				}
+				return warm.conn
+			}
+			if err := warm.conn.Close(); err != nil {
+				slog.Warn("Error closing idle prewarmed connection", "error", err)
+			}
+		}
+	}
+	return nil
+}
+
+// transport returns an HTTP transport that uses warm connections first
+func (w *connectionPrewarmer) transport() *http.Transport {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		if conn := w.take("http", addr); conn != nil {
+			return conn, nil
+		}
+		return w.dialer.DialContext(ctx, network, addr)
+	}
+	transport.DialTLSContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		if conn := w.take("https", addr); conn != nil {
+			return conn, nil
+		}
+		host, _, err := net.SplitHostPort(addr)
+		if err != nil {
+			return nil, err
+		}
+		return w.dial(ctx, "https", host, addr)
+	}
+	return transport
+}
+
+// status reports every upstream and whether all finished their first warm-up
+func (w *connectionPrewarmer) status() ([]PrewarmStatus, bool) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	ready := true
+	statuses := make([]PrewarmStatus, 0, len(w.upstreams))
+	for _, upstream := range w.upstreams {
+		status := PrewarmStatus{
+			Upstream:  upstream.name(),
+			Addresses: upstream.addresses,
+			Warm:      len(upstream.conns),
+			Target:    w.target,
+			Ready:     upstream.attempted,
+			Error:     upstream.err,
+		}
+		if !upstream.warmedAt.IsZero() {
+			warmedAt := upstream.warmedAt
+			status.WarmedAt = &warmedAt
+		}
+		ready = ready && upstream.attempted
+		statuses = append(statuses, status)
+	}
+	return statuses, ready
+}
+
+// stop ends background warming and closes the unused connections
+func (w *connectionPrewarmer) stop() {
+	w.cancel()
+	w.wg.Wait()
+
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	for _, upstream := range w.upstreams {
+		for _, warm := range upstream.conns {
+			if err := warm.conn.Close(); err != nil {
+				slog.Warn("Error closing prewarmed connection", "error", err)
+			}
+		}
+		upstream.conns = nil
+	}
+}
+
+// readiness reports "ready", or "warming" until every prewarmed upstream
+// finished its first warm-up, with the prewarm status of each upstream
+func (p *Proxy) readiness() (string, []PrewarmStatus) {
+	if p.prewarm == nil {
+		return "ready", []PrewarmStatus{}
+	}
+	upstreams, ready := p.prewarm.status()
+	if !ready {
+		return "warming", upstreams
+	}
+	return "ready", upstreams
+}
+
+// handleReady reports whether the proxy is ready for traffic: every prewarmed
+// upstream has finished its first warm-up, whether or not it succeeded
+func (p *Proxy) handleReady(w http.ResponseWriter, r *http.Request) {
+	// Add CORS headers
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, Cache-Control, Pragma, Expires")
+
+	// Handle preflight requests
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	response := struct {
+		Status  string          `json:"status"`
+		Prewarm []PrewarmStatus `json:"prewarm"`
+	}{}
+	response.Status, response.Prewarm = p.readiness()
+
+	status := http.StatusOK
+	if response.Status != "ready" {
+		status = http.StatusServiceUnavailable
+	}
+
+	data, err := json.Marshal(response)
+	if err != nil {
+		http.Error(w, "Failed to encode readiness", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if _, err := w.Write(data); err != nil {
+		slog.Warn("Error writing readiness response", "error", err)
+	}
+}
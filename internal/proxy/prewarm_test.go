@@ -0,0 +1,112 @@
+//go:build unit
+
+package proxy
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func waitForPrewarm(t *testing.T, prewarmer *connectionPrewarmer) []PrewarmStatus {
+	t.Helper()
+	var statuses []PrewarmStatus
+	require.Eventually(t, func() bool {
+		var ready bool
+		statuses, ready = prewarmer.status()
+		return ready
+	}, 5*time.Second, 10*time.Millisecond)
+	return statuses
+}
+
+func TestPrewarmUsesWarmConnections(t *testing.T) {
+	remotes := make(chan string, 10)
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		remotes <- r.RemoteAddr
+		if _, err := w.Write([]byte("ok")); err != nil {
+			t.Logf("Error writing response: %v", err)
+		}
+	}))
+	defer upstream.Close()
+
+	target, err := ParsePrewarmTarget(upstream.URL)
+	require.NoError(t, err)
+	p := New(&Config{Prewarm: []*url.URL{target}, PrewarmConnections: 2})
+	defer p.prewarm.stop()
+
+	statuses := waitForPrewarm(t, p.prewarm)
+	require.Len(t, statuses, 1)
+	assert.Equal(t, "http://"+target.Host, statuses[0].Upstream)
+	assert.Equal(t, 2, statuses[0].Warm)
+	assert.Equal(t, []string{"127.0.0.1"}, statuses[0].Addresses)
+	assert.NotNil(t, statuses[0].WarmedAt)
+	assert.Empty(t, statuses[0].Error)
+
+	p.prewarm.mutex.Lock()
+	warm := map[string]bool{}
+	for _, conn := range p.prewarm.upstreams[0].conns {
+		warm[conn.conn.LocalAddr().String()] = true
+	}
+	p.prewarm.mutex.Unlock()
+
+	req := httptest.NewRequest(http.MethodGet, upstream.URL+"/", nil)
+	rec := httptest.NewRecorder()
+	p.ServeHTTP(rec, req)
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.True(t, warm[<-remotes], "the request reuses a prewarmed connection")
+
+	// The used connection is replaced in the background
+	assert.Eventually(t, func() bool {
+		statuses, _ := p.prewarm.status()
+		return statuses[0].Warm == 2
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestPrewarmReportsHandshakeErrors(t *testing.T) {
+	upstream := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer upstream.Close()
+
+	target, err := ParsePrewarmTarget(upstream.URL)
+	require.NoError(t, err)
+	prewarmer := startPrewarmer([]*url.URL{target}, 1, newUpstreamDialer(nil, 0, nil))
+	defer prewarmer.stop()
+
+	// The self-signed certificate fails verification, which still counts as warmed up
+	statuses := waitForPrewarm(t, prewarmer)
+	assert.Zero(t, statuses[0].Warm)
+	assert.Nil(t, statuses[0].WarmedAt)
+	assert.Contains(t, statuses[0].Error, "TLS handshake")
+}
+
+func TestHandleReady(t *testing.T) {
+	get := func(p *Proxy) (*httptest.ResponseRecorder, map[string]interface{}) {
+		rec := httptest.NewRecorder()
+		p.handleReady(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+		var body map[string]interface{}
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &body))
+		return rec, body
+	}
+
+	rec, body := get(New(&Config{}))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "ready", body["status"])
+
+	warming := &Proxy{prewarm: &connectionPrewarmer{
+		target:    2,
+		upstreams: []*warmUpstream{{scheme: "https", host: "api.example.com", port: "443"}},
+	}}
+	rec, body = get(warming)
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+	assert.Equal(t, "warming", body["status"])
+	assert.Equal(t, "https://api.example.com:443", body["prewarm"].([]interface{})[0].(map[string]interface{})["upstream"])
+
+	warming.prewarm.upstreams[0].attempted = true
+	rec, _ = get(warming)
+	assert.Equal(t, http.StatusOK, rec.Code)
+}
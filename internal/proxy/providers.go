@@ -0,0 +1,225 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Provider groups upstream routes under a third-party vendor name for SLA reporting
+type Provider struct {
+	Name   string
+	Routes []Route
+}
+
+// ParseProvider parses a provider in "name=route[,route...]" form, e.g.
+// "stripe=api.stripe.com,files.stripe.com". Routes take the ParseRoute form;
+// a host of "*.example.com" also matches every subdomain of example.com.
+func ParseProvider(spec string) (Provider, error) {
+	name, routes, ok := strings.Cut(spec, "=")
+	name = strings.TrimSpace(name)
+	if !ok || name == "" || strings.TrimSpace(routes) == "" {
+		return Provider{}, fmt.Errorf("invalid provider %q: expected name=host[,host...]", spec)
+	}
+
+	provider := Provider{Name: name}
+	for _, route := range strings.Split(routes, ",") {
+		route = strings.TrimSpace(route)
+		if route == "" {
+			continue
+		}
+		parsed := ParseRoute(route)
+		if parsed.Host == "" {
+			return Provider{}, fmt.Errorf("invalid provider %q: route %q needs a host", spec, route)
+		}
+		provider.Routes = append(provider.Routes, parsed)
+	}
+	return provider, nil
+}
+
+// Matches reports whether the URL belongs to the provider
+func (pr Provider) Matches(u *url.URL) bool {
+	for _, route := range pr.Routes {
+		if route.matchesSubdomains(u) {
+			return true
+		}
+	}
+	return false
+}
+
+// ProviderSLA is the availability and latency of one provider over a window.
+// Availability counts proxy errors and 5xx responses as unavailable; 4xx
+// responses are the caller's fault and count as available.
+type ProviderSLA struct {
+	Provider      string   `json:"provider"`
+	Routes        []string `json:"routes"`
+	Unavailable   int      `json:"unavailable"`
+	Availability  *float64 `json:"availability,omitempty"` // Percentage, unset without traffic
+	MaxDurationUs int64    `json:"max_duration_us"`
+	TrafficStats
+}
+
+// ProviderReport is the SLA report for every configured provider
+type ProviderReport struct {
+	Start     time.Time     `json:"start"`
+	End       time.Time     `json:"end"`
+	Providers []ProviderSLA `json:"providers"` // In configuration order
+}
+
+// BuildProviderReport reports on the records in [start, end) for each
+// provider. A request counts towards the first provider it matches.
+func BuildProviderReport(records []RequestRecord, providers []Provider, start, end time.Time) ProviderReport {
+	accumulators := make([]trafficAccumulator, len(providers))
+	unavailable := make([]int, len(providers))
+	maxDurations := make([]int64, len(providers))
+
+	for _, record := range records {
+		if record.Timestamp.Before(start) || !record.Timestamp.Before(end) {
+			continue
+		}
+		u, err := url.Parse(record.URL)
+		if err != nil {
+			continue
+		}
+		for i, provider := range providers {
+			if !provider.Matches(u) {
+				continue
+			}
+			accumulators[i].add(record)
+			if !record.Success || record.ResponseStatus >= http.StatusInternalServerError {
+				unavailable[i]++
+			}
+			if record.TotalDurationUs > maxDurations[i] {
+				maxDurations[i] = record.TotalDurationUs
+			}
+			break
+		}
+	}
+
+	report := ProviderReport{Start: start, End: end, Providers: []ProviderSLA{}}
+	for i, provider := range providers {
+		sla := ProviderSLA{
+			Provider:      provider.Name,
+			Routes:        make([]string, 0, len(provider.Routes)),
+			Unavailable:   unavailable[i],
+			MaxDurationUs: maxDurations[i],
+			TrafficStats:  accumulators[i].stats(),
+		}
+		for _, route := range provider.Routes {
+			sla.Routes = append(sla.Routes, route.String())
+		}
+		if sla.Count > 0 {
+			availability := 100 * float64(sla.Count-sla.Unavailable) / float64(sla.Count)
+			sla.Availability = &availability
+		}
+		report.Providers = append(report.Providers, sla)
+	}
+	return report
+}
+
+// providerReportCSV renders one row per provider
+func providerReportCSV(report ProviderReport) ([]byte, error) {
+	var buf bytes.Buffer
+	writer := csv.NewWriter(&buf)
+	rows := [][]string{{
+		"provider", "routes", "window_start", "window_end", "requests", "unavailable", "availability_pct",
+		"error_rate", "p50_duration_us", "p95_duration_us", "p99_duration_us", "max_duration_us",
+	}}
+	for _, sla := range report.Providers {
+		availability := ""
+		if sla.Availability != nil {
+			availability = strconv.FormatFloat(*sla.Availability, 'f', 3, 64)
+		}
+		rows = append(rows, []string{
+			sla.Provider,
+			strings.Join(sla.Routes, " "),
+			report.Start.UTC().Format(time.RFC3339),
+			report.End.UTC().Format(time.RFC3339),
+			strconv.Itoa(sla.Count),
+			strconv.Itoa(sla.Unavailable),
+			availability,
+			strconv.FormatFloat(sla.ErrorRate, 'f', 4, 64),
+			strconv.FormatInt(sla.P50DurationUs, 10),
+			strconv.FormatInt(sla.P95DurationUs, 10),
+			strconv.FormatInt(sla.P99DurationUs, 10),
+			strconv.FormatInt(sla.MaxDurationUs, 10),
+		})
+	}
+	if err := writer.WriteAll(rows); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// parseReportWindow parses a window query parameter: a trailing duration
+// (e.g. "24h") or a "start/end" window as accepted by ParseTimeWindow
+func parseReportWindow(value string, fallback time.Duration, now time.Time) (time.Time, time.Time, error) {
+	if value == "" {
+		return now.Add(-fallback), now, nil
+	}
+	if strings.Contains(value, "/") {
+		return ParseTimeWindow(value, now)
+	}
+	window, err := time.ParseDuration(value)
+	if err != nil || window <= 0 {
+		return time.Time{}, time.Time{}, fmt.Errorf("expected a duration or start/end, got %q", value)
+	}
+	return now.Add(-window), now, nil
+}
+
+// handleProviderReport reports availability and latency per configured provider
+func (p *Proxy) handleProviderReport(w http.ResponseWriter, r *http.Request) {
+	// Add CORS headers
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, Cache-Control, Pragma, Expires")
+
+	// Handle preflight requests
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	start, end, err := parseReportWindow(r.URL.Query().Get("window"), 24*time.Hour, time.Now())
+	if err != nil {
+		http.Error(w, "Invalid window: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	report := BuildProviderReport(p.history.GetRecords(), p.currentConfig().Providers, start, end)
+
+	var data []byte
+	switch format := r.URL.Query().Get("format"); format {
+	case "", "json":
+		data, err = json.Marshal(report)
+		w.Header().Set("Content-Type", "application/json")
+	case "csv":
+		data, err = providerReportCSV(report)
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		w.Header().Set("Content-Disposition", `attachment; filename="netkit-providers.csv"`)
+	default:
+		http.Error(w, "Invalid format: expected json or csv", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		w.Header().Del("Content-Disposition")
+		http.Error(w, "Failed to build provider report", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	if _, err := w.Write(data); err != nil {
+		slog.Warn("Error writing provider report response", "error", err)
+	}
+}
@@ -0,0 +1,121 @@
+//go:build unit
+
+package proxy
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseProvider(t *testing.T) {
+	provider, err := ParseProvider("stripe=api.stripe.com, *.stripe-files.com/v1")
+	require.NoError(t, err)
+	assert.Equal(t, "stripe", provider.Name)
+	require.Len(t, provider.Routes, 2)
+
+	for target, want := range map[string]bool{
+		"https://api.stripe.com/v1/charges":    true,
+		"https://API.STRIPE.COM:443/v1":        true,
+		"https://eu.stripe-files.com/v1/files": true,
+		"https://stripe-files.com/v1/files":    true,
+		"https://eu.stripe-files.com/v2/files": false,
+		"https://evilstripe-files.com/v1":      false,
+		"https://api.stripe.com.evil.com/":     false,
+	} {
+		u, err := url.Parse(target)
+		require.NoError(t, err)
+		assert.Equal(t, want, provider.Matches(u), target)
+	}
+
+	for _, spec := range []string{"", "stripe", "=api.stripe.com", "stripe=", "stripe=/v1"} {
+		_, err := ParseProvider(spec)
+		assert.Error(t, err, spec)
+	}
+}
+
+func providerRecords(now time.Time) []RequestRecord {
+	return []RequestRecord{
+		{URL: "https://api.stripe.com/v1/charges", ResponseStatus: 200, Success: true, TotalDurationUs: 100000, Timestamp: now.Add(-time.Minute)},
+		{URL: "https://api.stripe.com/v1/charges", ResponseStatus: 402, Success: true, TotalDurationUs: 200000, Timestamp: now.Add(-2 * time.Minute)},
+		{URL: "https://api.stripe.com/v1/charges", ResponseStatus: 503, Success: true, TotalDurationUs: 300000, Timestamp: now.Add(-3 * time.Minute)},
+		{URL: "https://api.stripe.com/v1/charges", Error: "timeout", TotalDurationUs: 30000000, Timestamp: now.Add(-4 * time.Minute)},
+		{URL: "https://api.stripe.com/v1/charges", ResponseStatus: 200, Success: true, TotalDurationUs: 100000, Timestamp: now.Add(-48 * time.Hour)},
+		{URL: "https://api.twilio.com/Messages", ResponseStatus: 201, Success: true, TotalDurationUs: 50000, Timestamp: now.Add(-time.Minute)},
+		{URL: "https://internal.example.com/", ResponseStatus: 500, Success: true, TotalDurationUs: 1000, Timestamp: now.Add(-time.Minute)},
+	}
+}
+
+func testProviders(t *testing.T) []Provider {
+	var providers []Provider
+	for _, spec := range []string{"stripe=api.stripe.com", "twilio=*.twilio.com", "sendgrid=api.sendgrid.com"} {
+		provider, err := ParseProvider(spec)
+		require.NoError(t, err)
+		providers = append(providers, provider)
+	}
+	return providers
+}
+
+func TestBuildProviderReport(t *testing.T) {
+	now := time.Now()
+	report := BuildProviderReport(providerRecords(now), testProviders(t), now.Add(-24*time.Hour), now)
+	require.Len(t, report.Providers, 3)
+
+	stripe := report.Providers[0]
+	assert.Equal(t, "stripe", stripe.Provider)
+	assert.Equal(t, 4, stripe.Count)
+	assert.Equal(t, 2, stripe.Unavailable, "the 402 counts as available")
+	require.NotNil(t, stripe.Availability)
+	assert.InDelta(t, 50.0, *stripe.Availability, 0.001)
+	assert.InDelta(t, 0.75, stripe.ErrorRate, 0.001)
+	assert.Equal(t, int64(30000000), stripe.MaxDurationUs)
+
+	twilio := report.Providers[1]
+	assert.Equal(t, 1, twilio.Count)
+	assert.InDelta(t, 100.0, *twilio.Availability, 0.001)
+
+	sendgrid := report.Providers[2]
+	assert.Equal(t, 0, sendgrid.Count)
+	assert.Nil(t, sendgrid.Availability)
+}
+
+func TestProviderReportAPI(t *testing.T) {
+	p := New(&Config{Providers: testProviders(t)})
+	now := time.Now()
+	// AddRecord derives durations from timestamps, so restore the fixtures as they are
+	p.history.restore(providerRecords(now))
+
+	rec := httptest.NewRecorder()
+	p.handleProviderReport(rec, httptest.NewRequest(http.MethodGet, "/requests/providers?window=72h", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	var report ProviderReport
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &report))
+	assert.Equal(t, 5, report.Providers[0].Count)
+
+	rec = httptest.NewRecorder()
+	p.handleProviderReport(rec, httptest.NewRequest(http.MethodGet, "/requests/providers?window=1h/now&format=csv", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "text/csv; charset=utf-8", rec.Header().Get("Content-Type"))
+	rows, err := csv.NewReader(strings.NewReader(rec.Body.String())).ReadAll()
+	require.NoError(t, err)
+	require.Len(t, rows, 4)
+	assert.Equal(t, "provider", rows[0][0])
+	assert.Equal(t, []string{"stripe", "api.stripe.com"}, rows[1][:2])
+	assert.Equal(t, "4", rows[1][4])
+	assert.Equal(t, "50.000", rows[1][6])
+	assert.Equal(t, "", rows[3][6], "no availability without traffic")
+
+	for _, query := range []string{"window=soon", "window=1h/2h", "format=xml"} {
+		rec = httptest.NewRecorder()
+		p.handleProviderReport(rec, httptest.NewRequest(http.MethodGet, "/requests/providers?"+query, nil))
+		assert.Equal(t, http.StatusBadRequest, rec.Code, query)
+	}
+}
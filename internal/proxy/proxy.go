@@ -3,15 +3,23 @@ package proxy
 import (
 	"bytes"
 	"context"
+	"crypto/ed25519"
 	"crypto/rand"
+	"crypto/tls"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
 	"net"
 	"net/http"
+	"net/http/pprof"
 	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/biancarosa/netkit/internal/dashboard"
@@ -21,21 +29,263 @@ import (
 type Config struct {
 	Port          int
 	AdminPort     int
-	LogLevel      string
+	LogLevel      string // debug, info, warn, or error (default: info)
+	LogFormat     string // text or json (default: text)
 	HistorySize   int    // Maximum number of requests to keep in history
 	Dashboard     bool   // Enable dashboard serving
 	DashboardPort int    // Port for dashboard (separate from admin port)
 	DashboardDir  string // Directory containing dashboard build files
+
+	// Log file output, rotated so long-running proxies don't fill disks
+	LogFile     string      // Write logs to this file instead of stderr (optional)
+	LogRotation LogRotation // When LogFile is rotated and how many rotated files are kept
+
+	// Syslog output for environments that collect logs with syslog
+	LogSyslog         string // Syslog daemon logs are sent to instead of stderr, e.g. udp://host:514 (optional)
+	LogSyslogFacility string // Facility logs are sent with (default: daemon)
+	LogSyslogTag      string // Tag, or app name, logs are sent with (default: netkit)
+
+	// Conditional GET synthesis for polling clients
+	ConditionalGET bool // Revalidate cached GET responses upstream and synthesize 200s on 304
+	CacheSize      int  // Maximum number of cached responses (default: 500)
+
+	// CORS preflight caching for browsers calling remote APIs
+	PreflightCache  bool          // Forward CORS preflights upstream and answer repeats from a cache
+	PreflightMaxAge time.Duration // Longest a preflight is cached; a lower upstream Access-Control-Max-Age wins (default: 10m)
+
+	// Binary body decoding with user-supplied schemas
+	BodySchemas []BodySchema
+
+	// XML and SOAP capture
+	XMLPrettyPrint bool    // Store captured XML bodies indented
+	XMLRedactions  []XPath // Elements and attributes masked in captured XML bodies
+
+	// gRPC-Web to gRPC translation
+	GRPCWeb         bool   // Translate gRPC-Web requests to native gRPC upstream calls
+	GRPCWebUpstream string // gRPC upstream for gRPC-Web requests sent directly to the proxy (e.g. http://localhost:50051)
+
+	// Protocol sniffing on a single port
+	ProtocolSniffing bool        // Detect TLS, SOCKS, and plain HTTP on the proxy port
+	TLSConfig        *tls.Config // Certificate used to terminate sniffed TLS connections (optional)
+
+	// Strict request parsing on the proxy port
+	StrictParsing     bool // Reject malformed and smuggling-prone requests with a 400 before they are parsed
+	StrictHeaderBytes int  // Largest request line and headers in strict mode (default: 32 KiB)
+
+	// Header casing
+	HeaderCaseRoutes []Route // Send request headers of these routes upstream with the casing the client used
+
+	// Reverse-proxy mode
+	ReverseRoutes []ReverseRoute // Requests matching a route are forwarded to its upstream
+
+	// Redirect handling
+	RedirectPolicy string // RedirectFollow (default), RedirectNone, or RedirectRecord
+	MaxRedirects   int    // Maximum redirects followed per request (default: 10)
+
+	// Webhook signature verification
+	WebhookVerifiers []WebhookVerifier
+	WebhookTolerance time.Duration // Maximum signature timestamp age (0 disables the check)
+
+	// Webhook capture-and-forward inbox
+	InboxPath          string        // Path prefix that accepts and stores any webhook (e.g. /inbox/)
+	InboxForward       string        // Local target captured webhooks are forwarded to (optional)
+	InboxRetries       int           // Delivery retries while the forward target is down
+	InboxRetryInterval time.Duration // Delay before the first retry, doubled per attempt (default: 1s)
+
+	// Built-in test endpoints
+	TestEndpoints bool // Serve /__netkit/echo, /__netkit/status/{code}, and /__netkit/delay/{duration}
+
+	// Latency breakdown on proxied responses
+	ServerTiming  bool // Add a Server-Timing header with upstream latency and proxy overhead
+	TimingHeaders bool // Add X-Netkit-* timing and cache status headers
+
+	// Scheduled history reports
+	ReportSchedule *CronSchedule // When reports are rendered (nil disables them)
+	ReportFormats  []string      // ReportJSON, ReportHTML, and/or ReportMarkdown (default: markdown)
+	ReportDir      string        // Directory reports are written to (optional)
+	ReportNotify   []string      // URLs each report is POSTed to (optional)
+
+	// Client hints learned from upstream responses
+	AdvisoryHeaders bool // Attach deprecation and recent rate-limit headers seen earlier on the same route
+
+	// Saved history filters
+	FiltersFile string // JSON file saved filters persist to (optional, in memory otherwise)
+
+	// Admin API authentication
+	AdminToken string // Static token with every scope; with it or any issued token set, the admin API requires a bearer token
+	TokensFile string // JSON file issued tokens (hashed) persist to (optional, in memory otherwise)
+	Workspace  string // Workspace tokens must be scoped to (default: "default")
+	Pprof      bool   // Serve net/http/pprof profiles under /debug/pprof/ on the admin port
+
+	// Browser origins allowed to call the admin API with credentials; any
+	// origin may call it without credentials when empty
+	AdminOrigins []string
+
+	// Third-party providers reported on by /requests/providers
+	Providers []Provider
+
+	// Streaming large and unbounded bodies
+	StreamThreshold    int64 // Pipe bodies larger than this many bytes, or of unknown length, instead of buffering them (default: 1 MiB; negative buffers every body)
+	StreamCaptureLimit int64 // Bytes at the start of a streamed body kept in history (default: 64 KiB)
+
+	// Bytes at the start of every request and response body kept in history;
+	// longer bodies are truncated (0 keeps whole bodies)
+	CaptureMaxBodyBytes int64
+
+	// Content types whose bodies are stored in history, as MIME types or
+	// type/*; other bodies keep only their size and SHA-256 (default: every
+	// type but DefaultBinaryBodyTypes)
+	CaptureBodyTypes []string
+
+	// Teeing response bodies to other tooling
+	TeeRules []TeeRule // Stream response bodies of these routes to a file, command, or URL while serving them; the first matching rule applies
+
+	// Extracting downloaded files such as PDFs and CSVs
+	ExtractRules   []ExtractRule // Save the response bodies matching any of these routes and types to ExtractDir
+	ExtractDir     string        // Directory artifacts and their index are saved to
+	ExtractMaxSize int64         // Largest body extracted, in bytes (default: 100 MiB)
+
+	// Raw wire capture
+	RawCaptureRoutes []Route // Keep the exact bytes sent to and received from upstreams for these routes
+	RawCaptureLimit  int64   // Bytes of each direction a raw capture keeps (default: 1 MiB)
+
+	// Per-host capture policies for regulated destinations; the first
+	// matching policy applies and other requests are captured in full
+	CapturePolicies []CapturePolicy
+
+	// Credentials masked in records before they are stored
+	Redaction Redaction
+
+	// Shared rule bundles
+	RulesDir  string              // Directory rule bundles are installed to through POST /rules/import (optional, importing is off otherwise)
+	RulesKeys []ed25519.PublicKey // Keys trusted to sign rule bundles; unsigned bundles are refused
+
+	// Named captures
+	CapturesDir string // Directory each named capture persists to as its own file (optional, in memory otherwise)
+
+	// Protection against deleting recorded traffic by accident
+	ClearProtection string // Clearing history and deleting runs or captures needs confirmation: confirm or admin (default: none)
+	ClearBackup     bool   // Save history as a pre-clear capture before it is cleared or a run's records are deleted
+
+	// Persistent history
+	HistoryFile    string        // File history snapshots persist to (optional, in memory otherwise)
+	HistoryKey     KeyProvider   // Wraps the data key that encrypts the history file or backend (optional, stored in plain JSON otherwise)
+	HistoryBackend string        // HistoryBackendMemory, HistoryBackendSQLite, HistoryBackendBolt, or HistoryBackendRedis (default: memory)
+	HistoryPath    string        // Database or file history is kept in with the sqlite and bolt backends
+	RedisURL       string        // Redis server shared history is kept in with the redis backend
+	HistoryTTL     time.Duration // Remove records older than this from history and its backend (0 keeps them)
+
+	// Record IDs and sampling
+	IDGenerator IDGenerator // Creates request record IDs (default: random hex)
+	Sampler     Sampler     // Decides which requests are kept in history (default: all of them)
+
+	// Request hedging
+	HedgeDelay  time.Duration // Send a second copy of idempotent requests without a response after this long (0 disables hedging)
+	HedgeTarget *url.URL      // Scheme and host the second copy is sent to (default: the same target)
+
+	// Adaptive concurrency limits per upstream host
+	ConcurrencyLimit *ConcurrencyLimit // Reject requests over each upstream's adaptive limit (nil disables limiting)
+
+	// Client throttling
+	ThrottleRules []ThrottleRule // Slow down clients matching a header pattern; the first matching rule applies
+
+	// Shaping upstream latency to a target distribution
+	LatencyProfiles []LatencyProfile // Hold responses of these routes until they reach the profile's latency; the first matching profile applies
+
+	// Connection prewarming
+	Prewarm            []*url.URL // Upstreams resolved and connected to at startup, reported on /readyz
+	PrewarmConnections int        // Warm connections kept per prewarmed upstream (default: 2)
+
+	// Upstream name resolution
+	DNSResolvers []DNSResolver // DNS-over-HTTPS/TLS servers tried in order instead of the system resolver
+	DNSFallback  bool          // Ask the system's name server when every DNS resolver fails
+
+	// Upstream address families
+	IPFamilyRules      []IPFamilyRule // How connections to matching hosts choose between IPv4 and IPv6; the first matching rule applies
+	HappyEyeballsDelay time.Duration  // Head start of one address family before the other is tried (default: 250ms; negative tries them one after the other)
+
+	// Upstream source addresses
+	SourceRules []SourceRule // Local addresses or interfaces upstream connections are bound to; route rules take precedence over the first rule without a route
+
+	// SSH jump hosts
+	SSHJumps          []SSHJump // Jump hosts connections to matching upstream hosts go through; the first matching rule applies
+	SSHKeyFile        string    // Private key to log in to jump hosts with
+	SSHKnownHostsFile string    // known_hosts file jump host keys are checked against
+
+	// History sinks
+	Sinks             []Sink // Files and systems new records are exported to; reloads start, stop, and restart changed sinks
+	SinkDeadLetterDir string // Where batches sinks give up on are spooled for a replay ("" drops them)
+
+	// StatsD metrics export
+	StatsDAddr   string   // host:port StatsD metrics are sent to over UDP ("" disables the exporter)
+	StatsDPrefix string   // Prepended to every metric name, e.g. "netkit."
+	StatsDFlavor string   // StatsDPlain or StatsDDatadog (default: StatsDPlain)
+	StatsDTags   []string // key:value tags added to every metric with StatsDDatadog
+
+	// OpenTelemetry tracing
+	TracingEndpoint    string            // OTLP/HTTP collector URL spans are exported to as JSON ("" disables tracing)
+	TracingHeaders     map[string]string // Headers sent with every export, e.g. for the collector's authentication
+	TracingServiceName string            // service.name of the exported spans (default: "netkit")
+	TraceGenerate      bool              // Start a W3C trace for requests without a traceparent, so upstreams can correlate with history
+
+	// Per-request overrides
+	AllowedOptions []string // X-Netkit-Options a client may set (default: none)
+
+	// Tail-based sampling, deciding after requests complete
+	TailSampling *TailSampling // Keeps errors, slow, and matching requests and samples the rest (nil disables it)
+
+	// Hot reloading
+	ConfigLoader func() (*Config, error) // Rebuilds the configuration on SIGHUP or POST /config/reload (nil disables reloading)
 }
 
 // Proxy represents the HTTP proxy server
 type Proxy struct {
-	config          *Config
+	config          atomic.Pointer[Config] // Swapped by Reload
 	server          *http.Server
 	adminServer     *http.Server
 	dashboardServer *http.Server
 	httpClient      *http.Client
 	history         *RequestHistory
+	cache           *ResponseCache
+	preflights      *preflightCache
+	grpcClient      *http.Client
+	inbox           *webhookInbox
+	reports         *reportScheduler
+	advisories      *advisoryStore
+	filters         *filterStore
+	tokens          *tokenStore
+	historyFile     *historyPersister
+	historyStore    historyStore
+	historyExpiry   *historyExpiry
+	capture         *captureControl
+	captures        *captureStore
+	heatmap         *latencyHeatmap
+	tailSampler     *tailSampler
+	concurrency     *concurrencyLimiter
+	prewarm         *connectionPrewarmer
+	runs            *runStore
+	recordings      *recordingStore
+	rawCaptures     rawCaptureStore
+	confirmations   *purgeConfirmations
+	throttler       *throttler
+	latency         *latencyShaper
+	tees            *teeSinks
+	artifacts       *artifactStore
+	dialer          *upstreamDialer
+	feed            *recordFeed
+	sinks           *historySinks
+	strict          *strictParsing // Nil unless StrictParsing is set
+	headerCase      bool           // Proxy port connections note header names as sent
+	tracer          *tracer        // Nil unless TracingEndpoint is set
+	metrics         *trafficMetrics
+	rulesMutex      sync.Mutex // Serializes rule bundle installs and the reloads they trigger
+
+	// Listeners and reloads
+	reloadMutex sync.Mutex                    // Serializes reloads and listener changes
+	listeners   map[*http.Server]net.Listener // Current listener of each bound server
+	started     bool                          // Start has bound the listeners
+	stopped     chan struct{}                 // Closed by Stop
+	stopOnce    sync.Once
 }
 
 // New creates a new Proxy instance
@@ -47,40 +297,260 @@ func New(config *Config) *Proxy {
 	}
 
 	proxy := &Proxy{
-		config: config,
 		httpClient: &http.Client{
 			Timeout: 30 * time.Second,
 		},
-		history: NewRequestHistory(historySize),
+		history:       NewRequestHistory(historySize),
+		capture:       newCaptureControl(),
+		heatmap:       newLatencyHeatmap(),
+		runs:          newRunStore(),
+		recordings:    newRecordingStore(),
+		confirmations: newPurgeConfirmations(),
+		throttler:     newThrottler(),
+		latency:       newLatencyShaper(),
+		feed:          newRecordFeed(),
+		sinks:         newHistorySinks(config.Sinks, config.SinkDeadLetterDir),
+		metrics:       newTrafficMetrics(),
+
+		listeners: make(map[*http.Server]net.Listener),
+		stopped:   make(chan struct{}),
 	}
+	proxy.config.Store(config)
+	if config.StrictParsing {
+		proxy.strict = newStrictParsing(config.StrictHeaderBytes)
+	}
+	proxy.headerCase = len(config.HeaderCaseRoutes) > 0
+	proxy.metrics.statsd = newStatsDClient(config.StatsDAddr, config.StatsDPrefix, config.StatsDFlavor, config.StatsDTags)
+	proxy.tracer = newTracer(config.TracingEndpoint, config.TracingHeaders, config.TracingServiceName)
+	proxy.httpClient.CheckRedirect = proxy.checkRedirect
+
+	// Resolve upstream names with DNS-over-HTTPS/TLS servers, and connect
+	// over the address family each host's rule asks for
+	var resolver *net.Resolver
+	if len(config.DNSResolvers) > 0 {
+		resolver = newDNSResolver(config.DNSResolvers, config.DNSFallback)
+	}
+	proxy.dialer = newUpstreamDialer(config.IPFamilyRules, config.HappyEyeballsDelay, resolver)
+	if len(config.SSHJumps) > 0 {
+		jumps, err := newSSHJumps(config.SSHJumps, config.SSHKeyFile, config.SSHKnownHostsFile)
+		if err != nil {
+			slog.Error("Error setting up SSH jump hosts, upstreams will be connected to directly", "error", err)
+		}
+		proxy.dialer.jumps = jumps
+	}
+	globalSource, routeSources := splitSourceRules(config.SourceRules)
+	if globalSource != nil {
+		proxy.dialer = proxy.dialer.withSource(*globalSource)
+	}
+	proxy.httpClient.Transport = proxy.dialer.transport()
 
-	// Initialize the main HTTP proxy server
-	proxy.server = &http.Server{
-		Addr:    fmt.Sprintf(":%d", config.Port),
-		Handler: proxy,
+	// Keep warm connections to configured upstreams for the first requests
+	if len(config.Prewarm) > 0 {
+		proxy.prewarm = startPrewarmer(config.Prewarm, config.PrewarmConnections, proxy.dialer)
+		proxy.httpClient.Transport = proxy.prewarm.transport()
+	}
+	proxy.httpClient.Transport = &wireCaptureTransport{base: proxy.httpClient.Transport, dialer: proxy.dialer}
+
+	// Bind the connections of routes with a source address of their own
+	if len(routeSources) > 0 {
+		proxy.httpClient.Transport = newSourceTransport(routeSources, proxy.dialer, proxy.httpClient.Transport)
+	}
+
+	// Initialize the response cache if conditional GET synthesis is enabled
+	if config.ConditionalGET {
+		cacheSize := config.CacheSize
+		if cacheSize <= 0 {
+			cacheSize = 500
+		}
+		proxy.cache = NewResponseCache(cacheSize)
+	}
+
+	// Initialize the preflight cache if CORS preflights should reach upstreams
+	if config.PreflightCache {
+		proxy.preflights = newPreflightCache()
+	}
+
+	// Initialize the HTTP/2 client used for translated gRPC-Web calls
+	if config.GRPCWeb {
+		proxy.grpcClient = newGRPCClient(proxy.dialer)
+	}
+
+	// Initialize background delivery for the webhook inbox
+	if config.InboxPath != "" {
+		proxy.inbox = newWebhookInbox()
+	}
+
+	// Restore persisted history, leaving a file that cannot be read untouched
+	if config.HistoryFile != "" {
+		persister, err := startHistoryPersistence(config.HistoryFile, config.HistoryKey, proxy.history)
+		if err != nil {
+			slog.Error("Error loading history file, history will not be persisted", "error", err)
+		}
+		proxy.historyFile = persister
 	}
 
-	// Initialize the admin server if admin port is specified
-	if config.AdminPort > 0 {
-		adminMux := http.NewServeMux()
+	// Keep history in a backend, in memory only if it cannot be opened
+	location := config.HistoryPath
+	if config.HistoryBackend == HistoryBackendRedis {
+		location = config.RedisURL
+	}
+	store, err := openHistoryStore(config.HistoryBackend, location, config.HistoryKey, proxy.history)
+	if err != nil {
+		slog.Error("Error opening history backend, history will be kept in memory", "backend", config.HistoryBackend, "error", err)
+	}
+	proxy.historyStore = store
 
-		// Always enable both health and metrics when admin port is specified
-		adminMux.HandleFunc("/healthz", proxy.handleHealth)
-		adminMux.HandleFunc("/metrics", proxy.handleMetrics)
+	// Remove expired records, from the backend as well
+	if config.HistoryTTL > 0 {
+		proxy.historyExpiry = startHistoryExpiry(config.HistoryTTL, proxy.history)
+	}
 
-		// Add request history endpoints
-		adminMux.HandleFunc("/requests", proxy.handleRequestHistory)
-		adminMux.HandleFunc("/requests/stats", proxy.handleRequestStats)
-		adminMux.HandleFunc("/requests/clear", proxy.handleClearHistory)
+	// Restored records count towards the latency heatmap like new ones
+	for _, record := range proxy.history.GetRecords() {
+		proxy.heatmap.observe(record.Timestamp, time.Duration(record.TotalDurationUs)*time.Microsecond, isFailedRecord(record))
+	}
+
+	// Track adaptive concurrency limits per upstream
+	if config.ConcurrencyLimit != nil {
+		proxy.concurrency = newConcurrencyLimiter(*config.ConcurrencyLimit)
+	}
+
+	// Open sinks that response bodies are teed to
+	if len(config.TeeRules) > 0 {
+		proxy.tees = newTeeSinks(config.TeeRules)
+	}
 
-		proxy.adminServer = &http.Server{
-			Addr:    fmt.Sprintf(":%d", config.AdminPort),
-			Handler: adminMux,
+	// Open the directory response bodies are extracted to
+	if len(config.ExtractRules) > 0 && config.ExtractDir != "" {
+		artifacts, err := newArtifactStore(config.ExtractDir, config.ExtractRules, config.ExtractMaxSize)
+		if err != nil {
+			slog.Error("Error opening artifacts directory, not extracting artifacts", "error", err)
+		} else {
+			proxy.artifacts = artifacts
 		}
 	}
 
+	// Buffer records for tail sampling
+	if config.TailSampling != nil {
+		proxy.tailSampler = startTailSampler(*config.TailSampling, proxy.storeRecord)
+	}
+
+	// Load named captures, sealed with the history key when one is set
+	captures, err := newCaptureStore(config.CapturesDir, config.HistoryKey)
+	if err != nil {
+		slog.Error("Error loading captures, keeping captures in memory only", "error", err)
+		captures, _ = newCaptureStore("", nil)
+	}
+	proxy.captures = captures
+
+	// Load saved history filters
+	filters, err := newFilterStore(config.FiltersFile)
+	if err != nil {
+		slog.Error("Error loading saved filters, keeping filters in memory only", "error", err)
+		filters, _ = newFilterStore("")
+	}
+	proxy.filters = filters
+
+	// Load admin API tokens, keeping the admin API closed if they cannot be read
+	tokens, err := newTokenStore(config.TokensFile)
+	if err != nil {
+		slog.Error("Error loading API tokens, admin API only accepts the static admin token", "error", err)
+		tokens, _ = newTokenStore("")
+		tokens.locked = true
+	}
+	proxy.tokens = tokens
+
+	// Initialize the per-route store of advisory headers
+	if config.AdvisoryHeaders {
+		proxy.advisories = newAdvisoryStore()
+	}
+
+	// Start rendering scheduled reports
+	if config.ReportSchedule != nil {
+		proxy.reports = proxy.startReports()
+	}
+
+	// Initialize the main HTTP proxy server
+	proxy.server = &http.Server{
+		Addr:      fmt.Sprintf(":%d", config.Port),
+		Handler:   proxy,
+		ConnState: proxy.metrics.trackConn,
+	}
+
+	// Initialize the admin server, which listens while an admin port is set
+	adminMux := http.NewServeMux()
+
+	// Always enable both health and metrics on the admin port
+	adminMux.HandleFunc("/healthz", proxy.handleHealth)
+	adminMux.HandleFunc("/metrics", proxy.handleMetrics)
+	adminMux.HandleFunc("/readyz", proxy.handleReady)
+	adminMux.HandleFunc("/time", proxy.handleTime)
+	adminMux.HandleFunc("/runtime", proxy.handleRuntime)
+
+	// Add request history endpoints
+	adminMux.HandleFunc("/requests", proxy.handleRequestHistory)
+	adminMux.HandleFunc("/requests/", proxy.handleRequestDetail)
+	adminMux.HandleFunc("/requests/stats", proxy.handleRequestStats)
+	adminMux.HandleFunc("/requests/stats/compare", proxy.handleStatsCompare)
+	adminMux.HandleFunc("/requests/stats/export", proxy.handleStatsExport)
+	adminMux.HandleFunc("/requests/stats/heatmap", proxy.handleHeatmap)
+	adminMux.HandleFunc("/requests/stats/hosts", proxy.handleHostStats)
+	adminMux.HandleFunc("/requests/errors", proxy.handleRequestErrors)
+	adminMux.HandleFunc("/requests/export", proxy.handleRequestExport)
+	adminMux.HandleFunc("/requests/report", proxy.handleReport)
+	adminMux.HandleFunc("/requests/providers", proxy.handleProviderReport)
+	adminMux.HandleFunc("/requests/credentials", proxy.handleCredentialReport)
+	adminMux.HandleFunc("/requests/assert", proxy.handleRequestAssert)
+	adminMux.HandleFunc("/requests/clear", proxy.handleClearHistory)
+	adminMux.HandleFunc("/requests/filters", proxy.handleSavedFilters)
+	adminMux.HandleFunc("/requests/raw", proxy.handleRawCapture)
+	adminMux.HandleFunc("/requests/search", proxy.handleSearch)
+	adminMux.HandleFunc("/requests/stream", proxy.handleRequestStream)
+	adminMux.HandleFunc("/ws", proxy.handlePush)
+	adminMux.HandleFunc("/capture", proxy.handleCapture)
+	adminMux.HandleFunc("/capture/pause", proxy.handleCapturePause)
+	adminMux.HandleFunc("/capture/resume", proxy.handleCaptureResume)
+	adminMux.HandleFunc("/captures", proxy.handleCaptures)
+	adminMux.HandleFunc("/captures/diff", proxy.handleCaptureDiff)
+	adminMux.HandleFunc("/captures/", proxy.handleCaptureSubresource)
+	adminMux.HandleFunc("/artifacts", proxy.handleArtifacts)
+	adminMux.HandleFunc("/artifacts/", proxy.handleArtifact)
+
+	// Add isolated test runs for CI jobs sharing the proxy
+	adminMux.HandleFunc("/runs", proxy.handleRuns)
+	adminMux.HandleFunc("/runs/", proxy.handleRun)
+
+	// Add per-browser recordings exported as HAR
+	adminMux.HandleFunc("/recordings", proxy.handleRecordings)
+	adminMux.HandleFunc("/recordings/", proxy.handleRecording)
+
+	// Add API token management
+	adminMux.HandleFunc("/tokens", proxy.handleTokens)
+
+	// Add history sinks, enabled and disabled at runtime
+	adminMux.HandleFunc("/sinks", proxy.handleSinks)
+	adminMux.HandleFunc("/sinks/", proxy.handleSink)
+
+	// Add config reloading
+	adminMux.HandleFunc("/config/reload", proxy.handleConfigReload)
+	adminMux.HandleFunc("/rules", proxy.handleRules)
+	adminMux.HandleFunc("/rules/import", proxy.handleRulesImport)
+
+	// Add Go runtime profiling of the live proxy
+	if config.Pprof {
+		adminMux.HandleFunc("/debug/pprof/", pprof.Index)
+		adminMux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+		adminMux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+		adminMux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+		adminMux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	}
+
+	proxy.adminServer = &http.Server{
+		Handler: proxy.requireAdminOrigin(proxy.requireAdminAuth(adminMux)),
+	}
+
 	// Initialize the dashboard server if dashboard is enabled
-	if config.Dashboard && config.DashboardPort > 0 {
+	if config.Dashboard {
 		dashboardMux := http.NewServeMux()
 
 		// Serve static files from dashboard directory or embedded dashboard
@@ -93,7 +563,6 @@ func New(config *Config) *Proxy {
 		}
 
 		proxy.dashboardServer = &http.Server{
-			Addr:    fmt.Sprintf(":%d", config.DashboardPort),
 			Handler: dashboardMux,
 		}
 	}
@@ -103,10 +572,17 @@ func New(config *Config) *Proxy {
 
 // ServeHTTP implements the http.Handler interface for the proxy
 func (p *Proxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	// Debug logging for received requests
-	if p.config.LogLevel == "debug" {
-		log.Printf("Received request: %s %s", r.Method, r.URL.String())
+	// Answer requests strict parsing rejected on the connection
+	if p.strict != nil {
+		if message := r.Header.Get(strictViolationHeader); message != "" {
+			handleStrictViolation(w, message)
+			return
+		}
 	}
+	r = p.takeHeaderNames(r)
+
+	// Debug logging for received requests
+	slog.Debug("Received request", "method", r.Method, "url", r.URL.String())
 
 	// For CONNECT method (HTTPS tunneling)
 	if r.Method == http.MethodConnect {
@@ -121,25 +597,61 @@ func (p *Proxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 // handleHTTP handles regular HTTP requests
 func (p *Proxy) handleHTTP(w http.ResponseWriter, r *http.Request) {
 	// Always add CORS headers to allow any web application to use the proxy
+	allowHeaders := "Content-Type, X-Netkit-Destination, X-Netkit-Options, X-Netkit-Run, Authorization, Accept, Origin, X-Requested-With, Cache-Control, Pragma, Expires"
+	if p.currentConfig().GRPCWeb {
+		allowHeaders += ", X-Grpc-Web, X-User-Agent, Grpc-Timeout"
+	}
 	w.Header().Set("Access-Control-Allow-Origin", "*")
 	w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, PATCH, HEAD, OPTIONS")
-	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, X-Netkit-Destination, Authorization, Accept, Origin, X-Requested-With, Cache-Control, Pragma, Expires")
+	w.Header().Set("Access-Control-Allow-Headers", allowHeaders)
 	w.Header().Set("Access-Control-Expose-Headers", "*")
 
-	// Handle preflight requests
-	if r.Method == http.MethodOptions {
+	// Handle preflight requests; with the preflight cache on, CORS preflights
+	// are forwarded so the upstream's policy applies
+	preflight := p.preflights != nil && isPreflightRequest(r)
+	if r.Method == http.MethodOptions && !preflight {
 		w.WriteHeader(http.StatusOK)
 		return
 	}
 
+	// Translate browser gRPC-Web calls to native gRPC
+	if p.currentConfig().GRPCWeb && isGRPCWebRequest(r) {
+		p.handleGRPCWeb(w, r)
+		return
+	}
+
+	// Capture webhooks sent to the inbox path
+	if p.isInboxRequest(r) {
+		p.handleInbox(w, r)
+		return
+	}
+
+	// Answer requests to the built-in test endpoints
+	if p.isTestEndpointRequest(r) {
+		p.handleTestEndpoint(w, r)
+		return
+	}
+
+	// Count the request as active until it is answered
+	defer p.metrics.begin(trafficHTTP)()
+
 	// Start timing
 	proxyStartTime := time.Now()
 
 	// Generate request ID
-	requestID := generateID()
-
-	// Capture request data
-	requestBody, requestSize, bodyReader := captureRequestBody(r)
+	requestID := p.requestIDFor(r)
+
+	// Capture request data, piping large and chunked uploads through
+	var requestBody string
+	var requestSize int64
+	var bodyReader io.Reader
+	var requestCapture *bodyCapture
+	if r.Body != nil && r.Body != http.NoBody && p.shouldStream(r.ContentLength) {
+		requestCapture = newBodyCapture(r.Body, p.streamCaptureLimit())
+		bodyReader = requestCapture
+	} else {
+		requestBody, requestSize, bodyReader = captureRequestBody(r)
+	}
 
 	// Create request record
 	record := RequestRecord{
@@ -153,89 +665,249 @@ func (p *Proxy) handleHTTP(w http.ResponseWriter, r *http.Request) {
 		ProxyStartTime: proxyStartTime,
 		Success:        false, // Will be updated based on outcome
 	}
+	if clientIP, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		record.ClientAddr = clientIP
+	}
+	defer p.metrics.observeRecord(&record)
+
+	// Record the client's trace, continuing it or starting one when tracing
+	// or trace generation is on
+	trace := p.beginTrace(r, &record)
+	defer p.tracer.end(trace, &record)
 
 	// Check for X-Netkit-Destination header (for dashboard requests)
 	var targetURL *url.URL
+	var reverse *ReverseRoute
+	var incoming *url.URL
 	var err error
 
+	// Apply per-request overrides the client opted into
+	options := &RequestOptions{}
+	if header := r.Header.Get(RequestOptionsHeader); header != "" {
+		if options, err = ParseRequestOptions(header, p.currentConfig().AllowedOptions); err != nil {
+			record.Error = "Invalid X-Netkit-Options: " + err.Error()
+			record.ProxyEndTime = time.Now()
+			p.recordRequest(record)
+			http.Error(w, "Invalid X-Netkit-Options: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		record.Tags = options.Tags
+		record.captureOverride = options.Capture
+	}
+
+	// Tag the record with the test run the client belongs to
+	if record.RunID, err = p.runFor(r); err != nil {
+		record.Error = "Invalid X-Netkit-Run: " + err.Error()
+		record.ProxyEndTime = time.Now()
+		p.recordRequest(record)
+		http.Error(w, "Invalid X-Netkit-Run: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
 	if destinationHeader := r.Header.Get("X-Netkit-Destination"); destinationHeader != "" {
 		// Dashboard request - use the destination header as the target URL
 		targetURL, err = url.Parse(destinationHeader)
 		if err != nil {
 			record.Error = "Invalid X-Netkit-Destination URL"
 			record.ProxyEndTime = time.Now()
-			p.history.AddRecord(record)
+			p.recordRequest(record)
 			http.Error(w, "Invalid X-Netkit-Destination URL", http.StatusBadRequest)
 			return
 		}
 		// Update the record URL to reflect the actual destination
 		record.URL = destinationHeader
+	} else if reverse, incoming = p.matchReverseRoute(r); reverse != nil {
+		// Reverse-proxy request - send it to the route's upstream
+		if r.URL.IsAbs() && reverse.AbsoluteURI == AbsoluteURIReject {
+			record.Error = "Absolute-form request URI not accepted"
+			record.ProxyEndTime = time.Now()
+			p.recordRequest(record)
+			http.Error(w, "Absolute-form request URI not accepted", http.StatusBadRequest)
+			return
+		}
+		targetURL = reverse.target(incoming)
+		record.URL = targetURL.String()
+		record.ReverseRoute = reverse.Route.String()
 	} else {
 		// Regular proxy request - use the request URL
 		targetURL, err = url.Parse(r.URL.String())
 		if err != nil {
 			record.Error = "Invalid URL"
 			record.ProxyEndTime = time.Now()
-			p.history.AddRecord(record)
+			p.recordRequest(record)
 			http.Error(w, "Invalid URL", http.StatusBadRequest)
 			return
 		}
 	}
+	if options.Upstream != nil {
+		targetURL = options.applyUpstream(targetURL)
+		record.URL = targetURL.String()
+	}
+
+	// Check webhook signatures for routes with a configured secret; streamed
+	// bodies are checked once they have been sent
+	if requestCapture == nil {
+		p.verifyWebhook(&record, r.Header, []byte(requestBody), targetURL)
+	}
 
 	// Create the proxied request
 	proxyReq, err := http.NewRequest(r.Method, targetURL.String(), bodyReader)
 	if err != nil {
 		record.Error = "Failed to create proxy request"
 		record.ProxyEndTime = time.Now()
-		p.history.AddRecord(record)
+		p.recordRequest(record)
 		http.Error(w, "Failed to create proxy request", http.StatusInternalServerError)
 		return
 	}
 
+	if options.Timeout > 0 {
+		ctx, cancel := context.WithTimeout(proxyReq.Context(), options.Timeout)
+		defer cancel()
+		proxyReq = proxyReq.WithContext(ctx)
+	}
+
 	// Copy headers from original request
 	for key, values := range r.Header {
-		// Skip the X-Netkit-Destination header - it's only for internal proxy routing
-		if key == "X-Netkit-Destination" {
+		// Skip the X-Netkit-Destination, X-Netkit-Options, X-Netkit-Run, and X-Netkit-Recording headers - they're only for the proxy
+		if key == "X-Netkit-Destination" || key == RequestOptionsHeader || key == RunHeader || key == RecordingHeader {
 			continue
 		}
 		for _, value := range values {
 			proxyReq.Header.Add(key, value)
 		}
 	}
+	if reverse != nil {
+		reverse.applyHeaders(proxyReq, r, incoming)
+	}
+	trace.inject(proxyReq.Header)
+
+	// Record the exact upstream bytes for routes configured for raw capture
+	if record.rawWire = p.rawCaptureFor(proxyReq); record.rawWire != nil {
+		proxyReq = withWireCapture(proxyReq, record.rawWire)
+	}
+
+	// Add validators from the cache layer so polling clients can be revalidated,
+	// and answer preflights the upstream already answered for this origin and route
+	cacheKey := targetURL.String()
+	var cached *cachedResponse
+	if (p.cache != nil || preflight) && options.NoCache {
+		record.CacheStatus = CacheStatusBypass
+	} else if preflight {
+		cacheKey = preflightCacheKey(r.Header, targetURL)
+		if entry := p.preflights.lookup(cacheKey); entry != nil {
+			p.servePreflight(w, entry, &record)
+			return
+		}
+		record.CacheStatus = CacheStatusPreflightMiss
+	} else if p.cache != nil {
+		cached = p.cache.PrepareConditional(cacheKey, proxyReq)
+		record.CacheStatus = CacheStatusMiss
+	}
+
+	// Slow down clients matched by a throttle rule
+	if !p.throttle(w, r, &record) {
+		return
+	}
+
+	// Shed load beyond the upstream's adaptive concurrency limit
+	if p.concurrency != nil && !p.concurrency.acquire(targetURL.Host) {
+		record.Error = "Upstream concurrency limit reached"
+		record.ProxyEndTime = time.Now()
+		p.recordRequest(record)
+		http.Error(w, "Upstream concurrency limit reached", http.StatusServiceUnavailable)
+		return
+	}
+
+	// Send headers with the client's casing to routes that need it
+	if names := headerNamesFrom(r.Context()); names != nil && p.preservesHeaderCase(targetURL) {
+		record.RequestHeaderNames = names
+		applyHeaderCase(proxyReq.Header, names)
+	}
 
 	// Make the request to the target server (start upstream timing)
+	proxyReq, phases := withPhaseTrace(proxyReq)
 	record.UpstreamStartTime = time.Now()
-	resp, err := p.httpClient.Do(proxyReq)
+	resp, hops, err := p.sendUpstream(proxyReq, requestBody, &record)
 	record.UpstreamEndTime = time.Now()
+	record.UpstreamAddr, record.UpstreamIPFamily = phases.endpoint()
+	if requestCapture != nil {
+		record.RequestBody, record.RequestSize, record.RequestBodyTruncated = requestCapture.result()
+		record.requestBodyHash = requestCapture.sum()
+		if !record.RequestBodyTruncated {
+			p.verifyWebhook(&record, r.Header, []byte(record.RequestBody), targetURL)
+		}
+	}
+	if p.concurrency != nil {
+		p.concurrency.release(targetURL.Host, elapsed(record.UpstreamStartTime, record.UpstreamEndTime), isDroppedResponse(resp, err))
+	}
 
+	if err != nil && errors.Is(err, context.DeadlineExceeded) {
+		record.Error = "Upstream timed out"
+		record.ProxyEndTime = time.Now()
+		p.recordRequest(record)
+		http.Error(w, "Upstream timed out", http.StatusGatewayTimeout)
+		return
+	}
 	if err != nil {
 		record.Error = "Failed to proxy request"
 		record.ProxyEndTime = time.Now()
-		p.history.AddRecord(record)
+		p.recordRequest(record)
 		http.Error(w, "Failed to proxy request", http.StatusBadGateway)
 		return
 	}
 	defer func() {
 		if closeErr := resp.Body.Close(); closeErr != nil {
-			log.Printf("Error closing response body: %v", closeErr)
+			slog.Warn("Error closing response body", "error", closeErr)
 		}
 	}()
 
-	// Capture response data
-	responseBody, responseSize, err := captureResponseBody(resp)
-	if err != nil {
-		record.Error = "Failed to read response body"
-		record.ProxyEndTime = time.Now()
-		p.history.AddRecord(record)
-		http.Error(w, "Failed to read response body", http.StatusInternalServerError)
-		return
+	// Record each followed redirect ahead of the final response
+	p.recordRedirectHops(hops, &record, resp)
+
+	// Synthesize the full cached response for clients that did not ask for a 304
+	if cached != nil && resp.StatusCode == http.StatusNotModified {
+		cached.synthesize(resp)
+		record.CacheStatus = CacheStatusRevalidated
+	}
+
+	// Copy bodies of tee routes to their sink as they are read
+	if p.tees != nil {
+		p.tees.tee(targetURL, resp, record.ID)
+	}
+
+	// Save bodies of extract routes to the artifacts directory as they are read
+	var artifact *artifactStream
+	if p.artifacts != nil {
+		artifact = p.artifacts.extract(targetURL, resp, record.ID)
 	}
 
+	// Capture response data, teeing the start of large and unbounded bodies
+	// into history while they are piped to the client
+	var responseCapture *bodyCapture
+	if p.shouldStream(resp.ContentLength) {
+		responseCapture = newBodyCapture(resp.Body, p.streamCaptureLimit())
+		resp.Body = responseCapture
+	} else {
+		responseBody, responseSize, err := captureResponseBody(resp)
+		if err != nil {
+			record.Error = "Failed to read response body"
+			record.ProxyEndTime = time.Now()
+			p.recordRequest(record)
+			http.Error(w, "Failed to read response body", http.StatusInternalServerError)
+			return
+		}
+		record.ResponseBody = responseBody
+		record.ResponseSize = responseSize
+		record.Phases = phases.result(time.Now())
+		p.processResponseBody(&record, cacheKey, proxyReq, resp, targetURL)
+	}
+
+	// Hold the response until it reaches the latency of the route's profile
+	p.shapeLatency(r, targetURL, &record)
+
 	// Update record with response data
 	record.ResponseStatus = resp.StatusCode
 	record.ResponseHeaders = convertHeaders(resp.Header)
-	record.ResponseBody = responseBody
-	record.ResponseSize = responseSize
 	record.Success = true
 
 	// End proxy processing timing here - before we start writing response to client
@@ -261,38 +933,82 @@ func (p *Proxy) handleHTTP(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
+	// Warn callers about deprecations and rate limits seen earlier on this route
+	p.applyAdvisories(w.Header(), targetURL, &record)
+
+	// Expose the proxy's latency breakdown to the client
+	p.setTimingHeaders(w.Header(), &record)
+
 	// Copy status code
 	w.WriteHeader(resp.StatusCode)
 
 	// Copy response body
-	if _, err := io.Copy(w, resp.Body); err != nil {
-		log.Printf("Error copying response body: %v", err)
+	if responseCapture != nil {
+		err = copyStreaming(w, resp.Body)
+	} else {
+		_, err = io.Copy(w, resp.Body)
+	}
+	if err != nil {
+		slog.Warn("Error copying response body", "error", err)
 		record.Error = "Failed to copy response body"
 		record.Success = false
 	}
+	if responseCapture != nil {
+		record.ResponseBody, record.ResponseSize, record.ResponseBodyTruncated = responseCapture.result()
+		record.responseBodyHash = responseCapture.sum()
+		record.Phases = phases.result(time.Now())
+		if record.Success && !record.ResponseBodyTruncated {
+			p.processResponseBody(&record, cacheKey, proxyReq, resp, targetURL)
+		}
+	}
+
+	// Both copies of a hedged request report to the same trace
+	if record.Hedged {
+		record.Phases = nil
+	}
+
+	if artifact != nil {
+		record.ArtifactID = artifact.id()
+	}
 
 	// Record the request (proxy processing complete)
-	p.history.AddRecord(record)
+	p.recordRequest(record)
 
 	// Debug logging for completed requests
-	if p.config.LogLevel == "debug" {
-		log.Printf("HTTP request completed: %s %s -> %d (%dus)",
-			r.Method, r.URL.String(), resp.StatusCode, record.TotalDurationUs)
+	slog.Debug("HTTP request completed", "method", r.Method, "url", r.URL.String(), "status", resp.StatusCode, "duration_us", elapsed(record.ProxyStartTime, record.ProxyEndTime).Microseconds())
+}
+
+// processResponseBody caches a complete response body and decodes it for
+// the record
+func (p *Proxy) processResponseBody(record *RequestRecord, cacheKey string, proxyReq *http.Request, resp *http.Response, targetURL *url.URL) {
+	if p.cache != nil && record.CacheStatus == CacheStatusMiss {
+		p.cache.Store(cacheKey, proxyReq, resp, []byte(record.ResponseBody))
 	}
+	if record.CacheStatus == CacheStatusPreflightMiss {
+		p.preflights.store(cacheKey, resp, p.currentConfig().PreflightMaxAge)
+	}
+
+	// Decode binary bodies for routes with a registered schema
+	p.decodeBodies(record, targetURL)
+
+	// Extract SOAP fields and pretty-print/redact XML bodies
+	p.processXML(record, proxyReq.Header, resp.Header)
 }
 
 // handleConnect handles CONNECT method for HTTPS tunneling
 func (p *Proxy) handleConnect(w http.ResponseWriter, r *http.Request) {
 	// This is a simplified CONNECT handler
 	// In a production proxy, you'd implement proper tunneling
-	dest, err := net.Dial("tcp", r.Host)
+	defer p.metrics.begin(trafficConnect)()
+	dest, err := p.dialer.DialContext(r.Context(), "tcp", r.Host)
 	if err != nil {
+		p.metrics.observe(trafficConnect, r.Method, http.StatusServiceUnavailable, 0, 0, true)
 		http.Error(w, err.Error(), http.StatusServiceUnavailable)
 		return
 	}
 	defer func() {
 		if closeErr := dest.Close(); closeErr != nil {
-			log.Printf("Error closing destination connection: %v", closeErr)
+			slog.Warn("Error closing destination connection", "error", closeErr)
 		}
 	}()
 
@@ -311,20 +1027,24 @@ func (p *Proxy) handleConnect(w http.ResponseWriter, r *http.Request) {
 	}
 	defer func() {
 		if closeErr := clientConn.Close(); closeErr != nil {
-			log.Printf("Error closing client connection: %v", closeErr)
+			slog.Warn("Error closing client connection", "error", closeErr)
 		}
 	}()
 
 	// Start copying data between client and destination
 	go func() {
-		if _, err := io.Copy(dest, clientConn); err != nil {
-			log.Printf("Error copying from client to destination: %v", err)
+		sent, err := io.Copy(dest, clientConn)
+		p.metrics.bytesIn.Add(sent)
+		if err != nil {
+			slog.Warn("Error copying from client to destination", "error", err)
 		}
 	}()
 
-	if _, err := io.Copy(clientConn, dest); err != nil {
-		log.Printf("Error copying from destination to client: %v", err)
+	received, err := io.Copy(clientConn, dest)
+	if err != nil {
+		slog.Warn("Error copying from destination to client", "error", err)
 	}
+	p.metrics.observe(trafficConnect, r.Method, http.StatusOK, 0, received, false)
 }
 
 // handleHealth handles health check requests
@@ -332,7 +1052,7 @@ func (p *Proxy) handleHealth(w http.ResponseWriter, r *http.Request) {
 	// Add CORS headers to allow requests from the dashboard
 	w.Header().Set("Access-Control-Allow-Origin", "*")
 	w.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
-	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Cache-Control, Pragma, Expires")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, Cache-Control, Pragma, Expires")
 
 	// Handle preflight requests
 	if r.Method == http.MethodOptions {
@@ -343,7 +1063,7 @@ func (p *Proxy) handleHealth(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
 	if _, err := w.Write([]byte(`{"status":"healthy","proxy":"netkit"}`)); err != nil {
-		log.Printf("Error writing health response: %v", err)
+		slog.Warn("Error writing health response", "error", err)
 	}
 }
 
@@ -352,7 +1072,7 @@ func (p *Proxy) handleMetrics(w http.ResponseWriter, r *http.Request) {
 	// Add CORS headers to allow requests from the dashboard
 	w.Header().Set("Access-Control-Allow-Origin", "*")
 	w.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
-	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Cache-Control, Pragma, Expires")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, Cache-Control, Pragma, Expires")
 
 	// Handle preflight requests
 	if r.Method == http.MethodOptions {
@@ -362,17 +1082,33 @@ func (p *Proxy) handleMetrics(w http.ResponseWriter, r *http.Request) {
 
 	w.Header().Set("Content-Type", "text/plain")
 	w.WriteHeader(http.StatusOK)
-	// Simple metrics for now - can be expanded later
-	metrics := `# HELP netkit_requests_total Total number of requests handled
-# TYPE netkit_requests_total counter
-netkit_requests_total 0
-
+	var metrics strings.Builder
+	p.metrics.writeTrafficMetrics(&metrics)
+	metrics.WriteString(`
 # HELP netkit_proxy_status Status of the proxy server
 # TYPE netkit_proxy_status gauge
 netkit_proxy_status 1
-`
-	if _, err := w.Write([]byte(metrics)); err != nil {
-		log.Printf("Error writing metrics response: %v", err)
+`)
+	if p.concurrency != nil {
+		p.concurrency.writeConcurrencyMetrics(&metrics)
+	}
+	if len(p.currentConfig().ThrottleRules) > 0 {
+		p.throttler.writeThrottleMetrics(&metrics)
+	}
+	if len(p.currentConfig().Sinks) > 0 {
+		p.sinks.writeSinkMetrics(&metrics)
+	}
+	if p.strict != nil {
+		p.strict.writeStrictMetrics(&metrics)
+	}
+	if p.tracer != nil {
+		p.tracer.writeTracingMetrics(&metrics)
+	}
+	if p.preflights != nil {
+		p.preflights.writePreflightMetrics(&metrics)
+	}
+	if _, err := w.Write([]byte(metrics.String())); err != nil {
+		slog.Warn("Error writing metrics response", "error", err)
 	}
 }
 
@@ -381,7 +1117,7 @@ func (p *Proxy) handleRequestHistory(w http.ResponseWriter, r *http.Request) {
 	// Add CORS headers
 	w.Header().Set("Access-Control-Allow-Origin", "*")
 	w.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
-	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Cache-Control, Pragma, Expires")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, Cache-Control, Pragma, Expires")
 
 	// Handle preflight requests
 	if r.Method == http.MethodOptions {
@@ -394,7 +1130,49 @@ func (p *Proxy) handleRequestHistory(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	data, err := p.history.GetRecordsJSON()
+	filter, err := p.historyFilter(r.URL.Query())
+	if err != nil {
+		http.Error(w, "Invalid filter: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	page, err := parseHistoryPage(r.URL.Query())
+	if err != nil {
+		http.Error(w, "Invalid page: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	records, err := p.queryHistory(filter)
+	if err != nil {
+		slog.Error("Error querying history backend", "error", err)
+		http.Error(w, "Failed to get request history", http.StatusInternalServerError)
+		return
+	}
+
+	total := len(records)
+	records, next, err := page.apply(records)
+	if err != nil {
+		http.Error(w, "Invalid page: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Access-Control-Expose-Headers", "X-Total-Count, X-Next-Cursor, Link")
+	w.Header().Set("X-Total-Count", strconv.Itoa(total))
+	if next != "" {
+		query := r.URL.Query()
+		query.Del("offset")
+		query.Set("cursor", next)
+		w.Header().Set("X-Next-Cursor", next)
+		w.Header().Set("Link", fmt.Sprintf(`<%s?%s>; rel="next"`, r.URL.Path, query.Encode()))
+	}
+	if summary, _ := strconv.ParseBool(r.URL.Query().Get("summary")); summary {
+		for i := range records {
+			records[i].summarize()
+		}
+	}
+
+	data, err := json.Marshal(map[string]interface{}{
+		"records": records,
+		"total":   total,
+	})
 	if err != nil {
 		http.Error(w, "Failed to get request history", http.StatusInternalServerError)
 		return
@@ -403,7 +1181,132 @@ func (p *Proxy) handleRequestHistory(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
 	if _, err := w.Write(data); err != nil {
-		log.Printf("Error writing request history response: %v", err)
+		slog.Warn("Error writing request history response", "error", err)
+	}
+}
+
+// queryHistory returns the records that match filter, most recent first,
+// from the history backend when there is one
+func (p *Proxy) queryHistory(filter *RequestFilter) ([]RequestRecord, error) {
+	if p.historyStore != nil {
+		return p.historyStore.query(filter)
+	}
+	return p.history.GetFilteredRecords(filter), nil
+}
+
+// eachHistory calls fn with the records that match filter, most recent
+// first, until it returns an error. Stores that can stream are read one
+// record at a time.
+func (p *Proxy) eachHistory(filter *RequestFilter, fn func(RequestRecord) error) error {
+	if streamer, ok := p.historyStore.(historyStreamer); ok {
+		return streamer.each(filter, fn)
+	}
+	records, err := p.queryHistory(filter)
+	if err != nil {
+		return err
+	}
+	for _, record := range records {
+		if err := fn(record); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// handleRequestDetail serves a single record with its headers and bodies
+// (GET /requests/{id}) and deletes it (DELETE /requests/{id})
+func (p *Proxy) handleRequestDetail(w http.ResponseWriter, r *http.Request) {
+	// Add CORS headers
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "GET, POST, DELETE, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, Cache-Control, Pragma, Expires")
+
+	// Handle preflight requests
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	id := strings.TrimPrefix(r.URL.Path, "/requests/")
+	if original, ok := strings.CutSuffix(id, "/replay"); ok && original != "" && !strings.Contains(original, "/") {
+		p.handleReplayRequest(w, r, original)
+		return
+	}
+	if id == "" || strings.Contains(id, "/") {
+		http.NotFound(w, r)
+		return
+	}
+
+	if r.Method == http.MethodDelete {
+		p.handleDeleteRequest(w, r, id)
+		return
+	}
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	records, err := p.queryHistory(&RequestFilter{IDs: []string{id}})
+	if err != nil {
+		slog.Error("Error querying history backend", "error", err)
+		http.Error(w, "Failed to get request", http.StatusInternalServerError)
+		return
+	}
+	if len(records) == 0 {
+		http.Error(w, "Request not found", http.StatusNotFound)
+		return
+	}
+
+	data, err := json.Marshal(records[0])
+	if err != nil {
+		http.Error(w, "Failed to get request", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if _, err := w.Write(data); err != nil {
+		slog.Warn("Error writing request detail response", "error", err)
+	}
+}
+
+// handleDeleteRequest deletes one record, e.g. one that captured a secret,
+// with its raw capture and its copy in the history backend. It is not backed
+// up first.
+func (p *Proxy) handleDeleteRequest(w http.ResponseWriter, r *http.Request, id string) {
+	if !p.allowPurge(w, r) {
+		return
+	}
+
+	deleted := p.history.RemoveRecord(id)
+	// Records of other instances are only in a shared backend
+	if !deleted && p.historyStore != nil && p.historyStore.shared() {
+		records, err := p.historyStore.query(&RequestFilter{IDs: []string{id}})
+		if err != nil {
+			slog.Error("Error querying history backend", "error", err)
+			http.Error(w, "Failed to delete request", http.StatusInternalServerError)
+			return
+		}
+		if deleted = len(records) > 0; deleted {
+			p.historyStore.recordsRemoved([]string{id})
+		}
+	}
+	p.rawCaptures.remove(id)
+	if !deleted {
+		http.Error(w, "Request not found", http.StatusNotFound)
+		return
+	}
+
+	data, err := json.Marshal(map[string]interface{}{"success": true, "message": "Request deleted"})
+	if err != nil {
+		http.Error(w, "Failed to encode response", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if _, err := w.Write(data); err != nil {
+		slog.Warn("Error writing delete request response", "error", err)
 	}
 }
 
@@ -412,7 +1315,7 @@ func (p *Proxy) handleRequestStats(w http.ResponseWriter, r *http.Request) {
 	// Add CORS headers
 	w.Header().Set("Access-Control-Allow-Origin", "*")
 	w.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
-	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Cache-Control, Pragma, Expires")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, Cache-Control, Pragma, Expires")
 
 	// Handle preflight requests
 	if r.Method == http.MethodOptions {
@@ -425,7 +1328,17 @@ func (p *Proxy) handleRequestStats(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// A shared backend has the stats of every instance writing to it
 	stats := p.history.GetStats()
+	if p.historyStore != nil && p.historyStore.shared() {
+		records, err := p.historyStore.query(&RequestFilter{})
+		if err != nil {
+			slog.Error("Error querying shared history", "error", err)
+			http.Error(w, "Failed to get request stats", http.StatusInternalServerError)
+			return
+		}
+		stats = recordStats(records)
+	}
 	data, err := json.Marshal(stats)
 	if err != nil {
 		http.Error(w, "Failed to get request stats", http.StatusInternalServerError)
@@ -435,7 +1348,7 @@ func (p *Proxy) handleRequestStats(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
 	if _, err := w.Write(data); err != nil {
-		log.Printf("Error writing request stats response: %v", err)
+		slog.Warn("Error writing request stats response", "error", err)
 	}
 }
 
@@ -444,7 +1357,7 @@ func (p *Proxy) handleClearHistory(w http.ResponseWriter, r *http.Request) {
 	// Add CORS headers
 	w.Header().Set("Access-Control-Allow-Origin", "*")
 	w.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS")
-	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Cache-Control, Pragma, Expires")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, Cache-Control, Pragma, Expires")
 
 	// Handle preflight requests
 	if r.Method == http.MethodOptions {
@@ -457,47 +1370,85 @@ func (p *Proxy) handleClearHistory(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if !p.allowPurge(w, r) {
+		return
+	}
+	backup, err := p.backupBeforePurge(p.history.GetRecords(), "clearing history")
+	if err != nil {
+		slog.Error("Error saving history before clearing it", "error", err)
+		http.Error(w, "Failed to save history before clearing it, history was kept", http.StatusInternalServerError)
+		return
+	}
+
+	if p.tailSampler != nil {
+		p.tailSampler.reset()
+	}
 	p.history.Clear()
+	p.heatmap.reset()
+
+	response := map[string]interface{}{"success": true, "message": "Request history cleared"}
+	if backup != "" {
+		response["backup"] = backup
+	}
+	data, err := json.Marshal(response)
+	if err != nil {
+		http.Error(w, "Failed to encode response", http.StatusInternalServerError)
+		return
+	}
 
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
-	if _, err := w.Write([]byte(`{"success": true, "message": "Request history cleared"}`)); err != nil {
-		log.Printf("Error writing clear history response: %v", err)
+	if _, err := w.Write(data); err != nil {
+		slog.Warn("Error writing clear history response", "error", err)
 	}
 }
 
-// Start starts the proxy server and admin server (if configured)
+// Start starts the proxy server and admin server (if configured). It blocks
+// until Stop is called and then returns http.ErrServerClosed, like
+// ListenAndServe.
 func (p *Proxy) Start() error {
 	if p.server == nil {
 		return fmt.Errorf("server not initialized")
 	}
+	config := p.currentConfig()
 
-	// Start admin server in background if configured
-	if p.adminServer != nil {
-		go func() {
-			log.Printf("Starting admin server on port %d", p.config.AdminPort)
-			if err := p.adminServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-				log.Printf("Admin server error: %v", err)
-			}
-		}()
+	p.reloadMutex.Lock()
+	// Start admin and dashboard servers, logging rather than returning their errors
+	if err := p.bind(p.adminServer, "admin", config.AdminPort); err != nil {
+		slog.Error("Admin server error", "error", err)
 	}
-
-	// Start dashboard server in background if configured
 	if p.dashboardServer != nil {
-		go func() {
-			log.Printf("Starting dashboard server on port %d", p.config.DashboardPort)
-			if err := p.dashboardServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-				log.Printf("Dashboard server error: %v", err)
-			}
-		}()
+		if err := p.bind(p.dashboardServer, "dashboard", config.DashboardPort); err != nil {
+			slog.Error("Dashboard server error", "error", err)
+		}
+	}
+	err := p.bind(p.server, "proxy", config.Port)
+	p.started = err == nil
+	p.reloadMutex.Unlock()
+	if err != nil {
+		return err
 	}
 
-	log.Printf("Starting proxy server on port %d", p.config.Port)
-	return p.server.ListenAndServe()
+	<-p.stopped
+	return http.ErrServerClosed
+}
+
+// Serve serves proxy traffic on an additional listener, such as a tunnel, alongside the proxy port
+func (p *Proxy) Serve(ln net.Listener) error {
+	return p.server.Serve(ln)
 }
 
 // Stop stops both the proxy server and admin server
 func (p *Proxy) Stop() error {
+	// Let Start return once everything has shut down, and stop reloads from binding listeners
+	defer p.stopOnce.Do(func() { close(p.stopped) })
+	p.reloadMutex.Lock()
+	p.started = false
+	p.reloadMutex.Unlock()
+
+	// End live streams, which would otherwise hold up the admin server's shutdown
+	p.feed.close()
+
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
@@ -515,6 +1466,51 @@ func (p *Proxy) Stop() error {
 		dashboardErr = p.dashboardServer.Shutdown(ctx)
 	}
 
+	if p.inbox != nil {
+		p.inbox.stop()
+	}
+
+	if p.reports != nil {
+		p.reports.stop()
+	}
+
+	if p.prewarm != nil {
+		p.prewarm.stop()
+	}
+
+	if p.tees != nil {
+		p.tees.stop()
+	}
+
+	if p.dialer.jumps != nil {
+		p.dialer.jumps.close()
+	}
+
+	// Decide on buffered records before the final history snapshot
+	if p.tailSampler != nil {
+		p.tailSampler.stop()
+	}
+
+	// Deliver what the sinks still hold, including records the sampler just kept
+	p.sinks.stop()
+	p.metrics.statsd.stop()
+	p.tracer.stop()
+
+	if p.historyFile != nil {
+		if err := p.historyFile.stop(); err != nil {
+			slog.Error("Error saving history file", "error", err)
+		}
+	}
+	if p.historyExpiry != nil {
+		p.historyExpiry.stop()
+	}
+	if p.historyStore != nil {
+		p.history.attach(nil)
+		if err := p.historyStore.close(); err != nil {
+			slog.Warn("Error closing history backend", "error", err)
+		}
+	}
+
 	// Return the first error encountered
 	if proxyErr != nil {
 		return fmt.Errorf("proxy server shutdown error: %v", proxyErr)
@@ -553,7 +1549,7 @@ func captureRequestBody(r *http.Request) (string, int64, io.Reader) {
 
 	// Close the original body
 	if err := r.Body.Close(); err != nil {
-		log.Printf("Error closing request body: %v", err)
+		slog.Warn("Error closing request body", "error", err)
 	}
 
 	// Create new readers for the proxy and for capture
@@ -577,7 +1573,7 @@ func captureResponseBody(resp *http.Response) (string, int64, error) {
 
 	// Close the original body
 	if err := resp.Body.Close(); err != nil {
-		log.Printf("Error closing response body: %v", err)
+		slog.Warn("Error closing response body", "error", err)
 	}
 
 	// Replace with a new reader for downstream consumption
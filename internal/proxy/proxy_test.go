@@ -86,20 +86,20 @@ func TestNewProxy(t *testing.T) {
 
 	proxy := New(config)
 
-	if proxy.config.Port != 9090 {
-		t.Errorf("Expected port 9090, got %d", proxy.config.Port)
+	if proxy.currentConfig().Port != 9090 {
+		t.Errorf("Expected port 9090, got %d", proxy.currentConfig().Port)
 	}
 
-	if proxy.config.LogLevel != "debug" {
-		t.Errorf("Expected log level 'debug', got %s", proxy.config.LogLevel)
+	if proxy.currentConfig().LogLevel != "debug" {
+		t.Errorf("Expected log level 'debug', got %s", proxy.currentConfig().LogLevel)
 	}
 
-	if proxy.config.AdminPort != 9091 {
-		t.Errorf("Expected admin port 9091, got %d", proxy.config.AdminPort)
+	if proxy.currentConfig().AdminPort != 9091 {
+		t.Errorf("Expected admin port 9091, got %d", proxy.currentConfig().AdminPort)
 	}
 
-	if proxy.config.HistorySize != 500 {
-		t.Errorf("Expected history size 500, got %d", proxy.config.HistorySize)
+	if proxy.currentConfig().HistorySize != 500 {
+		t.Errorf("Expected history size 500, got %d", proxy.currentConfig().HistorySize)
 	}
 
 	if proxy.history == nil {
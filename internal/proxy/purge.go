@@ -0,0 +1,165 @@
+package proxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Clear protection modes for history clearing and other purges
+const (
+	ClearProtectionConfirm = "confirm" // Purges must be repeated with a short-lived confirmation token
+	ClearProtectionAdmin   = "admin"   // Purges need a token with the admin scope
+)
+
+// ConfirmHeader carries the confirmation token of a protected purge
+const ConfirmHeader = "X-Netkit-Confirm"
+
+// confirmationTTL is how long a confirmation token can be used
+const confirmationTTL = time.Minute
+
+// ValidateClearProtection checks that a clear protection mode is known
+func ValidateClearProtection(mode string) error {
+	switch mode {
+	case "", ClearProtectionConfirm, ClearProtectionAdmin:
+		return nil
+	}
+	return fmt.Errorf("unknown clear protection %q (expected confirm or admin)", mode)
+}
+
+// isPurgeRequest reports whether an admin request deletes recorded traffic:
+// clearing history, deleting a test run or its records, or deleting a capture
+func isPurgeRequest(r *http.Request) bool {
+	switch {
+	case r.Method == http.MethodPost && r.URL.Path == "/requests/clear":
+		return true
+	case r.Method == http.MethodDelete && (r.URL.Path == "/captures" || strings.HasPrefix(r.URL.Path, "/runs/")):
+		return true
+	}
+	return false
+}
+
+// purgeConfirmations holds the confirmation tokens handed out for purges.
+// Each token confirms one purge of one target and is used up by it.
+type purgeConfirmations struct {
+	mutex   sync.Mutex
+	pending map[string]pendingPurge
+}
+
+// pendingPurge is a purge waiting to be confirmed
+type pendingPurge struct {
+	target  string
+	expires time.Time
+}
+
+func newPurgeConfirmations() *purgeConfirmations {
+	return &purgeConfirmations{pending: make(map[string]pendingPurge)}
+}
+
+// Issue returns a new token confirming a purge of target
+func (c *purgeConfirmations) Issue(target string, now time.Time) (string, time.Time) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	for token, purge := range c.pending {
+		if now.After(purge.expires) {
+			delete(c.pending, token)
+		}
+	}
+	token := generateID()
+	expires := now.Add(confirmationTTL)
+	c.pending[token] = pendingPurge{target: target, expires: expires}
+	return token, expires
+}
+
+// Confirm uses up a token, reporting whether it confirms a purge of target
+func (c *purgeConfirmations) Confirm(token, target string, now time.Time) bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	purge, ok := c.pending[token]
+	if !ok || purge.target != target {
+		return false
+	}
+	delete(c.pending, token)
+	return !now.After(purge.expires)
+}
+
+// allowPurge enforces the clear protection mode on a purge request. When the
+// purge may not go ahead it writes the response and returns false: a
+// confirmation token to repeat the request with, or why it was refused.
+func (p *Proxy) allowPurge(w http.ResponseWriter, r *http.Request) bool {
+	switch p.currentConfig().ClearProtection {
+	case ClearProtectionAdmin:
+		// requireAdminAuth has checked the admin scope, as long as auth is on
+		if !p.adminAuthEnabled() {
+			http.Error(w, "Deleting recorded traffic requires an admin token (--admin-token or an issued token with the admin scope)", http.StatusForbidden)
+			return false
+		}
+		return true
+
+	case ClearProtectionConfirm:
+		target := r.Method + " " + r.URL.Path + "?name=" + r.URL.Query().Get("name")
+		token := r.Header.Get(ConfirmHeader)
+		if token == "" {
+			token = r.URL.Query().Get("confirm")
+		}
+		now := time.Now()
+		if token != "" && p.confirmations.Confirm(token, target, now) {
+			return true
+		}
+
+		token, expires := p.confirmations.Issue(target, now)
+		message := "Repeat the request with the " + ConfirmHeader + " header or confirm parameter set to confirm_token"
+		if r.Header.Get(ConfirmHeader) != "" || r.URL.Query().Get("confirm") != "" {
+			message = "Confirmation token is invalid, expired, or for another request; " + message
+		}
+		data, err := json.Marshal(map[string]interface{}{
+			"success":       false,
+			"message":       message,
+			"confirm_token": token,
+			"expires_at":    expires.UTC(),
+		})
+		if err != nil {
+			http.Error(w, "Failed to encode confirmation", http.StatusInternalServerError)
+			return false
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusPreconditionRequired)
+		if _, err := w.Write(data); err != nil {
+			slog.Warn("Error writing purge confirmation response", "error", err)
+		}
+		return false
+	}
+	return true
+}
+
+// backupBeforePurge saves records about to be deleted as a capture named
+// pre-clear-<time> when ClearBackup is set, returning the capture's name. It
+// does nothing when there is nothing to save.
+func (p *Proxy) backupBeforePurge(records []RequestRecord, reason string) (string, error) {
+	if !p.currentConfig().ClearBackup || len(records) == 0 {
+		return "", nil
+	}
+
+	now := time.Now()
+	capture := Capture{
+		CaptureInfo: CaptureInfo{
+			Name:        "pre-clear-" + now.UTC().Format("20060102T150405.000000Z"),
+			Description: "Saved automatically before " + reason,
+			CreatedAt:   now,
+			Start:       records[len(records)-1].Timestamp,
+			End:         records[0].Timestamp,
+		},
+		Records: records,
+	}
+	if err := p.captures.Create(capture); err != nil {
+		return "", err
+	}
+	slog.Info("Saved records to a capture", "records", len(records), "capture", capture.Name, "before", reason)
+	return capture.Name, nil
+}
@@ -0,0 +1,107 @@
+//go:build unit
+
+package proxy
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClearProtectionConfirm(t *testing.T) {
+	p := New(&Config{ClearProtection: ClearProtectionConfirm})
+	p.history.AddRecord(RequestRecord{ID: "evidence"})
+
+	clear := func(token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/requests/clear", nil)
+		if token != "" {
+			req.Header.Set(ConfirmHeader, token)
+		}
+		rec := httptest.NewRecorder()
+		p.handleClearHistory(rec, req)
+		return rec
+	}
+
+	rec := clear("")
+	require.Equal(t, http.StatusPreconditionRequired, rec.Code)
+	var confirmation struct {
+		ConfirmToken string    `json:"confirm_token"`
+		ExpiresAt    time.Time `json:"expires_at"`
+	}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &confirmation))
+	require.NotEmpty(t, confirmation.ConfirmToken)
+	assert.Len(t, p.history.GetRecords(), 1, "nothing is cleared before confirming")
+
+	assert.Equal(t, http.StatusPreconditionRequired, clear("wrong").Code)
+	assert.Equal(t, http.StatusOK, clear(confirmation.ConfirmToken).Code)
+	assert.Empty(t, p.history.GetRecords())
+	assert.Equal(t, http.StatusPreconditionRequired, clear(confirmation.ConfirmToken).Code, "tokens are used up")
+}
+
+func TestPurgeConfirmations(t *testing.T) {
+	confirmations := newPurgeConfirmations()
+	now := time.Now()
+	token, expires := confirmations.Issue("DELETE /captures?name=a", now)
+	assert.Equal(t, now.Add(confirmationTTL), expires)
+	assert.False(t, confirmations.Confirm(token, "DELETE /captures?name=b", now), "tokens confirm one target")
+	assert.True(t, confirmations.Confirm(token, "DELETE /captures?name=a", now))
+
+	token, _ = confirmations.Issue("POST /requests/clear?name=", now)
+	assert.False(t, confirmations.Confirm(token, "POST /requests/clear?name=", now.Add(2*confirmationTTL)), "tokens expire")
+}
+
+func TestClearProtectionAdmin(t *testing.T) {
+	// Without admin authentication nobody holds an elevated role
+	p := New(&Config{ClearProtection: ClearProtectionAdmin})
+	assert.Equal(t, http.StatusForbidden, adminRequest(p, http.MethodPost, "/requests/clear", "", "").Code)
+
+	p = New(&Config{ClearProtection: ClearProtectionAdmin, AdminToken: "root-secret"})
+	writer, _ := createToken(t, p, "root-secret", `{"name": "dashboard", "scopes": ["read", "write"]}`)
+	assert.Equal(t, http.StatusForbidden, adminRequest(p, http.MethodPost, "/requests/clear", writer, "").Code)
+	admin, _ := createToken(t, p, "root-secret", `{"name": "ops", "scopes": ["admin"]}`)
+	assert.Equal(t, http.StatusOK, adminRequest(p, http.MethodPost, "/requests/clear", admin, "").Code)
+}
+
+func TestClearBackup(t *testing.T) {
+	p := New(&Config{ClearBackup: true})
+	rec := httptest.NewRecorder()
+	p.handleClearHistory(rec, httptest.NewRequest(http.MethodPost, "/requests/clear", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.NotContains(t, rec.Body.String(), "backup", "empty history is not saved")
+
+	base := time.Now()
+	p.history.AddRecord(RequestRecord{ID: "first", Timestamp: base})
+	p.history.AddRecord(RequestRecord{ID: "second", Timestamp: base.Add(time.Second)})
+	rec = httptest.NewRecorder()
+	p.handleClearHistory(rec, httptest.NewRequest(http.MethodPost, "/requests/clear", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	var cleared struct {
+		Backup string `json:"backup"`
+	}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &cleared))
+	assert.Empty(t, p.history.GetRecords())
+
+	capture, ok := p.captures.Get(cleared.Backup)
+	require.True(t, ok)
+	assert.Equal(t, 2, capture.Count)
+	assert.Equal(t, "second", capture.Records[0].ID)
+	assert.Equal(t, base.Add(time.Second), capture.End)
+
+	// Deleting a run saves just its records
+	run := createRun(t, p, `{"name": "ci"}`)
+	p.history.AddRecord(RequestRecord{ID: "in-run", RunID: run.ID})
+	p.history.AddRecord(RequestRecord{ID: "other"})
+	rec = httptest.NewRecorder()
+	p.handleRun(rec, httptest.NewRequest(http.MethodDelete, "/runs/"+run.ID, nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &cleared))
+	capture, ok = p.captures.Get(cleared.Backup)
+	require.True(t, ok)
+	require.Len(t, capture.Records, 1)
+	assert.Equal(t, "in-run", capture.Records[0].ID)
+}
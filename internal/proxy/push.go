@@ -0,0 +1,219 @@
+package proxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// Topics of the admin push channel
+const (
+	PushTopicRequests = "requests" // Each new record
+	PushTopicStats    = "stats"    // Statistics of the records since the last delta
+	PushTopicHealth   = "health"   // Readiness, when it changes
+)
+
+// pushSubscribeTimeout is how long a push client has to send its
+// subscription after connecting
+const pushSubscribeTimeout = 10 * time.Second
+
+// pushInterval is how often stats deltas are sent and readiness is checked
+const pushInterval = time.Second
+
+// pushSubscription is the first message of a push client
+type pushSubscription struct {
+	Topics  []string          `json:"topics"`  // Default: every topic
+	Filter  map[string]string `json:"filter"`  // GET /requests filters, e.g. {"host": "api.example.com", "status": "5xx"}
+	Summary bool              `json:"summary"` // Leave headers and bodies out of records
+}
+
+// pushMessage is a message sent to push clients
+type pushMessage struct {
+	Type    string                 `json:"type"` // subscribed, request, stats, health, or error
+	Topics  []string               `json:"topics,omitempty"`
+	Record  *RequestRecord         `json:"record,omitempty"`
+	Stats   map[string]interface{} `json:"stats,omitempty"`
+	Health  *pushHealth            `json:"health,omitempty"`
+	Dropped int                    `json:"dropped,omitempty"` // Records missed since the previous message because the client fell behind
+	Error   string                 `json:"error,omitempty"`
+}
+
+// pushHealth is the readiness sent on the health topic
+type pushHealth struct {
+	Status  string          `json:"status"` // ready, warming, or stopping
+	Prewarm []PrewarmStatus `json:"prewarm"`
+}
+
+// handlePush serves GET /ws: a WebSocket that pushes new records, stats
+// deltas, and readiness changes to the dashboard, for the topics and
+// filters the client subscribes to in its first message
+func (p *Proxy) handlePush(w http.ResponseWriter, r *http.Request) {
+	conn := upgradeWebSocket(w, r)
+	if conn == nil {
+		return
+	}
+	defer func() {
+		if err := conn.Close(); err != nil {
+			slog.Warn("Error closing push connection", "error", err)
+		}
+	}()
+
+	send := func(message pushMessage) error {
+		data, err := json.Marshal(message)
+		if err != nil {
+			return err
+		}
+		return conn.writeText(data)
+	}
+	reject := func(code int, reason string) {
+		if err := send(pushMessage{Type: "error", Error: reason}); err == nil {
+			_ = conn.close(code, reason)
+		}
+	}
+
+	// The subscription comes first
+	_ = conn.conn.SetReadDeadline(time.Now().Add(pushSubscribeTimeout))
+	opcode, data, err := conn.readMessage()
+	if err != nil {
+		return
+	}
+	_ = conn.conn.SetReadDeadline(time.Time{})
+	if opcode != websocketText {
+		reject(websocketUnsupportedData, "expected a JSON subscription")
+		return
+	}
+	var subscription pushSubscription
+	if err := json.Unmarshal(data, &subscription); err != nil {
+		reject(websocketPolicyViolation, "invalid subscription: "+err.Error())
+		return
+	}
+	topics, err := pushTopics(subscription.Topics)
+	if err != nil {
+		reject(websocketPolicyViolation, "invalid subscription: "+err.Error())
+		return
+	}
+	values := url.Values{}
+	for key, value := range subscription.Filter {
+		values.Set(key, value)
+	}
+	filter, err := p.historyFilter(values)
+	if err != nil {
+		reject(websocketPolicyViolation, "invalid filter: "+err.Error())
+		return
+	}
+
+	subscriber := p.feed.subscribe()
+	if subscriber == nil {
+		reject(websocketGoingAway, "proxy is shutting down")
+		return
+	}
+	defer p.feed.unsubscribe(subscriber)
+	if err := send(pushMessage{Type: "subscribed", Topics: pushTopicList(topics)}); err != nil {
+		return
+	}
+
+	var health pushHealth
+	sendHealth := func() error {
+		status, prewarm := p.readiness()
+		if status == health.Status {
+			return nil
+		}
+		health = pushHealth{Status: status, Prewarm: prewarm}
+		return send(pushMessage{Type: "health", Health: &health})
+	}
+	if topics[PushTopicHealth] {
+		if err := sendHealth(); err != nil {
+			return
+		}
+	}
+
+	// Later messages from the client are only read for pings and closing
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		for {
+			if _, _, err := conn.readMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	ticker := time.NewTicker(pushInterval)
+	defer ticker.Stop()
+	var delta []RequestRecord
+	dropped := 0
+	for {
+		select {
+		case <-closed:
+			return
+		case <-ticker.C:
+			if topics[PushTopicStats] && len(delta) > 0 {
+				if err := send(pushMessage{Type: "stats", Stats: recordStats(delta)}); err != nil {
+					return
+				}
+				delta = nil
+			}
+			if topics[PushTopicHealth] {
+				if err := sendHealth(); err != nil {
+					return
+				}
+			}
+		case record, ok := <-subscriber.records:
+			if !ok {
+				if topics[PushTopicHealth] {
+					_ = send(pushMessage{Type: "health", Health: &pushHealth{Status: "stopping", Prewarm: []PrewarmStatus{}}})
+				}
+				_ = conn.close(websocketGoingAway, "proxy is shutting down")
+				return
+			}
+			dropped += p.feed.takeDropped(subscriber)
+			if !filter.Matches(record) {
+				continue
+			}
+			if topics[PushTopicStats] {
+				delta = append(delta, record)
+			}
+			if topics[PushTopicRequests] {
+				if subscription.Summary {
+					record.summarize()
+				}
+				if err := send(pushMessage{Type: "request", Record: &record, Dropped: dropped}); err != nil {
+					return
+				}
+				dropped = 0
+			}
+		}
+	}
+}
+
+// pushTopics returns the set of subscribed topics, every topic when none
+// are named
+func pushTopics(names []string) (map[string]bool, error) {
+	if len(names) == 0 {
+		names = []string{PushTopicRequests, PushTopicStats, PushTopicHealth}
+	}
+	topics := make(map[string]bool)
+	for _, name := range names {
+		switch name {
+		case PushTopicRequests, PushTopicStats, PushTopicHealth:
+			topics[name] = true
+		default:
+			return nil, fmt.Errorf("unknown topic %q (expected %s, %s, or %s)", name, PushTopicRequests, PushTopicStats, PushTopicHealth)
+		}
+	}
+	return topics, nil
+}
+
+// pushTopicList lists subscribed topics in a fixed order
+func pushTopicList(topics map[string]bool) []string {
+	var list []string
+	for _, name := range []string{PushTopicRequests, PushTopicStats, PushTopicHealth} {
+		if topics[name] {
+			list = append(list, name)
+		}
+	}
+	return list
+}
@@ -0,0 +1,149 @@
+//go:build unit
+
+package proxy
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testWebSocket is the client side of a WebSocket connection
+type testWebSocket struct {
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+// dialTestWebSocket opens a WebSocket to path on server
+func dialTestWebSocket(t *testing.T, server *httptest.Server, path string) *testWebSocket {
+	conn, err := net.Dial("tcp", strings.TrimPrefix(server.URL, "http://"))
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = conn.Close() })
+	require.NoError(t, conn.SetDeadline(time.Now().Add(5*time.Second)))
+
+	_, err = io.WriteString(conn, "GET "+path+" HTTP/1.1\r\nHost: netkit\r\nConnection: Upgrade\r\nUpgrade: websocket\r\n"+
+		"Sec-WebSocket-Version: 13\r\nSec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\n\r\n")
+	require.NoError(t, err)
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, nil)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusSwitchingProtocols, resp.StatusCode)
+	// The accept key of the RFC 6455 example
+	require.Equal(t, "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=", resp.Header.Get("Sec-WebSocket-Accept"))
+	return &testWebSocket{conn: conn, reader: reader}
+}
+
+// write sends a masked frame
+func (ws *testWebSocket) write(t *testing.T, opcode byte, payload []byte) {
+	mask := [4]byte{1, 2, 3, 4}
+	frame := []byte{0x80 | opcode, 0x80 | byte(len(payload))}
+	frame = append(frame, mask[:]...)
+	for i, b := range payload {
+		frame = append(frame, b^mask[i%4])
+	}
+	_, err := ws.conn.Write(frame)
+	require.NoError(t, err)
+}
+
+// read returns the opcode and payload of the next frame
+func (ws *testWebSocket) read(t *testing.T) (byte, []byte) {
+	var header [2]byte
+	_, err := io.ReadFull(ws.reader, header[:])
+	require.NoError(t, err)
+	length := int(header[1] & 0x7f)
+	if length == 126 {
+		var extended [2]byte
+		_, err := io.ReadFull(ws.reader, extended[:])
+		require.NoError(t, err)
+		length = int(binary.BigEndian.Uint16(extended[:]))
+	}
+	payload := make([]byte, length)
+	_, err = io.ReadFull(ws.reader, payload)
+	require.NoError(t, err)
+	return header[0] & 0x0f, payload
+}
+
+// message reads the next push message
+func (ws *testWebSocket) message(t *testing.T) pushMessage {
+	opcode, payload := ws.read(t)
+	require.Equal(t, byte(websocketText), opcode, string(payload))
+	var message pushMessage
+	require.NoError(t, json.Unmarshal(payload, &message))
+	return message
+}
+
+func TestHandlePush(t *testing.T) {
+	p := New(&Config{})
+	server := httptest.NewServer(http.HandlerFunc(p.handlePush))
+	defer server.Close()
+
+	ws := dialTestWebSocket(t, server, "/ws")
+	ws.write(t, websocketText, []byte(`{"filter":{"host":"api.example.com","status":"5xx"},"summary":true}`))
+	subscribed := ws.message(t)
+	assert.Equal(t, "subscribed", subscribed.Type)
+	assert.Equal(t, []string{PushTopicRequests, PushTopicStats, PushTopicHealth}, subscribed.Topics)
+	health := ws.message(t)
+	require.NotNil(t, health.Health)
+	assert.Equal(t, "ready", health.Health.Status)
+
+	p.storeRecord(RequestRecord{ID: "ok", URL: "http://api.example.com/users", ResponseStatus: 200})
+	p.storeRecord(RequestRecord{ID: "other", URL: "http://other.example.com/users", ResponseStatus: 502})
+	p.storeRecord(RequestRecord{ID: "failed", URL: "http://api.example.com/users", ResponseStatus: 503, ResponseBody: "unavailable"})
+
+	request := ws.message(t)
+	assert.Equal(t, "request", request.Type)
+	require.NotNil(t, request.Record)
+	assert.Equal(t, "failed", request.Record.ID, "records outside the filter are skipped")
+	assert.Empty(t, request.Record.ResponseBody, "summaries leave out bodies")
+
+	stats := ws.message(t)
+	assert.Equal(t, "stats", stats.Type)
+	assert.Equal(t, float64(1), stats.Stats["total_requests"], "stats cover the matching records since the last delta")
+
+	// Pings are answered
+	ws.write(t, websocketPing, []byte("hello"))
+	opcode, payload := ws.read(t)
+	assert.Equal(t, byte(websocketPong), opcode)
+	assert.Equal(t, "hello", string(payload))
+
+	// Stopping closes the connection as going away
+	p.feed.close()
+	opcode, payload = ws.read(t)
+	if opcode == websocketText {
+		opcode, payload = ws.read(t)
+	}
+	assert.Equal(t, byte(websocketClose), opcode)
+	assert.Equal(t, uint16(websocketGoingAway), binary.BigEndian.Uint16(payload))
+}
+
+func TestHandlePushRejectsSubscription(t *testing.T) {
+	p := New(&Config{})
+	server := httptest.NewServer(http.HandlerFunc(p.handlePush))
+	defer server.Close()
+
+	for _, subscription := range []string{`{"topics":["traces"]}`, `{"filter":{"status":"teapot"}}`, `not json`} {
+		ws := dialTestWebSocket(t, server, "/ws")
+		ws.write(t, websocketText, []byte(subscription))
+		message := ws.message(t)
+		assert.Equal(t, "error", message.Type, subscription)
+		opcode, payload := ws.read(t)
+		assert.Equal(t, byte(websocketClose), opcode)
+		assert.Equal(t, uint16(websocketPolicyViolation), binary.BigEndian.Uint16(payload))
+	}
+
+	// Plain HTTP requests are not upgraded
+	resp, err := http.Get(server.URL + "/ws")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
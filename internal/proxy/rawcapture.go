@@ -0,0 +1,264 @@
+package proxy
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"slices"
+	"sync"
+)
+
+// DefaultRawCaptureLimit is how many bytes of each direction a raw capture
+// keeps by default
+const DefaultRawCaptureLimit = 1 << 20
+
+// rawCaptureKeep is how many raw captures are kept, most recent first; older
+// ones are dropped even while their records are still in history
+const rawCaptureKeep = 100
+
+// Parts of a raw capture for GET /requests/raw
+const (
+	RawPartRequest  = "request"  // Bytes sent upstream
+	RawPartResponse = "response" // Bytes received from upstream
+)
+
+// wireCapture records the bytes of a request's upstream exchanges exactly as
+// they cross the connection, after TLS is removed: start-lines, headers as
+// written and read, and chunk framing. Followed redirects are recorded one
+// after another.
+type wireCapture struct {
+	mutex     sync.Mutex
+	limit     int64
+	sent      []byte
+	received  []byte
+	truncated bool
+}
+
+func newWireCapture(limit int64) *wireCapture {
+	if limit <= 0 {
+		limit = DefaultRawCaptureLimit
+	}
+	return &wireCapture{limit: limit}
+}
+
+// add appends data to one direction of the capture, up to the limit
+func (wc *wireCapture) add(buf *[]byte, data []byte) {
+	wc.mutex.Lock()
+	defer wc.mutex.Unlock()
+
+	if room := wc.limit - int64(len(*buf)); int64(len(data)) > room {
+		data = data[:max(room, 0)]
+		wc.truncated = true
+	}
+	*buf = append(*buf, data...)
+}
+
+// result returns copies of the bytes sent and received so far
+func (wc *wireCapture) result() (sent, received []byte, truncated bool) {
+	wc.mutex.Lock()
+	defer wc.mutex.Unlock()
+	return append([]byte(nil), wc.sent...), append([]byte(nil), wc.received...), wc.truncated
+}
+
+// transport opens the connections of a captured request. Each exchange gets a
+// new HTTP/1.1 connection straight to the upstream, so the recorded bytes are
+// the ones the upstream sees.
+func (wc *wireCapture) transport(dialer *upstreamDialer) *http.Transport {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DisableKeepAlives = true
+	transport.ForceAttemptHTTP2 = false
+	transport.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		conn, err := dialer.DialContext(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+		return &wireConn{Conn: conn, capture: wc}, nil
+	}
+	transport.DialTLSContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		host, _, err := net.SplitHostPort(addr)
+		if err != nil {
+			return nil, err
+		}
+		conn, err := dialer.DialContext(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+		tlsConn := tls.Client(conn, &tls.Config{ServerName: host, NextProtos: []string{"http/1.1"}})
+		if err := tlsConn.HandshakeContext(ctx); err != nil {
+			if closeErr := conn.Close(); closeErr != nil {
+				slog.Warn("Error closing connection after failed TLS handshake", "error", closeErr)
+			}
+			return nil, fmt.Errorf("TLS handshake with %s failed: %v", host, err)
+		}
+		return &wireConn{Conn: tlsConn, capture: wc}, nil
+	}
+	return transport
+}
+
+// wireConn copies what is written to and read from a connection into a
+// wire capture
+type wireConn struct {
+	net.Conn
+	capture *wireCapture
+}
+
+func (c *wireConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	c.capture.add(&c.capture.received, b[:n])
+	return n, err
+}
+
+func (c *wireConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	c.capture.add(&c.capture.sent, b[:n])
+	return n, err
+}
+
+type wireCaptureKey struct{}
+
+// withWireCapture marks a request's upstream exchanges for raw capture
+func withWireCapture(req *http.Request, capture *wireCapture) *http.Request {
+	return req.WithContext(context.WithValue(req.Context(), wireCaptureKey{}, capture))
+}
+
+// wireCaptureFrom returns the raw capture a request is marked for, if any
+func wireCaptureFrom(ctx context.Context) *wireCapture {
+	capture, _ := ctx.Value(wireCaptureKey{}).(*wireCapture)
+	return capture
+}
+
+// wireCaptureTransport sends requests marked for raw capture over recorded
+// connections of their own, and every other request through base
+type wireCaptureTransport struct {
+	base   http.RoundTripper
+	dialer *upstreamDialer
+}
+
+func (t *wireCaptureTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	capture := wireCaptureFrom(req.Context())
+	if capture == nil {
+		base := t.base
+		if base == nil {
+			base = http.DefaultTransport
+		}
+		return base.RoundTrip(req)
+	}
+	return capture.transport(t.dialer).RoundTrip(req)
+}
+
+// rawCapture is the kept result of a wire capture
+type rawCapture struct {
+	id       string
+	sent     []byte
+	received []byte
+}
+
+// rawCaptureStore keeps the most recent raw captures by record ID
+type rawCaptureStore struct {
+	mutex    sync.Mutex
+	captures []rawCapture // Oldest first
+}
+
+// add keeps the bytes captured for a record, masked by the redaction, and
+// marks the record
+func (s *rawCaptureStore) add(record *RequestRecord, redaction Redaction) {
+	sent, received, truncated := record.rawWire.result()
+	sent, received = redaction.redactWire(sent), redaction.redactWire(received)
+	record.RawCapture, record.RawCaptureTruncated = true, truncated
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.captures = append(s.captures, rawCapture{id: record.ID, sent: sent, received: received})
+	if len(s.captures) > rawCaptureKeep {
+		s.captures = s.captures[len(s.captures)-rawCaptureKeep:]
+	}
+}
+
+// remove drops the raw capture of a record
+func (s *rawCaptureStore) remove(id string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.captures = slices.DeleteFunc(s.captures, func(capture rawCapture) bool { return capture.id == id })
+}
+
+func (s *rawCaptureStore) get(id string) (rawCapture, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for i := len(s.captures) - 1; i >= 0; i-- {
+		if s.captures[i].id == id {
+			return s.captures[i], true
+		}
+	}
+	return rawCapture{}, false
+}
+
+// rawCaptureFor returns a wire capture for requests to routes configured for
+// raw capture, or nil
+func (p *Proxy) rawCaptureFor(target *http.Request) *wireCapture {
+	config := p.currentConfig()
+	for _, route := range config.RawCaptureRoutes {
+		if route.Matches(target.URL) {
+			return newWireCapture(config.RawCaptureLimit)
+		}
+	}
+	return nil
+}
+
+// handleRawCapture serves the raw bytes captured for a record:
+// GET /requests/raw?id=<id>[&part=request|response]. Without a part, the
+// bytes sent upstream are followed by the bytes received.
+func (p *Proxy) handleRawCapture(w http.ResponseWriter, r *http.Request) {
+	// Add CORS headers
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, Cache-Control, Pragma, Expires")
+
+	// Handle preflight requests
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	id := r.URL.Query().Get("id")
+	if id == "" {
+		http.Error(w, "Missing id parameter", http.StatusBadRequest)
+		return
+	}
+	// Raw bytes leave with their record
+	capture, ok := p.rawCaptures.get(id)
+	if _, found := p.history.GetRecord(id); !ok || !found {
+		http.Error(w, "No raw capture for this record", http.StatusNotFound)
+		return
+	}
+
+	var data []byte
+	part := r.URL.Query().Get("part")
+	switch part {
+	case "":
+		part = "raw"
+		data = append(append(data, capture.sent...), capture.received...)
+	case RawPartRequest:
+		data = capture.sent
+	case RawPartResponse:
+		data = capture.received
+	default:
+		http.Error(w, fmt.Sprintf("Invalid part %q (expected request or response)", part), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.%s.http"`, id, part))
+	w.WriteHeader(http.StatusOK)
+	if _, err := w.Write(data); err != nil {
+		slog.Warn("Error writing raw capture response", "error", err)
+	}
+}
@@ -0,0 +1,92 @@
+//go:build unit
+
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRawCapture(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Upstream", "yes")
+		for _, chunk := range []string{"hello ", "world"} {
+			if _, err := w.Write([]byte(chunk)); err != nil {
+				t.Logf("Error writing response: %v", err)
+			}
+			w.(http.Flusher).Flush()
+		}
+	}))
+	defer upstream.Close()
+	host := strings.TrimPrefix(upstream.URL, "http://")
+
+	p := New(&Config{RawCaptureRoutes: []Route{ParseRoute(host + "/raw")}})
+	send := func(path string) RequestRecord {
+		req := httptest.NewRequest(http.MethodPost, upstream.URL+path, strings.NewReader("ping"))
+		req.Header.Set("X-Client", "kept")
+		rec := httptest.NewRecorder()
+		p.ServeHTTP(rec, req)
+		require.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, "hello world", rec.Body.String())
+		return p.history.GetRecords()[0]
+	}
+
+	record := send("/raw/echo")
+	assert.True(t, record.RawCapture)
+	assert.False(t, record.RawCaptureTruncated)
+	assert.Equal(t, "hello world", record.ResponseBody)
+
+	download := func(query string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		p.handleRawCapture(rec, httptest.NewRequest(http.MethodGet, "/requests/raw?"+query, nil))
+		return rec
+	}
+
+	rec := download("id=" + url.QueryEscape(record.ID) + "&part=request")
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "application/octet-stream", rec.Header().Get("Content-Type"))
+	sent := rec.Body.String()
+	assert.True(t, strings.HasPrefix(sent, "POST /raw/echo HTTP/1.1\r\nHost: "+host+"\r\n"), sent)
+	assert.Contains(t, sent, "\r\nX-Client: kept\r\n")
+	assert.Contains(t, sent, "\r\nConnection: close\r\n", "each exchange has a connection of its own")
+	assert.True(t, strings.HasSuffix(sent, "\r\n\r\n4\r\nping\r\n0\r\n\r\n"), sent)
+
+	rec = download("id=" + url.QueryEscape(record.ID) + "&part=response")
+	received := rec.Body.String()
+	assert.True(t, strings.HasPrefix(received, "HTTP/1.1 200 OK\r\n"), received)
+	assert.Contains(t, received, "Transfer-Encoding: chunked\r\n")
+	assert.True(t, strings.HasSuffix(received, "\r\n\r\n6\r\nhello \r\n5\r\nworld\r\n0\r\n\r\n"), received)
+
+	rec = download("id=" + url.QueryEscape(record.ID))
+	assert.Equal(t, sent+received, rec.Body.String())
+	assert.Equal(t, `attachment; filename="`+record.ID+`.raw.http"`, rec.Header().Get("Content-Disposition"))
+
+	assert.Equal(t, http.StatusBadRequest, download("id="+url.QueryEscape(record.ID)+"&part=both").Code)
+	assert.Equal(t, http.StatusBadRequest, download("").Code)
+
+	// Other routes are not captured
+	record = send("/plain")
+	assert.False(t, record.RawCapture)
+	assert.Equal(t, http.StatusNotFound, download("id="+url.QueryEscape(record.ID)).Code)
+
+	// Raw bytes leave with their record
+	p.history.Clear()
+	assert.Equal(t, http.StatusNotFound, download("id="+url.QueryEscape(record.ID)).Code)
+}
+
+func TestRawCaptureLimit(t *testing.T) {
+	capture := newWireCapture(4)
+	capture.add(&capture.sent, []byte("abc"))
+	capture.add(&capture.sent, []byte("def"))
+	capture.add(&capture.received, []byte("xy"))
+	sent, received, truncated := capture.result()
+	assert.Equal(t, "abcd", string(sent))
+	assert.Equal(t, "xy", string(received))
+	assert.True(t, truncated)
+}
@@ -0,0 +1,294 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RecordingHeader carries the tag of the recording a request belongs to,
+// e.g. set by a browser extension on one tester's traffic
+const RecordingHeader = "X-Netkit-Recording"
+
+// recordingLimit is how many records one recording keeps; later ones are
+// counted as dropped
+const recordingLimit = 10000
+
+// recordingNamePattern matches valid recording names
+var recordingNamePattern = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+// Recording collects the traffic of one browser or client into a HAR, a
+// focused alternative to exporting the whole history
+type Recording struct {
+	Name      string     `json:"name"`
+	Tag       string     `json:"tag,omitempty"`       // X-Netkit-Recording value requests must carry
+	ClientIP  string     `json:"client_ip,omitempty"` // Client address requests must come from
+	StartedAt time.Time  `json:"started_at"`
+	StoppedAt *time.Time `json:"stopped_at,omitempty"`
+	Count     int        `json:"count"`             // Records collected
+	Dropped   int        `json:"dropped,omitempty"` // Records past the limit, not collected
+}
+
+// recording is a Recording with its records
+type recording struct {
+	Recording
+	records []RequestRecord
+}
+
+// matches reports whether a record is part of an active recording
+func (r *recording) matches(record RequestRecord) bool {
+	if r.StoppedAt != nil {
+		return false
+	}
+	if r.Tag != "" && recordHeader(record.RequestHeaders, RecordingHeader) != r.Tag {
+		return false
+	}
+	return r.ClientIP == "" || r.ClientIP == record.ClientAddr
+}
+
+// recordingStore keeps recordings in memory until they are deleted
+type recordingStore struct {
+	mutex      sync.Mutex
+	recordings map[string]*recording
+}
+
+func newRecordingStore() *recordingStore {
+	return &recordingStore{recordings: make(map[string]*recording)}
+}
+
+// errRecordingExists is returned when starting a recording under a name in use
+var errRecordingExists = errors.New("a recording with this name already exists")
+
+// Start begins a recording. Without a tag or client IP, requests are matched
+// by a tag of the recording's name.
+func (s *recordingStore) Start(name, tag, clientIP string) (Recording, error) {
+	if !recordingNamePattern.MatchString(name) {
+		return Recording{}, fmt.Errorf("invalid name %q: use 1-64 letters, digits, '.', '_', or '-'", name)
+	}
+	if clientIP != "" && net.ParseIP(clientIP) == nil {
+		return Recording{}, fmt.Errorf("invalid client_ip %q", clientIP)
+	}
+	if tag == "" && clientIP == "" {
+		tag = name
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if _, ok := s.recordings[name]; ok {
+		return Recording{}, errRecordingExists
+	}
+	r := &recording{Recording: Recording{Name: name, Tag: tag, ClientIP: clientIP, StartedAt: time.Now().UTC()}}
+	s.recordings[name] = r
+	return r.Recording, nil
+}
+
+// matching returns the names of the active recordings a record belongs to
+func (s *recordingStore) matching(record RequestRecord) []string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	var names []string
+	for name, r := range s.recordings {
+		if r.matches(record) {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// add collects a record into the named recordings that are still active
+func (s *recordingStore) add(names []string, record RequestRecord) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for _, name := range names {
+		r, ok := s.recordings[name]
+		if !ok || r.StoppedAt != nil {
+			continue
+		}
+		if len(r.records) >= recordingLimit {
+			r.Dropped++
+			continue
+		}
+		r.records = append(r.records, record)
+		r.Count++
+	}
+}
+
+// Get returns a recording and a copy of its records
+func (s *recordingStore) Get(name string) (Recording, []RequestRecord, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	r, ok := s.recordings[name]
+	if !ok {
+		return Recording{}, nil, false
+	}
+	return r.Recording, append([]RequestRecord(nil), r.records...), true
+}
+
+// List returns every recording, newest first
+func (s *recordingStore) List() []Recording {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	recordings := make([]Recording, 0, len(s.recordings))
+	for _, r := range s.recordings {
+		recordings = append(recordings, r.Recording)
+	}
+	sort.Slice(recordings, func(i, j int) bool { return recordings[i].StartedAt.After(recordings[j].StartedAt) })
+	return recordings
+}
+
+// Stop ends a recording, reporting whether it was still active
+func (s *recordingStore) Stop(name string) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	r, ok := s.recordings[name]
+	if !ok || r.StoppedAt != nil {
+		return false
+	}
+	now := time.Now().UTC()
+	r.StoppedAt = &now
+	return true
+}
+
+// Delete removes a recording, reporting whether it existed
+func (s *recordingStore) Delete(name string) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	_, ok := s.recordings[name]
+	delete(s.recordings, name)
+	return ok
+}
+
+// handleRecordings lists and starts recordings
+func (p *Proxy) handleRecordings(w http.ResponseWriter, r *http.Request) {
+	// Add CORS headers
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, Cache-Control, Pragma, Expires")
+
+	// Handle preflight requests
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		writeRecordingsResponse(w, http.StatusOK, map[string]interface{}{"recordings": p.recordings.List()})
+
+	case http.MethodPost:
+		var request struct {
+			Name     string `json:"name"`
+			Tag      string `json:"tag"`
+			ClientIP string `json:"client_ip"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil && !errors.Is(err, io.EOF) {
+			http.Error(w, "Invalid recording JSON", http.StatusBadRequest)
+			return
+		}
+		recording, err := p.recordings.Start(request.Name, request.Tag, request.ClientIP)
+		if errors.Is(err, errRecordingExists) {
+			http.Error(w, "Recording already exists", http.StatusConflict)
+			return
+		}
+		if err != nil {
+			http.Error(w, "Invalid recording: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		writeRecordingsResponse(w, http.StatusCreated, recording)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleRecording serves /recordings/{name}, which reports or deletes a
+// recording, /recordings/{name}/stop, which ends it and responds with its
+// HAR, and /recordings/{name}/har, which downloads the HAR
+func (p *Proxy) handleRecording(w http.ResponseWriter, r *http.Request) {
+	// Add CORS headers
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "GET, POST, DELETE, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, Cache-Control, Pragma, Expires")
+
+	// Handle preflight requests
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	name, rest, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/recordings/"), "/")
+	recording, _, ok := p.recordings.Get(name)
+	if !ok || (rest != "" && rest != "stop" && rest != "har") {
+		http.Error(w, "Recording not found", http.StatusNotFound)
+		return
+	}
+
+	switch {
+	case rest == "" && r.Method == http.MethodGet:
+		writeRecordingsResponse(w, http.StatusOK, recording)
+
+	case rest == "" && r.Method == http.MethodDelete:
+		p.recordings.Delete(name)
+		writeRecordingsResponse(w, http.StatusOK, map[string]interface{}{"success": true, "message": "Recording deleted"})
+
+	case rest == "stop" && r.Method == http.MethodPost:
+		if !p.recordings.Stop(name) {
+			http.Error(w, "Recording already stopped", http.StatusConflict)
+			return
+		}
+		p.writeRecordingHAR(w, name)
+
+	case rest == "har" && r.Method == http.MethodGet:
+		p.writeRecordingHAR(w, name)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// writeRecordingHAR responds with a recording's records as a HAR download,
+// oldest first like a browser's
+func (p *Proxy) writeRecordingHAR(w http.ResponseWriter, name string) {
+	recording, records, ok := p.recordings.Get(name)
+	if !ok {
+		http.Error(w, "Recording not found", http.StatusNotFound)
+		return
+	}
+	var buf bytes.Buffer
+	if err := WriteHAR(&buf, records); err != nil {
+		http.Error(w, "Failed to export recording", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Disposition", `attachment; filename="netkit-`+recording.Name+`.har"`)
+	w.WriteHeader(http.StatusOK)
+	if _, err := w.Write(buf.Bytes()); err != nil {
+		slog.Warn("Error writing recording response", "error", err)
+	}
+}
+
+// writeRecordingsResponse writes a recordings endpoint response as JSON
+func writeRecordingsResponse(w http.ResponseWriter, status int, response interface{}) {
+	data, err := json.Marshal(response)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to encode recording: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if _, err := w.Write(data); err != nil {
+		slog.Warn("Error writing recordings response", "error", err)
+	}
+}
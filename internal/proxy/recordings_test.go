@@ -0,0 +1,101 @@
+//go:build unit
+
+package proxy
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// recordingRequest sends a request to the admin API
+func recordingRequest(p *Proxy, method, target, body string) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	p.adminServer.Handler.ServeHTTP(rec, httptest.NewRequest(method, target, strings.NewReader(body)))
+	return rec
+}
+
+func TestRecordingCollectsTaggedTraffic(t *testing.T) {
+	var forwarded []string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		forwarded = append(forwarded, r.Header.Get(RecordingHeader))
+		_, _ = w.Write([]byte("ok"))
+	}))
+	defer upstream.Close()
+
+	p := New(&Config{Sampler: HeadSampler{Rate: 0}})
+	rec := recordingRequest(p, http.MethodPost, "/recordings", `{"name": "checkout-bug"}`)
+	require.Equal(t, http.StatusCreated, rec.Code)
+	var started Recording
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &started))
+	assert.Equal(t, "checkout-bug", started.Tag, "the name is the tag by default")
+
+	assert.Equal(t, http.StatusConflict, recordingRequest(p, http.MethodPost, "/recordings", `{"name": "checkout-bug"}`).Code)
+	assert.Equal(t, http.StatusBadRequest, recordingRequest(p, http.MethodPost, "/recordings", `{"name": "a b"}`).Code)
+	assert.Equal(t, http.StatusBadRequest, recordingRequest(p, http.MethodPost, "/recordings", `{"name": "ip", "client_ip": "nope"}`).Code)
+
+	for _, tag := range []string{"checkout-bug", "", "other", "checkout-bug"} {
+		req := httptest.NewRequest(http.MethodGet, upstream.URL+"/cart", nil)
+		if tag != "" {
+			req.Header.Set(RecordingHeader, tag)
+		}
+		p.ServeHTTP(httptest.NewRecorder(), req)
+	}
+	assert.Equal(t, []string{"", "", "", ""}, forwarded, "the tag is not sent upstream")
+	assert.Len(t, p.history.GetRecords(), 2, "recorded requests skip sampling")
+
+	rec = recordingRequest(p, http.MethodPost, "/recordings/checkout-bug/stop", "")
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, `attachment; filename="netkit-checkout-bug.har"`, rec.Header().Get("Content-Disposition"))
+	var har harDocument
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &har))
+	assert.Len(t, har.Log.Entries, 2)
+
+	// Stopped recordings collect nothing more but stay downloadable
+	req := httptest.NewRequest(http.MethodGet, upstream.URL+"/cart", nil)
+	req.Header.Set(RecordingHeader, "checkout-bug")
+	p.ServeHTTP(httptest.NewRecorder(), req)
+	assert.Equal(t, http.StatusConflict, recordingRequest(p, http.MethodPost, "/recordings/checkout-bug/stop", "").Code)
+	rec = recordingRequest(p, http.MethodGet, "/recordings/checkout-bug/har", "")
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &har))
+	assert.Len(t, har.Log.Entries, 2)
+
+	rec = recordingRequest(p, http.MethodGet, "/recordings/checkout-bug", "")
+	require.Equal(t, http.StatusOK, rec.Code)
+	var stopped Recording
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &stopped))
+	assert.Equal(t, 2, stopped.Count)
+	assert.NotNil(t, stopped.StoppedAt)
+
+	assert.Equal(t, http.StatusOK, recordingRequest(p, http.MethodDelete, "/recordings/checkout-bug", "").Code)
+	assert.Equal(t, http.StatusNotFound, recordingRequest(p, http.MethodGet, "/recordings/checkout-bug/har", "").Code)
+}
+
+func TestRecordingByClientIP(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer upstream.Close()
+
+	p := New(&Config{})
+	require.Equal(t, http.StatusCreated, recordingRequest(p, http.MethodPost, "/recordings", `{"name": "tablet", "client_ip": "10.0.0.7"}`).Code)
+
+	for _, addr := range []string{"10.0.0.7:50000", "10.0.0.8:50000", "10.0.0.7:50001"} {
+		req := httptest.NewRequest(http.MethodGet, upstream.URL+"/", nil)
+		req.RemoteAddr = addr
+		p.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	recording, records, ok := p.recordings.Get("tablet")
+	require.True(t, ok)
+	assert.Empty(t, recording.Tag)
+	assert.Equal(t, 2, recording.Count)
+	for _, record := range records {
+		assert.Equal(t, "10.0.0.7", record.ClientAddr)
+	}
+	assert.Len(t, p.history.GetRecords(), 3, "history keeps every request as usual")
+}
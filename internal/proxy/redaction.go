@@ -0,0 +1,234 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// redactedValue replaces header values and body secrets masked by a redaction
+const redactedValue = "[REDACTED]"
+
+// DefaultRedactHeaders are the headers masked unless --redact-defaults=false
+var DefaultRedactHeaders = []string{"Authorization", "Proxy-Authorization", "Cookie", "Set-Cookie"}
+
+// DefaultRedactPatterns find tokens and keys in bodies unless
+// --redact-defaults=false. Patterns with a group mask only the first group.
+var DefaultRedactPatterns = []string{
+	// JSON fields named like secrets, e.g. "password": "..." or "access_token": "..."
+	`(?i)"[a-z0-9_.-]*(?:password|passwd|secret|token|api[_-]?key|private[_-]?key|credential)[a-z0-9_.-]*"\s*:\s*"((?:[^"\\]|\\.)*)"`,
+	// Form fields named like secrets, e.g. password=...&client_secret=...
+	`(?i)(?:^|[&?\s"])[a-z0-9_.-]*(?:password|passwd|secret|token|api[_-]?key|private[_-]?key)[a-z0-9_.-]*=([^&\s"]*)`,
+	// Bearer tokens in text
+	`(?i)\bbearer\s+([a-z0-9._~+/-]+=*)`,
+	// JWTs
+	`\beyJ[A-Za-z0-9_-]+\.eyJ[A-Za-z0-9_-]+\.[A-Za-z0-9_-]+`,
+	// AWS access key IDs
+	`\b(?:AKIA|ASIA)[0-9A-Z]{16}\b`,
+	// PEM private keys
+	`-----BEGIN [A-Z ]*PRIVATE KEY-----[\s\S]*?-----END [A-Z ]*PRIVATE KEY-----`,
+}
+
+// Redaction masks credentials in records before they are stored, so history,
+// sinks, and exports don't leak them
+type Redaction struct {
+	Headers  []string         // Header names whose values are masked
+	Patterns []*regexp.Regexp // Matches masked in bodies; only the first group when the pattern has one
+}
+
+// ParseRedactPattern compiles a body redaction pattern
+func ParseRedactPattern(expr string) (*regexp.Regexp, error) {
+	pattern, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid pattern %q: %v", expr, err)
+	}
+	if pattern.MatchString("") {
+		return nil, fmt.Errorf("invalid pattern %q: it matches empty text", expr)
+	}
+	return pattern, nil
+}
+
+// DefaultRedaction returns the redaction of DefaultRedactHeaders and
+// DefaultRedactPatterns
+func DefaultRedaction() Redaction {
+	redaction := Redaction{Headers: append([]string(nil), DefaultRedactHeaders...)}
+	for _, expr := range DefaultRedactPatterns {
+		redaction.Patterns = append(redaction.Patterns, regexp.MustCompile(expr))
+	}
+	return redaction
+}
+
+// enabled reports whether the redaction masks anything
+func (rd Redaction) enabled() bool {
+	return len(rd.Headers) > 0 || len(rd.Patterns) > 0
+}
+
+// apply masks the redacted headers and body matches of a record. Header maps
+// are replaced rather than changed, since copies of the record may share them.
+func (rd Redaction) apply(record *RequestRecord) {
+	if !rd.enabled() {
+		return
+	}
+	record.RequestHeaders = rd.redactHeaders(record.RequestHeaders)
+	record.ResponseHeaders = rd.redactHeaders(record.ResponseHeaders)
+	if len(rd.Patterns) == 0 {
+		return
+	}
+	rd.redactQuery(record)
+	record.RequestBody = rd.redactText(record.RequestBody)
+	record.ResponseBody = rd.redactText(record.ResponseBody)
+	record.RequestBodyDecoded = rd.redactJSON(record.RequestBodyDecoded)
+	record.ResponseBodyDecoded = rd.redactJSON(record.ResponseBodyDecoded)
+}
+
+// redactQuery masks pattern matches in the query string of a record's URL,
+// such as access_token=... or api_key=..., and in its URL components
+func (rd Redaction) redactQuery(record *RequestRecord) {
+	u, err := url.Parse(record.URL)
+	if err != nil || u.RawQuery == "" {
+		return
+	}
+	query := rd.redactText(u.RawQuery)
+	if query == u.RawQuery {
+		return
+	}
+	u.RawQuery = query
+	record.URL = u.String()
+	record.URLComponents = ParseURLComponents(record.URL)
+}
+
+// redactWire masks the redacted headers and pattern matches in the raw bytes
+// of an upstream exchange. Header lines are masked wherever they appear, since
+// a capture holds every exchange of a followed redirect. Masks change lengths,
+// so Content-Length and chunk sizes no longer match masked bodies.
+func (rd Redaction) redactWire(data []byte) []byte {
+	if !rd.enabled() || len(data) == 0 {
+		return data
+	}
+	if len(rd.Headers) > 0 {
+		lines := bytes.SplitAfter(data, []byte("\n"))
+		for i, line := range lines {
+			name, value, ok := bytes.Cut(line, []byte(":"))
+			if !ok || !rd.redactsHeader(string(name)) {
+				continue
+			}
+			content := bytes.TrimRight(value, "\r\n")
+			masked := redactHeaderValue(string(name), strings.TrimSpace(string(content)))
+			lines[i] = slices.Concat(name, []byte(": "+masked), value[len(content):])
+		}
+		data = bytes.Join(lines, nil)
+	}
+	if len(rd.Patterns) == 0 {
+		return data
+	}
+	return []byte(rd.redactText(string(data)))
+}
+
+// redactsHeader reports whether the values of a header are masked
+func (rd Redaction) redactsHeader(name string) bool {
+	for _, header := range rd.Headers {
+		if strings.EqualFold(name, header) {
+			return true
+		}
+	}
+	return false
+}
+
+// redactHeaders returns headers with the values of redacted ones masked
+func (rd Redaction) redactHeaders(headers map[string]string) map[string]string {
+	if len(headers) == 0 || len(rd.Headers) == 0 {
+		return headers
+	}
+	redacted := make(map[string]string, len(headers))
+	for name, value := range headers {
+		redacted[name] = value
+		if rd.redactsHeader(name) {
+			redacted[name] = redactHeaderValue(name, value)
+		}
+	}
+	return redacted
+}
+
+// redactHeaderValue masks a header value, keeping the Authorization scheme
+// and cookie names and attributes so reports and session grouping still see
+// them
+func redactHeaderValue(name, value string) string {
+	switch http.CanonicalHeaderKey(name) {
+	case "Authorization", "Proxy-Authorization":
+		scheme, _, ok := strings.Cut(strings.TrimSpace(value), " ")
+		if ok && authSchemePattern.MatchString(scheme) {
+			return scheme + " " + redactedValue
+		}
+	case "Cookie":
+		cookies := strings.Split(value, ";")
+		for i, cookie := range cookies {
+			cookieName, _, ok := strings.Cut(cookie, "=")
+			if !ok {
+				cookies[i] = redactedValue
+				continue
+			}
+			cookies[i] = cookieName + "=" + redactedValue
+		}
+		return strings.Join(cookies, ";")
+	case "Set-Cookie":
+		cookie, attributes, hasAttributes := strings.Cut(value, ";")
+		if cookieName, _, ok := strings.Cut(cookie, "="); ok {
+			if hasAttributes {
+				return cookieName + "=" + redactedValue + ";" + attributes
+			}
+			return cookieName + "=" + redactedValue
+		}
+	}
+	return redactedValue
+}
+
+// redactText masks every pattern match in text
+func (rd Redaction) redactText(text string) string {
+	if text == "" {
+		return text
+	}
+	for _, pattern := range rd.Patterns {
+		matches := pattern.FindAllStringSubmatchIndex(text, -1)
+		if matches == nil {
+			continue
+		}
+		var b strings.Builder
+		last := 0
+		for _, match := range matches {
+			start, end := match[0], match[1]
+			if len(match) >= 4 && match[2] >= 0 {
+				start, end = match[2], match[3]
+			}
+			if start < last || start == end {
+				continue
+			}
+			b.WriteString(text[last:start])
+			b.WriteString(redactedValue)
+			last = end
+		}
+		b.WriteString(text[last:])
+		text = b.String()
+	}
+	return text
+}
+
+// redactJSON masks pattern matches in a decoded body, dropping it if masking
+// left it invalid
+func (rd Redaction) redactJSON(data json.RawMessage) json.RawMessage {
+	if len(data) == 0 {
+		return data
+	}
+	redacted := rd.redactText(string(data))
+	if redacted == string(data) {
+		return data
+	}
+	if !json.Valid([]byte(redacted)) {
+		return nil
+	}
+	return json.RawMessage(redacted)
+}
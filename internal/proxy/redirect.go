@@ -0,0 +1,135 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Redirect policies
+const (
+	RedirectFollow = "follow" // Follow redirects upstream and return the final response (default)
+	RedirectNone   = "none"   // Pass redirect responses through to the client
+	RedirectRecord = "record" // Follow redirects and record every hop as a linked record chain
+)
+
+// defaultMaxRedirects matches the net/http client default
+const defaultMaxRedirects = 10
+
+// ValidateRedirectPolicy checks that a redirect policy name is known
+func ValidateRedirectPolicy(policy string) error {
+	switch policy {
+	case "", RedirectFollow, RedirectNone, RedirectRecord:
+		return nil
+	}
+	return fmt.Errorf("unknown redirect policy %q (expected follow, none, or record)", policy)
+}
+
+// redirectHop is a redirect response observed while following redirects upstream
+type redirectHop struct {
+	request  *http.Request
+	response *http.Response
+	end      time.Time
+}
+
+// redirectHops collects the hops of a single proxied request
+type redirectHops struct {
+	mutex sync.Mutex
+	hops  []redirectHop
+}
+
+type redirectHopsKey struct{}
+
+// checkRedirect enforces the configured redirect policy. Once the redirect limit
+// is reached the last redirect response is returned to the client as-is.
+func (p *Proxy) checkRedirect(req *http.Request, via []*http.Request) error {
+	if p.currentConfig().RedirectPolicy == RedirectNone {
+		return http.ErrUseLastResponse
+	}
+
+	if hops, ok := req.Context().Value(redirectHopsKey{}).(*redirectHops); ok {
+		hops.mutex.Lock()
+		hops.hops = append(hops.hops, redirectHop{
+			request:  via[len(via)-1],
+			response: req.Response,
+			end:      time.Now(),
+		})
+		hops.mutex.Unlock()
+	}
+
+	maxRedirects := p.currentConfig().MaxRedirects
+	if maxRedirects <= 0 {
+		maxRedirects = defaultMaxRedirects
+	}
+	if len(via) > maxRedirects {
+		return http.ErrUseLastResponse
+	}
+	return nil
+}
+
+// trackRedirects attaches a hop collector to the request when every hop should be recorded
+func (p *Proxy) trackRedirects(req *http.Request) (*http.Request, *redirectHops) {
+	if p.currentConfig().RedirectPolicy != RedirectRecord {
+		return req, nil
+	}
+	hops := &redirectHops{}
+	return req.WithContext(context.WithValue(req.Context(), redirectHopsKey{}, hops)), hops
+}
+
+// recordRedirectHops stores each followed redirect as its own record, linked in
+// order to the final record. The final record is updated to point at the URL that
+// produced the final response.
+func (p *Proxy) recordRedirectHops(hops *redirectHops, final *RequestRecord, resp *http.Response) {
+	if hops == nil {
+		return
+	}
+	hops.mutex.Lock()
+	defer hops.mutex.Unlock()
+
+	// With the limit reached the last collected hop is the response handed to the client
+	followed := hops.hops
+	if len(followed) > 0 && followed[len(followed)-1].response == resp {
+		followed = followed[:len(followed)-1]
+	}
+	if len(followed) == 0 {
+		return
+	}
+
+	start := final.UpstreamStartTime
+	prevID := ""
+	records := make([]RequestRecord, len(followed))
+	for i, hop := range followed {
+		records[i] = RequestRecord{
+			ID:                p.newRequestID(),
+			Timestamp:         start,
+			Method:            hop.request.Method,
+			URL:               hop.request.URL.String(),
+			RequestHeaders:    convertHeaders(hop.request.Header),
+			ResponseStatus:    hop.response.StatusCode,
+			ResponseHeaders:   convertHeaders(hop.response.Header),
+			ProxyStartTime:    start,
+			UpstreamStartTime: start,
+			UpstreamEndTime:   hop.end,
+			ProxyEndTime:      hop.end,
+			Success:           true,
+			RedirectHop:       i + 1,
+			RedirectPrevID:    prevID,
+			RunID:             final.RunID,
+		}
+		if i > 0 {
+			records[i-1].RedirectNextID = records[i].ID
+		}
+		prevID = records[i].ID
+		start = hop.end
+	}
+	records[len(records)-1].RedirectNextID = final.ID
+
+	for _, record := range records {
+		p.recordRequest(record)
+	}
+	final.RedirectPrevID = prevID
+	final.RedirectHop = len(records) + 1
+	final.URL = resp.Request.URL.String()
+}
@@ -0,0 +1,138 @@
+//go:build unit
+
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newRedirectUpstream redirects /hop/N to /hop/N-1 until /hop/0, which answers 200
+func newRedirectUpstream(t *testing.T) *httptest.Server {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n, err := strconv.Atoi(r.URL.Path[len("/hop/"):])
+		require.NoError(t, err)
+		if n > 0 {
+			http.Redirect(w, r, "/hop/"+strconv.Itoa(n-1), http.StatusFound)
+			return
+		}
+		if _, err := w.Write([]byte("arrived")); err != nil {
+			t.Logf("Error writing response: %v", err)
+		}
+	}))
+	t.Cleanup(upstream.Close)
+	return upstream
+}
+
+func TestRedirectFollow(t *testing.T) {
+	upstream := newRedirectUpstream(t)
+	p := New(&Config{})
+
+	rec := httptest.NewRecorder()
+	p.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, upstream.URL+"/hop/2", nil))
+
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "arrived", rec.Body.String())
+	records := p.history.GetRecords()
+	require.Len(t, records, 1)
+	assert.Equal(t, upstream.URL+"/hop/2", records[0].URL)
+	assert.Zero(t, records[0].RedirectHop)
+}
+
+func TestRedirectNone(t *testing.T) {
+	upstream := newRedirectUpstream(t)
+	p := New(&Config{RedirectPolicy: RedirectNone})
+
+	rec := httptest.NewRecorder()
+	p.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, upstream.URL+"/hop/2", nil))
+
+	assert.Equal(t, http.StatusFound, rec.Code)
+	assert.Equal(t, "/hop/1", rec.Header().Get("Location"))
+	records := p.history.GetRecords()
+	require.Len(t, records, 1)
+	assert.Equal(t, http.StatusFound, records[0].ResponseStatus)
+}
+
+func TestRedirectMaxRedirects(t *testing.T) {
+	upstream := newRedirectUpstream(t)
+	p := New(&Config{MaxRedirects: 1})
+
+	rec := httptest.NewRecorder()
+	p.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, upstream.URL+"/hop/3", nil))
+
+	// One redirect is followed, the next is handed to the client
+	assert.Equal(t, http.StatusFound, rec.Code)
+	assert.Equal(t, "/hop/1", rec.Header().Get("Location"))
+}
+
+func TestRedirectRecordChain(t *testing.T) {
+	upstream := newRedirectUpstream(t)
+	p := New(&Config{RedirectPolicy: RedirectRecord})
+
+	rec := httptest.NewRecorder()
+	p.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, upstream.URL+"/hop/2", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	// Most recent first: final response, then the hops in reverse
+	records := p.history.GetRecords()
+	require.Len(t, records, 3)
+	first, second, final := records[2], records[1], records[0]
+
+	assert.Equal(t, upstream.URL+"/hop/2", first.URL)
+	assert.Equal(t, http.StatusFound, first.ResponseStatus)
+	assert.Equal(t, "/hop/1", first.ResponseHeaders["Location"])
+	assert.Equal(t, 1, first.RedirectHop)
+	assert.Empty(t, first.RedirectPrevID)
+	assert.Equal(t, second.ID, first.RedirectNextID)
+
+	assert.Equal(t, upstream.URL+"/hop/1", second.URL)
+	assert.Equal(t, 2, second.RedirectHop)
+	assert.Equal(t, first.ID, second.RedirectPrevID)
+	assert.Equal(t, final.ID, second.RedirectNextID)
+
+	assert.Equal(t, upstream.URL+"/hop/0", final.URL)
+	assert.Equal(t, http.StatusOK, final.ResponseStatus)
+	assert.Equal(t, "arrived", final.ResponseBody)
+	assert.Equal(t, 3, final.RedirectHop)
+	assert.Equal(t, second.ID, final.RedirectPrevID)
+	assert.Empty(t, final.RedirectNextID)
+}
+
+func TestRedirectRecordStopsAtLimit(t *testing.T) {
+	upstream := newRedirectUpstream(t)
+	p := New(&Config{RedirectPolicy: RedirectRecord, MaxRedirects: 1})
+
+	rec := httptest.NewRecorder()
+	p.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, upstream.URL+"/hop/3", nil))
+	assert.Equal(t, http.StatusFound, rec.Code)
+
+	records := p.history.GetRecords()
+	require.Len(t, records, 2)
+	assert.Equal(t, upstream.URL+"/hop/2", records[0].URL)
+	assert.Equal(t, http.StatusFound, records[0].ResponseStatus)
+	assert.Equal(t, records[1].ID, records[0].RedirectPrevID)
+}
+
+func TestRedirectNoRedirectsRecorded(t *testing.T) {
+	upstream := newRedirectUpstream(t)
+	p := New(&Config{RedirectPolicy: RedirectRecord})
+
+	rec := httptest.NewRecorder()
+	p.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, upstream.URL+"/hop/0", nil))
+
+	records := p.history.GetRecords()
+	require.Len(t, records, 1)
+	assert.Zero(t, records[0].RedirectHop)
+}
+
+func TestValidateRedirectPolicy(t *testing.T) {
+	for _, policy := range []string{"", RedirectFollow, RedirectNone, RedirectRecord} {
+		assert.NoError(t, ValidateRedirectPolicy(policy))
+	}
+	assert.Error(t, ValidateRedirectPolicy("sometimes"))
+}
@@ -0,0 +1,273 @@
+package proxy
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"reflect"
+)
+
+// listenerSettings are ports whose listeners are rebound on reload
+var listenerSettings = map[string]bool{
+	"Port":          true,
+	"AdminPort":     true,
+	"DashboardPort": true,
+}
+
+// restartSettings are set up once by New, so reloads keep their current
+// values and report that a restart is needed
+var restartSettings = map[string]bool{
+	"Dashboard":          true,
+	"LogFormat":          true,
+	"LogFile":            true,
+	"LogRotation":        true,
+	"LogSyslog":          true,
+	"LogSyslogFacility":  true,
+	"LogSyslogTag":       true,
+	"ExtractRules":       true,
+	"ExtractDir":         true,
+	"ExtractMaxSize":     true,
+	"DashboardDir":       true,
+	"ConditionalGET":     true,
+	"CacheSize":          true,
+	"PreflightCache":     true,
+	"Pprof":              true,
+	"GRPCWeb":            true,
+	"ProtocolSniffing":   true,
+	"TLSConfig":          true,
+	"StrictParsing":      true,
+	"StrictHeaderBytes":  true,
+	"HeaderCaseRoutes":   true,
+	"InboxPath":          true,
+	"ReportSchedule":     true,
+	"AdvisoryHeaders":    true,
+	"FiltersFile":        true,
+	"TokensFile":         true,
+	"CapturesDir":        true,
+	"RulesDir":           true,
+	"HistoryFile":        true,
+	"HistoryKey":         true,
+	"HistoryBackend":     true,
+	"HistoryPath":        true,
+	"RedisURL":           true,
+	"HistoryTTL":         true,
+	"ConcurrencyLimit":   true,
+	"Prewarm":            true,
+	"PrewarmConnections": true,
+	"TailSampling":       true,
+	"TeeRules":           true,
+	"DNSResolvers":       true,
+	"DNSFallback":        true,
+	"IPFamilyRules":      true,
+	"HappyEyeballsDelay": true,
+	"SourceRules":        true,
+	"SSHJumps":           true,
+	"SSHKeyFile":         true,
+	"SSHKnownHostsFile":  true,
+	"StatsDAddr":         true,
+	"StatsDPrefix":       true,
+	"StatsDFlavor":       true,
+	"StatsDTags":         true,
+	"TracingEndpoint":    true,
+	"TracingHeaders":     true,
+	"TracingServiceName": true,
+}
+
+// ConfigDiff reports what a reload changed, by Config field name
+type ConfigDiff struct {
+	Applied         []string `json:"applied"`          // Settings now in effect for new requests
+	Rebound         []string `json:"rebound"`          // Ports now listened on, with in-flight requests finishing on the old ones
+	RestartRequired []string `json:"restart_required"` // Changed settings kept at their current values until a restart
+	Errors          []string `json:"errors,omitempty"` // Ports that could not be bound, which keep their current listeners
+}
+
+// DiffConfig compares two configurations. Settings are compared by value, so
+// reloading an unchanged file reports no changes.
+func DiffConfig(current, next *Config) ConfigDiff {
+	diff := ConfigDiff{Applied: []string{}, Rebound: []string{}, RestartRequired: []string{}}
+	currentValue, nextValue := reflect.ValueOf(current).Elem(), reflect.ValueOf(next).Elem()
+	for i := 0; i < currentValue.NumField(); i++ {
+		name := currentValue.Type().Field(i).Name
+		if name == "ConfigLoader" || reflect.DeepEqual(currentValue.Field(i).Interface(), nextValue.Field(i).Interface()) {
+			continue
+		}
+		switch {
+		case restartSettings[name]:
+			diff.RestartRequired = append(diff.RestartRequired, name)
+		case listenerSettings[name]:
+			diff.Rebound = append(diff.Rebound, name)
+		default:
+			diff.Applied = append(diff.Applied, name)
+		}
+	}
+	return diff
+}
+
+// currentConfig returns the configuration in effect. Read it once per
+// request where settings must agree with each other, since a reload may
+// swap it at any time.
+func (p *Proxy) currentConfig() *Config {
+	return p.config.Load()
+}
+
+// Reload swaps in a new configuration without dropping in-flight requests.
+// Routing, history, and other per-request settings apply to new requests,
+// listeners are rebound only when their port changed, and settings that need
+// a restart keep their current values.
+func (p *Proxy) Reload(next *Config) ConfigDiff {
+	p.reloadMutex.Lock()
+	defer p.reloadMutex.Unlock()
+
+	current := p.currentConfig()
+	merged := *next
+	merged.ConfigLoader = current.ConfigLoader
+	diff := DiffConfig(current, &merged)
+
+	currentValue, mergedValue := reflect.ValueOf(current).Elem(), reflect.ValueOf(&merged).Elem()
+	for _, name := range diff.RestartRequired {
+		mergedValue.FieldByName(name).Set(currentValue.FieldByName(name))
+	}
+
+	// Move listeners once the server is running; before that Start binds the new ports
+	if p.started {
+		rebound := diff.Rebound[:0]
+		for _, name := range diff.Rebound {
+			server, serverName := p.serverFor(name)
+			if server == nil {
+				continue
+			}
+			if err := p.bind(server, serverName, int(mergedValue.FieldByName(name).Int())); err != nil {
+				mergedValue.FieldByName(name).Set(currentValue.FieldByName(name))
+				diff.Errors = append(diff.Errors, fmt.Sprintf("%s: %v", name, err))
+				continue
+			}
+			rebound = append(rebound, name)
+		}
+		diff.Rebound = rebound
+	}
+
+	historySize := merged.HistorySize
+	if historySize <= 0 {
+		historySize = 1000
+	}
+	p.history.SetMaxSize(historySize)
+	p.sinks.configure(merged.Sinks, merged.SinkDeadLetterDir)
+	p.config.Store(&merged)
+
+	if level, err := ParseLogLevel(merged.LogLevel); err == nil {
+		logLevel.Set(level)
+	}
+
+	slog.Info("Config reloaded", "applied", diff.Applied, "rebound", diff.Rebound, "restart_required", diff.RestartRequired)
+	for _, err := range diff.Errors {
+		slog.Error("Config reload could not rebind a listener", "error", err)
+	}
+	return diff
+}
+
+// ReloadConfig rebuilds the configuration with Config.ConfigLoader and
+// reloads it, keeping the current configuration if it cannot be loaded
+func (p *Proxy) ReloadConfig() (ConfigDiff, error) {
+	loader := p.currentConfig().ConfigLoader
+	if loader == nil {
+		return ConfigDiff{}, errors.New("config reloading is not configured")
+	}
+	next, err := loader()
+	if err != nil {
+		return ConfigDiff{}, err
+	}
+	return p.Reload(next), nil
+}
+
+// serverFor returns the server listening on a port setting and its name in logs
+func (p *Proxy) serverFor(setting string) (*http.Server, string) {
+	switch setting {
+	case "AdminPort":
+		return p.adminServer, "admin"
+	case "DashboardPort":
+		return p.dashboardServer, "dashboard"
+	default:
+		return p.server, "proxy"
+	}
+}
+
+// bind starts serving server on port, or stops listening for port 0, and
+// closes the listener it had. Connections accepted on the old listener
+// finish their requests. Callers hold reloadMutex.
+func (p *Proxy) bind(server *http.Server, name string, port int) error {
+	var ln net.Listener
+	if port > 0 {
+		var err error
+		if ln, err = net.Listen("tcp", fmt.Sprintf(":%d", port)); err != nil {
+			return err
+		}
+		if server == p.server && p.currentConfig().ProtocolSniffing {
+			ln = newSniffListener(ln, p, p.currentConfig().TLSConfig)
+		}
+		if server == p.server && (p.strict != nil || p.headerCase) {
+			ln = &strictListener{Listener: ln, strict: p.strict, headerCase: p.headerCase}
+		}
+		slog.Info("Starting server", "server", name, "port", port)
+		go func() {
+			if err := server.Serve(ln); err != nil && err != http.ErrServerClosed && !errors.Is(err, net.ErrClosed) {
+				slog.Error("Error serving", "server", name, "error", err)
+			}
+		}()
+	}
+
+	if previous := p.listeners[server]; previous != nil {
+		if err := previous.Close(); err != nil {
+			slog.Warn("Error closing listener", "server", name, "error", err)
+		}
+	}
+	if ln == nil {
+		delete(p.listeners, server)
+	} else {
+		p.listeners[server] = ln
+	}
+	return nil
+}
+
+// handleConfigReload reloads the configuration like SIGHUP
+func (p *Proxy) handleConfigReload(w http.ResponseWriter, r *http.Request) {
+	// Add CORS headers
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, Cache-Control, Pragma, Expires")
+
+	// Handle preflight requests
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if p.currentConfig().ConfigLoader == nil {
+		http.Error(w, "Config reloading is not configured", http.StatusNotImplemented)
+		return
+	}
+	diff, err := p.ReloadConfig()
+	if err != nil {
+		http.Error(w, "Invalid config, keeping the current one: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	data, err := json.Marshal(diff)
+	if err != nil {
+		http.Error(w, "Failed to encode config diff", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if _, err := w.Write(data); err != nil {
+		slog.Warn("Error writing config reload response", "error", err)
+	}
+}
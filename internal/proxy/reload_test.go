@@ -0,0 +1,154 @@
+//go:build unit
+
+package proxy
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// freePort returns a port nothing is listening on
+func freePort(t *testing.T) int {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	port := ln.Addr().(*net.TCPAddr).Port
+	require.NoError(t, ln.Close())
+	return port
+}
+
+func TestDiffConfig(t *testing.T) {
+	current := &Config{Port: 8080, HistorySize: 100, CacheSize: 10, ReverseRoutes: []ReverseRoute{{Upstream: &url.URL{Host: "a"}}}}
+	next := &Config{Port: 8080, HistorySize: 100, CacheSize: 10, ReverseRoutes: []ReverseRoute{{Upstream: &url.URL{Host: "a"}}}}
+	assert.Equal(t, ConfigDiff{Applied: []string{}, Rebound: []string{}, RestartRequired: []string{}}, DiffConfig(current, next))
+
+	next.Port, next.HistorySize, next.CacheSize = 9090, 5, 20
+	next.ReverseRoutes = []ReverseRoute{{Upstream: &url.URL{Host: "b"}}}
+	next.ConfigLoader = func() (*Config, error) { return nil, nil }
+	diff := DiffConfig(current, next)
+	assert.Equal(t, []string{"HistorySize", "ReverseRoutes"}, diff.Applied)
+	assert.Equal(t, []string{"Port"}, diff.Rebound)
+	assert.Equal(t, []string{"CacheSize"}, diff.RestartRequired)
+}
+
+func TestReloadSwapsSettings(t *testing.T) {
+	p := New(&Config{HistorySize: 10, ServerTiming: true})
+	for i := 0; i < 10; i++ {
+		p.history.AddRecord(RequestRecord{ID: fmt.Sprint(i)})
+	}
+
+	diff := p.Reload(&Config{HistorySize: 3, TestEndpoints: true, ConditionalGET: true})
+	assert.Equal(t, []string{"HistorySize", "TestEndpoints", "ServerTiming"}, diff.Applied)
+	assert.Equal(t, []string{"ConditionalGET"}, diff.RestartRequired)
+	assert.True(t, p.currentConfig().TestEndpoints)
+	assert.False(t, p.currentConfig().ConditionalGET, "settings set up by New keep their values")
+	assert.Nil(t, p.cache)
+
+	records := p.history.GetRecords()
+	require.Len(t, records, 3)
+	assert.Equal(t, "9", records[0].ID, "the newest records are kept")
+	p.history.AddRecord(RequestRecord{ID: "10"})
+	assert.Len(t, p.history.GetRecords(), 3)
+}
+
+func TestReloadRebindsListeners(t *testing.T) {
+	release := make(chan struct{})
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			<-release
+		}
+		if _, err := w.Write([]byte("ok")); err != nil {
+			t.Logf("Error writing response: %v", err)
+		}
+	}))
+	defer upstream.Close()
+
+	oldPort, newPort, adminPort := freePort(t), freePort(t), freePort(t)
+	p := New(&Config{Port: oldPort, AdminPort: adminPort})
+	started := make(chan error, 1)
+	go func() { started <- p.Start() }()
+
+	client := func(port int) *http.Client {
+		proxyURL, _ := url.Parse(fmt.Sprintf("http://127.0.0.1:%d", port))
+		return &http.Client{Transport: &http.Transport{Proxy: http.ProxyURL(proxyURL)}, Timeout: 5 * time.Second}
+	}
+	require.Eventually(t, func() bool {
+		resp, err := client(oldPort).Get(upstream.URL)
+		if err == nil {
+			_ = resp.Body.Close()
+		}
+		return err == nil
+	}, 2*time.Second, 10*time.Millisecond)
+
+	// A request in flight on the old port finishes after the rebind
+	inFlight := make(chan string, 1)
+	go func() {
+		resp, err := client(oldPort).Get(upstream.URL + "/slow")
+		if err != nil {
+			inFlight <- err.Error()
+			return
+		}
+		defer func() { _ = resp.Body.Close() }()
+		body, _ := io.ReadAll(resp.Body)
+		inFlight <- string(body)
+	}()
+	time.Sleep(50 * time.Millisecond)
+
+	diff := p.Reload(&Config{Port: newPort, AdminPort: adminPort})
+	assert.Equal(t, []string{"Port"}, diff.Rebound)
+	assert.Empty(t, diff.Errors)
+	close(release)
+	assert.Equal(t, "ok", <-inFlight)
+
+	resp, err := client(newPort).Get(upstream.URL)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	_, err = net.DialTimeout("tcp", fmt.Sprintf("127.0.0.1:%d", oldPort), time.Second)
+	assert.Error(t, err, "the old port is closed")
+
+	// A port that cannot be bound keeps the current listener
+	taken, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer func() { _ = taken.Close() }()
+	diff = p.Reload(&Config{Port: taken.Addr().(*net.TCPAddr).Port, AdminPort: adminPort})
+	assert.Empty(t, diff.Rebound)
+	require.Len(t, diff.Errors, 1)
+	assert.Equal(t, newPort, p.currentConfig().Port)
+
+	require.NoError(t, p.Stop())
+	assert.Equal(t, http.ErrServerClosed, <-started)
+}
+
+func TestConfigReloadEndpoint(t *testing.T) {
+	p := New(&Config{})
+	call := func() *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		p.handleConfigReload(rec, httptest.NewRequest(http.MethodPost, "/config/reload", nil))
+		return rec
+	}
+	assert.Equal(t, http.StatusNotImplemented, call().Code)
+
+	loaded := &Config{LogLevel: "debug"}
+	var loadErr error
+	p = New(&Config{ConfigLoader: func() (*Config, error) { return loaded, loadErr }})
+	rec := call()
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	assert.JSONEq(t, `{"applied": ["LogLevel"], "rebound": [], "restart_required": []}`, rec.Body.String())
+	assert.NotNil(t, p.currentConfig().ConfigLoader, "the loader survives the reload")
+
+	loadErr = errors.New("bad file")
+	rec = call()
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.Contains(t, rec.Body.String(), "bad file")
+	assert.Equal(t, "debug", p.currentConfig().LogLevel)
+}
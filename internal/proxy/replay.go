@@ -0,0 +1,454 @@
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Replay pacing modes
+const (
+	PacingNone    = "none"    // Send requests back to back
+	PacingGlobal  = "global"  // Keep the original gaps between all requests
+	PacingSession = "session" // Keep each client session's think time, with sessions running side by side
+)
+
+// ReplayHeader carries the ID of the record a replayed request came from
+const ReplayHeader = "X-Netkit-Replay"
+
+// replaySkippedHeaders are not copied onto replayed requests
+var replaySkippedHeaders = map[string]bool{
+	"Connection":           true,
+	"Content-Length":       true,
+	"Host":                 true,
+	"Keep-Alive":           true,
+	"Proxy-Authorization":  true,
+	"Proxy-Connection":     true,
+	"Te":                   true,
+	"Trailer":              true,
+	"Transfer-Encoding":    true,
+	"Upgrade":              true,
+	"X-Netkit-Destination": true,
+	RequestOptionsHeader:   true,
+	ReplayHeader:           true,
+	RunHeader:              true,
+	"Accept-Encoding":      true, // Let the client negotiate compression it can decode
+	"If-None-Match":        true, // Replay full responses rather than cache revalidations
+	"If-Modified-Since":    true,
+}
+
+// ReplayOptions configure how recorded requests are sent again
+type ReplayOptions struct {
+	Target     *url.URL      // Scheme and host requests are sent to (default: each record's own URL)
+	Pacing     string        // PacingNone (default), PacingGlobal, or PacingSession
+	Speed      float64       // Divides every gap, so 2 replays twice as fast (default: 1)
+	MaxGap     time.Duration // Longest wait between two requests (0 for no limit)
+	SessionKey string        // Groups records into sessions: a header name or cookie:NAME (default: Authorization, then Cookie, then the client address)
+	Client     *http.Client  // Client requests are sent with (default: 30s timeout)
+
+	// OnResult is called after each request, from the session's goroutine
+	OnResult func(ReplayResult)
+}
+
+// ReplayResult is the outcome of one replayed request
+type ReplayResult struct {
+	RecordID string
+	Session  int // Numbered from 1, so session keys such as tokens stay out of output
+	Method   string
+	URL      string
+	Status   int
+	Duration time.Duration
+	Error    string
+}
+
+// ReplaySummary totals a replay
+type ReplaySummary struct {
+	Sessions int
+	Sent     int
+	Failed   int
+	Skipped  int // Redirect hops the client follows itself, tunnels, and records without an absolute URL
+	Statuses map[int]int
+	Duration time.Duration
+}
+
+// ValidatePacing checks a replay pacing mode
+func ValidatePacing(pacing string) error {
+	switch pacing {
+	case PacingNone, PacingGlobal, PacingSession:
+		return nil
+	}
+	return fmt.Errorf("pacing must be %s, %s, or %s, got %q", PacingNone, PacingGlobal, PacingSession, pacing)
+}
+
+// ReplayRecords sends recorded requests again. With session pacing, each
+// client session waits its original think time (from the end of one response
+// to the start of the next request) and sessions start at their original
+// offsets, so concurrency follows the recorded traffic.
+func ReplayRecords(ctx context.Context, records []RequestRecord, options ReplayOptions) ReplaySummary {
+	if options.Pacing == "" {
+		options.Pacing = PacingNone
+	}
+	if options.Speed <= 0 {
+		options.Speed = 1
+	}
+	if options.Client == nil {
+		options.Client = &http.Client{Timeout: 30 * time.Second}
+	}
+
+	summary := ReplaySummary{Statuses: map[int]int{}}
+	var replayable []RequestRecord
+	for _, record := range records {
+		if record.Method == http.MethodConnect || record.RedirectPrevID != "" || replayURL(record, options.Target) == nil {
+			summary.Skipped++
+			continue
+		}
+		replayable = append(replayable, record)
+	}
+	sort.SliceStable(replayable, func(i, j int) bool { return replayable[i].Timestamp.Before(replayable[j].Timestamp) })
+
+	// Without session pacing, every request runs on one timeline
+	sessions := [][]RequestRecord{replayable}
+	if options.Pacing == PacingSession {
+		sessions = groupReplaySessions(replayable, options.SessionKey)
+	}
+	if len(replayable) == 0 {
+		return summary
+	}
+	summary.Sessions = len(sessions)
+
+	var mutex sync.Mutex
+	var wg sync.WaitGroup
+	start := time.Now()
+	first := replayable[0].Timestamp
+	for number, session := range sessions {
+		wg.Add(1)
+		go func(number int, session []RequestRecord) {
+			defer wg.Done()
+			if options.Pacing == PacingSession && !sleepContext(ctx, options.gap(session[0].Timestamp.Sub(first))) {
+				return
+			}
+			for i, record := range session {
+				if i > 0 && !sleepContext(ctx, options.wait(session[i-1], record, start, first)) {
+					return
+				}
+				result := replayRequest(ctx, options, record)
+				result.Session = number
+
+				mutex.Lock()
+				summary.Sent++
+				if result.Error != "" {
+					summary.Failed++
+				} else {
+					summary.Statuses[result.Status]++
+				}
+				mutex.Unlock()
+				if options.OnResult != nil {
+					options.OnResult(result)
+				}
+			}
+		}(number+1, session)
+	}
+	wg.Wait()
+	summary.Duration = time.Since(start)
+	return summary
+}
+
+// wait returns how long to pause before sending next after previous
+func (o ReplayOptions) wait(previous, next RequestRecord, start, first time.Time) time.Duration {
+	switch o.Pacing {
+	case PacingGlobal:
+		// Keep each request at its original offset from the first one
+		return time.Until(start.Add(o.gap(next.Timestamp.Sub(first))))
+	case PacingSession:
+		// Think time runs from the end of the previous response
+		end := previous.ProxyEndTime
+		if end.IsZero() {
+			end = previous.Timestamp
+		}
+		return o.gap(next.Timestamp.Sub(end))
+	default:
+		return 0
+	}
+}
+
+// gap scales a recorded gap by the speed and caps it at the maximum
+func (o ReplayOptions) gap(gap time.Duration) time.Duration {
+	gap = time.Duration(float64(gap) / o.Speed)
+	if o.MaxGap > 0 && gap > o.MaxGap {
+		return o.MaxGap
+	}
+	return max(gap, 0)
+}
+
+// replayRequest sends one recorded request and reports the outcome
+func replayRequest(ctx context.Context, options ReplayOptions, record RequestRecord) ReplayResult {
+	target := replayURL(record, options.Target)
+	result := ReplayResult{RecordID: record.ID, Method: record.Method, URL: target.String()}
+
+	var body io.Reader
+	if record.RequestBody != "" {
+		body = strings.NewReader(record.RequestBody)
+	}
+	req, err := http.NewRequestWithContext(ctx, record.Method, target.String(), body)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	for name, value := range record.RequestHeaders {
+		if !replaySkippedHeaders[http.CanonicalHeaderKey(name)] {
+			req.Header.Set(name, value)
+		}
+	}
+	req.Header.Set(ReplayHeader, record.ID)
+
+	sent := time.Now()
+	resp, err := options.Client.Do(req)
+	if err != nil {
+		result.Duration = time.Since(sent)
+		result.Error = err.Error()
+		return result
+	}
+	defer func() {
+		if err := resp.Body.Close(); err != nil {
+			slog.Warn("Error closing replay response body", "error", err)
+		}
+	}()
+	if _, err := io.Copy(io.Discard, resp.Body); err != nil {
+		result.Error = fmt.Sprintf("failed to read response: %v", err)
+	}
+	result.Duration = time.Since(sent)
+	result.Status = resp.StatusCode
+	return result
+}
+
+// replayURL returns where a record is replayed to: its own URL, or its path
+// and query on the target
+func replayURL(record RequestRecord, target *url.URL) *url.URL {
+	original, err := url.Parse(record.URL)
+	if err != nil {
+		return nil
+	}
+	if target == nil {
+		if !original.IsAbs() || original.Host == "" {
+			return nil
+		}
+		return original
+	}
+	replayed := *original
+	replayed.Scheme, replayed.Host = target.Scheme, target.Host
+	if strings.TrimSuffix(target.Path, "/") != "" {
+		replayed.Path = strings.TrimSuffix(target.Path, "/") + original.Path
+	}
+	return &replayed
+}
+
+// groupReplaySessions splits records into client sessions, each in time order
+func groupReplaySessions(records []RequestRecord, key string) [][]RequestRecord {
+	index := map[string]int{}
+	var sessions [][]RequestRecord
+	for _, record := range records {
+		name := replaySessionName(record, key)
+		i, ok := index[name]
+		if !ok {
+			i = len(sessions)
+			index[name] = i
+			sessions = append(sessions, nil)
+		}
+		sessions[i] = append(sessions[i], record)
+	}
+	return sessions
+}
+
+// replaySessionName identifies the client session of a record: by the
+// configured header or cookie, or else by the Authorization header, the
+// Cookie header, and finally the client address
+func replaySessionName(record RequestRecord, key string) string {
+	if key != "" {
+		if cookie, ok := strings.CutPrefix(key, "cookie:"); ok {
+			return requestCookie(record, cookie)
+		}
+		return recordHeader(record.RequestHeaders, key)
+	}
+	for _, header := range []string{"Authorization", "Cookie"} {
+		if value := recordHeader(record.RequestHeaders, header); value != "" {
+			return value
+		}
+	}
+	return record.ClientAddr
+}
+
+// requestCookie returns a cookie's value from the recorded Cookie header
+func requestCookie(record RequestRecord, name string) string {
+	req := http.Request{Header: http.Header{"Cookie": {recordHeader(record.RequestHeaders, "Cookie")}}}
+	cookie, err := req.Cookie(name)
+	if err != nil {
+		return ""
+	}
+	return cookie.Value
+}
+
+// recordHeader looks up a recorded header case-insensitively
+func recordHeader(headers map[string]string, name string) string {
+	for key, value := range headers {
+		if strings.EqualFold(key, name) {
+			return value
+		}
+	}
+	return ""
+}
+
+// sleepContext waits for the duration, returning false if the context ends first
+func sleepContext(ctx context.Context, d time.Duration) bool {
+	if d <= 0 {
+		return ctx.Err() == nil
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// ReplayOverrides change a recorded request before it is sent again. Every
+// field is optional.
+type ReplayOverrides struct {
+	Method  string             `json:"method,omitempty"`
+	URL     string             `json:"url,omitempty"`
+	Headers map[string]*string `json:"headers,omitempty"` // A null value removes the header
+	Body    *string            `json:"body,omitempty"`
+}
+
+// ReplayResponse identifies the record of a request replayed through the
+// proxy
+type ReplayResponse struct {
+	ID         string `json:"id"`
+	OriginalID string `json:"original_id"`
+	Status     int    `json:"status"`
+}
+
+// replayResponseWriter keeps the status of a replayed request and discards
+// its body, which is in the new record
+type replayResponseWriter struct {
+	header http.Header
+	status int
+}
+
+func (w *replayResponseWriter) Header() http.Header { return w.header }
+
+func (w *replayResponseWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return len(b), nil
+}
+
+func (w *replayResponseWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+}
+
+// handleReplayRequest serves POST /requests/{id}/replay: the record's request
+// is sent through the proxy again, with optional overrides, and recorded as
+// a new request whose ID is returned
+func (p *Proxy) handleReplayRequest(w http.ResponseWriter, r *http.Request, id string) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// An empty body replays the request as it was recorded
+	var overrides ReplayOverrides
+	if err := json.NewDecoder(r.Body).Decode(&overrides); err != nil && !errors.Is(err, io.EOF) {
+		http.Error(w, "Invalid replay JSON", http.StatusBadRequest)
+		return
+	}
+
+	records, err := p.queryHistory(&RequestFilter{IDs: []string{id}})
+	if err != nil {
+		slog.Error("Error querying history backend", "error", err)
+		http.Error(w, "Failed to get request", http.StatusInternalServerError)
+		return
+	}
+	if len(records) == 0 {
+		http.Error(w, "Request not found", http.StatusNotFound)
+		return
+	}
+	record := records[0]
+	if overrides.Body == nil && (record.RequestBodyTruncated || record.BodiesOmitted || record.RequestBodySHA256 != "") {
+		http.Error(w, "The recorded request body is incomplete; give the body to replay with", http.StatusConflict)
+		return
+	}
+
+	req, err := replayProxyRequest(r.Context(), record, overrides)
+	if err != nil {
+		http.Error(w, "Invalid replay overrides: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	replayID := p.newRequestID()
+	recorder := &replayResponseWriter{header: make(http.Header)}
+	p.ServeHTTP(recorder, withRequestID(req, replayID))
+
+	data, err := json.Marshal(ReplayResponse{ID: replayID, OriginalID: id, Status: recorder.status})
+	if err != nil {
+		http.Error(w, "Failed to replay request", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if _, err := w.Write(data); err != nil {
+		slog.Warn("Error writing replay response", "error", err)
+	}
+}
+
+// replayProxyRequest builds the request a record is replayed with through the
+// proxy, sent to the recorded URL like a dashboard request
+func replayProxyRequest(ctx context.Context, record RequestRecord, overrides ReplayOverrides) (*http.Request, error) {
+	method, target, body := record.Method, record.URL, record.RequestBody
+	if overrides.Method != "" {
+		method = strings.ToUpper(overrides.Method)
+	}
+	if overrides.URL != "" {
+		target = overrides.URL
+	}
+	if overrides.Body != nil {
+		body = *overrides.Body
+	}
+	if parsed, err := url.Parse(target); err != nil || !parsed.IsAbs() || parsed.Host == "" {
+		return nil, fmt.Errorf("cannot replay to %q: not an absolute URL", target)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, target, strings.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	for name, value := range record.RequestHeaders {
+		if !replaySkippedHeaders[http.CanonicalHeaderKey(name)] {
+			req.Header.Set(name, value)
+		}
+	}
+	for name, value := range overrides.Headers {
+		if value == nil {
+			req.Header.Del(name)
+		} else {
+			req.Header.Set(name, *value)
+		}
+	}
+	req.Header.Set("X-Netkit-Destination", target)
+	req.Header.Set(ReplayHeader, record.ID)
+	req.RemoteAddr = "127.0.0.1:0"
+	return req, nil
+}
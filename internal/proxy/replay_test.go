@@ -0,0 +1,261 @@
+//go:build unit
+
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// replayArrival is a request seen by the replay target
+type replayArrival struct {
+	path    string
+	session string
+	at      time.Time
+	headers http.Header
+	body    string
+}
+
+func replayTarget(t *testing.T) (*httptest.Server, func() []replayArrival) {
+	t.Helper()
+	var mutex sync.Mutex
+	var arrivals []replayArrival
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			t.Logf("Error reading body: %v", err)
+		}
+		mutex.Lock()
+		arrivals = append(arrivals, replayArrival{
+			path:    r.URL.RequestURI(),
+			session: r.Header.Get("Authorization"),
+			at:      time.Now(),
+			headers: r.Header.Clone(),
+			body:    string(body),
+		})
+		mutex.Unlock()
+		if strings.HasSuffix(r.URL.Path, "/missing") {
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server, func() []replayArrival {
+		mutex.Lock()
+		defer mutex.Unlock()
+		return append([]replayArrival(nil), arrivals...)
+	}
+}
+
+func replayRecord(id, session, path string, at, duration time.Duration) RequestRecord {
+	start := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC).Add(at)
+	return RequestRecord{
+		ID:             id,
+		Timestamp:      start,
+		Method:         http.MethodGet,
+		URL:            "http://api.example.com" + path,
+		RequestHeaders: map[string]string{"Authorization": session},
+		ProxyStartTime: start,
+		ProxyEndTime:   start.Add(duration),
+	}
+}
+
+func TestReplaySessionThinkTime(t *testing.T) {
+	server, arrivals := replayTarget(t)
+	target, err := url.Parse(server.URL)
+	require.NoError(t, err)
+
+	// Session a thinks for 150ms after a slow first response; session b starts 60ms in
+	records := []RequestRecord{
+		replayRecord("a2", "a", "/a2", 250*time.Millisecond, 0),
+		replayRecord("a1", "a", "/a1", 0, 100*time.Millisecond),
+		replayRecord("b1", "b", "/b1", 60*time.Millisecond, 0),
+	}
+	summary := ReplayRecords(context.Background(), records, ReplayOptions{Target: target, Pacing: PacingSession})
+	assert.Equal(t, 2, summary.Sessions)
+	assert.Equal(t, 3, summary.Sent)
+	assert.Equal(t, map[int]int{http.StatusOK: 3}, summary.Statuses)
+
+	seen := map[string]replayArrival{}
+	for _, arrival := range arrivals() {
+		seen[arrival.path] = arrival
+	}
+	require.Len(t, seen, 3)
+	assert.GreaterOrEqual(t, seen["/b1"].at.Sub(seen["/a1"].at), 50*time.Millisecond, "sessions start at their original offsets")
+	// The replayed response is fast, so a2 follows a1 by the think time alone
+	gap := seen["/a2"].at.Sub(seen["/a1"].at)
+	assert.GreaterOrEqual(t, gap, 150*time.Millisecond)
+	assert.Less(t, gap, 240*time.Millisecond, "the original response time is not waited out again")
+}
+
+func TestReplayGlobalPacingAndSpeed(t *testing.T) {
+	server, arrivals := replayTarget(t)
+	target, err := url.Parse(server.URL)
+	require.NoError(t, err)
+
+	records := []RequestRecord{
+		replayRecord("1", "a", "/1", 0, 0),
+		replayRecord("2", "b", "/2", 200*time.Millisecond, 0),
+	}
+	ReplayRecords(context.Background(), records, ReplayOptions{Target: target, Pacing: PacingGlobal, Speed: 2})
+	seen := arrivals()
+	require.Len(t, seen, 2)
+	// Offsets count from the start of the replay, before the first connection is set up
+	gap := seen[1].at.Sub(seen[0].at)
+	assert.GreaterOrEqual(t, gap, 80*time.Millisecond)
+	assert.Less(t, gap, 190*time.Millisecond)
+
+	// Long gaps are capped
+	start := time.Now()
+	records[1] = replayRecord("2", "b", "/2", time.Hour, 0)
+	ReplayRecords(context.Background(), records, ReplayOptions{Target: target, Pacing: PacingGlobal, MaxGap: 20 * time.Millisecond})
+	assert.Less(t, time.Since(start), time.Second)
+}
+
+func TestReplayRequests(t *testing.T) {
+	server, arrivals := replayTarget(t)
+	target, err := url.Parse(server.URL + "/base")
+	require.NoError(t, err)
+
+	post := replayRecord("post", "a", "/orders?id=1", 0, 0)
+	post.Method = http.MethodPost
+	post.RequestBody = `{"item": 1}`
+	post.RequestHeaders["Content-Type"] = "application/json"
+	post.RequestHeaders["X-Netkit-Destination"] = "http://api.example.com"
+	post.RequestHeaders["If-None-Match"] = `"v1"`
+	hop := replayRecord("hop", "a", "/next", 0, 0)
+	hop.RedirectPrevID = "post"
+	tunnel := replayRecord("tunnel", "a", "", 0, 0)
+	tunnel.Method = http.MethodConnect
+	missing := replayRecord("missing", "a", "/missing", time.Millisecond, 0)
+
+	var results []ReplayResult
+	summary := ReplayRecords(context.Background(), []RequestRecord{post, hop, tunnel, missing}, ReplayOptions{
+		Target:   target,
+		OnResult: func(result ReplayResult) { results = append(results, result) },
+	})
+	assert.Equal(t, 2, summary.Sent)
+	assert.Equal(t, 2, summary.Skipped)
+	assert.Equal(t, map[int]int{http.StatusOK: 1, http.StatusNotFound: 1}, summary.Statuses)
+
+	seen := arrivals()
+	require.Len(t, seen, 2)
+	assert.Equal(t, "/base/orders?id=1", seen[0].path)
+	assert.Equal(t, `{"item": 1}`, seen[0].body)
+	assert.Equal(t, "application/json", seen[0].headers.Get("Content-Type"))
+	assert.Equal(t, "post", seen[0].headers.Get(ReplayHeader))
+	assert.Empty(t, seen[0].headers.Get("X-Netkit-Destination"))
+	assert.Empty(t, seen[0].headers.Get("If-None-Match"))
+
+	require.Len(t, results, 2)
+	assert.Equal(t, "post", results[0].RecordID)
+	assert.Equal(t, 1, results[0].Session)
+	assert.Equal(t, server.URL+"/base/orders?id=1", results[0].URL)
+
+	// Without a target, relative URLs cannot be replayed
+	relative := replayRecord("inbox", "a", "", 0, 0)
+	relative.URL = "/inbox/stripe"
+	assert.Equal(t, 1, ReplayRecords(context.Background(), []RequestRecord{relative}, ReplayOptions{}).Skipped)
+}
+
+func TestReplaySessionNames(t *testing.T) {
+	record := RequestRecord{
+		RequestHeaders: map[string]string{"cookie": "theme=dark; sid=abc", "X-User": "7"},
+		ClientAddr:     "10.0.0.1",
+	}
+	assert.Equal(t, "abc", replaySessionName(record, "cookie:sid"))
+	assert.Equal(t, "7", replaySessionName(record, "x-user"))
+	assert.Equal(t, "theme=dark; sid=abc", replaySessionName(record, ""))
+
+	record.RequestHeaders = map[string]string{"Authorization": "Bearer t"}
+	assert.Equal(t, "Bearer t", replaySessionName(record, ""))
+	record.RequestHeaders = nil
+	assert.Equal(t, "10.0.0.1", replaySessionName(record, ""))
+
+	assert.NoError(t, ValidatePacing(PacingSession))
+	assert.Error(t, ValidatePacing("realistic"))
+}
+
+func TestHandleReplayRequest(t *testing.T) {
+	type arrival struct {
+		method string
+		header http.Header
+		body   string
+	}
+	arrivals := make(chan arrival, 4)
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		arrivals <- arrival{method: r.Method, header: r.Header.Clone(), body: string(body)}
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer upstream.Close()
+
+	p := New(&Config{})
+	p.history.AddRecord(RequestRecord{
+		ID:             "original",
+		Timestamp:      time.Now(),
+		Method:         "POST",
+		URL:            upstream.URL + "/orders",
+		RequestHeaders: map[string]string{"Content-Type": "application/json", "Authorization": "Bearer old", "X-Debug": "1"},
+		RequestBody:    `{"sku":"a"}`,
+	})
+	p.history.AddRecord(RequestRecord{ID: "truncated", Timestamp: time.Now(), Method: "POST", URL: upstream.URL + "/upload", RequestBodyTruncated: true})
+
+	replay := func(id, payload string) (ReplayResponse, *httptest.ResponseRecorder) {
+		rec := httptest.NewRecorder()
+		p.handleRequestDetail(rec, httptest.NewRequest(http.MethodPost, "/requests/"+id+"/replay", strings.NewReader(payload)))
+		var response ReplayResponse
+		if rec.Code == http.StatusOK {
+			require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &response))
+		}
+		return response, rec
+	}
+
+	// As recorded
+	response, rec := replay("original", "")
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	assert.Equal(t, "original", response.OriginalID)
+	assert.Equal(t, http.StatusCreated, response.Status)
+	got := <-arrivals
+	assert.Equal(t, "POST", got.method)
+	assert.Equal(t, `{"sku":"a"}`, got.body)
+	assert.Equal(t, "Bearer old", got.header.Get("Authorization"))
+	assert.Equal(t, "original", got.header.Get(ReplayHeader))
+
+	records := p.history.GetRecords()
+	require.Len(t, records, 3)
+	assert.Equal(t, response.ID, records[0].ID, "the replay is recorded under the returned ID")
+	assert.Equal(t, upstream.URL+"/orders", records[0].URL)
+	assert.Equal(t, http.StatusCreated, records[0].ResponseStatus)
+
+	// With overrides
+	response, rec = replay("original", `{"method":"put","headers":{"Authorization":"Bearer new","X-Debug":null},"body":"{\"sku\":\"b\"}"}`)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	got = <-arrivals
+	assert.Equal(t, "PUT", got.method)
+	assert.Equal(t, `{"sku":"b"}`, got.body)
+	assert.Equal(t, "Bearer new", got.header.Get("Authorization"))
+	assert.Empty(t, got.header.Get("X-Debug"))
+	assert.NotEqual(t, "original", response.ID)
+
+	_, rec = replay("missing", "")
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+	_, rec = replay("truncated", "")
+	assert.Equal(t, http.StatusConflict, rec.Code, "incomplete bodies need an override")
+	_, rec = replay("original", `{"url":"/relative"}`)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	rec = httptest.NewRecorder()
+	p.handleRequestDetail(rec, httptest.NewRequest(http.MethodGet, "/requests/original/replay", nil))
+	assert.Equal(t, http.StatusMethodNotAllowed, rec.Code)
+}
@@ -0,0 +1,547 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"log/slog"
+	"math"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Report output formats
+const (
+	ReportJSON     = "json"
+	ReportHTML     = "html"
+	ReportMarkdown = "markdown"
+)
+
+// Report sizes
+const (
+	reportTopEndpoints  = 10
+	reportErrorClusters = 10
+	reportMaxBuckets    = 48
+)
+
+// reportBucketSizes are the latency trend granularities, smallest first
+var reportBucketSizes = []time.Duration{
+	time.Minute, 5 * time.Minute, 15 * time.Minute, time.Hour, 6 * time.Hour, 24 * time.Hour,
+}
+
+// Report summarizes the traffic that went through the proxy in a time window
+type Report struct {
+	Title         string            `json:"title,omitempty"` // Defaults to "Netkit traffic report"
+	GeneratedAt   time.Time         `json:"generated_at"`
+	WindowStart   time.Time         `json:"window_start"`
+	WindowEnd     time.Time         `json:"window_end"`
+	TotalRequests int               `json:"total_requests"`
+	ErrorCount    int               `json:"error_count"`
+	ErrorRate     float64           `json:"error_rate"` // Fraction of requests that failed, 0-1
+	RequestBytes  int64             `json:"request_bytes"`
+	ResponseBytes int64             `json:"response_bytes"`
+	P50DurationUs int64             `json:"p50_duration_us"`
+	P95DurationUs int64             `json:"p95_duration_us"`
+	TopEndpoints  []EndpointSummary `json:"top_endpoints"`
+	ErrorClusters []ErrorCluster    `json:"error_clusters"`
+	LatencyTrend  []LatencyBucket   `json:"latency_trend"`
+}
+
+// EndpointSummary is the traffic for one method and normalized route
+type EndpointSummary struct {
+	Method        string `json:"method"`
+	Route         string `json:"route"`
+	Count         int    `json:"count"`
+	ErrorCount    int    `json:"error_count"`
+	AvgDurationUs int64  `json:"avg_duration_us"`
+	P95DurationUs int64  `json:"p95_duration_us"`
+}
+
+// LatencyBucket is the latency of the requests that started in one slice of the window
+type LatencyBucket struct {
+	Start         time.Time `json:"start"`
+	Count         int       `json:"count"`
+	ErrorCount    int       `json:"error_count"`
+	P50DurationUs int64     `json:"p50_duration_us"`
+	P95DurationUs int64     `json:"p95_duration_us"`
+}
+
+// ValidateReportFormat checks that format is a supported report format
+func ValidateReportFormat(format string) error {
+	switch format {
+	case ReportJSON, ReportHTML, ReportMarkdown:
+		return nil
+	default:
+		return fmt.Errorf("unknown report format %q (expected json, html, or markdown)", format)
+	}
+}
+
+// BuildReport summarizes the records with timestamps in [start, end).
+// Records must be most recent first, as returned by GetRecords.
+func BuildReport(records []RequestRecord, start, end time.Time) Report {
+	report := Report{
+		GeneratedAt:   time.Now(),
+		WindowStart:   start,
+		WindowEnd:     end,
+		TopEndpoints:  []EndpointSummary{},
+		ErrorClusters: []ErrorCluster{},
+		LatencyTrend:  []LatencyBucket{},
+	}
+
+	var window []RequestRecord
+	for _, record := range records {
+		if !record.Timestamp.Before(start) && record.Timestamp.Before(end) {
+			window = append(window, record)
+		}
+	}
+	if len(window) == 0 {
+		return report
+	}
+
+	type endpointKey struct{ method, route string }
+	endpoints := make(map[endpointKey]*EndpointSummary)
+	endpointDurations := make(map[endpointKey][]int64)
+	durations := make([]int64, 0, len(window))
+
+	for _, record := range window {
+		failed := isFailedRecord(record)
+		report.TotalRequests++
+		if failed {
+			report.ErrorCount++
+		}
+		report.RequestBytes += record.RequestSize
+		report.ResponseBytes += record.ResponseSize
+		durations = append(durations, record.TotalDurationUs)
+
+		key := endpointKey{record.Method, normalizeRoute(record.URL)}
+		endpoint, ok := endpoints[key]
+		if !ok {
+			endpoint = &EndpointSummary{Method: key.method, Route: key.route}
+			endpoints[key] = endpoint
+		}
+		endpoint.Count++
+		if failed {
+			endpoint.ErrorCount++
+		}
+		endpoint.AvgDurationUs += record.TotalDurationUs
+		endpointDurations[key] = append(endpointDurations[key], record.TotalDurationUs)
+	}
+
+	report.ErrorRate = float64(report.ErrorCount) / float64(report.TotalRequests)
+	report.P50DurationUs = percentile(durations, 50)
+	report.P95DurationUs = percentile(durations, 95)
+
+	for key, endpoint := range endpoints {
+		endpoint.AvgDurationUs /= int64(endpoint.Count)
+		endpoint.P95DurationUs = percentile(endpointDurations[key], 95)
+		report.TopEndpoints = append(report.TopEndpoints, *endpoint)
+	}
+	sort.Slice(report.TopEndpoints, func(i, j int) bool {
+		a, b := report.TopEndpoints[i], report.TopEndpoints[j]
+		if a.Count != b.Count {
+			return a.Count > b.Count
+		}
+		return a.Method+" "+a.Route < b.Method+" "+b.Route
+	})
+	if len(report.TopEndpoints) > reportTopEndpoints {
+		report.TopEndpoints = report.TopEndpoints[:reportTopEndpoints]
+	}
+
+	report.ErrorClusters = clusterErrors(window)
+	if len(report.ErrorClusters) > reportErrorClusters {
+		report.ErrorClusters = report.ErrorClusters[:reportErrorClusters]
+	}
+
+	report.LatencyTrend = latencyTrend(window, start, end)
+	return report
+}
+
+// latencyTrend buckets the window at the finest granularity that keeps the
+// number of buckets readable
+func latencyTrend(records []RequestRecord, start, end time.Time) []LatencyBucket {
+	size := reportBucketSizes[len(reportBucketSizes)-1]
+	for _, candidate := range reportBucketSizes {
+		if end.Sub(start)/candidate < reportMaxBuckets {
+			size = candidate
+			break
+		}
+	}
+
+	first := start.Truncate(size)
+	count := int(end.Sub(first)/size) + 1
+	if count > reportMaxBuckets {
+		// Very long windows only show their most recent buckets
+		first = first.Add(time.Duration(count-reportMaxBuckets) * size)
+		count = reportMaxBuckets
+	}
+
+	durations := make([][]int64, count)
+	buckets := make([]LatencyBucket, count)
+	for i := range buckets {
+		buckets[i].Start = first.Add(time.Duration(i) * size)
+	}
+	for _, record := range records {
+		i := int(record.Timestamp.Sub(first) / size)
+		if i < 0 || i >= count {
+			continue
+		}
+		buckets[i].Count++
+		if isFailedRecord(record) {
+			buckets[i].ErrorCount++
+		}
+		durations[i] = append(durations[i], record.TotalDurationUs)
+	}
+	for i := range buckets {
+		buckets[i].P50DurationUs = percentile(durations[i], 50)
+		buckets[i].P95DurationUs = percentile(durations[i], 95)
+	}
+	return buckets
+}
+
+// percentile returns the nearest-rank percentile p (0-100) of values, which it sorts
+func percentile(values []int64, p float64) int64 {
+	if len(values) == 0 {
+		return 0
+	}
+	sort.Slice(values, func(i, j int) bool { return values[i] < values[j] })
+	rank := int(math.Ceil(p/100*float64(len(values)))) - 1
+	if rank < 0 {
+		rank = 0
+	}
+	if rank >= len(values) {
+		rank = len(values) - 1
+	}
+	return values[rank]
+}
+
+// RenderReport renders a report in the given format
+func RenderReport(report Report, format string) ([]byte, error) {
+	switch format {
+	case ReportJSON:
+		return json.MarshalIndent(report, "", "  ")
+	case ReportMarkdown:
+		return renderReportMarkdown(report), nil
+	case ReportHTML:
+		var buf bytes.Buffer
+		if err := reportHTMLTemplate.Execute(&buf, report); err != nil {
+			return nil, fmt.Errorf("failed to render HTML report: %v", err)
+		}
+		return buf.Bytes(), nil
+	default:
+		return nil, ValidateReportFormat(format)
+	}
+}
+
+// formatMicros formats a duration in microseconds for reports
+func formatMicros(us int64) string {
+	d := time.Duration(us) * time.Microsecond
+	switch {
+	case d >= time.Second:
+		return d.Round(time.Millisecond).String()
+	case d >= time.Millisecond:
+		return d.Round(10 * time.Microsecond).String()
+	default:
+		return d.String()
+	}
+}
+
+func renderReportMarkdown(report Report) []byte {
+	var b strings.Builder
+	fmt.Fprintf(&b, "# %s\n\n", reportTitle(report))
+	fmt.Fprintf(&b, "%s to %s\n\n", report.WindowStart.Format(time.RFC3339), report.WindowEnd.Format(time.RFC3339))
+	fmt.Fprintf(&b, "- Requests: %d\n", report.TotalRequests)
+	fmt.Fprintf(&b, "- Errors: %d (%.1f%%)\n", report.ErrorCount, report.ErrorRate*100)
+	fmt.Fprintf(&b, "- Latency: p50 %s, p95 %s\n", formatMicros(report.P50DurationUs), formatMicros(report.P95DurationUs))
+	fmt.Fprintf(&b, "- Bytes: %d sent, %d received\n", report.RequestBytes, report.ResponseBytes)
+
+	if len(report.TopEndpoints) > 0 {
+		fmt.Fprintf(&b, "\n## Top endpoints\n\n")
+		fmt.Fprintf(&b, "| Endpoint | Requests | Errors | Avg | p95 |\n|---|---:|---:|---:|---:|\n")
+		for _, e := range report.TopEndpoints {
+			fmt.Fprintf(&b, "| `%s %s` | %d | %d | %s | %s |\n", e.Method, e.Route, e.Count, e.ErrorCount,
+				formatMicros(e.AvgDurationUs), formatMicros(e.P95DurationUs))
+		}
+	}
+
+	if len(report.ErrorClusters) > 0 {
+		fmt.Fprintf(&b, "\n## Error clusters\n\n")
+		fmt.Fprintf(&b, "| Status | Endpoint | Signature | Count | Last seen |\n|---:|---|---|---:|---|\n")
+		for _, c := range report.ErrorClusters {
+			fmt.Fprintf(&b, "| %d | `%s %s` | %s | %d | %s |\n", c.Status, c.Method, c.Route,
+				strings.ReplaceAll(c.Signature, "|", `\|`), c.Count, c.LastSeen.Format(time.RFC3339))
+		}
+	}
+
+	if len(report.LatencyTrend) > 0 {
+		fmt.Fprintf(&b, "\n## Latency trend\n\n")
+		fmt.Fprintf(&b, "| Start | Requests | Errors | p50 | p95 |\n|---|---:|---:|---:|---:|\n")
+		for _, bucket := range report.LatencyTrend {
+			if bucket.Count == 0 {
+				continue
+			}
+			fmt.Fprintf(&b, "| %s | %d | %d | %s | %s |\n", bucket.Start.Format(time.RFC3339), bucket.Count,
+				bucket.ErrorCount, formatMicros(bucket.P50DurationUs), formatMicros(bucket.P95DurationUs))
+		}
+	}
+	return []byte(b.String())
+}
+
+// reportTitle returns the report's title, or the default one
+func reportTitle(report Report) string {
+	if report.Title != "" {
+		return report.Title
+	}
+	return "Netkit traffic report"
+}
+
+// reportHTMLTemplate renders a self-contained page: styles and charts are
+// inlined so the file can be shared and opened without netkit
+var reportHTMLTemplate = template.Must(template.New("report").Funcs(template.FuncMap{
+	"title":         reportTitle,
+	"trendChart":    latencyTrendChart,
+	"endpointChart": endpointChart,
+	"micros":        formatMicros,
+	"percent":       func(f float64) string { return fmt.Sprintf("%.1f%%", f*100) },
+	"time":          func(t time.Time) string { return t.Format(time.RFC3339) },
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{title .}}</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; margin-bottom: 2em; }
+th, td { border: 1px solid #ccc; padding: 4px 8px; text-align: left; }
+td.num { text-align: right; }
+svg { display: block; margin-bottom: 1em; font-family: sans-serif; }
+</style>
+</head>
+<body>
+<h1>{{title .}}</h1>
+<p>{{time .WindowStart}} to {{time .WindowEnd}}</p>
+<ul>
+<li>Requests: {{.TotalRequests}}</li>
+<li>Errors: {{.ErrorCount}} ({{percent .ErrorRate}})</li>
+<li>Latency: p50 {{micros .P50DurationUs}}, p95 {{micros .P95DurationUs}}</li>
+<li>Bytes: {{.RequestBytes}} sent, {{.ResponseBytes}} received</li>
+</ul>
+{{if .TotalRequests}}{{trendChart .LatencyTrend}}
+{{end}}{{if .TopEndpoints}}<h2>Top endpoints</h2>
+{{endpointChart .TopEndpoints}}
+<table>
+<tr><th>Endpoint</th><th>Requests</th><th>Errors</th><th>Avg</th><th>p95</th></tr>
+{{range .TopEndpoints}}<tr><td>{{.Method}} {{.Route}}</td><td class="num">{{.Count}}</td><td class="num">{{.ErrorCount}}</td><td class="num">{{micros .AvgDurationUs}}</td><td class="num">{{micros .P95DurationUs}}</td></tr>
+{{end}}</table>
+{{end}}{{if .ErrorClusters}}<h2>Error clusters</h2>
+<table>
+<tr><th>Status</th><th>Endpoint</th><th>Signature</th><th>Count</th><th>Last seen</th></tr>
+{{range .ErrorClusters}}<tr><td class="num">{{.Status}}</td><td>{{.Method}} {{.Route}}</td><td>{{.Signature}}</td><td class="num">{{.Count}}</td><td>{{time .LastSeen}}</td></tr>
+{{end}}</table>
+{{end}}{{if .LatencyTrend}}<h2>Latency trend</h2>
+<table>
+<tr><th>Start</th><th>Requests</th><th>Errors</th><th>p50</th><th>p95</th></tr>
+{{range .LatencyTrend}}{{if .Count}}<tr><td>{{time .Start}}</td><td class="num">{{.Count}}</td><td class="num">{{.ErrorCount}}</td><td class="num">{{micros .P50DurationUs}}</td><td class="num">{{micros .P95DurationUs}}</td></tr>
+{{end}}{{end}}</table>
+{{end}}</body>
+</html>
+`))
+
+// reportFileExtensions maps report formats to file extensions
+var reportFileExtensions = map[string]string{
+	ReportJSON:     "json",
+	ReportHTML:     "html",
+	ReportMarkdown: "md",
+}
+
+// reportScheduler renders reports on a cron schedule in the background
+type reportScheduler struct {
+	client *http.Client
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// startReports starts the report scheduler. Each report covers the time since
+// the previous one, or since startup for the first.
+func (p *Proxy) startReports() *reportScheduler {
+	ctx, cancel := context.WithCancel(context.Background())
+	scheduler := &reportScheduler{
+		client: &http.Client{Timeout: 30 * time.Second},
+		cancel: cancel,
+	}
+
+	start := time.Now()
+	scheduler.wg.Add(1)
+	go func() {
+		defer scheduler.wg.Done()
+		for {
+			next := p.currentConfig().ReportSchedule.Next(time.Now())
+			if next.IsZero() {
+				slog.Warn("Report schedule never fires, reports disabled", "schedule", p.currentConfig().ReportSchedule.String())
+				return
+			}
+			timer := time.NewTimer(time.Until(next))
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return
+			case end := <-timer.C:
+				report := BuildReport(p.history.GetRecords(), start, end)
+				scheduler.deliver(ctx, p.currentConfig(), report)
+				start = end
+			}
+		}
+	}()
+	return scheduler
+}
+
+// stop cancels the schedule and waits for a report being delivered
+func (s *reportScheduler) stop() {
+	s.cancel()
+	s.wg.Wait()
+}
+
+// deliver writes the report to the report directory and sends it to every notifier
+func (s *reportScheduler) deliver(ctx context.Context, config *Config, report Report) {
+	formats := config.ReportFormats
+	if len(formats) == 0 {
+		formats = []string{ReportMarkdown}
+	}
+
+	if config.ReportDir != "" {
+		if err := os.MkdirAll(config.ReportDir, 0755); err != nil {
+			slog.Error("Error creating report directory", "error", err)
+		} else {
+			for _, format := range formats {
+				data, err := RenderReport(report, format)
+				if err != nil {
+					slog.Error("Error rendering report", "format", format, "error", err)
+					continue
+				}
+				name := fmt.Sprintf("netkit-report-%s.%s", report.WindowEnd.UTC().Format("20060102-150405"), reportFileExtensions[format])
+				if err := os.WriteFile(filepath.Join(config.ReportDir, name), data, 0644); err != nil {
+					slog.Warn("Error writing report", "error", err)
+				}
+			}
+		}
+	}
+
+	for _, target := range config.ReportNotify {
+		if err := s.notify(ctx, target, report); err != nil {
+			slog.Error("Error sending report", "target", redactURL(target), "error", err)
+		}
+	}
+}
+
+// notify POSTs the report to a notifier URL: Slack incoming webhooks receive
+// the Markdown rendering as a message, anything else receives the JSON report
+func (s *reportScheduler) notify(ctx context.Context, target string, report Report) error {
+	var body []byte
+	var err error
+	if isSlackWebhook(target) {
+		body, err = json.Marshal(map[string]string{"text": string(renderReportMarkdown(report))})
+	} else {
+		body, err = RenderReport(report, ReportJSON)
+	}
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, target, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := resp.Body.Close(); err != nil {
+			slog.Warn("Error closing notifier response body", "error", err)
+		}
+	}()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("notifier returned %s", resp.Status)
+	}
+	return nil
+}
+
+func isSlackWebhook(target string) bool {
+	u, err := url.Parse(target)
+	return err == nil && u.Host == "hooks.slack.com"
+}
+
+// redactURL drops the path and query of a notifier URL, which often hold its secret
+func redactURL(target string) string {
+	u, err := url.Parse(target)
+	if err != nil {
+		return "notifier"
+	}
+	return u.Scheme + "://" + u.Host
+}
+
+// handleReport renders an on-demand report over a trailing window
+func (p *Proxy) handleReport(w http.ResponseWriter, r *http.Request) {
+	// Add CORS headers
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, Cache-Control, Pragma, Expires")
+
+	// Handle preflight requests
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	window := 24 * time.Hour
+	if value := r.URL.Query().Get("window"); value != "" {
+		var err error
+		window, err = time.ParseDuration(value)
+		if err != nil || window <= 0 {
+			http.Error(w, "Invalid window duration", http.StatusBadRequest)
+			return
+		}
+	}
+
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = ReportJSON
+	}
+	if err := ValidateReportFormat(format); err != nil {
+		http.Error(w, "Invalid format: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	end := time.Now()
+	data, err := RenderReport(BuildReport(p.history.GetRecords(), end.Add(-window), end), format)
+	if err != nil {
+		http.Error(w, "Failed to render report", http.StatusInternalServerError)
+		return
+	}
+
+	switch format {
+	case ReportHTML:
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	case ReportMarkdown:
+		w.Header().Set("Content-Type", "text/markdown; charset=utf-8")
+	default:
+		w.Header().Set("Content-Type", "application/json")
+	}
+	w.WriteHeader(http.StatusOK)
+	if _, err := w.Write(data); err != nil {
+		slog.Warn("Error writing report response", "error", err)
+	}
+}
@@ -0,0 +1,202 @@
+//go:build unit
+
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func reportRecords(start time.Time) []RequestRecord {
+	var records []RequestRecord
+	for i := 0; i < 20; i++ {
+		records = append(records, RequestRecord{
+			ID:              fmt.Sprintf("ok-%d", i),
+			Timestamp:       start.Add(time.Duration(i) * time.Minute),
+			Method:          http.MethodGet,
+			URL:             fmt.Sprintf("http://api.example.com/users/%d", i),
+			ResponseStatus:  http.StatusOK,
+			Success:         true,
+			TotalDurationUs: int64(i+1) * 1000,
+			RequestSize:     10,
+			ResponseSize:    100,
+		})
+	}
+	for i := 0; i < 5; i++ {
+		records = append(records, RequestRecord{
+			ID:              fmt.Sprintf("err-%d", i),
+			Timestamp:       start.Add(time.Duration(30+i) * time.Minute),
+			Method:          http.MethodPost,
+			URL:             "http://api.example.com/orders",
+			ResponseStatus:  http.StatusInternalServerError,
+			ResponseBody:    `{"error": "database timeout"}`,
+			Success:         true,
+			TotalDurationUs: 50000,
+		})
+	}
+	// Outside the window
+	records = append(records, RequestRecord{ID: "late", Timestamp: start.Add(2 * time.Hour), Method: http.MethodGet, URL: "http://late.example.com/"})
+
+	// History is most recent first
+	for i, j := 0, len(records)-1; i < j; i, j = i+1, j-1 {
+		records[i], records[j] = records[j], records[i]
+	}
+	return records
+}
+
+func TestBuildReport(t *testing.T) {
+	start := time.Date(2024, 1, 15, 0, 0, 0, 0, time.UTC)
+	report := BuildReport(reportRecords(start), start, start.Add(time.Hour))
+
+	assert.Equal(t, 25, report.TotalRequests)
+	assert.Equal(t, 5, report.ErrorCount)
+	assert.InDelta(t, 0.2, report.ErrorRate, 0.0001)
+	assert.Equal(t, int64(200), report.RequestBytes)
+	assert.Equal(t, int64(2000), report.ResponseBytes)
+	assert.Equal(t, int64(13000), report.P50DurationUs)
+	assert.Equal(t, int64(50000), report.P95DurationUs)
+
+	require.Len(t, report.TopEndpoints, 2)
+	users := report.TopEndpoints[0]
+	assert.Equal(t, "api.example.com/users/{id}", users.Route)
+	assert.Equal(t, 20, users.Count)
+	assert.Equal(t, 0, users.ErrorCount)
+	assert.Equal(t, int64(10500), users.AvgDurationUs)
+	assert.Equal(t, int64(19000), users.P95DurationUs)
+	assert.Equal(t, 5, report.TopEndpoints[1].ErrorCount)
+
+	require.Len(t, report.ErrorClusters, 1)
+	assert.Equal(t, 5, report.ErrorClusters[0].Count)
+	assert.Equal(t, "error=database timeout", report.ErrorClusters[0].Signature)
+
+	// An hour is split into 5 minute buckets
+	require.Len(t, report.LatencyTrend, 13)
+	assert.Equal(t, start, report.LatencyTrend[0].Start)
+	assert.Equal(t, 5, report.LatencyTrend[0].Count)
+	assert.Equal(t, int64(3000), report.LatencyTrend[0].P50DurationUs)
+	assert.Equal(t, 5, report.LatencyTrend[6].ErrorCount)
+}
+
+func TestBuildReportEmpty(t *testing.T) {
+	start := time.Now()
+	report := BuildReport(nil, start, start.Add(time.Hour))
+	assert.Equal(t, 0, report.TotalRequests)
+
+	data, err := RenderReport(report, ReportJSON)
+	require.NoError(t, err)
+	assert.Contains(t, string(data), `"top_endpoints": []`)
+}
+
+func TestRenderReport(t *testing.T) {
+	start := time.Date(2024, 1, 15, 0, 0, 0, 0, time.UTC)
+	report := BuildReport(reportRecords(start), start, start.Add(time.Hour))
+
+	markdown, err := RenderReport(report, ReportMarkdown)
+	require.NoError(t, err)
+	assert.Contains(t, string(markdown), "- Errors: 5 (20.0%)")
+	assert.Contains(t, string(markdown), "| `GET api.example.com/users/{id}` | 20 | 0 | 10.5ms | 19ms |")
+	assert.Contains(t, string(markdown), "| 500 | `POST api.example.com/orders` | error=database timeout | 5 |")
+
+	html, err := RenderReport(report, ReportHTML)
+	require.NoError(t, err)
+	assert.Contains(t, string(html), "<td>GET api.example.com/users/{id}</td>")
+
+	var decoded Report
+	data, err := RenderReport(report, ReportJSON)
+	require.NoError(t, err)
+	require.NoError(t, json.Unmarshal(data, &decoded))
+	assert.Equal(t, report.TotalRequests, decoded.TotalRequests)
+
+	_, err = RenderReport(report, "pdf")
+	assert.Error(t, err)
+}
+
+func TestScheduledReports(t *testing.T) {
+	notified := make(chan Report, 10)
+	notifier := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var report Report
+		if err := json.NewDecoder(r.Body).Decode(&report); err != nil {
+			t.Errorf("Error decoding report: %v", err)
+		}
+		notified <- report
+	}))
+	defer notifier.Close()
+
+	dir := t.TempDir()
+	p := New(&Config{
+		ReportSchedule: &CronSchedule{every: 50 * time.Millisecond},
+		ReportFormats:  []string{ReportMarkdown, ReportHTML},
+		ReportDir:      dir,
+		ReportNotify:   []string{notifier.URL},
+	})
+	p.history.AddRecord(RequestRecord{ID: "a", Timestamp: time.Now(), Method: http.MethodGet, URL: "http://example.com/", Success: true, ResponseStatus: 200})
+
+	var report Report
+	select {
+	case report = <-notified:
+	case <-time.After(2 * time.Second):
+		t.Fatal("No report delivered")
+	}
+	require.NoError(t, p.Stop())
+	assert.Equal(t, 1, report.TotalRequests)
+
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	var extensions []string
+	for _, entry := range entries {
+		require.True(t, strings.HasPrefix(entry.Name(), "netkit-report-"))
+		extensions = append(extensions, filepath.Ext(entry.Name()))
+	}
+	assert.Contains(t, extensions, ".md")
+	assert.Contains(t, extensions, ".html")
+}
+
+func TestNotifySlack(t *testing.T) {
+	var received map[string]string
+	slack := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+		require.NoError(t, json.Unmarshal(body, &received))
+	}))
+	defer slack.Close()
+
+	// Send requests for the Slack hostname to the test server
+	transport := &http.Transport{Proxy: func(*http.Request) (*url.URL, error) { return url.Parse(slack.URL) }}
+	scheduler := &reportScheduler{client: &http.Client{Transport: transport}}
+
+	report := BuildReport(nil, time.Now().Add(-time.Hour), time.Now())
+	require.NoError(t, scheduler.notify(context.Background(), "http://hooks.slack.com/services/T/B/x", report))
+	assert.True(t, strings.HasPrefix(received["text"], "# Netkit traffic report"))
+}
+
+func TestHandleReport(t *testing.T) {
+	p := New(&Config{})
+	p.history.AddRecord(RequestRecord{ID: "a", Timestamp: time.Now(), Method: http.MethodGet, URL: "http://example.com/", Success: true, ResponseStatus: 200})
+
+	rec := httptest.NewRecorder()
+	p.handleReport(rec, httptest.NewRequest(http.MethodGet, "/requests/report?window=1h&format=markdown", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "text/markdown; charset=utf-8", rec.Header().Get("Content-Type"))
+	assert.Contains(t, rec.Body.String(), "- Requests: 1")
+
+	rec = httptest.NewRecorder()
+	p.handleReport(rec, httptest.NewRequest(http.MethodGet, "/requests/report?window=-1h", nil))
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	rec = httptest.NewRecorder()
+	p.handleReport(rec, httptest.NewRequest(http.MethodGet, "/requests/report?format=pdf", nil))
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+}
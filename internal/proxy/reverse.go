@@ -0,0 +1,140 @@
+package proxy
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Host header handling for reverse routes
+const (
+	HostRewrite  = "rewrite"  // Send the upstream's host (default)
+	HostPreserve = "preserve" // Send the client's original Host
+)
+
+// Absolute-form request URI handling for reverse routes
+const (
+	AbsoluteURIRoute   = "route"   // Route by the URI's authority, which takes precedence over the Host header (default)
+	AbsoluteURIForward = "forward" // Forward-proxy the request to the URI instead of the route's upstream
+	AbsoluteURIReject  = "reject"  // Reject absolute-form requests with 400
+)
+
+// ReverseRoute forwards requests arriving for a host/path to a fixed upstream,
+// letting netkit sit in front of a service instead of being configured as a client proxy
+type ReverseRoute struct {
+	Route       Route
+	Upstream    *url.URL
+	Host        string // HostRewrite, HostPreserve, or a literal Host header value
+	Forwarded   bool   // Set X-Forwarded-Host, X-Forwarded-Proto, and X-Forwarded-For
+	AbsoluteURI string // AbsoluteURIRoute, AbsoluteURIForward, or AbsoluteURIReject
+}
+
+// ParseReverseRoute parses a reverse route in "route=upstream[,option...]" form, e.g.
+// "app.local/=http://10.0.0.5:8080,host=preserve,forwarded". Options are
+// host=rewrite|preserve|<value>, forwarded, and absolute=route|forward|reject.
+func ParseReverseRoute(spec string) (ReverseRoute, error) {
+	route, rest, ok := strings.Cut(spec, "=")
+	if !ok {
+		return ReverseRoute{}, fmt.Errorf("invalid reverse route %q: expected route=upstream", spec)
+	}
+
+	parts := strings.Split(rest, ",")
+	upstream, err := url.Parse(strings.TrimSpace(parts[0]))
+	if err != nil || upstream.Host == "" || (upstream.Scheme != "http" && upstream.Scheme != "https") {
+		return ReverseRoute{}, fmt.Errorf("invalid reverse route %q: upstream must be an http:// or https:// URL", spec)
+	}
+
+	rt := ReverseRoute{
+		Route:       ParseRoute(route),
+		Upstream:    upstream,
+		Host:        HostRewrite,
+		AbsoluteURI: AbsoluteURIRoute,
+	}
+	for _, option := range parts[1:] {
+		key, value, _ := strings.Cut(strings.TrimSpace(option), "=")
+		switch key {
+		case "host":
+			if value == "" {
+				return ReverseRoute{}, fmt.Errorf("invalid reverse route %q: host option needs a value", spec)
+			}
+			rt.Host = value
+		case "forwarded":
+			rt.Forwarded = true
+		case "absolute":
+			switch value {
+			case AbsoluteURIRoute, AbsoluteURIForward, AbsoluteURIReject:
+				rt.AbsoluteURI = value
+			default:
+				return ReverseRoute{}, fmt.Errorf("invalid reverse route %q: absolute must be route, forward, or reject", spec)
+			}
+		default:
+			return ReverseRoute{}, fmt.Errorf("invalid reverse route %q: unknown option %q", spec, key)
+		}
+	}
+	return rt, nil
+}
+
+// matchReverseRoute returns the first reverse route for the request along with the
+// URL the client asked for. For absolute-form requests the URI's authority is used
+// as the requested host.
+func (p *Proxy) matchReverseRoute(r *http.Request) (*ReverseRoute, *url.URL) {
+	if len(p.currentConfig().ReverseRoutes) == 0 {
+		return nil, nil
+	}
+
+	incoming := *r.URL
+	if !r.URL.IsAbs() {
+		incoming.Scheme = "http"
+		if r.TLS != nil {
+			incoming.Scheme = "https"
+		}
+		incoming.Host = r.Host
+	}
+
+	for i := range p.currentConfig().ReverseRoutes {
+		rt := &p.currentConfig().ReverseRoutes[i]
+		if !rt.Route.Matches(&incoming) {
+			continue
+		}
+		if r.URL.IsAbs() && rt.AbsoluteURI == AbsoluteURIForward {
+			return nil, nil
+		}
+		return rt, &incoming
+	}
+	return nil, nil
+}
+
+// target maps the requested URL onto the route's upstream
+func (rt *ReverseRoute) target(incoming *url.URL) *url.URL {
+	target := *rt.Upstream
+	target.Path = strings.TrimSuffix(rt.Upstream.Path, "/") + incoming.Path
+	target.RawPath = ""
+	target.RawQuery = incoming.RawQuery
+	return &target
+}
+
+// applyHeaders sets the Host and X-Forwarded-* headers on the upstream request
+func (rt *ReverseRoute) applyHeaders(proxyReq, r *http.Request, incoming *url.URL) {
+	switch rt.Host {
+	case HostRewrite:
+		proxyReq.Host = rt.Upstream.Host
+	case HostPreserve:
+		proxyReq.Host = incoming.Host
+	default:
+		proxyReq.Host = rt.Host
+	}
+
+	if !rt.Forwarded {
+		return
+	}
+	proxyReq.Header.Set("X-Forwarded-Host", incoming.Host)
+	proxyReq.Header.Set("X-Forwarded-Proto", incoming.Scheme)
+	if clientIP, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		if prior := r.Header.Get("X-Forwarded-For"); prior != "" {
+			clientIP = prior + ", " + clientIP
+		}
+		proxyReq.Header.Set("X-Forwarded-For", clientIP)
+	}
+}
@@ -0,0 +1,182 @@
+//go:build unit
+
+package proxy
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newHeaderEchoUpstream returns the Host, path, and forwarding headers it received as JSON
+func newHeaderEchoUpstream(t *testing.T) *httptest.Server {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		err := json.NewEncoder(w).Encode(map[string]string{
+			"host": r.Host,
+			"uri":  r.URL.RequestURI(),
+			"xfh":  r.Header.Get("X-Forwarded-Host"),
+			"xfp":  r.Header.Get("X-Forwarded-Proto"),
+			"xff":  r.Header.Get("X-Forwarded-For"),
+		})
+		if err != nil {
+			t.Logf("Error writing response: %v", err)
+		}
+	}))
+	t.Cleanup(upstream.Close)
+	return upstream
+}
+
+func serveReverse(t *testing.T, p *Proxy, req *http.Request) (*httptest.ResponseRecorder, map[string]string) {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	p.ServeHTTP(rec, req)
+	var seen map[string]string
+	if rec.Code == http.StatusOK {
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &seen))
+	}
+	return rec, seen
+}
+
+func TestParseReverseRoute(t *testing.T) {
+	rt, err := ParseReverseRoute("app.local/api=http://10.0.0.5:8080/base,host=preserve,forwarded,absolute=reject")
+	require.NoError(t, err)
+	assert.Equal(t, Route{Host: "app.local", PathPrefix: "/api"}, rt.Route)
+	assert.Equal(t, "http://10.0.0.5:8080/base", rt.Upstream.String())
+	assert.Equal(t, HostPreserve, rt.Host)
+	assert.True(t, rt.Forwarded)
+	assert.Equal(t, AbsoluteURIReject, rt.AbsoluteURI)
+
+	rt, err = ParseReverseRoute("*=https://backend")
+	require.NoError(t, err)
+	assert.Equal(t, HostRewrite, rt.Host)
+	assert.False(t, rt.Forwarded)
+	assert.Equal(t, AbsoluteURIRoute, rt.AbsoluteURI)
+
+	for _, spec := range []string{
+		"app.local",
+		"app.local=backend:8080",
+		"app.local=ftp://backend",
+		"app.local=http://backend,host",
+		"app.local=http://backend,absolute=ignore",
+		"app.local=http://backend,bogus",
+	} {
+		_, err := ParseReverseRoute(spec)
+		assert.Error(t, err, spec)
+	}
+}
+
+func TestReverseHostRewrite(t *testing.T) {
+	upstream := newHeaderEchoUpstream(t)
+	rt, err := ParseReverseRoute("app.local=" + upstream.URL + "/base")
+	require.NoError(t, err)
+	p := New(&Config{ReverseRoutes: []ReverseRoute{rt}})
+
+	req := httptest.NewRequest(http.MethodGet, "/users?id=7", nil)
+	req.Host = "app.local"
+	rec, seen := serveReverse(t, p, req)
+
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, upstream.Listener.Addr().String(), seen["host"])
+	assert.Equal(t, "/base/users?id=7", seen["uri"])
+	assert.Empty(t, seen["xfh"], "forwarding headers are opt-in")
+
+	records := p.history.GetRecords()
+	require.Len(t, records, 1)
+	assert.Equal(t, upstream.URL+"/base/users?id=7", records[0].URL)
+	assert.Equal(t, "app.local", records[0].ReverseRoute)
+}
+
+func TestReverseHostPreserveAndForwarded(t *testing.T) {
+	upstream := newHeaderEchoUpstream(t)
+	rt, err := ParseReverseRoute("app.local=" + upstream.URL + ",host=preserve,forwarded")
+	require.NoError(t, err)
+	p := New(&Config{ReverseRoutes: []ReverseRoute{rt}})
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Host = "app.local:8080"
+	req.RemoteAddr = "192.0.2.10:5555"
+	req.Header.Set("X-Forwarded-For", "198.51.100.1")
+	_, seen := serveReverse(t, p, req)
+
+	assert.Equal(t, "app.local:8080", seen["host"])
+	assert.Equal(t, "app.local:8080", seen["xfh"])
+	assert.Equal(t, "http", seen["xfp"])
+	assert.Equal(t, "198.51.100.1, 192.0.2.10", seen["xff"])
+}
+
+func TestReverseHostOverride(t *testing.T) {
+	upstream := newHeaderEchoUpstream(t)
+	rt, err := ParseReverseRoute("*=" + upstream.URL + ",host=tenant-a.internal")
+	require.NoError(t, err)
+	p := New(&Config{ReverseRoutes: []ReverseRoute{rt}})
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Host = "anything.example"
+	_, seen := serveReverse(t, p, req)
+
+	assert.Equal(t, "tenant-a.internal", seen["host"])
+}
+
+func TestReverseAbsoluteURI(t *testing.T) {
+	upstream := newHeaderEchoUpstream(t)
+
+	t.Run("route by URI authority", func(t *testing.T) {
+		rt, err := ParseReverseRoute("app.local=" + upstream.URL + ",host=preserve")
+		require.NoError(t, err)
+		p := New(&Config{ReverseRoutes: []ReverseRoute{rt}})
+
+		// The Go server sets r.Host from an absolute-form URI, overriding the Host header
+		req := httptest.NewRequest(http.MethodGet, "http://app.local/path", nil)
+		req.Header.Set("Host", "ignored.example")
+		rec, seen := serveReverse(t, p, req)
+
+		require.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, "app.local", seen["host"])
+		assert.Equal(t, "/path", seen["uri"])
+	})
+
+	t.Run("forward", func(t *testing.T) {
+		rt, err := ParseReverseRoute("*=http://unused.invalid,absolute=forward")
+		require.NoError(t, err)
+		p := New(&Config{ReverseRoutes: []ReverseRoute{rt}})
+
+		req := httptest.NewRequest(http.MethodGet, upstream.URL+"/direct", nil)
+		rec, seen := serveReverse(t, p, req)
+
+		require.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, "/direct", seen["uri"])
+		assert.Empty(t, p.history.GetRecords()[0].ReverseRoute)
+	})
+
+	t.Run("reject", func(t *testing.T) {
+		rt, err := ParseReverseRoute("*=" + upstream.URL + ",absolute=reject")
+		require.NoError(t, err)
+		p := New(&Config{ReverseRoutes: []ReverseRoute{rt}})
+
+		req := httptest.NewRequest(http.MethodGet, "http://app.local/path", nil)
+		rec, _ := serveReverse(t, p, req)
+
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+		records := p.history.GetRecords()
+		require.Len(t, records, 1)
+		assert.False(t, records[0].Success)
+	})
+}
+
+func TestReverseRouteNoMatch(t *testing.T) {
+	upstream := newHeaderEchoUpstream(t)
+	rt, err := ParseReverseRoute("app.local/api=http://unused.invalid")
+	require.NoError(t, err)
+	p := New(&Config{ReverseRoutes: []ReverseRoute{rt}})
+
+	// Non-matching absolute-form requests are still forward-proxied
+	req := httptest.NewRequest(http.MethodGet, upstream.URL+"/other", nil)
+	rec, seen := serveReverse(t, p, req)
+
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "/other", seen["uri"])
+}
@@ -0,0 +1,55 @@
+package proxy
+
+import (
+	"net/url"
+	"strings"
+)
+
+// Route matches proxied requests by destination host and path prefix.
+// An empty field matches anything.
+type Route struct {
+	Host       string `json:"host,omitempty"`
+	PathPrefix string `json:"path_prefix,omitempty"`
+}
+
+// ParseRoute parses a route in "host/path/prefix" form. A leading "*" or an
+// empty host matches any host, e.g. "*/api" or "/api".
+func ParseRoute(s string) Route {
+	s = strings.TrimPrefix(strings.TrimPrefix(s, "http://"), "https://")
+
+	host, path := s, ""
+	if i := strings.Index(s, "/"); i >= 0 {
+		host, path = s[:i], s[i:]
+	}
+	if host == "*" {
+		host = ""
+	}
+	return Route{Host: strings.ToLower(host), PathPrefix: path}
+}
+
+// Matches reports whether the URL falls under the route
+func (rt Route) Matches(u *url.URL) bool {
+	if rt.Host != "" && !strings.EqualFold(rt.Host, u.Host) && !strings.EqualFold(rt.Host, u.Hostname()) {
+		return false
+	}
+	return strings.HasPrefix(u.Path, rt.PathPrefix)
+}
+
+// matchesSubdomains is Matches with a host of "*.example.com" also matching
+// example.com and every subdomain of it
+func (rt Route) matchesSubdomains(u *url.URL) bool {
+	if suffix, ok := strings.CutPrefix(rt.Host, "*."); ok {
+		host := strings.ToLower(u.Hostname())
+		return (host == suffix || strings.HasSuffix(host, "."+suffix)) && strings.HasPrefix(u.Path, rt.PathPrefix)
+	}
+	return rt.Matches(u)
+}
+
+// String returns the route in the form accepted by ParseRoute
+func (rt Route) String() string {
+	host := rt.Host
+	if host == "" {
+		host = "*"
+	}
+	return host + rt.PathPrefix
+}
@@ -0,0 +1,39 @@
+//go:build unit
+
+package proxy
+
+import (
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRouteMatches(t *testing.T) {
+	tests := []struct {
+		route string
+		url   string
+		want  bool
+	}{
+		{"api.example.com/v1", "http://api.example.com/v1/users", true},
+		{"api.example.com/v1", "http://api.example.com:8080/v1/users", true},
+		{"api.example.com:8080/v1", "http://api.example.com:8080/v1", true},
+		{"API.example.com", "http://api.example.com/anything", true},
+		{"api.example.com/v1", "http://api.example.com/v2", false},
+		{"api.example.com", "http://other.example.com/", false},
+		{"*/health", "http://any.host/health", true},
+		{"/health", "http://any.host/healthz", true},
+		{"https://secure.example.com/pay", "https://secure.example.com/pay/1", true},
+	}
+
+	for _, tt := range tests {
+		u, err := url.Parse(tt.url)
+		assert.NoError(t, err)
+		assert.Equal(t, tt.want, ParseRoute(tt.route).Matches(u), "%s vs %s", tt.route, tt.url)
+	}
+}
+
+func TestRouteString(t *testing.T) {
+	assert.Equal(t, "*/api", ParseRoute("/api").String())
+	assert.Equal(t, "example.com/api", ParseRoute("example.com/api").String())
+}
@@ -0,0 +1,463 @@
+package proxy
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ruleBundleLimit bounds the size of a rule bundle fetched or uploaded
+const ruleBundleLimit = 4 << 20
+
+// ruleBundleNamePattern keeps bundle names safe to use as file names
+var ruleBundleNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9._-]{0,63}$`)
+
+// ruleSettingPattern matches serve flag names
+var ruleSettingPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9-]*$`)
+
+// ruleBundleSettings are the serve flags a rule bundle may add values to:
+// routing, throttling, and capture rules. Flags that run commands, send
+// records elsewhere, or change what the proxy trusts, such as tee, sink,
+// rules-key, admin-origin, ssh-jump, or dns, are left out.
+var ruleBundleSettings = map[string]bool{
+	"reverse":              true,
+	"throttle":             true,
+	"latency-profile":      true,
+	"capture-policy":       true,
+	"capture-body-type":    true,
+	"redact-header":        true,
+	"redact-body":          true,
+	"xml-redact":           true,
+	"preserve-header-case": true,
+}
+
+// RuleBundle is a pack of serve rules teams can share, such as "staging
+// rewrites" or "block trackers": values for repeatable serve flags like
+// reverse, throttle, or capture-policy, and optionally stateful behaviors for
+// netkit mock
+type RuleBundle struct {
+	Name        string              `json:"name"`
+	Version     string              `json:"version,omitempty"`
+	Description string              `json:"description,omitempty"`
+	Settings    map[string][]string `json:"settings,omitempty"`  // Flag name to the values added for it
+	Behaviors   json.RawMessage     `json:"behaviors,omitempty"` // As read by netkit mock --behaviors
+}
+
+// SignedRuleBundle is a rule bundle as it is distributed: its JSON with a
+// checksum and an Ed25519 signature over it
+type SignedRuleBundle struct {
+	Bundle    string `json:"bundle"`              // Base64 of the bundle's JSON
+	SHA256    string `json:"sha256"`              // Hex SHA-256 of the bundle's JSON
+	Signature string `json:"signature,omitempty"` // Base64 Ed25519 signature of the bundle's JSON
+}
+
+// InstalledRuleBundle is a verified rule bundle kept in the rules directory
+type InstalledRuleBundle struct {
+	RuleBundle
+	SHA256        string    `json:"sha256"`
+	SignedBy      string    `json:"signed_by,omitempty"` // ID of the trusted key that signed it
+	Source        string    `json:"source,omitempty"`    // URL the bundle was fetched from
+	InstalledAt   time.Time `json:"installed_at"`
+	BehaviorsFile string    `json:"behaviors_file,omitempty"` // Mock behaviors written out for netkit mock --behaviors
+}
+
+// ParseRulesKey parses a base64 Ed25519 public key trusted to sign rule bundles
+func ParseRulesKey(value string) (ed25519.PublicKey, error) {
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(value))
+	if err != nil {
+		return nil, fmt.Errorf("invalid key %q: %v", value, err)
+	}
+	if len(key) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("invalid key %q: expected a %d-byte Ed25519 public key", value, ed25519.PublicKeySize)
+	}
+	return ed25519.PublicKey(key), nil
+}
+
+// RulesKeyID identifies a rule signing key by the start of its SHA-256
+func RulesKeyID(key ed25519.PublicKey) string {
+	sum := sha256.Sum256(key)
+	return hex.EncodeToString(sum[:8])
+}
+
+// Validate checks a bundle's name, that it has something to install, and
+// that it only sets rule flags
+func (b RuleBundle) Validate() error {
+	if !ruleBundleNamePattern.MatchString(b.Name) {
+		return fmt.Errorf("invalid bundle name %q (lowercase letters, digits, '.', '_', and '-', up to 64 characters)", b.Name)
+	}
+	if len(b.Settings) == 0 && len(b.Behaviors) == 0 {
+		return fmt.Errorf("bundle %q has no settings or behaviors", b.Name)
+	}
+	for name, values := range b.Settings {
+		if !ruleSettingPattern.MatchString(name) {
+			return fmt.Errorf("bundle %q has an invalid setting %q", b.Name, name)
+		}
+		if !ruleBundleSettings[name] {
+			return fmt.Errorf("bundle %q sets %q, which rule bundles may not set", b.Name, name)
+		}
+		if len(values) == 0 {
+			return fmt.Errorf("bundle %q has no values for %q", b.Name, name)
+		}
+	}
+	return nil
+}
+
+// SignRuleBundle encodes a bundle for distribution, signed with key
+func SignRuleBundle(bundle RuleBundle, key ed25519.PrivateKey) (SignedRuleBundle, error) {
+	if err := bundle.Validate(); err != nil {
+		return SignedRuleBundle{}, err
+	}
+	data, err := json.Marshal(bundle)
+	if err != nil {
+		return SignedRuleBundle{}, err
+	}
+	sum := sha256.Sum256(data)
+	return SignedRuleBundle{
+		Bundle:    base64.StdEncoding.EncodeToString(data),
+		SHA256:    hex.EncodeToString(sum[:]),
+		Signature: base64.StdEncoding.EncodeToString(ed25519.Sign(key, data)),
+	}, nil
+}
+
+// VerifyRuleBundle decodes a distributed bundle after checking its checksum
+// and its signature. A bundle must be signed by one of the trusted keys; the
+// expected checksum, when given, pins it to one version on top of that.
+func VerifyRuleBundle(signed SignedRuleBundle, trusted []ed25519.PublicKey, checksum string) (InstalledRuleBundle, error) {
+	data, err := base64.StdEncoding.DecodeString(signed.Bundle)
+	if err != nil {
+		return InstalledRuleBundle{}, fmt.Errorf("bundle is not base64: %v", err)
+	}
+	sum := sha256.Sum256(data)
+	actual := hex.EncodeToString(sum[:])
+	if !strings.EqualFold(actual, signed.SHA256) {
+		return InstalledRuleBundle{}, fmt.Errorf("checksum mismatch: bundle has sha256 %s, expected %s", actual, signed.SHA256)
+	}
+	if checksum != "" && !strings.EqualFold(actual, checksum) {
+		return InstalledRuleBundle{}, fmt.Errorf("checksum mismatch: bundle has sha256 %s, expected %s", actual, checksum)
+	}
+
+	installed := InstalledRuleBundle{SHA256: actual}
+	if signature, err := base64.StdEncoding.DecodeString(signed.Signature); err == nil && len(signature) > 0 {
+		for _, key := range trusted {
+			if ed25519.Verify(key, data, signature) {
+				installed.SignedBy = RulesKeyID(key)
+				break
+			}
+		}
+	}
+	if installed.SignedBy == "" {
+		return InstalledRuleBundle{}, errors.New("bundle is not signed by a trusted key")
+	}
+
+	if err := json.Unmarshal(data, &installed.RuleBundle); err != nil {
+		return InstalledRuleBundle{}, fmt.Errorf("invalid bundle JSON: %v", err)
+	}
+	if err := installed.Validate(); err != nil {
+		return InstalledRuleBundle{}, err
+	}
+	return installed, nil
+}
+
+// LoadRuleBundles reads the bundles installed in dir, sorted by name
+func LoadRuleBundles(dir string) ([]InstalledRuleBundle, error) {
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read rules directory: %v", err)
+	}
+
+	var bundles []InstalledRuleBundle
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(name, ".json") || strings.HasSuffix(name, ".behaviors.json") {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read rule bundle %s: %v", name, err)
+		}
+		var bundle InstalledRuleBundle
+		if err := json.Unmarshal(data, &bundle); err != nil {
+			return nil, fmt.Errorf("failed to parse rule bundle %s: %v", name, err)
+		}
+		if err := bundle.Validate(); err != nil {
+			return nil, fmt.Errorf("invalid rule bundle %s: %v", name, err)
+		}
+		bundles = append(bundles, bundle)
+	}
+	sort.Slice(bundles, func(i, j int) bool { return bundles[i].Name < bundles[j].Name })
+	return bundles, nil
+}
+
+// installRuleBundle writes a bundle and its mock behaviors to dir, replacing
+// an installed bundle with the same name. The returned function puts back
+// what was there before.
+func installRuleBundle(dir string, bundle *InstalledRuleBundle) (func(), error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create rules directory: %v", err)
+	}
+	path := filepath.Join(dir, bundle.Name+".json")
+	behaviorsPath := filepath.Join(dir, bundle.Name+".behaviors.json")
+	previous, previousErr := os.ReadFile(path)
+	previousBehaviors, previousBehaviorsErr := os.ReadFile(behaviorsPath)
+	restore := func() {
+		restoreFile(path, previous, previousErr)
+		restoreFile(behaviorsPath, previousBehaviors, previousBehaviorsErr)
+	}
+
+	bundle.BehaviorsFile = ""
+	if len(bundle.Behaviors) > 0 {
+		bundle.BehaviorsFile = behaviorsPath
+		if err := writeFileAtomic(behaviorsPath, bundle.Behaviors, 0644); err != nil {
+			restore()
+			return nil, fmt.Errorf("failed to write mock behaviors: %v", err)
+		}
+	} else {
+		os.Remove(behaviorsPath)
+	}
+	data, err := json.MarshalIndent(bundle, "", "  ")
+	if err == nil {
+		err = writeFileAtomic(path, data, 0644)
+	}
+	if err != nil {
+		restore()
+		return nil, fmt.Errorf("failed to write rule bundle: %v", err)
+	}
+	return restore, nil
+}
+
+// removeRuleBundle deletes an installed bundle, reporting whether it existed
+func removeRuleBundle(dir, name string) (bool, error) {
+	if !ruleBundleNamePattern.MatchString(name) {
+		return false, nil
+	}
+	err := os.Remove(filepath.Join(dir, name+".json"))
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	os.Remove(filepath.Join(dir, name+".behaviors.json"))
+	return true, nil
+}
+
+// restoreFile puts back the contents path had before an install, or removes
+// it when reading it failed because it did not exist
+func restoreFile(path string, data []byte, readErr error) {
+	if readErr != nil {
+		os.Remove(path)
+		return
+	}
+	writeFileAtomic(path, data, 0644)
+}
+
+// fetchRuleBundle downloads a distributed bundle
+func fetchRuleBundle(url string) (SignedRuleBundle, error) {
+	client := &http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Get(url)
+	if err != nil {
+		return SignedRuleBundle{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return SignedRuleBundle{}, fmt.Errorf("%s responded %s", url, resp.Status)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, ruleBundleLimit+1))
+	if err != nil {
+		return SignedRuleBundle{}, err
+	}
+	if len(data) > ruleBundleLimit {
+		return SignedRuleBundle{}, fmt.Errorf("bundle is larger than %d bytes", ruleBundleLimit)
+	}
+	var signed SignedRuleBundle
+	if err := json.Unmarshal(data, &signed); err != nil {
+		return SignedRuleBundle{}, fmt.Errorf("invalid bundle JSON: %v", err)
+	}
+	return signed, nil
+}
+
+// handleRules lists and removes installed rule bundles
+func (p *Proxy) handleRules(w http.ResponseWriter, r *http.Request) {
+	// Add CORS headers
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "GET, DELETE, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, Cache-Control, Pragma, Expires")
+
+	// Handle preflight requests
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	dir := p.currentConfig().RulesDir
+	if dir == "" {
+		http.Error(w, "Rule bundles are not configured, start netkit with --rules-dir", http.StatusNotImplemented)
+		return
+	}
+
+	p.rulesMutex.Lock()
+	defer p.rulesMutex.Unlock()
+
+	var response interface{}
+	switch r.Method {
+	case http.MethodGet:
+		bundles, err := LoadRuleBundles(dir)
+		if err != nil {
+			http.Error(w, "Failed to load rule bundles", http.StatusInternalServerError)
+			return
+		}
+		if bundles == nil {
+			bundles = []InstalledRuleBundle{}
+		}
+		response = map[string]interface{}{"bundles": bundles}
+
+	case http.MethodDelete:
+		name := r.URL.Query().Get("name")
+		deleted, err := removeRuleBundle(dir, name)
+		if err != nil {
+			http.Error(w, "Failed to remove rule bundle", http.StatusInternalServerError)
+			return
+		}
+		if !deleted {
+			http.Error(w, "Rule bundle not found", http.StatusNotFound)
+			return
+		}
+		result := map[string]interface{}{"success": true, "message": "Rule bundle removed"}
+		if p.currentConfig().ConfigLoader != nil {
+			diff, err := p.ReloadConfig()
+			if err != nil {
+				http.Error(w, "Rule bundle removed, but the config did not reload: "+err.Error(), http.StatusInternalServerError)
+				return
+			}
+			result["config"] = diff
+		}
+		slog.Info("Removed rule bundle", "bundle", name)
+		response = result
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	data, err := json.Marshal(response)
+	if err != nil {
+		http.Error(w, "Failed to encode rule bundles", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if _, err := w.Write(data); err != nil {
+		slog.Warn("Error writing rule bundles response", "error", err)
+	}
+}
+
+// handleRulesImport fetches or accepts a signed rule bundle, verifies it,
+// installs it in the rules directory, and reloads the config so its rules
+// apply. A bundle whose settings do not load is not kept.
+func (p *Proxy) handleRulesImport(w http.ResponseWriter, r *http.Request) {
+	// Add CORS headers
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, Cache-Control, Pragma, Expires")
+
+	// Handle preflight requests
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	config := p.currentConfig()
+	if config.RulesDir == "" {
+		http.Error(w, "Rule bundles are not configured, start netkit with --rules-dir", http.StatusNotImplemented)
+		return
+	}
+
+	var request struct {
+		URL    string            `json:"url"`
+		Bundle *SignedRuleBundle `json:"bundle"`
+		SHA256 string            `json:"sha256"` // Expected checksum, pinning the bundle on top of its signature
+	}
+	if err := json.NewDecoder(io.LimitReader(r.Body, ruleBundleLimit)).Decode(&request); err != nil {
+		http.Error(w, "Invalid import JSON", http.StatusBadRequest)
+		return
+	}
+	if (request.URL == "") == (request.Bundle == nil) {
+		http.Error(w, "Set either url or bundle", http.StatusBadRequest)
+		return
+	}
+
+	signed := request.Bundle
+	if request.URL != "" {
+		if !strings.HasPrefix(request.URL, "https://") && !strings.HasPrefix(request.URL, "http://") {
+			http.Error(w, "Invalid url: expected http or https", http.StatusBadRequest)
+			return
+		}
+		fetched, err := fetchRuleBundle(request.URL)
+		if err != nil {
+			http.Error(w, "Failed to fetch bundle: "+err.Error(), http.StatusBadGateway)
+			return
+		}
+		signed = &fetched
+	}
+	bundle, err := VerifyRuleBundle(*signed, config.RulesKeys, request.SHA256)
+	if err != nil {
+		http.Error(w, "Invalid bundle: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	bundle.Source = request.URL
+	bundle.InstalledAt = time.Now()
+
+	p.rulesMutex.Lock()
+	defer p.rulesMutex.Unlock()
+
+	restore, err := installRuleBundle(config.RulesDir, &bundle)
+	if err != nil {
+		http.Error(w, "Failed to install bundle: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	response := map[string]interface{}{"bundle": bundle}
+	if config.ConfigLoader != nil {
+		diff, err := p.ReloadConfig()
+		if err != nil {
+			restore()
+			http.Error(w, "Invalid bundle settings, not installed: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		response["config"] = diff
+	}
+	slog.Info("Installed rule bundle", "bundle", bundle.Name, "version", bundle.Version, "sha256", bundle.SHA256, "signed_by", bundle.SignedBy)
+
+	data, err := json.Marshal(response)
+	if err != nil {
+		http.Error(w, "Failed to encode rule bundle", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	if _, err := w.Write(data); err != nil {
+		slog.Warn("Error writing rule bundle import response", "error", err)
+	}
+}
@@ -0,0 +1,220 @@
+//go:build unit
+
+package proxy
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// rulesRequest sends a request to the admin API
+func rulesRequest(p *Proxy, method, target, body string) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	p.adminServer.Handler.ServeHTTP(rec, httptest.NewRequest(method, target, strings.NewReader(body)))
+	return rec
+}
+
+func testRuleBundle() RuleBundle {
+	return RuleBundle{
+		Name:      "payments-privacy",
+		Version:   "1.0.0",
+		Settings:  map[string][]string{"capture-policy": {"*.stripe.com=metadata-only"}},
+		Behaviors: json.RawMessage(`{"sequences":[]}`),
+	}
+}
+
+func TestVerifyRuleBundle(t *testing.T) {
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	other, _, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	signed, err := SignRuleBundle(testRuleBundle(), private)
+	require.NoError(t, err)
+
+	bundle, err := VerifyRuleBundle(signed, []ed25519.PublicKey{other, public}, "")
+	require.NoError(t, err)
+	assert.Equal(t, "payments-privacy", bundle.Name)
+	assert.Equal(t, RulesKeyID(public), bundle.SignedBy)
+	assert.Equal(t, signed.SHA256, bundle.SHA256)
+
+	_, err = VerifyRuleBundle(signed, []ed25519.PublicKey{other}, "")
+	assert.ErrorContains(t, err, "not signed by a trusted key")
+
+	// A pinned checksum narrows a trusted signature but does not replace it
+	_, err = VerifyRuleBundle(signed, []ed25519.PublicKey{public}, strings.ToUpper(signed.SHA256))
+	require.NoError(t, err)
+	_, err = VerifyRuleBundle(signed, nil, signed.SHA256)
+	assert.ErrorContains(t, err, "not signed by a trusted key")
+	_, err = VerifyRuleBundle(signed, []ed25519.PublicKey{public}, strings.Repeat("0", 64))
+	assert.ErrorContains(t, err, "checksum mismatch")
+
+	// Changing the bundle breaks the checksum, and fixing the checksum breaks the signature
+	data, _ := base64.StdEncoding.DecodeString(signed.Bundle)
+	tampered := signed
+	tampered.Bundle = base64.StdEncoding.EncodeToString([]byte(strings.Replace(string(data), "metadata-only", "full", 1)))
+	_, err = VerifyRuleBundle(tampered, []ed25519.PublicKey{public}, "")
+	assert.ErrorContains(t, err, "checksum mismatch")
+	unsigned, err := SignRuleBundle(RuleBundle{Name: "payments-privacy", Settings: map[string][]string{"capture-policy": {"*.stripe.com=full"}}}, private)
+	require.NoError(t, err)
+	tampered.Bundle, tampered.SHA256 = unsigned.Bundle, unsigned.SHA256
+	_, err = VerifyRuleBundle(tampered, []ed25519.PublicKey{public}, "")
+	assert.ErrorContains(t, err, "not signed by a trusted key")
+
+	for _, invalid := range []RuleBundle{
+		{Name: "../etc", Settings: map[string][]string{"reverse": {"/a=http://b"}}},
+		{Name: "empty"},
+		{Name: "config", Settings: map[string][]string{"config": {"other.yaml"}}},
+	} {
+		_, err := SignRuleBundle(invalid, private)
+		assert.Error(t, err, invalid.Name)
+	}
+}
+
+func TestRuleBundleSettings(t *testing.T) {
+	for _, name := range []string{"reverse", "throttle", "capture-policy", "redact-header", "redact-body", "latency-profile", "xml-redact"} {
+		bundle := RuleBundle{Name: "rules", Settings: map[string][]string{name: {"x"}}}
+		assert.NoError(t, bundle.Validate(), name)
+	}
+
+	// Settings that run commands, export records, or change trust are refused
+	for _, name := range []string{"tee", "sink", "rules-key", "admin-origin", "ssh-jump", "dns", "rules-dir", "bogus"} {
+		bundle := RuleBundle{Name: "rules", Settings: map[string][]string{
+			"capture-policy": {"*=full"},
+			name:             {"*=exec:touch /tmp/pwned"},
+		}}
+		assert.ErrorContains(t, bundle.Validate(), "rule bundles may not set", name)
+	}
+}
+
+func TestLoadRuleBundlesRejectsInvalidSettings(t *testing.T) {
+	dir := t.TempDir()
+	bundle := InstalledRuleBundle{RuleBundle: RuleBundle{Name: "tee", Settings: map[string][]string{"tee": {"*=exec:sh"}}}}
+	data, err := json.Marshal(bundle)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "tee.json"), data, 0o600))
+
+	_, err = LoadRuleBundles(dir)
+	assert.ErrorContains(t, err, "rule bundles may not set")
+}
+
+func TestRulesImport(t *testing.T) {
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	signed, err := SignRuleBundle(testRuleBundle(), private)
+	require.NoError(t, err)
+	bundleServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(signed)
+	}))
+	defer bundleServer.Close()
+
+	// The loader stands in for netkit serve reading the rules directory
+	dir := filepath.Join(t.TempDir(), "rules")
+	var loadErr error
+	loads := 0
+	p := New(&Config{RulesDir: dir, RulesKeys: []ed25519.PublicKey{public}, ConfigLoader: func() (*Config, error) {
+		loads++
+		bundles, err := LoadRuleBundles(dir)
+		if err != nil || loadErr != nil {
+			return nil, errors.Join(err, loadErr)
+		}
+		next := &Config{RulesDir: dir, RulesKeys: []ed25519.PublicKey{public}}
+		for _, bundle := range bundles {
+			for _, spec := range bundle.Settings["capture-policy"] {
+				policy, err := ParseCapturePolicy(spec)
+				require.NoError(t, err)
+				next.CapturePolicies = append(next.CapturePolicies, policy)
+			}
+		}
+		return next, nil
+	}})
+
+	rec := rulesRequest(p, http.MethodPost, "/rules/import", `{"url": "`+bundleServer.URL+`"}`)
+	require.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+	var result struct {
+		Bundle InstalledRuleBundle `json:"bundle"`
+		Config ConfigDiff          `json:"config"`
+	}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &result))
+	assert.Equal(t, bundleServer.URL, result.Bundle.Source)
+	assert.Equal(t, []string{"CapturePolicies"}, result.Config.Applied)
+	assert.Equal(t, CapturePolicyMetadata, capturePolicyFor(p.currentConfig().CapturePolicies, "https://api.stripe.com/v1/charges"))
+	behaviors, err := os.ReadFile(filepath.Join(dir, "payments-privacy.behaviors.json"))
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"sequences":[]}`, string(behaviors))
+
+	rec = rulesRequest(p, http.MethodGet, "/rules", "")
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), `"signed_by":"`+RulesKeyID(public)+`"`)
+
+	// A bundle whose settings do not load leaves the installed one in place
+	loadErr = errors.New("invalid --capture-policy")
+	replacement := testRuleBundle()
+	replacement.Version = "2.0.0"
+	next, err := SignRuleBundle(replacement, private)
+	require.NoError(t, err)
+	body, _ := json.Marshal(map[string]interface{}{"bundle": next})
+	rec = rulesRequest(p, http.MethodPost, "/rules/import", string(body))
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	installed, err := LoadRuleBundles(dir)
+	require.NoError(t, err)
+	require.Len(t, installed, 1)
+	assert.Equal(t, "1.0.0", installed[0].Version)
+	loadErr = nil
+
+	rec = rulesRequest(p, http.MethodPost, "/rules/import", `{"url": "`+bundleServer.URL+`", "bundle": {}}`)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	// A checksum alone does not install a bundle without a trusted signature
+	_, untrusted, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	stranger, err := SignRuleBundle(replacement, untrusted)
+	require.NoError(t, err)
+	body, _ = json.Marshal(map[string]interface{}{"bundle": stranger, "sha256": stranger.SHA256})
+	rec = rulesRequest(p, http.MethodPost, "/rules/import", string(body))
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.Contains(t, rec.Body.String(), "not signed by a trusted key")
+
+	// Nor does a trusted signature install settings bundles may not set
+	var tee SignedRuleBundle
+	data, _ := json.Marshal(RuleBundle{Name: "tee", Settings: map[string][]string{"tee": {"*=exec:sh"}}})
+	sum := sha256.Sum256(data)
+	tee.Bundle, tee.SHA256 = base64.StdEncoding.EncodeToString(data), hex.EncodeToString(sum[:])
+	tee.Signature = base64.StdEncoding.EncodeToString(ed25519.Sign(private, data))
+	body, _ = json.Marshal(map[string]interface{}{"bundle": tee})
+	rec = rulesRequest(p, http.MethodPost, "/rules/import", string(body))
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.Contains(t, rec.Body.String(), "rule bundles may not set")
+
+	rec = rulesRequest(p, http.MethodDelete, "/rules?name=payments-privacy", "")
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Empty(t, p.currentConfig().CapturePolicies)
+	assert.NoFileExists(t, filepath.Join(dir, "payments-privacy.behaviors.json"))
+	rec = rulesRequest(p, http.MethodDelete, "/rules?name=payments-privacy", "")
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+	assert.Equal(t, 3, loads)
+
+	rec = rulesRequest(New(&Config{}), http.MethodGet, "/rules", "")
+	assert.Equal(t, http.StatusNotImplemented, rec.Code)
+}
+
+func TestRulesImportNeedsAdminScope(t *testing.T) {
+	p := New(&Config{AdminToken: "root-secret", RulesDir: t.TempDir()})
+	writer, _ := createToken(t, p, "root-secret", `{"name": "ci", "scopes": ["read", "write"]}`)
+	assert.Equal(t, http.StatusForbidden, adminRequest(p, http.MethodPost, "/rules/import", writer, `{}`).Code)
+	assert.Equal(t, http.StatusForbidden, adminRequest(p, http.MethodDelete, "/rules?name=x", writer, "").Code)
+}
@@ -0,0 +1,240 @@
+package proxy
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RunHeader carries the token of the test run a request belongs to
+const RunHeader = "X-Netkit-Run"
+
+// Run is an isolated test run context, e.g. one CI job sharing the proxy
+type Run struct {
+	ID        string    `json:"id"` // Token clients send in X-Netkit-Run
+	Name      string    `json:"name,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	Count     int       `json:"count"` // Records currently in history for the run
+}
+
+// runStore keeps the open test runs in memory
+type runStore struct {
+	mutex sync.RWMutex
+	runs  map[string]Run
+}
+
+func newRunStore() *runStore {
+	return &runStore{runs: make(map[string]Run)}
+}
+
+// Create opens a run with a new token
+func (s *runStore) Create(name string) Run {
+	run := Run{ID: generateID(), Name: name, CreatedAt: time.Now().UTC()}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.runs[run.ID] = run
+	return run
+}
+
+// Get returns the run with the given token
+func (s *runStore) Get(id string) (Run, bool) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	run, ok := s.runs[id]
+	return run, ok
+}
+
+// List returns every open run, newest first
+func (s *runStore) List() []Run {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	runs := make([]Run, 0, len(s.runs))
+	for _, run := range s.runs {
+		runs = append(runs, run)
+	}
+	sort.Slice(runs, func(i, j int) bool { return runs[i].CreatedAt.After(runs[j].CreatedAt) })
+	return runs
+}
+
+// Delete closes a run, reporting whether it existed
+func (s *runStore) Delete(id string) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	_, ok := s.runs[id]
+	delete(s.runs, id)
+	return ok
+}
+
+// runFor returns the run a proxied request was tagged with, or an error if
+// the token does not belong to an open run
+func (p *Proxy) runFor(r *http.Request) (string, error) {
+	id := r.Header.Get(RunHeader)
+	if id == "" {
+		return "", nil
+	}
+	if _, ok := p.runs.Get(id); !ok {
+		return "", errors.New("unknown run")
+	}
+	return id, nil
+}
+
+// countRunRecords returns how many history records belong to each run
+func (p *Proxy) countRunRecords() map[string]int {
+	counts := map[string]int{}
+	for _, record := range p.history.GetRecords() {
+		if record.RunID != "" {
+			counts[record.RunID]++
+		}
+	}
+	return counts
+}
+
+// handleRuns lists and creates test runs
+func (p *Proxy) handleRuns(w http.ResponseWriter, r *http.Request) {
+	// Add CORS headers
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, Cache-Control, Pragma, Expires")
+
+	// Handle preflight requests
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	var response interface{}
+	status := http.StatusOK
+	switch r.Method {
+	case http.MethodGet:
+		runs := p.runs.List()
+		counts := p.countRunRecords()
+		for i := range runs {
+			runs[i].Count = counts[runs[i].ID]
+		}
+		response = map[string]interface{}{"runs": runs}
+
+	case http.MethodPost:
+		// The body is optional, so a bare POST opens an unnamed run
+		var request struct {
+			Name string `json:"name"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil && !errors.Is(err, io.EOF) {
+			http.Error(w, "Invalid run JSON", http.StatusBadRequest)
+			return
+		}
+		response = p.runs.Create(request.Name)
+		status = http.StatusCreated
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	writeRunsResponse(w, status, response)
+}
+
+// handleRun serves /runs/{id}, which reports or deletes a run with its
+// records, and /runs/{id}/requests, which lists or deletes just its records
+func (p *Proxy) handleRun(w http.ResponseWriter, r *http.Request) {
+	// Add CORS headers
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "GET, DELETE, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, Cache-Control, Pragma, Expires")
+
+	// Handle preflight requests
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	id, rest, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/runs/"), "/")
+	if rest != "" && rest != "requests" {
+		http.NotFound(w, r)
+		return
+	}
+	run, ok := p.runs.Get(id)
+	if !ok {
+		http.Error(w, "Run not found", http.StatusNotFound)
+		return
+	}
+	inRun := func(record RequestRecord) bool { return record.RunID == id }
+
+	var response interface{}
+	switch r.Method {
+	case http.MethodGet:
+		if rest == "" {
+			run.Count = p.countRunRecords()[id]
+			response = run
+			break
+		}
+		filter, err := p.historyFilter(r.URL.Query())
+		if err != nil {
+			http.Error(w, "Invalid filter: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		records := make([]RequestRecord, 0)
+		for _, record := range p.history.GetFilteredRecords(filter) {
+			if inRun(record) {
+				records = append(records, record)
+			}
+		}
+		response = map[string]interface{}{"run": id, "records": records, "total": len(records)}
+
+	case http.MethodDelete:
+		if !p.allowPurge(w, r) {
+			return
+		}
+		var records []RequestRecord
+		for _, record := range p.history.GetRecords() {
+			if inRun(record) {
+				records = append(records, record)
+			}
+		}
+		backup, err := p.backupBeforePurge(records, "deleting run "+id)
+		if err != nil {
+			slog.Error("Error saving run before deleting it", "run", id, "error", err)
+			http.Error(w, "Failed to save run records before deleting them, they were kept", http.StatusInternalServerError)
+			return
+		}
+
+		deleted := p.history.RemoveRecords(inRun)
+		message := "Run records deleted"
+		if rest == "" {
+			p.runs.Delete(id)
+			message = "Run deleted"
+		}
+		result := map[string]interface{}{"success": true, "message": message, "deleted": deleted}
+		if backup != "" {
+			result["backup"] = backup
+		}
+		response = result
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	writeRunsResponse(w, http.StatusOK, response)
+}
+
+// writeRunsResponse writes a runs endpoint response as JSON
+func writeRunsResponse(w http.ResponseWriter, status int, response interface{}) {
+	data, err := json.Marshal(response)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to encode run: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if _, err := w.Write(data); err != nil {
+		slog.Warn("Error writing runs response", "error", err)
+	}
+}
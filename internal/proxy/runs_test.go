@@ -0,0 +1,105 @@
+//go:build unit
+
+package proxy
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func createRun(t *testing.T, p *Proxy, body string) Run {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	p.handleRuns(rec, httptest.NewRequest(http.MethodPost, "/runs", strings.NewReader(body)))
+	require.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+	var run Run
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &run))
+	return run
+}
+
+func TestRunsIsolateRecords(t *testing.T) {
+	var forwardedRun int32
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get(RunHeader) != "" {
+			atomic.AddInt32(&forwardedRun, 1)
+		}
+		if strings.HasPrefix(r.URL.Path, "/fail") {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer upstream.Close()
+
+	p := New(&Config{Sampler: HeadSampler{Rate: 0}})
+	jobA := createRun(t, p, `{"name": "job-a"}`)
+	jobB := createRun(t, p, "")
+	assert.Equal(t, "job-a", jobA.Name)
+	assert.NotEqual(t, jobA.ID, jobB.ID)
+
+	send := func(path, run string) int {
+		req := httptest.NewRequest(http.MethodGet, upstream.URL+path, nil)
+		if run != "" {
+			req.Header.Set(RunHeader, run)
+		}
+		rec := httptest.NewRecorder()
+		p.ServeHTTP(rec, req)
+		return rec.Code
+	}
+	assert.Equal(t, http.StatusOK, send("/a/1", jobA.ID))
+	assert.Equal(t, http.StatusInternalServerError, send("/fail/a", jobA.ID))
+	assert.Equal(t, http.StatusOK, send("/b/1", jobB.ID))
+	assert.Equal(t, http.StatusOK, send("/untagged", ""), "requests outside a run are sampled as usual")
+	assert.Equal(t, http.StatusBadRequest, send("/a/2", "not-a-run"))
+	assert.Zero(t, atomic.LoadInt32(&forwardedRun), "the header is not forwarded upstream")
+
+	call := func(method, target string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		p.handleRun(rec, httptest.NewRequest(method, target, nil))
+		return rec
+	}
+	var listed struct {
+		Records []RequestRecord `json:"records"`
+		Total   int             `json:"total"`
+	}
+	rec := call(http.MethodGet, "/runs/"+jobA.ID+"/requests")
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &listed))
+	assert.Equal(t, 2, listed.Total)
+	for _, record := range listed.Records {
+		assert.Equal(t, jobA.ID, record.RunID)
+	}
+
+	// History filters narrow the run's records
+	rec = call(http.MethodGet, "/runs/"+jobA.ID+"/requests?status=5xx")
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &listed))
+	require.Equal(t, 1, listed.Total)
+	assert.Equal(t, upstream.URL+"/fail/a", listed.Records[0].URL)
+
+	rec = httptest.NewRecorder()
+	p.handleRuns(rec, httptest.NewRequest(http.MethodGet, "/runs", nil))
+	var runs struct {
+		Runs []Run `json:"runs"`
+	}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &runs))
+	require.Len(t, runs.Runs, 2)
+	counts := map[string]int{runs.Runs[0].ID: runs.Runs[0].Count, runs.Runs[1].ID: runs.Runs[1].Count}
+	assert.Equal(t, map[string]int{jobA.ID: 2, jobB.ID: 1}, counts)
+
+	// Deleting a run's requests keeps the run; deleting the run closes it
+	assert.Equal(t, http.StatusOK, call(http.MethodDelete, "/runs/"+jobA.ID+"/requests").Code)
+	rec = call(http.MethodGet, "/runs/"+jobA.ID)
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), `"count":0`)
+	assert.Len(t, p.history.GetRecords(), 1, "other runs' records are kept")
+
+	assert.Equal(t, http.StatusOK, call(http.MethodDelete, "/runs/"+jobB.ID).Code)
+	assert.Equal(t, http.StatusNotFound, call(http.MethodGet, "/runs/"+jobB.ID+"/requests").Code)
+	assert.Equal(t, http.StatusBadRequest, send("/b/2", jobB.ID))
+	assert.Equal(t, http.StatusNotFound, call(http.MethodGet, "/runs/"+jobA.ID+"/other").Code)
+}
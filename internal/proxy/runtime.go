@@ -0,0 +1,128 @@
+package proxy
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"runtime"
+	"time"
+)
+
+// recentGCPauses is how many of the latest GC pauses GET /runtime lists
+const recentGCPauses = 16
+
+// RuntimeStats is the proxy process's state as reported by GET /runtime
+type RuntimeStats struct {
+	StartedAt     time.Time          `json:"started_at"`
+	UptimeSeconds float64            `json:"uptime_seconds"`
+	GoVersion     string             `json:"go_version"`
+	GOMAXPROCS    int                `json:"gomaxprocs"`
+	Goroutines    int                `json:"goroutines"`
+	Memory        RuntimeMemory      `json:"memory"`
+	GC            RuntimeGC          `json:"gc"`
+	Connections   RuntimeConnections `json:"connections"`
+}
+
+// RuntimeMemory is the Go heap and the memory obtained from the OS
+type RuntimeMemory struct {
+	HeapAllocBytes  uint64 `json:"heap_alloc_bytes"` // Live and not yet collected heap objects
+	HeapInuseBytes  uint64 `json:"heap_inuse_bytes"`
+	HeapObjects     uint64 `json:"heap_objects"`
+	StackInuseBytes uint64 `json:"stack_inuse_bytes"`
+	SysBytes        uint64 `json:"sys_bytes"`         // Everything the runtime obtained from the OS
+	TotalAllocBytes uint64 `json:"total_alloc_bytes"` // Allocated since startup, including freed objects
+}
+
+// RuntimeGC summarizes garbage collection since startup
+type RuntimeGC struct {
+	Cycles          uint32     `json:"cycles"`
+	LastAt          *time.Time `json:"last_at,omitempty"`
+	NextTargetBytes uint64     `json:"next_target_bytes"` // Heap size that triggers the next cycle
+	PauseTotalUs    int64      `json:"pause_total_us"`
+	RecentPausesUs  []int64    `json:"recent_pauses_us"` // Newest first
+	CPUFraction     float64    `json:"cpu_fraction"`     // Share of the process's CPU time spent in GC
+}
+
+// RuntimeConnections counts the proxy's open connections
+type RuntimeConnections struct {
+	Clients        int64 `json:"clients"` // Open client connections on the proxy port, not counting tunnels
+	ActiveRequests int64 `json:"active_requests"`
+	Tunnels        int64 `json:"tunnels"`
+}
+
+// runtimeStats reads the Go runtime's statistics. Reading memory statistics
+// briefly stops the world, so it is only done on request.
+func (p *Proxy) runtimeStats() RuntimeStats {
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+
+	stats := RuntimeStats{
+		StartedAt:     processClock.start,
+		UptimeSeconds: time.Since(processClock.start).Seconds(),
+		GoVersion:     runtime.Version(),
+		GOMAXPROCS:    runtime.GOMAXPROCS(0),
+		Goroutines:    runtime.NumGoroutine(),
+		Memory: RuntimeMemory{
+			HeapAllocBytes:  mem.HeapAlloc,
+			HeapInuseBytes:  mem.HeapInuse,
+			HeapObjects:     mem.HeapObjects,
+			StackInuseBytes: mem.StackInuse,
+			SysBytes:        mem.Sys,
+			TotalAllocBytes: mem.TotalAlloc,
+		},
+		GC: RuntimeGC{
+			Cycles:          mem.NumGC,
+			NextTargetBytes: mem.NextGC,
+			PauseTotalUs:    time.Duration(mem.PauseTotalNs).Microseconds(),
+			RecentPausesUs:  make([]int64, 0, recentGCPauses),
+			CPUFraction:     mem.GCCPUFraction,
+		},
+		Connections: RuntimeConnections{
+			Clients:        p.metrics.conns.Load(),
+			ActiveRequests: p.metrics.active[0].Load(),
+			Tunnels:        p.metrics.active[1].Load(),
+		},
+	}
+	if mem.NumGC > 0 {
+		last := time.Unix(0, int64(mem.LastGC))
+		stats.GC.LastAt = &last
+	}
+	// PauseNs is a circular buffer with the latest pause at (NumGC+255)%256
+	for i := uint32(0); i < min(mem.NumGC, recentGCPauses); i++ {
+		pause := mem.PauseNs[(mem.NumGC-1-i)%uint32(len(mem.PauseNs))]
+		stats.GC.RecentPausesUs = append(stats.GC.RecentPausesUs, time.Duration(pause).Microseconds())
+	}
+	return stats
+}
+
+// handleRuntime reports goroutines, memory, GC, connections, and uptime for
+// dashboards and quick diagnosis
+func (p *Proxy) handleRuntime(w http.ResponseWriter, r *http.Request) {
+	// Add CORS headers
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, Cache-Control, Pragma, Expires")
+
+	// Handle preflight requests
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	data, err := json.Marshal(p.runtimeStats())
+	if err != nil {
+		http.Error(w, "Failed to encode runtime stats", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if _, err := w.Write(data); err != nil {
+		slog.Warn("Error writing runtime stats response", "error", err)
+	}
+}
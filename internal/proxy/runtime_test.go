@@ -0,0 +1,53 @@
+//go:build unit
+
+package proxy
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRuntimeStats(t *testing.T) {
+	p := New(&Config{})
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go func() { _ = p.Serve(ln) }()
+	defer p.Stop()
+
+	// An idle keep-alive connection stays open
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	require.NoError(t, err)
+	assert.Eventually(t, func() bool { return p.metrics.conns.Load() == 1 }, time.Second, 10*time.Millisecond)
+	runtime.GC()
+
+	rec := httptest.NewRecorder()
+	p.handleRuntime(rec, httptest.NewRequest(http.MethodGet, "/runtime", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	var stats RuntimeStats
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &stats))
+	assert.Equal(t, runtime.Version(), stats.GoVersion)
+	assert.Positive(t, stats.Goroutines)
+	assert.Positive(t, stats.UptimeSeconds)
+	assert.Positive(t, stats.Memory.HeapAllocBytes)
+	assert.Positive(t, stats.GC.Cycles)
+	require.NotNil(t, stats.GC.LastAt)
+	assert.WithinDuration(t, time.Now(), *stats.GC.LastAt, time.Minute)
+	assert.NotEmpty(t, stats.GC.RecentPausesUs)
+	assert.LessOrEqual(t, len(stats.GC.RecentPausesUs), recentGCPauses)
+	assert.Equal(t, int64(1), stats.Connections.Clients)
+
+	require.NoError(t, conn.Close())
+	assert.Eventually(t, func() bool { return p.metrics.conns.Load() == 0 }, time.Second, 10*time.Millisecond)
+
+	rec = httptest.NewRecorder()
+	p.handleRuntime(rec, httptest.NewRequest(http.MethodPost, "/runtime", nil))
+	assert.Equal(t, http.StatusMethodNotAllowed, rec.Code)
+}
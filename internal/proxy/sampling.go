@@ -0,0 +1,79 @@
+package proxy
+
+import (
+	"fmt"
+	"hash/fnv"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Sampling strategies
+const (
+	SampleHead       = "head"        // Keep a fixed share of requests, regardless of outcome
+	SampleKeepErrors = "keep-errors" // Keep every failed request and a fixed share of the rest
+)
+
+// Sampler decides which completed requests are kept in history
+type Sampler interface {
+	Sample(record RequestRecord) bool
+}
+
+// ParseSampler parses a sampling strategy as STRATEGY:RATE, where RATE is the
+// share of requests kept, from 0 to 1 (e.g. head:0.1 or keep-errors:0.05)
+func ParseSampler(spec string) (Sampler, error) {
+	strategy, value, ok := strings.Cut(spec, ":")
+	if !ok {
+		return nil, fmt.Errorf("expected head:RATE or keep-errors:RATE, got %q", spec)
+	}
+	rate, err := strconv.ParseFloat(value, 64)
+	if err != nil || rate < 0 || rate > 1 {
+		return nil, fmt.Errorf("sampling rate must be between 0 and 1, got %q", value)
+	}
+
+	switch strategy {
+	case SampleHead:
+		return HeadSampler{Rate: rate}, nil
+	case SampleKeepErrors:
+		return KeepErrorsSampler{Rate: rate}, nil
+	default:
+		return nil, fmt.Errorf("unknown sampling strategy %q (expected head or keep-errors)", strategy)
+	}
+}
+
+// HeadSampler keeps a share of requests chosen from their IDs alone, so the
+// decision does not depend on how the request went
+type HeadSampler struct {
+	Rate float64 // Share of requests kept, from 0 to 1
+}
+
+func (s HeadSampler) Sample(record RequestRecord) bool {
+	return sampledID(record.ID, s.Rate)
+}
+
+// KeepErrorsSampler keeps every failed request and samples the rest by ID
+type KeepErrorsSampler struct {
+	Rate float64 // Share of successful requests kept, from 0 to 1
+}
+
+func (s KeepErrorsSampler) Sample(record RequestRecord) bool {
+	return isFailedRecord(record) || sampledID(record.ID, s.Rate)
+}
+
+// sampledID hashes the ID to a point in [0, 1) and keeps it below the rate, so
+// the same ID always gets the same decision
+func sampledID(id string, rate float64) bool {
+	if rate >= 1 {
+		return true
+	}
+	hash := fnv.New64a()
+	hash.Write([]byte(id))
+	// Mix the bits (MurmurHash3 finalizer): FNV alone spreads similar IDs unevenly
+	h := hash.Sum64()
+	h ^= h >> 33
+	h *= 0xff51afd7ed558ccd
+	h ^= h >> 33
+	h *= 0xc4ceb3f91a7e2b9d
+	h ^= h >> 33
+	return float64(h)/math.MaxUint64 < rate
+}
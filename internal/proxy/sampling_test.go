@@ -0,0 +1,62 @@
+//go:build unit
+
+package proxy
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseSampler(t *testing.T) {
+	sampler, err := ParseSampler("head:0.25")
+	require.NoError(t, err)
+	assert.Equal(t, HeadSampler{Rate: 0.25}, sampler)
+
+	sampler, err = ParseSampler("keep-errors:0")
+	require.NoError(t, err)
+	assert.Equal(t, KeepErrorsSampler{Rate: 0}, sampler)
+
+	for _, spec := range []string{"head", "head:1.5", "head:-0.1", "tail:0.5", "keep-errors:half"} {
+		_, err := ParseSampler(spec)
+		assert.Error(t, err, spec)
+	}
+}
+
+func TestHeadSamplerRate(t *testing.T) {
+	sampler := HeadSampler{Rate: 0.2}
+	kept := 0
+	for i := 0; i < 10000; i++ {
+		record := RequestRecord{ID: fmt.Sprintf("req-%d", i), ResponseStatus: 200, Success: true}
+		if sampler.Sample(record) {
+			kept++
+		}
+		// The decision depends on the ID only
+		record.ResponseStatus = 500
+		assert.Equal(t, sampler.Sample(RequestRecord{ID: record.ID}), sampler.Sample(record))
+	}
+	assert.InDelta(t, 2000, kept, 200)
+
+	assert.True(t, HeadSampler{Rate: 1}.Sample(RequestRecord{ID: "any"}))
+	assert.False(t, HeadSampler{Rate: 0}.Sample(RequestRecord{ID: "any"}))
+}
+
+func TestKeepErrorsSampler(t *testing.T) {
+	sampler := KeepErrorsSampler{Rate: 0}
+	assert.True(t, sampler.Sample(RequestRecord{ID: "1", ResponseStatus: 503, Success: true}))
+	assert.True(t, sampler.Sample(RequestRecord{ID: "2", Success: false}))
+	assert.False(t, sampler.Sample(RequestRecord{ID: "3", ResponseStatus: 200, Success: true}))
+}
+
+func TestRecordRequestSampling(t *testing.T) {
+	p := New(&Config{Sampler: KeepErrorsSampler{Rate: 0}})
+	p.recordRequest(RequestRecord{ID: "ok", URL: "http://api.example.com/", ResponseStatus: 200, Success: true})
+	p.recordRequest(RequestRecord{ID: "failed", URL: "http://api.example.com/", ResponseStatus: 500, Success: true})
+
+	records := p.history.GetRecords()
+	require.Len(t, records, 1)
+	assert.Equal(t, "failed", records[0].ID)
+	assert.Equal(t, int64(1), p.capture.Status().SampledOut)
+}
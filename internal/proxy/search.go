@@ -0,0 +1,274 @@
+package proxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// defaultSearchLimit is how many results GET /requests/search returns
+// without a limit
+const defaultSearchLimit = 50
+
+// searchScanLimit is how many bytes of each body a search reads when history
+// is scanned rather than looked up in a full-text index
+const searchScanLimit = 256 * 1024
+
+// Fields of a record that searches look in
+const (
+	SearchFieldURL             = "url"
+	SearchFieldRequestBody     = "request_body"
+	SearchFieldResponseBody    = "response_body"
+	SearchFieldRequestHeaders  = "request_headers"  // Followed by .<name>
+	SearchFieldResponseHeaders = "response_headers" // Followed by .<name>
+)
+
+// SearchMatch is where a search term was found in a record: byte offsets of
+// the match within the value of a field
+type SearchMatch struct {
+	Field string `json:"field"` // url, request_body, response_body, or request_headers/response_headers.<name>
+	Start int    `json:"start"`
+	End   int    `json:"end"`
+}
+
+// SearchResult is a record that matched a search, with every match in it
+type SearchResult struct {
+	Record  RequestRecord `json:"record"`
+	Matches []SearchMatch `json:"matches"`
+}
+
+// searchQuery is a parsed search: a record matches when it contains every
+// term, ignoring case
+type searchQuery struct {
+	terms []string // Lowercased
+}
+
+// parseSearchQuery splits a search into terms at whitespace; double quotes
+// keep a phrase together
+func parseSearchQuery(q string) (*searchQuery, error) {
+	query := &searchQuery{}
+	inPhrase := false
+	var term strings.Builder
+	flush := func() {
+		if term.Len() > 0 {
+			query.terms = append(query.terms, strings.ToLower(term.String()))
+			term.Reset()
+		}
+	}
+	for _, r := range q {
+		switch {
+		case r == '"':
+			flush()
+			inPhrase = !inPhrase
+		case !inPhrase && (r == ' ' || r == '\t' || r == '\n' || r == '\r'):
+			flush()
+		default:
+			term.WriteRune(r)
+		}
+	}
+	if inPhrase {
+		return nil, fmt.Errorf("unterminated quote")
+	}
+	flush()
+	if len(query.terms) == 0 {
+		return nil, fmt.Errorf("missing q parameter")
+	}
+	return query, nil
+}
+
+// match returns every match of the query's terms in a record, and whether
+// the record contains all of them. Bodies are searched up to bodyLimit bytes,
+// or in full when it is 0.
+func (sq *searchQuery) match(record RequestRecord, bodyLimit int) ([]SearchMatch, bool) {
+	type field struct{ name, value string }
+	fields := []field{{SearchFieldURL, record.URL}}
+	for _, name := range sortedHeaderNames(record.RequestHeaders) {
+		fields = append(fields, field{SearchFieldRequestHeaders + "." + name, record.RequestHeaders[name]})
+	}
+	fields = append(fields, field{SearchFieldRequestBody, searchBody(record.RequestBody, bodyLimit)})
+	for _, name := range sortedHeaderNames(record.ResponseHeaders) {
+		fields = append(fields, field{SearchFieldResponseHeaders + "." + name, record.ResponseHeaders[name]})
+	}
+	fields = append(fields, field{SearchFieldResponseBody, searchBody(record.ResponseBody, bodyLimit)})
+
+	found := make([]bool, len(sq.terms))
+	matches := make([]SearchMatch, 0)
+	for _, f := range fields {
+		// Offsets in the lowercased value only line up when lowercasing kept
+		// its length; otherwise the value is searched as it is
+		haystack := strings.ToLower(f.value)
+		if len(haystack) != len(f.value) {
+			haystack = f.value
+		}
+		var fieldMatches []SearchMatch
+		for i, term := range sq.terms {
+			for offset := 0; offset < len(haystack); {
+				index := strings.Index(haystack[offset:], term)
+				if index < 0 {
+					break
+				}
+				start := offset + index
+				fieldMatches = append(fieldMatches, SearchMatch{Field: f.name, Start: start, End: start + len(term)})
+				found[i] = true
+				offset = start + len(term)
+			}
+		}
+		sort.SliceStable(fieldMatches, func(i, j int) bool { return fieldMatches[i].Start < fieldMatches[j].Start })
+		matches = append(matches, fieldMatches...)
+	}
+	for _, ok := range found {
+		if !ok {
+			return nil, false
+		}
+	}
+	return matches, true
+}
+
+// searchBody returns the part of a body a search reads
+func searchBody(body string, limit int) string {
+	if limit > 0 && len(body) > limit {
+		return body[:limit]
+	}
+	return body
+}
+
+// sortedHeaderNames returns the names of headers in order
+func sortedHeaderNames(headers map[string]string) []string {
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// historySearcher is a history store with a full-text index that narrows a
+// search to the records that may match, most recent first. indexed is false
+// when the store has no usable index and returned every record matching
+// filter.
+type historySearcher interface {
+	searchCandidates(query *searchQuery, filter *RequestFilter) (records []RequestRecord, indexed bool, err error)
+}
+
+// searchHistory returns the records matching filter that contain every term
+// of query, most recent first
+func (p *Proxy) searchHistory(query *searchQuery, filter *RequestFilter) ([]SearchResult, error) {
+	var records []RequestRecord
+	var err error
+	bodyLimit := searchScanLimit
+	if searcher, ok := p.historyStore.(historySearcher); ok {
+		var indexed bool
+		records, indexed, err = searcher.searchCandidates(query, filter)
+		if indexed {
+			bodyLimit = 0
+		}
+	} else {
+		records, err = p.queryHistory(filter)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]SearchResult, 0)
+	for _, record := range records {
+		if matches, ok := query.match(record, bodyLimit); ok {
+			results = append(results, SearchResult{Record: record, Matches: matches})
+		}
+	}
+	return results, nil
+}
+
+// handleSearch serves GET /requests/search?q=<terms>: records whose URL,
+// headers, or bodies contain every term, with the offsets of each match.
+// GET /requests filters narrow the search, and results are paged like
+// GET /requests.
+func (p *Proxy) handleSearch(w http.ResponseWriter, r *http.Request) {
+	// Add CORS headers
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, Cache-Control, Pragma, Expires")
+
+	// Handle preflight requests
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	query, err := parseSearchQuery(r.URL.Query().Get("q"))
+	if err != nil {
+		http.Error(w, "Invalid search: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	filter, err := p.historyFilter(r.URL.Query())
+	if err != nil {
+		http.Error(w, "Invalid filter: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	page, err := parseHistoryPage(r.URL.Query())
+	if err != nil {
+		http.Error(w, "Invalid page: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if page.limit == 0 {
+		page.limit = defaultSearchLimit
+	}
+
+	results, err := p.searchHistory(query, filter)
+	if err != nil {
+		slog.Error("Error searching history", "error", err)
+		http.Error(w, "Failed to search request history", http.StatusInternalServerError)
+		return
+	}
+
+	// Page through the matched records, keeping each one's matches
+	records := make([]RequestRecord, len(results))
+	matches := make(map[string][]SearchMatch, len(results))
+	for i, result := range results {
+		records[i] = result.Record
+		matches[result.Record.ID] = result.Matches
+	}
+	total := len(records)
+	records, next, err := page.apply(records)
+	if err != nil {
+		http.Error(w, "Invalid page: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Access-Control-Expose-Headers", "X-Total-Count, X-Next-Cursor, Link")
+	w.Header().Set("X-Total-Count", strconv.Itoa(total))
+	if next != "" {
+		params := r.URL.Query()
+		params.Del("offset")
+		params.Set("cursor", next)
+		w.Header().Set("X-Next-Cursor", next)
+		w.Header().Set("Link", fmt.Sprintf(`<%s?%s>; rel="next"`, r.URL.Path, params.Encode()))
+	}
+	summary, _ := strconv.ParseBool(r.URL.Query().Get("summary"))
+	paged := make([]SearchResult, len(records))
+	for i, record := range records {
+		if summary {
+			record.summarize()
+		}
+		paged[i] = SearchResult{Record: record, Matches: matches[record.ID]}
+	}
+
+	data, err := json.Marshal(paged)
+	if err != nil {
+		http.Error(w, "Failed to search request history", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if _, err := w.Write(data); err != nil {
+		slog.Warn("Error writing search response", "error", err)
+	}
+}
@@ -0,0 +1,117 @@
+//go:build unit
+
+package proxy
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func searchRecords(start time.Time) []RequestRecord {
+	return []RequestRecord{
+		{
+			ID: "order", Timestamp: start, Method: http.MethodPost, URL: "https://api.example.com/orders",
+			RequestHeaders:  map[string]string{"X-Customer": "ACME Corp"},
+			RequestBody:     `{"customer":"acme","sku":"A1"}`,
+			ResponseStatus:  201,
+			ResponseHeaders: map[string]string{"Content-Type": "application/json"},
+			ResponseBody:    `{"id":42,"status":"created"}`,
+		},
+		{
+			ID: "invoice", Timestamp: start.Add(time.Second), Method: http.MethodGet, URL: "https://billing.example.com/invoices?customer=acme",
+			ResponseStatus: 404, ResponseBody: "invoice not found",
+		},
+		{
+			ID: "health", Timestamp: start.Add(2 * time.Second), Method: http.MethodGet, URL: "http://localhost/health",
+			ResponseStatus: 200, ResponseBody: "ok",
+		},
+	}
+}
+
+func TestParseSearchQuery(t *testing.T) {
+	query, err := parseSearchQuery(`  ACME "not found"  42 `)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"acme", "not found", "42"}, query.terms)
+
+	_, err = parseSearchQuery(`"open`)
+	assert.ErrorContains(t, err, "unterminated quote")
+	_, err = parseSearchQuery(" ")
+	assert.ErrorContains(t, err, "missing q")
+}
+
+func TestSearchMatch(t *testing.T) {
+	record := searchRecords(time.Now())[0]
+	query, err := parseSearchQuery("acme")
+	require.NoError(t, err)
+
+	matches, ok := query.match(record, 0)
+	require.True(t, ok)
+	assert.Equal(t, []SearchMatch{
+		{Field: "request_headers.X-Customer", Start: 0, End: 4},
+		{Field: "request_body", Start: 13, End: 17},
+	}, matches)
+
+	// Every term must be found
+	query, err = parseSearchQuery("acme missing")
+	require.NoError(t, err)
+	_, ok = query.match(record, 0)
+	assert.False(t, ok)
+
+	// Scans stop at the body limit
+	query, err = parseSearchQuery("created")
+	require.NoError(t, err)
+	_, ok = query.match(record, 10)
+	assert.False(t, ok)
+}
+
+func TestHandleSearch(t *testing.T) {
+	p := New(&Config{})
+	for _, record := range searchRecords(time.Now()) {
+		p.history.AddRecord(record)
+	}
+
+	search := func(query string) ([]SearchResult, *httptest.ResponseRecorder) {
+		rec := httptest.NewRecorder()
+		p.handleSearch(rec, httptest.NewRequest(http.MethodGet, "/requests/search?"+query, nil))
+		var results []SearchResult
+		if rec.Code == http.StatusOK {
+			require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &results))
+		}
+		return results, rec
+	}
+
+	results, rec := search("q=acme")
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "2", rec.Header().Get("X-Total-Count"))
+	require.Len(t, results, 2)
+	assert.Equal(t, "invoice", results[0].Record.ID, "most recent first")
+	assert.Equal(t, []SearchMatch{{Field: "url", Start: 46, End: 50}}, results[0].Matches)
+	assert.Equal(t, "acme", results[0].Record.URL[46:50])
+	assert.Equal(t, "order", results[1].Record.ID)
+
+	// Filters and paging apply
+	results, _ = search("q=acme&method=POST")
+	require.Len(t, results, 1)
+	assert.Equal(t, "order", results[0].Record.ID)
+	results, rec = search("q=acme&limit=1&summary=true")
+	require.Len(t, results, 1)
+	assert.Equal(t, "invoice", rec.Header().Get("X-Next-Cursor"))
+	assert.Empty(t, results[0].Record.ResponseBody)
+
+	results, _ = search("q=" + strings.ReplaceAll(`"NOT FOUND"`, " ", "+"))
+	require.Len(t, results, 1)
+	assert.Equal(t, []SearchMatch{{Field: "response_body", Start: 8, End: 17}}, results[0].Matches)
+
+	_, rec = search("q=")
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	rec = httptest.NewRecorder()
+	p.handleSearch(rec, httptest.NewRequest(http.MethodPost, "/requests/search?q=x", nil))
+	assert.Equal(t, http.StatusMethodNotAllowed, rec.Code)
+}
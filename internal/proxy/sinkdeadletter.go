@@ -0,0 +1,105 @@
+package proxy
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync/atomic"
+	"time"
+)
+
+// deadLetterSequence keeps dead-letter file names unique within a nanosecond
+var deadLetterSequence atomic.Int64
+
+// SinkReplay is the outcome of redelivering a sink's dead letters
+type SinkReplay struct {
+	Batches   int    `json:"batches"`         // Spooled batches delivered and removed
+	Records   int    `json:"records"`         // Records in those batches
+	Remaining int    `json:"remaining"`       // Spooled batches still waiting, after an error
+	Error     string `json:"error,omitempty"` // Why the replay stopped early
+}
+
+// spoolDeadLetter writes a batch a sink gave up on to
+// dir/<sink>/<time>-<sequence>.ndjson, one record per line
+func spoolDeadLetter(dir, sink string, batch []RequestRecord) error {
+	sinkDir := filepath.Join(dir, sink)
+	if err := os.MkdirAll(sinkDir, 0o700); err != nil {
+		return err
+	}
+	data, err := encodeNDJSON(batch)
+	if err != nil {
+		return err
+	}
+	name := fmt.Sprintf("%020d-%06d.ndjson", time.Now().UnixNano(), deadLetterSequence.Add(1)%1000000)
+	// Write under a temporary name, so a replay never reads a partial batch
+	path := filepath.Join(sinkDir, name)
+	if err := os.WriteFile(path+".tmp", data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+// deadLetterFiles lists the spooled batches of a sink, oldest first
+func deadLetterFiles(dir, sink string) []string {
+	if dir == "" {
+		return nil
+	}
+	matches, err := filepath.Glob(filepath.Join(dir, sink, "*.ndjson"))
+	if err != nil {
+		return nil
+	}
+	sort.Strings(matches)
+	return matches
+}
+
+// readDeadLetter reads the records of a spooled batch
+func readDeadLetter(path string) ([]RequestRecord, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var records []RequestRecord
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+	for scanner.Scan() {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var record RequestRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			return nil, err
+		}
+		records = append(records, record)
+	}
+	return records, scanner.Err()
+}
+
+// replay redelivers the sink's spooled batches oldest first, removing each
+// once delivered and stopping at the first that fails again
+func (r *sinkRunner) replay(ctx context.Context) SinkReplay {
+	var replay SinkReplay
+	files := deadLetterFiles(r.deadLetterDir, r.sink.Name)
+	for i, path := range files {
+		records, err := readDeadLetter(path)
+		if err == nil && len(records) > 0 {
+			err = r.write(ctx, records)
+		}
+		if err == nil {
+			err = os.Remove(path)
+		}
+		if err != nil {
+			replay.Remaining = len(files) - i
+			replay.Error = fmt.Sprintf("%s: %v", filepath.Base(path), err)
+			return replay
+		}
+		replay.Batches++
+		replay.Records += len(records)
+	}
+	return replay
+}
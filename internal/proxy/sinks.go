@@ -0,0 +1,565 @@
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Kinds of history sink
+const (
+	SinkFile      = "file"      // Records appended to a file as NDJSON
+	SinkWebhook   = "webhook"   // Batches POSTed to a URL as a JSON array
+	SinkCollector = "collector" // Batches POSTed to an OpenTelemetry collector as OTLP/HTTP JSON logs
+	SinkKafka     = "kafka"     // Batches produced to a topic through a Kafka REST Proxy
+	SinkS3        = "s3"        // Batches written to an S3 bucket as NDJSON objects
+)
+
+// Sink defaults
+const (
+	DefaultSinkBatch    = 100
+	DefaultSinkInterval = 5 * time.Second
+	DefaultSinkRetries  = 3
+)
+
+// sinkNamePattern restricts sink names to something safe in URLs and file
+// names, since dead letters are spooled by name
+var sinkNamePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]{0,63}$`)
+
+// sinkQueueSize is how many records wait for a sink; later ones are dropped
+// rather than holding up requests
+const sinkQueueSize = 10000
+
+// sinkDrainTimeout is how long stopping a sink waits to deliver the records
+// it still holds
+const sinkDrainTimeout = 5 * time.Second
+
+// Sink exports the records added to history to a file or another system, in
+// batches that are retried when delivery fails
+type Sink struct {
+	Name     string        // Unique name the admin API refers to the sink by
+	Kind     string        // file, webhook, collector, kafka, or s3
+	Target   string        // File path, URL, Kafka REST Proxy topic URL, or s3://bucket/prefix
+	Filter   string        // GET /requests filters records must match, as a query string (optional)
+	Batch    int           // Records per delivery (default: 100)
+	Interval time.Duration // Longest a record waits for its batch to fill (default: 5s)
+	Retries  int           // Delivery retries after a failure, with doubling delays from 1s (default: 3)
+	Disabled bool          // Start disabled, until enabled through the admin API
+
+	filter *RequestFilter
+}
+
+// ParseSink parses a sink in "name=kind:target[;option...]" form, with the
+// options filter=QUERY, batch=N, interval=DURATION, retries=N, and disabled,
+// e.g. "errors=webhook:https://hooks.example.com/netkit;filter=status=5xx"
+func ParseSink(spec string) (Sink, error) {
+	name, rest, ok := strings.Cut(spec, "=")
+	if !ok || !sinkNamePattern.MatchString(name) {
+		return Sink{}, fmt.Errorf("invalid sink %q: expected name=kind:target[;option...]", spec)
+	}
+	parts := strings.Split(rest, ";")
+	kind, target, ok := strings.Cut(parts[0], ":")
+	if !ok || target == "" {
+		return Sink{}, fmt.Errorf("invalid sink %q: expected kind:target after the name", spec)
+	}
+	sink := Sink{Name: name, Kind: kind, Target: target, Batch: DefaultSinkBatch, Interval: DefaultSinkInterval, Retries: DefaultSinkRetries}
+
+	for _, option := range parts[1:] {
+		key, value, _ := strings.Cut(option, "=")
+		var err error
+		switch key {
+		case "filter":
+			sink.Filter = value
+		case "batch":
+			sink.Batch, err = strconv.Atoi(value)
+			if err == nil && sink.Batch <= 0 {
+				err = fmt.Errorf("must be positive")
+			}
+		case "interval":
+			sink.Interval, err = time.ParseDuration(value)
+			if err == nil && sink.Interval <= 0 {
+				err = fmt.Errorf("must be positive")
+			}
+		case "retries":
+			sink.Retries, err = strconv.Atoi(value)
+			if err == nil && sink.Retries < 0 {
+				err = fmt.Errorf("must not be negative")
+			}
+		case "disabled":
+			sink.Disabled = true
+		default:
+			err = fmt.Errorf("unknown option (expected filter, batch, interval, retries, or disabled)")
+		}
+		if err != nil {
+			return Sink{}, fmt.Errorf("invalid sink %q: %s: %v", spec, key, err)
+		}
+	}
+
+	if err := sink.compile(); err != nil {
+		return Sink{}, fmt.Errorf("invalid sink %q: %v", spec, err)
+	}
+	return sink, nil
+}
+
+// compile checks the target and parses the filter
+func (s *Sink) compile() error {
+	switch s.Kind {
+	case SinkFile:
+	case SinkWebhook, SinkCollector, SinkKafka:
+		parsed, err := url.Parse(s.Target)
+		if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+			return fmt.Errorf("%s target must be an http(s) URL", s.Kind)
+		}
+		if s.Kind == SinkKafka && !strings.Contains(parsed.Path, "/topics/") {
+			return fmt.Errorf("kafka target must be a REST Proxy topic URL, e.g. http://localhost:8082/topics/netkit")
+		}
+	case SinkS3:
+		if _, err := parseS3Target(s.Target); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("unknown kind %q (expected %s, %s, %s, %s, or %s)", s.Kind, SinkFile, SinkWebhook, SinkCollector, SinkKafka, SinkS3)
+	}
+
+	values, err := url.ParseQuery(s.Filter)
+	if err != nil {
+		return fmt.Errorf("invalid filter: %v", err)
+	}
+	if s.filter, err = ParseRequestFilter(values, true); err != nil {
+		return fmt.Errorf("invalid filter: %v", err)
+	}
+	return nil
+}
+
+// SinkStatus is a sink and its delivery counters, as listed by GET /sinks
+type SinkStatus struct {
+	Name           string     `json:"name"`
+	Kind           string     `json:"kind"`
+	Target         string     `json:"target"`
+	Filter         string     `json:"filter,omitempty"`
+	Enabled        bool       `json:"enabled"`
+	Queued         int        `json:"queued"`         // Records waiting for delivery
+	Delivered      int64      `json:"delivered"`      // Records delivered
+	Batches        int64      `json:"batches"`        // Batches delivered
+	Failed         int64      `json:"failed"`         // Records given up on after every retry failed
+	FailedBatches  int64      `json:"failed_batches"` // Batches given up on
+	Dropped        int64      `json:"dropped"`        // Records dropped because the queue was full
+	DeadLettered   int64      `json:"dead_lettered"`  // Failed records spooled to the dead-letter directory
+	DeadLetters    int        `json:"dead_letters"`   // Spooled batches waiting for a replay
+	LastError      string     `json:"last_error,omitempty"`
+	LastDeliveryAt *time.Time `json:"last_delivery_at,omitempty"`
+}
+
+// sinkWriter delivers batches of records to one kind of sink
+type sinkWriter interface {
+	write(ctx context.Context, records []RequestRecord) error
+	close() error
+}
+
+// sinkRunner batches the records of one sink and delivers them in the
+// background
+type sinkRunner struct {
+	sink          Sink
+	writer        sinkWriter
+	writeMutex    sync.Mutex // Serializes batches and replays
+	deadLetterDir string     // Where undeliverable batches are spooled ("" to drop them)
+	queue         chan RequestRecord
+	done          chan struct{} // Closed to stop
+	exited        chan struct{} // Closed once run returns
+
+	mutex         sync.Mutex
+	enabled       bool
+	delivered     int64
+	batches       int64
+	failed        int64
+	failedBatches int64
+	dropped       int64
+	deadLettered  int64
+	lastError     string
+	lastAt        time.Time
+}
+
+func startSinkRunner(sink Sink, client *http.Client, deadLetterDir string) *sinkRunner {
+	runner := &sinkRunner{
+		sink:          sink,
+		writer:        newSinkWriter(sink, client),
+		deadLetterDir: deadLetterDir,
+		queue:         make(chan RequestRecord, sinkQueueSize),
+		done:          make(chan struct{}),
+		exited:        make(chan struct{}),
+		enabled:       !sink.Disabled,
+	}
+	go runner.run()
+	return runner
+}
+
+// offer queues a record the sink takes, dropping it when the queue is full
+func (r *sinkRunner) offer(record RequestRecord) {
+	r.mutex.Lock()
+	enabled := r.enabled
+	r.mutex.Unlock()
+	if !enabled || !r.sink.filter.Matches(record) {
+		return
+	}
+	select {
+	case r.queue <- record:
+	default:
+		r.mutex.Lock()
+		r.dropped++
+		r.mutex.Unlock()
+	}
+}
+
+// run collects batches until stopped, then delivers what is left
+func (r *sinkRunner) run() {
+	defer close(r.exited)
+	defer func() {
+		if err := r.writer.close(); err != nil {
+			slog.Warn("Error closing sink", "sink", r.sink.Name, "error", err)
+		}
+	}()
+
+	var batch []RequestRecord
+	timer := time.NewTimer(r.sink.Interval)
+	defer timer.Stop()
+	for {
+		select {
+		case record := <-r.queue:
+			batch = append(batch, record)
+			if len(batch) < r.sink.Batch {
+				continue
+			}
+		case <-timer.C:
+		case <-r.done:
+			for len(r.queue) > 0 {
+				batch = append(batch, <-r.queue)
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), sinkDrainTimeout)
+			r.deliver(ctx, batch, 0)
+			cancel()
+			return
+		}
+		if len(batch) > 0 {
+			r.deliver(context.Background(), batch, r.sink.Retries)
+			batch = nil
+		}
+		if !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+		timer.Reset(r.sink.Interval)
+	}
+}
+
+// deliver writes a batch, retrying failures with doubling delays. A batch
+// that cannot be delivered is spooled to the dead-letter directory.
+func (r *sinkRunner) deliver(ctx context.Context, batch []RequestRecord, retries int) {
+	if len(batch) == 0 {
+		return
+	}
+	delay := time.Second
+	for attempt := 0; ; attempt++ {
+		err := r.write(ctx, batch)
+		if err == nil {
+			return
+		}
+		if attempt >= retries || ctx.Err() != nil {
+			slog.Error("Error delivering records to sink, giving up", "sink", r.sink.Name, "records", len(batch), "error", err)
+			r.giveUp(batch)
+			return
+		}
+		slog.Warn("Error delivering records to sink, retrying", "sink", r.sink.Name, "records", len(batch), "retry_in", delay.String(), "error", err)
+
+		select {
+		case <-time.After(delay):
+		case <-r.done:
+			retries = attempt + 1 // One last try while stopping
+		case <-ctx.Done():
+		}
+		delay = min(delay*2, 30*time.Second)
+	}
+}
+
+// write makes one delivery attempt and counts its outcome
+func (r *sinkRunner) write(ctx context.Context, batch []RequestRecord) error {
+	r.writeMutex.Lock()
+	err := r.writer.write(ctx, batch)
+	r.writeMutex.Unlock()
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if err != nil {
+		r.lastError = err.Error()
+		return err
+	}
+	r.delivered += int64(len(batch))
+	r.batches++
+	r.lastAt = time.Now()
+	r.lastError = ""
+	return nil
+}
+
+// giveUp counts a batch as failed and spools it for a later replay
+func (r *sinkRunner) giveUp(batch []RequestRecord) {
+	r.mutex.Lock()
+	r.failed += int64(len(batch))
+	r.failedBatches++
+	r.mutex.Unlock()
+	if r.deadLetterDir == "" {
+		return
+	}
+	if err := spoolDeadLetter(r.deadLetterDir, r.sink.Name, batch); err != nil {
+		slog.Error("Error spooling records to the dead-letter directory, they are lost", "sink", r.sink.Name, "records", len(batch), "error", err)
+		return
+	}
+	r.mutex.Lock()
+	r.deadLettered += int64(len(batch))
+	r.mutex.Unlock()
+}
+
+// stop delivers the queued records and closes the sink
+func (r *sinkRunner) stop() {
+	close(r.done)
+	<-r.exited
+}
+
+func (r *sinkRunner) setEnabled(enabled bool) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.enabled = enabled
+}
+
+func (r *sinkRunner) status() SinkStatus {
+	deadLetters := len(deadLetterFiles(r.deadLetterDir, r.sink.Name))
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	status := SinkStatus{
+		DeadLetters:   deadLetters,
+		Name:          r.sink.Name,
+		Kind:          r.sink.Kind,
+		Target:        r.sink.Target,
+		Filter:        r.sink.Filter,
+		Enabled:       r.enabled,
+		Queued:        len(r.queue),
+		Delivered:     r.delivered,
+		Batches:       r.batches,
+		Failed:        r.failed,
+		FailedBatches: r.failedBatches,
+		Dropped:       r.dropped,
+		DeadLettered:  r.deadLettered,
+		LastError:     r.lastError,
+	}
+	if !r.lastAt.IsZero() {
+		lastAt := r.lastAt
+		status.LastDeliveryAt = &lastAt
+	}
+	return status
+}
+
+// historySinks runs the configured sinks
+type historySinks struct {
+	client *http.Client
+
+	mutex   sync.Mutex
+	runners []*sinkRunner // In configuration order
+}
+
+func newHistorySinks(sinks []Sink, deadLetterDir string) *historySinks {
+	hs := &historySinks{client: &http.Client{Timeout: 30 * time.Second}}
+	hs.configure(sinks, deadLetterDir)
+	return hs
+}
+
+// configure starts new sinks, restarts changed ones, and stops removed ones.
+// Unchanged sinks keep running, enabled or disabled as they were.
+func (hs *historySinks) configure(sinks []Sink, deadLetterDir string) {
+	hs.mutex.Lock()
+	current := make(map[string]*sinkRunner, len(hs.runners))
+	for _, runner := range hs.runners {
+		current[runner.sink.Name] = runner
+	}
+	runners := make([]*sinkRunner, 0, len(sinks))
+	var stopped []*sinkRunner
+	for _, sink := range sinks {
+		if runner, ok := current[sink.Name]; ok && reflect.DeepEqual(runner.sink, sink) && runner.deadLetterDir == deadLetterDir {
+			runners = append(runners, runner)
+			delete(current, sink.Name)
+			continue
+		}
+		if runner, ok := current[sink.Name]; ok {
+			stopped = append(stopped, runner)
+			delete(current, sink.Name)
+		}
+		runners = append(runners, startSinkRunner(sink, hs.client, deadLetterDir))
+	}
+	for _, runner := range current {
+		stopped = append(stopped, runner)
+	}
+	hs.runners = runners
+	hs.mutex.Unlock()
+
+	for _, runner := range stopped {
+		runner.stop()
+	}
+}
+
+// publish offers a record to every sink
+func (hs *historySinks) publish(record RequestRecord) {
+	hs.mutex.Lock()
+	defer hs.mutex.Unlock()
+	for _, runner := range hs.runners {
+		runner.offer(record)
+	}
+}
+
+// runner returns the sink named name, or nil
+func (hs *historySinks) runner(name string) *sinkRunner {
+	hs.mutex.Lock()
+	defer hs.mutex.Unlock()
+	for _, runner := range hs.runners {
+		if runner.sink.Name == name {
+			return runner
+		}
+	}
+	return nil
+}
+
+// status lists every sink in configuration order
+func (hs *historySinks) status() []SinkStatus {
+	hs.mutex.Lock()
+	defer hs.mutex.Unlock()
+	statuses := make([]SinkStatus, 0, len(hs.runners))
+	for _, runner := range hs.runners {
+		statuses = append(statuses, runner.status())
+	}
+	return statuses
+}
+
+// writeSinkMetrics appends the delivery counters of every sink in the
+// Prometheus text format
+func (hs *historySinks) writeSinkMetrics(b *strings.Builder) {
+	statuses := hs.status()
+	metrics := []struct {
+		name, kind, help string
+		value            func(SinkStatus) int64
+	}{
+		{"netkit_sink_delivered_records_total", "counter", "Records delivered by a sink", func(s SinkStatus) int64 { return s.Delivered }},
+		{"netkit_sink_delivered_batches_total", "counter", "Batches delivered by a sink", func(s SinkStatus) int64 { return s.Batches }},
+		{"netkit_sink_failed_records_total", "counter", "Records a sink gave up on after every retry failed", func(s SinkStatus) int64 { return s.Failed }},
+		{"netkit_sink_failed_batches_total", "counter", "Batches a sink gave up on after every retry failed", func(s SinkStatus) int64 { return s.FailedBatches }},
+		{"netkit_sink_dropped_records_total", "counter", "Records dropped because a sink's queue was full", func(s SinkStatus) int64 { return s.Dropped }},
+		{"netkit_sink_dead_lettered_records_total", "counter", "Failed records spooled to the dead-letter directory", func(s SinkStatus) int64 { return s.DeadLettered }},
+		{"netkit_sink_queued_records", "gauge", "Records waiting for a sink", func(s SinkStatus) int64 { return int64(s.Queued) }},
+		{"netkit_sink_dead_letters", "gauge", "Spooled batches waiting for a replay", func(s SinkStatus) int64 { return int64(s.DeadLetters) }},
+	}
+	for _, metric := range metrics {
+		fmt.Fprintf(b, "\n# HELP %s %s\n# TYPE %s %s\n", metric.name, metric.help, metric.name, metric.kind)
+		for _, status := range statuses {
+			fmt.Fprintf(b, "%s{sink=%q} %d\n", metric.name, status.Name, metric.value(status))
+		}
+	}
+}
+
+// stop delivers what every sink holds and closes them
+func (hs *historySinks) stop() {
+	hs.configure(nil, "")
+}
+
+// handleSinks lists the history sinks (GET /sinks)
+func (p *Proxy) handleSinks(w http.ResponseWriter, r *http.Request) {
+	// Add CORS headers
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, Cache-Control, Pragma, Expires")
+
+	// Handle preflight requests
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	data, err := json.Marshal(p.sinks.status())
+	if err != nil {
+		http.Error(w, "Failed to list sinks", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if _, err := w.Write(data); err != nil {
+		slog.Warn("Error writing sinks response", "error", err)
+	}
+}
+
+// handleSink enables (POST /sinks/{name}/enable) or disables (POST
+// /sinks/{name}/disable) a history sink at runtime, or redelivers its dead
+// letters (POST /sinks/{name}/replay)
+func (p *Proxy) handleSink(w http.ResponseWriter, r *http.Request) {
+	// Add CORS headers
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, Cache-Control, Pragma, Expires")
+
+	// Handle preflight requests
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	name, action, ok := strings.Cut(strings.TrimPrefix(r.URL.Path, "/sinks/"), "/")
+	if !ok || (action != "enable" && action != "disable" && action != "replay") {
+		http.Error(w, "Not found", http.StatusNotFound)
+		return
+	}
+	runner := p.sinks.runner(name)
+	if runner == nil {
+		http.Error(w, "Sink not found", http.StatusNotFound)
+		return
+	}
+	var data []byte
+	var err error
+	status := http.StatusOK
+	if action == "replay" {
+		if runner.deadLetterDir == "" {
+			http.Error(w, "No dead letters are kept without --sink-dead-letter-dir", http.StatusConflict)
+			return
+		}
+		replay := runner.replay(r.Context())
+		if replay.Error != "" {
+			status = http.StatusBadGateway
+		}
+		data, err = json.Marshal(replay)
+	} else {
+		runner.setEnabled(action == "enable")
+		data, err = json.Marshal(runner.status())
+	}
+	if err != nil {
+		http.Error(w, "Failed to update sink", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if _, err := w.Write(data); err != nil {
+		slog.Warn("Error writing sink response", "error", err)
+	}
+}
@@ -0,0 +1,388 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// newSinkWriter returns the writer for a sink's kind
+func newSinkWriter(sink Sink, client *http.Client) sinkWriter {
+	switch sink.Kind {
+	case SinkFile:
+		return &fileSinkWriter{path: sink.Target}
+	case SinkCollector:
+		return &postSinkWriter{client: client, url: collectorURL(sink.Target, "/v1/logs"), contentType: "application/json", encode: encodeOTLPLogs}
+	case SinkKafka:
+		return &postSinkWriter{client: client, url: sink.Target, contentType: "application/vnd.kafka.json.v2+json", encode: encodeKafkaRecords}
+	case SinkS3:
+		target, _ := parseS3Target(sink.Target)
+		return &s3SinkWriter{client: client, target: target}
+	default:
+		return &postSinkWriter{client: client, url: sink.Target, contentType: "application/json", encode: func(records []RequestRecord) ([]byte, error) {
+			return json.Marshal(records)
+		}}
+	}
+}
+
+// encodeNDJSON writes one JSON record per line
+func encodeNDJSON(records []RequestRecord) ([]byte, error) {
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	for _, record := range records {
+		if err := encoder.Encode(record); err != nil {
+			return nil, err
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+// fileSinkWriter appends records to a file as NDJSON, opening it on the
+// first batch
+type fileSinkWriter struct {
+	path string
+	file *os.File
+}
+
+func (w *fileSinkWriter) write(_ context.Context, records []RequestRecord) error {
+	data, err := encodeNDJSON(records)
+	if err != nil {
+		return err
+	}
+	if w.file == nil {
+		file, err := os.OpenFile(w.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+		if err != nil {
+			return err
+		}
+		w.file = file
+	}
+	_, err = w.file.Write(data)
+	return err
+}
+
+func (w *fileSinkWriter) close() error {
+	if w.file == nil {
+		return nil
+	}
+	return w.file.Close()
+}
+
+// postSinkWriter POSTs each batch to a URL, encoded for the receiving system
+type postSinkWriter struct {
+	client      *http.Client
+	url         string
+	contentType string
+	encode      func([]RequestRecord) ([]byte, error)
+}
+
+func (w *postSinkWriter) write(ctx context.Context, records []RequestRecord) error {
+	body, err := w.encode(records)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", w.contentType)
+	return doSinkRequest(w.client, req)
+}
+
+func (w *postSinkWriter) close() error {
+	return nil
+}
+
+// doSinkRequest sends a delivery and turns non-2xx responses into errors
+func doSinkRequest(client *http.Client, req *http.Request) error {
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("%s responded %s: %s", req.URL.Host, resp.Status, strings.TrimSpace(string(message)))
+	}
+	_, _ = io.Copy(io.Discard, resp.Body)
+	return nil
+}
+
+// encodeKafkaRecords encodes a batch for the Kafka REST Proxy v2 produce API,
+// keyed by record ID
+func encodeKafkaRecords(records []RequestRecord) ([]byte, error) {
+	type kafkaRecord struct {
+		Key   string        `json:"key"`
+		Value RequestRecord `json:"value"`
+	}
+	batch := struct {
+		Records []kafkaRecord `json:"records"`
+	}{Records: make([]kafkaRecord, 0, len(records))}
+	for _, record := range records {
+		batch.Records = append(batch.Records, kafkaRecord{Key: record.ID, Value: record})
+	}
+	return json.Marshal(batch)
+}
+
+// collectorURL adds an OTLP/HTTP signal path, such as /v1/logs, to a
+// collector URL without one
+func collectorURL(target, signalPath string) string {
+	parsed, err := url.Parse(target)
+	if err != nil || strings.Trim(parsed.Path, "/") != "" {
+		return target
+	}
+	parsed.Path = signalPath
+	return parsed.String()
+}
+
+// otlpValue is an OTLP/JSON AnyValue; 64-bit integers are strings in OTLP/JSON
+type otlpValue struct {
+	StringValue *string `json:"stringValue,omitempty"`
+	IntValue    *string `json:"intValue,omitempty"`
+}
+
+type otlpAttribute struct {
+	Key   string    `json:"key"`
+	Value otlpValue `json:"value"`
+}
+
+func otlpString(key, value string) otlpAttribute {
+	return otlpAttribute{Key: key, Value: otlpValue{StringValue: &value}}
+}
+
+func otlpInt(key string, value int64) otlpAttribute {
+	formatted := strconv.FormatInt(value, 10)
+	return otlpAttribute{Key: key, Value: otlpValue{IntValue: &formatted}}
+}
+
+// encodeOTLPLogs encodes a batch as OTLP/HTTP JSON logs, one log record per
+// request with the record as its body
+func encodeOTLPLogs(records []RequestRecord) ([]byte, error) {
+	type logRecord struct {
+		TimeUnixNano   string          `json:"timeUnixNano"`
+		SeverityNumber int             `json:"severityNumber"`
+		SeverityText   string          `json:"severityText"`
+		Body           otlpValue       `json:"body"`
+		Attributes     []otlpAttribute `json:"attributes"`
+	}
+	logRecords := make([]logRecord, 0, len(records))
+	for _, record := range records {
+		data, err := json.Marshal(record)
+		if err != nil {
+			return nil, err
+		}
+		body := string(data)
+		severityNumber, severityText := 9, "INFO"
+		if isFailedRecord(record) {
+			severityNumber, severityText = 17, "ERROR"
+		}
+		logRecords = append(logRecords, logRecord{
+			TimeUnixNano:   strconv.FormatInt(record.Timestamp.UnixNano(), 10),
+			SeverityNumber: severityNumber,
+			SeverityText:   severityText,
+			Body:           otlpValue{StringValue: &body},
+			Attributes: []otlpAttribute{
+				otlpString("netkit.record_id", record.ID),
+				otlpString("http.request.method", record.Method),
+				otlpString("url.full", record.URL),
+				otlpInt("http.response.status_code", int64(record.ResponseStatus)),
+				otlpInt("netkit.duration_us", record.TotalDurationUs),
+			},
+		})
+	}
+
+	return json.Marshal(map[string]interface{}{
+		"resourceLogs": []interface{}{map[string]interface{}{
+			"resource": map[string]interface{}{
+				"attributes": []otlpAttribute{otlpString("service.name", "netkit")},
+			},
+			"scopeLogs": []interface{}{map[string]interface{}{
+				"scope":      map[string]string{"name": "netkit"},
+				"logRecords": logRecords,
+			}},
+		}},
+	})
+}
+
+// s3Target is where an s3 sink writes its objects
+type s3Target struct {
+	Bucket   string
+	Prefix   string
+	Region   string // Default: $AWS_REGION, or us-east-1
+	Endpoint string // S3-compatible endpoint addressed path-style, e.g. http://localhost:9000 (default: AWS)
+}
+
+// parseS3Target parses "s3://bucket/prefix?region=REGION&endpoint=URL"
+func parseS3Target(target string) (s3Target, error) {
+	parsed, err := url.Parse(target)
+	if err != nil || parsed.Scheme != "s3" || parsed.Host == "" {
+		return s3Target{}, fmt.Errorf("s3 target must be s3://bucket/prefix")
+	}
+	query := parsed.Query()
+	s3 := s3Target{
+		Bucket:   parsed.Host,
+		Prefix:   strings.TrimPrefix(parsed.Path, "/"),
+		Region:   query.Get("region"),
+		Endpoint: strings.TrimSuffix(query.Get("endpoint"), "/"),
+	}
+	if s3.Region == "" {
+		s3.Region = os.Getenv("AWS_REGION")
+	}
+	if s3.Region == "" {
+		s3.Region = "us-east-1"
+	}
+	if s3.Endpoint != "" {
+		if endpoint, err := url.Parse(s3.Endpoint); err != nil || (endpoint.Scheme != "http" && endpoint.Scheme != "https") || endpoint.Host == "" {
+			return s3Target{}, fmt.Errorf("s3 endpoint must be an http(s) URL")
+		}
+	}
+	return s3, nil
+}
+
+// s3SinkWriter PUTs each batch as an NDJSON object, signed with the
+// credentials in AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY, and
+// AWS_SESSION_TOKEN
+type s3SinkWriter struct {
+	client *http.Client
+	target s3Target
+
+	mutex    sync.Mutex
+	sequence int
+}
+
+func (w *s3SinkWriter) write(ctx context.Context, records []RequestRecord) error {
+	body, err := encodeNDJSON(records)
+	if err != nil {
+		return err
+	}
+	w.mutex.Lock()
+	w.sequence++
+	key := fmt.Sprintf("%snetkit-%s-%06d.ndjson", w.target.Prefix, time.Now().UTC().Format("20060102T150405.000Z"), w.sequence)
+	w.mutex.Unlock()
+
+	objectURL := fmt.Sprintf("https://%s.s3.%s.amazonaws.com/%s", w.target.Bucket, w.target.Region, escapeS3Key(key))
+	if w.target.Endpoint != "" {
+		objectURL = fmt.Sprintf("%s/%s/%s", w.target.Endpoint, w.target.Bucket, escapeS3Key(key))
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, objectURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-ndjson")
+
+	credentials := awsCredentials{
+		AccessKeyID:     os.Getenv("AWS_ACCESS_KEY_ID"),
+		SecretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		SessionToken:    os.Getenv("AWS_SESSION_TOKEN"),
+	}
+	if credentials.AccessKeyID == "" || credentials.SecretAccessKey == "" {
+		return fmt.Errorf("AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY must be set")
+	}
+	signAWSRequest(req, body, credentials, w.target.Region, "s3", time.Now())
+	return doSinkRequest(w.client, req)
+}
+
+func (w *s3SinkWriter) close() error {
+	return nil
+}
+
+// escapeS3Key escapes each segment of an object key
+func escapeS3Key(key string) string {
+	segments := strings.Split(key, "/")
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
+	}
+	return strings.Join(segments, "/")
+}
+
+// awsCredentials sign requests to AWS
+type awsCredentials struct {
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+}
+
+// signAWSRequest adds a Signature Version 4 Authorization header to req,
+// signing the host and every header already set
+func signAWSRequest(req *http.Request, body []byte, credentials awsCredentials, region, service string, now time.Time) {
+	now = now.UTC()
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	payloadHash := sha256.Sum256(body)
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", hex.EncodeToString(payloadHash[:]))
+	if credentials.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", credentials.SessionToken)
+	}
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		headers[strings.ToLower(name)] = strings.TrimSpace(strings.Join(values, ","))
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	path := req.URL.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	query := req.URL.Query()
+	queryKeys := make([]string, 0, len(query))
+	for key := range query {
+		queryKeys = append(queryKeys, key)
+	}
+	sort.Strings(queryKeys)
+	var canonicalQuery []string
+	for _, key := range queryKeys {
+		for _, value := range query[key] {
+			canonicalQuery = append(canonicalQuery, url.QueryEscape(key)+"="+url.QueryEscape(value))
+		}
+	}
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		path,
+		strings.ReplaceAll(strings.Join(canonicalQuery, "&"), "+", "%20"),
+		canonicalHeaders.String(),
+		signedHeaders,
+		hex.EncodeToString(payloadHash[:]),
+	}, "\n")
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	scope := date + "/" + region + "/" + service + "/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+
+	key := hmacSHA256([]byte("AWS4"+credentials.SecretAccessKey), date)
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, service)
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		credentials.AccessKeyID, scope, signedHeaders, signature))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,314 @@
+package proxy
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Request smuggling probe techniques, named front end then back end by the
+// header each one uses to find the end of a request body
+const (
+	SmugglingCLTE = "cl.te" // Front end uses Content-Length, back end Transfer-Encoding
+	SmugglingTECL = "te.cl" // Front end uses Transfer-Encoding, back end Content-Length
+)
+
+// SmugglingTechniques are the techniques a scan probes by default, in the
+// order they are sent
+var SmugglingTechniques = []string{SmugglingCLTE, SmugglingTECL}
+
+// Outcomes of a smuggling probe
+const (
+	SmugglingTimedOut     = "timeout"      // No response in time on every attempt while the control was answered: a likely desync
+	SmugglingResponded    = "responded"    // The target answered
+	SmugglingClosed       = "closed"       // The target closed the connection without answering
+	SmugglingInconclusive = "inconclusive" // The probe timed out, but so did its control
+	SmugglingSkipped      = "skipped"      // Not sent, to keep the probe from affecting other clients
+)
+
+// Smuggling scan defaults
+const (
+	DefaultSmugglingTimeout  = 5 * time.Second
+	DefaultSmugglingAttempts = 2
+)
+
+// smugglingCaptureLimit is how many bytes of each probe's response a scan
+// reports
+const smugglingCaptureLimit = 4096
+
+// smugglingVariants are the ways a probe writes its Transfer-Encoding header.
+// Servers that disagree about which forms count are what the obfuscated ones
+// look for.
+var smugglingVariants = []struct {
+	name   string
+	header string
+}{
+	{"plain", "Transfer-Encoding: chunked"},
+	{"space-before-colon", "Transfer-Encoding : chunked"},
+	{"tab", "Transfer-Encoding:\tchunked"},
+	{"duplicate", "Transfer-Encoding: chunked\r\nTransfer-Encoding: identity"},
+	{"line-folded", "Transfer-Encoding:\r\n chunked"},
+	{"bare-lf", "X-Netkit-Probe: 1\nTransfer-Encoding: chunked"},
+	{"quoted", "Transfer-Encoding: \"chunked\""},
+}
+
+// ValidateSmugglingTechnique checks a smuggling probe technique name
+func ValidateSmugglingTechnique(technique string) error {
+	for _, known := range SmugglingTechniques {
+		if technique == known {
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown technique %q: expected one of %s", technique, strings.Join(SmugglingTechniques, ", "))
+}
+
+// SmugglingOptions configures a request smuggling scan
+type SmugglingOptions struct {
+	Target     string        // http or https URL probed with POST requests
+	Via        string        // host:port of an HTTP proxy to send probes through, in absolute form, for http targets (optional)
+	Techniques []string      // Techniques to probe (default: SmugglingTechniques)
+	Timeout    time.Duration // How long a probe waits for a response (default: 5s)
+	Attempts   int           // Times a probe must time out to be reported (default: 2)
+	Insecure   bool          // Skip verifying the target's certificate
+}
+
+// SmugglingProbe is the result of one technique with one Transfer-Encoding
+// variant
+type SmugglingProbe struct {
+	Technique  string `json:"technique"`
+	Variant    string `json:"variant"`
+	Outcome    string `json:"outcome"`
+	Status     int    `json:"status,omitempty"` // Response status when the target responded
+	DurationMs int64  `json:"duration_ms"`      // Time to the response or timeout of the last attempt
+	Suspicious bool   `json:"suspicious"`       // The outcome suggests front and back end disagree on where requests end
+	Note       string `json:"note,omitempty"`
+	Sent       string `json:"sent,omitempty"`     // Raw bytes of the last attempt
+	Received   string `json:"received,omitempty"` // Raw bytes read back, up to 4 KiB
+}
+
+// SmugglingReport is the result of a request smuggling scan
+type SmugglingReport struct {
+	Target         string           `json:"target"`
+	Via            string           `json:"via,omitempty"`
+	BaselineStatus int              `json:"baseline_status"`
+	BaselineMs     int64            `json:"baseline_ms"`
+	TimeoutMs      int64            `json:"timeout_ms"`
+	Probes         []SmugglingProbe `json:"probes"`
+	Findings       int              `json:"findings"` // Suspicious probes
+}
+
+// smugglingScanner sends raw probes to one target
+type smugglingScanner struct {
+	target   *url.URL
+	address  string // Where connections go: the target or the proxy it is reached through
+	via      bool
+	timeout  time.Duration
+	insecure bool
+}
+
+// smugglingAttempt is what one raw exchange produced
+type smugglingAttempt struct {
+	outcome  string
+	status   int
+	elapsed  time.Duration
+	sent     []byte
+	received []byte
+}
+
+// RunSmugglingScan probes a target for HTTP request smuggling with the
+// timing technique: each probe is framed so that a front end and back end
+// that disagree on the body length leave the back end waiting for bytes that
+// never come, which shows as a timeout, while servers that agree answer or
+// reject it right away. A matching control request with consistent framing
+// rules out targets that are just slow. TE.CL probes are skipped for variants
+// whose CL.TE probe timed out, since against such a front end they would
+// leave a stray byte on its connection to the back end, in front of another
+// client's request. Only run scans against systems you are allowed to test.
+func RunSmugglingScan(ctx context.Context, options SmugglingOptions) (*SmugglingReport, error) {
+	target, err := url.Parse(options.Target)
+	if err != nil || (target.Scheme != "http" && target.Scheme != "https") || target.Host == "" {
+		return nil, fmt.Errorf("target must be an http or https URL, got %q", options.Target)
+	}
+	if options.Via != "" && target.Scheme != "http" {
+		return nil, fmt.Errorf("probes can only be sent through a proxy to http targets")
+	}
+	// CL.TE always goes first, since its outcome decides whether TE.CL is safe
+	requested := map[string]bool{}
+	for _, technique := range options.Techniques {
+		if err := ValidateSmugglingTechnique(technique); err != nil {
+			return nil, err
+		}
+		requested[technique] = true
+	}
+	var techniques []string
+	for _, technique := range SmugglingTechniques {
+		if len(requested) == 0 || requested[technique] {
+			techniques = append(techniques, technique)
+		}
+	}
+	if options.Timeout <= 0 {
+		options.Timeout = DefaultSmugglingTimeout
+	}
+	if options.Attempts <= 0 {
+		options.Attempts = DefaultSmugglingAttempts
+	}
+
+	scanner := &smugglingScanner{target: target, address: options.Via, via: options.Via != "", timeout: options.Timeout, insecure: options.Insecure}
+	if scanner.address == "" {
+		host, port := serverAddress(target.String())
+		scanner.address = net.JoinHostPort(host, strconv.FormatInt(port, 10))
+	}
+	report := &SmugglingReport{Target: target.String(), Via: options.Via, TimeoutMs: options.Timeout.Milliseconds(), Probes: []SmugglingProbe{}}
+
+	// A target that cannot answer a plain request in time gives no signal
+	baseline := scanner.exchange(ctx, scanner.request(http.MethodGet, nil, ""))
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if baseline.outcome != SmugglingResponded {
+		return nil, fmt.Errorf("baseline request to %s got no response (%s)", target.Host, baseline.outcome)
+	}
+	report.BaselineStatus, report.BaselineMs = baseline.status, baseline.elapsed.Milliseconds()
+
+	for _, variant := range smugglingVariants {
+		backendWaits := false
+		for _, technique := range techniques {
+			if technique == SmugglingTECL && backendWaits {
+				report.Probes = append(report.Probes, SmugglingProbe{Technique: technique, Variant: variant.name, Outcome: SmugglingSkipped,
+					Note: "the front end appears to use Content-Length, so this probe would leave a byte on its back end connection"})
+				continue
+			}
+			probe := scanner.probe(ctx, technique, variant.name, variant.header, options.Attempts)
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+			if probe.Suspicious {
+				report.Findings++
+				backendWaits = backendWaits || technique == SmugglingCLTE
+			}
+			report.Probes = append(report.Probes, probe)
+		}
+	}
+	return report, nil
+}
+
+// probe sends a technique's probe until it is answered or has timed out on
+// every attempt, then checks a timeout against the control request
+func (s *smugglingScanner) probe(ctx context.Context, technique, variant, teHeader string, attempts int) SmugglingProbe {
+	// Both requests declare chunked bodies; only the probe's Content-Length
+	// disagrees with where the chunked body ends
+	var probeBody, probeLength, controlBody string
+	switch technique {
+	case SmugglingCLTE:
+		// A Content-Length front end forwards "1\r\nA"; a chunked back end then
+		// waits for the next chunk, while a chunked front end rejects the X
+		probeBody, probeLength, controlBody = "1\r\nA\r\nX\r\n", "4", "1\r\nA\r\n0\r\n\r\n"
+	case SmugglingTECL:
+		// A chunked front end forwards "0\r\n\r\n"; a Content-Length back end then waits for the sixth byte
+		probeBody, probeLength, controlBody = "0\r\n\r\nX", "6", "0\r\n\r\n"
+	}
+	headers := []string{teHeader, "Content-Length: " + probeLength}
+
+	var attempt smugglingAttempt
+	for i := 0; i < attempts; i++ {
+		attempt = s.exchange(ctx, s.request(http.MethodPost, headers, probeBody))
+		if attempt.outcome != SmugglingTimedOut || ctx.Err() != nil {
+			break
+		}
+	}
+	probe := SmugglingProbe{
+		Technique:  technique,
+		Variant:    variant,
+		Outcome:    attempt.outcome,
+		Status:     attempt.status,
+		DurationMs: attempt.elapsed.Milliseconds(),
+		Sent:       string(attempt.sent),
+		Received:   string(attempt.received),
+	}
+	if attempt.outcome != SmugglingTimedOut {
+		return probe
+	}
+
+	control := s.exchange(ctx, s.request(http.MethodPost, []string{teHeader, "Content-Length: " + strconv.Itoa(len(controlBody))}, controlBody))
+	if control.outcome == SmugglingTimedOut {
+		probe.Outcome = SmugglingInconclusive
+		probe.Note = "the control request timed out too"
+		return probe
+	}
+	probe.Suspicious = true
+	probe.Note = fmt.Sprintf("timed out on %d attempts while the control request was answered in %dms", attempts, control.elapsed.Milliseconds())
+	return probe
+}
+
+// request writes a raw request to the target, in absolute form through a
+// proxy, with the extra header lines exactly as given
+func (s *smugglingScanner) request(method string, headers []string, body string) []byte {
+	requestTarget := s.target.RequestURI()
+	if s.via {
+		requestTarget = s.target.String()
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s %s HTTP/1.1\r\nHost: %s\r\nUser-Agent: netkit-scan\r\nAccept: */*\r\n", method, requestTarget, s.target.Host)
+	for _, header := range headers {
+		b.WriteString(header + "\r\n")
+	}
+	if method == http.MethodGet {
+		b.WriteString("Connection: close\r\n")
+	}
+	b.WriteString("\r\n" + body)
+	return []byte(b.String())
+}
+
+// exchange sends raw on a new connection and reads the response head,
+// recording the bytes on the wire
+func (s *smugglingScanner) exchange(ctx context.Context, raw []byte) smugglingAttempt {
+	start := time.Now()
+	capture := newWireCapture(smugglingCaptureLimit)
+	result := func(outcome string, status int) smugglingAttempt {
+		sent, received, _ := capture.result()
+		return smugglingAttempt{outcome: outcome, status: status, elapsed: time.Since(start), sent: sent, received: received}
+	}
+
+	dialer := &net.Dialer{Timeout: s.timeout}
+	conn, err := dialer.DialContext(ctx, "tcp", s.address)
+	if err != nil {
+		return result(SmugglingClosed, 0)
+	}
+	defer conn.Close()
+	if s.target.Scheme == "https" {
+		conn = tls.Client(conn, &tls.Config{ServerName: s.target.Hostname(), InsecureSkipVerify: s.insecure, NextProtos: []string{"http/1.1"}})
+	}
+	conn = &wireConn{Conn: conn, capture: capture}
+	stop := context.AfterFunc(ctx, func() { _ = conn.SetDeadline(time.Now()) })
+	defer stop()
+	if err := conn.SetDeadline(time.Now().Add(s.timeout)); err != nil {
+		return result(SmugglingClosed, 0)
+	}
+	if _, err := conn.Write(raw); err != nil {
+		return result(SmugglingClosed, 0)
+	}
+	// Time from when the probe is on the wire, not from the TLS handshake
+	start = time.Now()
+	if err := conn.SetDeadline(start.Add(s.timeout)); err != nil {
+		return result(SmugglingClosed, 0)
+	}
+
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	var netErr net.Error
+	switch {
+	case err == nil:
+		return result(SmugglingResponded, resp.StatusCode)
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return result(SmugglingTimedOut, 0)
+	default:
+		return result(SmugglingClosed, 0)
+	}
+}
@@ -0,0 +1,160 @@
+//go:build unit
+
+package proxy
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httputil"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// startDesyncServer emulates a Content-Length front end before a chunked back
+// end: bodies of requests with both headers are cut at Content-Length, and
+// get no response when the cut body is not a complete chunked body
+func startDesyncServer(t *testing.T) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				reader := bufio.NewReader(conn)
+				length := -1
+				chunked := false
+				for {
+					line, err := reader.ReadString('\n')
+					if err != nil {
+						return
+					}
+					line = strings.TrimSuffix(line, "\r\n")
+					if line == "" {
+						break
+					}
+					if value, ok := strings.CutPrefix(line, "Content-Length: "); ok {
+						length, _ = strconv.Atoi(value)
+					}
+					chunked = chunked || line == "Transfer-Encoding: chunked"
+				}
+				if chunked && length < 0 {
+					_, _ = io.Copy(io.Discard, httputil.NewChunkedReader(reader))
+					_, _ = io.WriteString(conn, "HTTP/1.1 200 OK\r\nContent-Length: 0\r\nConnection: close\r\n\r\n")
+					return
+				}
+				body := make([]byte, max(length, 0))
+				if _, err := io.ReadFull(reader, body); err != nil {
+					return
+				}
+				if chunked && !strings.HasSuffix(string(body), "0\r\n\r\n") {
+					_, _ = io.Copy(io.Discard, conn)
+					return
+				}
+				_, _ = io.WriteString(conn, "HTTP/1.1 200 OK\r\nContent-Length: 0\r\nConnection: close\r\n\r\n")
+			}()
+		}
+	}()
+	return ln.Addr().String()
+}
+
+func TestSmugglingScanFindsDesync(t *testing.T) {
+	addr := startDesyncServer(t)
+	report, err := RunSmugglingScan(context.Background(), SmugglingOptions{Target: "http://" + addr + "/login", Timeout: 200 * time.Millisecond})
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, report.BaselineStatus)
+	require.Len(t, report.Probes, 2*len(smugglingVariants))
+
+	// The back end only understands the plain, duplicated, and bare LF headers
+	assert.Equal(t, 3, report.Findings)
+	plain, plainTECL := report.Probes[0], report.Probes[1]
+	assert.Equal(t, SmugglingCLTE, plain.Technique)
+	assert.Equal(t, "plain", plain.Variant)
+	assert.Equal(t, SmugglingTimedOut, plain.Outcome)
+	assert.True(t, plain.Suspicious)
+	assert.True(t, strings.HasPrefix(plain.Sent, "POST /login HTTP/1.1\r\nHost: "+addr+"\r\n"))
+	assert.True(t, strings.HasSuffix(plain.Sent, "Transfer-Encoding: chunked\r\nContent-Length: 4\r\n\r\n1\r\nA\r\nX\r\n"))
+	assert.Equal(t, SmugglingSkipped, plainTECL.Outcome, "TE.CL would leave a byte on the back end")
+
+	tab := report.Probes[4]
+	assert.Equal(t, "tab", tab.Variant)
+	assert.Equal(t, SmugglingResponded, tab.Outcome)
+	assert.Equal(t, http.StatusOK, tab.Status)
+	assert.False(t, tab.Suspicious)
+	assert.Contains(t, tab.Received, "HTTP/1.1 200 OK")
+}
+
+func TestSmugglingScanThroughProxy(t *testing.T) {
+	backend := startDesyncServer(t)
+	p := New(&Config{})
+	front := httptest.NewServer(p)
+	defer front.Close()
+
+	// Go's server picks chunked framing and drops Content-Length before the
+	// request reaches the back end, so the two never disagree
+	report, err := RunSmugglingScan(context.Background(), SmugglingOptions{
+		Target:     "http://" + backend + "/",
+		Via:        strings.TrimPrefix(front.URL, "http://"),
+		Techniques: []string{SmugglingTECL, SmugglingCLTE},
+		Timeout:    500 * time.Millisecond,
+	})
+	require.NoError(t, err)
+	assert.Zero(t, report.Findings)
+	assert.Equal(t, SmugglingCLTE, report.Probes[0].Technique, "CL.TE always goes first")
+	assert.True(t, strings.HasPrefix(report.Probes[0].Sent, "POST http://"+backend+"/ HTTP/1.1\r\n"))
+}
+
+func TestSmugglingScanInconclusive(t *testing.T) {
+	// A target that answers GETs but never POSTs times out on probe and control alike
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				line, _ := bufio.NewReader(conn).ReadString('\n')
+				if strings.HasPrefix(line, "GET ") {
+					_, _ = io.WriteString(conn, "HTTP/1.1 204 No Content\r\n\r\n")
+					return
+				}
+				_, _ = io.Copy(io.Discard, conn)
+			}()
+		}
+	}()
+
+	report, err := RunSmugglingScan(context.Background(), SmugglingOptions{Target: "http://" + ln.Addr().String(), Techniques: []string{SmugglingCLTE}, Timeout: 100 * time.Millisecond, Attempts: 1})
+	require.NoError(t, err)
+	assert.Zero(t, report.Findings)
+	require.Len(t, report.Probes, len(smugglingVariants))
+	assert.Equal(t, SmugglingInconclusive, report.Probes[0].Outcome)
+}
+
+func TestSmugglingScanValidation(t *testing.T) {
+	_, err := RunSmugglingScan(context.Background(), SmugglingOptions{Target: "ftp://example.com"})
+	assert.Error(t, err)
+	_, err = RunSmugglingScan(context.Background(), SmugglingOptions{Target: "https://example.com", Via: "127.0.0.1:8080"})
+	assert.Error(t, err)
+	_, err = RunSmugglingScan(context.Background(), SmugglingOptions{Target: "http://example.com", Techniques: []string{"h2.cl"}})
+	assert.Error(t, err)
+	_, err = RunSmugglingScan(context.Background(), SmugglingOptions{Target: "http://127.0.0.1:1", Timeout: 100 * time.Millisecond})
+	assert.ErrorContains(t, err, "baseline request")
+}
@@ -0,0 +1,166 @@
+package proxy
+
+import (
+	"bufio"
+	"crypto/tls"
+	"errors"
+	"log/slog"
+	"net"
+	"sync"
+	"time"
+)
+
+// Leading bytes used to tell protocols apart on a shared listener
+const (
+	tlsHandshakeRecord = 0x16
+	socks4Version      = 0x04
+	socks5Version      = 0x05
+)
+
+// sniffTimeout bounds how long a new connection may take to send its first byte
+const sniffTimeout = 10 * time.Second
+
+// peekedConn is a net.Conn whose first bytes were buffered while sniffing
+type peekedConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+func (c *peekedConn) Read(b []byte) (int, error) {
+	return c.reader.Read(b)
+}
+
+// CloseWrite half-closes the underlying TCP connection when supported
+func (c *peekedConn) CloseWrite() error {
+	if hc, ok := c.Conn.(interface{ CloseWrite() error }); ok {
+		return hc.CloseWrite()
+	}
+	return nil
+}
+
+// sniffListener wraps the proxy listener and dispatches each connection by its
+// first byte: TLS handshakes are terminated and served as HTTP, SOCKS
+// handshakes are tunneled directly, and everything else is plain HTTP
+type sniffListener struct {
+	net.Listener
+	proxy     *Proxy
+	tlsConfig *tls.Config // Nil when TLS termination is not configured
+
+	conns     chan net.Conn
+	done      chan struct{}
+	closeOnce sync.Once
+	acceptErr error
+}
+
+func newSniffListener(ln net.Listener, p *Proxy, tlsConfig *tls.Config) *sniffListener {
+	l := &sniffListener{
+		Listener:  ln,
+		proxy:     p,
+		tlsConfig: tlsConfig,
+		conns:     make(chan net.Conn),
+		done:      make(chan struct{}),
+	}
+	go l.acceptLoop()
+	return l
+}
+
+// Accept returns the next connection that should be served as HTTP
+func (l *sniffListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.done:
+		if l.acceptErr != nil {
+			return nil, l.acceptErr
+		}
+		return nil, net.ErrClosed
+	}
+}
+
+// Close stops accepting connections
+func (l *sniffListener) Close() error {
+	err := l.Listener.Close()
+	l.closeOnce.Do(func() { close(l.done) })
+	return err
+}
+
+func (l *sniffListener) acceptLoop() {
+	for {
+		conn, err := l.Listener.Accept()
+		if err != nil {
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				continue
+			}
+			l.acceptErr = err
+			l.closeOnce.Do(func() { close(l.done) })
+			return
+		}
+		go l.dispatch(conn)
+	}
+}
+
+func (l *sniffListener) dispatch(conn net.Conn) {
+	reader := bufio.NewReader(conn)
+
+	if err := conn.SetReadDeadline(time.Now().Add(sniffTimeout)); err != nil {
+		l.closeConn(conn)
+		return
+	}
+	first, err := reader.Peek(1)
+	if err != nil {
+		l.closeConn(conn)
+		return
+	}
+	if err := conn.SetReadDeadline(time.Time{}); err != nil {
+		l.closeConn(conn)
+		return
+	}
+
+	peeked := &peekedConn{Conn: conn, reader: reader}
+
+	switch first[0] {
+	case tlsHandshakeRecord:
+		if l.tlsConfig == nil {
+			slog.Warn("Rejecting TLS connection, no TLS certificate configured", "client", conn.RemoteAddr().String())
+			l.closeConn(conn)
+			return
+		}
+		l.deliver(tls.Server(peeked, l.tlsConfig))
+	case socks4Version, socks5Version:
+		l.proxy.handleSOCKS(peeked)
+	default:
+		l.deliver(peeked)
+	}
+}
+
+// deliver hands a connection to the HTTP server
+func (l *sniffListener) deliver(conn net.Conn) {
+	select {
+	case l.conns <- conn:
+	case <-l.done:
+		l.closeConn(conn)
+	}
+}
+
+func (l *sniffListener) closeConn(conn net.Conn) {
+	if err := conn.Close(); err != nil {
+		slog.Warn("Error closing sniffed connection", "error", err)
+	}
+}
+
+// LoadTLSConfig loads the certificate used to terminate TLS on the proxy port.
+// It returns a nil config when neither file is given.
+func LoadTLSConfig(certFile, keyFile string) (*tls.Config, error) {
+	if certFile == "" && keyFile == "" {
+		return nil, nil
+	}
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}, nil
+}
@@ -0,0 +1,225 @@
+//go:build unit
+
+package proxy
+
+import (
+	"bufio"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/binary"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// startSniffingProxy serves the proxy behind a sniffing listener on a random port
+func startSniffingProxy(t *testing.T, config *Config) (*Proxy, string) {
+	t.Helper()
+	config.ProtocolSniffing = true
+	p := New(config)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go func() {
+		if err := p.server.Serve(newSniffListener(ln, p, config.TLSConfig)); err != nil && err != http.ErrServerClosed {
+			t.Logf("Proxy server error: %v", err)
+		}
+	}()
+	t.Cleanup(func() {
+		if err := p.server.Close(); err != nil {
+			t.Logf("Error closing proxy server: %v", err)
+		}
+	})
+	return p, ln.Addr().String()
+}
+
+func newEchoUpstream(t *testing.T) *httptest.Server {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, err := w.Write([]byte("hello from upstream")); err != nil {
+			t.Logf("Error writing response: %v", err)
+		}
+	}))
+	t.Cleanup(upstream.Close)
+	return upstream
+}
+
+// selfSignedTLSConfig generates a throwaway certificate for 127.0.0.1
+func selfSignedTLSConfig(t *testing.T) *tls.Config {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "netkit test"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	return &tls.Config{Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}}}
+}
+
+func TestSniffPlainHTTP(t *testing.T) {
+	upstream := newEchoUpstream(t)
+	p, addr := startSniffingProxy(t, &Config{})
+
+	client := &http.Client{Transport: &http.Transport{Proxy: http.ProxyURL(&url.URL{Scheme: "http", Host: addr})}}
+	resp, err := client.Get(upstream.URL + "/plain")
+	require.NoError(t, err)
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "hello from upstream", string(body))
+	records := p.history.GetRecords()
+	require.Len(t, records, 1)
+	assert.Equal(t, upstream.URL+"/plain", records[0].URL)
+}
+
+func TestSniffTLS(t *testing.T) {
+	upstream := newEchoUpstream(t)
+	p, addr := startSniffingProxy(t, &Config{TLSConfig: selfSignedTLSConfig(t)})
+
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}}
+	req, err := http.NewRequest(http.MethodGet, "https://"+addr+"/", nil)
+	require.NoError(t, err)
+	req.Header.Set("X-Netkit-Destination", upstream.URL+"/secure")
+	resp, err := client.Do(req)
+	require.NoError(t, err)
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+
+	assert.Equal(t, "hello from upstream", string(body))
+	require.NotNil(t, resp.TLS, "connection should be TLS-terminated by the proxy")
+	records := p.history.GetRecords()
+	require.Len(t, records, 1)
+	assert.Equal(t, upstream.URL+"/secure", records[0].URL)
+}
+
+func TestSniffTLSWithoutCertificate(t *testing.T) {
+	_, addr := startSniffingProxy(t, &Config{})
+
+	conn, err := tls.Dial("tcp", addr, &tls.Config{InsecureSkipVerify: true})
+	if err == nil {
+		require.NoError(t, conn.Close())
+	}
+	assert.Error(t, err)
+}
+
+func TestSniffSOCKS5(t *testing.T) {
+	upstream := newEchoUpstream(t)
+	p, addr := startSniffingProxy(t, &Config{})
+	upstreamAddr := upstream.Listener.Addr().(*net.TCPAddr)
+
+	conn, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+
+	// Greeting offering "no authentication"
+	_, err = conn.Write([]byte{socks5Version, 1, socks5NoAuth})
+	require.NoError(t, err)
+	choice := make([]byte, 2)
+	_, err = io.ReadFull(conn, choice)
+	require.NoError(t, err)
+	assert.Equal(t, []byte{socks5Version, socks5NoAuth}, choice)
+
+	// CONNECT by domain name
+	host := "localhost"
+	request := []byte{socks5Version, socksCmdConnect, 0x00, socks5AddrDomain, byte(len(host))}
+	request = append(request, host...)
+	request = binary.BigEndian.AppendUint16(request, uint16(upstreamAddr.Port))
+	_, err = conn.Write(request)
+	require.NoError(t, err)
+	reply := make([]byte, 10)
+	_, err = io.ReadFull(conn, reply)
+	require.NoError(t, err)
+	assert.Equal(t, byte(socks5Succeeded), reply[1])
+
+	// Speak HTTP through the tunnel
+	req, err := http.NewRequest(http.MethodGet, upstream.URL+"/tunneled", nil)
+	require.NoError(t, err)
+	req.Close = true
+	require.NoError(t, req.Write(conn))
+	resp, err := http.ReadResponse(bufio.NewReader(conn), req)
+	require.NoError(t, err)
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Equal(t, "hello from upstream", string(body))
+
+	// The tunnel is recorded once both sides have closed
+	require.NoError(t, conn.Close())
+	require.Eventually(t, func() bool { return len(p.history.GetRecords()) == 1 }, 2*time.Second, 10*time.Millisecond)
+	record := p.history.GetRecords()[0]
+	assert.Equal(t, "CONNECT", record.Method)
+	assert.Equal(t, "socks5://localhost:"+resp.Request.URL.Port(), record.URL)
+	assert.Equal(t, upstreamAddr.String(), record.UpstreamAddr)
+	assert.True(t, record.Success)
+	assert.Positive(t, record.ResponseSize)
+}
+
+func TestSniffSOCKS4a(t *testing.T) {
+	upstream := newEchoUpstream(t)
+	p, addr := startSniffingProxy(t, &Config{})
+	upstreamAddr := upstream.Listener.Addr().(*net.TCPAddr)
+
+	conn, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+
+	request := []byte{socks4Version, socksCmdConnect}
+	request = binary.BigEndian.AppendUint16(request, uint16(upstreamAddr.Port))
+	request = append(request, 0, 0, 0, 1)
+	request = append(request, "user\x00localhost\x00"...)
+	_, err = conn.Write(request)
+	require.NoError(t, err)
+	reply := make([]byte, 8)
+	_, err = io.ReadFull(conn, reply)
+	require.NoError(t, err)
+	assert.Equal(t, byte(socks4Granted), reply[1])
+
+	require.NoError(t, conn.Close())
+	require.Eventually(t, func() bool { return len(p.history.GetRecords()) == 1 }, 2*time.Second, 10*time.Millisecond)
+	assert.Contains(t, p.history.GetRecords()[0].URL, "socks4://localhost:")
+}
+
+func TestSOCKS5UnsupportedCommand(t *testing.T) {
+	p, addr := startSniffingProxy(t, &Config{})
+
+	conn, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer func() {
+		if err := conn.Close(); err != nil {
+			t.Logf("Error closing connection: %v", err)
+		}
+	}()
+
+	_, err = conn.Write([]byte{socks5Version, 1, socks5NoAuth})
+	require.NoError(t, err)
+	choice := make([]byte, 2)
+	_, err = io.ReadFull(conn, choice)
+	require.NoError(t, err)
+
+	// BIND is not supported
+	_, err = conn.Write([]byte{socks5Version, 0x02, 0x00, socks5AddrIPv4, 127, 0, 0, 1, 0, 80})
+	require.NoError(t, err)
+	reply := make([]byte, 10)
+	_, err = io.ReadFull(conn, reply)
+	require.NoError(t, err)
+	assert.Equal(t, byte(socks5CommandUnsupported), reply[1])
+
+	require.Eventually(t, func() bool { return len(p.history.GetRecords()) == 1 }, 2*time.Second, 10*time.Millisecond)
+	assert.False(t, p.history.GetRecords()[0].Success)
+}
@@ -0,0 +1,286 @@
+package proxy
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"strconv"
+	"time"
+)
+
+// SOCKS protocol constants
+const (
+	socksCmdConnect = 0x01
+
+	socks5NoAuth       = 0x00
+	socks5NoAcceptable = 0xff
+
+	socks5AddrIPv4   = 0x01
+	socks5AddrDomain = 0x03
+	socks5AddrIPv6   = 0x04
+
+	socks5Succeeded          = 0x00
+	socks5GeneralFailure     = 0x01
+	socks5CommandUnsupported = 0x07
+	socks5AddrUnsupported    = 0x08
+
+	socks4Granted  = 0x5a
+	socks4Rejected = 0x5b
+)
+
+var errSOCKSCommandUnsupported = errors.New("unsupported SOCKS command")
+
+// handleSOCKS serves a SOCKS4/4a/5 CONNECT request on a sniffed connection,
+// tunneling it to the destination and recording it in history
+func (p *Proxy) handleSOCKS(conn net.Conn) {
+	defer func() {
+		if closeErr := conn.Close(); closeErr != nil {
+			slog.Warn("Error closing SOCKS client connection", "error", closeErr)
+		}
+	}()
+
+	proxyStartTime := time.Now()
+
+	var version byte
+	if err := binary.Read(conn, binary.BigEndian, &version); err != nil {
+		return
+	}
+
+	var target string
+	var err error
+	switch version {
+	case socks5Version:
+		target, err = socks5Handshake(conn)
+	case socks4Version:
+		target, err = socks4Handshake(conn)
+	default:
+		err = fmt.Errorf("unknown SOCKS version %d", version)
+	}
+
+	record := RequestRecord{
+		ID:             p.newRequestID(),
+		Timestamp:      proxyStartTime,
+		Method:         "CONNECT",
+		URL:            fmt.Sprintf("socks%d://%s", version, target),
+		RequestHeaders: map[string]string{},
+		ProxyStartTime: proxyStartTime,
+		Success:        false, // Will be updated based on outcome
+	}
+
+	if err != nil {
+		slog.Debug("SOCKS handshake failed", "error", err)
+		if target != "" {
+			record.Error = err.Error()
+			record.ProxyEndTime = time.Now()
+			p.recordRequest(record)
+		}
+		return
+	}
+
+	record.UpstreamStartTime = time.Now()
+	dest, err := p.dialer.DialContext(context.Background(), "tcp", target)
+	if err != nil {
+		record.UpstreamEndTime = time.Now()
+		record.Error = "Failed to connect to destination"
+		record.ProxyEndTime = time.Now()
+		p.recordRequest(record)
+		if err := socksReply(conn, version, false); err != nil {
+			slog.Warn("Error writing SOCKS reply", "error", err)
+		}
+		return
+	}
+	defer func() {
+		if closeErr := dest.Close(); closeErr != nil {
+			slog.Warn("Error closing SOCKS destination connection", "error", closeErr)
+		}
+	}()
+	record.UpstreamAddr, record.UpstreamIPFamily = connEndpoint(dest)
+
+	if err := socksReply(conn, version, true); err != nil {
+		record.UpstreamEndTime = time.Now()
+		record.Error = "Failed to write SOCKS reply"
+		record.ProxyEndTime = time.Now()
+		p.recordRequest(record)
+		return
+	}
+
+	// Relay until either side closes, counting bytes in each direction
+	sent := make(chan int64, 1)
+	go func() {
+		n, _ := io.Copy(dest, conn)
+		closeWrite(dest)
+		sent <- n
+	}()
+	received, _ := io.Copy(conn, dest)
+	closeWrite(conn)
+
+	record.RequestSize = <-sent
+	record.ResponseSize = received
+	record.UpstreamEndTime = time.Now()
+	record.ProxyEndTime = time.Now()
+	record.Success = true
+	p.recordRequest(record)
+
+	slog.Debug("SOCKS tunnel closed", "version", version, "target", target, "bytes_sent", record.RequestSize, "bytes_received", received)
+}
+
+// socks5Handshake negotiates "no authentication" and reads a CONNECT request,
+// returning the destination address
+func socks5Handshake(conn net.Conn) (string, error) {
+	var count byte
+	if err := binary.Read(conn, binary.BigEndian, &count); err != nil {
+		return "", err
+	}
+	methods := make([]byte, count)
+	if _, err := io.ReadFull(conn, methods); err != nil {
+		return "", err
+	}
+
+	method := byte(socks5NoAcceptable)
+	for _, m := range methods {
+		if m == socks5NoAuth {
+			method = socks5NoAuth
+		}
+	}
+	if _, err := conn.Write([]byte{socks5Version, method}); err != nil {
+		return "", err
+	}
+	if method == socks5NoAcceptable {
+		return "", fmt.Errorf("client does not support unauthenticated SOCKS5")
+	}
+
+	header := make([]byte, 4)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return "", err
+	}
+
+	var host string
+	switch header[3] {
+	case socks5AddrIPv4, socks5AddrIPv6:
+		size := net.IPv4len
+		if header[3] == socks5AddrIPv6 {
+			size = net.IPv6len
+		}
+		ip := make([]byte, size)
+		if _, err := io.ReadFull(conn, ip); err != nil {
+			return "", err
+		}
+		host = net.IP(ip).String()
+	case socks5AddrDomain:
+		var length byte
+		if err := binary.Read(conn, binary.BigEndian, &length); err != nil {
+			return "", err
+		}
+		domain := make([]byte, length)
+		if _, err := io.ReadFull(conn, domain); err != nil {
+			return "", err
+		}
+		host = string(domain)
+	default:
+		if err := writeSOCKS5Reply(conn, socks5AddrUnsupported); err != nil {
+			return "", err
+		}
+		return "", fmt.Errorf("unsupported SOCKS5 address type %d", header[3])
+	}
+
+	var port uint16
+	if err := binary.Read(conn, binary.BigEndian, &port); err != nil {
+		return "", err
+	}
+	target := net.JoinHostPort(host, strconv.Itoa(int(port)))
+
+	if header[1] != socksCmdConnect {
+		if err := writeSOCKS5Reply(conn, socks5CommandUnsupported); err != nil {
+			return target, err
+		}
+		return target, errSOCKSCommandUnsupported
+	}
+	return target, nil
+}
+
+// socks4Handshake reads a SOCKS4 or SOCKS4a CONNECT request, returning the destination address
+func socks4Handshake(conn net.Conn) (string, error) {
+	header := make([]byte, 7)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return "", err
+	}
+	port := binary.BigEndian.Uint16(header[1:3])
+	ip := net.IP(header[3:7])
+
+	// Skip the user ID
+	if _, err := readNullTerminated(conn); err != nil {
+		return "", err
+	}
+
+	host := ip.String()
+	// SOCKS4a: an address of 0.0.0.x means the domain name follows
+	if ip[0] == 0 && ip[1] == 0 && ip[2] == 0 && ip[3] != 0 {
+		domain, err := readNullTerminated(conn)
+		if err != nil {
+			return "", err
+		}
+		host = domain
+	}
+	target := net.JoinHostPort(host, strconv.Itoa(int(port)))
+
+	if header[0] != socksCmdConnect {
+		if err := socksReply(conn, socks4Version, false); err != nil {
+			return target, err
+		}
+		return target, errSOCKSCommandUnsupported
+	}
+	return target, nil
+}
+
+func readNullTerminated(r io.Reader) (string, error) {
+	var buf []byte
+	b := make([]byte, 1)
+	for len(buf) < 256 {
+		if _, err := io.ReadFull(r, b); err != nil {
+			return "", err
+		}
+		if b[0] == 0 {
+			return string(buf), nil
+		}
+		buf = append(buf, b[0])
+	}
+	return "", fmt.Errorf("SOCKS4 field too long")
+}
+
+// socksReply writes the CONNECT outcome in the client's protocol version
+func socksReply(conn net.Conn, version byte, ok bool) error {
+	if version == socks5Version {
+		code := byte(socks5Succeeded)
+		if !ok {
+			code = socks5GeneralFailure
+		}
+		return writeSOCKS5Reply(conn, code)
+	}
+
+	code := byte(socks4Granted)
+	if !ok {
+		code = socks4Rejected
+	}
+	_, err := conn.Write([]byte{0x00, code, 0, 0, 0, 0, 0, 0})
+	return err
+}
+
+func writeSOCKS5Reply(conn net.Conn, code byte) error {
+	_, err := conn.Write([]byte{socks5Version, code, 0x00, socks5AddrIPv4, 0, 0, 0, 0, 0, 0})
+	return err
+}
+
+// closeWrite half-closes a connection so the peer sees EOF while replies can still be read
+func closeWrite(conn net.Conn) {
+	hc, ok := conn.(interface{ CloseWrite() error })
+	if !ok {
+		return
+	}
+	if err := hc.CloseWrite(); err != nil && !errors.Is(err, net.ErrClosed) {
+		slog.Warn("Error half-closing connection", "error", err)
+	}
+}
@@ -0,0 +1,157 @@
+package proxy
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// SourceRule binds the upstream connections of a route to a local IP
+// address, or to an address of a network interface, e.g. to leave a
+// multi-homed host through the network an upstream allowlists
+type SourceRule struct {
+	Route     Route  // Every upstream when zero
+	Address   string // Local IP address; empty when Interface is set
+	Interface string // Name of the network interface whose address is used
+}
+
+// ParseSourceRule parses a rule in "[route=]source" form, where source is
+// an IP address or an interface name, e.g. "192.0.2.10" for every upstream
+// or "api.example.com=eth1"
+func ParseSourceRule(spec string) (SourceRule, error) {
+	route, source, ok := strings.Cut(spec, "=")
+	if !ok {
+		route, source = "", spec
+	}
+	if source == "" {
+		return SourceRule{}, fmt.Errorf("invalid source rule %q: missing IP address or interface", spec)
+	}
+	if ok && route == "" {
+		return SourceRule{}, fmt.Errorf("invalid source rule %q: missing route", spec)
+	}
+	rule := SourceRule{Route: ParseRoute(route)}
+	if ip := net.ParseIP(source); ip != nil {
+		rule.Address = ip.String()
+		return rule, nil
+	}
+	if _, err := net.InterfaceByName(source); err != nil {
+		return SourceRule{}, fmt.Errorf("invalid source rule %q: %q is neither an IP address nor a network interface", spec, source)
+	}
+	rule.Interface = source
+	return rule, nil
+}
+
+// String returns the rule in the form accepted by ParseSourceRule
+func (sr SourceRule) String() string {
+	source := sr.Address
+	if sr.Interface != "" {
+		source = sr.Interface
+	}
+	if sr.Route == (Route{}) {
+		return source
+	}
+	return sr.Route.String() + "=" + source
+}
+
+// localAddr returns the address to bind a connection to host over network
+// to. An interface's address is of the family network requires, or of the
+// one mode prefers and then the other.
+func (sr SourceRule) localAddr(network, mode, host string) (*net.TCPAddr, error) {
+	if sr.Address != "" {
+		return &net.TCPAddr{IP: net.ParseIP(sr.Address)}, nil
+	}
+
+	iface, err := net.InterfaceByName(sr.Interface)
+	if err != nil {
+		return nil, err
+	}
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return nil, err
+	}
+	var ipv4, ipv6 []net.IP
+	for _, addr := range addrs {
+		ipNet, ok := addr.(*net.IPNet)
+		if !ok || ipNet.IP.IsLinkLocalUnicast() {
+			continue
+		}
+		if ipFamily(ipNet.IP) == IPFamilyIPv4 {
+			ipv4 = append(ipv4, ipNet.IP)
+		} else {
+			ipv6 = append(ipv6, ipNet.IP)
+		}
+	}
+
+	candidates := append(ipv4, ipv6...)
+	switch {
+	case network == "tcp4":
+		candidates = ipv4
+	case network == "tcp6":
+		candidates = ipv6
+	case net.ParseIP(host) != nil:
+		if ipFamily(net.ParseIP(host)) == IPFamilyIPv4 {
+			candidates = ipv4
+		} else {
+			candidates = ipv6
+		}
+	case mode == IPFamilyIPv6 || mode == IPFamilyPreferIPv6:
+		candidates = append(ipv6, ipv4...)
+	}
+	if len(candidates) == 0 {
+		return nil, fmt.Errorf("interface %s has no address to connect to %s from", sr.Interface, host)
+	}
+	return &net.TCPAddr{IP: candidates[0]}, nil
+}
+
+// splitSourceRules returns the first rule for every upstream, which binds
+// all connections, and the rules for routes, which take precedence over it
+func splitSourceRules(rules []SourceRule) (*SourceRule, []SourceRule) {
+	var global *SourceRule
+	var routes []SourceRule
+	for i, rule := range rules {
+		if rule.Route != (Route{}) {
+			routes = append(routes, rule)
+		} else if global == nil {
+			global = &rules[i]
+		}
+	}
+	return global, routes
+}
+
+// withSource returns a copy of d that binds its connections to rule's
+// source
+func (d *upstreamDialer) withSource(rule SourceRule) *upstreamDialer {
+	bound := *d
+	bound.source = &rule
+	return &bound
+}
+
+// sourceTransport sends the requests of routes with a source of their own
+// over connections bound to it, each route keeping its own connection pool,
+// and the rest through base
+type sourceTransport struct {
+	base       http.RoundTripper
+	rules      []SourceRule
+	transports []http.RoundTripper // By rule
+}
+
+// newSourceTransport returns a transport binding the requests of route rules
+// with dialers derived from dialer
+func newSourceTransport(rules []SourceRule, dialer *upstreamDialer, base http.RoundTripper) *sourceTransport {
+	t := &sourceTransport{base: base, rules: rules}
+	for _, rule := range rules {
+		bound := dialer.withSource(rule)
+		t.transports = append(t.transports, &wireCaptureTransport{base: bound.transport(), dialer: bound})
+	}
+	return t
+}
+
+func (t *sourceTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	for i, rule := range t.rules {
+		if rule.Route.Matches(req.URL) {
+			return t.transports[i].RoundTrip(req)
+		}
+	}
+	return t.base.RoundTrip(req)
+}
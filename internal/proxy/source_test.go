@@ -0,0 +1,89 @@
+//go:build unit
+
+package proxy
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// loopbackInterface returns the name of the loopback interface
+func loopbackInterface(t *testing.T) string {
+	interfaces, err := net.Interfaces()
+	require.NoError(t, err)
+	for _, iface := range interfaces {
+		if iface.Flags&net.FlagLoopback != 0 {
+			return iface.Name
+		}
+	}
+	t.Skip("no loopback interface")
+	return ""
+}
+
+func TestParseSourceRule(t *testing.T) {
+	rule, err := ParseSourceRule("192.0.2.10")
+	require.NoError(t, err)
+	assert.Equal(t, SourceRule{Address: "192.0.2.10"}, rule)
+	assert.Equal(t, "192.0.2.10", rule.String())
+
+	rule, err = ParseSourceRule("api.example.com/v2=2001:db8::1")
+	require.NoError(t, err)
+	assert.Equal(t, Route{Host: "api.example.com", PathPrefix: "/v2"}, rule.Route)
+	assert.Equal(t, "2001:db8::1", rule.Address)
+
+	lo := loopbackInterface(t)
+	rule, err = ParseSourceRule("*/internal=" + lo)
+	require.NoError(t, err)
+	assert.Equal(t, lo, rule.Interface)
+	assert.Equal(t, "*/internal="+lo, rule.String())
+
+	for _, spec := range []string{"", "api.example.com=", "=192.0.2.10", "no-such-interface0"} {
+		_, err := ParseSourceRule(spec)
+		assert.Error(t, err, spec)
+	}
+}
+
+func TestSourceRuleInterfaceAddress(t *testing.T) {
+	rule := SourceRule{Interface: loopbackInterface(t)}
+	local, err := rule.localAddr("tcp", IPFamilyHappyEyeballs, "api.example.com")
+	require.NoError(t, err)
+	assert.True(t, local.IP.IsLoopback())
+	assert.Equal(t, IPFamilyIPv4, ipFamily(local.IP), "IPv4 first unless the host prefers IPv6")
+
+	local, err = rule.localAddr("tcp", IPFamilyHappyEyeballs, "127.0.0.1")
+	require.NoError(t, err)
+	assert.Equal(t, "127.0.0.1", local.IP.String())
+}
+
+func TestProxyBindsRouteSource(t *testing.T) {
+	// Any 127/8 address can be bound on Linux, but not everywhere
+	ln, err := net.Listen("tcp", "127.0.0.2:0")
+	if err != nil {
+		t.Skip("127.0.0.2 is not available")
+	}
+	_ = ln.Close()
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host, _, _ := net.SplitHostPort(r.RemoteAddr)
+		_, _ = w.Write([]byte(host))
+	}))
+	defer upstream.Close()
+
+	global, err := ParseSourceRule("127.0.0.1")
+	require.NoError(t, err)
+	routed, err := ParseSourceRule("*/bound=127.0.0.2")
+	require.NoError(t, err)
+	p := New(&Config{SourceRules: []SourceRule{global, routed}})
+
+	for path, source := range map[string]string{"/bound/users": "127.0.0.2", "/users": "127.0.0.1"} {
+		rec := httptest.NewRecorder()
+		p.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, upstream.URL+path, nil))
+		require.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, source, rec.Body.String(), path)
+	}
+}
@@ -0,0 +1,221 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// defaultSSHPort is the port of SSH jump hosts
+const defaultSSHPort = "22"
+
+// SSHJump sends the connections to upstream hosts matching a pattern through
+// an SSH jump host, e.g. to reach services only a bastion can connect to
+type SSHJump struct {
+	Host    string // Glob matched against the upstream hostname ignoring case, where * matches any run of characters
+	User    string // User to log in to the jump host as
+	Address string // host:port of the jump host
+
+	pattern *regexp.Regexp
+}
+
+// ParseSSHJump parses a rule in "host=user@jumphost[:port]" form, e.g.
+// "*.internal.example.com=deploy@bastion.example.com" (port 22 by default)
+func ParseSSHJump(spec string) (SSHJump, error) {
+	host, jump, ok := strings.Cut(spec, "=")
+	if !ok || host == "" {
+		return SSHJump{}, fmt.Errorf("invalid SSH jump %q: expected host=user@jumphost[:port]", spec)
+	}
+	user, address, ok := strings.Cut(jump, "@")
+	if !ok || user == "" || address == "" {
+		return SSHJump{}, fmt.Errorf("invalid SSH jump %q: expected user@jumphost[:port] after the host", spec)
+	}
+	if _, _, err := net.SplitHostPort(address); err != nil {
+		address = net.JoinHostPort(strings.Trim(address, "[]"), defaultSSHPort)
+	}
+	rule := SSHJump{Host: strings.ToLower(host), User: user, Address: address}
+	rule.pattern = globPattern(rule.Host)
+	return rule, nil
+}
+
+// String returns the rule in the form accepted by ParseSSHJump
+func (sj SSHJump) String() string {
+	return sj.Host + "=" + sj.User + "@" + sj.Address
+}
+
+// matches reports whether the rule applies to a hostname
+func (sj SSHJump) matches(host string) bool {
+	return sj.pattern != nil && sj.pattern.MatchString(strings.ToLower(host))
+}
+
+// sshJumps opens upstream connections through SSH jump hosts, keeping one
+// SSH connection per jump host and user for all of them. Upstream names are
+// resolved by the jump host.
+type sshJumps struct {
+	rules  []SSHJump
+	auth   []ssh.AuthMethod
+	verify ssh.HostKeyCallback
+
+	mutex   sync.Mutex
+	clients map[string]*ssh.Client // By user@address
+	closed  bool
+}
+
+// newSSHJumps returns jumps that log in with the private key in keyFile and
+// check host keys against knownHostsFile
+func newSSHJumps(rules []SSHJump, keyFile, knownHostsFile string) (*sshJumps, error) {
+	key, err := os.ReadFile(keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read SSH key: %v", err)
+	}
+	signer, err := ssh.ParsePrivateKey(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse SSH key %s: %v", keyFile, err)
+	}
+	verify, err := knownhosts.New(knownHostsFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read SSH known hosts: %v", err)
+	}
+	return &sshJumps{
+		rules:   rules,
+		auth:    []ssh.AuthMethod{ssh.PublicKeys(signer)},
+		verify:  verify,
+		clients: make(map[string]*ssh.Client),
+	}, nil
+}
+
+// jumpFor returns the first rule matching host, or nil
+func (j *sshJumps) jumpFor(host string) *SSHJump {
+	for i := range j.rules {
+		if j.rules[i].matches(host) {
+			return &j.rules[i]
+		}
+	}
+	return nil
+}
+
+// dial connects to addr through the jump host of rule, logging in again
+// once if its SSH connection has gone away
+func (j *sshJumps) dial(ctx context.Context, rule *SSHJump, addr string) (net.Conn, error) {
+	for attempt := 0; ; attempt++ {
+		client, err := j.client(ctx, rule)
+		if err != nil {
+			return nil, err
+		}
+		conn, err := client.DialContext(ctx, "tcp", addr)
+		if err == nil {
+			return &sshJumpConn{Conn: conn, remote: sshJumpAddr{jump: rule.Address, addr: addr}}, nil
+		}
+		// A channel the jump host refused means the upstream cannot be reached
+		// from it, anything else that the SSH connection broke
+		var refused *ssh.OpenChannelError
+		if errors.As(err, &refused) || attempt > 0 || ctx.Err() != nil {
+			return nil, fmt.Errorf("failed to connect to %s through %s: %v", addr, rule.Address, err)
+		}
+		j.forget(rule, client)
+	}
+}
+
+// client returns the SSH connection to the jump host of rule, logging in if
+// there is none
+func (j *sshJumps) client(ctx context.Context, rule *SSHJump) (*ssh.Client, error) {
+	key := rule.User + "@" + rule.Address
+	j.mutex.Lock()
+	if j.closed {
+		j.mutex.Unlock()
+		return nil, fmt.Errorf("SSH jump hosts are closed")
+	}
+	if client, ok := j.clients[key]; ok {
+		j.mutex.Unlock()
+		return client, nil
+	}
+	j.mutex.Unlock()
+
+	conn, err := (&net.Dialer{Timeout: 30 * time.Second}).DialContext(ctx, "tcp", rule.Address)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to SSH jump host %s: %v", rule.Address, err)
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+	sshConn, channels, requests, err := ssh.NewClientConn(conn, rule.Address, &ssh.ClientConfig{
+		User:            rule.User,
+		Auth:            j.auth,
+		HostKeyCallback: j.verify,
+	})
+	if err != nil {
+		_ = conn.Close()
+		return nil, fmt.Errorf("failed to log in to SSH jump host %s as %s: %v", rule.Address, rule.User, err)
+	}
+	_ = conn.SetDeadline(time.Time{})
+	client := ssh.NewClient(sshConn, channels, requests)
+
+	// Keep the first connection when logins raced, and forget it once it closes
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	if existing, ok := j.clients[key]; ok || j.closed {
+		_ = client.Close()
+		if !ok {
+			return nil, fmt.Errorf("SSH jump hosts are closed")
+		}
+		return existing, nil
+	}
+	j.clients[key] = client
+	go func() {
+		_ = client.Wait()
+		j.forget(rule, client)
+	}()
+	return client, nil
+}
+
+// forget closes client and drops it as the connection to rule's jump host
+func (j *sshJumps) forget(rule *SSHJump, client *ssh.Client) {
+	key := rule.User + "@" + rule.Address
+	j.mutex.Lock()
+	if j.clients[key] == client {
+		delete(j.clients, key)
+	}
+	j.mutex.Unlock()
+	_ = client.Close()
+}
+
+// close logs out of every jump host
+func (j *sshJumps) close() {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	j.closed = true
+	for key, client := range j.clients {
+		if err := client.Close(); err != nil {
+			slog.Warn("Error closing SSH connection", "host", key, "error", err)
+		}
+		delete(j.clients, key)
+	}
+}
+
+// sshJumpConn is a connection to an upstream through a jump host, whose
+// SSH channel has no addresses of its own
+type sshJumpConn struct {
+	net.Conn
+	remote sshJumpAddr
+}
+
+func (c *sshJumpConn) RemoteAddr() net.Addr { return c.remote }
+
+// sshJumpAddr is the upstream address of a connection through a jump host
+type sshJumpAddr struct {
+	jump string // host:port of the jump host
+	addr string // Upstream host:port, as the jump host was asked for it
+}
+
+func (a sshJumpAddr) Network() string { return "ssh" }
+func (a sshJumpAddr) String() string  { return a.addr + " via " + a.jump }
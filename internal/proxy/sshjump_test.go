@@ -0,0 +1,184 @@
+//go:build unit
+
+package proxy
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/pem"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// testJumpHost is an SSH server that forwards direct-tcpip channels
+type testJumpHost struct {
+	addr     string
+	forwards atomic.Int32
+}
+
+// newTestJumpHost starts a jump host that lets the user with key log in,
+// and writes its host key to a known_hosts file
+func newTestJumpHost(t *testing.T, user string, key ssh.PublicKey) (*testJumpHost, string) {
+	_, hostPrivate, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	hostSigner, err := ssh.NewSignerFromKey(hostPrivate)
+	require.NoError(t, err)
+	config := &ssh.ServerConfig{
+		PublicKeyCallback: func(meta ssh.ConnMetadata, offered ssh.PublicKey) (*ssh.Permissions, error) {
+			if meta.User() == user && string(offered.Marshal()) == string(key.Marshal()) {
+				return nil, nil
+			}
+			return nil, assert.AnError
+		},
+	}
+	config.AddHostKey(hostSigner)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = ln.Close() })
+	jump := &testJumpHost{addr: ln.Addr().String()}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go jump.serve(conn, config)
+		}
+	}()
+
+	knownHosts := filepath.Join(t.TempDir(), "known_hosts")
+	line := knownhosts.Line([]string{knownhosts.Normalize(jump.addr)}, hostSigner.PublicKey())
+	require.NoError(t, os.WriteFile(knownHosts, []byte(line+"\n"), 0o600))
+	return jump, knownHosts
+}
+
+func (j *testJumpHost) serve(conn net.Conn, config *ssh.ServerConfig) {
+	_, channels, requests, err := ssh.NewServerConn(conn, config)
+	if err != nil {
+		return
+	}
+	go ssh.DiscardRequests(requests)
+	for newChannel := range channels {
+		if newChannel.ChannelType() != "direct-tcpip" {
+			_ = newChannel.Reject(ssh.UnknownChannelType, "only direct-tcpip")
+			continue
+		}
+		// The target host and port lead the channel's extra data
+		data := newChannel.ExtraData()
+		hostLength := binary.BigEndian.Uint32(data)
+		host := string(data[4 : 4+hostLength])
+		port := binary.BigEndian.Uint32(data[4+hostLength:])
+		upstream, err := net.Dial("tcp", net.JoinHostPort(host, strconv.Itoa(int(port))))
+		if err != nil {
+			_ = newChannel.Reject(ssh.ConnectionFailed, err.Error())
+			continue
+		}
+		channel, channelRequests, err := newChannel.Accept()
+		if err != nil {
+			_ = upstream.Close()
+			continue
+		}
+		j.forwards.Add(1)
+		go ssh.DiscardRequests(channelRequests)
+		go func() {
+			_, _ = io.Copy(channel, upstream)
+			_ = channel.CloseWrite()
+		}()
+		go func() {
+			_, _ = io.Copy(upstream, channel)
+			_ = upstream.Close()
+		}()
+	}
+}
+
+// writeTestSSHKey generates a client key and writes it to a file
+func writeTestSSHKey(t *testing.T) (string, ssh.PublicKey) {
+	_, private, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	block, err := ssh.MarshalPrivateKey(private, "")
+	require.NoError(t, err)
+	path := filepath.Join(t.TempDir(), "id_ed25519")
+	require.NoError(t, os.WriteFile(path, pem.EncodeToMemory(block), 0o600))
+	signer, err := ssh.NewSignerFromKey(private)
+	require.NoError(t, err)
+	return path, signer.PublicKey()
+}
+
+func TestParseSSHJump(t *testing.T) {
+	jump, err := ParseSSHJump("*.Internal.example.com=deploy@bastion.example.com")
+	require.NoError(t, err)
+	assert.Equal(t, "deploy", jump.User)
+	assert.Equal(t, "bastion.example.com:22", jump.Address)
+	assert.True(t, jump.matches("db.internal.example.com"))
+	assert.False(t, jump.matches("api.example.com"))
+	assert.Equal(t, "*.internal.example.com=deploy@bastion.example.com:22", jump.String())
+
+	jump, err = ParseSSHJump("10.0.*=ops@[2001:db8::1]:2222")
+	require.NoError(t, err)
+	assert.Equal(t, "[2001:db8::1]:2222", jump.Address)
+
+	for _, spec := range []string{"bastion.example.com", "=deploy@bastion", "*.internal=bastion", "*.internal=@bastion", "*.internal=deploy@"} {
+		_, err := ParseSSHJump(spec)
+		assert.Error(t, err, spec)
+	}
+}
+
+func TestProxySSHJump(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("behind the bastion"))
+	}))
+	defer upstream.Close()
+	parsed, err := url.Parse(upstream.URL)
+	require.NoError(t, err)
+
+	keyFile, publicKey := writeTestSSHKey(t)
+	jumpHost, knownHosts := newTestJumpHost(t, "deploy", publicKey)
+	jump, err := ParseSSHJump("127.0.0.1=deploy@" + jumpHost.addr)
+	require.NoError(t, err)
+	p := New(&Config{SSHJumps: []SSHJump{jump}, SSHKeyFile: keyFile, SSHKnownHostsFile: knownHosts})
+	defer p.dialer.jumps.close()
+
+	rec := httptest.NewRecorder()
+	p.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, upstream.URL+"/", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "behind the bastion", rec.Body.String())
+	assert.Equal(t, int32(1), jumpHost.forwards.Load())
+	assert.Equal(t, "behind the bastion", p.history.GetRecords()[0].ResponseBody, "traffic through the jump host is captured")
+
+	// Hosts without a rule are connected to directly
+	rec = httptest.NewRecorder()
+	p.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "http://localhost:"+parsed.Port()+"/", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, int32(1), jumpHost.forwards.Load())
+}
+
+func TestSSHJumpChecksHostKey(t *testing.T) {
+	keyFile, publicKey := writeTestSSHKey(t)
+	jumpHost, _ := newTestJumpHost(t, "deploy", publicKey)
+	_, otherKnownHosts := newTestJumpHost(t, "deploy", publicKey)
+	jump, err := ParseSSHJump("*=deploy@" + jumpHost.addr)
+	require.NoError(t, err)
+
+	// The other jump host's key is not the one this host presents
+	jumps, err := newSSHJumps([]SSHJump{jump}, keyFile, otherKnownHosts)
+	require.NoError(t, err)
+	defer jumps.close()
+	_, err = jumps.dial(t.Context(), &jumps.rules[0], "127.0.0.1:80")
+	assert.ErrorContains(t, err, "failed to log in")
+	assert.Equal(t, int32(0), jumpHost.forwards.Load())
+}
@@ -0,0 +1,211 @@
+package proxy
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"html"
+	"html/template"
+	"io"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Chart dimensions for HTML reports
+const (
+	chartWidth   = 720
+	chartHeight  = 180
+	chartPadding = 40
+)
+
+// ReadRecords reads request records exported from netkit: newline-delimited
+// JSON (one record per line), or an object with a "records" array such as the
+// {"records", "total"} page served by GET /requests or a capture export. A
+// bare JSON array of records and mitmproxy flow files are read too. Records
+// are returned most recent first.
+func ReadRecords(r io.Reader) ([]RequestRecord, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	trimmed := bytes.TrimSpace(data)
+
+	var records []RequestRecord
+	switch {
+	case len(trimmed) == 0:
+		return nil, fmt.Errorf("no records found")
+
+	case isMitmFlows(trimmed):
+		if records, err = readMitmFlows(trimmed); err != nil {
+			return nil, err
+		}
+
+	case trimmed[0] == '[':
+		if err := json.Unmarshal(trimmed, &records); err != nil {
+			return nil, fmt.Errorf("invalid records array: %v", err)
+		}
+
+	case trimmed[0] == '{' && isRecordsObject(trimmed):
+		var wrapped struct {
+			Records []RequestRecord `json:"records"`
+		}
+		if err := json.Unmarshal(trimmed, &wrapped); err != nil {
+			return nil, fmt.Errorf("invalid records object: %v", err)
+		}
+		records = wrapped.Records
+
+	default:
+		scanner := bufio.NewScanner(bytes.NewReader(trimmed))
+		scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+		for line := 1; scanner.Scan(); line++ {
+			text := strings.TrimSpace(scanner.Text())
+			if text == "" {
+				continue
+			}
+			var record RequestRecord
+			if err := json.Unmarshal([]byte(text), &record); err != nil {
+				return nil, fmt.Errorf("invalid record on line %d: %v", line, err)
+			}
+			records = append(records, record)
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	sort.SliceStable(records, func(i, j int) bool { return records[i].Timestamp.After(records[j].Timestamp) })
+	return records, nil
+}
+
+// isRecordsObject reports whether a JSON object wraps records rather than being one
+func isRecordsObject(data []byte) bool {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return false
+	}
+	_, ok := fields["records"]
+	return ok
+}
+
+// BuildRecordsReport summarizes every record, with the window spanning the
+// oldest to the newest. Records must be most recent first.
+func BuildRecordsReport(records []RequestRecord) Report {
+	if len(records) == 0 {
+		now := time.Now()
+		return BuildReport(nil, now, now)
+	}
+	// The window end is exclusive, so it sits just after the newest record
+	return BuildReport(records, records[len(records)-1].Timestamp, records[0].Timestamp.Add(time.Microsecond))
+}
+
+// latencyTrendChart draws the latency trend as an inline SVG: request counts
+// as bars, with p50 and p95 latency lines over them
+func latencyTrendChart(buckets []LatencyBucket) template.HTML {
+	if len(buckets) == 0 {
+		return ""
+	}
+
+	var maxCount int
+	var maxLatency int64
+	for _, bucket := range buckets {
+		if bucket.Count > maxCount {
+			maxCount = bucket.Count
+		}
+		if bucket.P95DurationUs > maxLatency {
+			maxLatency = bucket.P95DurationUs
+		}
+	}
+	if maxCount == 0 {
+		return ""
+	}
+	if maxLatency == 0 {
+		maxLatency = 1
+	}
+
+	plotWidth := float64(chartWidth - 2*chartPadding)
+	plotHeight := float64(chartHeight - 2*chartPadding)
+	step := plotWidth / float64(len(buckets))
+	y := func(value, max float64) float64 {
+		return chartPadding + plotHeight - value/max*plotHeight
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d" role="img" aria-label="Latency trend">`,
+		chartWidth, chartHeight, chartWidth, chartHeight)
+	fmt.Fprintf(&b, `<line x1="%d" y1="%d" x2="%d" y2="%d" stroke="#999"/>`, chartPadding, chartHeight-chartPadding, chartWidth-chartPadding, chartHeight-chartPadding)
+
+	var p50, p95 []string
+	for i, bucket := range buckets {
+		x := chartPadding + float64(i)*step
+		if bucket.Count == 0 {
+			continue
+		}
+		top := y(float64(bucket.Count), float64(maxCount))
+		fmt.Fprintf(&b, `<rect x="%.1f" y="%.1f" width="%.1f" height="%.1f" fill="#dde6f3"><title>%s: %d requests, %d errors</title></rect>`,
+			x+step*0.1, top, step*0.8, chartPadding+plotHeight-top,
+			html.EscapeString(bucket.Start.Format(time.RFC3339)), bucket.Count, bucket.ErrorCount)
+		if bucket.ErrorCount > 0 {
+			errorTop := y(float64(bucket.ErrorCount), float64(maxCount))
+			fmt.Fprintf(&b, `<rect x="%.1f" y="%.1f" width="%.1f" height="%.1f" fill="#e8a0a0"/>`,
+				x+step*0.1, errorTop, step*0.8, chartPadding+plotHeight-errorTop)
+		}
+		center := x + step/2
+		p50 = append(p50, fmt.Sprintf("%.1f,%.1f", center, y(float64(bucket.P50DurationUs), float64(maxLatency))))
+		p95 = append(p95, fmt.Sprintf("%.1f,%.1f", center, y(float64(bucket.P95DurationUs), float64(maxLatency))))
+	}
+	fmt.Fprintf(&b, `<polyline points="%s" fill="none" stroke="#2b6cb0" stroke-width="2"/>`, strings.Join(p50, " "))
+	fmt.Fprintf(&b, `<polyline points="%s" fill="none" stroke="#c05621" stroke-width="2"/>`, strings.Join(p95, " "))
+
+	fmt.Fprintf(&b, `<text x="%d" y="%d" font-size="11">%s (p95 max)</text>`, chartPadding, chartPadding-8, html.EscapeString(formatMicros(maxLatency)))
+	fmt.Fprintf(&b, `<text x="%d" y="%d" font-size="11" text-anchor="end">%d requests (max)</text>`, chartWidth-chartPadding, chartPadding-8, maxCount)
+	fmt.Fprintf(&b, `<text x="%d" y="%d" font-size="11">%s</text>`, chartPadding, chartHeight-chartPadding+16, html.EscapeString(buckets[0].Start.Format(time.RFC3339)))
+	fmt.Fprintf(&b, `<text x="%d" y="%d" font-size="11" text-anchor="end">%s</text>`, chartWidth-chartPadding, chartHeight-chartPadding+16,
+		html.EscapeString(buckets[len(buckets)-1].Start.Format(time.RFC3339)))
+	fmt.Fprintf(&b, `<text x="%d" y="%d" font-size="11" fill="#2b6cb0">p50</text><text x="%d" y="%d" font-size="11" fill="#c05621">p95</text>`,
+		chartPadding, chartHeight-8, chartPadding+40, chartHeight-8)
+	b.WriteString(`</svg>`)
+
+	// Every value written above is numeric or escaped
+	return template.HTML(b.String())
+}
+
+// endpointChart draws the busiest endpoints as horizontal bars split into
+// successful and failed requests
+func endpointChart(endpoints []EndpointSummary) template.HTML {
+	if len(endpoints) == 0 {
+		return ""
+	}
+
+	const rowHeight, labelWidth = 22, 320
+	maxCount := endpoints[0].Count
+	for _, endpoint := range endpoints {
+		if endpoint.Count > maxCount {
+			maxCount = endpoint.Count
+		}
+	}
+	barWidth := float64(chartWidth - labelWidth - chartPadding)
+	height := len(endpoints)*rowHeight + 10
+
+	var b strings.Builder
+	fmt.Fprintf(&b, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d" role="img" aria-label="Top endpoints">`,
+		chartWidth, height, chartWidth, height)
+	for i, endpoint := range endpoints {
+		top := i*rowHeight + 5
+		label := endpoint.Method + " " + endpoint.Route
+		if len(label) > 48 {
+			label = label[:45] + "..."
+		}
+		total := float64(endpoint.Count) / float64(maxCount) * barWidth
+		failed := float64(endpoint.ErrorCount) / float64(maxCount) * barWidth
+		fmt.Fprintf(&b, `<text x="%d" y="%d" font-size="11" text-anchor="end">%s</text>`, labelWidth-8, top+14, html.EscapeString(label))
+		fmt.Fprintf(&b, `<rect x="%d" y="%d" width="%.1f" height="%d" fill="#9cb8dc"/>`, labelWidth, top, total-failed, rowHeight-6)
+		fmt.Fprintf(&b, `<rect x="%.1f" y="%d" width="%.1f" height="%d" fill="#e8a0a0"/>`, float64(labelWidth)+total-failed, top, failed, rowHeight-6)
+		fmt.Fprintf(&b, `<text x="%.1f" y="%d" font-size="11">%d</text>`, float64(labelWidth)+total+4, top+14, endpoint.Count)
+	}
+	b.WriteString(`</svg>`)
+
+	// Every value written above is numeric or escaped
+	return template.HTML(b.String())
+}
@@ -0,0 +1,83 @@
+//go:build unit
+
+package proxy
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func staticReportRecords(now time.Time) []RequestRecord {
+	return []RequestRecord{
+		{ID: "3", Method: http.MethodGet, URL: "http://api.example.com/users/3", ResponseStatus: 500, Success: true, TotalDurationUs: 90000, Timestamp: now},
+		{ID: "2", Method: http.MethodGet, URL: "http://api.example.com/users/2", ResponseStatus: 200, Success: true, TotalDurationUs: 20000, Timestamp: now.Add(-10 * time.Minute)},
+		{ID: "1", Method: http.MethodPost, URL: "http://api.example.com/<script>", ResponseStatus: 201, Success: true, TotalDurationUs: 10000, Timestamp: now.Add(-20 * time.Minute)},
+	}
+}
+
+func TestReadRecordsFormats(t *testing.T) {
+	now := time.Now().UTC().Truncate(time.Second)
+	records := staticReportRecords(now)
+
+	var ndjson strings.Builder
+	for i := len(records) - 1; i >= 0; i-- {
+		line, err := json.Marshal(records[i])
+		require.NoError(t, err)
+		ndjson.Write(line)
+		ndjson.WriteString("\n\n")
+	}
+	array, err := json.Marshal(records)
+	require.NoError(t, err)
+	capture, err := json.Marshal(Capture{CaptureInfo: CaptureInfo{Name: "before"}, Records: records})
+	require.NoError(t, err)
+	page, err := json.Marshal(map[string]interface{}{"records": records, "total": len(records)})
+	require.NoError(t, err)
+
+	for name, input := range map[string]string{"ndjson": ndjson.String(), "array": string(array), "capture": string(capture), "requests page": string(page)} {
+		read, err := ReadRecords(strings.NewReader(input))
+		require.NoError(t, err, name)
+		require.Len(t, read, 3, name)
+		assert.Equal(t, []string{"3", "2", "1"}, []string{read[0].ID, read[1].ID, read[2].ID}, "%s is sorted most recent first", name)
+	}
+
+	_, err = ReadRecords(strings.NewReader(""))
+	assert.Error(t, err)
+	_, err = ReadRecords(strings.NewReader("{\"id\": \"1\"}\nnot json\n"))
+	assert.ErrorContains(t, err, "line 2")
+}
+
+func TestStaticHTMLReport(t *testing.T) {
+	now := time.Now()
+	report := BuildRecordsReport(staticReportRecords(now))
+	assert.Equal(t, 3, report.TotalRequests, "the window includes the newest record")
+	assert.Equal(t, 1, report.ErrorCount)
+
+	report.Title = "Checkout <before>"
+	data, err := RenderReport(report, ReportHTML)
+	require.NoError(t, err)
+	page := string(data)
+
+	assert.Contains(t, page, "<title>Checkout &lt;before&gt;</title>")
+	assert.Equal(t, 2, strings.Count(page, "<svg "), "latency trend and endpoint charts are inlined")
+	assert.Contains(t, page, "<polyline")
+	assert.NotContains(t, page, "<script")
+	assert.NotContains(t, page, "src=")
+	assert.Contains(t, page, "&lt;script&gt;", "endpoint labels are escaped")
+
+	markdown, err := RenderReport(report, ReportMarkdown)
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(string(markdown), "# Checkout <before>\n"))
+}
+
+func TestStaticHTMLReportWithoutRecords(t *testing.T) {
+	data, err := RenderReport(BuildRecordsReport(nil), ReportHTML)
+	require.NoError(t, err)
+	assert.NotContains(t, string(data), "<svg")
+	assert.Contains(t, string(data), "<h1>Netkit traffic report</h1>")
+}
@@ -0,0 +1,173 @@
+package proxy
+
+import (
+	"fmt"
+	"log/slog"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// StatsD wire formats
+const (
+	StatsDPlain   = "statsd"    // Metric names only
+	StatsDDatadog = "dogstatsd" // Datadog's extension with |#key:value tags
+)
+
+// StatsD batching: lines are packed into datagrams that fit a common MTU
+// and sent at least once per flush interval
+const (
+	statsdMaxPacket     = 1432
+	statsdFlushInterval = time.Second
+	statsdQueueSize     = 10000
+)
+
+// statsdTagReplacer removes the characters that delimit DogStatsD tags
+var statsdTagReplacer = strings.NewReplacer(",", "_", "|", "_", "#", "_", "\n", "_")
+
+// ValidateStatsDFlavor checks a StatsD wire format name
+func ValidateStatsDFlavor(flavor string) error {
+	if flavor != StatsDPlain && flavor != StatsDDatadog {
+		return fmt.Errorf("expected %s or %s, got %q", StatsDPlain, StatsDDatadog, flavor)
+	}
+	return nil
+}
+
+// ValidateStatsDTag checks a constant DogStatsD tag, key:value or a bare key
+func ValidateStatsDTag(tag string) error {
+	if tag == "" || strings.ContainsAny(tag, ",|# \n") {
+		return fmt.Errorf("invalid tag %q: expected key:value without spaces, commas, pipes, or #", tag)
+	}
+	return nil
+}
+
+// statsdClient sends metrics to a StatsD server over UDP without blocking
+// requests: lines are queued, dropped when the queue is full, and written
+// in batches by a background goroutine
+type statsdClient struct {
+	conn    net.Conn
+	prefix  string
+	tagged  bool     // Send DogStatsD tags
+	tags    []string // Constant tags added to every metric
+	lines   chan string
+	dropped atomic.Int64
+	done    chan struct{} // Closed by stop; lines are never closed, so late sends are safe
+	stopped chan struct{}
+	once    sync.Once
+}
+
+// newStatsDClient starts a client sending to addr, or returns nil when addr
+// is empty or cannot be resolved
+func newStatsDClient(addr, prefix, flavor string, tags []string) *statsdClient {
+	if addr == "" {
+		return nil
+	}
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		slog.Error("StatsD exporter disabled", "error", err)
+		return nil
+	}
+	c := &statsdClient{
+		conn:    conn,
+		prefix:  prefix,
+		tagged:  flavor == StatsDDatadog,
+		tags:    tags,
+		lines:   make(chan string, statsdQueueSize),
+		done:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+	go c.run()
+	return c
+}
+
+// count adds to a counter
+func (c *statsdClient) count(name string, value int64, tags ...string) {
+	c.send(name, fmt.Sprint(value), "c", tags)
+}
+
+// timing records a duration given in microseconds, sent in milliseconds
+func (c *statsdClient) timing(name string, us int64, tags ...string) {
+	c.send(name, fmt.Sprintf("%g", float64(us)/1000), "ms", tags)
+}
+
+// send queues a metric line, tagging it with key:value tags for DogStatsD
+func (c *statsdClient) send(name, value, kind string, tags []string) {
+	var line strings.Builder
+	fmt.Fprintf(&line, "%s%s:%s|%s", c.prefix, name, value, kind)
+	if c.tagged {
+		separator := "|#"
+		for _, group := range [][]string{tags, c.tags} {
+			for _, tag := range group {
+				line.WriteString(separator)
+				line.WriteString(statsdTagReplacer.Replace(tag))
+				separator = ","
+			}
+		}
+	}
+	select {
+	case c.lines <- line.String():
+	default:
+		c.dropped.Add(1)
+	}
+}
+
+// run packs queued lines into datagrams until the client is stopped
+func (c *statsdClient) run() {
+	defer close(c.stopped)
+	ticker := time.NewTicker(statsdFlushInterval)
+	defer ticker.Stop()
+
+	var packet []byte
+	flush := func() {
+		if len(packet) == 0 {
+			return
+		}
+		// UDP delivery is best effort; a missing server is not an error worth logging per packet
+		_, _ = c.conn.Write(packet)
+		packet = packet[:0]
+	}
+	add := func(line string) {
+		if len(packet) > 0 && len(packet)+1+len(line) > statsdMaxPacket {
+			flush()
+		}
+		if len(packet) > 0 {
+			packet = append(packet, '\n')
+		}
+		packet = append(packet, line...)
+	}
+
+	for {
+		select {
+		case line := <-c.lines:
+			add(line)
+		case <-ticker.C:
+			flush()
+		case <-c.done:
+			for {
+				select {
+				case line := <-c.lines:
+					add(line)
+				default:
+					flush()
+					return
+				}
+			}
+		}
+	}
+}
+
+// stop sends the queued lines and closes the connection
+func (c *statsdClient) stop() {
+	if c == nil {
+		return
+	}
+	c.once.Do(func() {
+		close(c.done)
+		<-c.stopped
+		if err := c.conn.Close(); err != nil {
+			slog.Warn("Error closing StatsD connection", "error", err)
+		}
+	})
+}
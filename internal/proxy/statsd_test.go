@@ -0,0 +1,91 @@
+//go:build unit
+
+package proxy
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// readStatsD returns the metric lines received on conn until it goes quiet
+func readStatsD(t *testing.T, conn net.PacketConn) []string {
+	t.Helper()
+	var lines []string
+	buf := make([]byte, 65536)
+	for {
+		require.NoError(t, conn.SetReadDeadline(time.Now().Add(200*time.Millisecond)))
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			return lines
+		}
+		lines = append(lines, strings.Split(string(buf[:n]), "\n")...)
+	}
+}
+
+func TestStatsDExporter(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("hello"))
+	}))
+	defer upstream.Close()
+	server, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer server.Close()
+
+	p := New(&Config{StatsDAddr: server.LocalAddr().String(), StatsDPrefix: "netkit.", StatsDFlavor: StatsDDatadog, StatsDTags: []string{"env:test"}})
+	p.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, upstream.URL+"/users", nil))
+	p.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "http://127.0.0.1:1/down", nil))
+	require.NoError(t, p.Stop())
+
+	lines := readStatsD(t, server)
+	host := strings.TrimPrefix(upstream.URL, "http://")
+	assert.Contains(t, lines, "netkit.requests:1|c|#type:http,method:GET,status_class:2xx,env:test")
+	assert.Contains(t, lines, "netkit.requests:1|c|#type:http,method:GET,status_class:none,env:test")
+	assert.Contains(t, lines, "netkit.errors:1|c|#type:http,env:test")
+	assert.Contains(t, lines, "netkit.bytes.sent:5|c|#type:http,env:test")
+	var durations, latencies int
+	for _, line := range lines {
+		if strings.HasPrefix(line, "netkit.request.duration:") && strings.HasSuffix(line, "|ms|#method:GET,status_class:2xx,upstream:"+host+",env:test") {
+			durations++
+		}
+		if strings.HasPrefix(line, "netkit.upstream.latency:") {
+			latencies++
+		}
+	}
+	assert.Equal(t, 1, durations)
+	assert.Equal(t, 2, latencies, "the failed request waited for the upstream too")
+}
+
+func TestStatsDPlain(t *testing.T) {
+	server, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer server.Close()
+
+	client := newStatsDClient(server.LocalAddr().String(), "app.", StatsDPlain, nil)
+	require.NotNil(t, client)
+	client.count("requests", 3, "method:GET")
+	client.timing("request.duration", 1500, "method:GET")
+	client.stop()
+	client.stop()
+
+	assert.Equal(t, []string{"app.requests:3|c", "app.request.duration:1.5|ms"}, readStatsD(t, server))
+	client.count("requests", 1)
+	assert.Nil(t, newStatsDClient("", "netkit.", StatsDPlain, nil))
+}
+
+func TestStatsDValidation(t *testing.T) {
+	assert.NoError(t, ValidateStatsDFlavor(StatsDPlain))
+	assert.NoError(t, ValidateStatsDFlavor(StatsDDatadog))
+	assert.Error(t, ValidateStatsDFlavor("graphite"))
+	assert.NoError(t, ValidateStatsDTag("env:staging"))
+	assert.NoError(t, ValidateStatsDTag("canary"))
+	for _, tag := range []string{"", "a,b", "a|b", "#a", "team name"} {
+		assert.Error(t, ValidateStatsDTag(tag), tag)
+	}
+}
@@ -0,0 +1,163 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// RouteStats is the traffic of one method and normalized route over a window
+type RouteStats struct {
+	Method        string `json:"method"`
+	Route         string `json:"route"`
+	RequestBytes  int64  `json:"request_bytes"`
+	ResponseBytes int64  `json:"response_bytes"`
+	TrafficStats
+}
+
+// StatsExport is a per-route snapshot of history over a window
+type StatsExport struct {
+	Start  time.Time    `json:"start"`
+	End    time.Time    `json:"end"`
+	Routes []RouteStats `json:"routes"` // Busiest routes first
+}
+
+// BuildStatsExport summarizes the records in [start, end) per method and normalized route
+func BuildStatsExport(records []RequestRecord, start, end time.Time) StatsExport {
+	var inWindow []RequestRecord
+	for _, record := range records {
+		if !record.Timestamp.Before(start) && record.Timestamp.Before(end) {
+			inWindow = append(inWindow, record)
+		}
+	}
+	return StatsExport{Start: start, End: end, Routes: summarizeRoutes(inWindow)}
+}
+
+// summarizeRoutes aggregates records per method and normalized route, busiest first
+func summarizeRoutes(records []RequestRecord) []RouteStats {
+	type routeKey struct{ method, route string }
+	type routeTotals struct {
+		traffic                     trafficAccumulator
+		requestBytes, responseBytes int64
+	}
+	routes := make(map[routeKey]*routeTotals)
+
+	for _, record := range records {
+		key := routeKey{record.Method, normalizeRoute(record.URL)}
+		totals := routes[key]
+		if totals == nil {
+			totals = &routeTotals{}
+			routes[key] = totals
+		}
+		totals.traffic.add(record)
+		totals.requestBytes += record.RequestSize
+		totals.responseBytes += record.ResponseSize
+	}
+
+	summary := make([]RouteStats, 0, len(routes))
+	for key, totals := range routes {
+		summary = append(summary, RouteStats{
+			Method:        key.method,
+			Route:         key.route,
+			RequestBytes:  totals.requestBytes,
+			ResponseBytes: totals.responseBytes,
+			TrafficStats:  totals.traffic.stats(),
+		})
+	}
+	sort.Slice(summary, func(i, j int) bool {
+		ri, rj := summary[i], summary[j]
+		if ri.Count != rj.Count {
+			return ri.Count > rj.Count
+		}
+		return ri.Method+" "+ri.Route < rj.Method+" "+rj.Route
+	})
+	return summary
+}
+
+// statsExportCSV renders one row per route, with the window on every row so
+// exports from different windows can be pasted into one sheet
+func statsExportCSV(export StatsExport) ([]byte, error) {
+	var buf bytes.Buffer
+	writer := csv.NewWriter(&buf)
+	rows := [][]string{{
+		"window_start", "window_end", "method", "route", "count", "error_count", "error_rate",
+		"p50_duration_us", "p95_duration_us", "request_bytes", "response_bytes",
+	}}
+	start := export.Start.UTC().Format(time.RFC3339)
+	end := export.End.UTC().Format(time.RFC3339)
+	for _, route := range export.Routes {
+		rows = append(rows, []string{
+			start,
+			end,
+			route.Method,
+			route.Route,
+			strconv.Itoa(route.Count),
+			strconv.Itoa(route.ErrorCount),
+			strconv.FormatFloat(route.ErrorRate, 'f', 4, 64),
+			strconv.FormatInt(route.P50DurationUs, 10),
+			strconv.FormatInt(route.P95DurationUs, 10),
+			strconv.FormatInt(route.RequestBytes, 10),
+			strconv.FormatInt(route.ResponseBytes, 10),
+		})
+	}
+	if err := writer.WriteAll(rows); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// handleStatsExport exports per-route statistics for a window as CSV or JSON
+func (p *Proxy) handleStatsExport(w http.ResponseWriter, r *http.Request) {
+	// Add CORS headers
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, Cache-Control, Pragma, Expires")
+
+	// Handle preflight requests
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	start, end, err := parseReportWindow(r.URL.Query().Get("window"), 24*time.Hour, time.Now())
+	if err != nil {
+		http.Error(w, "Invalid window: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	export := BuildStatsExport(p.history.GetRecords(), start, end)
+
+	var data []byte
+	switch format := r.URL.Query().Get("format"); format {
+	case "", "csv":
+		data, err = statsExportCSV(export)
+		if err == nil {
+			w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+			w.Header().Set("Content-Disposition", `attachment; filename="netkit-stats-`+end.UTC().Format("20060102T150405Z")+`.csv"`)
+		}
+	case "json":
+		data, err = json.Marshal(export)
+		w.Header().Set("Content-Type", "application/json")
+	default:
+		http.Error(w, "Invalid format: expected csv or json", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to export stats", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	if _, err := w.Write(data); err != nil {
+		slog.Warn("Error writing stats export response", "error", err)
+	}
+}
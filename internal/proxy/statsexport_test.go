@@ -0,0 +1,77 @@
+//go:build unit
+
+package proxy
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func exportRecords(now time.Time) []RequestRecord {
+	return []RequestRecord{
+		{Method: http.MethodGet, URL: "http://api.example.com/users/1", ResponseStatus: 200, Success: true, TotalDurationUs: 10000, RequestSize: 0, ResponseSize: 100, Timestamp: now.Add(-time.Minute)},
+		{Method: http.MethodGet, URL: "http://api.example.com/users/2", ResponseStatus: 500, Success: true, TotalDurationUs: 30000, RequestSize: 0, ResponseSize: 50, Timestamp: now.Add(-2 * time.Minute)},
+		{Method: http.MethodGet, URL: "http://api.example.com/users/3", ResponseStatus: 200, Success: true, TotalDurationUs: 20000, RequestSize: 0, ResponseSize: 150, Timestamp: now.Add(-3 * time.Minute)},
+		{Method: http.MethodPost, URL: "http://api.example.com/orders", ResponseStatus: 201, Success: true, TotalDurationUs: 40000, RequestSize: 300, ResponseSize: 20, Timestamp: now.Add(-4 * time.Minute)},
+		{Method: http.MethodPost, URL: "http://api.example.com/orders", ResponseStatus: 201, Success: true, TotalDurationUs: 40000, RequestSize: 300, ResponseSize: 20, Timestamp: now.Add(-3 * time.Hour)},
+	}
+}
+
+func TestBuildStatsExport(t *testing.T) {
+	now := time.Now()
+	export := BuildStatsExport(exportRecords(now), now.Add(-time.Hour), now)
+	require.Len(t, export.Routes, 2)
+
+	users := export.Routes[0]
+	assert.Equal(t, http.MethodGet, users.Method)
+	assert.Equal(t, "api.example.com/users/{id}", users.Route)
+	assert.Equal(t, 3, users.Count)
+	assert.Equal(t, 1, users.ErrorCount)
+	assert.Equal(t, int64(20000), users.P50DurationUs)
+	assert.Equal(t, int64(30000), users.P95DurationUs)
+	assert.Equal(t, int64(300), users.ResponseBytes)
+
+	orders := export.Routes[1]
+	assert.Equal(t, 1, orders.Count, "records outside the window are skipped")
+	assert.Equal(t, int64(300), orders.RequestBytes)
+}
+
+func TestStatsExportAPI(t *testing.T) {
+	p := New(&Config{})
+	p.history.restore(exportRecords(time.Now()))
+
+	rec := httptest.NewRecorder()
+	p.handleStatsExport(rec, httptest.NewRequest(http.MethodGet, "/requests/stats/export?format=csv&window=1h", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "text/csv; charset=utf-8", rec.Header().Get("Content-Type"))
+	assert.Contains(t, rec.Header().Get("Content-Disposition"), "attachment")
+	rows, err := csv.NewReader(strings.NewReader(rec.Body.String())).ReadAll()
+	require.NoError(t, err)
+	require.Len(t, rows, 3)
+	assert.Equal(t, []string{"window_start", "window_end", "method", "route", "count", "error_count", "error_rate",
+		"p50_duration_us", "p95_duration_us", "request_bytes", "response_bytes"}, rows[0])
+	assert.Equal(t, []string{"GET", "api.example.com/users/{id}", "3", "1", "0.3333", "20000", "30000", "0", "300"}, rows[1][2:])
+
+	// The default window is the last 24 hours
+	rec = httptest.NewRecorder()
+	p.handleStatsExport(rec, httptest.NewRequest(http.MethodGet, "/requests/stats/export?format=json", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	var export StatsExport
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &export))
+	require.Len(t, export.Routes, 2)
+	assert.Equal(t, 2, export.Routes[1].Count)
+
+	for _, query := range []string{"window=yesterday", "format=xlsx"} {
+		rec = httptest.NewRecorder()
+		p.handleStatsExport(rec, httptest.NewRequest(http.MethodGet, "/requests/stats/export?"+query, nil))
+		assert.Equal(t, http.StatusBadRequest, rec.Code, query)
+	}
+}
@@ -0,0 +1,139 @@
+package proxy
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"hash"
+	"io"
+	"net/http"
+	"sync"
+)
+
+// Streaming defaults
+const (
+	defaultStreamThreshold    = 1 << 20  // Bodies above 1 MiB are streamed
+	defaultStreamCaptureLimit = 64 << 10 // 64 KiB of a streamed body is kept in history
+)
+
+// shouldStream reports whether a body of the given declared length is piped
+// through instead of buffered. Bodies of unknown length (-1), such as chunked
+// downloads and server-sent events, are always streamed.
+func (p *Proxy) shouldStream(contentLength int64) bool {
+	threshold := p.currentConfig().StreamThreshold
+	if threshold < 0 {
+		return false
+	}
+	if threshold == 0 {
+		threshold = defaultStreamThreshold
+	}
+	return contentLength < 0 || contentLength > threshold
+}
+
+// streamCaptureLimit returns how many bytes of a streamed body are recorded
+func (p *Proxy) streamCaptureLimit() int {
+	limit := int64(defaultStreamCaptureLimit)
+	if configured := p.currentConfig().StreamCaptureLimit; configured > 0 {
+		limit = configured
+	}
+	if maxBytes := p.currentConfig().CaptureMaxBodyBytes; maxBytes > 0 && maxBytes < limit {
+		limit = maxBytes
+	}
+	return int(limit)
+}
+
+// truncateBodies keeps only the first limit bytes of a record's bodies,
+// dropping the decoded copies of the bodies it cuts. The sizes still count
+// the whole bodies.
+func truncateBodies(record *RequestRecord, limit int64) {
+	if limit <= 0 {
+		return
+	}
+	if int64(len(record.RequestBody)) > limit {
+		record.RequestBody = record.RequestBody[:limit]
+		record.RequestBodyDecoded = nil
+		record.RequestBodyTruncated = true
+	}
+	if int64(len(record.ResponseBody)) > limit {
+		record.ResponseBody = record.ResponseBody[:limit]
+		record.ResponseBodyDecoded = nil
+		record.ResponseBodyTruncated = true
+	}
+}
+
+// bodyCapture tees the first bytes of a body into history while it is piped
+// through, counting the rest. The transport may still be reading a request
+// body when the response arrives, so its results are guarded by a mutex.
+type bodyCapture struct {
+	io.ReadCloser
+	limit int
+
+	mutex     sync.Mutex
+	captured  bytes.Buffer
+	size      int64
+	truncated bool
+	hash      hash.Hash // Of the whole body, for bodies whose type is not captured
+}
+
+func newBodyCapture(body io.ReadCloser, limit int) *bodyCapture {
+	return &bodyCapture{ReadCloser: body, limit: limit, hash: sha256.New()}
+}
+
+func (c *bodyCapture) Read(b []byte) (int, error) {
+	n, err := c.ReadCloser.Read(b)
+	if n > 0 {
+		c.mutex.Lock()
+		keep := c.limit - c.captured.Len()
+		if keep > n {
+			keep = n
+		}
+		if keep < n {
+			c.truncated = true
+		}
+		c.captured.Write(b[:keep])
+		c.hash.Write(b[:n])
+		c.size += int64(n)
+		c.mutex.Unlock()
+	}
+	return n, err
+}
+
+// result returns the captured start of the body, the bytes read so far, and
+// whether the body was longer than what was captured
+func (c *bodyCapture) result() (string, int64, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.captured.String(), c.size, c.truncated
+}
+
+// sum returns the hex SHA-256 of the bytes read so far
+func (c *bodyCapture) sum() string {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return hex.EncodeToString(c.hash.Sum(nil))
+}
+
+// copyStreaming pipes a streamed response to the client, flushing after
+// every read so event streams reach it as they arrive
+func copyStreaming(w http.ResponseWriter, body io.Reader) error {
+	controller := http.NewResponseController(w)
+	buf := make([]byte, 32<<10)
+	for {
+		n, err := body.Read(buf)
+		if n > 0 {
+			if _, writeErr := w.Write(buf[:n]); writeErr != nil {
+				return writeErr
+			}
+			if flushErr := controller.Flush(); flushErr != nil && !errors.Is(flushErr, http.ErrNotSupported) {
+				return flushErr
+			}
+		}
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
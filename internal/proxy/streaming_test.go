@@ -0,0 +1,150 @@
+//go:build unit
+
+package proxy
+
+import (
+	"bufio"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestShouldStream(t *testing.T) {
+	p := New(&Config{})
+	assert.False(t, p.shouldStream(0))
+	assert.False(t, p.shouldStream(defaultStreamThreshold))
+	assert.True(t, p.shouldStream(defaultStreamThreshold+1))
+	assert.True(t, p.shouldStream(-1), "bodies of unknown length are streamed")
+
+	p = New(&Config{StreamThreshold: -1})
+	assert.False(t, p.shouldStream(-1))
+	assert.False(t, p.shouldStream(1<<30))
+}
+
+func TestBodyCapture(t *testing.T) {
+	capture := newBodyCapture(io.NopCloser(strings.NewReader("0123456789")), 4)
+	data, err := io.ReadAll(capture)
+	require.NoError(t, err)
+	assert.Equal(t, "0123456789", string(data), "the whole body is piped through")
+
+	body, size, truncated := capture.result()
+	assert.Equal(t, "0123", body)
+	assert.Equal(t, int64(10), size)
+	assert.True(t, truncated)
+
+	capture = newBodyCapture(io.NopCloser(strings.NewReader("01")), 4)
+	_, err = io.ReadAll(capture)
+	require.NoError(t, err)
+	body, _, truncated = capture.result()
+	assert.Equal(t, "01", body)
+	assert.False(t, truncated)
+}
+
+func TestStreamedBodiesKeepTheirStart(t *testing.T) {
+	download := strings.Repeat("d", 5000)
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upload, _ := io.ReadAll(r.Body)
+		w.Header().Set("X-Upload-Size", strconv.Itoa(len(upload)))
+		if _, err := w.Write([]byte(download)); err != nil {
+			t.Logf("Error writing response: %v", err)
+		}
+	}))
+	defer upstream.Close()
+
+	p := New(&Config{StreamThreshold: 1000, StreamCaptureLimit: 100})
+	rec := httptest.NewRecorder()
+	p.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, upstream.URL+"/upload", strings.NewReader(strings.Repeat("u", 3000))))
+
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, download, rec.Body.String())
+	assert.Equal(t, "3000", rec.Header().Get("X-Upload-Size"), "the whole upload reaches the upstream")
+
+	record := p.history.GetRecords()[0]
+	assert.Equal(t, strings.Repeat("u", 100), record.RequestBody)
+	assert.Equal(t, int64(3000), record.RequestSize)
+	assert.True(t, record.RequestBodyTruncated)
+	assert.Equal(t, strings.Repeat("d", 100), record.ResponseBody)
+	assert.Equal(t, int64(5000), record.ResponseSize)
+	assert.True(t, record.ResponseBodyTruncated)
+	assert.True(t, record.Success)
+
+	// Bodies under the threshold are buffered whole
+	rec = httptest.NewRecorder()
+	p.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, upstream.URL+"/upload", strings.NewReader("small")))
+	record = p.history.GetRecords()[0]
+	assert.Equal(t, "small", record.RequestBody)
+	assert.False(t, record.RequestBodyTruncated)
+}
+
+func TestCaptureMaxBodyBytes(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if _, err := w.Write([]byte(`{"items": [1, 2, 3, 4, 5, 6, 7, 8, 9]}`)); err != nil {
+			t.Logf("Error writing response: %v", err)
+		}
+	}))
+	defer upstream.Close()
+
+	p := New(&Config{CaptureMaxBodyBytes: 10})
+	assert.Equal(t, 10, p.streamCaptureLimit(), "streamed bodies are held to the smaller limit")
+	rec := httptest.NewRecorder()
+	p.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, upstream.URL+"/items", strings.NewReader("name=ada")))
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, `{"items": [1, 2, 3, 4, 5, 6, 7, 8, 9]}`, rec.Body.String(), "the client gets the whole body")
+
+	record := p.history.GetRecords()[0]
+	assert.Equal(t, "name=ada", record.RequestBody)
+	assert.False(t, record.RequestBodyTruncated)
+	assert.Equal(t, `{"items": `, record.ResponseBody)
+	assert.Equal(t, int64(38), record.ResponseSize)
+	assert.True(t, record.ResponseBodyTruncated)
+	assert.Nil(t, record.ResponseBodyDecoded, "decoded copies of cut bodies are dropped")
+}
+
+func TestStreamedEventsArriveAsSent(t *testing.T) {
+	release := make(chan struct{})
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		if _, err := w.Write([]byte("data: first\n\n")); err != nil {
+			t.Logf("Error writing event: %v", err)
+		}
+		w.(http.Flusher).Flush()
+		<-release
+		if _, err := w.Write([]byte("data: second\n\n")); err != nil {
+			t.Logf("Error writing event: %v", err)
+		}
+	}))
+	defer upstream.Close()
+
+	p := New(&Config{})
+	proxyServer := httptest.NewServer(p)
+	defer proxyServer.Close()
+	proxyURL, _ := url.Parse(proxyServer.URL)
+	client := &http.Client{Transport: &http.Transport{Proxy: http.ProxyURL(proxyURL)}, Timeout: 5 * time.Second}
+
+	resp, err := client.Get(upstream.URL + "/events")
+	require.NoError(t, err)
+	defer func() { _ = resp.Body.Close() }()
+	reader := bufio.NewReader(resp.Body)
+	line, err := reader.ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, "data: first\n", line, "the first event arrives before the stream ends")
+
+	close(release)
+	rest, err := io.ReadAll(reader)
+	require.NoError(t, err)
+	assert.Equal(t, "\ndata: second\n\n", string(rest))
+
+	require.Eventually(t, func() bool { return len(p.history.GetRecords()) == 1 }, time.Second, 10*time.Millisecond)
+	record := p.history.GetRecords()[0]
+	assert.Equal(t, "data: first\n\ndata: second\n\n", record.ResponseBody)
+	assert.False(t, record.ResponseBodyTruncated)
+}
@@ -0,0 +1,467 @@
+package proxy
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// defaultStrictMaxHeaderBytes bounds the request line and headers in strict
+// parsing mode
+const defaultStrictMaxHeaderBytes = 32 << 10
+
+// Reasons strict parsing rejects a request, used as the metric label
+const (
+	strictRequestLine      = "request_line"      // Malformed request line or unsupported version
+	strictInvalidCharacter = "invalid_character" // Characters not allowed in the target, a header name, or a value
+	strictHeaderSyntax     = "header_syntax"     // Header lines without a colon, obs-fold, bare LF line endings
+	strictHeaderSize       = "header_size"       // Request line and headers over the limit
+	strictHost             = "host"              // Missing, repeated, or conflicting Host
+	strictFraming          = "framing"           // Conflicting or malformed Content-Length and Transfer-Encoding
+)
+
+// strictViolationHeader carries the reason of a rejected request from the
+// connection to ServeHTTP; clients cannot send it in strict mode
+const strictViolationHeader = "X-Netkit-Strict-Violation"
+
+// errStrictFraming ends a connection whose chunked body is malformed
+var errStrictFraming = errors.New("strict parsing: malformed chunked body")
+
+// strictViolation is why a request was rejected
+type strictViolation struct {
+	reason  string
+	message string
+}
+
+// strictParsing counts the requests strict mode rejected, by reason
+type strictParsing struct {
+	maxHeaderBytes int
+	mutex          sync.Mutex
+	rejected       map[string]int64
+}
+
+func newStrictParsing(maxHeaderBytes int) *strictParsing {
+	if maxHeaderBytes <= 0 {
+		maxHeaderBytes = defaultStrictMaxHeaderBytes
+	}
+	return &strictParsing{maxHeaderBytes: maxHeaderBytes, rejected: make(map[string]int64)}
+}
+
+func (s *strictParsing) reject(reason string) {
+	if s == nil {
+		return
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.rejected[reason]++
+}
+
+// writeStrictMetrics appends the rejection counters in the Prometheus text format
+func (s *strictParsing) writeStrictMetrics(b *strings.Builder) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	reasons := []string{strictFraming, strictHeaderSize, strictHeaderSyntax, strictHost, strictInvalidCharacter, strictRequestLine}
+	b.WriteString("\n# HELP netkit_strict_rejected_total Requests rejected by strict parsing, by reason\n")
+	b.WriteString("# TYPE netkit_strict_rejected_total counter\n")
+	for _, reason := range reasons {
+		fmt.Fprintf(b, "netkit_strict_rejected_total{reason=%q} %d\n", reason, s.rejected[reason])
+	}
+}
+
+// strictListener checks every request on plain HTTP connections before the
+// HTTP server parses it, or only notes their header names when strict is nil
+// and headerCase is set. TLS connections terminated by protocol sniffing are
+// passed through, since the server needs their *tls.Conn.
+type strictListener struct {
+	net.Listener
+	strict     *strictParsing
+	headerCase bool // Add the header names as sent to each request head
+}
+
+func (l *strictListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := conn.(*tls.Conn); ok {
+		return conn, nil
+	}
+	return &strictConn{Conn: conn, reader: bufio.NewReader(conn), strict: l.strict, headerCase: l.headerCase}, nil
+}
+
+// States of a strictConn between reads
+const (
+	strictHead        = iota // Reading a request line and headers
+	strictBody               // Passing a Content-Length body through
+	strictChunkSize          // Reading a chunk size line
+	strictChunkData          // Passing chunk data through
+	strictChunkEnd           // Reading the CRLF after chunk data
+	strictTrailers           // Reading trailer lines after the last chunk
+	strictPassthrough        // Tunneled or upgraded; no longer HTTP
+	strictClosed             // A rejection was handed to the server; nothing follows
+)
+
+// strictConn hands the HTTP server only requests that passed the strict
+// checks. It follows message framing to find each request head, and replaces
+// a rejected request with one that ServeHTTP answers with a 400, so the
+// response stays in order on pipelined connections. Partial lines are kept
+// between reads, as the server interrupts reads with deadlines. Without
+// strict parsing, it only adds header names to heads and leaves requests it
+// cannot follow to the server.
+type strictConn struct {
+	net.Conn
+	reader     *bufio.Reader
+	strict     *strictParsing // Nil when only preserving header case
+	headerCase bool
+
+	state     int
+	remaining int64  // Bytes left of a body or chunk
+	line      []byte // Partial line or head read so far
+	pending   []byte // Checked bytes not yet returned
+}
+
+// CloseWrite half-closes the underlying connection when supported, for tunnels
+func (c *strictConn) CloseWrite() error {
+	if hc, ok := c.Conn.(interface{ CloseWrite() error }); ok {
+		return hc.CloseWrite()
+	}
+	return nil
+}
+
+func (c *strictConn) Read(b []byte) (int, error) {
+	for len(c.pending) == 0 {
+		switch c.state {
+		case strictClosed:
+			return 0, io.EOF
+		case strictPassthrough:
+			return c.reader.Read(b)
+		case strictBody, strictChunkData:
+			if int64(len(b)) > c.remaining {
+				b = b[:c.remaining]
+			}
+			n, err := c.reader.Read(b)
+			c.remaining -= int64(n)
+			if c.remaining == 0 && c.state == strictBody {
+				c.state = strictHead
+			} else if c.remaining == 0 {
+				c.state = strictChunkEnd
+			}
+			return n, err
+		}
+
+		line, err := c.readLine()
+		if err != nil {
+			return 0, err
+		}
+		if violation := c.advance(line); violation != nil && c.strict == nil {
+			c.pending, c.state = line, strictPassthrough
+		} else if violation != nil {
+			c.strict.reject(violation.reason)
+			c.pending = []byte("GET / HTTP/1.1\r\nHost: netkit\r\nConnection: close\r\n" +
+				strictViolationHeader + ": " + violation.message + "\r\n\r\n")
+			c.state = strictClosed
+		} else if c.state == strictClosed {
+			return 0, errStrictFraming
+		}
+	}
+	n := copy(b, c.pending)
+	c.pending = c.pending[n:]
+	return n, nil
+}
+
+// readLine returns the next complete line, keeping partial lines between
+// calls; a head is read whole. Reading stops past the size limit.
+func (c *strictConn) readLine() ([]byte, error) {
+	for {
+		chunk, err := c.reader.ReadSlice('\n')
+		c.line = append(c.line, chunk...)
+		if len(c.line) > c.maxHeaderBytes() {
+			line := c.line
+			c.line = nil
+			return line, nil
+		}
+		if err == bufio.ErrBufferFull {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if c.state == strictHead && !isHeadComplete(c.line) {
+			continue
+		}
+		line := c.line
+		c.line = nil
+		return line, nil
+	}
+}
+
+// maxHeaderBytes returns the largest line or head read before giving up
+func (c *strictConn) maxHeaderBytes() int {
+	if c.strict == nil {
+		return defaultStrictMaxHeaderBytes
+	}
+	return c.strict.maxHeaderBytes
+}
+
+// malformed ends a connection whose chunked framing is malformed, or leaves
+// the rest of it to the server when only preserving header case
+func (c *strictConn) malformed(line []byte) {
+	if c.strict == nil {
+		c.pending, c.state = line, strictPassthrough
+		return
+	}
+	c.strict.reject(strictFraming)
+	c.state = strictClosed
+}
+
+// isHeadComplete reports whether head ends with an empty line
+func isHeadComplete(head []byte) bool {
+	return bytes.HasSuffix(head, []byte("\r\n\r\n")) || bytes.HasSuffix(head, []byte("\n\n")) ||
+		bytes.Equal(head, []byte("\r\n")) || bytes.Equal(head, []byte("\n"))
+}
+
+// advance checks a request head or a line of chunked framing, queues it for
+// the server, and moves to the next state. Malformed chunked framing closes
+// the connection, as the request is already being served.
+func (c *strictConn) advance(line []byte) *strictViolation {
+	if len(line) > c.maxHeaderBytes() {
+		if c.state == strictHead {
+			return &strictViolation{strictHeaderSize, fmt.Sprintf("request line and headers exceed %d bytes", c.maxHeaderBytes())}
+		}
+		c.malformed(line)
+		return nil
+	}
+
+	switch c.state {
+	case strictHead:
+		next, length, violation := checkRequestHead(line)
+		if violation != nil {
+			return violation
+		}
+		c.state, c.remaining = next, length
+		if next == strictBody && length == 0 {
+			c.state = strictHead
+		}
+		if c.headerCase {
+			line = withHeaderCase(line)
+		}
+
+	case strictChunkSize:
+		size, ok := parseChunkSize(line)
+		if !ok {
+			c.malformed(line)
+			return nil
+		}
+		c.state, c.remaining = strictChunkData, size
+		if size == 0 {
+			c.state = strictTrailers
+		}
+
+	case strictChunkEnd:
+		if string(line) != "\r\n" {
+			c.malformed(line)
+			return nil
+		}
+		c.state = strictChunkSize
+
+	case strictTrailers:
+		if string(line) == "\r\n" {
+			c.state = strictHead
+		} else if !bytes.HasSuffix(line, []byte("\r\n")) || bytes.IndexByte(line, ':') <= 0 {
+			c.malformed(line)
+			return nil
+		}
+	}
+	c.pending = line
+	return nil
+}
+
+// parseChunkSize parses a chunk size line, ignoring chunk extensions
+func parseChunkSize(line []byte) (int64, bool) {
+	if !bytes.HasSuffix(line, []byte("\r\n")) {
+		return 0, false
+	}
+	hex, _, _ := strings.Cut(strings.TrimSuffix(string(line), "\r\n"), ";")
+	if hex == "" || len(hex) > 15 {
+		return 0, false
+	}
+	size, err := strconv.ParseInt(hex, 16, 64)
+	return size, err == nil && size >= 0 && !strings.ContainsAny(hex, "+-xX")
+}
+
+// checkRequestHead checks a request line and its headers, returning the
+// state the body puts the connection in and the Content-Length
+func checkRequestHead(head []byte) (int, int64, *strictViolation) {
+	if !bytes.HasSuffix(head, []byte("\r\n\r\n")) && !bytes.Equal(head, []byte("\r\n")) {
+		return 0, 0, &strictViolation{strictHeaderSyntax, "lines must end with CRLF"}
+	}
+	lines := strings.Split(string(head), "\n")
+	lines = lines[:len(lines)-2] // The empty line ending the head and what follows it
+	for i, line := range lines {
+		if !strings.HasSuffix(line, "\r") {
+			return 0, 0, &strictViolation{strictHeaderSyntax, "lines must end with CRLF"}
+		}
+		lines[i] = strings.TrimSuffix(line, "\r")
+	}
+	if len(lines) == 0 || lines[0] == "" {
+		return 0, 0, &strictViolation{strictRequestLine, "empty request line"}
+	}
+
+	// Request line: method SP request-target SP HTTP-version
+	parts := strings.Split(lines[0], " ")
+	if len(parts) != 3 || parts[0] == "" || parts[1] == "" {
+		return 0, 0, &strictViolation{strictRequestLine, "expected method, target, and version separated by single spaces"}
+	}
+	method, target, version := parts[0], parts[1], parts[2]
+	if !isToken(method) {
+		return 0, 0, &strictViolation{strictInvalidCharacter, "invalid method"}
+	}
+	if version != "HTTP/1.1" && version != "HTTP/1.0" {
+		return 0, 0, &strictViolation{strictRequestLine, "unsupported version " + strconv.QuoteToASCII(version)}
+	}
+	if violation := checkRequestTarget(method, target); violation != nil {
+		return 0, 0, violation
+	}
+
+	var hosts, contentLengths, transferEncodings []string
+	upgrade := false
+	for _, line := range lines[1:] {
+		if line[0] == ' ' || line[0] == '\t' {
+			return 0, 0, &strictViolation{strictHeaderSyntax, "folded header lines are not allowed"}
+		}
+		name, value, ok := strings.Cut(line, ":")
+		if !ok || name == "" {
+			return 0, 0, &strictViolation{strictHeaderSyntax, "header line without a name and colon"}
+		}
+		if !isToken(name) {
+			return 0, 0, &strictViolation{strictInvalidCharacter, "invalid header name " + strconv.QuoteToASCII(name)}
+		}
+		value = strings.Trim(value, " \t")
+		for i := 0; i < len(value); i++ {
+			if ch := value[i]; (ch < 0x20 && ch != '\t') || ch >= 0x7f {
+				return 0, 0, &strictViolation{strictInvalidCharacter, "invalid character in " + name + " header"}
+			}
+		}
+		switch http.CanonicalHeaderKey(name) {
+		case "Host":
+			hosts = append(hosts, value)
+		case "Content-Length":
+			contentLengths = append(contentLengths, value)
+		case "Transfer-Encoding":
+			transferEncodings = append(transferEncodings, value)
+		case "Upgrade":
+			upgrade = true
+		case strictViolationHeader:
+			return 0, 0, &strictViolation{strictHeaderSyntax, strictViolationHeader + " is reserved"}
+		}
+	}
+
+	// Host must identify one authority, the one in an absolute-form target
+	if len(hosts) > 1 {
+		return 0, 0, &strictViolation{strictHost, "multiple Host headers"}
+	}
+	if len(hosts) == 0 && version == "HTTP/1.1" {
+		return 0, 0, &strictViolation{strictHost, "missing Host header"}
+	}
+	if len(hosts) == 1 && strings.Contains(target, "://") {
+		if u, err := url.Parse(target); err == nil && !strings.EqualFold(u.Host, hosts[0]) {
+			return 0, 0, &strictViolation{strictHost, "Host header does not match the request target"}
+		}
+	}
+
+	// One unambiguous way to find the end of the body
+	next, length := strictBody, int64(0)
+	switch {
+	case len(transferEncodings) > 0 && len(contentLengths) > 0:
+		return 0, 0, &strictViolation{strictFraming, "both Content-Length and Transfer-Encoding"}
+	case len(transferEncodings) > 1 || len(contentLengths) > 1:
+		return 0, 0, &strictViolation{strictFraming, "repeated Content-Length or Transfer-Encoding"}
+	case len(transferEncodings) == 1:
+		if version == "HTTP/1.0" {
+			return 0, 0, &strictViolation{strictFraming, "Transfer-Encoding in an HTTP/1.0 request"}
+		}
+		if !strings.EqualFold(transferEncodings[0], "chunked") {
+			return 0, 0, &strictViolation{strictFraming, "Transfer-Encoding must be chunked"}
+		}
+		next = strictChunkSize
+	case len(contentLengths) == 1:
+		var err error
+		length, err = strconv.ParseInt(contentLengths[0], 10, 64)
+		if err != nil || length < 0 || strings.HasPrefix(contentLengths[0], "+") {
+			return 0, 0, &strictViolation{strictFraming, "invalid Content-Length"}
+		}
+	}
+
+	// Tunnels and upgrades stop being HTTP once answered
+	if method == http.MethodConnect || upgrade {
+		return strictPassthrough, 0, nil
+	}
+	return next, length, nil
+}
+
+// checkRequestTarget checks the form of a request target and that it only
+// has characters allowed in a URI, percent-encoded where needed
+func checkRequestTarget(method, target string) *strictViolation {
+	for i := 0; i < len(target); i++ {
+		ch := target[i]
+		if ch == '%' {
+			if i+2 >= len(target) || !isHexDigit(target[i+1]) || !isHexDigit(target[i+2]) {
+				return &strictViolation{strictInvalidCharacter, "invalid percent-encoding in the request target"}
+			}
+			continue
+		}
+		if ch <= 0x20 || ch >= 0x7f || strings.IndexByte(`"<>\^`+"`{|}", ch) >= 0 {
+			return &strictViolation{strictInvalidCharacter, fmt.Sprintf("byte 0x%02x must be percent-encoded in the request target", ch)}
+		}
+	}
+	switch {
+	case method == http.MethodConnect:
+		if _, _, err := net.SplitHostPort(target); err != nil {
+			return &strictViolation{strictRequestLine, "CONNECT needs a host:port target"}
+		}
+	case target == "*":
+		if method != http.MethodOptions {
+			return &strictViolation{strictRequestLine, "* is only a target for OPTIONS"}
+		}
+	case !strings.HasPrefix(target, "/"):
+		u, err := url.Parse(target)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return &strictViolation{strictRequestLine, "target must be a path or an http(s) URL"}
+		}
+	}
+	return nil
+}
+
+// isToken reports whether s is an RFC 9110 token, as methods and header
+// names must be
+func isToken(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		ch := s[i]
+		if ch <= 0x20 || ch >= 0x7f || strings.IndexByte(`"(),/:;<=>?@[\]{}`, ch) >= 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func isHexDigit(ch byte) bool {
+	return ('0' <= ch && ch <= '9') || ('a' <= ch && ch <= 'f') || ('A' <= ch && ch <= 'F')
+}
+
+// handleStrictViolation answers a request strict parsing rejected
+func handleStrictViolation(w http.ResponseWriter, message string) {
+	w.Header().Set("Connection", "close")
+	http.Error(w, "Bad Request: "+message, http.StatusBadRequest)
+}
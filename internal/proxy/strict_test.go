@@ -0,0 +1,199 @@
+//go:build unit
+
+package proxy
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// startStrictProxy serves p on a strict listener, returning its address
+func startStrictProxy(t *testing.T, p *Proxy) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	server := &http.Server{Handler: p}
+	go func() { _ = server.Serve(&strictListener{Listener: ln, strict: p.strict}) }()
+	t.Cleanup(func() { _ = server.Close() })
+	return ln.Addr().String()
+}
+
+// sendRaw writes raw to a new connection and reads the responses to count requests
+func sendRaw(t *testing.T, addr, raw string, count int) []*http.Response {
+	t.Helper()
+	conn, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer conn.Close()
+	require.NoError(t, conn.SetDeadline(time.Now().Add(5*time.Second)))
+	_, err = io.WriteString(conn, raw)
+	require.NoError(t, err)
+
+	reader := bufio.NewReader(conn)
+	var responses []*http.Response
+	for i := 0; i < count; i++ {
+		resp, err := http.ReadResponse(reader, nil)
+		require.NoError(t, err)
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		resp.Body = io.NopCloser(strings.NewReader(string(body)))
+		responses = append(responses, resp)
+	}
+	return responses
+}
+
+func newStrictUpstream(t *testing.T) (*httptest.Server, string) {
+	t.Helper()
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		_, _ = w.Write(append([]byte("echo:"), body...))
+	}))
+	t.Cleanup(upstream.Close)
+	return upstream, strings.TrimPrefix(upstream.URL, "http://")
+}
+
+func TestStrictParsingAllowsValidRequests(t *testing.T) {
+	upstream, host := newStrictUpstream(t)
+	p := New(&Config{StrictParsing: true})
+	addr := startStrictProxy(t, p)
+
+	// Pipelined requests with each kind of body framing
+	raw := "GET " + upstream.URL + "/a%20b?q=1 HTTP/1.1\r\nHost: " + host + "\r\n\r\n" +
+		"POST " + upstream.URL + "/len HTTP/1.1\r\nHost: " + host + "\r\nContent-Length: 5\r\n\r\nhello" +
+		"POST " + upstream.URL + "/chunked HTTP/1.1\r\nHost: " + host + "\r\nTransfer-Encoding: chunked\r\n\r\n3;ext=1\r\nabc\r\n2\r\nde\r\n0\r\nX-Trailer: 1\r\n\r\n" +
+		"GET " + upstream.URL + "/last HTTP/1.1\r\nHost: " + host + "\r\nConnection: close\r\n\r\n"
+	responses := sendRaw(t, addr, raw, 4)
+	for i, want := range []string{"echo:", "echo:hello", "echo:abcde", "echo:"} {
+		assert.Equal(t, http.StatusOK, responses[i].StatusCode, i)
+		body, _ := io.ReadAll(responses[i].Body)
+		assert.Equal(t, want, string(body), i)
+	}
+	assert.Len(t, p.history.GetRecords(), 4)
+}
+
+func TestStrictParsingRejects(t *testing.T) {
+	upstream, host := newStrictUpstream(t)
+	target := upstream.URL + "/x"
+	tests := []struct {
+		name   string
+		raw    string
+		reason string
+	}{
+		{"CL and TE", "POST " + target + " HTTP/1.1\r\nHost: " + host + "\r\nContent-Length: 5\r\nTransfer-Encoding: chunked\r\n\r\n0\r\n\r\n", strictFraming},
+		{"repeated CL", "POST " + target + " HTTP/1.1\r\nHost: " + host + "\r\nContent-Length: 3\r\nContent-Length: 3\r\n\r\nabc", strictFraming},
+		{"signed CL", "POST " + target + " HTTP/1.1\r\nHost: " + host + "\r\nContent-Length: +3\r\n\r\nabc", strictFraming},
+		{"TE not chunked", "POST " + target + " HTTP/1.1\r\nHost: " + host + "\r\nTransfer-Encoding: gzip, chunked\r\n\r\n0\r\n\r\n", strictFraming},
+		{"TE in HTTP/1.0", "POST " + target + " HTTP/1.0\r\nHost: " + host + "\r\nTransfer-Encoding: chunked\r\n\r\n0\r\n\r\n", strictFraming},
+		{"unsafe target", "GET " + upstream.URL + "/a\\b<x> HTTP/1.1\r\nHost: " + host + "\r\n\r\n", strictInvalidCharacter},
+		{"non-ASCII target", "GET " + upstream.URL + "/caf\xc3\xa9 HTTP/1.1\r\nHost: " + host + "\r\n\r\n", strictInvalidCharacter},
+		{"bad percent-encoding", "GET " + upstream.URL + "/%zz HTTP/1.1\r\nHost: " + host + "\r\n\r\n", strictInvalidCharacter},
+		{"non-ASCII header", "GET " + target + " HTTP/1.1\r\nHost: " + host + "\r\nX-A: v\xff\r\n\r\n", strictInvalidCharacter},
+		{"folded header", "GET " + target + " HTTP/1.1\r\nHost: " + host + "\r\nX-Fold: a\r\n b\r\n\r\n", strictHeaderSyntax},
+		{"bare LF", "GET " + target + " HTTP/1.1\nHost: " + host + "\n\n", strictHeaderSyntax},
+		{"reserved header", "GET " + target + " HTTP/1.1\r\nHost: " + host + "\r\n" + strictViolationHeader + ": x\r\n\r\n", strictHeaderSyntax},
+		{"double space", "GET  " + target + " HTTP/1.1\r\nHost: " + host + "\r\n\r\n", strictRequestLine},
+		{"HTTP/2 preface", "PRI * HTTP/2.0\r\n\r\nSM\r\n\r\n", strictRequestLine},
+		{"missing Host", "GET /x HTTP/1.1\r\n\r\n", strictHost},
+		{"repeated Host", "GET " + target + " HTTP/1.1\r\nHost: " + host + "\r\nHost: other\r\n\r\n", strictHost},
+		{"Host mismatch", "GET " + target + " HTTP/1.1\r\nHost: internal.example\r\n\r\n", strictHost},
+		{"oversized headers", "GET " + target + " HTTP/1.1\r\nHost: " + host + "\r\nX-Big: " + strings.Repeat("a", 2048) + "\r\n\r\n", strictHeaderSize},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := New(&Config{StrictParsing: true, StrictHeaderBytes: 1024})
+			addr := startStrictProxy(t, p)
+			resp := sendRaw(t, addr, tt.raw, 1)[0]
+			assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+			assert.True(t, resp.Close, "the connection is closed after a rejection")
+			assert.Empty(t, p.history.GetRecords(), "rejected requests never reach the upstream")
+			assert.Equal(t, int64(1), p.strict.rejected[tt.reason])
+		})
+	}
+}
+
+func TestStrictParsingRejectsAfterValidRequest(t *testing.T) {
+	upstream, host := newStrictUpstream(t)
+	p := New(&Config{StrictParsing: true})
+	addr := startStrictProxy(t, p)
+
+	raw := "GET " + upstream.URL + "/ok HTTP/1.1\r\nHost: " + host + "\r\n\r\n" +
+		"POST " + upstream.URL + "/smuggle HTTP/1.1\r\nHost: " + host + "\r\nContent-Length: 4\r\nTransfer-Encoding: chunked\r\n\r\n0\r\n\r\n"
+	responses := sendRaw(t, addr, raw, 2)
+	assert.Equal(t, http.StatusOK, responses[0].StatusCode)
+	assert.Equal(t, http.StatusBadRequest, responses[1].StatusCode)
+	body, _ := io.ReadAll(responses[1].Body)
+	assert.Contains(t, string(body), "both Content-Length and Transfer-Encoding")
+	assert.Len(t, p.history.GetRecords(), 1)
+
+	rec := httptest.NewRecorder()
+	p.handleMetrics(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	assert.Contains(t, rec.Body.String(), `netkit_strict_rejected_total{reason="framing"} 1`)
+	assert.Contains(t, rec.Body.String(), `netkit_strict_rejected_total{reason="host"} 0`)
+}
+
+func TestStrictParsingMalformedChunks(t *testing.T) {
+	upstream, host := newStrictUpstream(t)
+	p := New(&Config{StrictParsing: true})
+	addr := startStrictProxy(t, p)
+
+	conn, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer conn.Close()
+	require.NoError(t, conn.SetDeadline(time.Now().Add(5*time.Second)))
+	_, err = io.WriteString(conn, "POST "+upstream.URL+"/x HTTP/1.1\r\nHost: "+host+"\r\nTransfer-Encoding: chunked\r\n\r\nzz\r\nabc\r\n0\r\n\r\n")
+	require.NoError(t, err)
+	_, _ = io.ReadAll(conn)
+	assert.Equal(t, int64(1), p.strict.rejected[strictFraming])
+}
+
+func TestStrictParsingTunnels(t *testing.T) {
+	echo, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer echo.Close()
+	go func() {
+		conn, err := echo.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		_, _ = io.CopyN(conn, conn, 8)
+	}()
+
+	p := New(&Config{StrictParsing: true})
+	addr := startStrictProxy(t, p)
+	conn, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer conn.Close()
+	require.NoError(t, conn.SetDeadline(time.Now().Add(5*time.Second)))
+	_, err = io.WriteString(conn, "CONNECT "+echo.Addr().String()+" HTTP/1.1\r\nHost: "+echo.Addr().String()+"\r\n\r\n")
+	require.NoError(t, err)
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, nil)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	// Tunneled bytes are not HTTP and pass through unchecked
+	_, err = io.WriteString(conn, "\x00\xff bin\r\n")
+	require.NoError(t, err)
+	reply := make([]byte, 8)
+	_, err = io.ReadFull(reader, reply)
+	require.NoError(t, err)
+	assert.Equal(t, "\x00\xff bin\r\n", string(reply))
+}
+
+func TestStrictViolationHeaderNeedsStrictMode(t *testing.T) {
+	p := New(&Config{})
+	req := httptest.NewRequest(http.MethodOptions, "http://example.com/", nil)
+	req.Header.Set(strictViolationHeader, "spoofed")
+	rec := httptest.NewRecorder()
+	p.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusOK, rec.Code)
+}
@@ -0,0 +1,322 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultSyslogFacility is the facility logs are sent with unless set otherwise
+const DefaultSyslogFacility = "daemon"
+
+// syslogWriteTimeout bounds how long a stalled TCP syslog daemon can hold up logging
+const syslogWriteTimeout = 5 * time.Second
+
+// syslogLocalPaths are the sockets local syslog daemons listen on, tried in order
+var syslogLocalPaths = []string{"/dev/log", "/var/run/syslog", "/var/run/log"}
+
+// syslogFacilities are the facility codes of RFC 5424 by name
+var syslogFacilities = map[string]int{
+	"kern": 0, "user": 1, "mail": 2, "daemon": 3, "auth": 4, "syslog": 5, "lpr": 6, "news": 7,
+	"uucp": 8, "cron": 9, "authpriv": 10, "ftp": 11,
+	"local0": 16, "local1": 17, "local2": 18, "local3": 19, "local4": 20, "local5": 21, "local6": 22, "local7": 23,
+}
+
+// Syslog severities of RFC 5424 that log levels map to
+const (
+	syslogCritical = 2
+	syslogError    = 3
+	syslogWarning  = 4
+	syslogInfo     = 6
+	syslogDebug    = 7
+)
+
+// ParseSyslogFacility returns the code of a facility name such as daemon or local0
+func ParseSyslogFacility(name string) (int, error) {
+	facility, ok := syslogFacilities[strings.ToLower(name)]
+	if !ok {
+		return 0, fmt.Errorf("unknown facility %q, expected kern, user, mail, daemon, auth, syslog, lpr, news, uucp, cron, authpriv, ftp, or local0 to local7", name)
+	}
+	return facility, nil
+}
+
+// syslogSeverity maps a log level to a syslog severity. Levels above error,
+// which nothing logs at yet, are critical.
+func syslogSeverity(level slog.Level) int {
+	switch {
+	case level > slog.LevelError:
+		return syslogCritical
+	case level >= slog.LevelError:
+		return syslogError
+	case level >= slog.LevelWarn:
+		return syslogWarning
+	case level >= slog.LevelInfo:
+		return syslogInfo
+	}
+	return syslogDebug
+}
+
+// ParseSyslogAddress parses a --log-syslog target: udp://host[:port] or
+// tcp://host[:port] for a remote daemon (port 514 by default),
+// unix:///path for a socket, or local for the local daemon's socket
+func ParseSyslogAddress(raw string) (network, addr string, err error) {
+	if raw == "local" {
+		return "unix", "", nil
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		return "", "", fmt.Errorf("invalid syslog address %q: %v", raw, err)
+	}
+	switch u.Scheme {
+	case "udp", "tcp":
+		if u.Hostname() == "" {
+			return "", "", fmt.Errorf("invalid syslog address %q: missing host", raw)
+		}
+		port := u.Port()
+		if port == "" {
+			port = "514"
+		}
+		return u.Scheme, net.JoinHostPort(u.Hostname(), port), nil
+	case "unix":
+		if u.Path == "" {
+			return "", "", fmt.Errorf("invalid syslog address %q: missing socket path", raw)
+		}
+		return "unix", u.Path, nil
+	}
+	return "", "", fmt.Errorf("invalid syslog address %q: expected udp://host:port, tcp://host:port, unix:///path, or local", raw)
+}
+
+// SyslogWriter sends log messages to a syslog daemon. Messages to the local
+// daemon use the traditional BSD format it expects; remote ones add the
+// hostname and a full timestamp, and are newline-framed over TCP.
+type SyslogWriter struct {
+	network  string
+	addr     string // Empty for the local daemon
+	facility int
+	tag      string
+	hostname string
+	mutex    sync.Mutex
+	conn     net.Conn
+	local    bool // Connected to a local socket
+}
+
+// OpenSyslog connects to the syslog daemon at a ParseSyslogAddress target,
+// sending messages with facility and tag
+func OpenSyslog(target string, facility int, tag string) (*SyslogWriter, error) {
+	network, addr, err := ParseSyslogAddress(target)
+	if err != nil {
+		return nil, err
+	}
+	hostname, _ := os.Hostname()
+	if hostname == "" {
+		hostname = "localhost"
+	}
+	w := &SyslogWriter{network: network, addr: addr, facility: facility, tag: tag, hostname: hostname}
+	if err := w.connect(); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// connect dials the daemon. Callers hold the mutex or own w exclusively.
+func (w *SyslogWriter) connect() error {
+	if w.conn != nil {
+		w.conn.Close()
+		w.conn = nil
+	}
+	if w.network != "unix" {
+		conn, err := net.DialTimeout(w.network, w.addr, syslogWriteTimeout)
+		if err != nil {
+			return fmt.Errorf("failed to connect to syslog: %w", err)
+		}
+		w.conn, w.local = conn, false
+		return nil
+	}
+
+	paths := syslogLocalPaths
+	if w.addr != "" {
+		paths = []string{w.addr}
+	}
+	var lastErr error
+	for _, path := range paths {
+		// Daemons listen on datagram sockets, or stream ones on some systems
+		for _, network := range []string{"unixgram", "unix"} {
+			conn, err := net.Dial(network, path)
+			if err == nil {
+				w.conn, w.local = conn, true
+				return nil
+			}
+			lastErr = err
+		}
+	}
+	return fmt.Errorf("failed to connect to syslog: %w", lastErr)
+}
+
+// format returns a message as sent on the wire
+func (w *SyslogWriter) format(severity int, msg string, t time.Time) []byte {
+	priority := w.facility*8 + severity
+	msg = strings.TrimRight(msg, "\n")
+	if w.local {
+		return []byte(fmt.Sprintf("<%d>%s %s[%d]: %s", priority, t.Format(time.Stamp), w.tag, os.Getpid(), msg))
+	}
+	line := fmt.Sprintf("<%d>%s %s %s[%d]: %s", priority, t.Format(time.RFC3339), w.hostname, w.tag, os.Getpid(), msg)
+	if w.network == "tcp" {
+		line += "\n"
+	}
+	return []byte(line)
+}
+
+// Send writes one message at a severity, reconnecting once if the daemon
+// went away, e.g. after it restarted
+func (w *SyslogWriter) Send(severity int, msg string) error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	now := time.Now()
+	if w.conn != nil {
+		if err := w.write(w.format(severity, msg, now)); err == nil {
+			return nil
+		}
+	}
+	if err := w.connect(); err != nil {
+		return err
+	}
+	return w.write(w.format(severity, msg, now))
+}
+
+// write sends a formatted message. Callers hold the mutex.
+func (w *SyslogWriter) write(data []byte) error {
+	if err := w.conn.SetWriteDeadline(time.Now().Add(syslogWriteTimeout)); err != nil {
+		return err
+	}
+	_, err := w.conn.Write(data)
+	return err
+}
+
+// Close closes the connection to the daemon
+func (w *SyslogWriter) Close() error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if w.conn == nil {
+		return nil
+	}
+	err := w.conn.Close()
+	w.conn = nil
+	return err
+}
+
+// syslogHandler sends each log record to syslog at the severity of its
+// level, formatted as text or JSON without the time and level, which syslog
+// carries itself
+type syslogHandler struct {
+	writer  *SyslogWriter
+	format  string
+	options *slog.HandlerOptions
+	scopes  []func(slog.Handler) slog.Handler // WithAttrs and WithGroup calls, replayed per record
+}
+
+// newSyslogHandler returns a handler sending records at or above the level
+// of options to w
+func newSyslogHandler(w *SyslogWriter, format string, options *slog.HandlerOptions) *syslogHandler {
+	return &syslogHandler{
+		writer: w,
+		format: format,
+		options: &slog.HandlerOptions{
+			Level: options.Level,
+			ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+				if len(groups) == 0 && (a.Key == slog.TimeKey || a.Key == slog.LevelKey) {
+					return slog.Attr{}
+				}
+				return a
+			},
+		},
+	}
+}
+
+// Enabled reports whether records at level are sent
+func (h *syslogHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= h.options.Level.Level()
+}
+
+// Handle formats a record and sends it. Send errors go to stderr, since they
+// cannot be logged.
+func (h *syslogHandler) Handle(ctx context.Context, record slog.Record) error {
+	var buf bytes.Buffer
+	var handler slog.Handler = slog.NewTextHandler(&buf, h.options)
+	if h.format == LogFormatJSON {
+		handler = slog.NewJSONHandler(&buf, h.options)
+	}
+	for _, scope := range h.scopes {
+		handler = scope(handler)
+	}
+	if err := handler.Handle(ctx, record); err != nil {
+		return err
+	}
+	if err := h.writer.Send(syslogSeverity(record.Level), buf.String()); err != nil {
+		fmt.Fprintf(os.Stderr, "Error sending log to syslog: %v\n", err)
+	}
+	return nil
+}
+
+// WithAttrs returns a handler adding attrs to every record
+func (h *syslogHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return h.with(func(handler slog.Handler) slog.Handler { return handler.WithAttrs(attrs) })
+}
+
+// WithGroup returns a handler nesting later attributes under name
+func (h *syslogHandler) WithGroup(name string) slog.Handler {
+	return h.with(func(handler slog.Handler) slog.Handler { return handler.WithGroup(name) })
+}
+
+func (h *syslogHandler) with(scope func(slog.Handler) slog.Handler) *syslogHandler {
+	next := *h
+	next.scopes = append(append([]func(slog.Handler) slog.Handler(nil), h.scopes...), scope)
+	return &next
+}
+
+// teeHandler sends records to every handler enabled for them
+type teeHandler []slog.Handler
+
+func (t teeHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	for _, handler := range t {
+		if handler.Enabled(ctx, level) {
+			return true
+		}
+	}
+	return false
+}
+
+func (t teeHandler) Handle(ctx context.Context, record slog.Record) error {
+	var first error
+	for _, handler := range t {
+		if handler.Enabled(ctx, record.Level) {
+			if err := handler.Handle(ctx, record.Clone()); err != nil && first == nil {
+				first = err
+			}
+		}
+	}
+	return first
+}
+
+func (t teeHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	next := make(teeHandler, len(t))
+	for i, handler := range t {
+		next[i] = handler.WithAttrs(attrs)
+	}
+	return next
+}
+
+func (t teeHandler) WithGroup(name string) slog.Handler {
+	next := make(teeHandler, len(t))
+	for i, handler := range t {
+		next[i] = handler.WithGroup(name)
+	}
+	return next
+}
@@ -0,0 +1,91 @@
+//go:build unit
+
+package proxy
+
+import (
+	"bytes"
+	"log"
+	"log/slog"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseSyslogAddress(t *testing.T) {
+	tests := []struct {
+		raw, network, addr string
+	}{
+		{"udp://logs.example.com", "udp", "logs.example.com:514"},
+		{"tcp://10.0.0.5:6514", "tcp", "10.0.0.5:6514"},
+		{"unix:///dev/log", "unix", "/dev/log"},
+		{"local", "unix", ""},
+	}
+	for _, tt := range tests {
+		network, addr, err := ParseSyslogAddress(tt.raw)
+		require.NoError(t, err, tt.raw)
+		assert.Equal(t, tt.network, network, tt.raw)
+		assert.Equal(t, tt.addr, addr, tt.raw)
+	}
+
+	for _, raw := range []string{"logs.example.com:514", "http://logs.example.com", "udp://", "unix://"} {
+		_, _, err := ParseSyslogAddress(raw)
+		assert.Error(t, err, raw)
+	}
+}
+
+func TestSyslogFacilityAndSeverity(t *testing.T) {
+	facility, err := ParseSyslogFacility("LOCAL3")
+	require.NoError(t, err)
+	assert.Equal(t, 19, facility)
+	_, err = ParseSyslogFacility("local8")
+	assert.Error(t, err)
+
+	assert.Equal(t, syslogDebug, syslogSeverity(slog.LevelDebug))
+	assert.Equal(t, syslogInfo, syslogSeverity(slog.LevelInfo))
+	assert.Equal(t, syslogWarning, syslogSeverity(slog.LevelWarn))
+	assert.Equal(t, syslogError, syslogSeverity(slog.LevelError))
+	assert.Equal(t, syslogCritical, syslogSeverity(slog.LevelError+4))
+}
+
+func TestSetupLoggingWithSyslog(t *testing.T) {
+	daemon, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer daemon.Close()
+
+	writer, err := OpenSyslog("udp://"+daemon.LocalAddr().String(), 16, "netkit-test")
+	require.NoError(t, err)
+	defer writer.Close()
+
+	previous, previousLevel := slog.Default(), logLevel.Level()
+	previousWriter, previousFlags := log.Writer(), log.Flags()
+	t.Cleanup(func() {
+		slog.SetDefault(previous)
+		log.SetOutput(previousWriter)
+		log.SetFlags(previousFlags)
+		logLevel.Set(previousLevel)
+	})
+	var stderr bytes.Buffer
+	require.NoError(t, SetupLoggingWithSyslog(&stderr, writer, "info", LogFormatJSON))
+
+	slog.Debug("Not sent")
+	slog.With("server", "admin").WithGroup("upstream").Warn("Upstream slow", "host", "api.example.com")
+
+	buf := make([]byte, 4096)
+	require.NoError(t, daemon.SetReadDeadline(time.Now().Add(5*time.Second)))
+	n, _, err := daemon.ReadFrom(buf)
+	require.NoError(t, err)
+	message := string(buf[:n])
+
+	// local0 (16) * 8 + warning (4)
+	assert.True(t, strings.HasPrefix(message, "<132>"), message)
+	assert.Contains(t, message, " netkit-test[")
+	assert.True(t, strings.HasSuffix(message, `]: {"msg":"Upstream slow","server":"admin","upstream":{"host":"api.example.com"}}`), message)
+
+	// The other output still gets every record with its time and level
+	assert.NotContains(t, stderr.String(), "Not sent")
+	assert.Contains(t, stderr.String(), `"level":"WARN"`)
+}
@@ -0,0 +1,190 @@
+package proxy
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+// defaultTailSamplingDelay is how long records wait for the rest of their
+// redirect chain before the sampling decision
+const defaultTailSamplingDelay = 2 * time.Second
+
+// TailSampling keeps every interesting request and a share of the rest,
+// deciding once a request and the redirects that led to it have completed
+type TailSampling struct {
+	Rate          float64          // Share of other requests kept, from 0 to 1
+	SlowThreshold time.Duration    // Requests slower than this are always kept (0 disables the check)
+	Keep          []*RequestFilter // Requests matching any filter are always kept
+	Delay         time.Duration    // How long records are buffered before the decision (default: 2s)
+}
+
+// Interesting reports whether a record is always kept: it failed, was slow,
+// or matches a keep filter
+func (ts *TailSampling) Interesting(record RequestRecord) bool {
+	if isFailedRecord(record) {
+		return true
+	}
+	if ts.SlowThreshold > 0 && time.Duration(record.TotalDurationUs)*time.Microsecond > ts.SlowThreshold {
+		return true
+	}
+	for _, filter := range ts.Keep {
+		if filter.Matches(record) {
+			return true
+		}
+	}
+	return false
+}
+
+// tailGroup is a buffered redirect chain, oldest hop first
+type tailGroup struct {
+	seq      uint64 // Buffering order, so kept chains are stored in the order they arrived
+	records  []RequestRecord
+	deadline time.Time
+}
+
+// tailSampler buffers records and hands the kept ones to store. Records of a
+// redirect chain share a decision, so a chain is kept whole or not at all.
+type tailSampler struct {
+	config TailSampling
+	store  func(RequestRecord)
+
+	mutex   sync.Mutex
+	groups  map[string]*tailGroup // Keyed by the first record of the chain
+	byID    map[string]string     // Buffered record ID to its group key
+	seq     uint64
+	dropped int64
+	cancel  context.CancelFunc
+	wg      sync.WaitGroup
+}
+
+func startTailSampler(config TailSampling, store func(RequestRecord)) *tailSampler {
+	if config.Delay <= 0 {
+		config.Delay = defaultTailSamplingDelay
+	}
+	ts := &tailSampler{
+		config: config,
+		store:  store,
+		groups: make(map[string]*tailGroup),
+		byID:   make(map[string]string),
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	ts.cancel = cancel
+	ts.wg.Add(1)
+	go func() {
+		defer ts.wg.Done()
+		ticker := time.NewTicker(config.Delay / 4)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case now := <-ticker.C:
+				ts.flush(now)
+			}
+		}
+	}()
+	return ts
+}
+
+// add buffers a record, joining the chain of the redirect that led to it
+func (ts *tailSampler) add(record RequestRecord) {
+	ts.mutex.Lock()
+	defer ts.mutex.Unlock()
+
+	key, ok := ts.byID[record.RedirectPrevID]
+	if record.RedirectPrevID == "" || !ok {
+		key = record.ID
+	}
+	group := ts.groups[key]
+	if group == nil {
+		ts.seq++
+		group = &tailGroup{seq: ts.seq}
+		ts.groups[key] = group
+	}
+	group.records = append(group.records, record)
+	group.deadline = time.Now().Add(ts.config.Delay)
+	ts.byID[record.ID] = key
+}
+
+// update applies update to a buffered record, reporting whether it was found
+func (ts *tailSampler) update(id string, update func(*RequestRecord)) bool {
+	ts.mutex.Lock()
+	defer ts.mutex.Unlock()
+
+	group := ts.groups[ts.byID[id]]
+	if group == nil {
+		return false
+	}
+	for i := range group.records {
+		if group.records[i].ID == id {
+			update(&group.records[i])
+			return true
+		}
+	}
+	return false
+}
+
+// flush decides on the chains whose deadline has passed
+func (ts *tailSampler) flush(now time.Time) {
+	var kept []*tailGroup
+
+	// Hold the lock while storing, so updates find records in the buffer or in history
+	ts.mutex.Lock()
+	defer ts.mutex.Unlock()
+	for key, group := range ts.groups {
+		if now.Before(group.deadline) {
+			continue
+		}
+		delete(ts.groups, key)
+		for _, record := range group.records {
+			delete(ts.byID, record.ID)
+		}
+		if ts.keep(key, group.records) {
+			kept = append(kept, group)
+		} else {
+			ts.dropped += int64(len(group.records))
+		}
+	}
+
+	// Store in the order the records were buffered
+	sort.Slice(kept, func(i, j int) bool { return kept[i].seq < kept[j].seq })
+	for _, group := range kept {
+		for _, record := range group.records {
+			ts.store(record)
+		}
+	}
+}
+
+func (ts *tailSampler) keep(key string, records []RequestRecord) bool {
+	for _, record := range records {
+		if ts.config.Interesting(record) {
+			return true
+		}
+	}
+	return sampledID(key, ts.config.Rate)
+}
+
+// reset drops every buffered record without a decision
+func (ts *tailSampler) reset() {
+	ts.mutex.Lock()
+	defer ts.mutex.Unlock()
+	ts.groups = make(map[string]*tailGroup)
+	ts.byID = make(map[string]string)
+}
+
+// droppedRecords returns how many records were sampled out
+func (ts *tailSampler) droppedRecords() int64 {
+	ts.mutex.Lock()
+	defer ts.mutex.Unlock()
+	return ts.dropped
+}
+
+// stop ends background flushing and decides on every buffered record
+func (ts *tailSampler) stop() {
+	ts.cancel()
+	ts.wg.Wait()
+	ts.flush(time.Now().Add(ts.config.Delay))
+}
@@ -0,0 +1,91 @@
+//go:build unit
+
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTailSamplingInteresting(t *testing.T) {
+	keep, err := ParseRequestFilter(url.Values{"method": {"POST"}, "host": {"payments.example.com"}}, true)
+	require.NoError(t, err)
+	config := &TailSampling{SlowThreshold: time.Second, Keep: []*RequestFilter{keep}}
+
+	ok := RequestRecord{Method: http.MethodGet, URL: "http://api.example.com/", ResponseStatus: 200, Success: true, TotalDurationUs: 1000}
+	assert.False(t, config.Interesting(ok))
+
+	failed := ok
+	failed.ResponseStatus = 502
+	assert.True(t, config.Interesting(failed))
+
+	slow := ok
+	slow.TotalDurationUs = 1500000
+	assert.True(t, config.Interesting(slow))
+
+	matched := ok
+	matched.Method, matched.URL = http.MethodPost, "http://payments.example.com/charges"
+	assert.True(t, config.Interesting(matched))
+}
+
+func TestTailSamplerKeepsInterestingChains(t *testing.T) {
+	var stored []RequestRecord
+	ts := startTailSampler(TailSampling{Rate: 0, Delay: time.Hour}, func(record RequestRecord) {
+		stored = append(stored, record)
+	})
+	defer ts.stop()
+
+	ts.add(RequestRecord{ID: "boring", ResponseStatus: 200, Success: true})
+	// A redirect hop followed by a failed final request: the whole chain is kept
+	ts.add(RequestRecord{ID: "hop", ResponseStatus: 302, Success: true, RedirectHop: 1, RedirectNextID: "final"})
+	ts.add(RequestRecord{ID: "final", ResponseStatus: 500, Success: true, RedirectHop: 2, RedirectPrevID: "hop"})
+
+	assert.True(t, ts.update("final", func(record *RequestRecord) { record.Error = "updated" }))
+	assert.False(t, ts.update("missing", func(record *RequestRecord) {}))
+
+	ts.flush(time.Now())
+	assert.Empty(t, stored, "records wait for the delay")
+
+	ts.flush(time.Now().Add(2 * time.Hour))
+	require.Len(t, stored, 2)
+	assert.Equal(t, "hop", stored[0].ID)
+	assert.Equal(t, "final", stored[1].ID)
+	assert.Equal(t, "updated", stored[1].Error)
+	assert.Equal(t, int64(1), ts.droppedRecords())
+}
+
+func TestTailSamplerRate(t *testing.T) {
+	kept := 0
+	ts := startTailSampler(TailSampling{Rate: 1, Delay: time.Hour}, func(RequestRecord) { kept++ })
+	ts.add(RequestRecord{ID: "1", ResponseStatus: 200, Success: true})
+	ts.add(RequestRecord{ID: "2", ResponseStatus: 200, Success: true})
+	// Stopping decides on everything still buffered
+	ts.stop()
+	assert.Equal(t, 2, kept)
+}
+
+func TestProxyTailSampling(t *testing.T) {
+	p := New(&Config{TailSampling: &TailSampling{Rate: 0, Delay: 20 * time.Millisecond}})
+	defer p.tailSampler.stop()
+
+	p.recordRequest(RequestRecord{ID: "ok", URL: "http://api.example.com/", ResponseStatus: 200, Success: true})
+	p.recordRequest(RequestRecord{ID: "failed", URL: "http://api.example.com/", ResponseStatus: 404, Success: true})
+	assert.Empty(t, p.history.GetRecords(), "records are buffered first")
+
+	assert.True(t, p.updateRecord("failed", func(record *RequestRecord) { record.InboxDeliveryStatus = InboxDelivered }))
+
+	require.Eventually(t, func() bool { return len(p.history.GetRecords()) == 1 }, time.Second, 5*time.Millisecond)
+	record := p.history.GetRecords()[0]
+	assert.Equal(t, "failed", record.ID)
+	assert.Equal(t, InboxDelivered, record.InboxDeliveryStatus)
+
+	rec := httptest.NewRecorder()
+	p.handleCapture(rec, httptest.NewRequest(http.MethodGet, "/capture", nil))
+	assert.Contains(t, rec.Body.String(), `"sampled_out":1`)
+}
@@ -0,0 +1,317 @@
+package proxy
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// teeDrainTimeout is how long stopping waits for queued chunks to reach file
+// and command sinks
+const teeDrainTimeout = 5 * time.Second
+
+// teeQueueSize is how many chunks of a response wait for a slow sink; later
+// chunks are dropped rather than holding up the client
+const teeQueueSize = 256
+
+// Kinds of tee sink
+const (
+	TeeSinkFile = "file" // Appended to a file
+	TeeSinkExec = "exec" // Written to the stdin of a shell command
+	TeeSinkHTTP = "http" // POSTed to a URL, one request per response
+)
+
+// TeeRule streams the response bodies of a route to a sink while they are
+// served, e.g. to pipe an event stream into other tooling as it arrives
+type TeeRule struct {
+	Route  Route
+	Sink   string // file, exec, or http
+	Target string // File path, shell command, or URL
+}
+
+// ParseTeeRule parses a rule in "route=file:PATH", "route=exec:COMMAND", or
+// "route=URL" form, e.g. "api.example.com/events=exec:jq -c ." or
+// "*/stream=http://localhost:9000/ingest"
+func ParseTeeRule(spec string) (TeeRule, error) {
+	route, sink, ok := strings.Cut(spec, "=")
+	if !ok || sink == "" {
+		return TeeRule{}, fmt.Errorf("invalid tee rule %q: expected route=file:PATH, route=exec:COMMAND, or route=URL", spec)
+	}
+	rule := TeeRule{Route: ParseRoute(route)}
+	switch {
+	case strings.HasPrefix(sink, TeeSinkFile+":"):
+		rule.Sink, rule.Target = TeeSinkFile, strings.TrimPrefix(sink, TeeSinkFile+":")
+	case strings.HasPrefix(sink, TeeSinkExec+":"):
+		rule.Sink, rule.Target = TeeSinkExec, strings.TrimSpace(strings.TrimPrefix(sink, TeeSinkExec+":"))
+	case strings.HasPrefix(sink, "http://"), strings.HasPrefix(sink, "https://"):
+		if _, err := url.ParseRequestURI(sink); err != nil {
+			return TeeRule{}, fmt.Errorf("invalid tee rule %q: %v", spec, err)
+		}
+		rule.Sink, rule.Target = TeeSinkHTTP, sink
+	default:
+		return TeeRule{}, fmt.Errorf("invalid tee rule %q: sink must be file:PATH, exec:COMMAND, or an http(s) URL", spec)
+	}
+	if rule.Target == "" {
+		return TeeRule{}, fmt.Errorf("invalid tee rule %q: missing %s target", spec, rule.Sink)
+	}
+	return rule, nil
+}
+
+// String returns the rule in the form accepted by ParseTeeRule
+func (tr TeeRule) String() string {
+	if tr.Sink == TeeSinkHTTP {
+		return tr.Route.String() + "=" + tr.Target
+	}
+	return tr.Route.String() + "=" + tr.Sink + ":" + tr.Target
+}
+
+// teeSinks streams the response bodies of tee routes to their sinks
+type teeSinks struct {
+	rules   []TeeRule
+	writers []*teeWriter // File and command sinks by rule; nil for HTTP sinks
+	client  *http.Client
+	streams sync.WaitGroup // Responses being written to file and command sinks
+}
+
+func newTeeSinks(rules []TeeRule) *teeSinks {
+	ts := &teeSinks{rules: rules, writers: make([]*teeWriter, len(rules)), client: &http.Client{Transport: &http.Transport{}}}
+	for i, rule := range rules {
+		if rule.Sink != TeeSinkHTTP {
+			ts.writers[i] = &teeWriter{rule: rule}
+		}
+	}
+	return ts
+}
+
+// tee replaces the body of a response to a tee route with one that copies
+// what is read from it to the route's sink
+func (ts *teeSinks) tee(target *url.URL, resp *http.Response, requestID string) {
+	for i, rule := range ts.rules {
+		if !rule.Route.Matches(target) || resp.Body == nil {
+			continue
+		}
+		stream := &teeStream{rule: rule, url: target.String(), chunks: make(chan []byte, teeQueueSize)}
+		if writer := ts.writers[i]; writer != nil {
+			ts.streams.Add(1)
+			go func() {
+				defer ts.streams.Done()
+				stream.writeTo(writer)
+			}()
+		} else {
+			go stream.post(ts.client, resp.Header.Get("Content-Type"), requestID, resp.StatusCode)
+		}
+		resp.Body = &teeBody{ReadCloser: resp.Body, stream: stream}
+		return
+	}
+}
+
+// stop closes the files and commands of the sinks once what was queued for
+// them has been written
+func (ts *teeSinks) stop() {
+	drained := make(chan struct{})
+	go func() {
+		ts.streams.Wait()
+		close(drained)
+	}()
+	select {
+	case <-drained:
+	case <-time.After(teeDrainTimeout):
+		slog.Warn("Tee sinks did not drain in time, closing them", "timeout", teeDrainTimeout.String())
+	}
+	for _, writer := range ts.writers {
+		if writer != nil {
+			writer.stop()
+		}
+	}
+}
+
+// teeStream queues the chunks of one response body for its sink
+type teeStream struct {
+	rule    TeeRule
+	url     string
+	chunks  chan []byte
+	dropped int64
+	once    sync.Once
+}
+
+// send queues a copy of a chunk, dropping it when the sink is behind
+func (s *teeStream) send(chunk []byte) {
+	select {
+	case s.chunks <- append([]byte(nil), chunk...):
+	default:
+		s.dropped += int64(len(chunk))
+	}
+}
+
+// close ends the stream once the body has been read or closed
+func (s *teeStream) close() {
+	s.once.Do(func() {
+		close(s.chunks)
+		if s.dropped > 0 {
+			slog.Warn("Tee fell behind and dropped bytes", "target", s.rule.Target, "url", s.url, "dropped_bytes", s.dropped)
+		}
+	})
+}
+
+// writeTo writes the chunks to a file or command shared with other
+// responses
+func (s *teeStream) writeTo(writer *teeWriter) {
+	failed := false
+	for chunk := range s.chunks {
+		if err := writer.write(chunk); err != nil && !failed {
+			slog.Error("Error teeing", "url", s.url, "target", s.rule.Target, "error", err)
+			failed = true
+		}
+	}
+}
+
+// post sends the body to an HTTP sink as it arrives, with the request ID,
+// upstream URL, and status of the response in headers
+func (s *teeStream) post(client *http.Client, contentType, requestID string, status int) {
+	reader, writer := io.Pipe()
+	go func() {
+		for chunk := range s.chunks {
+			// Keep draining once the sink has gone away
+			_, _ = writer.Write(chunk)
+		}
+		_ = writer.Close()
+	}()
+
+	req, err := http.NewRequest(http.MethodPost, s.rule.Target, reader)
+	if err != nil {
+		slog.Error("Error teeing", "url", s.url, "target", s.rule.Target, "error", err)
+		_ = reader.CloseWithError(err)
+		return
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	req.Header.Set("X-Netkit-Request-Id", requestID)
+	req.Header.Set("X-Netkit-Url", s.url)
+	req.Header.Set("X-Netkit-Status", strconv.Itoa(status))
+
+	resp, err := client.Do(req)
+	if err != nil {
+		slog.Error("Error teeing", "url", s.url, "target", s.rule.Target, "error", err)
+		_ = reader.CloseWithError(err)
+		return
+	}
+	if _, err := io.Copy(io.Discard, resp.Body); err != nil {
+		slog.Warn("Error reading tee response", "target", s.rule.Target, "error", err)
+	}
+	if err := resp.Body.Close(); err != nil {
+		slog.Warn("Error closing tee response", "target", s.rule.Target, "error", err)
+	}
+	if resp.StatusCode >= 300 {
+		slog.Warn("Tee target returned an error", "target", s.rule.Target, "url", s.url, "status", resp.StatusCode)
+	}
+}
+
+// teeBody copies a response body to its tee stream as it is read
+type teeBody struct {
+	io.ReadCloser
+	stream *teeStream
+}
+
+func (b *teeBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if n > 0 {
+		b.stream.send(p[:n])
+	}
+	if err != nil {
+		b.stream.close()
+	}
+	return n, err
+}
+
+func (b *teeBody) Close() error {
+	b.stream.close()
+	return b.ReadCloser.Close()
+}
+
+// teeWriter is a file or command that every response teed to it is written
+// to. Chunks are written whole, so concurrent responses interleave at chunk
+// boundaries. It is opened on first use and again after a write fails, e.g.
+// when the command exited.
+type teeWriter struct {
+	rule    TeeRule
+	mutex   sync.Mutex
+	out     io.WriteCloser
+	cmd     *exec.Cmd
+	stopped bool
+}
+
+func (tw *teeWriter) write(chunk []byte) error {
+	tw.mutex.Lock()
+	defer tw.mutex.Unlock()
+
+	if tw.stopped {
+		return nil
+	}
+	if tw.out == nil {
+		if err := tw.open(); err != nil {
+			return err
+		}
+	}
+	if _, err := tw.out.Write(chunk); err != nil {
+		tw.close()
+		return err
+	}
+	return nil
+}
+
+// open opens the file for appending or starts the command
+func (tw *teeWriter) open() error {
+	if tw.rule.Sink == TeeSinkFile {
+		file, err := os.OpenFile(tw.rule.Target, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+		if err != nil {
+			return err
+		}
+		tw.out = file
+		return nil
+	}
+
+	cmd := exec.Command("sh", "-c", tw.rule.Target)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	tw.out, tw.cmd = stdin, cmd
+	return nil
+}
+
+// close closes the file, or the command's stdin and waits for it to exit
+func (tw *teeWriter) close() {
+	if tw.out == nil {
+		return
+	}
+	if err := tw.out.Close(); err != nil {
+		slog.Warn("Error closing tee sink", "target", tw.rule.Target, "error", err)
+	}
+	if tw.cmd != nil {
+		if err := tw.cmd.Wait(); err != nil {
+			slog.Error("Tee command exited", "command", tw.rule.Target, "error", err)
+		}
+	}
+	tw.out, tw.cmd = nil, nil
+}
+
+func (tw *teeWriter) stop() {
+	tw.mutex.Lock()
+	defer tw.mutex.Unlock()
+	tw.stopped = true
+	tw.close()
+}
@@ -0,0 +1,149 @@
+//go:build unit
+
+package proxy
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseTeeRule(t *testing.T) {
+	rule, err := ParseTeeRule("api.example.com/events=exec:jq -c .")
+	require.NoError(t, err)
+	assert.Equal(t, TeeRule{Route: Route{Host: "api.example.com", PathPrefix: "/events"}, Sink: TeeSinkExec, Target: "jq -c ."}, rule)
+	assert.Equal(t, "api.example.com/events=exec:jq -c .", rule.String())
+
+	rule, err = ParseTeeRule("*/stream=http://localhost:9000/ingest?source=netkit")
+	require.NoError(t, err)
+	assert.Equal(t, TeeSinkHTTP, rule.Sink)
+	assert.Equal(t, "http://localhost:9000/ingest?source=netkit", rule.Target)
+
+	rule, err = ParseTeeRule("/feed=file:/tmp/feed.log")
+	require.NoError(t, err)
+	assert.Equal(t, TeeRule{Route: Route{PathPrefix: "/feed"}, Sink: TeeSinkFile, Target: "/tmp/feed.log"}, rule)
+
+	for _, spec := range []string{"api.example.com", "api.example.com=", "api.example.com=file:", "api.example.com=exec: ", "api.example.com=ftp://host/x"} {
+		_, err := ParseTeeRule(spec)
+		assert.Error(t, err, spec)
+	}
+}
+
+func TestProxyTeeFile(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(r.URL.Path + "\n"))
+	}))
+	defer upstream.Close()
+
+	path := filepath.Join(t.TempDir(), "tee.log")
+	rule, err := ParseTeeRule("*/events=file:" + path)
+	require.NoError(t, err)
+	p := New(&Config{TeeRules: []TeeRule{rule}})
+
+	for _, target := range []string{"/events/1", "/other", "/events/2"} {
+		rec := httptest.NewRecorder()
+		p.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, upstream.URL+target, nil))
+		require.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, target+"\n", rec.Body.String(), "the client gets the body as usual")
+	}
+
+	assert.Eventually(t, func() bool {
+		data, _ := os.ReadFile(path)
+		return string(data) == "/events/1\n/events/2\n"
+	}, time.Second, 5*time.Millisecond)
+
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+	require.NoError(t, p.Stop())
+}
+
+func TestProxyTeeExec(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("event\n"))
+	}))
+	defer upstream.Close()
+
+	path := filepath.Join(t.TempDir(), "out.log")
+	rule, err := ParseTeeRule("*=exec:cat > " + path)
+	require.NoError(t, err)
+	p := New(&Config{TeeRules: []TeeRule{rule}})
+
+	for i := 0; i < 2; i++ {
+		rec := httptest.NewRecorder()
+		p.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, upstream.URL+"/", nil))
+		require.Equal(t, http.StatusOK, rec.Code)
+	}
+
+	// Stopping closes the command's stdin and waits for it to exit
+	require.NoError(t, p.Stop())
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, "event\nevent\n", string(data))
+}
+
+func TestProxyTeeHTTPStreams(t *testing.T) {
+	// The sink sees the first event before the upstream sends the second
+	firstEvent := make(chan string, 1)
+	sinkBody := make(chan string, 1)
+	var sinkHeader http.Header
+	sink := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sinkHeader = r.Header.Clone()
+		buf := make([]byte, 64)
+		n, _ := r.Body.Read(buf)
+		firstEvent <- string(buf[:n])
+		rest, _ := io.ReadAll(r.Body)
+		sinkBody <- string(buf[:n]) + string(rest)
+	}))
+	defer sink.Close()
+
+	release := make(chan struct{})
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		_, _ = w.Write([]byte("data: one\n\n"))
+		w.(http.Flusher).Flush()
+		<-release
+		_, _ = w.Write([]byte("data: two\n\n"))
+	}))
+	defer upstream.Close()
+
+	rule, err := ParseTeeRule("*/stream=" + sink.URL + "/ingest")
+	require.NoError(t, err)
+	p := New(&Config{TeeRules: []TeeRule{rule}})
+
+	rec := httptest.NewRecorder()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		p.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, upstream.URL+"/stream", nil))
+	}()
+
+	select {
+	case event := <-firstEvent:
+		assert.Equal(t, "data: one\n\n", event)
+	case <-time.After(2 * time.Second):
+		t.Fatal("sink did not receive the first event while the response was streaming")
+	}
+	close(release)
+	<-done
+
+	assert.Equal(t, "data: one\n\ndata: two\n\n", rec.Body.String())
+	select {
+	case body := <-sinkBody:
+		assert.Equal(t, "data: one\n\ndata: two\n\n", body)
+	case <-time.After(2 * time.Second):
+		t.Fatal("sink did not receive the whole body")
+	}
+	record := p.history.GetRecords()[0]
+	assert.Equal(t, "text/event-stream", sinkHeader.Get("Content-Type"))
+	assert.Equal(t, record.ID, sinkHeader.Get("X-Netkit-Request-Id"))
+	assert.Equal(t, upstream.URL+"/stream", sinkHeader.Get("X-Netkit-Url"))
+	assert.Equal(t, "200", sinkHeader.Get("X-Netkit-Status"))
+}
@@ -0,0 +1,290 @@
+package proxy
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultThrottleMaxWait is the longest a request waits for its client's
+// rate before it is rejected
+const defaultThrottleMaxWait = 30 * time.Second
+
+// throttleMaxClients bounds how many clients' rates are tracked; clients
+// whose allowance has refilled are forgotten first
+const throttleMaxClients = 10000
+
+// ThrottleRule slows down the clients whose request header matches a pattern,
+// e.g. a runaway script identified by its User-Agent, without blocking them.
+// Each distinct header value is a client with a rate of its own.
+type ThrottleRule struct {
+	Header  string        // Canonical header name, e.g. User-Agent
+	Pattern string        // Glob matched against the header value ignoring case, where * matches any run of characters
+	Rate    float64       // Requests per second each client may send; 0 leaves the rate alone
+	Burst   int           // Requests a client may send at once before the rate applies (default: 1)
+	Delay   time.Duration // Added to every matching request, so the client waits behind everyone else
+	MaxWait time.Duration // Longest a request waits for the rate before it is rejected with 429 (default: 30s)
+
+	spec    string
+	pattern *regexp.Regexp
+}
+
+// ParseThrottleRule parses a rule in "header=pattern:action[,action...]"
+// form, where actions are rate=N/s (or /m, /h), burst=N, delay=DURATION, and
+// max-wait=DURATION, e.g. "User-Agent=python-requests/*:rate=2/s,burst=5" or
+// "X-Client=batch-*:delay=500ms"
+func ParseThrottleRule(spec string) (ThrottleRule, error) {
+	header, rest, ok := strings.Cut(spec, "=")
+	index := strings.LastIndex(rest, ":")
+	if !ok || header == "" || index < 0 {
+		return ThrottleRule{}, fmt.Errorf("invalid throttle rule %q: expected header=pattern:action[,action...]", spec)
+	}
+	rule := ThrottleRule{
+		Header:  http.CanonicalHeaderKey(strings.TrimSpace(header)),
+		Pattern: rest[:index],
+		MaxWait: defaultThrottleMaxWait,
+		spec:    spec,
+	}
+	rule.pattern = globPattern(strings.ToLower(rule.Pattern))
+
+	for _, action := range strings.Split(rest[index+1:], ",") {
+		name, value, _ := strings.Cut(strings.TrimSpace(action), "=")
+		var err error
+		switch name {
+		case "rate":
+			rule.Rate, err = parseThrottleRate(value)
+		case "burst":
+			if rule.Burst, err = strconv.Atoi(value); err == nil && rule.Burst <= 0 {
+				err = fmt.Errorf("must be positive")
+			}
+		case "delay":
+			if rule.Delay, err = time.ParseDuration(value); err == nil && rule.Delay < 0 {
+				err = fmt.Errorf("cannot be negative")
+			}
+		case "max-wait":
+			if rule.MaxWait, err = time.ParseDuration(value); err == nil && rule.MaxWait < 0 {
+				err = fmt.Errorf("cannot be negative")
+			}
+		default:
+			return ThrottleRule{}, fmt.Errorf("invalid throttle rule %q: unknown action %q (expected rate, burst, delay, or max-wait)", spec, name)
+		}
+		if err != nil {
+			return ThrottleRule{}, fmt.Errorf("invalid throttle rule %q: invalid %s %q: %v", spec, name, value, err)
+		}
+	}
+	if rule.Rate == 0 && rule.Delay == 0 {
+		return ThrottleRule{}, fmt.Errorf("invalid throttle rule %q: needs a rate or a delay", spec)
+	}
+	if rule.Burst == 0 {
+		rule.Burst = 1
+	}
+	return rule, nil
+}
+
+// parseThrottleRate parses a rate such as 2/s, 30/m, or 100/h in requests
+// per second; a bare number is per second
+func parseThrottleRate(value string) (float64, error) {
+	count, unit, _ := strings.Cut(value, "/")
+	per := time.Second
+	switch unit {
+	case "", "s":
+	case "m":
+		per = time.Minute
+	case "h":
+		per = time.Hour
+	default:
+		return 0, fmt.Errorf("unit must be s, m, or h")
+	}
+	n, err := strconv.ParseFloat(count, 64)
+	if err != nil || n <= 0 || math.IsInf(n, 0) {
+		return 0, fmt.Errorf("expected a positive number of requests")
+	}
+	return n / per.Seconds(), nil
+}
+
+// String returns the rule as it was given
+func (tr ThrottleRule) String() string {
+	return tr.spec
+}
+
+// matches returns the value of the rule's header when it matches the pattern
+func (tr ThrottleRule) matches(header http.Header) (string, bool) {
+	value := header.Get(tr.Header)
+	if value == "" && tr.Pattern != "*" {
+		return "", false
+	}
+	return value, tr.pattern.MatchString(strings.ToLower(value))
+}
+
+// ThrottleDecision is how a throttle rule shaped a request
+type ThrottleDecision struct {
+	Rule     string `json:"rule"`
+	Client   string `json:"client"`   // Value of the rule's header
+	DelayUs  int64  `json:"delay_us"` // Time the request was held before it was sent upstream
+	Rejected bool   `json:"rejected,omitempty"`
+}
+
+// throttleBucket is a client's allowance of requests; it goes below zero as
+// requests reserve what refills while they wait
+type throttleBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// throttleStats counts the decisions of a rule for /metrics
+type throttleStats struct {
+	throttled int64
+	rejected  int64
+	delay     time.Duration
+}
+
+// throttler tracks the rate of every client matched by a throttle rule
+type throttler struct {
+	mutex   sync.Mutex
+	buckets map[string]*throttleBucket // By rule and client
+	stats   map[string]*throttleStats  // By rule
+}
+
+func newThrottler() *throttler {
+	return &throttler{buckets: make(map[string]*throttleBucket), stats: make(map[string]*throttleStats)}
+}
+
+// reserve returns how long a client's request must wait for its rate. When
+// that is longer than the rule allows, nothing is reserved and ok is false.
+func (t *throttler) reserve(rule ThrottleRule, client string, now time.Time) (wait time.Duration, ok bool) {
+	if rule.Rate <= 0 {
+		return 0, true
+	}
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	key := rule.spec + "\x00" + client
+	bucket := t.buckets[key]
+	if bucket == nil {
+		if len(t.buckets) >= throttleMaxClients {
+			t.prune(now)
+		}
+		bucket = &throttleBucket{tokens: float64(rule.Burst), last: now}
+		t.buckets[key] = bucket
+	}
+	bucket.tokens = min(bucket.tokens+now.Sub(bucket.last).Seconds()*rule.Rate, float64(rule.Burst))
+	bucket.last = now
+
+	if bucket.tokens < 1 {
+		wait = time.Duration((1 - bucket.tokens) / rule.Rate * float64(time.Second))
+		if wait > rule.MaxWait {
+			return wait, false
+		}
+	}
+	bucket.tokens--
+	return wait, true
+}
+
+// prune forgets clients that have been idle for a second or more, whose
+// allowance has most likely refilled
+func (t *throttler) prune(now time.Time) {
+	for key, bucket := range t.buckets {
+		if now.Sub(bucket.last) >= time.Second && bucket.tokens >= 0 {
+			delete(t.buckets, key)
+		}
+	}
+}
+
+// count adds a decision to its rule's statistics
+func (t *throttler) count(decision *ThrottleDecision) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	stats := t.stats[decision.Rule]
+	if stats == nil {
+		stats = &throttleStats{}
+		t.stats[decision.Rule] = stats
+	}
+	stats.throttled++
+	if decision.Rejected {
+		stats.rejected++
+	}
+	stats.delay += time.Duration(decision.DelayUs) * time.Microsecond
+}
+
+// writeThrottleMetrics appends the decisions of each rule in the Prometheus
+// text format
+func (t *throttler) writeThrottleMetrics(b *strings.Builder) {
+	t.mutex.Lock()
+	rules := make([]string, 0, len(t.stats))
+	stats := make(map[string]throttleStats, len(t.stats))
+	for rule, s := range t.stats {
+		rules = append(rules, rule)
+		stats[rule] = *s
+	}
+	t.mutex.Unlock()
+	sort.Strings(rules)
+
+	b.WriteString("\n# HELP netkit_throttled_requests_total Requests matched by a throttle rule\n")
+	b.WriteString("# TYPE netkit_throttled_requests_total counter\n")
+	for _, rule := range rules {
+		fmt.Fprintf(b, "netkit_throttled_requests_total{rule=%q} %d\n", rule, stats[rule].throttled)
+	}
+	b.WriteString("\n# HELP netkit_throttle_rejected_total Requests rejected for waiting longer than a throttle rule allows\n")
+	b.WriteString("# TYPE netkit_throttle_rejected_total counter\n")
+	for _, rule := range rules {
+		fmt.Fprintf(b, "netkit_throttle_rejected_total{rule=%q} %d\n", rule, stats[rule].rejected)
+	}
+	b.WriteString("\n# HELP netkit_throttle_delay_seconds_total Time requests were held by a throttle rule\n")
+	b.WriteString("# TYPE netkit_throttle_delay_seconds_total counter\n")
+	for _, rule := range rules {
+		fmt.Fprintf(b, "netkit_throttle_delay_seconds_total{rule=%q} %g\n", rule, stats[rule].delay.Seconds())
+	}
+}
+
+// throttle holds a request from a client matching the first throttle rule
+// that applies, for the rule's delay and until its rate allows it. It
+// returns false when the request was rejected for waiting too long or the
+// client went away, after recording it.
+func (p *Proxy) throttle(w http.ResponseWriter, r *http.Request, record *RequestRecord) bool {
+	for _, rule := range p.currentConfig().ThrottleRules {
+		client, ok := rule.matches(r.Header)
+		if !ok {
+			continue
+		}
+
+		decision := &ThrottleDecision{Rule: rule.String(), Client: client}
+		record.Throttle = decision
+		wait, ok := p.throttler.reserve(rule, client, time.Now())
+		if !ok {
+			decision.Rejected = true
+			p.throttler.count(decision)
+			record.Error = "Throttled"
+			record.ProxyEndTime = time.Now()
+			p.recordRequest(*record)
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			http.Error(w, "Too many requests from this client", http.StatusTooManyRequests)
+			return false
+		}
+
+		delay := rule.Delay + wait
+		decision.DelayUs = delay.Microseconds()
+		p.throttler.count(decision)
+		if delay <= 0 {
+			return true
+		}
+		timer := time.NewTimer(delay)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+			return true
+		case <-r.Context().Done():
+			record.Error = "Client went away while throttled"
+			record.ProxyEndTime = time.Now()
+			p.recordRequest(*record)
+			return false
+		}
+	}
+	return true
+}
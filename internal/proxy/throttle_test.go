@@ -0,0 +1,148 @@
+//go:build unit
+
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseThrottleRule(t *testing.T) {
+	rule, err := ParseThrottleRule("user-agent=python-requests/*:rate=2/s,burst=5,max-wait=1s")
+	require.NoError(t, err)
+	assert.Equal(t, "User-Agent", rule.Header)
+	assert.Equal(t, "python-requests/*", rule.Pattern)
+	assert.Equal(t, 2.0, rule.Rate)
+	assert.Equal(t, 5, rule.Burst)
+	assert.Equal(t, time.Second, rule.MaxWait)
+
+	rule, err = ParseThrottleRule("X-Client=batch-*:delay=500ms")
+	require.NoError(t, err)
+	assert.Equal(t, 500*time.Millisecond, rule.Delay)
+	assert.Equal(t, 1, rule.Burst)
+	assert.Equal(t, defaultThrottleMaxWait, rule.MaxWait)
+
+	rule, err = ParseThrottleRule("User-Agent=curl/*:rate=30/m")
+	require.NoError(t, err)
+	assert.InDelta(t, 0.5, rule.Rate, 1e-9)
+
+	for _, spec := range []string{
+		"User-Agent",
+		"User-Agent=curl",
+		"=curl:rate=1/s",
+		"User-Agent=curl:burst=2",
+		"User-Agent=curl:rate=0/s",
+		"User-Agent=curl:rate=1/d",
+		"User-Agent=curl:delay=-1s",
+		"User-Agent=curl:rate=1/s,burst=0",
+		"User-Agent=curl:priority=low",
+	} {
+		_, err := ParseThrottleRule(spec)
+		assert.Error(t, err, spec)
+	}
+}
+
+func TestThrottleRuleMatches(t *testing.T) {
+	rule, err := ParseThrottleRule("User-Agent=Python-Requests/*:rate=1/s")
+	require.NoError(t, err)
+
+	client, ok := rule.matches(http.Header{"User-Agent": {"python-requests/2.31"}})
+	assert.True(t, ok, "patterns ignore case")
+	assert.Equal(t, "python-requests/2.31", client)
+
+	_, ok = rule.matches(http.Header{"User-Agent": {"curl/8.0"}})
+	assert.False(t, ok)
+	_, ok = rule.matches(http.Header{})
+	assert.False(t, ok, "requests without the header do not match")
+}
+
+func TestThrottlerReserve(t *testing.T) {
+	rule, err := ParseThrottleRule("User-Agent=*:rate=2/s,burst=2,max-wait=1s")
+	require.NoError(t, err)
+	th := newThrottler()
+	now := time.Now()
+
+	// The burst goes through at once, then requests wait for the rate
+	for i := 0; i < 2; i++ {
+		wait, ok := th.reserve(rule, "a", now)
+		require.True(t, ok)
+		assert.Zero(t, wait)
+	}
+	wait, ok := th.reserve(rule, "a", now)
+	require.True(t, ok)
+	assert.Equal(t, 500*time.Millisecond, wait)
+	wait, ok = th.reserve(rule, "a", now)
+	require.True(t, ok)
+	assert.Equal(t, time.Second, wait)
+
+	// Waiting longer than max-wait is rejected without reserving anything
+	wait, ok = th.reserve(rule, "a", now)
+	assert.False(t, ok)
+	assert.Equal(t, 1500*time.Millisecond, wait)
+
+	// Each client has a rate of its own
+	wait, ok = th.reserve(rule, "b", now)
+	require.True(t, ok)
+	assert.Zero(t, wait)
+
+	// The allowance refills over time
+	wait, ok = th.reserve(rule, "a", now.Add(2*time.Second))
+	require.True(t, ok)
+	assert.Zero(t, wait)
+}
+
+func TestProxyThrottle(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer upstream.Close()
+
+	rule, err := ParseThrottleRule("User-Agent=batch/*:rate=1/h,max-wait=0s")
+	require.NoError(t, err)
+	delayRule, err := ParseThrottleRule("X-Client=slow:delay=20ms")
+	require.NoError(t, err)
+	p := New(&Config{ThrottleRules: []ThrottleRule{rule, delayRule}})
+
+	send := func(header, value string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, upstream.URL+"/", nil)
+		req.Header.Set(header, value)
+		rec := httptest.NewRecorder()
+		p.ServeHTTP(rec, req)
+		return rec
+	}
+
+	// The first request of the hour goes through; the next is over the rate
+	assert.Equal(t, http.StatusOK, send("User-Agent", "batch/1.0").Code)
+	rec := send("User-Agent", "batch/1.0")
+	assert.Equal(t, http.StatusTooManyRequests, rec.Code)
+	assert.Equal(t, "3600", rec.Header().Get("Retry-After"))
+
+	records := p.history.GetRecords()
+	require.Len(t, records, 2)
+	assert.Equal(t, "Throttled", records[0].Error)
+	assert.Equal(t, &ThrottleDecision{Rule: rule.String(), Client: "batch/1.0", Rejected: true}, records[0].Throttle)
+	assert.Equal(t, &ThrottleDecision{Rule: rule.String(), Client: "batch/1.0"}, records[1].Throttle)
+
+	// Delays hold the request before it is sent upstream
+	start := time.Now()
+	assert.Equal(t, http.StatusOK, send("X-Client", "slow").Code)
+	assert.GreaterOrEqual(t, time.Since(start), 20*time.Millisecond)
+	delayed := p.history.GetRecords()[0].Throttle
+	require.NotNil(t, delayed)
+	assert.Equal(t, int64(20000), delayed.DelayUs)
+
+	// Requests that match no rule are left alone
+	assert.Equal(t, http.StatusOK, send("User-Agent", "curl/8.0").Code)
+	assert.Nil(t, p.history.GetRecords()[0].Throttle)
+
+	rec = httptest.NewRecorder()
+	p.handleMetrics(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	assert.Contains(t, rec.Body.String(), `netkit_throttled_requests_total{rule="User-Agent=batch/*:rate=1/h,max-wait=0s"} 2`)
+	assert.Contains(t, rec.Body.String(), `netkit_throttle_rejected_total{rule="User-Agent=batch/*:rate=1/h,max-wait=0s"} 1`)
+	assert.Contains(t, rec.Body.String(), `netkit_throttle_delay_seconds_total{rule="X-Client=slow:delay=20ms"} 0.02`)
+}
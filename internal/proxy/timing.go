@@ -0,0 +1,41 @@
+package proxy
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// setTimingHeaders adds the proxy's latency breakdown to a proxied response so
+// browser devtools can show it. Upstream Server-Timing entries are kept.
+func (p *Proxy) setTimingHeaders(header http.Header, record *RequestRecord) {
+	if !p.currentConfig().ServerTiming && !p.currentConfig().TimingHeaders {
+		return
+	}
+
+	upstream := elapsed(record.UpstreamStartTime, record.UpstreamEndTime)
+	overhead := time.Since(record.ProxyStartTime) - upstream
+
+	if p.currentConfig().ServerTiming {
+		header.Add("Server-Timing", fmt.Sprintf(`upstream;dur=%s;desc="Upstream", proxy;dur=%s;desc="Proxy overhead"`,
+			formatMillis(upstream), formatMillis(overhead)))
+		if record.CacheStatus != "" {
+			header.Add("Server-Timing", fmt.Sprintf(`cache;desc=%q`, record.CacheStatus))
+		}
+	}
+
+	if p.currentConfig().TimingHeaders {
+		header.Set("X-Netkit-Request-Id", record.ID)
+		header.Set("X-Netkit-Upstream-Latency-Us", strconv.FormatInt(upstream.Microseconds(), 10))
+		header.Set("X-Netkit-Proxy-Overhead-Us", strconv.FormatInt(overhead.Microseconds(), 10))
+		if record.CacheStatus != "" {
+			header.Set("X-Netkit-Cache", record.CacheStatus)
+		}
+	}
+}
+
+// formatMillis formats a duration in milliseconds as Server-Timing expects
+func formatMillis(d time.Duration) string {
+	return strconv.FormatFloat(float64(d.Microseconds())/1000, 'f', 3, 64)
+}
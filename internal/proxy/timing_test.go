@@ -0,0 +1,77 @@
+//go:build unit
+
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newSlowUpstream(t *testing.T) *httptest.Server {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(5 * time.Millisecond)
+		w.Header().Set("Server-Timing", `db;dur=2`)
+		w.Header().Set("ETag", `"v1"`)
+		if _, err := w.Write([]byte("ok")); err != nil {
+			t.Logf("Error writing response: %v", err)
+		}
+	}))
+	t.Cleanup(upstream.Close)
+	return upstream
+}
+
+func TestServerTimingHeader(t *testing.T) {
+	upstream := newSlowUpstream(t)
+	p := New(&Config{ServerTiming: true})
+
+	rec := httptest.NewRecorder()
+	p.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, upstream.URL, nil))
+
+	values := rec.Header().Values("Server-Timing")
+	require.Len(t, values, 2)
+	assert.Equal(t, "db;dur=2", values[0], "upstream entries are kept")
+
+	match := regexp.MustCompile(`^upstream;dur=([0-9.]+);desc="Upstream", proxy;dur=([0-9.]+);desc="Proxy overhead"$`).FindStringSubmatch(values[1])
+	require.NotNil(t, match, values[1])
+	upstreamMs, err := strconv.ParseFloat(match[1], 64)
+	require.NoError(t, err)
+	assert.GreaterOrEqual(t, upstreamMs, 5.0)
+
+	assert.Empty(t, rec.Header().Get("X-Netkit-Upstream-Latency-Us"), "X-Netkit headers are opt-in")
+}
+
+func TestTimingHeaders(t *testing.T) {
+	upstream := newSlowUpstream(t)
+	p := New(&Config{TimingHeaders: true, ServerTiming: true, ConditionalGET: true})
+
+	rec := httptest.NewRecorder()
+	p.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, upstream.URL, nil))
+
+	record := p.history.GetRecords()[0]
+	assert.Equal(t, record.ID, rec.Header().Get("X-Netkit-Request-Id"))
+	latency, err := strconv.ParseInt(rec.Header().Get("X-Netkit-Upstream-Latency-Us"), 10, 64)
+	require.NoError(t, err)
+	assert.GreaterOrEqual(t, latency, int64(5000))
+	_, err = strconv.ParseInt(rec.Header().Get("X-Netkit-Proxy-Overhead-Us"), 10, 64)
+	assert.NoError(t, err)
+	assert.Equal(t, CacheStatusMiss, rec.Header().Get("X-Netkit-Cache"))
+	assert.Contains(t, rec.Header().Values("Server-Timing"), `cache;desc="miss"`)
+}
+
+func TestTimingHeadersDisabled(t *testing.T) {
+	upstream := newSlowUpstream(t)
+	p := New(&Config{})
+
+	rec := httptest.NewRecorder()
+	p.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, upstream.URL, nil))
+
+	assert.Equal(t, []string{"db;dur=2"}, rec.Header().Values("Server-Timing"))
+	assert.Empty(t, rec.Header().Get("X-Netkit-Request-Id"))
+}
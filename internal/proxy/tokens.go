@@ -0,0 +1,402 @@
+package proxy
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Admin API token scopes
+const (
+	ScopeRead  = "read"  // GET history, stats, reports, and metrics
+	ScopeWrite = "write" // Change history and saved filters
+	ScopeAdmin = "admin" // Manage tokens; implies read and write
+)
+
+// DefaultWorkspace is the workspace of a proxy started without --workspace
+const DefaultWorkspace = "default"
+
+// tokenPrefix marks netkit admin tokens so they are easy to spot in leaks
+const tokenPrefix = "nk_"
+
+// APIToken is an admin API token. Only a hash of the secret is kept.
+type APIToken struct {
+	ID         string     `json:"id"`
+	Name       string     `json:"name"`
+	Workspace  string     `json:"workspace"` // Workspace the token is valid in, or * for all
+	Scopes     []string   `json:"scopes"`
+	CreatedAt  time.Time  `json:"created_at"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"` // Nil for tokens that never expire
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	Hash       string     `json:"hash,omitempty"` // SHA-256 of the secret, never returned by the API
+}
+
+// hasScope reports whether the token grants scope
+func (t *APIToken) hasScope(scope string) bool {
+	for _, s := range t.Scopes {
+		if s == scope || s == ScopeAdmin {
+			return true
+		}
+	}
+	return false
+}
+
+// validAt reports whether the token can be used at time now in workspace
+func (t *APIToken) validAt(now time.Time, workspace string) bool {
+	if t.RevokedAt != nil || (t.ExpiresAt != nil && !now.Before(*t.ExpiresAt)) {
+		return false
+	}
+	return t.Workspace == "*" || t.Workspace == workspace
+}
+
+// public returns a copy of the token that is safe to return from the API
+func (t APIToken) public() APIToken {
+	t.Hash = ""
+	return t
+}
+
+// tokenStore keeps admin API tokens, persisting them to a file when one is configured
+type tokenStore struct {
+	mutex  sync.Mutex
+	path   string
+	tokens map[string]*APIToken // By ID
+	locked bool                 // The tokens file could not be loaded, so the API stays closed
+}
+
+// newTokenStore loads tokens from path, which may not exist yet
+func newTokenStore(path string) (*tokenStore, error) {
+	store := &tokenStore{path: path, tokens: make(map[string]*APIToken)}
+	if path == "" {
+		return store, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return store, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read tokens file: %v", err)
+	}
+
+	var tokens []*APIToken
+	if err := json.Unmarshal(data, &tokens); err != nil {
+		return nil, fmt.Errorf("failed to parse tokens file: %v", err)
+	}
+	for _, token := range tokens {
+		store.tokens[token.ID] = token
+	}
+	return store, nil
+}
+
+// hashToken returns the stored form of a token secret
+func hashToken(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// Create issues a token and returns its secret, which is not stored
+func (s *tokenStore) Create(name, workspace string, scopes []string, ttl time.Duration) (APIToken, string, error) {
+	if name == "" {
+		return APIToken{}, "", fmt.Errorf("name is required")
+	}
+	if len(scopes) == 0 {
+		return APIToken{}, "", fmt.Errorf("at least one scope is required")
+	}
+	for _, scope := range scopes {
+		if scope != ScopeRead && scope != ScopeWrite && scope != ScopeAdmin {
+			return APIToken{}, "", fmt.Errorf("unknown scope %q (expected read, write, or admin)", scope)
+		}
+	}
+	if ttl < 0 {
+		return APIToken{}, "", fmt.Errorf("expiry must not be negative")
+	}
+	if workspace == "" {
+		workspace = DefaultWorkspace
+	}
+
+	raw := make([]byte, 32)
+	id := make([]byte, 6)
+	if _, err := rand.Read(raw); err != nil {
+		return APIToken{}, "", fmt.Errorf("failed to generate token: %v", err)
+	}
+	if _, err := rand.Read(id); err != nil {
+		return APIToken{}, "", fmt.Errorf("failed to generate token: %v", err)
+	}
+	secret := tokenPrefix + base64.RawURLEncoding.EncodeToString(raw)
+
+	now := time.Now().UTC()
+	token := &APIToken{
+		ID:        hex.EncodeToString(id),
+		Name:      name,
+		Workspace: workspace,
+		Scopes:    scopes,
+		CreatedAt: now,
+		Hash:      hashToken(secret),
+	}
+	if ttl > 0 {
+		expires := now.Add(ttl)
+		token.ExpiresAt = &expires
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.tokens[token.ID] = token
+	if err := s.persist(); err != nil {
+		delete(s.tokens, token.ID)
+		return APIToken{}, "", err
+	}
+	return token.public(), secret, nil
+}
+
+// List returns every token, including expired and revoked ones, newest first
+func (s *tokenStore) List() []APIToken {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	tokens := make([]APIToken, 0, len(s.tokens))
+	for _, token := range s.tokens {
+		tokens = append(tokens, token.public())
+	}
+	sort.Slice(tokens, func(i, j int) bool { return tokens[i].CreatedAt.After(tokens[j].CreatedAt) })
+	return tokens
+}
+
+// Revoke marks a token revoked, reporting whether it existed
+func (s *tokenStore) Revoke(id string) (bool, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	token, ok := s.tokens[id]
+	if !ok {
+		return false, nil
+	}
+	if token.RevokedAt != nil {
+		return true, nil
+	}
+	now := time.Now().UTC()
+	token.RevokedAt = &now
+	if err := s.persist(); err != nil {
+		token.RevokedAt = nil
+		return false, err
+	}
+	return true, nil
+}
+
+// Authenticate returns the token matching secret if it is usable in workspace
+func (s *tokenStore) Authenticate(secret, workspace string) (*APIToken, bool) {
+	hash := hashToken(secret)
+	now := time.Now().UTC()
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for _, token := range s.tokens {
+		if subtle.ConstantTimeCompare([]byte(token.Hash), []byte(hash)) == 1 {
+			if !token.validAt(now, workspace) {
+				return nil, false
+			}
+			// Usage is tracked in memory only, so reads do not rewrite the file
+			token.LastUsedAt = &now
+			copied := *token
+			return &copied, true
+		}
+	}
+	return nil, false
+}
+
+// persist writes the tokens to the tokens file; the caller holds the lock
+func (s *tokenStore) persist() error {
+	if s.path == "" {
+		return nil
+	}
+
+	tokens := make([]*APIToken, 0, len(s.tokens))
+	for _, token := range s.tokens {
+		tokens = append(tokens, token)
+	}
+	sort.Slice(tokens, func(i, j int) bool { return tokens[i].CreatedAt.Before(tokens[j].CreatedAt) })
+
+	data, err := json.MarshalIndent(tokens, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return fmt.Errorf("failed to write tokens file: %v", err)
+	}
+	if err := os.Rename(tmp, s.path); err != nil {
+		return fmt.Errorf("failed to write tokens file: %v", err)
+	}
+	return nil
+}
+
+// adminAuthEnabled reports whether admin API requests need a token
+func (p *Proxy) adminAuthEnabled() bool {
+	if p.currentConfig().AdminToken != "" {
+		return true
+	}
+	p.tokens.mutex.Lock()
+	defer p.tokens.mutex.Unlock()
+	return p.tokens.locked || len(p.tokens.tokens) > 0
+}
+
+// requiredScope returns the scope an admin request needs
+func requiredScope(r *http.Request) string {
+	// Profiles expose memory contents and the command line, and rule bundles
+	// change how traffic is proxied
+	if r.URL.Path == "/tokens" || r.URL.Path == "/config/reload" || strings.HasPrefix(r.URL.Path, "/debug/pprof/") ||
+		(r.URL.Path == "/rules" && r.Method == http.MethodDelete) || r.URL.Path == "/rules/import" {
+		return ScopeAdmin
+	}
+	// Assertions are POSTed but only read history
+	if r.Method == http.MethodGet || r.Method == http.MethodHead || r.URL.Path == "/requests/assert" {
+		return ScopeRead
+	}
+	return ScopeWrite
+}
+
+// requireAdminAuth checks bearer tokens on admin requests once a static admin
+// token is configured or any token has been issued. Health and readiness
+// checks and CORS preflights are always allowed.
+func (p *Proxy) requireAdminAuth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/healthz" || r.URL.Path == "/readyz" || r.Method == http.MethodOptions || !p.adminAuthEnabled() {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		secret, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		// Browsers cannot set headers on WebSocket connections
+		if !ok && r.URL.Path == "/ws" {
+			secret = r.URL.Query().Get("access_token")
+			ok = secret != ""
+		}
+		if !ok || secret == "" {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="netkit"`)
+			http.Error(w, "Missing bearer token", http.StatusUnauthorized)
+			return
+		}
+
+		// The static admin token has every scope
+		if p.currentConfig().AdminToken != "" && subtle.ConstantTimeCompare([]byte(secret), []byte(p.currentConfig().AdminToken)) == 1 {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		token, ok := p.tokens.Authenticate(secret, p.workspace())
+		if !ok {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="netkit", error="invalid_token"`)
+			http.Error(w, "Invalid, expired, or revoked token", http.StatusUnauthorized)
+			return
+		}
+		scope := requiredScope(r)
+		if p.currentConfig().ClearProtection == ClearProtectionAdmin && isPurgeRequest(r) {
+			scope = ScopeAdmin
+		}
+		if !token.hasScope(scope) {
+			http.Error(w, fmt.Sprintf("Token lacks the %s scope", scope), http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// workspace returns the workspace this proxy serves
+func (p *Proxy) workspace() string {
+	if p.currentConfig().Workspace == "" {
+		return DefaultWorkspace
+	}
+	return p.currentConfig().Workspace
+}
+
+// handleTokens lists, creates, and revokes admin API tokens
+func (p *Proxy) handleTokens(w http.ResponseWriter, r *http.Request) {
+	// Add CORS headers
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "GET, POST, DELETE, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, Cache-Control, Pragma, Expires")
+
+	// Handle preflight requests
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	var response interface{}
+	status := http.StatusOK
+	switch r.Method {
+	case http.MethodGet:
+		response = map[string]interface{}{"tokens": p.tokens.List()}
+
+	case http.MethodPost:
+		var request struct {
+			Name      string   `json:"name"`
+			Workspace string   `json:"workspace"`
+			Scopes    []string `json:"scopes"`
+			ExpiresIn string   `json:"expires_in"` // Go duration such as 720h; empty never expires
+		}
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			http.Error(w, "Invalid token JSON", http.StatusBadRequest)
+			return
+		}
+		var ttl time.Duration
+		if request.ExpiresIn != "" {
+			var err error
+			if ttl, err = time.ParseDuration(request.ExpiresIn); err != nil || ttl <= 0 {
+				http.Error(w, "Invalid expires_in duration", http.StatusBadRequest)
+				return
+			}
+		}
+		if request.Workspace == "" {
+			request.Workspace = p.workspace()
+		}
+		token, secret, err := p.tokens.Create(request.Name, request.Workspace, request.Scopes, ttl)
+		if err != nil {
+			http.Error(w, "Invalid token: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		// The secret is only ever shown here
+		response = map[string]interface{}{"token": secret, "info": token}
+		status = http.StatusCreated
+
+	case http.MethodDelete:
+		revoked, err := p.tokens.Revoke(r.URL.Query().Get("id"))
+		if err != nil {
+			http.Error(w, "Failed to revoke token", http.StatusInternalServerError)
+			return
+		}
+		if !revoked {
+			http.Error(w, "Token not found", http.StatusNotFound)
+			return
+		}
+		response = map[string]interface{}{"success": true, "message": "Token revoked"}
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	data, err := json.Marshal(response)
+	if err != nil {
+		http.Error(w, "Failed to encode tokens", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if _, err := w.Write(data); err != nil {
+		slog.Warn("Error writing tokens response", "error", err)
+	}
+}
@@ -0,0 +1,180 @@
+//go:build unit
+
+package proxy
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// adminRequest sends a request through the admin API's auth middleware
+func adminRequest(p *Proxy, method, target, token, body string) *httptest.ResponseRecorder {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", p.handleHealth)
+	mux.HandleFunc("/requests", p.handleRequestHistory)
+	mux.HandleFunc("/requests/clear", p.handleClearHistory)
+	mux.HandleFunc("/tokens", p.handleTokens)
+
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	rec := httptest.NewRecorder()
+	p.requireAdminAuth(mux).ServeHTTP(rec, req)
+	return rec
+}
+
+func createToken(t *testing.T, p *Proxy, auth, body string) (string, APIToken) {
+	t.Helper()
+	rec := adminRequest(p, http.MethodPost, "/tokens", auth, body)
+	require.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+
+	var created struct {
+		Token string   `json:"token"`
+		Info  APIToken `json:"info"`
+	}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &created))
+	require.True(t, strings.HasPrefix(created.Token, tokenPrefix))
+	assert.Empty(t, created.Info.Hash)
+	return created.Token, created.Info
+}
+
+func TestAdminAPIOpenWithoutTokens(t *testing.T) {
+	p := New(&Config{})
+	assert.Equal(t, http.StatusOK, adminRequest(p, http.MethodGet, "/requests", "", "").Code)
+}
+
+func TestAdminTokenScopes(t *testing.T) {
+	p := New(&Config{AdminToken: "root-secret"})
+
+	assert.Equal(t, http.StatusOK, adminRequest(p, http.MethodGet, "/healthz", "", "").Code, "health checks stay open")
+	assert.Equal(t, http.StatusOK, adminRequest(p, http.MethodOptions, "/requests", "", "").Code, "preflights stay open")
+	rec := adminRequest(p, http.MethodGet, "/requests", "", "")
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	assert.NotEmpty(t, rec.Header().Get("WWW-Authenticate"))
+	assert.Equal(t, http.StatusUnauthorized, adminRequest(p, http.MethodGet, "/requests", "wrong", "").Code)
+	assert.Equal(t, http.StatusOK, adminRequest(p, http.MethodGet, "/requests", "root-secret", "").Code)
+
+	reader, _ := createToken(t, p, "root-secret", `{"name": "ci", "scopes": ["read"], "expires_in": "1h"}`)
+	assert.Equal(t, http.StatusOK, adminRequest(p, http.MethodGet, "/requests", reader, "").Code)
+	assert.Equal(t, http.StatusForbidden, adminRequest(p, http.MethodPost, "/requests/clear", reader, "").Code)
+	assert.Equal(t, http.StatusForbidden, adminRequest(p, http.MethodGet, "/tokens", reader, "").Code)
+
+	writer, _ := createToken(t, p, "root-secret", `{"name": "cleanup", "scopes": ["read", "write"]}`)
+	assert.Equal(t, http.StatusOK, adminRequest(p, http.MethodPost, "/requests/clear", writer, "").Code)
+
+	admin, _ := createToken(t, p, "root-secret", `{"name": "ops", "scopes": ["admin"]}`)
+	rec = adminRequest(p, http.MethodGet, "/tokens", admin, "")
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.NotContains(t, rec.Body.String(), `"hash"`)
+	var list struct {
+		Tokens []APIToken `json:"tokens"`
+	}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &list))
+	assert.Len(t, list.Tokens, 3)
+}
+
+func TestIssuedTokenEnablesAuth(t *testing.T) {
+	p := New(&Config{})
+	token, info := createToken(t, p, "", `{"name": "first", "scopes": ["admin"]}`)
+	assert.Equal(t, DefaultWorkspace, info.Workspace)
+
+	assert.Equal(t, http.StatusUnauthorized, adminRequest(p, http.MethodGet, "/requests", "", "").Code)
+	assert.Equal(t, http.StatusOK, adminRequest(p, http.MethodGet, "/requests", token, "").Code)
+
+	// Revoked tokens stop working immediately
+	assert.Equal(t, http.StatusOK, adminRequest(p, http.MethodDelete, "/tokens?id="+info.ID, token, "").Code)
+	assert.Equal(t, http.StatusUnauthorized, adminRequest(p, http.MethodGet, "/requests", token, "").Code)
+}
+
+func TestTokenExpiryAndWorkspace(t *testing.T) {
+	p := New(&Config{AdminToken: "root-secret", Workspace: "staging"})
+
+	staging, _ := createToken(t, p, "root-secret", `{"name": "staging", "scopes": ["read"]}`)
+	prod, _ := createToken(t, p, "root-secret", `{"name": "prod", "workspace": "prod", "scopes": ["read"]}`)
+	all, _ := createToken(t, p, "root-secret", `{"name": "all", "workspace": "*", "scopes": ["read"]}`)
+	expiring, info := createToken(t, p, "root-secret", `{"name": "short", "scopes": ["read"], "expires_in": "1h"}`)
+
+	assert.Equal(t, http.StatusOK, adminRequest(p, http.MethodGet, "/requests", staging, "").Code)
+	assert.Equal(t, http.StatusUnauthorized, adminRequest(p, http.MethodGet, "/requests", prod, "").Code)
+	assert.Equal(t, http.StatusOK, adminRequest(p, http.MethodGet, "/requests", all, "").Code)
+	assert.Equal(t, http.StatusOK, adminRequest(p, http.MethodGet, "/requests", expiring, "").Code)
+
+	past := time.Now().Add(-time.Minute)
+	p.tokens.tokens[info.ID].ExpiresAt = &past
+	assert.Equal(t, http.StatusUnauthorized, adminRequest(p, http.MethodGet, "/requests", expiring, "").Code)
+}
+
+func TestCreateTokenValidation(t *testing.T) {
+	p := New(&Config{AdminToken: "root-secret"})
+	for _, body := range []string{
+		`{"scopes": ["read"]}`,
+		`{"name": "x"}`,
+		`{"name": "x", "scopes": ["delete"]}`,
+		`{"name": "x", "scopes": ["read"], "expires_in": "soon"}`,
+		`{"name": "x", "scopes": ["read"], "expires_in": "-1h"}`,
+		`not json`,
+	} {
+		assert.Equal(t, http.StatusBadRequest, adminRequest(p, http.MethodPost, "/tokens", "root-secret", body).Code, body)
+	}
+	assert.Equal(t, http.StatusNotFound, adminRequest(p, http.MethodDelete, "/tokens?id=missing", "root-secret", "").Code)
+}
+
+func TestTokenStorePersistence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tokens.json")
+	store, err := newTokenStore(path)
+	require.NoError(t, err)
+
+	_, secret, err := store.Create("ci", DefaultWorkspace, []string{ScopeRead}, 0)
+	require.NoError(t, err)
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.NotContains(t, string(data), secret, "secrets are stored hashed")
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+	reloaded, err := newTokenStore(path)
+	require.NoError(t, err)
+	token, ok := reloaded.Authenticate(secret, DefaultWorkspace)
+	require.True(t, ok)
+	assert.Equal(t, "ci", token.Name)
+}
+
+func TestDamagedTokensFileKeepsAPIClosed(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tokens.json")
+	require.NoError(t, os.WriteFile(path, []byte("{broken"), 0600))
+
+	p := New(&Config{TokensFile: path})
+	assert.Equal(t, http.StatusUnauthorized, adminRequest(p, http.MethodGet, "/requests", "", "").Code)
+}
+
+func TestPprofNeedsAdminScope(t *testing.T) {
+	get := func(p *Proxy, target, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, target, nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rec := httptest.NewRecorder()
+		p.adminServer.Handler.ServeHTTP(rec, req)
+		return rec
+	}
+	assert.Equal(t, http.StatusNotFound, get(New(&Config{}), "/debug/pprof/", "").Code, "profiling is opt-in")
+
+	p := New(&Config{AdminToken: "root-secret", Pprof: true})
+	reader, _ := createToken(t, p, "root-secret", `{"name": "ci", "scopes": ["read"]}`)
+	assert.Equal(t, http.StatusForbidden, get(p, "/debug/pprof/heap", reader).Code)
+	rec := get(p, "/debug/pprof/goroutine?debug=1", "root-secret")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), "goroutine profile")
+}
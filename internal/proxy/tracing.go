@@ -0,0 +1,380 @@
+package proxy
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultTracingServiceName is the service.name spans are exported under
+const DefaultTracingServiceName = "netkit"
+
+// Span export: spans are queued, dropped when the queue is full, and POSTed
+// to the collector in batches at least once per flush interval
+const (
+	tracingQueueSize     = 10000
+	tracingBatchSize     = 512
+	tracingFlushInterval = 5 * time.Second
+	tracingExportTimeout = 10 * time.Second
+)
+
+// OTLP span kinds and status codes
+const (
+	spanKindServer  = 2
+	spanKindClient  = 3
+	spanStatusError = 2
+)
+
+// W3C trace context headers
+const (
+	traceparentHeader = "Traceparent"
+	tracestateHeader  = "Tracestate"
+)
+
+// requestTrace is the W3C trace context of a proxied request: the span the
+// proxy serves it under and the child span of its upstream call
+type requestTrace struct {
+	traceID    string
+	parentID   string // The client's span, "" when the request started the trace
+	serverID   string
+	upstreamID string
+	state      string
+	sampled    bool // Whether the spans are exported, as the client decided
+	forwarded  bool // The proxy has no span of its own and passes the client's context on as is
+}
+
+// beginTrace reads and records the W3C trace context of a request. A proxy
+// exporting spans continues the trace with spans of its own, and one that
+// generates traces starts one for requests without a valid traceparent; any
+// other client context is forwarded as is. Returns nil for requests outside
+// any trace.
+func (p *Proxy) beginTrace(r *http.Request, record *RequestRecord) *requestTrace {
+	traceID, parentID, sampled, ok := parseTraceparent(r.Header.Get(traceparentHeader))
+	var rt *requestTrace
+	switch {
+	case p.tracer != nil:
+		rt = &requestTrace{serverID: newTraceID(8), upstreamID: newTraceID(8), sampled: true}
+	case ok:
+		rt = &requestTrace{forwarded: true}
+	case p.currentConfig().TraceGenerate:
+		// Without spans to export, upstreams see the recorded span as their parent
+		spanID := newTraceID(8)
+		rt = &requestTrace{serverID: spanID, upstreamID: spanID, sampled: true}
+	default:
+		return nil
+	}
+	if ok {
+		rt.traceID, rt.parentID, rt.sampled = traceID, parentID, sampled
+		rt.state = strings.Join(r.Header.Values(tracestateHeader), ",")
+	} else {
+		rt.traceID = newTraceID(16)
+	}
+
+	record.TraceID, record.SpanID, record.ParentSpanID, record.TraceState = rt.traceID, rt.serverID, rt.parentID, rt.state
+	return rt
+}
+
+// inject has the upstream request continue the trace from the upstream span
+func (rt *requestTrace) inject(header http.Header) {
+	if rt == nil || rt.forwarded {
+		return
+	}
+	// The vendor state of a trace the client did not validly start does not carry over
+	if rt.parentID == "" {
+		header.Del(tracestateHeader)
+	}
+	flags := "00"
+	if rt.sampled {
+		flags = "01"
+	}
+	header.Set(traceparentHeader, "00-"+rt.traceID+"-"+rt.upstreamID+"-"+flags)
+}
+
+// parseTraceparent reads a W3C traceparent header, returning the trace ID,
+// the caller's span ID, and whether the caller sampled the trace
+func parseTraceparent(value string) (traceID, parentID string, sampled, ok bool) {
+	// Later versions may append fields, but must keep the version 00 layout
+	if len(value) < 55 || (len(value) > 55 && (value[:2] == "00" || value[55] != '-')) {
+		return "", "", false, false
+	}
+	version, traceID, parentID, flags := value[:2], value[3:35], value[36:52], value[53:55]
+	if value[2] != '-' || value[35] != '-' || value[52] != '-' || version == "ff" {
+		return "", "", false, false
+	}
+	for _, field := range []string{version, traceID, parentID, flags} {
+		if !isLowerHex(field) {
+			return "", "", false, false
+		}
+	}
+	if strings.Trim(traceID, "0") == "" || strings.Trim(parentID, "0") == "" {
+		return "", "", false, false
+	}
+	flagBits, _ := strconv.ParseUint(flags, 16, 8)
+	return traceID, parentID, flagBits&1 == 1, true
+}
+
+// isLowerHex reports whether s is made of lowercase hex digits
+func isLowerHex(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if !(s[i] >= '0' && s[i] <= '9' || s[i] >= 'a' && s[i] <= 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+// newTraceID returns size random bytes as hex, the form of trace and span IDs
+func newTraceID(size int) string {
+	id := make([]byte, size)
+	_, _ = rand.Read(id)
+	return hex.EncodeToString(id)
+}
+
+// otlpSpanStatus is an OTLP span status; spans without one are unset
+type otlpSpanStatus struct {
+	Code    int    `json:"code"`
+	Message string `json:"message,omitempty"`
+}
+
+// otlpSpan is a span in OTLP/JSON, where trace and span IDs are hex
+type otlpSpan struct {
+	TraceID           string          `json:"traceId"`
+	SpanID            string          `json:"spanId"`
+	ParentSpanID      string          `json:"parentSpanId,omitempty"`
+	Name              string          `json:"name"`
+	Kind              int             `json:"kind"`
+	StartTimeUnixNano string          `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string          `json:"endTimeUnixNano"`
+	Attributes        []otlpAttribute `json:"attributes"`
+	Status            *otlpSpanStatus `json:"status,omitempty"`
+}
+
+// spanStatus returns an error status with message when failed, or none
+func spanStatus(failed bool, message string) *otlpSpanStatus {
+	if !failed {
+		return nil
+	}
+	return &otlpSpanStatus{Code: spanStatusError, Message: message}
+}
+
+// serverAddress returns the host and port a URL is sent to, with the port
+// implied by the scheme when it has none
+func serverAddress(rawURL string) (string, int64) {
+	parsed, err := url.Parse(rawURL)
+	if err != nil || parsed.Host == "" {
+		return "", 0
+	}
+	port, err := strconv.ParseInt(parsed.Port(), 10, 64)
+	if err != nil {
+		port = 80
+		if parsed.Scheme == "https" || parsed.Scheme == "wss" {
+			port = 443
+		}
+	}
+	return parsed.Hostname(), port
+}
+
+// tracer exports a span for each proxied request, with a child span for its
+// upstream call, to an OpenTelemetry collector as OTLP/HTTP JSON
+type tracer struct {
+	client      *http.Client
+	url         string
+	headers     map[string]string // Added to every export, e.g. for the collector's authentication
+	serviceName string
+	spans       chan otlpSpan
+	exported    atomic.Int64
+	dropped     atomic.Int64  // Spans the queue had no room for or the collector did not accept
+	done        chan struct{} // Closed by stop; spans are never closed, so late sends are safe
+	stopped     chan struct{}
+	once        sync.Once
+}
+
+// newTracer starts a tracer exporting to a collector URL, or returns nil when
+// endpoint is empty. A URL without a path is sent to /v1/traces.
+func newTracer(endpoint string, headers map[string]string, serviceName string) *tracer {
+	if endpoint == "" {
+		return nil
+	}
+	if serviceName == "" {
+		serviceName = DefaultTracingServiceName
+	}
+	t := &tracer{
+		client:      &http.Client{Timeout: tracingExportTimeout},
+		url:         collectorURL(endpoint, "/v1/traces"),
+		headers:     headers,
+		serviceName: serviceName,
+		spans:       make(chan otlpSpan, tracingQueueSize),
+		done:        make(chan struct{}),
+		stopped:     make(chan struct{}),
+	}
+	go t.run()
+	return t
+}
+
+// end queues the spans of a finished request: the server span from when the
+// proxy received it until now, and the upstream span when it was sent on
+func (t *tracer) end(rt *requestTrace, record *RequestRecord) {
+	if t == nil || rt == nil || !rt.sampled {
+		return
+	}
+	host, port := serverAddress(record.URL)
+	attributes := func(extra ...otlpAttribute) []otlpAttribute {
+		attrs := []otlpAttribute{
+			otlpString("netkit.record_id", record.ID),
+			otlpString("http.request.method", record.Method),
+			otlpString("server.address", host),
+			otlpInt("server.port", port),
+		}
+		if record.ResponseStatus > 0 {
+			attrs = append(attrs, otlpInt("http.response.status_code", int64(record.ResponseStatus)))
+		}
+		return append(attrs, extra...)
+	}
+
+	server := otlpSpan{
+		TraceID:           rt.traceID,
+		SpanID:            rt.serverID,
+		ParentSpanID:      rt.parentID,
+		Name:              record.Method,
+		Kind:              spanKindServer,
+		StartTimeUnixNano: strconv.FormatInt(record.ProxyStartTime.UnixNano(), 10),
+		EndTimeUnixNano:   strconv.FormatInt(time.Now().UnixNano(), 10),
+		Attributes:        attributes(otlpString("client.address", record.ClientAddr)),
+		Status:            spanStatus(record.Error != "" || record.ResponseStatus >= 500, record.Error),
+	}
+	t.queue(server)
+
+	if record.UpstreamStartTime.IsZero() {
+		return
+	}
+	extra := []otlpAttribute{otlpString("url.full", record.URL)}
+	if record.UpstreamAddr != "" {
+		extra = append(extra, otlpString("network.peer.address", record.UpstreamAddr))
+	}
+	upstreamError := ""
+	if record.ResponseStatus == 0 {
+		upstreamError = record.Error
+	}
+	t.queue(otlpSpan{
+		TraceID:           rt.traceID,
+		SpanID:            rt.upstreamID,
+		ParentSpanID:      rt.serverID,
+		Name:              record.Method,
+		Kind:              spanKindClient,
+		StartTimeUnixNano: strconv.FormatInt(record.UpstreamStartTime.UnixNano(), 10),
+		EndTimeUnixNano:   strconv.FormatInt(record.UpstreamEndTime.UnixNano(), 10),
+		Attributes:        attributes(extra...),
+		Status:            spanStatus(upstreamError != "" || record.ResponseStatus >= 400, upstreamError),
+	})
+}
+
+// queue adds a span to the next export, or drops it when the queue is full
+func (t *tracer) queue(span otlpSpan) {
+	select {
+	case t.spans <- span:
+	default:
+		t.dropped.Add(1)
+	}
+}
+
+// run exports queued spans in batches until the tracer is stopped
+func (t *tracer) run() {
+	defer close(t.stopped)
+	ticker := time.NewTicker(tracingFlushInterval)
+	defer ticker.Stop()
+
+	var batch []otlpSpan
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		if err := t.export(batch); err != nil {
+			slog.Error("Error exporting spans", "spans", len(batch), "error", err)
+			t.dropped.Add(int64(len(batch)))
+		} else {
+			t.exported.Add(int64(len(batch)))
+		}
+		batch = nil
+	}
+
+	for {
+		select {
+		case span := <-t.spans:
+			if batch = append(batch, span); len(batch) >= tracingBatchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		case <-t.done:
+			for {
+				select {
+				case span := <-t.spans:
+					if batch = append(batch, span); len(batch) >= tracingBatchSize {
+						flush()
+					}
+				default:
+					flush()
+					return
+				}
+			}
+		}
+	}
+}
+
+// export POSTs a batch of spans to the collector
+func (t *tracer) export(spans []otlpSpan) error {
+	body, err := json.Marshal(map[string]interface{}{
+		"resourceSpans": []interface{}{map[string]interface{}{
+			"resource": map[string]interface{}{
+				"attributes": []otlpAttribute{otlpString("service.name", t.serviceName)},
+			},
+			"scopeSpans": []interface{}{map[string]interface{}{
+				"scope": map[string]string{"name": "netkit"},
+				"spans": spans,
+			}},
+		}},
+	})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, t.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for key, value := range t.headers {
+		req.Header.Set(key, value)
+	}
+	return doSinkRequest(t.client, req)
+}
+
+// stop exports the queued spans
+func (t *tracer) stop() {
+	if t == nil {
+		return
+	}
+	t.once.Do(func() {
+		close(t.done)
+		<-t.stopped
+	})
+}
+
+// writeTracingMetrics writes the span export counters
+func (t *tracer) writeTracingMetrics(b *strings.Builder) {
+	b.WriteString("\n# HELP netkit_tracing_spans_exported_total Spans the OTLP collector accepted\n")
+	b.WriteString("# TYPE netkit_tracing_spans_exported_total counter\n")
+	fmt.Fprintf(b, "netkit_tracing_spans_exported_total %d\n", t.exported.Load())
+	b.WriteString("\n# HELP netkit_tracing_spans_dropped_total Spans dropped because the export queue was full or the collector failed\n")
+	b.WriteString("# TYPE netkit_tracing_spans_dropped_total counter\n")
+	fmt.Fprintf(b, "netkit_tracing_spans_dropped_total %d\n", t.dropped.Load())
+}
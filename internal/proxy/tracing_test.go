@@ -0,0 +1,227 @@
+//go:build unit
+
+package proxy
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// spanCollector is an OTLP/HTTP collector that keeps the spans it receives
+type spanCollector struct {
+	mu      sync.Mutex
+	spans   []otlpSpan
+	headers http.Header
+	service string
+}
+
+func newSpanCollector(t *testing.T) (*spanCollector, *httptest.Server) {
+	t.Helper()
+	c := &spanCollector{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload struct {
+			ResourceSpans []struct {
+				Resource struct {
+					Attributes []otlpAttribute `json:"attributes"`
+				} `json:"resource"`
+				ScopeSpans []struct {
+					Spans []otlpSpan `json:"spans"`
+				} `json:"scopeSpans"`
+			} `json:"resourceSpans"`
+		}
+		if r.URL.Path != "/v1/traces" || json.NewDecoder(r.Body).Decode(&payload) != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		c.headers = r.Header
+		for _, resource := range payload.ResourceSpans {
+			c.service = *resource.Resource.Attributes[0].Value.StringValue
+			for _, scope := range resource.ScopeSpans {
+				c.spans = append(c.spans, scope.Spans...)
+			}
+		}
+	}))
+	t.Cleanup(server.Close)
+	return c, server
+}
+
+func spanAttribute(span otlpSpan, key string) string {
+	for _, attribute := range span.Attributes {
+		if attribute.Key != key {
+			continue
+		}
+		if attribute.Value.StringValue != nil {
+			return *attribute.Value.StringValue
+		}
+		return *attribute.Value.IntValue
+	}
+	return ""
+}
+
+func TestTracingExportsSpans(t *testing.T) {
+	collector, collectorServer := newSpanCollector(t)
+	var upstreamTraceparent string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstreamTraceparent = r.Header.Get("Traceparent")
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer upstream.Close()
+
+	p := New(&Config{TracingEndpoint: collectorServer.URL, TracingHeaders: map[string]string{"Authorization": "Bearer token"}, TracingServiceName: "edge-proxy"})
+	req := httptest.NewRequest(http.MethodGet, upstream.URL+"/users", nil)
+	req.Header.Set("Traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	p.ServeHTTP(httptest.NewRecorder(), req)
+	p.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "http://127.0.0.1:1/down", nil))
+	require.NoError(t, p.Stop())
+
+	collector.mu.Lock()
+	defer collector.mu.Unlock()
+	require.Len(t, collector.spans, 4)
+	assert.Equal(t, "edge-proxy", collector.service)
+	assert.Equal(t, "Bearer token", collector.headers.Get("Authorization"))
+
+	server, client := collector.spans[0], collector.spans[1]
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", server.TraceID)
+	assert.Equal(t, "00f067aa0ba902b7", server.ParentSpanID)
+	assert.Equal(t, spanKindServer, server.Kind)
+	assert.Equal(t, "GET", server.Name)
+	assert.Nil(t, server.Status, "a 404 is not a server error")
+	assert.Equal(t, "404", spanAttribute(server, "http.response.status_code"))
+	assert.Equal(t, "127.0.0.1", spanAttribute(server, "server.address"))
+	records := p.history.GetRecords()
+	require.Len(t, records, 2)
+	assert.Equal(t, records[1].ID, spanAttribute(server, "netkit.record_id"))
+	assert.Equal(t, server.TraceID, records[1].TraceID)
+	assert.Equal(t, server.SpanID, records[1].SpanID)
+	assert.Equal(t, "00f067aa0ba902b7", records[1].ParentSpanID)
+
+	assert.Equal(t, server.TraceID, client.TraceID)
+	assert.Equal(t, server.SpanID, client.ParentSpanID)
+	assert.Equal(t, spanKindClient, client.Kind)
+	assert.Equal(t, upstream.URL+"/users", spanAttribute(client, "url.full"))
+	require.NotNil(t, client.Status)
+	assert.Equal(t, spanStatusError, client.Status.Code)
+	assert.Equal(t, "00-"+client.TraceID+"-"+client.SpanID+"-01", upstreamTraceparent)
+
+	failedServer, failedClient := collector.spans[2], collector.spans[3]
+	assert.Empty(t, failedServer.ParentSpanID, "the request started a new trace")
+	assert.Len(t, failedServer.TraceID, 32)
+	require.NotNil(t, failedServer.Status)
+	assert.Equal(t, "Failed to proxy request", failedServer.Status.Message)
+	assert.Equal(t, "Failed to proxy request", failedClient.Status.Message)
+
+	rec := httptest.NewRecorder()
+	p.handleMetrics(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	assert.Contains(t, rec.Body.String(), "netkit_tracing_spans_exported_total 4\n")
+	assert.Contains(t, rec.Body.String(), "netkit_tracing_spans_dropped_total 0\n")
+}
+
+func TestTracingUnsampled(t *testing.T) {
+	collector, collectorServer := newSpanCollector(t)
+	var upstreamTraceparent string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstreamTraceparent = r.Header.Get("Traceparent")
+	}))
+	defer upstream.Close()
+
+	p := New(&Config{TracingEndpoint: collectorServer.URL})
+	req := httptest.NewRequest(http.MethodGet, upstream.URL, nil)
+	req.Header.Set("Traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00")
+	p.ServeHTTP(httptest.NewRecorder(), req)
+	require.NoError(t, p.Stop())
+
+	assert.Empty(t, collector.spans, "the client did not sample the trace")
+	assert.True(t, strings.HasPrefix(upstreamTraceparent, "00-4bf92f3577b34da6a3ce929d0e0e4736-"))
+	assert.True(t, strings.HasSuffix(upstreamTraceparent, "-00"))
+	assert.NotContains(t, upstreamTraceparent, "00f067aa0ba902b7", "the upstream's parent is the proxy's span")
+}
+
+func TestTraceparentForwarded(t *testing.T) {
+	var upstreamTraceparent string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstreamTraceparent = r.Header.Get("Traceparent")
+	}))
+	defer upstream.Close()
+
+	p := New(&Config{TraceGenerate: true})
+	req := httptest.NewRequest(http.MethodGet, upstream.URL, nil)
+	req.Header.Set("Traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	req.Header.Add("Tracestate", "vendor=a")
+	req.Header.Add("Tracestate", "other=b")
+	p.ServeHTTP(httptest.NewRecorder(), req)
+	assert.Equal(t, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", upstreamTraceparent)
+	assert.Nil(t, p.tracer)
+
+	record := p.history.GetRecords()[0]
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", record.TraceID)
+	assert.Empty(t, record.SpanID, "the proxy took no part in the trace")
+	assert.Equal(t, "00f067aa0ba902b7", record.ParentSpanID)
+	assert.Equal(t, "vendor=a,other=b", record.TraceState)
+}
+
+func TestTraceGenerate(t *testing.T) {
+	var upstreamTraceparent, upstreamTracestate string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstreamTraceparent, upstreamTracestate = r.Header.Get("Traceparent"), r.Header.Get("Tracestate")
+	}))
+	defer upstream.Close()
+
+	// A malformed traceparent is replaced, and the state that came with it dropped
+	p := New(&Config{TraceGenerate: true})
+	req := httptest.NewRequest(http.MethodGet, upstream.URL, nil)
+	req.Header.Set("Traceparent", "not-a-trace")
+	req.Header.Set("Tracestate", "vendor=a")
+	p.ServeHTTP(httptest.NewRecorder(), req)
+	record := p.history.GetRecords()[0]
+	require.Len(t, record.TraceID, 32)
+	require.Len(t, record.SpanID, 16)
+	assert.Empty(t, record.ParentSpanID)
+	assert.Equal(t, "00-"+record.TraceID+"-"+record.SpanID+"-01", upstreamTraceparent)
+	assert.Empty(t, upstreamTracestate)
+
+	rec := httptest.NewRecorder()
+	p.handleRequestHistory(rec, httptest.NewRequest(http.MethodGet, "/requests?trace_id="+record.TraceID, nil))
+	records, _ := decodeHistory(t, rec)
+	require.Len(t, records, 1)
+	assert.Equal(t, record.SpanID, records[0].SpanID)
+
+	// Without generation, requests outside a trace are left alone
+	p = New(&Config{})
+	p.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, upstream.URL, nil))
+	assert.Empty(t, upstreamTraceparent)
+	assert.Empty(t, p.history.GetRecords()[0].TraceID)
+}
+
+func TestParseTraceparent(t *testing.T) {
+	traceID, parentID, sampled, ok := parseTraceparent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	require.True(t, ok)
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", traceID)
+	assert.Equal(t, "00f067aa0ba902b7", parentID)
+	assert.True(t, sampled)
+
+	_, _, sampled, ok = parseTraceparent("01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-02-future")
+	assert.True(t, ok, "later versions may add fields")
+	assert.False(t, sampled)
+
+	for _, value := range []string{
+		"",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra",
+		"00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01",
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01",
+		"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"00_4bf92f3577b34da6a3ce929d0e0e4736_00f067aa0ba902b7_01",
+	} {
+		_, _, _, ok := parseTraceparent(value)
+		assert.False(t, ok, value)
+	}
+}
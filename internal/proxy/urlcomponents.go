@@ -0,0 +1,62 @@
+package proxy
+
+import (
+	"net/url"
+	"strings"
+)
+
+// Filter parameter prefixes for URL components
+const (
+	paramFilterPrefix   = "param."   // param.user_id=42 matches a query parameter value
+	segmentFilterPrefix = "segment." // segment.0=users matches a path segment, counted from 0
+)
+
+// URLComponents is a request URL split into its parts, so history can be
+// filtered on them without parsing URLs or writing regexes
+type URLComponents struct {
+	Scheme   string              `json:"scheme,omitempty"`
+	Host     string              `json:"host,omitempty"` // Lowercased, without the port
+	Port     string              `json:"port,omitempty"`
+	Path     string              `json:"path"`
+	Segments []string            `json:"segments,omitempty"` // Unescaped path segments
+	Query    map[string][]string `json:"query,omitempty"`    // Every value of every query parameter, in order
+}
+
+// ParseURLComponents splits a URL into its components, returning nil for a URL
+// that cannot be parsed
+func ParseURLComponents(rawURL string) *URLComponents {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil
+	}
+
+	components := &URLComponents{
+		Scheme: u.Scheme,
+		Host:   strings.ToLower(u.Hostname()),
+		Port:   u.Port(),
+		Path:   u.Path,
+	}
+	for _, segment := range strings.Split(u.Path, "/") {
+		if segment != "" {
+			components.Segments = append(components.Segments, segment)
+		}
+	}
+	if query, err := url.ParseQuery(u.RawQuery); err == nil && len(query) > 0 {
+		components.Query = query
+	}
+	return components
+}
+
+// recordURLComponents returns the components stored on the record, parsing its
+// URL for records captured without them
+func recordURLComponents(record RequestRecord) *URLComponents {
+	if record.URLComponents != nil {
+		return record.URLComponents
+	}
+	return ParseURLComponents(record.URL)
+}
+
+// isFilterParam reports whether a query parameter is a history filter
+func isFilterParam(key string) bool {
+	return filterParams[key] || strings.HasPrefix(key, paramFilterPrefix) || strings.HasPrefix(key, segmentFilterPrefix)
+}
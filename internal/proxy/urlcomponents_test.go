@@ -0,0 +1,87 @@
+//go:build unit
+
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseURLComponents(t *testing.T) {
+	components := ParseURLComponents("https://API.example.com:8443/v1/users/a%2Fb?user_id=42&tag=a&tag=b&empty=")
+	require.NotNil(t, components)
+	assert.Equal(t, "https", components.Scheme)
+	assert.Equal(t, "api.example.com", components.Host)
+	assert.Equal(t, "8443", components.Port)
+	assert.Equal(t, "/v1/users/a/b", components.Path)
+	assert.Equal(t, []string{"v1", "users", "a", "b"}, components.Segments)
+	assert.Equal(t, []string{"a", "b"}, components.Query["tag"])
+	assert.Equal(t, []string{""}, components.Query["empty"])
+
+	components = ParseURLComponents("http://example.com/")
+	assert.Empty(t, components.Segments)
+	assert.Nil(t, components.Query)
+
+	assert.Nil(t, ParseURLComponents("http://bad host/%zz"))
+}
+
+func TestRequestFilterURLComponents(t *testing.T) {
+	records := []RequestRecord{
+		{ID: "user-42", URL: "http://api.example.com/users/42?user_id=42&tag=a&tag=b"},
+		{ID: "user-7", URL: "http://api.example.com/users/7?user_id=7"},
+		{ID: "order", URL: "http://api.example.com:8080/orders/1?tag=b"},
+	}
+	// Components stored on the record take precedence over the URL
+	records[2].URLComponents = ParseURLComponents(records[2].URL)
+
+	match := func(query string) []string {
+		values, err := url.ParseQuery(query)
+		require.NoError(t, err)
+		filter, err := ParseRequestFilter(values, true)
+		require.NoError(t, err, query)
+		var ids []string
+		for _, record := range records {
+			if filter.Matches(record) {
+				ids = append(ids, record.ID)
+			}
+		}
+		return ids
+	}
+
+	assert.Equal(t, []string{"user-42"}, match("param.user_id=42"))
+	assert.Equal(t, []string{"user-42", "user-7"}, match("param.user_id=42&param.user_id=7"))
+	assert.Equal(t, []string{"user-42", "order"}, match("param.tag=b"), "any value of a repeated parameter matches")
+	assert.Equal(t, []string{"user-42", "user-7"}, match("param.user_id="), "an empty value matches presence")
+	assert.Equal(t, []string{"user-42"}, match("param.tag=a&segment.1=42"))
+	assert.Equal(t, []string{"order"}, match("segment.0=orders"))
+	assert.Empty(t, match("segment.5=users"))
+	assert.Equal(t, []string{"order"}, match("host=api.example.com:8080"))
+
+	for _, query := range []string{"param.=1", "segment.x=users", "segment.-1=users"} {
+		values, err := url.ParseQuery(query)
+		require.NoError(t, err)
+		_, err = ParseRequestFilter(values, true)
+		assert.Error(t, err, query)
+	}
+}
+
+func TestRequestHistoryURLComponents(t *testing.T) {
+	p := New(&Config{})
+	p.recordRequest(RequestRecord{ID: "1", URL: "http://api.example.com/users/42?user_id=42"})
+	p.recordRequest(RequestRecord{ID: "2", URL: "http://api.example.com/users/7?user_id=7"})
+
+	rec := httptest.NewRecorder()
+	p.handleRequestHistory(rec, httptest.NewRequest(http.MethodGet, "/requests?param.user_id=42", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	records, _ := decodeHistory(t, rec)
+	require.Len(t, records, 1)
+	assert.Equal(t, "1", records[0].ID)
+	require.NotNil(t, records[0].URLComponents)
+	assert.Equal(t, []string{"users", "42"}, records[0].URLComponents.Segments)
+}
@@ -0,0 +1,163 @@
+package proxy
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Supported webhook signature schemes
+const (
+	WebhookStripe = "stripe"
+	WebhookGitHub = "github"
+	WebhookSlack  = "slack"
+)
+
+// WebhookVerifier checks the signature of inbound webhooks on a route
+type WebhookVerifier struct {
+	Route    Route
+	Provider string // WebhookStripe, WebhookGitHub, or WebhookSlack
+	secret   string
+}
+
+// ParseWebhookVerifier parses a verifier in "route=provider:secret" form, e.g.
+// "/hooks/stripe=stripe:whsec_123". A secret of "env:NAME" is read from the
+// environment variable NAME so it stays out of shell history.
+func ParseWebhookVerifier(spec string) (WebhookVerifier, error) {
+	route, rest, ok := strings.Cut(spec, "=")
+	if !ok {
+		return WebhookVerifier{}, fmt.Errorf("invalid webhook verifier %q: expected route=provider:secret", spec)
+	}
+	provider, secret, ok := strings.Cut(rest, ":")
+	if !ok || secret == "" {
+		return WebhookVerifier{}, fmt.Errorf("invalid webhook verifier %q: expected route=provider:secret", spec)
+	}
+
+	provider = strings.ToLower(provider)
+	switch provider {
+	case WebhookStripe, WebhookGitHub, WebhookSlack:
+	default:
+		return WebhookVerifier{}, fmt.Errorf("invalid webhook verifier %q: provider must be stripe, github, or slack", spec)
+	}
+
+	if name, fromEnv := strings.CutPrefix(secret, "env:"); fromEnv {
+		if secret = os.Getenv(name); secret == "" {
+			return WebhookVerifier{}, fmt.Errorf("invalid webhook verifier %q: environment variable %s is not set", spec, name)
+		}
+	}
+	return WebhookVerifier{Route: ParseRoute(route), Provider: provider, secret: secret}, nil
+}
+
+// verifyWebhook marks the record with the signature check of the first verifier matching the target
+func (p *Proxy) verifyWebhook(record *RequestRecord, header http.Header, body []byte, target *url.URL) {
+	for _, v := range p.currentConfig().WebhookVerifiers {
+		if !v.Route.Matches(target) {
+			continue
+		}
+
+		err := v.verify(header, body, time.Now(), p.currentConfig().WebhookTolerance)
+		valid := err == nil
+		record.WebhookProvider = v.Provider
+		record.SignatureValid = &valid
+		if err != nil {
+			record.SignatureError = err.Error()
+		}
+		return
+	}
+}
+
+// verify checks the provider's signature header against the body. A zero
+// tolerance disables the timestamp check, which helps when replaying old webhooks.
+func (v WebhookVerifier) verify(header http.Header, body []byte, now time.Time, tolerance time.Duration) error {
+	switch v.Provider {
+	case WebhookGitHub:
+		if signature := header.Get("X-Hub-Signature-256"); signature != "" {
+			return checkHexSignature(sha256.New, v.secret, body, strings.TrimPrefix(signature, "sha256="))
+		}
+		if signature := header.Get("X-Hub-Signature"); signature != "" {
+			return checkHexSignature(sha1.New, v.secret, body, strings.TrimPrefix(signature, "sha1="))
+		}
+		return fmt.Errorf("missing X-Hub-Signature-256 header")
+
+	case WebhookStripe:
+		signature := header.Get("Stripe-Signature")
+		if signature == "" {
+			return fmt.Errorf("missing Stripe-Signature header")
+		}
+		var timestamp string
+		var candidates []string
+		for _, part := range strings.Split(signature, ",") {
+			key, value, _ := strings.Cut(strings.TrimSpace(part), "=")
+			switch key {
+			case "t":
+				timestamp = value
+			case "v1":
+				candidates = append(candidates, value)
+			}
+		}
+		if timestamp == "" || len(candidates) == 0 {
+			return fmt.Errorf("malformed Stripe-Signature header")
+		}
+		if err := checkTimestamp(timestamp, now, tolerance); err != nil {
+			return err
+		}
+		payload := append([]byte(timestamp+"."), body...)
+		// Stripe sends one v1 signature per active secret during rotation
+		for _, candidate := range candidates {
+			if checkHexSignature(sha256.New, v.secret, payload, candidate) == nil {
+				return nil
+			}
+		}
+		return fmt.Errorf("signature mismatch")
+
+	case WebhookSlack:
+		signature := header.Get("X-Slack-Signature")
+		timestamp := header.Get("X-Slack-Request-Timestamp")
+		if signature == "" || timestamp == "" {
+			return fmt.Errorf("missing X-Slack-Signature or X-Slack-Request-Timestamp header")
+		}
+		if err := checkTimestamp(timestamp, now, tolerance); err != nil {
+			return err
+		}
+		payload := append([]byte("v0:"+timestamp+":"), body...)
+		return checkHexSignature(sha256.New, v.secret, payload, strings.TrimPrefix(signature, "v0="))
+	}
+	return fmt.Errorf("unsupported webhook provider %q", v.Provider)
+}
+
+func checkHexSignature(newHash func() hash.Hash, secret string, payload []byte, signature string) error {
+	got, err := hex.DecodeString(signature)
+	if err != nil {
+		return fmt.Errorf("signature is not hex encoded")
+	}
+	mac := hmac.New(newHash, []byte(secret))
+	mac.Write(payload)
+	if !hmac.Equal(got, mac.Sum(nil)) {
+		return fmt.Errorf("signature mismatch")
+	}
+	return nil
+}
+
+func checkTimestamp(timestamp string, now time.Time, tolerance time.Duration) error {
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid signature timestamp %q", timestamp)
+	}
+	if tolerance <= 0 {
+		return nil
+	}
+	age := now.Sub(time.Unix(seconds, 0))
+	if age > tolerance || age < -tolerance {
+		return fmt.Errorf("signature timestamp outside tolerance (%s old)", age.Round(time.Second))
+	}
+	return nil
+}
@@ -0,0 +1,134 @@
+//go:build unit
+
+package proxy
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func hmacHex(secret, payload string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(payload))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func TestParseWebhookVerifier(t *testing.T) {
+	v, err := ParseWebhookVerifier("/hooks/stripe=Stripe:whsec_abc")
+	require.NoError(t, err)
+	assert.Equal(t, Route{PathPrefix: "/hooks/stripe"}, v.Route)
+	assert.Equal(t, WebhookStripe, v.Provider)
+	assert.Equal(t, "whsec_abc", v.secret)
+
+	t.Setenv("NETKIT_TEST_SECRET", "from-env")
+	v, err = ParseWebhookVerifier("api.local/gh=github:env:NETKIT_TEST_SECRET")
+	require.NoError(t, err)
+	assert.Equal(t, "from-env", v.secret)
+
+	for _, spec := range []string{
+		"/hooks",
+		"/hooks=stripe",
+		"/hooks=stripe:",
+		"/hooks=twilio:secret",
+		"/hooks=github:env:NETKIT_TEST_UNSET",
+	} {
+		_, err := ParseWebhookVerifier(spec)
+		assert.Error(t, err, spec)
+	}
+}
+
+func TestWebhookVerifyGitHub(t *testing.T) {
+	v := WebhookVerifier{Provider: WebhookGitHub, secret: "gh-secret"}
+	body := []byte(`{"action":"opened"}`)
+
+	header := http.Header{}
+	header.Set("X-Hub-Signature-256", "sha256="+hmacHex("gh-secret", string(body)))
+	assert.NoError(t, v.verify(header, body, time.Now(), 0))
+
+	header.Set("X-Hub-Signature-256", "sha256="+hmacHex("other", string(body)))
+	assert.EqualError(t, v.verify(header, body, time.Now(), 0), "signature mismatch")
+
+	assert.Error(t, v.verify(http.Header{}, body, time.Now(), 0))
+}
+
+func TestWebhookVerifyStripe(t *testing.T) {
+	v := WebhookVerifier{Provider: WebhookStripe, secret: "whsec_test"}
+	body := []byte(`{"type":"charge.succeeded"}`)
+	now := time.Unix(1700000000, 0)
+	timestamp := strconv.FormatInt(now.Unix(), 10)
+	valid := hmacHex("whsec_test", timestamp+"."+string(body))
+
+	header := http.Header{}
+	header.Set("Stripe-Signature", "t="+timestamp+",v1="+hmacHex("rotated", "x")+",v1="+valid)
+	assert.NoError(t, v.verify(header, body, now, 5*time.Minute))
+
+	// Replays are rejected by default but can be checked with the tolerance disabled
+	later := now.Add(time.Hour)
+	err := v.verify(header, body, later, 5*time.Minute)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "outside tolerance")
+	assert.NoError(t, v.verify(header, body, later, 0))
+
+	header.Set("Stripe-Signature", "v1="+valid)
+	assert.EqualError(t, v.verify(header, body, now, 0), "malformed Stripe-Signature header")
+}
+
+func TestWebhookVerifySlack(t *testing.T) {
+	v := WebhookVerifier{Provider: WebhookSlack, secret: "slack-secret"}
+	body := []byte("token=abc&command=%2Fdeploy")
+	now := time.Unix(1700000000, 0)
+	timestamp := strconv.FormatInt(now.Unix(), 10)
+
+	header := http.Header{}
+	header.Set("X-Slack-Request-Timestamp", timestamp)
+	header.Set("X-Slack-Signature", "v0="+hmacHex("slack-secret", "v0:"+timestamp+":"+string(body)))
+	assert.NoError(t, v.verify(header, body, now, 5*time.Minute))
+
+	assert.Error(t, v.verify(header, []byte("tampered"), now, 5*time.Minute))
+}
+
+func TestWebhookRecordMarked(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer upstream.Close()
+
+	v, err := ParseWebhookVerifier("/hooks/github=github:gh-secret")
+	require.NoError(t, err)
+	p := New(&Config{WebhookVerifiers: []WebhookVerifier{v}})
+
+	body := `{"zen":"Keep it logically awesome."}`
+	send := func(path, signature string) RequestRecord {
+		req := httptest.NewRequest(http.MethodPost, upstream.URL+path, strings.NewReader(body))
+		req.Header.Set("X-Hub-Signature-256", signature)
+		rec := httptest.NewRecorder()
+		p.ServeHTTP(rec, req)
+		assert.Equal(t, http.StatusNoContent, rec.Code, "webhooks are forwarded regardless of the outcome")
+		return p.history.GetRecords()[0]
+	}
+
+	record := send("/hooks/github", "sha256="+hmacHex("gh-secret", body))
+	assert.Equal(t, WebhookGitHub, record.WebhookProvider)
+	require.NotNil(t, record.SignatureValid)
+	assert.True(t, *record.SignatureValid)
+	assert.Empty(t, record.SignatureError)
+
+	record = send("/hooks/github", "sha256=00")
+	require.NotNil(t, record.SignatureValid)
+	assert.False(t, *record.SignatureValid)
+	assert.Equal(t, "signature mismatch", record.SignatureError)
+
+	record = send("/other", "sha256=00")
+	assert.Nil(t, record.SignatureValid)
+	assert.Empty(t, record.WebhookProvider)
+}
@@ -0,0 +1,272 @@
+package proxy
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// websocketGUID is appended to the client's key to compute the accept key
+// (RFC 6455, section 1.3)
+const websocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// websocketMaxMessage bounds the messages read from clients
+const websocketMaxMessage = 1 << 20
+
+// websocketWriteTimeout bounds writing one message, so a client that stops
+// reading does not hold up the server
+const websocketWriteTimeout = 10 * time.Second
+
+// WebSocket opcodes
+const (
+	websocketContinuation = 0x0
+	websocketText         = 0x1
+	websocketBinary       = 0x2
+	websocketClose        = 0x8
+	websocketPing         = 0x9
+	websocketPong         = 0xa
+)
+
+// WebSocket close codes
+const (
+	websocketNormalClosure   = 1000
+	websocketGoingAway       = 1001
+	websocketProtocolError   = 1002
+	websocketUnsupportedData = 1003
+	websocketPolicyViolation = 1008
+	websocketMessageTooBig   = 1009
+)
+
+// websocketConn is the server side of a WebSocket connection. Messages are
+// read from one goroutine; writes may come from any.
+type websocketConn struct {
+	conn   net.Conn
+	reader *bufio.Reader
+
+	writeMutex sync.Mutex
+	closed     bool // A close frame has been sent
+}
+
+// upgradeWebSocket completes the opening handshake of a WebSocket request,
+// or answers it with an error and returns nil
+func upgradeWebSocket(w http.ResponseWriter, r *http.Request) *websocketConn {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return nil
+	}
+	if !headerHasToken(r.Header, "Connection", "upgrade") || !headerHasToken(r.Header, "Upgrade", "websocket") {
+		http.Error(w, "Expected a WebSocket upgrade", http.StatusBadRequest)
+		return nil
+	}
+	if r.Header.Get("Sec-WebSocket-Version") != "13" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		http.Error(w, "Unsupported WebSocket version", http.StatusUpgradeRequired)
+		return nil
+	}
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if decoded, err := base64.StdEncoding.DecodeString(key); err != nil || len(decoded) != 16 {
+		http.Error(w, "Invalid Sec-WebSocket-Key", http.StatusBadRequest)
+		return nil
+	}
+
+	conn, buffered, err := http.NewResponseController(w).Hijack()
+	if err != nil {
+		http.Error(w, "WebSocket upgrades are not supported on this connection", http.StatusInternalServerError)
+		return nil
+	}
+	sum := sha1.Sum([]byte(key + websocketGUID))
+	response := "HTTP/1.1 101 Switching Protocols\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + base64.StdEncoding.EncodeToString(sum[:]) + "\r\n\r\n"
+	_ = conn.SetWriteDeadline(time.Now().Add(websocketWriteTimeout))
+	if _, err := conn.Write([]byte(response)); err != nil {
+		_ = conn.Close()
+		return nil
+	}
+	_ = conn.SetDeadline(time.Time{})
+	return &websocketConn{conn: conn, reader: buffered.Reader}
+}
+
+// headerHasToken reports whether a comma-separated header lists token,
+// ignoring case
+func headerHasToken(header http.Header, name, token string) bool {
+	for _, value := range header.Values(name) {
+		for _, part := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(part), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// websocketCloseError is returned by readMessage once the peer closed the
+// connection, or once it broke the protocol and was sent a close frame
+type websocketCloseError struct {
+	code   int
+	reason string
+}
+
+func (e *websocketCloseError) Error() string {
+	return fmt.Sprintf("websocket closed (%d): %s", e.code, e.reason)
+}
+
+// readMessage returns the next text or binary message, answering pings and
+// close frames along the way
+func (c *websocketConn) readMessage() (int, []byte, error) {
+	var opcode int
+	var message []byte
+	for {
+		fin, frameOpcode, payload, err := c.readFrame()
+		if err != nil {
+			return 0, nil, err
+		}
+		switch frameOpcode {
+		case websocketPing:
+			if err := c.writeFrame(websocketPong, payload); err != nil {
+				return 0, nil, err
+			}
+			continue
+		case websocketPong:
+			continue
+		case websocketClose:
+			code := websocketNormalClosure
+			if len(payload) >= 2 {
+				code = int(binary.BigEndian.Uint16(payload))
+			}
+			_ = c.close(code, "")
+			return 0, nil, &websocketCloseError{code: code, reason: string(payload[min(len(payload), 2):])}
+		case websocketText, websocketBinary:
+			if opcode != 0 {
+				return 0, nil, c.fail(websocketProtocolError, "expected a continuation frame")
+			}
+			opcode = frameOpcode
+		case websocketContinuation:
+			if opcode == 0 {
+				return 0, nil, c.fail(websocketProtocolError, "unexpected continuation frame")
+			}
+		default:
+			return 0, nil, c.fail(websocketProtocolError, "unknown opcode")
+		}
+		if len(message)+len(payload) > websocketMaxMessage {
+			return 0, nil, c.fail(websocketMessageTooBig, "message too big")
+		}
+		message = append(message, payload...)
+		if fin {
+			return opcode, message, nil
+		}
+	}
+}
+
+// readFrame reads and unmasks one frame
+func (c *websocketConn) readFrame() (bool, int, []byte, error) {
+	var header [2]byte
+	if _, err := io.ReadFull(c.reader, header[:]); err != nil {
+		return false, 0, nil, err
+	}
+	fin := header[0]&0x80 != 0
+	opcode := int(header[0] & 0x0f)
+	if header[0]&0x70 != 0 {
+		return false, 0, nil, c.fail(websocketProtocolError, "reserved bits set")
+	}
+	if header[1]&0x80 == 0 {
+		return false, 0, nil, c.fail(websocketProtocolError, "client frames must be masked")
+	}
+
+	length := uint64(header[1] & 0x7f)
+	switch length {
+	case 126:
+		var extended [2]byte
+		if _, err := io.ReadFull(c.reader, extended[:]); err != nil {
+			return false, 0, nil, err
+		}
+		length = uint64(binary.BigEndian.Uint16(extended[:]))
+	case 127:
+		var extended [8]byte
+		if _, err := io.ReadFull(c.reader, extended[:]); err != nil {
+			return false, 0, nil, err
+		}
+		length = binary.BigEndian.Uint64(extended[:])
+	}
+	if opcode >= websocketClose && (length > 125 || !fin) {
+		return false, 0, nil, c.fail(websocketProtocolError, "invalid control frame")
+	}
+	if length > websocketMaxMessage {
+		return false, 0, nil, c.fail(websocketMessageTooBig, "message too big")
+	}
+
+	var mask [4]byte
+	if _, err := io.ReadFull(c.reader, mask[:]); err != nil {
+		return false, 0, nil, err
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(c.reader, payload); err != nil {
+		return false, 0, nil, err
+	}
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+	return fin, opcode, payload, nil
+}
+
+// writeText sends a text message
+func (c *websocketConn) writeText(data []byte) error {
+	return c.writeFrame(websocketText, data)
+}
+
+// writeFrame sends one unmasked, final frame
+func (c *websocketConn) writeFrame(opcode int, payload []byte) error {
+	c.writeMutex.Lock()
+	defer c.writeMutex.Unlock()
+	if c.closed {
+		return net.ErrClosed
+	}
+
+	frame := []byte{0x80 | byte(opcode)}
+	switch length := len(payload); {
+	case length <= 125:
+		frame = append(frame, byte(length))
+	case length <= 0xffff:
+		frame = binary.BigEndian.AppendUint16(append(frame, 126), uint16(length))
+	default:
+		frame = binary.BigEndian.AppendUint64(append(frame, 127), uint64(length))
+	}
+	if err := c.conn.SetWriteDeadline(time.Now().Add(websocketWriteTimeout)); err != nil {
+		return err
+	}
+	if _, err := c.conn.Write(append(frame, payload...)); err != nil {
+		return err
+	}
+	if opcode == websocketClose {
+		c.closed = true
+	}
+	return nil
+}
+
+// close sends a close frame with code and reason, unless one was sent
+// already
+func (c *websocketConn) close(code int, reason string) error {
+	payload := binary.BigEndian.AppendUint16(nil, uint16(code))
+	return c.writeFrame(websocketClose, append(payload, reason...))
+}
+
+// fail sends a close frame for a protocol violation and returns the error
+// readMessage reports for it
+func (c *websocketConn) fail(code int, reason string) error {
+	_ = c.close(code, reason)
+	return &websocketCloseError{code: code, reason: reason}
+}
+
+// Close closes the underlying connection
+func (c *websocketConn) Close() error {
+	return c.conn.Close()
+}
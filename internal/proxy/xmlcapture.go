@@ -0,0 +1,384 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+)
+
+// xmlRedactedValue replaces element text and attribute values matched by an XPath redaction
+const xmlRedactedValue = "[REDACTED]"
+
+// XPath is a compiled location path used for XML redaction. It supports the
+// abbreviated subset of XPath needed to address elements and attributes:
+// "/" and "//" separators, name tests (prefixes are ignored and matched by
+// local name), "*" wildcards, and a final "@attr" step. Paths not starting
+// with "/" match anywhere in the document.
+type XPath struct {
+	expr  string
+	steps []xpathStep
+	attr  string // Attribute name when the path selects an attribute
+}
+
+type xpathStep struct {
+	descendant bool // Step may match at any depth below the previous step
+	name       string
+}
+
+// ParseXPath compiles an XPath expression for XML redaction
+func ParseXPath(expr string) (XPath, error) {
+	p := XPath{expr: expr}
+	rest := strings.TrimSpace(expr)
+	if rest == "" {
+		return XPath{}, fmt.Errorf("empty XPath expression")
+	}
+	if strings.ContainsAny(rest, "[]()=") {
+		return XPath{}, fmt.Errorf("XPath %q: predicates and functions are not supported", expr)
+	}
+
+	descendant := !strings.HasPrefix(rest, "/")
+	for rest != "" {
+		switch {
+		case strings.HasPrefix(rest, "//"):
+			descendant = true
+			rest = rest[2:]
+			continue
+		case strings.HasPrefix(rest, "/"):
+			rest = rest[1:]
+			continue
+		}
+
+		name := rest
+		if i := strings.Index(rest, "/"); i >= 0 {
+			name, rest = rest[:i], rest[i:]
+		} else {
+			rest = ""
+		}
+
+		if strings.HasPrefix(name, "@") {
+			if rest != "" || len(name) == 1 {
+				return XPath{}, fmt.Errorf("XPath %q: attribute must be the last step", expr)
+			}
+			p.attr = localName(name[1:])
+			break
+		}
+
+		p.steps = append(p.steps, xpathStep{descendant: descendant, name: localName(name)})
+		descendant = false
+	}
+
+	if len(p.steps) == 0 {
+		return XPath{}, fmt.Errorf("XPath %q: no element steps", expr)
+	}
+	return p, nil
+}
+
+// String returns the original expression
+func (p XPath) String() string {
+	return p.expr
+}
+
+// matchesElement reports whether the element path (local names from the root) is selected
+func (p XPath) matchesElement(path []string) bool {
+	return p.attr == "" && matchXPathSteps(p.steps, path)
+}
+
+// matchesAttr reports whether an attribute of the element at path is selected
+func (p XPath) matchesAttr(path []string, attr string) bool {
+	return p.attr != "" && (p.attr == "*" || p.attr == localName(attr)) && matchXPathSteps(p.steps, path)
+}
+
+func matchXPathSteps(steps []xpathStep, path []string) bool {
+	if len(steps) == 0 {
+		return len(path) == 0
+	}
+	step := steps[0]
+	if step.descendant {
+		for i := range path {
+			if step.matches(path[i]) && matchXPathSteps(steps[1:], path[i+1:]) {
+				return true
+			}
+		}
+		return false
+	}
+	return len(path) > 0 && step.matches(path[0]) && matchXPathSteps(steps[1:], path[1:])
+}
+
+func (s xpathStep) matches(name string) bool {
+	return s.name == "*" || s.name == name
+}
+
+func localName(name string) string {
+	if i := strings.LastIndex(name, ":"); i >= 0 {
+		return name[i+1:]
+	}
+	return name
+}
+
+// isXMLContentType reports whether a Content-Type header denotes an XML body
+func isXMLContentType(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	return mediaType == "text/xml" || mediaType == "application/xml" || strings.HasSuffix(mediaType, "+xml")
+}
+
+// soapAction extracts the SOAP action from the SOAPAction header (SOAP 1.1) or
+// the action parameter of the Content-Type (SOAP 1.2)
+func soapAction(header http.Header) string {
+	if action := header.Get("SOAPAction"); action != "" {
+		return strings.Trim(action, `"`)
+	}
+	_, params, err := mime.ParseMediaType(header.Get("Content-Type"))
+	if err != nil {
+		return ""
+	}
+	return params["action"]
+}
+
+// xmlDocument is a parsed XML body kept as raw tokens so prefixes are preserved
+type xmlDocument struct {
+	tokens []xml.Token
+}
+
+func parseXMLDocument(body string) (*xmlDocument, error) {
+	decoder := xml.NewDecoder(strings.NewReader(body))
+	decoder.Strict = false
+
+	doc := &xmlDocument{}
+	for {
+		tok, err := decoder.RawToken()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		doc.tokens = append(doc.tokens, xml.CopyToken(tok))
+	}
+	return doc, nil
+}
+
+// redact masks element text and attribute values selected by the given paths
+func (d *xmlDocument) redact(paths []XPath) {
+	if len(paths) == 0 {
+		return
+	}
+
+	var stack []string
+	redactDepth := 0 // Depth of the outermost redacted element, 0 when not redacting
+
+	for i, tok := range d.tokens {
+		switch t := tok.(type) {
+		case xml.StartElement:
+			stack = append(stack, t.Name.Local)
+			for _, p := range paths {
+				if redactDepth == 0 && p.matchesElement(stack) {
+					redactDepth = len(stack)
+				}
+				for j, attr := range t.Attr {
+					if p.matchesAttr(stack, qualifiedName(attr.Name)) {
+						t.Attr[j].Value = xmlRedactedValue
+					}
+				}
+			}
+			d.tokens[i] = t
+		case xml.EndElement:
+			if len(stack) == redactDepth {
+				redactDepth = 0
+			}
+			if len(stack) > 0 {
+				stack = stack[:len(stack)-1]
+			}
+		case xml.CharData:
+			if redactDepth > 0 && len(bytes.TrimSpace(t)) > 0 {
+				d.tokens[i] = xml.CharData(xmlRedactedValue)
+			}
+		}
+	}
+}
+
+// soapFields returns the operation (first element inside the SOAP Body) and the
+// fault string, if the document is a SOAP envelope
+func (d *xmlDocument) soapFields() (operation, fault string) {
+	var stack []string
+	inFaultString := false
+
+	for _, tok := range d.tokens {
+		switch t := tok.(type) {
+		case xml.StartElement:
+			stack = append(stack, t.Name.Local)
+			if len(stack) == 1 && t.Name.Local != "Envelope" {
+				return "", ""
+			}
+			if len(stack) == 3 && stack[1] == "Body" && operation == "" {
+				operation = t.Name.Local
+			}
+			// SOAP 1.1 uses faultstring, SOAP 1.2 uses Reason/Text
+			inFaultString = operation == "Fault" && (t.Name.Local == "faultstring" || t.Name.Local == "Text")
+		case xml.EndElement:
+			if len(stack) > 0 {
+				stack = stack[:len(stack)-1]
+			}
+			inFaultString = false
+		case xml.CharData:
+			if inFaultString && fault == "" {
+				fault = strings.TrimSpace(string(t))
+			}
+		}
+	}
+	return operation, fault
+}
+
+// rootElement returns the qualified name of the document element
+func (d *xmlDocument) rootElement() string {
+	for _, tok := range d.tokens {
+		if t, ok := tok.(xml.StartElement); ok {
+			return qualifiedName(t.Name)
+		}
+	}
+	return ""
+}
+
+// render serializes the document, indenting elements when pretty is set
+func (d *xmlDocument) render(pretty bool) string {
+	var buf strings.Builder
+	depth := 0
+
+	indent := func() {
+		if pretty {
+			buf.WriteString(strings.Repeat("  ", depth))
+		}
+	}
+	newline := func() {
+		if pretty {
+			buf.WriteString("\n")
+		}
+	}
+
+	for i := 0; i < len(d.tokens); i++ {
+		switch t := d.tokens[i].(type) {
+		case xml.StartElement:
+			indent()
+			writeStartTag(&buf, t)
+
+			if pretty {
+				// Collapse empty and text-only elements onto one line
+				if i+1 < len(d.tokens) {
+					if _, ok := d.tokens[i+1].(xml.EndElement); ok {
+						buf.WriteString("/>")
+						newline()
+						i++
+						continue
+					}
+				}
+				if i+2 < len(d.tokens) {
+					text, isText := d.tokens[i+1].(xml.CharData)
+					end, isEnd := d.tokens[i+2].(xml.EndElement)
+					if isText && isEnd {
+						buf.WriteString(">")
+						xmlEscapeText(&buf, text)
+						buf.WriteString("</" + qualifiedName(end.Name) + ">")
+						newline()
+						i += 2
+						continue
+					}
+				}
+			}
+
+			buf.WriteString(">")
+			newline()
+			depth++
+		case xml.EndElement:
+			depth--
+			indent()
+			buf.WriteString("</" + qualifiedName(t.Name) + ">")
+			newline()
+		case xml.CharData:
+			if pretty {
+				text := bytes.TrimSpace(t)
+				if len(text) == 0 {
+					continue
+				}
+				indent()
+				xmlEscapeText(&buf, text)
+				newline()
+				continue
+			}
+			xmlEscapeText(&buf, t)
+		case xml.Comment:
+			indent()
+			buf.WriteString("<!--" + string(t) + "-->")
+			newline()
+		case xml.ProcInst:
+			indent()
+			buf.WriteString("<?" + t.Target + " " + string(t.Inst) + "?>")
+			newline()
+		case xml.Directive:
+			indent()
+			buf.WriteString("<!" + string(t) + ">")
+			newline()
+		}
+	}
+
+	return strings.TrimRight(buf.String(), "\n")
+}
+
+func writeStartTag(buf *strings.Builder, t xml.StartElement) {
+	buf.WriteString("<" + qualifiedName(t.Name))
+	for _, attr := range t.Attr {
+		buf.WriteString(" " + qualifiedName(attr.Name) + `="`)
+		if err := xml.EscapeText(buf, []byte(attr.Value)); err != nil {
+			buf.WriteString(attr.Value)
+		}
+		buf.WriteString(`"`)
+	}
+}
+
+func xmlEscapeText(buf *strings.Builder, text []byte) {
+	if err := xml.EscapeText(buf, text); err != nil {
+		buf.Write(text)
+	}
+}
+
+// qualifiedName renders a raw token name with its prefix
+func qualifiedName(name xml.Name) string {
+	if name.Space == "" {
+		return name.Local
+	}
+	return name.Space + ":" + name.Local
+}
+
+// processXML extracts SOAP fields from captured XML bodies and pretty-prints and
+// redacts them when configured. Bodies that fail to parse are left untouched.
+func (p *Proxy) processXML(record *RequestRecord, reqHeader, respHeader http.Header) {
+	rewrite := p.currentConfig().XMLPrettyPrint || len(p.currentConfig().XMLRedactions) > 0
+
+	if isXMLContentType(reqHeader.Get("Content-Type")) {
+		record.SOAPAction = soapAction(reqHeader)
+		if doc, err := parseXMLDocument(record.RequestBody); err == nil {
+			record.XMLRequestRoot = doc.rootElement()
+			record.SOAPOperation, _ = doc.soapFields()
+			if rewrite {
+				doc.redact(p.currentConfig().XMLRedactions)
+				record.RequestBody = doc.render(p.currentConfig().XMLPrettyPrint)
+			}
+		}
+	}
+
+	if isXMLContentType(respHeader.Get("Content-Type")) {
+		if doc, err := parseXMLDocument(record.ResponseBody); err == nil {
+			record.XMLResponseRoot = doc.rootElement()
+			_, record.SOAPFault = doc.soapFields()
+			if rewrite {
+				doc.redact(p.currentConfig().XMLRedactions)
+				record.ResponseBody = doc.render(p.currentConfig().XMLPrettyPrint)
+			}
+		}
+	}
+}
@@ -0,0 +1,163 @@
+//go:build unit
+
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testSOAPRequest = `<?xml version="1.0"?>
+<soap:Envelope xmlns:soap="http://schemas.xmlsoap.org/soap/envelope/"><soap:Header><auth:Credentials xmlns:auth="urn:auth" token="secret-token"><auth:Password>hunter2</auth:Password></auth:Credentials></soap:Header><soap:Body><m:GetUser xmlns:m="urn:users"><m:ID>42</m:ID><m:Flags/></m:GetUser></soap:Body></soap:Envelope>`
+
+const testSOAPFault = `<soap:Envelope xmlns:soap="http://schemas.xmlsoap.org/soap/envelope/">
+  <soap:Body>
+    <soap:Fault>
+      <faultcode>soap:Server</faultcode>
+      <faultstring>User not found</faultstring>
+    </soap:Fault>
+  </soap:Body>
+</soap:Envelope>`
+
+func TestParseXPath(t *testing.T) {
+	valid := []string{"//Password", "/Envelope/Body/GetUser", "Credentials/@token", "//soap:Header/*", "//*/@id"}
+	for _, expr := range valid {
+		_, err := ParseXPath(expr)
+		assert.NoError(t, err, expr)
+	}
+
+	invalid := []string{"", "//Item[1]", "count(//Item)", "//@id/Name", "/@id", "//Item/@"}
+	for _, expr := range invalid {
+		_, err := ParseXPath(expr)
+		assert.Error(t, err, expr)
+	}
+}
+
+func TestXPathMatching(t *testing.T) {
+	path := []string{"Envelope", "Header", "Credentials", "Password"}
+
+	tests := []struct {
+		expr string
+		want bool
+	}{
+		{"//Password", true},
+		{"Password", true},
+		{"/Envelope/Header/Credentials/Password", true},
+		{"/soap:Envelope//Password", true},
+		{"/Envelope/*/Credentials/Password", true},
+		{"/Header/Credentials/Password", false},
+		{"//Credentials", false},
+		{"//Body//Password", false},
+	}
+
+	for _, tt := range tests {
+		p, err := ParseXPath(tt.expr)
+		require.NoError(t, err)
+		assert.Equal(t, tt.want, p.matchesElement(path), tt.expr)
+	}
+
+	attr, err := ParseXPath("//Credentials/@token")
+	require.NoError(t, err)
+	assert.True(t, attr.matchesAttr(path[:3], "token"))
+	assert.False(t, attr.matchesAttr(path[:3], "other"))
+	assert.False(t, attr.matchesElement(path[:3]))
+}
+
+func TestXMLRedactAndPrettyPrint(t *testing.T) {
+	doc, err := parseXMLDocument(testSOAPRequest)
+	require.NoError(t, err)
+
+	password, err := ParseXPath("//Password")
+	require.NoError(t, err)
+	token, err := ParseXPath("//Credentials/@token")
+	require.NoError(t, err)
+	doc.redact([]XPath{password, token})
+
+	expected := `<?xml version="1.0"?>
+<soap:Envelope xmlns:soap="http://schemas.xmlsoap.org/soap/envelope/">
+  <soap:Header>
+    <auth:Credentials xmlns:auth="urn:auth" token="[REDACTED]">
+      <auth:Password>[REDACTED]</auth:Password>
+    </auth:Credentials>
+  </soap:Header>
+  <soap:Body>
+    <m:GetUser xmlns:m="urn:users">
+      <m:ID>42</m:ID>
+      <m:Flags/>
+    </m:GetUser>
+  </soap:Body>
+</soap:Envelope>`
+	assert.Equal(t, expected, doc.render(true))
+
+	compact := doc.render(false)
+	assert.NotContains(t, compact, "hunter2")
+	assert.NotContains(t, compact, "secret-token")
+	assert.NotContains(t, compact, "\n  ")
+}
+
+func TestSOAPFields(t *testing.T) {
+	doc, err := parseXMLDocument(testSOAPRequest)
+	require.NoError(t, err)
+	operation, fault := doc.soapFields()
+	assert.Equal(t, "GetUser", operation)
+	assert.Empty(t, fault)
+	assert.Equal(t, "soap:Envelope", doc.rootElement())
+
+	doc, err = parseXMLDocument(testSOAPFault)
+	require.NoError(t, err)
+	operation, fault = doc.soapFields()
+	assert.Equal(t, "Fault", operation)
+	assert.Equal(t, "User not found", fault)
+
+	doc, err = parseXMLDocument(`<feed><entry/></feed>`)
+	require.NoError(t, err)
+	operation, _ = doc.soapFields()
+	assert.Empty(t, operation)
+}
+
+func TestSOAPCaptureInHistory(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/xml; charset=utf-8")
+		w.WriteHeader(http.StatusInternalServerError)
+		if _, err := w.Write([]byte(testSOAPFault)); err != nil {
+			t.Logf("Error writing response: %v", err)
+		}
+	}))
+	defer upstream.Close()
+
+	password, err := ParseXPath("//Password")
+	require.NoError(t, err)
+	proxy := New(&Config{Port: 8080, XMLPrettyPrint: true, XMLRedactions: []XPath{password}})
+
+	req := httptest.NewRequest(http.MethodPost, upstream.URL+"/soap", strings.NewReader(testSOAPRequest))
+	req.Header.Set("Content-Type", "text/xml; charset=utf-8")
+	req.Header.Set("SOAPAction", `"urn:users#GetUser"`)
+	rec := httptest.NewRecorder()
+	proxy.ServeHTTP(rec, req)
+
+	// The client still receives the upstream bytes untouched
+	assert.Equal(t, testSOAPFault, rec.Body.String())
+
+	records := proxy.history.GetRecords()
+	require.Len(t, records, 1)
+	record := records[0]
+	assert.Equal(t, "urn:users#GetUser", record.SOAPAction)
+	assert.Equal(t, "GetUser", record.SOAPOperation)
+	assert.Equal(t, "User not found", record.SOAPFault)
+	assert.Equal(t, "soap:Envelope", record.XMLRequestRoot)
+	assert.NotContains(t, record.RequestBody, "hunter2")
+	assert.Contains(t, record.RequestBody, "\n      <m:ID>42</m:ID>")
+}
+
+func TestSOAP12ActionFromContentType(t *testing.T) {
+	header := http.Header{}
+	header.Set("Content-Type", `application/soap+xml; charset=utf-8; action="urn:Ping"`)
+	assert.Equal(t, "urn:Ping", soapAction(header))
+	assert.True(t, isXMLContentType(header.Get("Content-Type")))
+	assert.False(t, isXMLContentType("application/json"))
+}
@@ -0,0 +1,144 @@
+package schema
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// tokenizer splits .proto and .thrift sources into identifiers, numbers,
+// string literals, and single-character symbols, skipping comments
+type tokenizer struct {
+	tokens []string
+	pos    int
+}
+
+func newTokenizer(src string) *tokenizer {
+	t := &tokenizer{}
+	runes := []rune(src)
+	for i := 0; i < len(runes); {
+		c := runes[i]
+		switch {
+		case unicode.IsSpace(c):
+			i++
+		case c == '/' && i+1 < len(runes) && runes[i+1] == '/', c == '#':
+			for i < len(runes) && runes[i] != '\n' {
+				i++
+			}
+		case c == '/' && i+1 < len(runes) && runes[i+1] == '*':
+			i += 2
+			for i+1 < len(runes) && (runes[i] != '*' || runes[i+1] != '/') {
+				i++
+			}
+			i += 2
+		case c == '"' || c == '\'':
+			start := i
+			i++
+			for i < len(runes) && runes[i] != c {
+				if runes[i] == '\\' {
+					i++
+				}
+				i++
+			}
+			i++
+			if i > len(runes) {
+				i = len(runes)
+			}
+			t.tokens = append(t.tokens, string(runes[start:i]))
+		case isIdentRune(c) || c == '-' && i+1 < len(runes) && unicode.IsDigit(runes[i+1]):
+			start := i
+			i++
+			for i < len(runes) && isIdentRune(runes[i]) {
+				i++
+			}
+			t.tokens = append(t.tokens, string(runes[start:i]))
+		default:
+			t.tokens = append(t.tokens, string(c))
+			i++
+		}
+	}
+	return t
+}
+
+func isIdentRune(c rune) bool {
+	return unicode.IsLetter(c) || unicode.IsDigit(c) || c == '_' || c == '.'
+}
+
+// done reports whether all tokens have been consumed
+func (t *tokenizer) done() bool {
+	return t.pos >= len(t.tokens)
+}
+
+// peek returns the next token without consuming it
+func (t *tokenizer) peek() string {
+	if t.done() {
+		return ""
+	}
+	return t.tokens[t.pos]
+}
+
+// next consumes and returns the next token
+func (t *tokenizer) next() string {
+	tok := t.peek()
+	t.pos++
+	return tok
+}
+
+// expect consumes the next token and fails if it is not want
+func (t *tokenizer) expect(want string) error {
+	if got := t.next(); got != want {
+		return fmt.Errorf("expected %q, got %q", want, got)
+	}
+	return nil
+}
+
+// skipStatement consumes tokens up to and including the next ';', skipping any
+// balanced block encountered on the way
+func (t *tokenizer) skipStatement() {
+	for !t.done() {
+		switch t.next() {
+		case ";":
+			return
+		case "{":
+			t.skipBlock()
+			return
+		}
+	}
+}
+
+// skipBlock consumes tokens up to the '}' matching an already consumed '{'
+func (t *tokenizer) skipBlock() {
+	depth := 1
+	for !t.done() && depth > 0 {
+		switch t.next() {
+		case "{":
+			depth++
+		case "}":
+			depth--
+		}
+	}
+}
+
+// skipBracketed consumes a balanced (), [] or <> group if one starts at the current position
+func (t *tokenizer) skipBracketed(open, closing string) {
+	if t.peek() != open {
+		return
+	}
+	depth := 0
+	for !t.done() {
+		switch t.next() {
+		case open:
+			depth++
+		case closing:
+			depth--
+			if depth == 0 {
+				return
+			}
+		}
+	}
+}
+
+// unquote strips the quotes from a string literal token
+func unquote(tok string) string {
+	return strings.Trim(tok, `"'`)
+}
@@ -0,0 +1,545 @@
+package schema
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// protoField describes a single field of a protobuf message
+type protoField struct {
+	name     string
+	number   int
+	typeName string // Scalar type name or fully-qualified message/enum name
+	repeated bool
+	mapKey   string // Non-empty for map fields
+	mapValue string
+}
+
+// protoMessage describes a protobuf message type
+type protoMessage struct {
+	name   string
+	fields map[int]*protoField
+}
+
+// ProtoFile holds the message and enum definitions parsed from .proto sources
+type ProtoFile struct {
+	messages map[string]*protoMessage    // Keyed by fully-qualified name without leading dot
+	enums    map[string]map[int32]string // Enum value names keyed by fully-qualified enum name
+}
+
+// ParseProto parses a proto2/proto3 schema. Imports are resolved relative to dir
+// when the imported file exists; missing imports are ignored.
+func ParseProto(src, dir string) (*ProtoFile, error) {
+	f := &ProtoFile{
+		messages: make(map[string]*protoMessage),
+		enums:    make(map[string]map[int32]string),
+	}
+	if err := f.parse(src, dir, map[string]bool{}); err != nil {
+		return nil, err
+	}
+	f.resolve()
+	return f, nil
+}
+
+func (f *ProtoFile) parse(src, dir string, seen map[string]bool) error {
+	t := newTokenizer(src)
+	pkg := ""
+
+	for !t.done() {
+		switch tok := t.next(); tok {
+		case "syntax", "option", "edition":
+			t.skipStatement()
+		case "package":
+			pkg = t.next()
+			t.skipStatement()
+		case "import":
+			path := t.next()
+			if path == "public" || path == "weak" {
+				path = t.next()
+			}
+			t.skipStatement()
+			if err := f.parseImport(filepath.Join(dir, unquote(path)), seen); err != nil {
+				return err
+			}
+		case "message":
+			if err := f.parseMessage(t, pkg); err != nil {
+				return err
+			}
+		case "enum":
+			if err := f.parseEnum(t, pkg); err != nil {
+				return err
+			}
+		case "service", "extend":
+			t.skipStatement()
+		case ";":
+		default:
+			return fmt.Errorf("unexpected token %q", tok)
+		}
+	}
+	return nil
+}
+
+func (f *ProtoFile) parseImport(path string, seen map[string]bool) error {
+	if seen[path] {
+		return nil
+	}
+	seen[path] = true
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		// Well-known and third-party imports are usually not available locally
+		return nil
+	}
+	return f.parse(string(data), filepath.Dir(path), seen)
+}
+
+func (f *ProtoFile) parseMessage(t *tokenizer, scope string) error {
+	name := qualify(scope, t.next())
+	if err := t.expect("{"); err != nil {
+		return fmt.Errorf("message %s: %v", name, err)
+	}
+
+	msg := &protoMessage{name: name, fields: make(map[int]*protoField)}
+	f.messages[name] = msg
+
+	for !t.done() {
+		tok := t.next()
+		switch tok {
+		case "}":
+			return nil
+		case "message":
+			if err := f.parseMessage(t, name); err != nil {
+				return err
+			}
+		case "enum":
+			if err := f.parseEnum(t, name); err != nil {
+				return err
+			}
+		case "oneof":
+			// Oneof members are regular fields on the wire
+			t.next()
+			if err := t.expect("{"); err != nil {
+				return fmt.Errorf("message %s: %v", name, err)
+			}
+			for !t.done() && t.peek() != "}" {
+				if t.peek() == "option" {
+					t.skipStatement()
+					continue
+				}
+				if err := f.parseField(t, msg, t.next()); err != nil {
+					return err
+				}
+			}
+			t.next()
+		case "option", "reserved", "extensions", "extend":
+			t.skipStatement()
+		case ";":
+		default:
+			if err := f.parseField(t, msg, tok); err != nil {
+				return err
+			}
+		}
+	}
+	return fmt.Errorf("message %s: unexpected end of file", name)
+}
+
+func (f *ProtoFile) parseField(t *tokenizer, msg *protoMessage, tok string) error {
+	field := &protoField{}
+
+	switch tok {
+	case "repeated":
+		field.repeated = true
+		tok = t.next()
+	case "optional", "required":
+		tok = t.next()
+	}
+
+	if tok == "map" {
+		if err := t.expect("<"); err != nil {
+			return fmt.Errorf("message %s: %v", msg.name, err)
+		}
+		field.mapKey = t.next()
+		if err := t.expect(","); err != nil {
+			return fmt.Errorf("message %s: %v", msg.name, err)
+		}
+		field.mapValue = t.next()
+		if err := t.expect(">"); err != nil {
+			return fmt.Errorf("message %s: %v", msg.name, err)
+		}
+	} else {
+		field.typeName = tok
+	}
+
+	field.name = t.next()
+	if err := t.expect("="); err != nil {
+		return fmt.Errorf("message %s field %s: %v", msg.name, field.name, err)
+	}
+	number, err := strconv.Atoi(t.next())
+	if err != nil {
+		return fmt.Errorf("message %s field %s: invalid field number", msg.name, field.name)
+	}
+	field.number = number
+
+	t.skipBracketed("[", "]")
+	if err := t.expect(";"); err != nil {
+		return fmt.Errorf("message %s field %s: %v", msg.name, field.name, err)
+	}
+
+	msg.fields[number] = field
+	return nil
+}
+
+func (f *ProtoFile) parseEnum(t *tokenizer, scope string) error {
+	name := qualify(scope, t.next())
+	if err := t.expect("{"); err != nil {
+		return fmt.Errorf("enum %s: %v", name, err)
+	}
+
+	values := make(map[int32]string)
+	f.enums[name] = values
+
+	for !t.done() {
+		tok := t.next()
+		switch tok {
+		case "}":
+			return nil
+		case "option", "reserved":
+			t.skipStatement()
+		case ";":
+		default:
+			if err := t.expect("="); err != nil {
+				return fmt.Errorf("enum %s: %v", name, err)
+			}
+			number, err := strconv.ParseInt(t.next(), 0, 32)
+			if err != nil {
+				return fmt.Errorf("enum %s value %s: invalid number", name, tok)
+			}
+			t.skipBracketed("[", "]")
+			t.skipStatement()
+			if _, exists := values[int32(number)]; !exists {
+				values[int32(number)] = tok
+			}
+		}
+	}
+	return fmt.Errorf("enum %s: unexpected end of file", name)
+}
+
+// resolve replaces relative message and enum type references with fully-qualified names
+func (f *ProtoFile) resolve() {
+	for _, msg := range f.messages {
+		for _, field := range msg.fields {
+			if field.mapKey != "" {
+				field.mapValue = f.resolveType(msg.name, field.mapValue)
+			} else {
+				field.typeName = f.resolveType(msg.name, field.typeName)
+			}
+		}
+	}
+}
+
+func (f *ProtoFile) resolveType(scope, name string) string {
+	if isProtoScalar(name) {
+		return name
+	}
+	if strings.HasPrefix(name, ".") {
+		return strings.TrimPrefix(name, ".")
+	}
+
+	// Search from the innermost scope outwards, as protoc does
+	for {
+		candidate := qualify(scope, name)
+		if _, ok := f.messages[candidate]; ok {
+			return candidate
+		}
+		if _, ok := f.enums[candidate]; ok {
+			return candidate
+		}
+		if scope == "" {
+			break
+		}
+		if i := strings.LastIndex(scope, "."); i >= 0 {
+			scope = scope[:i]
+		} else {
+			scope = ""
+		}
+	}
+
+	// Unresolved types (e.g. from unavailable imports) are decoded as raw values
+	return name
+}
+
+// Decoder returns a decoder for the named message type. The name may be
+// fully-qualified or a unique simple name.
+func (f *ProtoFile) Decoder(typeName string) (Decoder, error) {
+	typeName = strings.TrimPrefix(typeName, ".")
+	if msg, ok := f.messages[typeName]; ok {
+		return &protoDecoder{file: f, message: msg}, nil
+	}
+
+	var match *protoMessage
+	for name, msg := range f.messages {
+		if name == typeName || strings.HasSuffix(name, "."+typeName) {
+			if match != nil {
+				return nil, fmt.Errorf("message type %q is ambiguous", typeName)
+			}
+			match = msg
+		}
+	}
+	if match == nil {
+		return nil, fmt.Errorf("message type %q not found", typeName)
+	}
+	return &protoDecoder{file: f, message: match}, nil
+}
+
+// protoDecoder decodes protobuf wire format into a map keyed by field name
+type protoDecoder struct {
+	file    *ProtoFile
+	message *protoMessage
+}
+
+// Decode implements Decoder
+func (d *protoDecoder) Decode(body []byte) (interface{}, error) {
+	return d.file.decodeMessage(d.message, body)
+}
+
+const (
+	wireVarint     = 0
+	wireFixed64    = 1
+	wireBytes      = 2
+	wireStartGroup = 3
+	wireEndGroup   = 4
+	wireFixed32    = 5
+)
+
+func (f *ProtoFile) decodeMessage(msg *protoMessage, data []byte) (map[string]interface{}, error) {
+	result := make(map[string]interface{})
+
+	for len(data) > 0 {
+		key, n := binary.Uvarint(data)
+		if n <= 0 {
+			return nil, fmt.Errorf("%s: invalid field key", msg.name)
+		}
+		data = data[n:]
+
+		number := int(key >> 3)
+		wireType := int(key & 7)
+
+		raw, rest, err := readWireValue(wireType, data)
+		if err != nil {
+			return nil, fmt.Errorf("%s field %d: %v", msg.name, number, err)
+		}
+		data = rest
+
+		field, known := msg.fields[number]
+		if !known {
+			result[strconv.Itoa(number)] = decodeUnknown(wireType, raw)
+			continue
+		}
+
+		if field.mapKey != "" {
+			entry, err := f.decodeMapEntry(field, raw.bytes)
+			if err != nil {
+				return nil, fmt.Errorf("%s.%s: %v", msg.name, field.name, err)
+			}
+			m, _ := result[field.name].(map[string]interface{})
+			if m == nil {
+				m = make(map[string]interface{})
+				result[field.name] = m
+			}
+			for k, v := range entry {
+				m[k] = v
+			}
+			continue
+		}
+
+		// Packed repeated scalars arrive as a single length-delimited value
+		if wireType == wireBytes && field.repeated && f.isPackable(field.typeName) {
+			values, err := f.decodePacked(field, raw.bytes)
+			if err != nil {
+				return nil, fmt.Errorf("%s.%s: %v", msg.name, field.name, err)
+			}
+			existing, _ := result[field.name].([]interface{})
+			result[field.name] = append(existing, values...)
+			continue
+		}
+
+		value, err := f.decodeValue(field.typeName, wireType, raw)
+		if err != nil {
+			return nil, fmt.Errorf("%s.%s: %v", msg.name, field.name, err)
+		}
+		if field.repeated {
+			existing, _ := result[field.name].([]interface{})
+			result[field.name] = append(existing, value)
+		} else {
+			result[field.name] = value
+		}
+	}
+
+	return result, nil
+}
+
+func (f *ProtoFile) decodeMapEntry(field *protoField, data []byte) (map[string]interface{}, error) {
+	entryMsg := &protoMessage{
+		name: field.name + "Entry",
+		fields: map[int]*protoField{
+			1: {name: "key", number: 1, typeName: field.mapKey},
+			2: {name: "value", number: 2, typeName: field.mapValue},
+		},
+	}
+	entry, err := f.decodeMessage(entryMsg, data)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{fmt.Sprint(entry["key"]): entry["value"]}, nil
+}
+
+func (f *ProtoFile) decodePacked(field *protoField, data []byte) ([]interface{}, error) {
+	var values []interface{}
+	wireType := packedWireType(field.typeName)
+	for len(data) > 0 {
+		raw, rest, err := readWireValue(wireType, data)
+		if err != nil {
+			return nil, err
+		}
+		data = rest
+		value, err := f.decodeValue(field.typeName, wireType, raw)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+	}
+	return values, nil
+}
+
+// wireValue holds a single undecoded value read from the wire
+type wireValue struct {
+	varint uint64
+	bytes  []byte
+}
+
+func readWireValue(wireType int, data []byte) (wireValue, []byte, error) {
+	switch wireType {
+	case wireVarint:
+		v, n := binary.Uvarint(data)
+		if n <= 0 {
+			return wireValue{}, nil, fmt.Errorf("invalid varint")
+		}
+		return wireValue{varint: v}, data[n:], nil
+	case wireFixed64:
+		if len(data) < 8 {
+			return wireValue{}, nil, fmt.Errorf("truncated fixed64")
+		}
+		return wireValue{varint: binary.LittleEndian.Uint64(data), bytes: data[:8]}, data[8:], nil
+	case wireFixed32:
+		if len(data) < 4 {
+			return wireValue{}, nil, fmt.Errorf("truncated fixed32")
+		}
+		return wireValue{varint: uint64(binary.LittleEndian.Uint32(data)), bytes: data[:4]}, data[4:], nil
+	case wireBytes:
+		length, n := binary.Uvarint(data)
+		if n <= 0 || uint64(len(data)-n) < length {
+			return wireValue{}, nil, fmt.Errorf("truncated length-delimited value")
+		}
+		end := n + int(length)
+		return wireValue{bytes: data[n:end]}, data[end:], nil
+	case wireStartGroup, wireEndGroup:
+		return wireValue{}, nil, fmt.Errorf("groups are not supported")
+	default:
+		return wireValue{}, nil, fmt.Errorf("unknown wire type %d", wireType)
+	}
+}
+
+func (f *ProtoFile) decodeValue(typeName string, wireType int, raw wireValue) (interface{}, error) {
+	switch typeName {
+	case "int32":
+		return int32(raw.varint), nil
+	case "int64":
+		return int64(raw.varint), nil
+	case "uint32", "fixed32":
+		return uint32(raw.varint), nil
+	case "uint64", "fixed64":
+		return raw.varint, nil
+	case "sint32":
+		return int32(uint32(raw.varint>>1) ^ -uint32(raw.varint&1)), nil
+	case "sint64":
+		return int64(raw.varint>>1) ^ -int64(raw.varint&1), nil
+	case "sfixed32":
+		return int32(uint32(raw.varint)), nil
+	case "sfixed64":
+		return int64(raw.varint), nil
+	case "bool":
+		return raw.varint != 0, nil
+	case "float":
+		return math.Float32frombits(uint32(raw.varint)), nil
+	case "double":
+		return math.Float64frombits(raw.varint), nil
+	case "string":
+		return string(raw.bytes), nil
+	case "bytes":
+		return base64.StdEncoding.EncodeToString(raw.bytes), nil
+	}
+
+	if values, ok := f.enums[typeName]; ok {
+		if name, ok := values[int32(raw.varint)]; ok {
+			return name, nil
+		}
+		return int32(raw.varint), nil
+	}
+	if msg, ok := f.messages[typeName]; ok && wireType == wireBytes {
+		return f.decodeMessage(msg, raw.bytes)
+	}
+	return decodeUnknown(wireType, raw), nil
+}
+
+// decodeUnknown renders a value without schema information
+func decodeUnknown(wireType int, raw wireValue) interface{} {
+	if wireType == wireBytes {
+		return base64.StdEncoding.EncodeToString(raw.bytes)
+	}
+	return raw.varint
+}
+
+func isProtoScalar(name string) bool {
+	switch name {
+	case "double", "float", "int32", "int64", "uint32", "uint64", "sint32", "sint64",
+		"fixed32", "fixed64", "sfixed32", "sfixed64", "bool", "string", "bytes":
+		return true
+	}
+	return false
+}
+
+// isPackable reports whether repeated values of the type may use packed encoding
+func (f *ProtoFile) isPackable(name string) bool {
+	if name == "string" || name == "bytes" {
+		return false
+	}
+	if isProtoScalar(name) {
+		return true
+	}
+	_, isEnum := f.enums[name]
+	return isEnum
+}
+
+func packedWireType(name string) int {
+	switch name {
+	case "double", "fixed64", "sfixed64":
+		return wireFixed64
+	case "float", "fixed32", "sfixed32":
+		return wireFixed32
+	}
+	return wireVarint
+}
+
+// qualify joins a scope and a name with a dot
+func qualify(scope, name string) string {
+	if scope == "" {
+		return name
+	}
+	return scope + "." + name
+}
@@ -0,0 +1,154 @@
+//go:build unit
+
+package schema
+
+import (
+	"encoding/binary"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testProto = `
+syntax = "proto3";
+package example.v1;
+
+import "google/protobuf/timestamp.proto";
+
+// A user account
+message User {
+  enum Role {
+    ROLE_UNSPECIFIED = 0;
+    ROLE_ADMIN = 1;
+  }
+  message Address {
+    string city = 1;
+  }
+
+  int64 id = 1;
+  string name = 2 [json_name = "displayName"];
+  Role role = 3;
+  repeated int32 scores = 4;
+  Address address = 5;
+  map<string, int32> counters = 6;
+  sint32 delta = 7;
+  double ratio = 8;
+  oneof contact {
+    string email = 9;
+    string phone = 10;
+  }
+  bytes avatar = 11;
+  reserved 12, 13;
+}
+
+message GetUserRequest { int64 id = 1; }
+`
+
+// protoBuilder encodes protobuf wire format for tests
+type protoBuilder []byte
+
+func (b protoBuilder) varint(field int, v uint64) protoBuilder {
+	b = binary.AppendUvarint(b, uint64(field<<3|wireVarint))
+	return binary.AppendUvarint(b, v)
+}
+
+func (b protoBuilder) bytes(field int, v []byte) protoBuilder {
+	b = binary.AppendUvarint(b, uint64(field<<3|wireBytes))
+	b = binary.AppendUvarint(b, uint64(len(v)))
+	return append(b, v...)
+}
+
+func (b protoBuilder) fixed64(field int, v uint64) protoBuilder {
+	b = binary.AppendUvarint(b, uint64(field<<3|wireFixed64))
+	return binary.LittleEndian.AppendUint64(b, v)
+}
+
+func TestProtoDecode(t *testing.T) {
+	f, err := ParseProto(testProto, t.TempDir())
+	require.NoError(t, err)
+
+	decoder, err := f.Decoder("User")
+	require.NoError(t, err)
+
+	packed := binary.AppendUvarint(nil, 10)
+	packed = binary.AppendUvarint(packed, 20)
+
+	body := protoBuilder(nil).
+		varint(1, 42).
+		bytes(2, []byte("Ada")).
+		varint(3, 1).
+		bytes(4, packed).
+		bytes(5, protoBuilder(nil).bytes(1, []byte("London"))).
+		bytes(6, protoBuilder(nil).bytes(1, []byte("logins")).varint(2, 7)).
+		varint(7, 3). // zigzag for -2
+		fixed64(8, math.Float64bits(0.5)).
+		bytes(9, []byte("ada@example.com")).
+		bytes(11, []byte{0xff, 0x00}).
+		varint(99, 5)
+
+	value, err := decoder.Decode(body)
+	require.NoError(t, err)
+
+	user := value.(map[string]interface{})
+	assert.Equal(t, int64(42), user["id"])
+	assert.Equal(t, "Ada", user["name"])
+	assert.Equal(t, "ROLE_ADMIN", user["role"])
+	assert.Equal(t, []interface{}{int32(10), int32(20)}, user["scores"])
+	assert.Equal(t, map[string]interface{}{"city": "London"}, user["address"])
+	assert.Equal(t, map[string]interface{}{"logins": int32(7)}, user["counters"])
+	assert.Equal(t, int32(-2), user["delta"])
+	assert.Equal(t, 0.5, user["ratio"])
+	assert.Equal(t, "ada@example.com", user["email"])
+	assert.Equal(t, "/wA=", user["avatar"])
+	assert.Equal(t, uint64(5), user["99"], "unknown fields are keyed by number")
+}
+
+func TestProtoDecoderLookup(t *testing.T) {
+	f, err := ParseProto(testProto, "")
+	require.NoError(t, err)
+
+	_, err = f.Decoder("example.v1.GetUserRequest")
+	assert.NoError(t, err)
+	_, err = f.Decoder("User.Address")
+	assert.NoError(t, err)
+	_, err = f.Decoder("Missing")
+	assert.Error(t, err)
+}
+
+func TestProtoImports(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "common.proto"), []byte(`
+syntax = "proto3";
+package common;
+message Money { int64 cents = 1; }
+`), 0o600))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "order.proto"), []byte(`
+syntax = "proto3";
+package shop;
+import "common.proto";
+message Order { common.Money total = 1; }
+`), 0o600))
+
+	f, err := LoadFile(filepath.Join(dir, "order.proto"))
+	require.NoError(t, err)
+	decoder, err := f.Decoder("Order")
+	require.NoError(t, err)
+
+	value, err := decoder.Decode(protoBuilder(nil).bytes(1, protoBuilder(nil).varint(1, 1999)))
+	require.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{"total": map[string]interface{}{"cents": int64(1999)}}, value)
+}
+
+func TestProtoDecodeTruncated(t *testing.T) {
+	f, err := ParseProto(testProto, "")
+	require.NoError(t, err)
+	decoder, err := f.Decoder("User")
+	require.NoError(t, err)
+
+	_, err = decoder.Decode([]byte{0x12, 0x05, 'A'})
+	assert.Error(t, err)
+}
@@ -0,0 +1,35 @@
+package schema
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Decoder converts a binary message body into a JSON-serializable value
+type Decoder interface {
+	Decode(body []byte) (interface{}, error)
+}
+
+// File is a parsed schema file that can produce decoders for its named types
+type File interface {
+	Decoder(typeName string) (Decoder, error)
+}
+
+// LoadFile parses a .proto or .thrift schema file based on its extension
+func LoadFile(path string) (File, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading schema file: %v", err)
+	}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".proto":
+		return ParseProto(string(data), filepath.Dir(path))
+	case ".thrift":
+		return ParseThrift(string(data))
+	default:
+		return nil, fmt.Errorf("unsupported schema file %q (expected .proto or .thrift)", path)
+	}
+}
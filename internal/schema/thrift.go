@@ -0,0 +1,658 @@
+package schema
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// thriftType is a reference to a base, container, or named Thrift type
+type thriftType struct {
+	name string      // Base type name, container kind (list, set, map), or named type
+	key  *thriftType // Map key type
+	elem *thriftType // List/set element type or map value type
+}
+
+// thriftField describes a single struct field or function argument
+type thriftField struct {
+	id   int16
+	name string
+	typ  *thriftType
+}
+
+// thriftStruct describes a struct, union, or exception
+type thriftStruct struct {
+	name   string
+	fields map[int16]*thriftField
+}
+
+// thriftFunction describes a service method
+type thriftFunction struct {
+	name    string
+	returns *thriftType
+	args    *thriftStruct
+	throws  []*thriftField
+}
+
+// ThriftFile holds the definitions parsed from a .thrift source
+type ThriftFile struct {
+	structs  map[string]*thriftStruct
+	enums    map[string]map[int32]string
+	typedefs map[string]*thriftType
+	services map[string]map[string]*thriftFunction
+}
+
+// ParseThrift parses a Thrift IDL source
+func ParseThrift(src string) (*ThriftFile, error) {
+	f := &ThriftFile{
+		structs:  make(map[string]*thriftStruct),
+		enums:    make(map[string]map[int32]string),
+		typedefs: make(map[string]*thriftType),
+		services: make(map[string]map[string]*thriftFunction),
+	}
+
+	t := newTokenizer(src)
+	for !t.done() {
+		switch tok := t.next(); tok {
+		case "namespace":
+			t.next()
+			t.next()
+		case "include", "cpp_include":
+			t.next()
+		case "typedef":
+			typ, err := parseThriftType(t)
+			if err != nil {
+				return nil, err
+			}
+			f.typedefs[t.next()] = typ
+			t.skipBracketed("(", ")")
+		case "const":
+			if _, err := parseThriftType(t); err != nil {
+				return nil, err
+			}
+			t.next()
+			if err := t.expect("="); err != nil {
+				return nil, fmt.Errorf("const: %v", err)
+			}
+			skipThriftValue(t)
+		case "enum":
+			if err := f.parseEnum(t); err != nil {
+				return nil, err
+			}
+		case "struct", "union", "exception":
+			s, err := parseThriftStruct(t, t.next(), "{", "}")
+			if err != nil {
+				return nil, err
+			}
+			f.structs[s.name] = s
+			t.skipBracketed("(", ")")
+		case "service":
+			if err := f.parseService(t); err != nil {
+				return nil, err
+			}
+		case "senum":
+			t.next()
+			if err := t.expect("{"); err != nil {
+				return nil, fmt.Errorf("senum: %v", err)
+			}
+			t.skipBlock()
+		case ";", ",":
+		default:
+			return nil, fmt.Errorf("unexpected token %q", tok)
+		}
+	}
+	return f, nil
+}
+
+func parseThriftType(t *tokenizer) (*thriftType, error) {
+	name := t.next()
+	switch name {
+	case "list", "set":
+		if err := t.expect("<"); err != nil {
+			return nil, fmt.Errorf("%s: %v", name, err)
+		}
+		elem, err := parseThriftType(t)
+		if err != nil {
+			return nil, err
+		}
+		if err := t.expect(">"); err != nil {
+			return nil, fmt.Errorf("%s: %v", name, err)
+		}
+		t.skipBracketed("(", ")")
+		return &thriftType{name: name, elem: elem}, nil
+	case "map":
+		if err := t.expect("<"); err != nil {
+			return nil, fmt.Errorf("map: %v", err)
+		}
+		key, err := parseThriftType(t)
+		if err != nil {
+			return nil, err
+		}
+		if err := t.expect(","); err != nil {
+			return nil, fmt.Errorf("map: %v", err)
+		}
+		value, err := parseThriftType(t)
+		if err != nil {
+			return nil, err
+		}
+		if err := t.expect(">"); err != nil {
+			return nil, fmt.Errorf("map: %v", err)
+		}
+		t.skipBracketed("(", ")")
+		return &thriftType{name: name, key: key, elem: value}, nil
+	case "":
+		return nil, fmt.Errorf("unexpected end of file")
+	}
+	t.skipBracketed("(", ")")
+	return &thriftType{name: name}, nil
+}
+
+// skipThriftValue consumes a constant value, which may be a list or map literal
+func skipThriftValue(t *tokenizer) {
+	switch t.peek() {
+	case "[":
+		t.skipBracketed("[", "]")
+	case "{":
+		t.next()
+		t.skipBlock()
+	default:
+		t.next()
+	}
+}
+
+func parseThriftStruct(t *tokenizer, name, open, closing string) (*thriftStruct, error) {
+	if err := t.expect(open); err != nil {
+		return nil, fmt.Errorf("%s: %v", name, err)
+	}
+
+	s := &thriftStruct{name: name, fields: make(map[int16]*thriftField)}
+	nextImplicitID := int16(-1)
+
+	for !t.done() {
+		tok := t.peek()
+		if tok == closing {
+			t.next()
+			return s, nil
+		}
+		if tok == "," || tok == ";" {
+			t.next()
+			continue
+		}
+
+		field := &thriftField{}
+		if id, err := strconv.ParseInt(tok, 0, 16); err == nil {
+			t.next()
+			if err := t.expect(":"); err != nil {
+				return nil, fmt.Errorf("%s: %v", name, err)
+			}
+			field.id = int16(id)
+		} else {
+			// Fields without explicit IDs get negative IDs, as in the Apache compiler
+			field.id = nextImplicitID
+			nextImplicitID--
+		}
+
+		if t.peek() == "required" || t.peek() == "optional" {
+			t.next()
+		}
+
+		typ, err := parseThriftType(t)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", name, err)
+		}
+		field.typ = typ
+		field.name = t.next()
+
+		if t.peek() == "=" {
+			t.next()
+			skipThriftValue(t)
+		}
+		t.skipBracketed("(", ")")
+
+		s.fields[field.id] = field
+	}
+	return nil, fmt.Errorf("%s: unexpected end of file", name)
+}
+
+func (f *ThriftFile) parseEnum(t *tokenizer) error {
+	name := t.next()
+	if err := t.expect("{"); err != nil {
+		return fmt.Errorf("enum %s: %v", name, err)
+	}
+
+	values := make(map[int32]string)
+	f.enums[name] = values
+	next := int32(0)
+
+	for !t.done() {
+		tok := t.next()
+		switch tok {
+		case "}":
+			t.skipBracketed("(", ")")
+			return nil
+		case ",", ";":
+		default:
+			if t.peek() == "=" {
+				t.next()
+				number, err := strconv.ParseInt(t.next(), 0, 32)
+				if err != nil {
+					return fmt.Errorf("enum %s value %s: invalid number", name, tok)
+				}
+				next = int32(number)
+			}
+			t.skipBracketed("(", ")")
+			values[next] = tok
+			next++
+		}
+	}
+	return fmt.Errorf("enum %s: unexpected end of file", name)
+}
+
+func (f *ThriftFile) parseService(t *tokenizer) error {
+	name := t.next()
+	if t.peek() == "extends" {
+		t.next()
+		t.next()
+	}
+	if err := t.expect("{"); err != nil {
+		return fmt.Errorf("service %s: %v", name, err)
+	}
+
+	functions := make(map[string]*thriftFunction)
+	f.services[name] = functions
+
+	for !t.done() {
+		tok := t.peek()
+		switch tok {
+		case "}":
+			t.next()
+			t.skipBracketed("(", ")")
+			return nil
+		case ",", ";":
+			t.next()
+			continue
+		case "oneway":
+			t.next()
+		}
+
+		returns, err := parseThriftType(t)
+		if err != nil {
+			return fmt.Errorf("service %s: %v", name, err)
+		}
+		fn := &thriftFunction{name: t.next(), returns: returns}
+		fn.args, err = parseThriftStruct(t, name+"_"+fn.name+"_args", "(", ")")
+		if err != nil {
+			return fmt.Errorf("service %s: %v", name, err)
+		}
+		if t.peek() == "throws" {
+			t.next()
+			throws, err := parseThriftStruct(t, name+"_"+fn.name+"_throws", "(", ")")
+			if err != nil {
+				return fmt.Errorf("service %s: %v", name, err)
+			}
+			for _, field := range throws.fields {
+				fn.throws = append(fn.throws, field)
+			}
+		}
+		t.skipBracketed("(", ")")
+		functions[fn.name] = fn
+	}
+	return fmt.Errorf("service %s: unexpected end of file", name)
+}
+
+// Decoder returns a decoder for a struct name or a service name. Service decoders
+// expect a full message envelope and decode arguments or results by method name.
+func (f *ThriftFile) Decoder(typeName string) (Decoder, error) {
+	if s, ok := f.structs[typeName]; ok {
+		return &thriftDecoder{file: f, root: s}, nil
+	}
+	if functions, ok := f.services[typeName]; ok {
+		return &thriftDecoder{file: f, service: functions}, nil
+	}
+	return nil, fmt.Errorf("struct or service %q not found", typeName)
+}
+
+// thriftDecoder decodes TBinaryProtocol payloads
+type thriftDecoder struct {
+	file    *ThriftFile
+	root    *thriftStruct
+	service map[string]*thriftFunction
+}
+
+// Thrift binary protocol type IDs
+const (
+	thriftTypeStop   = 0
+	thriftTypeBool   = 2
+	thriftTypeByte   = 3
+	thriftTypeDouble = 4
+	thriftTypeI16    = 6
+	thriftTypeI32    = 8
+	thriftTypeI64    = 10
+	thriftTypeString = 11
+	thriftTypeStruct = 12
+	thriftTypeMap    = 13
+	thriftTypeSet    = 14
+	thriftTypeList   = 15
+	thriftTypeUUID   = 16
+
+	thriftVersionMask = 0xffff0000
+	thriftVersion1    = 0x80010000
+)
+
+var thriftMessageTypes = map[byte]string{1: "call", 2: "reply", 3: "exception", 4: "oneway"}
+
+// Decode implements Decoder
+func (d *thriftDecoder) Decode(body []byte) (interface{}, error) {
+	r := &thriftReader{data: body}
+
+	if !r.hasEnvelope() {
+		if d.root == nil {
+			return nil, fmt.Errorf("expected a message envelope")
+		}
+		return d.file.readStruct(r, d.root)
+	}
+
+	method, messageType, seqID, err := r.readEnvelope()
+	if err != nil {
+		return nil, err
+	}
+
+	result := map[string]interface{}{
+		"method": method,
+		"type":   thriftMessageTypes[messageType],
+		"seqid":  seqID,
+	}
+
+	var payload *thriftStruct
+	switch {
+	case d.root != nil:
+		payload = d.root
+	case messageType == 3:
+		payload = applicationException
+	default:
+		if fn, ok := d.service[method]; ok {
+			if messageType == 2 {
+				payload = fn.result()
+			} else {
+				payload = fn.args
+			}
+		}
+	}
+
+	value, err := d.file.readStruct(r, payload)
+	if err != nil {
+		return nil, err
+	}
+	if messageType == 2 || messageType == 3 {
+		result["result"] = value
+	} else {
+		result["args"] = value
+	}
+	return result, nil
+}
+
+// applicationException is the built-in TApplicationException struct
+var applicationException = &thriftStruct{
+	name: "TApplicationException",
+	fields: map[int16]*thriftField{
+		1: {id: 1, name: "message", typ: &thriftType{name: "string"}},
+		2: {id: 2, name: "type", typ: &thriftType{name: "i32"}},
+	},
+}
+
+// result builds the implicit result struct of a function: success plus declared exceptions
+func (fn *thriftFunction) result() *thriftStruct {
+	s := &thriftStruct{name: fn.name + "_result", fields: make(map[int16]*thriftField)}
+	if fn.returns.name != "void" {
+		s.fields[0] = &thriftField{id: 0, name: "success", typ: fn.returns}
+	}
+	for _, field := range fn.throws {
+		s.fields[field.id] = field
+	}
+	return s
+}
+
+// resolve follows typedefs to the underlying type
+func (f *ThriftFile) resolve(typ *thriftType) *thriftType {
+	for i := 0; typ != nil && i < 32; i++ {
+		next, ok := f.typedefs[typ.name]
+		if !ok {
+			return typ
+		}
+		typ = next
+	}
+	return typ
+}
+
+func (f *ThriftFile) readStruct(r *thriftReader, s *thriftStruct) (map[string]interface{}, error) {
+	result := make(map[string]interface{})
+
+	for {
+		wireType, err := r.readByte()
+		if err != nil {
+			return nil, err
+		}
+		if wireType == thriftTypeStop {
+			return result, nil
+		}
+		id, err := r.readI16()
+		if err != nil {
+			return nil, err
+		}
+
+		name := strconv.Itoa(int(id))
+		var typ *thriftType
+		if s != nil {
+			if field, ok := s.fields[id]; ok {
+				name = field.name
+				typ = field.typ
+			}
+		}
+
+		value, err := f.readValue(r, wireType, typ)
+		if err != nil {
+			return nil, fmt.Errorf("field %s: %v", name, err)
+		}
+		result[name] = value
+	}
+}
+
+func (f *ThriftFile) readValue(r *thriftReader, wireType byte, typ *thriftType) (interface{}, error) {
+	typ = f.resolve(typ)
+	typeName := ""
+	if typ != nil {
+		typeName = typ.name
+	}
+
+	switch wireType {
+	case thriftTypeBool:
+		b, err := r.readByte()
+		return b != 0, err
+	case thriftTypeByte:
+		b, err := r.readByte()
+		return int8(b), err
+	case thriftTypeDouble:
+		v, err := r.readU64()
+		return math.Float64frombits(v), err
+	case thriftTypeI16:
+		return r.readI16()
+	case thriftTypeI32:
+		v, err := r.readI32()
+		if err != nil {
+			return nil, err
+		}
+		if values, ok := f.enums[typeName]; ok {
+			if name, ok := values[v]; ok {
+				return name, nil
+			}
+		}
+		return v, nil
+	case thriftTypeI64:
+		v, err := r.readU64()
+		return int64(v), err
+	case thriftTypeString:
+		b, err := r.readBinary()
+		if err != nil {
+			return nil, err
+		}
+		if typeName == "binary" {
+			return base64.StdEncoding.EncodeToString(b), nil
+		}
+		return string(b), nil
+	case thriftTypeUUID:
+		b, err := r.read(16)
+		if err != nil {
+			return nil, err
+		}
+		return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16]), nil
+	case thriftTypeStruct:
+		return f.readStruct(r, f.structs[typeName])
+	case thriftTypeList, thriftTypeSet:
+		elemType, err := r.readByte()
+		if err != nil {
+			return nil, err
+		}
+		size, err := r.readSize()
+		if err != nil {
+			return nil, err
+		}
+		var elem *thriftType
+		if typ != nil {
+			elem = typ.elem
+		}
+		values := make([]interface{}, 0, size)
+		for i := 0; i < size; i++ {
+			v, err := f.readValue(r, elemType, elem)
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, v)
+		}
+		return values, nil
+	case thriftTypeMap:
+		keyType, err := r.readByte()
+		if err != nil {
+			return nil, err
+		}
+		valueType, err := r.readByte()
+		if err != nil {
+			return nil, err
+		}
+		size, err := r.readSize()
+		if err != nil {
+			return nil, err
+		}
+		var key, elem *thriftType
+		if typ != nil {
+			key, elem = typ.key, typ.elem
+		}
+		values := make(map[string]interface{}, size)
+		for i := 0; i < size; i++ {
+			k, err := f.readValue(r, keyType, key)
+			if err != nil {
+				return nil, err
+			}
+			v, err := f.readValue(r, valueType, elem)
+			if err != nil {
+				return nil, err
+			}
+			values[fmt.Sprint(k)] = v
+		}
+		return values, nil
+	default:
+		return nil, fmt.Errorf("unknown type id %d", wireType)
+	}
+}
+
+// thriftReader reads big-endian TBinaryProtocol primitives
+type thriftReader struct {
+	data []byte
+	pos  int
+}
+
+func (r *thriftReader) read(n int) ([]byte, error) {
+	if n < 0 || r.pos+n > len(r.data) {
+		return nil, fmt.Errorf("unexpected end of payload")
+	}
+	b := r.data[r.pos : r.pos+n]
+	r.pos += n
+	return b, nil
+}
+
+func (r *thriftReader) readByte() (byte, error) {
+	b, err := r.read(1)
+	if err != nil {
+		return 0, err
+	}
+	return b[0], nil
+}
+
+func (r *thriftReader) readI16() (int16, error) {
+	b, err := r.read(2)
+	if err != nil {
+		return 0, err
+	}
+	return int16(binary.BigEndian.Uint16(b)), nil
+}
+
+func (r *thriftReader) readI32() (int32, error) {
+	b, err := r.read(4)
+	if err != nil {
+		return 0, err
+	}
+	return int32(binary.BigEndian.Uint32(b)), nil
+}
+
+func (r *thriftReader) readU64() (uint64, error) {
+	b, err := r.read(8)
+	if err != nil {
+		return 0, err
+	}
+	return binary.BigEndian.Uint64(b), nil
+}
+
+func (r *thriftReader) readSize() (int, error) {
+	size, err := r.readI32()
+	if err != nil {
+		return 0, err
+	}
+	if size < 0 || int(size) > len(r.data)-r.pos {
+		return 0, fmt.Errorf("invalid size %d", size)
+	}
+	return int(size), nil
+}
+
+func (r *thriftReader) readBinary() ([]byte, error) {
+	size, err := r.readSize()
+	if err != nil {
+		return nil, err
+	}
+	return r.read(size)
+}
+
+// hasEnvelope reports whether the payload starts with a strict message header
+func (r *thriftReader) hasEnvelope() bool {
+	if len(r.data) < 4 {
+		return false
+	}
+	return binary.BigEndian.Uint32(r.data)&thriftVersionMask == thriftVersion1
+}
+
+func (r *thriftReader) readEnvelope() (string, byte, int32, error) {
+	header, err := r.readI32()
+	if err != nil {
+		return "", 0, 0, err
+	}
+	name, err := r.readBinary()
+	if err != nil {
+		return "", 0, 0, err
+	}
+	seqID, err := r.readI32()
+	if err != nil {
+		return "", 0, 0, err
+	}
+	return strings.TrimSpace(string(name)), byte(uint32(header) & 0xff), seqID, nil
+}
@@ -0,0 +1,155 @@
+//go:build unit
+
+package schema
+
+import (
+	"encoding/binary"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testThrift = `
+namespace go example
+include "shared.thrift"
+
+typedef i64 UserID
+
+enum Status {
+  ACTIVE = 1,
+  DISABLED
+}
+
+struct User {
+  1: required UserID id,
+  2: optional string name = "anonymous",
+  3: Status status,
+  4: list<string> tags,
+  5: map<string, i32> counters (go.tag = "x"),
+  6: binary avatar
+}
+
+exception NotFound {
+  1: string message
+}
+
+const list<string> DEFAULT_TAGS = ["a", "b"]
+
+service Users {
+  User getUser(1: UserID id) throws (1: NotFound notFound),
+  oneway void ping()
+}
+`
+
+// thriftBuilder encodes TBinaryProtocol payloads for tests
+type thriftBuilder []byte
+
+func (b thriftBuilder) field(typeID byte, id int16) thriftBuilder {
+	b = append(b, typeID)
+	return binary.BigEndian.AppendUint16(b, uint16(id))
+}
+
+func (b thriftBuilder) i32(v int32) thriftBuilder {
+	return binary.BigEndian.AppendUint32(b, uint32(v))
+}
+
+func (b thriftBuilder) u32(v uint32) thriftBuilder {
+	return binary.BigEndian.AppendUint32(b, v)
+}
+
+func (b thriftBuilder) i64(v int64) thriftBuilder {
+	return binary.BigEndian.AppendUint64(b, uint64(v))
+}
+
+func (b thriftBuilder) str(v string) thriftBuilder {
+	return append(b.i32(int32(len(v))), v...)
+}
+
+func (b thriftBuilder) stop() thriftBuilder {
+	return append(b, thriftTypeStop)
+}
+
+func userPayload() thriftBuilder {
+	b := thriftBuilder(nil).
+		field(thriftTypeI64, 1).i64(7).
+		field(thriftTypeString, 2).str("Grace").
+		field(thriftTypeI32, 3).i32(2).
+		field(thriftTypeList, 4)
+	b = append(b, thriftTypeString)
+	b = b.i32(2).str("x").str("y").field(thriftTypeMap, 5)
+	b = append(b, thriftTypeString, thriftTypeI32)
+	b = b.i32(1).str("logins").i32(3).
+		field(thriftTypeString, 6).str("\x01\x02").
+		field(thriftTypeI16, 42)
+	b = binary.BigEndian.AppendUint16(b, 9)
+	return b.stop()
+}
+
+func TestThriftDecodeStruct(t *testing.T) {
+	f, err := ParseThrift(testThrift)
+	require.NoError(t, err)
+
+	decoder, err := f.Decoder("User")
+	require.NoError(t, err)
+
+	value, err := decoder.Decode(userPayload())
+	require.NoError(t, err)
+
+	user := value.(map[string]interface{})
+	assert.Equal(t, int64(7), user["id"])
+	assert.Equal(t, "Grace", user["name"])
+	assert.Equal(t, "DISABLED", user["status"])
+	assert.Equal(t, []interface{}{"x", "y"}, user["tags"])
+	assert.Equal(t, map[string]interface{}{"logins": int32(3)}, user["counters"])
+	assert.Equal(t, "AQI=", user["avatar"])
+	assert.Equal(t, int16(9), user["42"], "unknown fields are keyed by id")
+}
+
+func TestThriftDecodeServiceEnvelope(t *testing.T) {
+	f, err := ParseThrift(testThrift)
+	require.NoError(t, err)
+
+	decoder, err := f.Decoder("Users")
+	require.NoError(t, err)
+
+	call := thriftBuilder(nil).u32(thriftVersion1 | 1)
+	call = call.str("getUser").i32(5).field(thriftTypeI64, 1).i64(7).stop()
+
+	value, err := decoder.Decode(call)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{
+		"method": "getUser",
+		"type":   "call",
+		"seqid":  int32(5),
+		"args":   map[string]interface{}{"id": int64(7)},
+	}, value)
+
+	reply := thriftBuilder(nil).u32(thriftVersion1 | 2)
+	reply = reply.str("getUser").i32(5).field(thriftTypeStruct, 0)
+	reply = append(reply, userPayload()...)
+	reply = reply.stop()
+
+	value, err = decoder.Decode(reply)
+	require.NoError(t, err)
+	result := value.(map[string]interface{})["result"].(map[string]interface{})
+	assert.Equal(t, "Grace", result["success"].(map[string]interface{})["name"])
+}
+
+func TestThriftDecodeErrors(t *testing.T) {
+	f, err := ParseThrift(testThrift)
+	require.NoError(t, err)
+
+	_, err = f.Decoder("Missing")
+	assert.Error(t, err)
+
+	decoder, err := f.Decoder("Users")
+	require.NoError(t, err)
+	_, err = decoder.Decode(userPayload())
+	assert.Error(t, err, "service decoders require an envelope")
+
+	structDecoder, err := f.Decoder("User")
+	require.NoError(t, err)
+	_, err = structDecoder.Decode([]byte{thriftTypeString, 0, 1, 0, 0, 0, 9})
+	assert.Error(t, err)
+}
@@ -0,0 +1,189 @@
+package session
+
+import (
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Cookie is a stored cookie together with the scope it was set for
+type Cookie struct {
+	Name     string    `json:"name"`
+	Value    string    `json:"value"`
+	Domain   string    `json:"domain"`
+	Path     string    `json:"path"`
+	Expires  time.Time `json:"expires,omitempty"` // Zero for session cookies, which persist with the session
+	Secure   bool      `json:"secure,omitempty"`
+	HostOnly bool      `json:"host_only,omitempty"`
+	Created  time.Time `json:"created"`
+}
+
+// Jar is an http.CookieJar whose contents can be persisted with a Store.
+// Unlike net/http/cookiejar it keeps session cookies, since a named session
+// spans several CLI invocations.
+type Jar struct {
+	mutex   sync.Mutex
+	cookies []Cookie
+	now     func() time.Time
+}
+
+// NewJar creates an empty cookie jar
+func NewJar() *Jar {
+	return &Jar{now: time.Now}
+}
+
+// SetCookies stores the cookies received in a response from u
+func (j *Jar) SetCookies(u *url.URL, cookies []*http.Cookie) {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+
+	host := canonicalHost(u)
+	now := j.now()
+	for _, c := range cookies {
+		stored := Cookie{
+			Name:    c.Name,
+			Value:   c.Value,
+			Path:    c.Path,
+			Secure:  c.Secure,
+			Created: now,
+		}
+
+		if c.Domain == "" {
+			stored.Domain = host
+			stored.HostOnly = true
+		} else {
+			domain := strings.ToLower(strings.TrimPrefix(c.Domain, "."))
+			if !domainMatch(host, domain) {
+				continue
+			}
+			stored.Domain = domain
+		}
+
+		if !strings.HasPrefix(stored.Path, "/") {
+			stored.Path = defaultPath(u.Path)
+		}
+
+		expired := false
+		switch {
+		case c.MaxAge < 0:
+			expired = true
+		case c.MaxAge > 0:
+			stored.Expires = now.Add(time.Duration(c.MaxAge) * time.Second)
+		case !c.Expires.IsZero():
+			stored.Expires = c.Expires
+			expired = !c.Expires.After(now)
+		}
+
+		j.remove(stored.Name, stored.Domain, stored.Path)
+		if !expired {
+			j.cookies = append(j.cookies, stored)
+		}
+	}
+}
+
+// Cookies returns the cookies to send in a request to u
+func (j *Jar) Cookies(u *url.URL) []*http.Cookie {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+
+	host := canonicalHost(u)
+	path := u.Path
+	if path == "" {
+		path = "/"
+	}
+	now := j.now()
+
+	var matched []Cookie
+	for _, c := range j.cookies {
+		if c.expired(now) {
+			continue
+		}
+		if c.HostOnly && host != c.Domain || !c.HostOnly && !domainMatch(host, c.Domain) {
+			continue
+		}
+		if !pathMatch(path, c.Path) || c.Secure && u.Scheme != "https" {
+			continue
+		}
+		matched = append(matched, c)
+	}
+
+	// Longer paths first, then older cookies first (RFC 6265 section 5.4)
+	sort.SliceStable(matched, func(a, b int) bool {
+		if len(matched[a].Path) != len(matched[b].Path) {
+			return len(matched[a].Path) > len(matched[b].Path)
+		}
+		return matched[a].Created.Before(matched[b].Created)
+	})
+
+	result := make([]*http.Cookie, len(matched))
+	for i, c := range matched {
+		result[i] = &http.Cookie{Name: c.Name, Value: c.Value}
+	}
+	return result
+}
+
+// All returns a copy of the unexpired cookies in the jar
+func (j *Jar) All() []Cookie {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+
+	now := j.now()
+	result := make([]Cookie, 0, len(j.cookies))
+	for _, c := range j.cookies {
+		if !c.expired(now) {
+			result = append(result, c)
+		}
+	}
+	return result
+}
+
+func (j *Jar) remove(name, domain, path string) {
+	kept := j.cookies[:0]
+	for _, c := range j.cookies {
+		if c.Name != name || c.Domain != domain || c.Path != path {
+			kept = append(kept, c)
+		}
+	}
+	j.cookies = kept
+}
+
+func (c Cookie) expired(now time.Time) bool {
+	return !c.Expires.IsZero() && !c.Expires.After(now)
+}
+
+func canonicalHost(u *url.URL) string {
+	return strings.ToLower(strings.TrimSuffix(u.Hostname(), "."))
+}
+
+// domainMatch reports whether host is the domain or a subdomain of it.
+// IP addresses only match exactly.
+func domainMatch(host, domain string) bool {
+	if host == domain {
+		return true
+	}
+	return net.ParseIP(host) == nil && strings.HasSuffix(host, "."+domain)
+}
+
+// pathMatch implements the RFC 6265 path-match rule
+func pathMatch(requestPath, cookiePath string) bool {
+	if requestPath == cookiePath {
+		return true
+	}
+	if !strings.HasPrefix(requestPath, cookiePath) {
+		return false
+	}
+	return strings.HasSuffix(cookiePath, "/") || requestPath[len(cookiePath)] == '/'
+}
+
+// defaultPath is the directory of the request path (RFC 6265 section 5.1.4)
+func defaultPath(path string) string {
+	i := strings.LastIndex(path, "/")
+	if i <= 0 {
+		return "/"
+	}
+	return path[:i]
+}
@@ -0,0 +1,176 @@
+//go:build unit
+
+package session
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func mustParse(t *testing.T, raw string) *url.URL {
+	t.Helper()
+	u, err := url.Parse(raw)
+	require.NoError(t, err)
+	return u
+}
+
+func cookieNames(cookies []*http.Cookie) []string {
+	names := make([]string, len(cookies))
+	for i, c := range cookies {
+		names[i] = c.Name
+	}
+	return names
+}
+
+func TestJarScoping(t *testing.T) {
+	jar := NewJar()
+	jar.SetCookies(mustParse(t, "https://api.example.com/auth/login"), []*http.Cookie{
+		{Name: "host", Value: "1"},
+		{Name: "domain", Value: "2", Domain: ".example.com", Path: "/"},
+		{Name: "secure", Value: "3", Path: "/", Secure: true},
+		{Name: "scoped", Value: "4", Path: "/v1"},
+		{Name: "foreign", Value: "5", Domain: "other.com"},
+	})
+
+	// Host-only cookies default to the directory of the request path
+	assert.ElementsMatch(t, []string{"host", "domain", "secure"}, cookieNames(jar.Cookies(mustParse(t, "https://api.example.com/auth/me"))))
+	assert.ElementsMatch(t, []string{"domain"}, cookieNames(jar.Cookies(mustParse(t, "http://www.example.com/"))))
+	assert.ElementsMatch(t, []string{"domain"}, cookieNames(jar.Cookies(mustParse(t, "http://api.example.com/"))))
+	assert.Equal(t, []string{"scoped", "domain", "secure"}, cookieNames(jar.Cookies(mustParse(t, "https://api.example.com/v1/users"))))
+	assert.ElementsMatch(t, []string{"domain", "secure"}, cookieNames(jar.Cookies(mustParse(t, "https://api.example.com/v10"))))
+	assert.Empty(t, jar.Cookies(mustParse(t, "https://other.com/")))
+}
+
+func TestJarExpiry(t *testing.T) {
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	jar := NewJar()
+	jar.now = func() time.Time { return now }
+	u := mustParse(t, "http://example.com/")
+
+	jar.SetCookies(u, []*http.Cookie{
+		{Name: "short", Value: "1", MaxAge: 60},
+		{Name: "session", Value: "2"},
+		{Name: "gone", Value: "3", Expires: now.Add(-time.Hour)},
+	})
+	assert.ElementsMatch(t, []string{"short", "session"}, cookieNames(jar.Cookies(u)))
+
+	now = now.Add(2 * time.Minute)
+	assert.Equal(t, []string{"session"}, cookieNames(jar.Cookies(u)))
+
+	// A negative Max-Age deletes the cookie
+	jar.SetCookies(u, []*http.Cookie{{Name: "session", MaxAge: -1}})
+	assert.Empty(t, jar.Cookies(u))
+}
+
+func TestJarReplacesCookie(t *testing.T) {
+	jar := NewJar()
+	u := mustParse(t, "http://example.com/")
+	jar.SetCookies(u, []*http.Cookie{{Name: "token", Value: "old"}})
+	jar.SetCookies(u, []*http.Cookie{{Name: "token", Value: "new"}})
+
+	cookies := jar.Cookies(u)
+	require.Len(t, cookies, 1)
+	assert.Equal(t, "new", cookies[0].Value)
+}
+
+func TestStoreRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	store := NewStore(dir, "")
+	u := mustParse(t, "http://example.com/")
+
+	jar, err := store.Load("login")
+	require.NoError(t, err)
+	assert.Empty(t, jar.All())
+
+	jar.SetCookies(u, []*http.Cookie{{Name: "sid", Value: "secret-session-id"}})
+	require.NoError(t, store.Save("login", jar))
+
+	// The jar is encrypted on disk and the generated key is private
+	data, err := os.ReadFile(filepath.Join(dir, "login.jar"))
+	require.NoError(t, err)
+	assert.NotContains(t, string(data), "secret-session-id")
+	info, err := os.Stat(filepath.Join(dir, keyFileName))
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0o600), info.Mode().Perm())
+
+	loaded, err := store.Load("login")
+	require.NoError(t, err)
+	cookies := loaded.Cookies(u)
+	require.Len(t, cookies, 1)
+	assert.Equal(t, "secret-session-id", cookies[0].Value)
+
+	names, err := store.List()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"login"}, names)
+
+	require.NoError(t, store.Clear("login"))
+	require.NoError(t, store.Clear("login"), "clearing twice is fine")
+	names, err = store.List()
+	require.NoError(t, err)
+	assert.Empty(t, names)
+}
+
+func TestStorePassphrase(t *testing.T) {
+	dir := t.TempDir()
+	u := mustParse(t, "http://example.com/")
+
+	jar := NewJar()
+	jar.SetCookies(u, []*http.Cookie{{Name: "sid", Value: "1"}})
+	require.NoError(t, NewStore(dir, "correct horse").Save("api", jar))
+
+	_, err := os.Stat(filepath.Join(dir, keyFileName))
+	assert.True(t, os.IsNotExist(err), "no key file is written when a passphrase is used")
+
+	loaded, err := NewStore(dir, "correct horse").Load("api")
+	require.NoError(t, err)
+	assert.Len(t, loaded.All(), 1)
+
+	_, err = NewStore(dir, "wrong").Load("api")
+	assert.Error(t, err)
+}
+
+func TestStoreInvalidName(t *testing.T) {
+	store := NewStore(t.TempDir(), "")
+	for _, name := range []string{"", "../escape", ".hidden", "a/b"} {
+		_, err := store.Load(name)
+		assert.Error(t, err, name)
+	}
+}
+
+func TestJarWithHTTPClient(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/login" {
+			http.SetCookie(w, &http.Cookie{Name: "sid", Value: "abc", Path: "/"})
+			return
+		}
+		if c, err := r.Cookie("sid"); err != nil || c.Value != "abc" {
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+	}))
+	defer server.Close()
+
+	store := NewStore(t.TempDir(), "")
+
+	// Log in with one jar, then use a freshly loaded jar as a later CLI run would
+	jar, err := store.Load("flow")
+	require.NoError(t, err)
+	resp, err := (&http.Client{Jar: jar}).Get(server.URL + "/login")
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	require.NoError(t, store.Save("flow", jar))
+
+	jar, err = store.Load("flow")
+	require.NoError(t, err)
+	resp, err = (&http.Client{Jar: jar}).Get(server.URL + "/api")
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+}
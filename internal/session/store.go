@@ -0,0 +1,243 @@
+package session
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Environment variables that configure where and how sessions are stored
+const (
+	DirEnv = "NETKIT_SESSION_DIR" // Overrides the session directory
+	KeyEnv = "NETKIT_SESSION_KEY" // Passphrase used instead of the generated key file
+)
+
+const (
+	jarExtension     = ".jar"
+	keyFileName      = "session.key"
+	fileMagic        = "NKJ1"
+	saltSize         = 16
+	keySize          = 32
+	pbkdf2Iterations = 100000
+)
+
+var validName = regexp.MustCompile(`^[A-Za-z0-9_-][A-Za-z0-9_.-]*$`)
+
+// Store persists named cookie jars to disk, encrypted with AES-256-GCM. The key
+// is derived from a passphrase when one is given, otherwise it is a random key
+// generated on first use and kept next to the jars with owner-only permissions.
+type Store struct {
+	dir        string
+	passphrase string
+}
+
+// NewStore creates a store rooted at dir
+func NewStore(dir, passphrase string) *Store {
+	return &Store{dir: dir, passphrase: passphrase}
+}
+
+// DefaultStore creates a store in $NETKIT_SESSION_DIR, or netkit/sessions under
+// the user config directory, using $NETKIT_SESSION_KEY as the passphrase if set
+func DefaultStore() (*Store, error) {
+	dir := os.Getenv(DirEnv)
+	if dir == "" {
+		configDir, err := os.UserConfigDir()
+		if err != nil {
+			return nil, fmt.Errorf("failed to locate session directory: %v", err)
+		}
+		dir = filepath.Join(configDir, "netkit", "sessions")
+	}
+	return NewStore(dir, os.Getenv(KeyEnv)), nil
+}
+
+// Load reads a session's cookie jar. A session that was never saved loads as an empty jar.
+func (s *Store) Load(name string) (*Jar, error) {
+	path, err := s.path(name)
+	if err != nil {
+		return nil, err
+	}
+
+	jar := NewJar()
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return jar, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read session %q: %v", name, err)
+	}
+
+	plaintext, err := s.decrypt(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt session %q: %v", name, err)
+	}
+	if err := json.Unmarshal(plaintext, &jar.cookies); err != nil {
+		return nil, fmt.Errorf("failed to parse session %q: %v", name, err)
+	}
+	return jar, nil
+}
+
+// Save writes a session's cookie jar, dropping expired cookies
+func (s *Store) Save(name string, jar *Jar) error {
+	path, err := s.path(name)
+	if err != nil {
+		return err
+	}
+
+	plaintext, err := json.Marshal(jar.All())
+	if err != nil {
+		return err
+	}
+	data, err := s.encrypt(plaintext)
+	if err != nil {
+		return fmt.Errorf("failed to encrypt session %q: %v", name, err)
+	}
+
+	if err := os.MkdirAll(s.dir, 0o700); err != nil {
+		return fmt.Errorf("failed to create session directory: %v", err)
+	}
+	// Write to a temporary file first so an interrupted save never truncates the jar
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return fmt.Errorf("failed to write session %q: %v", name, err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("failed to write session %q: %v", name, err)
+	}
+	return nil
+}
+
+// Clear deletes a session. Clearing a session that does not exist is not an error.
+func (s *Store) Clear(name string) error {
+	path, err := s.path(name)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to clear session %q: %v", name, err)
+	}
+	return nil
+}
+
+// List returns the names of all saved sessions
+func (s *Store) List() ([]string, error) {
+	entries, err := os.ReadDir(s.dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to list sessions: %v", err)
+	}
+
+	var names []string
+	for _, entry := range entries {
+		if !entry.IsDir() && strings.HasSuffix(entry.Name(), jarExtension) {
+			names = append(names, strings.TrimSuffix(entry.Name(), jarExtension))
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+func (s *Store) path(name string) (string, error) {
+	if !validName.MatchString(name) {
+		return "", fmt.Errorf("invalid session name %q: use letters, digits, '.', '_', and '-'", name)
+	}
+	return filepath.Join(s.dir, name+jarExtension), nil
+}
+
+// encrypt seals the plaintext as magic | salt | nonce | ciphertext
+func (s *Store) encrypt(plaintext []byte) ([]byte, error) {
+	salt := make([]byte, saltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	gcm, err := s.cipher(salt, true)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	data := append([]byte(fileMagic), salt...)
+	data = append(data, nonce...)
+	return gcm.Seal(data, nonce, plaintext, []byte(fileMagic)), nil
+}
+
+func (s *Store) decrypt(data []byte) ([]byte, error) {
+	if !bytes.HasPrefix(data, []byte(fileMagic)) || len(data) < len(fileMagic)+saltSize {
+		return nil, fmt.Errorf("not a netkit session file")
+	}
+	data = data[len(fileMagic):]
+	salt, data := data[:saltSize], data[saltSize:]
+
+	gcm, err := s.cipher(salt, false)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < gcm.NonceSize() {
+		return nil, fmt.Errorf("truncated session file")
+	}
+	nonce, ciphertext := data[:gcm.NonceSize()], data[gcm.NonceSize():]
+	plaintext, err := gcm.Open(nil, nonce, ciphertext, []byte(fileMagic))
+	if err != nil {
+		return nil, fmt.Errorf("wrong key or corrupted file")
+	}
+	return plaintext, nil
+}
+
+func (s *Store) cipher(salt []byte, create bool) (cipher.AEAD, error) {
+	key, err := s.key(salt, create)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// key derives the encryption key from the passphrase, or reads (and with create,
+// generates) the key file
+func (s *Store) key(salt []byte, create bool) ([]byte, error) {
+	if s.passphrase != "" {
+		return pbkdf2.Key(sha256.New, s.passphrase, salt, pbkdf2Iterations, keySize)
+	}
+
+	path := filepath.Join(s.dir, keyFileName)
+	key, err := os.ReadFile(path)
+	if err == nil {
+		if len(key) != keySize {
+			return nil, fmt.Errorf("invalid key file %s", path)
+		}
+		return key, nil
+	}
+	if !errors.Is(err, os.ErrNotExist) || !create {
+		return nil, fmt.Errorf("failed to read key file: %v", err)
+	}
+
+	key = make([]byte, keySize)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(s.dir, 0o700); err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(path, key, 0o600); err != nil {
+		return nil, fmt.Errorf("failed to write key file: %v", err)
+	}
+	return key, nil
+}
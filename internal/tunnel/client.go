@@ -0,0 +1,197 @@
+package tunnel
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxRedialBackoff caps the delay between reconnection attempts
+const maxRedialBackoff = 30 * time.Second
+
+// Client keeps a pool of idle connections to a tunnel server and exposes the
+// public connections relayed over them as a net.Listener, so an http.Server
+// can serve tunneled traffic directly
+type Client struct {
+	serverAddr string
+	token      string
+	publicURL  string
+
+	conns     chan net.Conn
+	done      chan struct{}
+	closeOnce sync.Once
+	wg        sync.WaitGroup
+}
+
+// Dial registers with the tunnel server and keeps poolSize idle tunnels open,
+// which bounds how many public connections can be served at once
+func Dial(serverAddr, token string, poolSize int) (*Client, error) {
+	if poolSize <= 0 {
+		poolSize = 4
+	}
+	c := &Client{
+		serverAddr: serverAddr,
+		token:      token,
+		conns:      make(chan net.Conn),
+		done:       make(chan struct{}),
+	}
+
+	// The first tunnel is opened synchronously so bad addresses and tokens fail fast
+	conn, publicURL, err := c.connect()
+	if err != nil {
+		return nil, err
+	}
+	c.publicURL = publicURL
+
+	c.wg.Add(poolSize)
+	go c.worker(conn)
+	for i := 1; i < poolSize; i++ {
+		go c.worker(nil)
+	}
+	return c, nil
+}
+
+// PublicURL returns the URL the server exposes this client on
+func (c *Client) PublicURL() string {
+	return c.publicURL
+}
+
+// Accept returns the next public connection relayed by the server
+func (c *Client) Accept() (net.Conn, error) {
+	select {
+	case conn := <-c.conns:
+		return conn, nil
+	case <-c.done:
+		return nil, net.ErrClosed
+	}
+}
+
+// Close disconnects all idle tunnels
+func (c *Client) Close() error {
+	c.closeOnce.Do(func() { close(c.done) })
+	c.wg.Wait()
+	return nil
+}
+
+// Addr returns the tunnel server address
+func (c *Client) Addr() net.Addr {
+	return tunnelAddr(c.serverAddr)
+}
+
+// worker holds one idle tunnel at a time, handing it to Accept once the server
+// assigns a public connection and then dialing a replacement
+func (c *Client) worker(conn net.Conn) {
+	defer c.wg.Done()
+	backoff := time.Second
+
+	for {
+		if conn == nil {
+			var err error
+			conn, _, err = c.connect()
+			if err != nil {
+				slog.Warn("Tunnel connection failed, retrying", "server", c.serverAddr, "retry_in", backoff.String(), "error", err)
+				select {
+				case <-time.After(backoff):
+				case <-c.done:
+					return
+				}
+				if backoff *= 2; backoff > maxRedialBackoff {
+					backoff = maxRedialBackoff
+				}
+				continue
+			}
+			backoff = time.Second
+		}
+
+		if !c.waitForOpen(conn) {
+			closeConn(conn)
+			conn = nil
+			select {
+			case <-c.done:
+				return
+			default:
+				continue
+			}
+		}
+
+		select {
+		case c.conns <- conn:
+		case <-c.done:
+			closeConn(conn)
+			return
+		}
+		conn = nil
+	}
+}
+
+// waitForOpen blocks until the server signals a public connection, skipping keepalives
+func (c *Client) waitForOpen(conn net.Conn) bool {
+	// Unblock the read when the client is closed
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		select {
+		case <-c.done:
+			if err := conn.SetReadDeadline(time.Now()); err != nil {
+				slog.Warn("Error interrupting tunnel", "error", err)
+			}
+		case <-stop:
+		}
+	}()
+
+	signal := make([]byte, 1)
+	for {
+		if _, err := io.ReadFull(conn, signal); err != nil {
+			return false
+		}
+		switch signal[0] {
+		case signalKeepalive:
+			continue
+		case signalOpen:
+			return true
+		default:
+			return false
+		}
+	}
+}
+
+// connect opens and authenticates one tunnel connection, returning the public URL
+func (c *Client) connect() (net.Conn, string, error) {
+	conn, err := net.DialTimeout("tcp", c.serverAddr, handshakeTimeout)
+	if err != nil {
+		return nil, "", err
+	}
+	if err := conn.SetDeadline(time.Now().Add(handshakeTimeout)); err != nil {
+		closeConn(conn)
+		return nil, "", err
+	}
+	if !writeLine(conn, protocolVersion+" "+c.token) {
+		closeConn(conn)
+		return nil, "", fmt.Errorf("failed to send tunnel handshake")
+	}
+	reply, err := readLine(conn)
+	if err != nil {
+		closeConn(conn)
+		return nil, "", fmt.Errorf("failed to read tunnel handshake: %v", err)
+	}
+	status, detail, _ := strings.Cut(reply, " ")
+	if status != "OK" {
+		closeConn(conn)
+		return nil, "", errors.New("tunnel server refused connection: " + detail)
+	}
+	if err := conn.SetDeadline(time.Time{}); err != nil {
+		closeConn(conn)
+		return nil, "", err
+	}
+	return conn, detail, nil
+}
+
+type tunnelAddr string
+
+func (a tunnelAddr) Network() string { return "tunnel" }
+func (a tunnelAddr) String() string  { return string(a) }
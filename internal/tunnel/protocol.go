@@ -0,0 +1,87 @@
+// Package tunnel implements a minimal reverse tunnel: a server on a public host
+// relays raw TCP connections to a client behind NAT over connections the client
+// opened in advance.
+//
+// A client authenticates each tunnel connection with a single line,
+// "NETKIT-TUNNEL/1 <token>\n", and the server answers "OK <public-url>\n" or
+// "ERR <reason>\n". The connection then idles, receiving keepalive bytes, until
+// the server writes signalOpen and starts piping a public connection through it.
+package tunnel
+
+import (
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"strings"
+	"time"
+)
+
+const protocolVersion = "NETKIT-TUNNEL/1"
+
+// Control bytes sent by the server on an idle tunnel
+const (
+	signalKeepalive = 0x00
+	signalOpen      = 0x01
+)
+
+const (
+	handshakeTimeout  = 10 * time.Second
+	keepaliveInterval = 30 * time.Second
+	maxLineLength     = 1024
+)
+
+// readLine reads a newline-terminated line one byte at a time so no tunneled
+// bytes after it are consumed
+func readLine(conn net.Conn) (string, error) {
+	var line []byte
+	b := make([]byte, 1)
+	for len(line) < maxLineLength {
+		if _, err := io.ReadFull(conn, b); err != nil {
+			return "", err
+		}
+		if b[0] == '\n' {
+			return strings.TrimSuffix(string(line), "\r"), nil
+		}
+		line = append(line, b[0])
+	}
+	return "", errors.New("handshake line too long")
+}
+
+func writeLine(conn net.Conn, line string) bool {
+	if _, err := io.WriteString(conn, line+"\n"); err != nil {
+		slog.Warn("Error writing tunnel handshake", "error", err)
+		return false
+	}
+	return true
+}
+
+// pipe copies in both directions until both sides are done, then closes both
+func pipe(a, b net.Conn) {
+	done := make(chan struct{})
+	go func() {
+		copyAndCloseWrite(a, b)
+		close(done)
+	}()
+	copyAndCloseWrite(b, a)
+	<-done
+	closeConn(a)
+	closeConn(b)
+}
+
+func copyAndCloseWrite(dst, src net.Conn) {
+	if _, err := io.Copy(dst, src); err != nil && !errors.Is(err, net.ErrClosed) {
+		slog.Warn("Tunnel copy error", "error", err)
+	}
+	if hc, ok := dst.(interface{ CloseWrite() error }); ok {
+		if err := hc.CloseWrite(); err != nil && !errors.Is(err, net.ErrClosed) {
+			slog.Warn("Error half-closing tunnel connection", "error", err)
+		}
+	}
+}
+
+func closeConn(conn net.Conn) {
+	if err := conn.Close(); err != nil && !errors.Is(err, net.ErrClosed) {
+		slog.Warn("Error closing tunnel connection", "error", err)
+	}
+}
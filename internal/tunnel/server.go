@@ -0,0 +1,197 @@
+package tunnel
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Server accepts tunnel clients on a control listener and relays every public
+// connection to an idle tunnel connection from the client
+type Server struct {
+	Token     string // Shared secret clients must present (optional)
+	PublicURL string // URL reported to clients (defaults to http://<public listener address>)
+
+	// ClaimTimeout bounds how long a public connection waits for an idle tunnel (default: 10s)
+	ClaimTimeout time.Duration
+
+	pending   chan net.Conn
+	done      chan struct{}
+	closeOnce sync.Once
+	mutex     sync.Mutex
+	listeners []net.Listener
+}
+
+// NewServer creates a tunnel server
+func NewServer(token, publicURL string) *Server {
+	return &Server{
+		Token:     token,
+		PublicURL: publicURL,
+		pending:   make(chan net.Conn),
+		done:      make(chan struct{}),
+	}
+}
+
+// ListenAndServe listens for public traffic on publicAddr and tunnel clients on controlAddr
+func (s *Server) ListenAndServe(publicAddr, controlAddr string) error {
+	public, err := net.Listen("tcp", publicAddr)
+	if err != nil {
+		return err
+	}
+	control, err := net.Listen("tcp", controlAddr)
+	if err != nil {
+		if closeErr := public.Close(); closeErr != nil {
+			slog.Warn("Error closing public listener", "error", closeErr)
+		}
+		return err
+	}
+	return s.Serve(public, control)
+}
+
+// Serve relays connections from the public listener over tunnels registered on the control listener
+func (s *Server) Serve(public, control net.Listener) error {
+	s.mutex.Lock()
+	s.listeners = append(s.listeners, public, control)
+	if s.PublicURL == "" {
+		s.PublicURL = "http://" + public.Addr().String()
+	}
+	s.mutex.Unlock()
+
+	errs := make(chan error, 2)
+	go func() { errs <- s.acceptLoop(control, s.register) }()
+	go func() { errs <- s.acceptLoop(public, s.relay) }()
+
+	err := <-errs
+	if closeErr := s.Close(); closeErr != nil {
+		slog.Warn("Error closing tunnel server", "error", closeErr)
+	}
+	if errors.Is(err, net.ErrClosed) {
+		return nil
+	}
+	return err
+}
+
+// Close stops the server and drops idle tunnels
+func (s *Server) Close() error {
+	var err error
+	s.closeOnce.Do(func() {
+		close(s.done)
+		s.mutex.Lock()
+		defer s.mutex.Unlock()
+		for _, ln := range s.listeners {
+			if closeErr := ln.Close(); closeErr != nil && !errors.Is(closeErr, net.ErrClosed) && err == nil {
+				err = closeErr
+			}
+		}
+	})
+	return err
+}
+
+func (s *Server) acceptLoop(ln net.Listener, handle func(net.Conn)) error {
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			select {
+			case <-s.done:
+				return net.ErrClosed
+			default:
+			}
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				continue
+			}
+			return err
+		}
+		go handle(conn)
+	}
+}
+
+// register authenticates a tunnel connection and holds it until a public connection claims it
+func (s *Server) register(conn net.Conn) {
+	if err := conn.SetReadDeadline(time.Now().Add(handshakeTimeout)); err != nil {
+		closeConn(conn)
+		return
+	}
+	line, err := readLine(conn)
+	if err != nil {
+		closeConn(conn)
+		return
+	}
+	version, token, _ := strings.Cut(line, " ")
+	if version != protocolVersion {
+		writeLine(conn, "ERR unsupported protocol version")
+		closeConn(conn)
+		return
+	}
+	if subtle.ConstantTimeCompare([]byte(token), []byte(s.Token)) != 1 {
+		slog.Warn("Rejected tunnel client with an invalid token", "client", conn.RemoteAddr().String())
+		writeLine(conn, "ERR invalid token")
+		closeConn(conn)
+		return
+	}
+	if err := conn.SetReadDeadline(time.Time{}); err != nil {
+		closeConn(conn)
+		return
+	}
+	if !writeLine(conn, "OK "+s.PublicURL) {
+		closeConn(conn)
+		return
+	}
+
+	ticker := time.NewTicker(keepaliveInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case public := <-s.pending:
+			if _, err := conn.Write([]byte{signalOpen}); err != nil {
+				rejectPublic(public)
+				closeConn(conn)
+				return
+			}
+			pipe(public, conn)
+			return
+		case <-ticker.C:
+			if _, err := conn.Write([]byte{signalKeepalive}); err != nil {
+				closeConn(conn)
+				return
+			}
+		case <-s.done:
+			closeConn(conn)
+			return
+		}
+	}
+}
+
+// relay hands a public connection to the next idle tunnel
+func (s *Server) relay(public net.Conn) {
+	timeout := s.ClaimTimeout
+	if timeout <= 0 {
+		timeout = 10 * time.Second
+	}
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case s.pending <- public:
+	case <-timer.C:
+		rejectPublic(public)
+	case <-s.done:
+		closeConn(public)
+	}
+}
+
+// rejectPublic answers a public connection that no tunnel picked up
+func rejectPublic(conn net.Conn) {
+	body := "No tunnel client is connected\n"
+	response := fmt.Sprintf("HTTP/1.1 502 Bad Gateway\r\nContent-Type: text/plain\r\nContent-Length: %d\r\nConnection: close\r\n\r\n%s", len(body), body)
+	if _, err := io.WriteString(conn, response); err != nil {
+		slog.Warn("Error writing tunnel rejection", "error", err)
+	}
+	closeConn(conn)
+}
@@ -0,0 +1,112 @@
+//go:build unit
+
+package tunnel
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// startServer runs a tunnel server on random local ports
+func startServer(t *testing.T, token string) *Server {
+	t.Helper()
+	public, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	control, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	server := NewServer(token, "")
+	server.ClaimTimeout = 200 * time.Millisecond
+	go func() {
+		if err := server.Serve(public, control); err != nil {
+			t.Logf("Tunnel server error: %v", err)
+		}
+	}()
+	t.Cleanup(func() { require.NoError(t, server.Close()) })
+
+	// Wait for Serve to register its listeners
+	require.Eventually(t, func() bool {
+		server.mutex.Lock()
+		defer server.mutex.Unlock()
+		return len(server.listeners) == 2
+	}, time.Second, 5*time.Millisecond)
+	return server
+}
+
+func controlAddr(s *Server) string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.listeners[1].Addr().String()
+}
+
+func get(t *testing.T, url string) (int, string) {
+	t.Helper()
+	client := &http.Client{Timeout: 5 * time.Second}
+	resp, err := client.Get(url)
+	require.NoError(t, err)
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	return resp.StatusCode, string(body)
+}
+
+func TestTunnelRelaysHTTP(t *testing.T) {
+	server := startServer(t, "s3cret")
+
+	client, err := Dial(controlAddr(server), "s3cret", 2)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, client.Close()) }()
+	assert.Equal(t, server.PublicURL, client.PublicURL())
+
+	httpServer := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, err := io.WriteString(w, "tunneled "+r.URL.Path); err != nil {
+			t.Logf("Error writing response: %v", err)
+		}
+	})}
+	go func() {
+		if err := httpServer.Serve(client); err != nil && err != http.ErrServerClosed {
+			t.Logf("HTTP server error: %v", err)
+		}
+	}()
+	defer func() { require.NoError(t, httpServer.Close()) }()
+
+	// More sequential requests than pooled connections, so tunnels are replenished
+	for _, path := range []string{"/a", "/b", "/c", "/d"} {
+		status, body := get(t, client.PublicURL()+path)
+		assert.Equal(t, http.StatusOK, status)
+		assert.Equal(t, "tunneled "+path, body)
+	}
+}
+
+func TestTunnelRejectsBadToken(t *testing.T) {
+	server := startServer(t, "s3cret")
+
+	_, err := Dial(controlAddr(server), "wrong", 1)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "invalid token")
+}
+
+func TestTunnelWithoutClient(t *testing.T) {
+	server := startServer(t, "")
+
+	status, body := get(t, server.PublicURL+"/")
+	assert.Equal(t, http.StatusBadGateway, status)
+	assert.True(t, strings.HasPrefix(body, "No tunnel client"))
+}
+
+func TestDialUnreachableServer(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	addr := ln.Addr().String()
+	require.NoError(t, ln.Close())
+
+	_, err = Dial(addr, "", 1)
+	assert.Error(t, err)
+}
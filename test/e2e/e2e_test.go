@@ -472,7 +472,7 @@ func TestProxyWithDifferentLogLevels(t *testing.T) {
 			// Check logs for appropriate log level messages - logs go to stderr
 			switch level {
 			case "debug":
-				assert.Contains(t, stderrOutput, "Starting proxy server on port")
+				assert.Contains(t, stderrOutput, "Starting proxy server")
 				assert.Contains(t, stderrOutput, "Received request")
 			case "info":
 				assert.NotContains(t, stderrOutput, "debug")
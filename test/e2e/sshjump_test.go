@@ -0,0 +1,155 @@
+//go:build e2e
+
+package e2e
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/pem"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// jumpHost is an SSH server that forwards direct-tcpip channels for one
+// user and key, counting the channels it forwards
+type jumpHost struct {
+	addr     string
+	forwards atomic.Int32
+}
+
+// startJumpHost starts a jump host and writes a client key and a
+// known_hosts file for it, returning their paths
+func startJumpHost(t *testing.T, user string) (jump *jumpHost, keyFile, knownHostsFile string) {
+	_, clientPrivate, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	clientSigner, err := ssh.NewSignerFromKey(clientPrivate)
+	require.NoError(t, err)
+	block, err := ssh.MarshalPrivateKey(clientPrivate, "")
+	require.NoError(t, err)
+	keyFile = filepath.Join(t.TempDir(), "id_ed25519")
+	require.NoError(t, os.WriteFile(keyFile, pem.EncodeToMemory(block), 0o600))
+
+	_, hostPrivate, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	hostSigner, err := ssh.NewSignerFromKey(hostPrivate)
+	require.NoError(t, err)
+	config := &ssh.ServerConfig{
+		PublicKeyCallback: func(meta ssh.ConnMetadata, offered ssh.PublicKey) (*ssh.Permissions, error) {
+			if meta.User() == user && string(offered.Marshal()) == string(clientSigner.PublicKey().Marshal()) {
+				return nil, nil
+			}
+			return nil, assert.AnError
+		},
+	}
+	config.AddHostKey(hostSigner)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = ln.Close() })
+	jump = &jumpHost{addr: ln.Addr().String()}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go jump.serve(conn, config)
+		}
+	}()
+
+	knownHostsFile = filepath.Join(t.TempDir(), "known_hosts")
+	line := knownhosts.Line([]string{knownhosts.Normalize(jump.addr)}, hostSigner.PublicKey())
+	require.NoError(t, os.WriteFile(knownHostsFile, []byte(line+"\n"), 0o600))
+	return jump, keyFile, knownHostsFile
+}
+
+func (j *jumpHost) serve(conn net.Conn, config *ssh.ServerConfig) {
+	_, channels, requests, err := ssh.NewServerConn(conn, config)
+	if err != nil {
+		return
+	}
+	go ssh.DiscardRequests(requests)
+	for newChannel := range channels {
+		if newChannel.ChannelType() != "direct-tcpip" {
+			_ = newChannel.Reject(ssh.UnknownChannelType, "only direct-tcpip")
+			continue
+		}
+		// The target host and port lead the channel's extra data
+		data := newChannel.ExtraData()
+		hostLength := binary.BigEndian.Uint32(data)
+		host := string(data[4 : 4+hostLength])
+		port := binary.BigEndian.Uint32(data[4+hostLength:])
+		upstream, err := net.Dial("tcp", net.JoinHostPort(host, strconv.Itoa(int(port))))
+		if err != nil {
+			_ = newChannel.Reject(ssh.ConnectionFailed, err.Error())
+			continue
+		}
+		channel, channelRequests, err := newChannel.Accept()
+		if err != nil {
+			_ = upstream.Close()
+			continue
+		}
+		j.forwards.Add(1)
+		go ssh.DiscardRequests(channelRequests)
+		go func() {
+			_, _ = io.Copy(channel, upstream)
+			_ = channel.CloseWrite()
+		}()
+		go func() {
+			_, _ = io.Copy(upstream, channel)
+			_ = upstream.Close()
+		}()
+	}
+}
+
+// TestConnectThroughSSHJump tests that CONNECT tunnels reach hosts behind a
+// --ssh-jump host through it
+func TestConnectThroughSSHJump(t *testing.T) {
+	upstream := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("behind the bastion"))
+	}))
+	defer upstream.Close()
+
+	jump, keyFile, knownHosts := startJumpHost(t, "deploy")
+	proxy := startProxyServer(t,
+		"--ssh-jump=127.0.0.1=deploy@"+jump.addr,
+		"--ssh-key="+keyFile,
+		"--ssh-known-hosts="+knownHosts,
+	)
+	defer proxy.stop(t)
+
+	// HTTPS requests through a proxy are tunneled with CONNECT
+	client := upstream.Client()
+	transport := client.Transport.(*http.Transport)
+	transport.Proxy = func(_ *http.Request) (*url.URL, error) {
+		return url.Parse(proxyAddr)
+	}
+	client.Timeout = timeoutDuration
+
+	resp, err := client.Get(upstream.URL + "/")
+	require.NoError(t, err)
+	defer func() {
+		if closeErr := resp.Body.Close(); closeErr != nil {
+			t.Logf("Error closing response body: %v", closeErr)
+		}
+	}()
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "behind the bastion", string(body))
+	assert.Equal(t, int32(1), jump.forwards.Load(), "the tunnel goes through the jump host")
+}